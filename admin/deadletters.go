@@ -0,0 +1,229 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/queue"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// defaultDeadLetterLimit and maxDeadLetterLimit bound the messages listed.
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 100
+	// requeueVisibility hides the messages received to requeue them from the other receives
+	// while they are. The ones not selected show up again after it.
+	requeueVisibility = 30 * time.Second
+	// maxRequeue is the number of messages requeued at most by a request.
+	maxRequeue = 50
+)
+
+// DeadLetterQueue is a dead-letter queue and the queue its jobs are requeued to.
+type DeadLetterQueue struct {
+	DeadLetters queue.DeadLetters
+	Source      queue.Queue
+}
+
+// DeadLetterQueues are the dead-letter queues the admins can inspect, by name, e.g. "replies".
+// None are configured by default.
+var DeadLetterQueues = map[string]DeadLetterQueue{}
+
+// DeadLetterSummary is a dead-letter queue and the number of messages in it.
+type DeadLetterSummary struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// RequeueRequest is the body of the endpoint requeuing dead-lettered messages.
+type RequeueRequest struct {
+	MessageIDs []string `json:"messageIds"`
+}
+
+// RequeueResult lists the messages requeued and those no longer in the dead-letter queue,
+// or hidden by another receive.
+type RequeueResult struct {
+	Requeued []string `json:"requeued"`
+	Missing  []string `json:"missing"`
+}
+
+func deadLettersResponse(value interface{}) (events.APIGatewayProxyResponse, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshalling dead letters:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// GetDeadLetterQueuesHandler lists the dead-letter queues and how many messages each holds.
+func GetDeadLetterQueuesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	summaries := make([]DeadLetterSummary, 0, len(DeadLetterQueues))
+	for name, deadLetters := range DeadLetterQueues {
+		count, err := deadLetters.DeadLetters.Count(context.TODO())
+		if err != nil {
+			log.Printf("Error counting the messages of dead-letter queue %s: %v", name, err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		summaries = append(summaries, DeadLetterSummary{Name: name, Count: count})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return deadLettersResponse(summaries)
+}
+
+// GetDeadLettersHandler lists the messages of a dead-letter queue with their payloads. They
+// are received with no visibility timeout, so listing them doesn't hide them, but the
+// receive count of each grows.
+func GetDeadLettersHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	deadLetters, ok := DeadLetterQueues[request.PathParameters["queue"]]
+	if !ok {
+		return common.CreateErrorResponse(404, "Dead-letter queue not found")
+	}
+
+	limit := defaultDeadLetterLimit
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDeadLetterLimit {
+			return common.CreateErrorResponse(400, "Invalid limit, expected 1 to "+strconv.Itoa(maxDeadLetterLimit))
+		}
+		limit = parsed
+	}
+
+	// The same messages may come back in the next receives, the listing ends once a receive
+	// brings none not seen yet
+	messages := []queue.Message{}
+	seen := map[string]bool{}
+	for len(messages) < limit {
+		received, err := deadLetters.DeadLetters.Receive(context.TODO(), limit-len(messages), 0)
+		if err != nil {
+			log.Printf("Error receiving dead letters: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		added := 0
+		for _, message := range received {
+			if !seen[message.ID] {
+				seen[message.ID] = true
+				messages = append(messages, message)
+				added++
+			}
+		}
+		if added == 0 {
+			break
+		}
+	}
+	slices.SortFunc(messages, func(a, b queue.Message) int { return a.SentAt.Compare(b.SentAt) })
+	return deadLettersResponse(messages)
+}
+
+// RequeueDeadLettersHandler sends the selected messages of a dead-letter queue back to the
+// queue they failed in, and deletes them from the dead-letter queue. The messages are
+// looked for until a receive brings none not seen yet, those not found are reported missing.
+func RequeueDeadLettersHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	deadLetters, ok := DeadLetterQueues[request.PathParameters["queue"]]
+	if !ok {
+		return common.CreateErrorResponse(404, "Dead-letter queue not found")
+	}
+
+	var incoming RequeueRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil || len(incoming.MessageIDs) == 0 {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if len(incoming.MessageIDs) > maxRequeue {
+		return common.CreateErrorResponse(400, "At most "+strconv.Itoa(maxRequeue)+" messages can be requeued at once")
+	}
+
+	wanted := map[string]bool{}
+	for _, messageId := range incoming.MessageIDs {
+		wanted[messageId] = true
+	}
+	result := RequeueResult{Requeued: []string{}, Missing: []string{}}
+	seen := map[string]bool{}
+	for len(wanted) > 0 {
+		received, err := deadLetters.DeadLetters.Receive(ctx, queue.MaxReceive, requeueVisibility)
+		if err != nil {
+			log.Printf("Error receiving dead letters: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		added := 0
+		for _, message := range received {
+			if seen[message.ID] {
+				continue
+			}
+			seen[message.ID] = true
+			added++
+			if !wanted[message.ID] {
+				continue
+			}
+
+			// Sent before being deleted, so a failure leaves the message dead-lettered
+			// rather than lost
+			if err := deadLetters.Source.Send(ctx, []byte(message.Body), 0); err != nil {
+				log.Printf("Error requeuing dead letter %s: %v", message.ID, err)
+				return common.CreateErrorResponse(500, "Internal server error")
+			}
+			if err := deadLetters.DeadLetters.Delete(ctx, message.ReceiptHandle); err != nil {
+				log.Printf("Error deleting dead letter %s: %v", message.ID, err)
+				return common.CreateErrorResponse(500, "Internal server error")
+			}
+			delete(wanted, message.ID)
+			result.Requeued = append(result.Requeued, message.ID)
+		}
+		if added == 0 {
+			break
+		}
+	}
+	for _, messageId := range incoming.MessageIDs {
+		if wanted[messageId] {
+			result.Missing = append(result.Missing, messageId)
+		}
+	}
+
+	log.Printf("Requeued %d dead letters of %s, requested by %s", len(result.Requeued), request.PathParameters["queue"], claims.Username)
+	return deadLettersResponse(result)
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/queue"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDeadLetters is a dead-letter queue in memory. Like SQS, every receive returns the
+// visible messages from the first, up to the limit, hiding them for the visibility timeout.
+type fakeDeadLetters struct {
+	messages []queue.Message
+	hidden   map[string]bool
+}
+
+func (f *fakeDeadLetters) Receive(ctx context.Context, limit int, visibility time.Duration) ([]queue.Message, error) {
+	var received []queue.Message
+	for i := range f.messages {
+		if len(received) == min(limit, queue.MaxReceive) {
+			break
+		}
+		if f.hidden[f.messages[i].ID] {
+			continue
+		}
+		f.messages[i].ReceiveCount++
+		f.messages[i].ReceiptHandle = "handle-" + f.messages[i].ID
+		received = append(received, f.messages[i])
+		if visibility > 0 {
+			f.hidden[f.messages[i].ID] = true
+		}
+	}
+	return received, nil
+}
+
+func (f *fakeDeadLetters) Delete(ctx context.Context, receiptHandle string) error {
+	for i, message := range f.messages {
+		if message.ReceiptHandle == receiptHandle {
+			f.messages = append(f.messages[:i], f.messages[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *fakeDeadLetters) Count(ctx context.Context) (int, error) {
+	return len(f.messages), nil
+}
+
+// sentJobs is a queue in memory.
+type sentJobs struct{ bodies []string }
+
+func (s *sentJobs) Send(ctx context.Context, body []byte, delay time.Duration) error {
+	s.bodies = append(s.bodies, string(body))
+	return nil
+}
+
+func TestDeadLetterHandlers(t *testing.T) {
+	deadLetters := &fakeDeadLetters{hidden: map[string]bool{}}
+	for i, id := range []string{"m-1", "m-2", "m-3", "m-4", "m-5", "m-6", "m-7", "m-8", "m-9", "m-10", "m-11", "m-12"} {
+		deadLetters.messages = append(deadLetters.messages, queue.Message{
+			ID:     id,
+			Body:   `{"messageId":"` + id + `"}`,
+			SentAt: time.Date(2026, 10, 1, 12, i, 0, 0, time.UTC),
+		})
+	}
+	replies := &sentJobs{}
+	DeadLetterQueues = map[string]DeadLetterQueue{"replies": {DeadLetters: deadLetters, Source: replies}}
+	defer func() { DeadLetterQueues = map[string]DeadLetterQueue{} }()
+
+	call := func(handler func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error), groups string, request *testutil.RequestBuilder) events.APIGatewayProxyResponse {
+		response, err := handler(request.WithClaims("admin-1", "root").WithClaim("cognito:groups", groups).Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Only the admins see the dead letters
+	assert.Equal(t, http.StatusForbidden, call(GetDeadLetterQueuesHandler, "users", testutil.NewRequest("GET", "/admin/dead-letters")).StatusCode)
+
+	response := call(GetDeadLetterQueuesHandler, "admin", testutil.NewRequest("GET", "/admin/dead-letters"))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var summaries []DeadLetterSummary
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &summaries))
+	assert.Equal(t, []DeadLetterSummary{{Name: "replies", Count: 12}}, summaries)
+
+	// The listing shows the payloads without hiding the messages
+	response = call(GetDeadLettersHandler, "admin", testutil.NewRequest("GET", "/admin/dead-letters/replies").WithPathParam("queue", "replies"))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var messages []queue.Message
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &messages))
+	if assert.Len(t, messages, 10) {
+		assert.Equal(t, `{"messageId":"m-1"}`, messages[0].Body)
+	}
+	assert.Empty(t, deadLetters.hidden)
+
+	response = call(GetDeadLettersHandler, "admin", testutil.NewRequest("GET", "/admin/dead-letters/replies").WithPathParam("queue", "replies").WithQueryParam("limit", "500"))
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response = call(GetDeadLettersHandler, "admin", testutil.NewRequest("GET", "/admin/dead-letters/exports").WithPathParam("queue", "exports"))
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	// The selected messages go back to their queue, past the first receive, and leave the
+	// dead-letter queue
+	response = call(RequeueDeadLettersHandler, "admin", testutil.NewRequest("POST", "/admin/dead-letters/replies/requeue").
+		WithPathParam("queue", "replies").
+		WithJSONBody(t, RequeueRequest{MessageIDs: []string{"m-2", "m-12", "m-99"}}))
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var result RequeueResult
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+	assert.Equal(t, RequeueResult{Requeued: []string{"m-2", "m-12"}, Missing: []string{"m-99"}}, result)
+	assert.Equal(t, []string{`{"messageId":"m-2"}`, `{"messageId":"m-12"}`}, replies.bodies)
+	count, _ := deadLetters.Count(context.TODO())
+	assert.Equal(t, 10, count)
+}
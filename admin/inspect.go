@@ -0,0 +1,279 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/encryption"
+	"vassistant-backend/plans"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// redactedValue replaces the content of the redacted attributes.
+const redactedValue = "[REDACTED]"
+
+// Handlers serves the admin routes reading and writing the tables, with the DynamoDB client
+// it was created with. The client decrypts the items like the handlers do, the content
+// being redacted by the inspections unless the glass is broken.
+type Handlers struct {
+	client common.DynamoDBAPI
+	plans  *plans.Handlers
+}
+
+// NewHandlers creates the admin handlers reading and writing through the client, the plans
+// of the users included.
+func NewHandlers(client common.DynamoDBAPI) *Handlers {
+	return &Handlers{client: client, plans: plans.NewHandlers(client)}
+}
+
+// InspectedItem is an item of a table as stored, with the content of the users redacted.
+type InspectedItem struct {
+	Table    string         `json:"table"`
+	Item     map[string]any `json:"item"`
+	Redacted []string       `json:"redacted,omitempty"`
+}
+
+// Inspection is the response of the inspection endpoints.
+type Inspection struct {
+	Resource   string          `json:"resource"`
+	BreakGlass bool            `json:"breakGlass"`
+	Items      []InspectedItem `json:"items"`
+}
+
+// InspectionAudit struct for the admin-audit table, recording every inspection breaking the
+// glass before its content is read.
+type InspectionAudit struct {
+	AuditID   string `json:"auditId" dynamodbav:"auditId"`
+	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+	AdminID   string `json:"adminId" dynamodbav:"adminId"`
+	Admin     string `json:"admin" dynamodbav:"admin"`
+	Resource  string `json:"resource" dynamodbav:"resource"`
+	Reason    string `json:"reason" dynamodbav:"reason"`
+}
+
+// tableItem is an item read for an inspection and the table it comes from.
+type tableItem struct {
+	table string
+	item  map[string]types.AttributeValue
+}
+
+// inspectItem converts a stored item for the response, redacting the attributes the table
+// encrypts, e.g. the content of the messages and the notes of the expenses.
+func inspectItem(stored tableItem, breakGlass bool) (InspectedItem, error) {
+	item := map[string]any{}
+	err := attributevalue.UnmarshalMapWithOptions(stored.item, &item, func(options *attributevalue.DecoderOptions) {
+		options.UseNumber = true
+	})
+	if err != nil {
+		return InspectedItem{}, err
+	}
+
+	inspected := InspectedItem{Table: stored.table, Item: item}
+	if breakGlass {
+		return inspected, nil
+	}
+	for _, attribute := range encryption.DefaultTables[stored.table].Attributes {
+		if _, ok := item[attribute]; ok {
+			item[attribute] = redactedValue
+			inspected.Redacted = append(inspected.Redacted, attribute)
+		}
+	}
+	slices.Sort(inspected.Redacted)
+	return inspected, nil
+}
+
+// inspect answers an inspection endpoint with the items loaded for the resource, or 404 when
+// there are none. With the breakGlass query parameter set to true the items aren't
+// redacted, which needs a reason and is audited first.
+func (h *Handlers) inspect(request events.APIGatewayProxyRequest, resource string, load func(ctx context.Context) ([]tableItem, error)) (events.APIGatewayProxyResponse, error) {
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	breakGlass := request.QueryStringParameters["breakGlass"] == "true"
+	if breakGlass {
+		reason := request.QueryStringParameters["reason"]
+		if reason == "" {
+			return common.CreateErrorResponse(400, "A reason is required to break the glass")
+		}
+		audit := InspectionAudit{
+			AuditID:   uuid.New().String(),
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			AdminID:   claims.Sub,
+			Admin:     claims.Username,
+			Resource:  resource,
+			Reason:    reason,
+		}
+		err = common.ConditionalPutItem(context.TODO(), h.client, "admin-audit", audit, common.IfNotExists("auditId"))
+		if err != nil {
+			log.Printf("Error putting inspection audit into DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		log.Printf("Break-glass inspection of %s by %s: %q", resource, claims.Username, reason)
+	}
+
+	stored, err := load(context.TODO())
+	if err != nil {
+		log.Printf("Error loading %s from DynamoDB: %v", resource, err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if len(stored) == 0 {
+		return common.CreateErrorResponse(404, "Resource not found")
+	}
+
+	inspection := Inspection{Resource: resource, BreakGlass: breakGlass, Items: []InspectedItem{}}
+	for _, item := range stored {
+		inspected, err := inspectItem(item, breakGlass)
+		if err != nil {
+			log.Printf("Error unmarshalling %s item of %s: %v", item.table, resource, err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		inspection.Items = append(inspection.Items, inspected)
+	}
+
+	// Marshal the inspection into JSON for the payload
+	payload, err := json.Marshal(inspection)
+	if err != nil {
+		log.Println("Error marshalling inspection:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// getStoredItem returns the item of the table with the key, or nil if there is none.
+func (h *Handlers) getStoredItem(ctx context.Context, table string, key map[string]types.AttributeValue) ([]tableItem, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+	return []tableItem{{table, result.Item}}, nil
+}
+
+// queryStoredItems returns the items of the query, following the pages of the result, that
+// the keep function keeps.
+func (h *Handlers) queryStoredItems(ctx context.Context, queryInput *dynamodb.QueryInput, keep func(item map[string]types.AttributeValue) bool) ([]tableItem, error) {
+	var items []tableItem
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			if keep(item) {
+				items = append(items, tableItem{aws.ToString(queryInput.TableName), item})
+			}
+		}
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return items, nil
+		}
+	}
+}
+
+// hasString reports whether the string attribute of the item has the value.
+func hasString(item map[string]types.AttributeValue, attribute, value string) bool {
+	stored, ok := item[attribute].(*types.AttributeValueMemberS)
+	return ok && stored.Value == value
+}
+
+// InspectGroupHandler returns the settings and the memberships of any group.
+func (h *Handlers) InspectGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Missing groupId in path")
+	}
+
+	return h.inspect(request, "groups/"+groupId, func(ctx context.Context) ([]tableItem, error) {
+		settings, err := h.getStoredItem(ctx, "splitter-group-settings", map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		})
+		if err != nil {
+			return nil, err
+		}
+		members, err := h.queryStoredItems(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String("splitter-group-members"),
+			IndexName:              aws.String("groupId-index"),
+			KeyConditionExpression: aws.String("groupId = :groupId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":groupId": &types.AttributeValueMemberS{Value: groupId},
+			},
+		}, func(map[string]types.AttributeValue) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		return append(settings, members...), nil
+	})
+}
+
+// InspectExpenseHandler returns any expense of any group.
+func (h *Handlers) InspectExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Missing groupId in path")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Missing expenseId in path")
+	}
+
+	return h.inspect(request, "groups/"+groupId+"/expenses/"+expenseId, func(ctx context.Context) ([]tableItem, error) {
+		return h.getStoredItem(ctx, "splitter-expenses", map[string]types.AttributeValue{
+			"groupId":   &types.AttributeValueMemberS{Value: groupId},
+			"expenseId": &types.AttributeValueMemberS{Value: expenseId},
+		})
+	})
+}
+
+// InspectMessageHandler returns any message of any user, found by its ID among their
+// messages as the chat table is keyed by creation time.
+func (h *Handlers) InspectMessageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	userId, ok := request.PathParameters["userId"]
+	if !ok || userId == "" {
+		return common.CreateErrorResponse(400, "Missing userId in path")
+	}
+	messageId, ok := request.PathParameters["messageId"]
+	if !ok || messageId == "" {
+		return common.CreateErrorResponse(400, "Missing messageId in path")
+	}
+
+	return h.inspect(request, "users/"+userId+"/messages/"+messageId, func(ctx context.Context) ([]tableItem, error) {
+		return h.queryStoredItems(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String("chat"),
+			KeyConditionExpression: aws.String("userId = :userId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":userId": &types.AttributeValueMemberS{Value: userId},
+			},
+		}, func(item map[string]types.AttributeValue) bool { return hasString(item, "id", messageId) })
+	})
+}
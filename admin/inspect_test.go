@@ -0,0 +1,117 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectMessage(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"chat": {
+			{"userId": "user-1", "createdAt": "2024-04-01T10:00:00Z", "id": "message-1", "role": "user", "content": "My card PIN is 1234"},
+			{"userId": "user-1", "createdAt": "2024-04-01T10:00:05Z", "id": "message-2", "role": "assistant", "content": "Please don't share it"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	inspect := func(groups string, query map[string]string) (int, Inspection) {
+		builder := testutil.NewRequest("GET", "/admin/inspect/users/user-1/messages/message-1").
+			WithClaims("admin-1", "root").
+			WithClaim("cognito:groups", groups).
+			WithPathParam("userId", "user-1").
+			WithPathParam("messageId", "message-1")
+		for name, value := range query {
+			builder = builder.WithQueryParam(name, value)
+		}
+		response, err := h.InspectMessageHandler(builder.Build())
+		assert.NoError(t, err)
+		var inspection Inspection
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &inspection))
+		}
+		return response.StatusCode, inspection
+	}
+
+	// Only the admins can inspect
+	status, _ := inspect("users", nil)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// The content of the message is redacted
+	status, inspection := inspect("admin", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.False(t, inspection.BreakGlass)
+	assert.Len(t, inspection.Items, 1)
+	assert.Equal(t, "chat", inspection.Items[0].Table)
+	assert.Equal(t, "message-1", inspection.Items[0].Item["id"])
+	assert.Equal(t, redactedValue, inspection.Items[0].Item["content"])
+	assert.Equal(t, []string{"content"}, inspection.Items[0].Redacted)
+
+	// Breaking the glass needs a reason and is audited
+	status, _ = inspect("admin", map[string]string{"breakGlass": "true"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, inspection = inspect("admin", map[string]string{"breakGlass": "true", "reason": "Ticket 42, garbled reply"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, inspection.BreakGlass)
+	assert.Equal(t, "My card PIN is 1234", inspection.Items[0].Item["content"])
+	assert.Empty(t, inspection.Items[0].Redacted)
+
+	audits, err := fake.Scan(context.TODO(), &dynamodb.ScanInput{TableName: aws.String("admin-audit")})
+	assert.NoError(t, err)
+	assert.Len(t, audits.Items, 1)
+}
+
+func TestInspectGroup(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-settings": {{"groupId": "house", "defaultCurrency": "EUR"}},
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "trip", "groupName": "Trip"},
+		},
+		"splitter-expenses": {{"groupId": "house", "expenseId": "expense-1", "title": "Rent", "amount": "1000", "notes": "Paid late"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	request := testutil.NewRequest("GET", "/admin/inspect/groups/house").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", "admin").
+		WithPathParam("groupId", "house").
+		Build()
+	response, err := h.InspectGroupHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var inspection Inspection
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &inspection))
+	var tables []string
+	for _, item := range inspection.Items {
+		tables = append(tables, item.Table)
+	}
+	assert.Equal(t, []string{"splitter-group-settings", "splitter-group-members", "splitter-group-members"}, tables)
+
+	// The notes of the expenses are redacted too
+	request = testutil.NewRequest("GET", "/admin/inspect/groups/house/expenses/expense-1").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", "admin").
+		WithPathParam("groupId", "house").
+		WithPathParam("expenseId", "expense-1").
+		Build()
+	response, err = h.InspectExpenseHandler(request)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &inspection))
+	assert.Equal(t, "Rent", inspection.Items[0].Item["title"])
+	assert.Equal(t, redactedValue, inspection.Items[0].Item["notes"])
+
+	request.PathParameters["expenseId"] = "expense-2"
+	response, err = h.InspectExpenseHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxCostDays bounds the range of the cost aggregates, a year of monthly costs.
+const maxCostDays = 366
+
+// defaultCostDays is the range of the cost aggregates when none is given.
+const defaultCostDays = 30
+
+// LLMCosts records and aggregates the language model requests. Cost reports are disabled when nil.
+var LLMCosts *metrics.LLMCostRecorder
+
+// LLMCostsResponse is the response of the language model costs endpoint, with the total
+// cost of the range in US dollars.
+type LLMCostsResponse struct {
+	From        string               `json:"from"`
+	To          string               `json:"to"`
+	Granularity string               `json:"granularity"`
+	GroupBy     string               `json:"groupBy"`
+	Total       json.Number          `json:"total"`
+	Buckets     []metrics.CostBucket `json:"buckets"`
+}
+
+// parseCostQuery parses the query string of the costs endpoint. The range is given in days,
+// both inclusive, and defaults to the last thirty days.
+func parseCostQuery(parameters map[string]string, now time.Time) (metrics.CostQuery, string) {
+	query := metrics.CostQuery{
+		Granularity: metrics.ByDay,
+		Dimension:   metrics.ByModel,
+	}
+	if granularity, ok := parameters["granularity"]; ok {
+		if granularity != metrics.ByDay && granularity != metrics.ByMonth {
+			return query, "Granularity must be day or month"
+		}
+		query.Granularity = granularity
+	}
+	if dimension, ok := parameters["groupBy"]; ok {
+		if dimension != metrics.ByModel && dimension != metrics.ByUser {
+			return query, "Group by must be model or user"
+		}
+		query.Dimension = dimension
+	}
+
+	to := now.UTC().Truncate(24 * time.Hour)
+	if value, ok := parameters["to"]; ok {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return query, "Invalid to date"
+		}
+		to = date
+	}
+	from := to.AddDate(0, 0, 1-defaultCostDays)
+	if value, ok := parameters["from"]; ok {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return query, "Invalid from date"
+		}
+		from = date
+	}
+	if from.After(to) {
+		return query, "From must not be after to"
+	}
+	if to.Sub(from) >= maxCostDays*24*time.Hour {
+		return query, "The range can't be longer than 366 days"
+	}
+
+	query.From = from
+	query.To = to.AddDate(0, 0, 1)
+	return query, ""
+}
+
+func GetLLMCostsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	if LLMCosts == nil {
+		return common.CreateErrorResponse(503, "Cost reports are not available")
+	}
+
+	query, message := parseCostQuery(request.QueryStringParameters, time.Now())
+	if message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	buckets, err := LLMCosts.Aggregate(context.TODO(), query)
+	if err != nil {
+		log.Printf("Error aggregating language model costs: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	total := new(big.Rat)
+	for _, bucket := range buckets {
+		cost, _ := new(big.Rat).SetString(string(bucket.Cost))
+		total.Add(total, cost)
+	}
+	response := LLMCostsResponse{
+		From:        query.From.Format(time.DateOnly),
+		To:          query.To.AddDate(0, 0, -1).Format(time.DateOnly),
+		Granularity: query.Granularity,
+		GroupBy:     query.Dimension,
+		Total:       json.Number(total.FloatString(6)),
+		Buckets:     buckets,
+	}
+
+	// Marshal the costs into JSON for the payload
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshalling language model costs:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/llm"
+	"vassistant-backend/metrics"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCostQuery(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+
+	// The last thirty days by default
+	query, message := parseCostQuery(map[string]string{}, now)
+	assert.Empty(t, message)
+	assert.Equal(t, time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC), query.From)
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), query.To)
+	assert.Equal(t, metrics.ByModel, query.Dimension)
+
+	// A year of months
+	query, message = parseCostQuery(map[string]string{"from": "2023-03-11", "to": "2024-03-10", "granularity": "month", "groupBy": "user"}, now)
+	assert.Empty(t, message)
+	assert.Equal(t, metrics.ByMonth, query.Granularity)
+	assert.Equal(t, metrics.ByUser, query.Dimension)
+
+	for _, parameters := range []map[string]string{
+		{"granularity": "hour"},
+		{"groupBy": "route"},
+		{"to": "tomorrow"},
+		{"from": "2024-03-05", "to": "2024-03-01"},
+		{"from": "2022-01-01", "to": "2024-01-01"},
+	} {
+		_, message = parseCostQuery(parameters, now)
+		assert.NotEmpty(t, message, parameters)
+	}
+}
+
+func TestGetLLMCostsHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	LLMCosts = metrics.NewLLMCostRecorder(fake, "llm-costs")
+	defer func() { LLMCosts = nil }()
+
+	provider := LLMCosts.Provider(llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		return llm.Response{Model: "amazon.nova-lite-v1:0", InputTokens: 1000000, OutputTokens: 1000000}, nil
+	}))
+	_, err = provider.Complete(context.TODO(), llm.Request{User: "user-1"})
+	assert.NoError(t, err)
+
+	// Only admins see the costs
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/admin/llm-costs").WithClaims("user-1", "alice").Build()
+	response, err := GetLLMCostsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	request = testutil.NewRequest("GET", "/VassistantBackendProxy/admin/llm-costs").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", AdminGroup).
+		Build()
+	response, err = GetLLMCostsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var costs LLMCostsResponse
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &costs))
+	assert.Equal(t, "0.300000", string(costs.Total))
+	assert.Len(t, costs.Buckets, 1)
+	assert.Equal(t, "amazon.nova-lite-v1:0", costs.Buckets[0].Key)
+}
@@ -0,0 +1,210 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MaintenanceCode is the error code of the mutations turned away during maintenance.
+const MaintenanceCode = "MAINTENANCE"
+
+// maintenanceName is the key of the maintenance mode item, the only item of its table.
+const maintenanceName = "api"
+
+// maintenanceTTL is how long an instance trusts the maintenance mode it last read. The
+// other instances see a toggle within this delay.
+const maintenanceTTL = 10 * time.Second
+
+// maintenanceRetryAfter is the Retry-After, in seconds, of the mutations turned away.
+const maintenanceRetryAfter = "60"
+
+// Maintenance switches the API to read-only during migrations. Maintenance mode is disabled
+// when nil.
+var Maintenance *MaintenanceSwitch
+
+// MaintenanceMode is the state of the maintenance mode. While ReadOnly, the routes not
+// annotated as read-only are rejected with 503.
+type MaintenanceMode struct {
+	Name      string `json:"-" dynamodbav:"name"`
+	ReadOnly  bool   `json:"readOnly" dynamodbav:"readOnly"`
+	Reason    string `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+	UpdatedBy string `json:"updatedBy,omitempty" dynamodbav:"updatedBy,omitempty"`
+}
+
+// MaintenanceSwitch keeps the maintenance mode in a table keyed by name, shared by all the
+// instances, and caches it for maintenanceTTL.
+type MaintenanceSwitch struct {
+	Client    common.DynamoDBAPI
+	TableName string
+
+	mu        sync.Mutex
+	mode      MaintenanceMode
+	checkedAt time.Time
+}
+
+// NewMaintenanceSwitch creates a MaintenanceSwitch on the table.
+func NewMaintenanceSwitch(client common.DynamoDBAPI, tableName string) *MaintenanceSwitch {
+	return &MaintenanceSwitch{Client: client, TableName: tableName}
+}
+
+// Mode returns the maintenance mode, read from the table at most every maintenanceTTL. When
+// the table can't be read the last known mode is kept, so an outage of the table doesn't turn
+// the API read-only.
+func (s *MaintenanceSwitch) Mode(ctx context.Context) MaintenanceMode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.checkedAt.IsZero() && time.Since(s.checkedAt) < maintenanceTTL {
+		return s.mode
+	}
+
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key: map[string]types.AttributeValue{
+			"name": &types.AttributeValueMemberS{Value: maintenanceName},
+		},
+	})
+	if err != nil {
+		log.Printf("Error getting maintenance mode from DynamoDB: %v", err)
+		return s.mode
+	}
+	mode := MaintenanceMode{}
+	if result.Item != nil {
+		if err := attributevalue.UnmarshalMap(result.Item, &mode); err != nil {
+			log.Printf("Error unmarshalling maintenance mode: %v", err)
+			return s.mode
+		}
+	}
+	s.mode, s.checkedAt = mode, time.Now()
+	return mode
+}
+
+// Set stores the maintenance mode. The instance setting it sees it at once, the others once
+// their cached mode expires.
+func (s *MaintenanceSwitch) Set(ctx context.Context, mode MaintenanceMode) error {
+	mode.Name = maintenanceName
+	av, err := attributevalue.MarshalMap(mode)
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item:      av,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.mode, s.checkedAt = mode, time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Middleware rejects the mutating routes with 503 while the API is read-only. The read-only
+// routes, and those allowed in maintenance, are served as usual.
+func (s *MaintenanceSwitch) Middleware(route api.Route, next api.HandlerFunc) api.HandlerFunc {
+	if route.ReadOnly || route.AllowedInMaintenance {
+		return next
+	}
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if !s.Mode(context.TODO()).ReadOnly {
+			return next(request)
+		}
+		response, err := common.CreateCodedErrorResponse(http.StatusServiceUnavailable, MaintenanceCode, "The service is read-only during maintenance, please try again later")
+		response.Headers["Retry-After"] = maintenanceRetryAfter
+		return response, err
+	}
+}
+
+// MaintenanceRequest is the body of the maintenance toggle endpoint.
+type MaintenanceRequest struct {
+	ReadOnly *bool  `json:"readOnly"`
+	Reason   string `json:"reason"`
+}
+
+func GetMaintenanceHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	if Maintenance == nil {
+		return common.CreateErrorResponse(503, "Maintenance mode is not available")
+	}
+
+	return maintenanceResponse(Maintenance.Mode(context.TODO()))
+}
+
+func PutMaintenanceHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	if Maintenance == nil {
+		return common.CreateErrorResponse(503, "Maintenance mode is not available")
+	}
+
+	var incoming MaintenanceRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if incoming.ReadOnly == nil {
+		return common.CreateErrorResponse(400, "readOnly is required")
+	}
+
+	mode := MaintenanceMode{
+		ReadOnly:  *incoming.ReadOnly,
+		Reason:    incoming.Reason,
+		UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+		UpdatedBy: claims.Username,
+	}
+	if err := Maintenance.Set(context.TODO(), mode); err != nil {
+		log.Printf("Error putting maintenance mode into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	log.Printf("Maintenance mode set by %s: readOnly=%t reason=%q", claims.Username, mode.ReadOnly, mode.Reason)
+
+	return maintenanceResponse(mode)
+}
+
+func maintenanceResponse(mode MaintenanceMode) (events.APIGatewayProxyResponse, error) {
+	// Marshal the maintenance mode into JSON for the payload
+	payload, err := json.Marshal(mode)
+	if err != nil {
+		log.Println("Error marshalling maintenance mode:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/api"
+	"vassistant-backend/common"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	Maintenance = NewMaintenanceSwitch(fake, "maintenance-mode")
+	defer func() { Maintenance = nil }()
+
+	ok := func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}
+	router := api.NewRouter()
+	router.AddRoute("GET", "/items", ok)
+	router.AddRoute("POST", "/items", ok)
+	router.AddRoute("POST", "/items/search", ok, api.ReadOnly)
+	router.AddRoute("GET", "/items/seen", ok, api.Mutating)
+	router.AddRoute("PUT", "/admin/maintenance", PutMaintenanceHandler, api.AllowedInMaintenance)
+	router.Use(Maintenance.Middleware)
+
+	// GET, HEAD and OPTIONS routes are read-only unless annotated otherwise
+	var readOnly []bool
+	for _, route := range router.Routes() {
+		readOnly = append(readOnly, route.ReadOnly)
+	}
+	assert.Equal(t, []bool{true, false, true, false, false}, readOnly)
+
+	serve := func(method, path string) events.APIGatewayProxyResponse {
+		response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: method, Path: path})
+		assert.NoError(t, err)
+		return response
+	}
+	toggle := func(groups string, readOnly bool) events.APIGatewayProxyResponse {
+		request := testutil.NewRequest("PUT", "/admin/maintenance").
+			WithClaims("admin-1", "root").
+			WithClaim("cognito:groups", groups).
+			WithJSONBody(t, map[string]interface{}{"readOnly": readOnly, "reason": "Migrating the expenses"}).
+			Build()
+		response, err := router.Serve(request)
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Everything is served out of maintenance
+	assert.Equal(t, http.StatusOK, serve("POST", "/items").StatusCode)
+
+	// Only the admins can toggle the maintenance mode
+	assert.Equal(t, http.StatusForbidden, toggle("users", true).StatusCode)
+	response := toggle(AdminGroup, true)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var mode MaintenanceMode
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &mode))
+	assert.True(t, mode.ReadOnly)
+	assert.Equal(t, "root", mode.UpdatedBy)
+
+	// The mutations are turned away during maintenance, the reads aren't
+	response = serve("POST", "/items")
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	assert.Equal(t, "60", response.Headers["Retry-After"])
+	var body common.ErrorResponse
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &body))
+	assert.Equal(t, MaintenanceCode, body.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve("GET", "/items/seen").StatusCode)
+	assert.Equal(t, http.StatusOK, serve("GET", "/items").StatusCode)
+	assert.Equal(t, http.StatusOK, serve("POST", "/items/search").StatusCode)
+
+	// The other instances read the mode from the table
+	assert.True(t, NewMaintenanceSwitch(fake, "maintenance-mode").Mode(t.Context()).ReadOnly)
+
+	// The toggle itself is allowed in maintenance
+	assert.Equal(t, http.StatusOK, toggle(AdminGroup, false).StatusCode)
+	assert.Equal(t, http.StatusOK, serve("POST", "/items").StatusCode)
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/plans"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// PlanRequest is the body of the endpoint changing the plan of a user.
+type PlanRequest struct {
+	Plan string `json:"plan"`
+}
+
+// PutUserPlanHandler moves a user to another plan, e.g. after a payment or as a courtesy.
+// The entitlements of the new plan apply to the next requests of the user.
+func (h *Handlers) PutUserPlanHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	// Extract userId from path parameters
+	userId, ok := request.PathParameters["userId"]
+	if !ok || userId == "" {
+		return common.CreateErrorResponse(400, "User ID is missing")
+	}
+
+	var incoming PlanRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	plan, err := h.plans.Set(context.TODO(), userId, incoming.Plan, claims.Username, time.Now())
+	if errors.Is(err, plans.ErrUnknownPlan) {
+		return common.CreateErrorResponse(400, "Invalid plan, expected FREE or PREMIUM")
+	}
+	if errors.Is(err, plans.ErrUserNotFound) {
+		return common.CreateErrorResponse(404, "User not found")
+	}
+	if err != nil {
+		log.Printf("Error setting plan in DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	log.Printf("Plan of user %s set to %s by %s", userId, plan.Name, claims.Username)
+
+	// Marshal the plan into JSON for the payload
+	payload, err := json.Marshal(plan)
+	if err != nil {
+		log.Println("Error marshalling plan:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/plans"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutUserPlanHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"vassistant-users": {{"userId": "user-1", "username": "alice"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	put := func(groups, userId, plan string) events.APIGatewayProxyResponse {
+		response, err := h.PutUserPlanHandler(testutil.NewRequest("PUT", "/admin/users/"+userId+"/plan").
+			WithClaims("admin-1", "root").
+			WithClaim("cognito:groups", groups).
+			WithPathParam("userId", userId).
+			WithJSONBody(t, PlanRequest{Plan: plan}).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Only the admins change the plans
+	assert.Equal(t, http.StatusForbidden, put("users", "user-1", plans.Premium).StatusCode)
+
+	response := put("admin", "user-1", plans.Premium)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var plan plans.Plan
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &plan))
+	assert.Equal(t, plans.Premium, plan.Name)
+
+	assert.Equal(t, http.StatusBadRequest, put("admin", "user-1", "GOLD").StatusCode)
+	assert.Equal(t, http.StatusNotFound, put("admin", "user-2", plans.Premium).StatusCode)
+}
@@ -0,0 +1,224 @@
+package admin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"vassistant-backend/analytics"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxReplayBatch is the maximum number of expenses read by a single call of the replay, the
+// next ones being replayed by the calls with the returned cursor.
+const maxReplayBatch = 500
+
+// errInvalidReplayCursor is returned for a cursor the replay didn't return.
+var errInvalidReplayCursor = errors.New("Invalid cursor")
+
+// Replay summarizes a call of the analytics replay. Deleted is the number of shares of the
+// scope deleted by the first call, before the expenses are indexed again.
+type Replay struct {
+	GroupID    string `json:"groupId,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	Deleted    int    `json:"deleted"`
+	Expenses   int    `json:"expenses"`
+	Shares     int    `json:"shares"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// encodeReplayCursor turns the last key read into the cursor of the next call, "" when the
+// table was read to its end.
+func encodeReplayCursor(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	var values map[string]string
+	err := attributevalue.UnmarshalMap(key, &values)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// decodeReplayCursor reads the key the previous call stopped at, nil without a cursor.
+func decodeReplayCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidReplayCursor
+	}
+	var values map[string]string
+	if err := json.Unmarshal(payload, &values); err != nil || len(values) == 0 {
+		return nil, errInvalidReplayCursor
+	}
+	return attributevalue.MarshalMap(values)
+}
+
+// parseReplayDate parses an optional RFC 3339 bound of the replayed range.
+func parseReplayDate(value string) (string, bool) {
+	if value == "" {
+		return "", true
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", false
+	}
+	return parsed.UTC().Format(time.RFC3339), true
+}
+
+// readReplayBatch reads the next batch of the expenses of the group, or of every group when
+// groupId is empty, after the start key. The range is on the dateTime of the expenses, like
+// the analytics queries.
+func (h *Handlers) readReplayBatch(ctx context.Context, groupId, from, to string, startKey map[string]types.AttributeValue) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	var read []map[string]types.AttributeValue
+	var lastKey map[string]types.AttributeValue
+	if groupId != "" {
+		keyCondition := "groupId = :groupId"
+		values := map[string]types.AttributeValue{":groupId": &types.AttributeValueMemberS{Value: groupId}}
+		if from != "" {
+			keyCondition += " AND dateTime >= :from"
+			values[":from"] = &types.AttributeValueMemberS{Value: from}
+		}
+		result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String("splitter-expenses"),
+			IndexName:                 aws.String("groupId-dateTime-index"),
+			KeyConditionExpression:    aws.String(keyCondition),
+			ExpressionAttributeValues: values,
+			ExclusiveStartKey:         startKey,
+			Limit:                     aws.Int32(maxReplayBatch),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		read, lastKey = result.Items, result.LastEvaluatedKey
+	} else {
+		result, err := h.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String("splitter-expenses"),
+			ExclusiveStartKey: startKey,
+			Limit:             aws.Int32(maxReplayBatch),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		read, lastKey = result.Items, result.LastEvaluatedKey
+	}
+
+	// Keep the expenses of the range, the scans reading every expense
+	batch := make([]map[string]types.AttributeValue, 0, len(read))
+	for _, item := range read {
+		dateTime, _ := item["dateTime"].(*types.AttributeValueMemberS)
+		if dateTime == nil {
+			continue
+		}
+		if (from != "" && dateTime.Value < from) || (to != "" && dateTime.Value >= to) {
+			continue
+		}
+		batch = append(batch, item)
+	}
+	return batch, lastKey, nil
+}
+
+// ReplayAnalyticsHandler rebuilds the analytics index of a group, or of every group, from
+// the expenses table after its stream lost records, e.g. during an outage of OpenSearch.
+// The first call deletes the shares of the groupId, from and to query parameters and every
+// call indexes the next batch of expenses again, until no cursor is returned. The expenses
+// deleted meanwhile are thus dropped from the index too.
+func (h *Handlers) ReplayAnalyticsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	// Parse the scope of the replay
+	replay := Replay{GroupID: request.QueryStringParameters["groupId"]}
+	var ok bool
+	replay.From, ok = parseReplayDate(request.QueryStringParameters["from"])
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid from date")
+	}
+	replay.To, ok = parseReplayDate(request.QueryStringParameters["to"])
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid to date")
+	}
+	if replay.From != "" && replay.To != "" && replay.From >= replay.To {
+		return common.CreateErrorResponse(400, "The from date must be before the to date")
+	}
+	cursor := request.QueryStringParameters["cursor"]
+	startKey, err := decodeReplayCursor(cursor)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	if analytics.DefaultClient == nil {
+		return common.CreateErrorResponse(503, "Analytics are not available")
+	}
+
+	// Clear the scope once, so the expenses deleted since the outage don't stay indexed
+	if cursor == "" {
+		replay.Deleted, err = analytics.DefaultClient.DeleteShares(ctx, replay.GroupID, replay.From, replay.To)
+		if err != nil {
+			log.Printf("Error deleting shares from OpenSearch: %v", err)
+			return common.CreateErrorResponse(502, "Analytics could not be replayed")
+		}
+	}
+
+	batch, lastKey, err := h.readReplayBatch(ctx, replay.GroupID, replay.From, replay.To, startKey)
+	if err != nil {
+		log.Printf("Error reading expenses from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	replay.Expenses = len(batch)
+	replay.Shares, err = analytics.DefaultClient.IndexExpenses(ctx, batch)
+	if err != nil {
+		log.Printf("Error indexing expenses into OpenSearch: %v", err)
+		return common.CreateErrorResponse(502, "Analytics could not be replayed")
+	}
+	replay.NextCursor, err = encodeReplayCursor(lastKey)
+	if err != nil {
+		log.Printf("Error encoding replay cursor: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Admin %s replayed %d expenses into %d shares of group %q from %q to %q", claims.Username, replay.Expenses, replay.Shares, replay.GroupID, replay.From, replay.To)
+
+	// Marshal the replay into JSON for the payload
+	payload, err := json.Marshal(replay)
+	if err != nil {
+		log.Println("Error marshalling replay:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	if replay.NextCursor != "" {
+		headers[common.NextCursorHeader] = replay.NextCursor
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    headers,
+		Body:       string(payload),
+	}, nil
+}
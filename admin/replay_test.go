@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vassistant-backend/analytics"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayAnalytics(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-expenses": {
+			{"groupId": "group-1", "expenseId": "expense-1", "dateTime": "2024-03-01T10:00:00Z", "currency": "EUR", "amount": "30", "paidBy": "user-1",
+				"participants": []map[string]interface{}{{"userId": "user-1", "calculatedMoney": "10.00"}, {"userId": "user-2", "calculatedMoney": "20.00"}}},
+			{"groupId": "group-1", "expenseId": "expense-2", "dateTime": "2024-01-15T10:00:00Z", "currency": "EUR", "amount": "8", "paidBy": "user-2"},
+			{"groupId": "group-2", "expenseId": "expense-3", "dateTime": "2024-03-02T10:00:00Z", "currency": "USD", "amount": "5", "paidBy": "user-3"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Set up an OpenSearch domain recording the deletions and the indexed shares
+	var deletions []string
+	var indexed []analytics.Share
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_bulk":
+			scanner := bufio.NewScanner(r.Body)
+			for line := 0; scanner.Scan(); line++ {
+				if line%2 == 1 {
+					var share analytics.Share
+					assert.NoError(t, json.Unmarshal(scanner.Bytes(), &share))
+					indexed = append(indexed, share)
+				}
+			}
+			io.WriteString(w, `{"errors":false}`)
+		case "/" + analytics.SharesIndex + "/_delete_by_query":
+			body, _ := io.ReadAll(r.Body)
+			deletions = append(deletions, string(body))
+			io.WriteString(w, `{"deleted":7}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	analytics.DefaultClient = &analytics.Client{Endpoint: server.URL, HTTPClient: server.Client()}
+	defer func() { analytics.DefaultClient = nil }()
+
+	replay := func(groups string, query map[string]string) (int, Replay) {
+		builder := testutil.NewRequest("POST", "/admin/replay/analytics").
+			WithClaims("admin-1", "root").
+			WithClaim("cognito:groups", groups)
+		for name, value := range query {
+			builder = builder.WithQueryParam(name, value)
+		}
+		response, err := h.ReplayAnalyticsHandler(builder.Build())
+		assert.NoError(t, err)
+		var result Replay
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+		}
+		return response.StatusCode, result
+	}
+
+	// Only the admins can replay
+	status, _ := replay("users", nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = replay("admin", map[string]string{"from": "March"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = replay("admin", map[string]string{"cursor": "!"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// The scope is cleared, then the expenses of the range are indexed again
+	status, result := replay("admin", map[string]string{"groupId": "group-1", "from": "2024-02-01T00:00:00Z"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, Replay{GroupID: "group-1", From: "2024-02-01T00:00:00Z", Deleted: 7, Expenses: 1, Shares: 2}, result)
+	assert.Len(t, deletions, 1)
+	assert.Contains(t, deletions[0], `"groupId":"group-1"`)
+	assert.Contains(t, deletions[0], `"gte":"2024-02-01T00:00:00Z"`)
+	assert.Len(t, indexed, 2)
+	assert.Equal(t, "expense-1", indexed[0].ExpenseID)
+
+	// Without a group every group is replayed
+	indexed = nil
+	status, result = replay("admin", map[string]string{"to": "2024-03-02T00:00:00Z"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2, result.Expenses)
+	assert.Len(t, indexed, 3)
+}
@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/residency"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// residencyDataTables are the tables checked for data of a group before changing its region,
+// as the data isn't moved along.
+var residencyDataTables = []string{"splitter-expenses", "splitter-group-chat", "splitter-group-settings"}
+
+// ResidencyRequest is the body of the endpoint changing the region of a group.
+type ResidencyRequest struct {
+	Region string `json:"region"`
+}
+
+// GroupResidency is the region of a group and the regions it may be moved to.
+type GroupResidency struct {
+	residency.Residency
+	AllowedRegions []string `json:"allowedRegions"`
+}
+
+// hasGroupData reports whether the group has data in one of the residencyDataTables of its
+// current region.
+func (h *Handlers) hasGroupData(ctx context.Context, groupId string) (bool, error) {
+	for _, table := range residencyDataTables {
+		result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(table),
+			KeyConditionExpression: aws.String("groupId = :groupId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":groupId": &types.AttributeValueMemberS{Value: groupId},
+			},
+			Limit: aws.Int32(1),
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(result.Items) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// groupExists reports whether the group has members.
+func (h *Handlers) groupExists(ctx context.Context, groupId string) (bool, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-group-members"),
+		IndexName:              aws.String("groupId-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(result.Items) > 0, nil
+}
+
+func residencyResponse(groupResidency residency.Residency) (events.APIGatewayProxyResponse, error) {
+	payload, err := json.Marshal(GroupResidency{Residency: groupResidency, AllowedRegions: residency.Default.AllowedRegions()})
+	if err != nil {
+		log.Println("Error marshalling residency:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// GetGroupResidencyHandler returns the region the data of a group is kept in.
+func (h *Handlers) GetGroupResidencyHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+	if residency.Default == nil {
+		return common.CreateErrorResponse(503, "Data residency is not available")
+	}
+
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Missing groupId in path")
+	}
+
+	groupResidency, err := residency.Default.Get(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting residency from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return residencyResponse(groupResidency)
+}
+
+// PutGroupResidencyHandler tags a group with the region its data must be kept in, for the
+// groups required to keep it in a jurisdiction. The data isn't moved, so the region of a
+// group can only change before it has any.
+func (h *Handlers) PutGroupResidencyHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+	if residency.Default == nil {
+		return common.CreateErrorResponse(503, "Data residency is not available")
+	}
+
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Missing groupId in path")
+	}
+
+	var incoming ResidencyRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	exists, err := h.groupExists(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if !exists {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	current, err := residency.Default.Get(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting residency from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if current.Region != incoming.Region {
+		hasData, err := h.hasGroupData(context.TODO(), groupId)
+		if err != nil {
+			log.Printf("Error querying DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if hasData {
+			return common.CreateErrorResponse(409, "The group already has data in "+current.Region)
+		}
+	}
+
+	groupResidency, err := residency.Default.Set(context.TODO(), groupId, incoming.Region, claims.Username, time.Now())
+	if errors.Is(err, residency.ErrUnknownRegion) {
+		return common.CreateErrorResponse(400, "Invalid region, expected one of "+strings.Join(residency.Default.AllowedRegions(), ", "))
+	}
+	if err != nil {
+		log.Printf("Error setting residency in DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	log.Printf("Data of group %s kept in %s, set by %s", groupId, groupResidency.Region, claims.Username)
+	return residencyResponse(groupResidency)
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/common"
+	"vassistant-backend/residency"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutGroupResidencyHandler(t *testing.T) {
+	home, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "berlin"},
+			{"userId": "user-1", "groupId": "boston"},
+		},
+		"splitter-expenses": {{"groupId": "boston", "expenseId": "rent", "title": "Rent"}},
+	})
+	assert.NoError(t, err)
+	eu, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	router := residency.NewRoutingDynamoDB(home, "us-east-1", map[string]common.DynamoDBAPI{"eu-central-1": eu})
+	h := NewHandlers(router)
+	defer func() { residency.Default = nil }()
+
+	put := func(groups, groupId, region string) events.APIGatewayProxyResponse {
+		response, err := h.PutGroupResidencyHandler(testutil.NewRequest("PUT", "/admin/groups/"+groupId+"/residency").
+			WithClaims("admin-1", "root").
+			WithClaim("cognito:groups", groups).
+			WithPathParam("groupId", groupId).
+			WithJSONBody(t, ResidencyRequest{Region: region}).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The residency must be enabled
+	assert.Equal(t, http.StatusServiceUnavailable, put("admin", "berlin", "eu-central-1").StatusCode)
+	residency.Default = router
+
+	// Only the admins tag the groups
+	assert.Equal(t, http.StatusForbidden, put("users", "berlin", "eu-central-1").StatusCode)
+
+	response := put("admin", "berlin", "eu-central-1")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var groupResidency GroupResidency
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &groupResidency))
+	assert.Equal(t, "eu-central-1", groupResidency.Region)
+	assert.Equal(t, "root", groupResidency.UpdatedBy)
+	assert.Equal(t, []string{"us-east-1", "eu-central-1"}, groupResidency.AllowedRegions)
+
+	response, err = h.GetGroupResidencyHandler(testutil.NewRequest("GET", "/admin/groups/berlin/residency").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", "admin").
+		WithPathParam("groupId", "berlin").
+		Build())
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &groupResidency))
+	assert.Equal(t, "eu-central-1", groupResidency.Region)
+
+	// The data isn't moved, so the groups with data keep their region
+	assert.Equal(t, http.StatusConflict, put("admin", "boston", "eu-central-1").StatusCode)
+	assert.Equal(t, http.StatusOK, put("admin", "boston", "us-east-1").StatusCode)
+
+	assert.Equal(t, http.StatusBadRequest, put("admin", "berlin", "mars-north-1").StatusCode)
+	assert.Equal(t, http.StatusNotFound, put("admin", "tokyo", "eu-central-1").StatusCode)
+}
@@ -0,0 +1,135 @@
+// Package admin serves the endpoints reserved to the operators of the app, the members of
+// the admin Cognito group.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// AdminGroup is the Cognito group of the operators of the app.
+const AdminGroup = "admin"
+
+// maxUsageDays bounds the range of the usage aggregates, which are computed from every call.
+const maxUsageDays = 31
+
+// defaultUsageDays is the range of the usage aggregates when none is given.
+const defaultUsageDays = 7
+
+// Usage records and aggregates the calls to the API. Usage analytics are disabled when nil.
+var Usage *metrics.UsageRecorder
+
+// UsageResponse is the response of the usage endpoint.
+type UsageResponse struct {
+	From        string                `json:"from"`
+	To          string                `json:"to"`
+	Granularity string                `json:"granularity"`
+	GroupBy     string                `json:"groupBy"`
+	Buckets     []metrics.UsageBucket `json:"buckets"`
+}
+
+// parseUsageQuery parses the query string of the usage endpoint. The range is given in days,
+// both inclusive, and defaults to the last seven days.
+func parseUsageQuery(parameters map[string]string, now time.Time) (metrics.UsageQuery, string) {
+	query := metrics.UsageQuery{
+		Granularity: metrics.ByDay,
+		Dimension:   metrics.ByRoute,
+		Route:       parameters["route"],
+		UserID:      parameters["userId"],
+	}
+	if granularity, ok := parameters["granularity"]; ok {
+		if granularity != metrics.ByHour && granularity != metrics.ByDay {
+			return query, "Granularity must be hour or day"
+		}
+		query.Granularity = granularity
+	}
+	if dimension, ok := parameters["groupBy"]; ok {
+		if dimension != metrics.ByRoute && dimension != metrics.ByUser {
+			return query, "Group by must be route or user"
+		}
+		query.Dimension = dimension
+	}
+
+	to := now.UTC().Truncate(24 * time.Hour)
+	if value, ok := parameters["to"]; ok {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return query, "Invalid to date"
+		}
+		to = date
+	}
+	from := to.AddDate(0, 0, 1-defaultUsageDays)
+	if value, ok := parameters["from"]; ok {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return query, "Invalid from date"
+		}
+		from = date
+	}
+	if from.After(to) {
+		return query, "From must not be after to"
+	}
+	if to.Sub(from) >= maxUsageDays*24*time.Hour {
+		return query, "The range can't be longer than 31 days"
+	}
+
+	query.From = from
+	query.To = to.AddDate(0, 0, 1)
+	return query, ""
+}
+
+func GetUsageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	if Usage == nil {
+		return common.CreateErrorResponse(503, "Usage analytics are not available")
+	}
+
+	query, message := parseUsageQuery(request.QueryStringParameters, time.Now())
+	if message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	buckets, err := Usage.Aggregate(context.TODO(), query)
+	if err != nil {
+		log.Printf("Error aggregating usage: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	response := UsageResponse{
+		From:        query.From.Format(time.DateOnly),
+		To:          query.To.AddDate(0, 0, -1).Format(time.DateOnly),
+		Granularity: query.Granularity,
+		GroupBy:     query.Dimension,
+		Buckets:     buckets,
+	}
+
+	// Marshal the usage into JSON for the payload
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshalling usage:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
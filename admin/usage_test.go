@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUsageQuery(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+
+	// The last seven days by default
+	query, message := parseUsageQuery(map[string]string{}, now)
+	assert.Empty(t, message)
+	assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), query.From)
+	assert.Equal(t, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), query.To)
+
+	query, message = parseUsageQuery(map[string]string{"from": "2024-01-01", "to": "2024-01-01", "granularity": "hour", "groupBy": "user"}, now)
+	assert.Empty(t, message)
+	assert.Equal(t, 24*time.Hour, query.To.Sub(query.From))
+	assert.Equal(t, "hour", query.Granularity)
+	assert.Equal(t, "user", query.Dimension)
+
+	for _, parameters := range []map[string]string{
+		{"granularity": "week"},
+		{"groupBy": "group"},
+		{"from": "yesterday"},
+		{"from": "2024-03-05", "to": "2024-03-01"},
+		{"from": "2024-01-01", "to": "2024-02-01"},
+	} {
+		_, message = parseUsageQuery(parameters, now)
+		assert.NotEmpty(t, message, parameters)
+	}
+}
+
+func TestGetUsageHandlerRequiresAdmin(t *testing.T) {
+	// Create a sample request
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/admin/usage").WithClaims("user-1", "alice").Build()
+
+	// Call the handler
+	response, err := GetUsageHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+}
@@ -0,0 +1,169 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamExpenseShares(t *testing.T) {
+	image := map[string]events.DynamoDBAttributeValue{
+		"groupId":   events.NewStringAttribute("group-1"),
+		"expenseId": events.NewStringAttribute("expense-1"),
+		"category":  events.NewStringAttribute("FOOD"),
+		"currency":  events.NewStringAttribute("USD"),
+		"dateTime":  events.NewStringAttribute("2024-02-03T10:00:00Z"),
+		"paidBy":    events.NewStringAttribute("user-1"),
+		"amount":    events.NewNumberAttribute("30"),
+		"participants": events.NewListAttribute([]events.DynamoDBAttributeValue{
+			events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{
+				"userId":          events.NewStringAttribute("user-1"),
+				"calculatedMoney": events.NewStringAttribute("10.00"),
+			}),
+			events.NewMapAttribute(map[string]events.DynamoDBAttributeValue{
+				"userId":          events.NewStringAttribute("user-2"),
+				"calculatedMoney": events.NewStringAttribute("20.00"),
+			}),
+		}),
+	}
+
+	// Set up an OpenSearch domain recording the bulk requests
+	var indexed []Share
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_bulk":
+			scanner := bufio.NewScanner(r.Body)
+			for line := 0; scanner.Scan(); line++ {
+				if line%2 == 1 {
+					var share Share
+					assert.NoError(t, json.Unmarshal(scanner.Bytes(), &share))
+					indexed = append(indexed, share)
+				}
+			}
+			io.WriteString(w, `{"errors":false}`)
+		case "/" + SharesIndex + "/_delete_by_query":
+			indexed = nil
+			io.WriteString(w, `{"deleted":2}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	// Each participant gets the share of their calculated money
+	err := client.IndexStreamEvent(context.TODO(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{EventName: "INSERT", Change: events.DynamoDBStreamRecord{NewImage: image}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Share{
+		{GroupID: "group-1", ExpenseID: "expense-1", UserID: "user-1", PaidBy: []string{"user-1"}, Category: "FOOD", Currency: "USD", DateTime: "2024-02-03T10:00:00Z", Amount: "10.00"},
+		{GroupID: "group-1", ExpenseID: "expense-1", UserID: "user-2", PaidBy: []string{"user-1"}, Category: "FOOD", Currency: "USD", DateTime: "2024-02-03T10:00:00Z", Amount: "20.00"},
+	}, indexed)
+
+	// Removing the expense deletes its shares
+	err = client.IndexStreamEvent(context.TODO(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{EventName: "REMOVE", Change: events.DynamoDBStreamRecord{Keys: map[string]events.DynamoDBAttributeValue{
+			"groupId":   events.NewStringAttribute("group-1"),
+			"expenseId": events.NewStringAttribute("expense-1"),
+		}}},
+	}})
+	assert.NoError(t, err)
+	assert.Empty(t, indexed)
+}
+
+func TestAggregate(t *testing.T) {
+	// Set up an OpenSearch domain answering a category aggregation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/"+SharesIndex+"/_search", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(body), `"term":{"groupId":"group-1"}`)
+		assert.Contains(t, string(body), `"gte":"2024-01-01T00:00:00Z"`)
+		assert.Contains(t, string(body), `"terms":{"field":"category","size":100}`)
+		io.WriteString(w, `{"aggregations":{"groups":{"buckets":[
+			{"key":"FOOD","currencies":{"buckets":[
+				{"key":"USD","total":{"value":30.000000001},"expenses":{"value":2}},
+				{"key":"BRL","total":{"value":12.5},"expenses":{"value":1}}
+			]}}
+		]}}}`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	buckets, err := client.Aggregate(context.TODO(), Query{GroupID: "group-1", GroupBy: ByCategory, From: "2024-01-01T00:00:00Z"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Bucket{
+		{Key: "FOOD", Currency: "USD", Total: "30.00", Expenses: 2},
+		{Key: "FOOD", Currency: "BRL", Total: "12.50", Expenses: 1},
+	}, buckets)
+}
+
+func TestAggregateByMonth(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		assert.Contains(t, string(body), `"calendar_interval":"month"`)
+		io.WriteString(w, `{"aggregations":{"groups":{"buckets":[
+			{"key":1706745600000,"key_as_string":"2024-02","currencies":{"buckets":[{"key":"USD","total":{"value":5},"expenses":{"value":1}}]}}
+		]}}}`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	buckets, err := client.Aggregate(context.TODO(), Query{GroupID: "group-1", GroupBy: ByMonth})
+	assert.NoError(t, err)
+	assert.Equal(t, []Bucket{{Key: "2024-02", Currency: "USD", Total: "5.00", Expenses: 1}}, buckets)
+	assert.NotContains(t, string(body), `"offset"`)
+
+	// The months starting on the 25th are shifted by 24 days
+	_, err = client.Aggregate(context.TODO(), Query{GroupID: "group-1", GroupBy: ByMonth, MonthStartDay: 25})
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"offset":"+24d"`)
+
+	// The months start at midnight in the time zone of the group
+	_, err = client.Aggregate(context.TODO(), Query{GroupID: "group-1", GroupBy: ByMonth, TimeZone: "America/Sao_Paulo"})
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"time_zone":"America/Sao_Paulo"`)
+
+	_, err = client.Aggregate(context.TODO(), Query{GroupID: "group-1", GroupBy: "weekday"})
+	assert.Error(t, err)
+}
+
+func TestAggregateByWeek(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		io.WriteString(w, `{"aggregations":{"groups":{"buckets":[
+			{"key":1707696000000,"key_as_string":"2024-02-12","currencies":{"buckets":[{"key":"USD","total":{"value":7.5},"expenses":{"value":2}}]}}
+		]}}}`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	// The month start day only shifts the months
+	buckets, err := client.Aggregate(context.TODO(), Query{GroupID: "group-1", GroupBy: ByWeek, MonthStartDay: 25})
+	assert.NoError(t, err)
+	assert.Equal(t, []Bucket{{Key: "2024-02-12", Currency: "USD", Total: "7.50", Expenses: 2}}, buckets)
+	assert.Contains(t, string(body), `"calendar_interval":"week","field":"dateTime","format":"yyyy-MM-dd"`)
+	assert.NotContains(t, string(body), `"offset"`)
+	assert.True(t, ValidGranularity(ByDay))
+	assert.False(t, ValidGranularity(ByCategory))
+}
+
+func TestEnsureIndexExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"error":{"type":"resource_already_exists_exception"}}`)
+	}))
+	defer server.Close()
+	client := &Client{Endpoint: server.URL, HTTPClient: server.Client()}
+
+	assert.NoError(t, client.EnsureIndex(context.TODO()))
+}
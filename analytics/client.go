@@ -0,0 +1,92 @@
+// Package analytics indexes the group expenses into OpenSearch and runs the aggregations
+// of the statistics dashboard, which DynamoDB alone can't serve efficiently.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ErrNotConfigured is returned when no OpenSearch domain is configured.
+var ErrNotConfigured = errors.New("analytics are not configured")
+
+// SharesIndex is the index holding one document per participant share of an expense.
+const SharesIndex = "expense-shares"
+
+// Client sends requests to an OpenSearch domain, signing them with SigV4 when credentials
+// are given.
+type Client struct {
+	Endpoint    string
+	Region      string
+	Credentials aws.CredentialsProvider
+	HTTPClient  *http.Client
+	signer      *v4.Signer
+}
+
+// DefaultClient is the client used by the handlers. It is nil until a domain is configured.
+var DefaultClient *Client
+
+// NewClient creates a client for the OpenSearch domain endpoint, signing the requests with
+// the credentials of the AWS config.
+func NewClient(endpoint string, cfg aws.Config) *Client {
+	return &Client{
+		Endpoint:    strings.TrimSuffix(endpoint, "/"),
+		Region:      cfg.Region,
+		Credentials: cfg.Credentials,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		signer:      v4.NewSigner(),
+	}
+}
+
+// Do sends the request and returns the response body, failing on non-2xx responses.
+func (c *Client) Do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	if c.Credentials != nil {
+		credentials, err := c.Credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(body)
+		signer := c.signer
+		if signer == nil {
+			signer = v4.NewSigner()
+		}
+		err = signer.SignHTTP(ctx, credentials, request, hex.EncodeToString(hash[:]), "es", c.Region, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	payload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return payload, fmt.Errorf("OpenSearch %s %s returned %s: %s", method, path, response.Status, payload)
+	}
+	return payload, nil
+}
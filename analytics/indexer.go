@@ -0,0 +1,247 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// sharesMapping is the mapping of the SharesIndex. Amounts are scaled floats so sums of
+// cents stay exact.
+const sharesMapping = `{
+  "mappings": {
+    "properties": {
+      "groupId":   {"type": "keyword"},
+      "expenseId": {"type": "keyword"},
+      "userId":    {"type": "keyword"},
+      "paidBy":    {"type": "keyword"},
+      "category":  {"type": "keyword"},
+      "currency":  {"type": "keyword"},
+      "dateTime":  {"type": "date"},
+      "amount":    {"type": "scaled_float", "scaling_factor": 100}
+    }
+  }
+}`
+
+// Share is the document indexed for the share of a participant in an expense.
+type Share struct {
+	GroupID   string      `json:"groupId"`
+	ExpenseID string      `json:"expenseId"`
+	UserID    string      `json:"userId"`
+	PaidBy    []string    `json:"paidBy"`
+	Category  string      `json:"category"`
+	Currency  string      `json:"currency"`
+	DateTime  string      `json:"dateTime"`
+	Amount    json.Number `json:"amount"`
+}
+
+// streamExpense holds the attributes of a splitter-expenses stream image that are indexed.
+type streamExpense struct {
+	GroupID      string        `dynamodbav:"groupId"`
+	ExpenseID    string        `dynamodbav:"expenseId"`
+	Category     string        `dynamodbav:"category"`
+	Currency     string        `dynamodbav:"currency"`
+	DateTime     string        `dynamodbav:"dateTime"`
+	PaidBy       string        `dynamodbav:"paidBy"`
+	Amount       json.Number   `dynamodbav:"amount"`
+	Payers       []streamPayer `dynamodbav:"payers"`
+	Participants []struct {
+		UserID          string      `dynamodbav:"userId"`
+		CalculatedMoney json.Number `dynamodbav:"calculatedMoney"`
+	} `dynamodbav:"participants"`
+}
+
+// streamPayer holds a payer of a splitter-expenses stream image.
+type streamPayer struct {
+	UserID string      `dynamodbav:"userId"`
+	Amount json.Number `dynamodbav:"amount"`
+}
+
+// shares returns the documents of the expense, one per participant. An expense without
+// participants is counted for its payers, each with the amount they paid. Expenses stored
+// before payers have their paidBy as single payer.
+func (e streamExpense) shares() []Share {
+	if len(e.Payers) == 0 && e.PaidBy != "" {
+		e.Payers = []streamPayer{{UserID: e.PaidBy, Amount: e.Amount}}
+	}
+	paidBy := make([]string, 0, len(e.Payers))
+	for _, payer := range e.Payers {
+		paidBy = append(paidBy, payer.UserID)
+	}
+
+	share := Share{
+		GroupID:   e.GroupID,
+		ExpenseID: e.ExpenseID,
+		PaidBy:    paidBy,
+		Category:  e.Category,
+		Currency:  e.Currency,
+		DateTime:  e.DateTime,
+	}
+	if len(e.Participants) == 0 {
+		shares := make([]Share, 0, len(e.Payers))
+		for _, payer := range e.Payers {
+			share.UserID, share.Amount = payer.UserID, payer.Amount
+			shares = append(shares, share)
+		}
+		return shares
+	}
+
+	shares := make([]Share, 0, len(e.Participants))
+	for _, participant := range e.Participants {
+		share.UserID, share.Amount = participant.UserID, participant.CalculatedMoney
+		shares = append(shares, share)
+	}
+	return shares
+}
+
+// EnsureIndex creates the SharesIndex with its mapping, unless it exists already.
+func (c *Client) EnsureIndex(ctx context.Context) error {
+	payload, err := c.Do(ctx, "PUT", "/"+SharesIndex, "application/json", []byte(sharesMapping))
+	if err != nil && strings.Contains(string(payload), "resource_already_exists_exception") {
+		return nil
+	}
+	return err
+}
+
+// IndexStreamEvent applies the records of a splitter-expenses stream event to the index.
+// The shares of a changed expense are replaced, since its participants may have changed.
+func (c *Client) IndexStreamEvent(ctx context.Context, event events.DynamoDBEvent) error {
+	for _, record := range event.Records {
+		switch events.DynamoDBOperationType(record.EventName) {
+		case events.DynamoDBOperationTypeInsert:
+			err := c.indexExpense(ctx, record.Change.NewImage)
+			if err != nil {
+				return err
+			}
+		case events.DynamoDBOperationTypeModify:
+			err := c.deleteExpense(ctx, record.Change.Keys)
+			if err != nil {
+				return err
+			}
+			err = c.indexExpense(ctx, record.Change.NewImage)
+			if err != nil {
+				return err
+			}
+		case events.DynamoDBOperationTypeRemove:
+			err := c.deleteExpense(ctx, record.Change.Keys)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) indexExpense(ctx context.Context, image map[string]events.DynamoDBAttributeValue) error {
+	var expense streamExpense
+	err := attributevalue.UnmarshalMap(fromStreamImage(image), &expense)
+	if err != nil {
+		return err
+	}
+
+	err = c.indexShares(ctx, expense.shares())
+	if err != nil {
+		return fmt.Errorf("bulk indexing expense %s failed: %w", expense.ExpenseID, err)
+	}
+
+	log.Printf("Indexed expense %s of group %s", expense.ExpenseID, expense.GroupID)
+	return nil
+}
+
+// indexShares bulk indexes the shares, keyed by expense and user so retried records
+// overwrite them.
+func (c *Client) indexShares(ctx context.Context, shares []Share) error {
+	if len(shares) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, share := range shares {
+		action := map[string]map[string]string{"index": {"_index": SharesIndex, "_id": share.ExpenseID + "#" + share.UserID}}
+		if err := encoder.Encode(action); err != nil {
+			return err
+		}
+		if err := encoder.Encode(share); err != nil {
+			return err
+		}
+	}
+
+	payload, err := c.Do(ctx, "POST", "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return err
+	}
+	if result.Errors {
+		return fmt.Errorf("%s", payload)
+	}
+	return nil
+}
+
+func (c *Client) deleteExpense(ctx context.Context, keys map[string]events.DynamoDBAttributeValue) error {
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]string{"groupId": keys["groupId"].String()}},
+					map[string]interface{}{"term": map[string]string{"expenseId": keys["expenseId"].String()}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.Do(ctx, "POST", "/"+SharesIndex+"/_delete_by_query", "application/json", query)
+	return err
+}
+
+// fromStreamImage converts a DynamoDB stream image into attribute values, so it can be
+// unmarshalled like the items read through the SDK.
+func fromStreamImage(image map[string]events.DynamoDBAttributeValue) map[string]types.AttributeValue {
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		item[name] = fromStreamValue(value)
+	}
+	return item
+}
+
+func fromStreamValue(value events.DynamoDBAttributeValue) types.AttributeValue {
+	switch value.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: value.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: value.Number()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: value.Boolean()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: value.Binary()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: value.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: value.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: value.BinarySet()}
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, 0, len(value.List()))
+		for _, element := range value.List() {
+			list = append(list, fromStreamValue(element))
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case events.DataTypeMap:
+		return &types.AttributeValueMemberM{Value: fromStreamImage(value.Map())}
+	default:
+		return &types.AttributeValueMemberNULL{Value: true}
+	}
+}
@@ -0,0 +1,172 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Dimensions the spending can be grouped by
+const (
+	ByDay      = "day"
+	ByWeek     = "week"
+	ByMonth    = "month"
+	ByCategory = "category"
+	ByMember   = "member"
+)
+
+// histogramFormats are the formats of the keys of the date dimensions, each period being
+// keyed by the date it starts on. The weeks start on Mondays.
+var histogramFormats = map[string]string{
+	ByDay:   "yyyy-MM-dd",
+	ByWeek:  "yyyy-MM-dd",
+	ByMonth: "yyyy-MM",
+}
+
+// maxBuckets bounds the number of categories, members or currencies returned.
+const maxBuckets = 100
+
+// Bucket is the spending of a group for one value of the dimension, in one currency.
+type Bucket struct {
+	Key      string      `json:"key"`
+	Currency string      `json:"currency"`
+	Total    json.Number `json:"total"`
+	Expenses int         `json:"expenses"`
+}
+
+// Query selects the spending of a group to aggregate. From and To are RFC 3339 date times,
+// To being exclusive; either can be empty to leave the range open. MonthStartDay is the day
+// the months start on, the 1st when 0; each month is keyed by the calendar month it starts in.
+// TimeZone is the IANA time zone the days, weeks and months start at midnight in, UTC when
+// empty.
+type Query struct {
+	GroupID       string
+	GroupBy       string
+	From          string
+	To            string
+	MonthStartDay int
+	TimeZone      string
+}
+
+// ValidDimension reports whether the spending can be grouped by the dimension.
+func ValidDimension(dimension string) bool {
+	return dimension == ByMonth || dimension == ByCategory || dimension == ByMember
+}
+
+// ValidGranularity reports whether the spending can be bucketed into periods of the
+// granularity.
+func ValidGranularity(granularity string) bool {
+	_, ok := histogramFormats[granularity]
+	return ok
+}
+
+// searchBody builds the OpenSearch aggregation of the query. Amounts are split by currency
+// first, as amounts in different currencies can't be added up.
+func (q Query) searchBody() ([]byte, error) {
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]string{"groupId": q.GroupID}},
+	}
+	if q.From != "" || q.To != "" {
+		dateRange := map[string]string{}
+		if q.From != "" {
+			dateRange["gte"] = q.From
+		}
+		if q.To != "" {
+			dateRange["lt"] = q.To
+		}
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"dateTime": dateRange}})
+	}
+
+	var grouping map[string]interface{}
+	switch q.GroupBy {
+	case ByDay, ByWeek, ByMonth:
+		histogram := map[string]string{"field": "dateTime", "calendar_interval": q.GroupBy, "format": histogramFormats[q.GroupBy]}
+		if q.GroupBy == ByMonth && q.MonthStartDay > 1 {
+			histogram["offset"] = fmt.Sprintf("+%dd", q.MonthStartDay-1)
+		}
+		if q.TimeZone != "" && q.TimeZone != "UTC" {
+			histogram["time_zone"] = q.TimeZone
+		}
+		grouping = map[string]interface{}{"date_histogram": histogram}
+	case ByCategory:
+		grouping = map[string]interface{}{"terms": map[string]interface{}{"field": "category", "size": maxBuckets}}
+	case ByMember:
+		grouping = map[string]interface{}{"terms": map[string]interface{}{"field": "userId", "size": maxBuckets}}
+	default:
+		return nil, fmt.Errorf("unknown dimension %q", q.GroupBy)
+	}
+	grouping["aggs"] = map[string]interface{}{
+		"currencies": map[string]interface{}{
+			"terms": map[string]interface{}{"field": "currency", "size": maxBuckets, "missing": ""},
+			"aggs": map[string]interface{}{
+				"total":    map[string]interface{}{"sum": map[string]string{"field": "amount"}},
+				"expenses": map[string]interface{}{"cardinality": map[string]string{"field": "expenseId"}},
+			},
+		},
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"size":  0,
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+		"aggs":  map[string]interface{}{"groups": grouping},
+	})
+}
+
+// searchResponse is the part of the OpenSearch response holding the aggregation.
+type searchResponse struct {
+	Aggregations struct {
+		Groups struct {
+			Buckets []struct {
+				Key         json.RawMessage `json:"key"`
+				KeyAsString string          `json:"key_as_string"`
+				Currencies  struct {
+					Buckets []struct {
+						Key      string                  `json:"key"`
+						Total    struct{ Value float64 } `json:"total"`
+						Expenses struct{ Value int }     `json:"expenses"`
+					} `json:"buckets"`
+				} `json:"currencies"`
+			} `json:"buckets"`
+		} `json:"groups"`
+	} `json:"aggregations"`
+}
+
+// Aggregate returns the spending of the group grouped by the dimension of the query.
+func (c *Client) Aggregate(ctx context.Context, query Query) ([]Bucket, error) {
+	body, err := query.searchBody()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := c.Do(ctx, "POST", "/"+SharesIndex+"/_search", "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	var response searchResponse
+	if err := json.Unmarshal(payload, &response); err != nil {
+		return nil, err
+	}
+
+	buckets := []Bucket{}
+	for _, group := range response.Aggregations.Groups.Buckets {
+		key := group.KeyAsString
+		if key == "" {
+			if err := json.Unmarshal(group.Key, &key); err != nil {
+				return nil, err
+			}
+		}
+		for _, currency := range group.Currencies.Buckets {
+			// Sums of scaled floats are whole cents, up to floating point noise
+			total := math.Round(currency.Total.Value*100) / 100
+			buckets = append(buckets, Bucket{
+				Key:      key,
+				Currency: currency.Key,
+				Total:    json.Number(strconv.FormatFloat(total, 'f', 2, 64)),
+				Expenses: currency.Expenses.Value,
+			})
+		}
+	}
+	return buckets, nil
+}
@@ -0,0 +1,66 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DeleteShares deletes the shares of the group, or of every group when groupId is empty,
+// dated from From inclusive to To exclusive, either left empty to leave the range open. It
+// returns how many were deleted.
+func (c *Client) DeleteShares(ctx context.Context, groupId, from, to string) (int, error) {
+	filters := []interface{}{}
+	if groupId != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{"groupId": groupId}})
+	}
+	dateRange := map[string]string{}
+	if from != "" {
+		dateRange["gte"] = from
+	}
+	if to != "" {
+		dateRange["lt"] = to
+	}
+	if len(dateRange) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"dateTime": dateRange}})
+	}
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	payload, err := c.Do(ctx, "POST", "/"+SharesIndex+"/_delete_by_query?conflicts=proceed", "application/json", query)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Deleted int `json:"deleted"`
+	}
+	err = json.Unmarshal(payload, &result)
+	return result.Deleted, err
+}
+
+// IndexExpenses indexes the shares of splitter-expenses items read back from the table, like
+// the stream records of their insertion, in a single bulk request. Replaying them is how the
+// index recovers from the records its stream lost.
+func (c *Client) IndexExpenses(ctx context.Context, items []map[string]types.AttributeValue) (int, error) {
+	var expenses []streamExpense
+	err := attributevalue.UnmarshalListOfMaps(items, &expenses)
+	if err != nil {
+		return 0, err
+	}
+
+	var shares []Share
+	for _, expense := range expenses {
+		shares = append(shares, expense.shares()...)
+	}
+	err = c.indexShares(ctx, shares)
+	if err != nil {
+		return 0, err
+	}
+	return len(shares), nil
+}
@@ -1,7 +1,10 @@
 package api
 
 import (
+	"net/http"
+	"reflect"
 	"regexp"
+	"strings"
 	"vassistant-backend/common"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -10,38 +13,140 @@ import (
 // HandlerFunc defines the function signature for our Lambda handlers.
 type HandlerFunc func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
 
-// Route defines the structure for a single API route.
+// Middleware wraps the handler of a matched route.
+type Middleware func(route Route, next HandlerFunc) HandlerFunc
+
+// Route defines the structure for a single API route. ReadOnly routes don't change any
+// data; the others are turned away during maintenance unless AllowedInMaintenance, and
+// need a device signature when signatures are required unless Unsigned. The bodies of the
+// routes with a StrictBody type can't have fields it doesn't. The RateLimit of a route only
+// documents the limit its handler enforces.
 type Route struct {
-	Method  string
-	Path    *regexp.Regexp
-	Handler HandlerFunc
+	Method               string
+	Path                 *regexp.Regexp
+	Template             string
+	Handler              HandlerFunc
+	ReadOnly             bool
+	AllowedInMaintenance bool
+	Unsigned             bool
+	StrictBody           reflect.Type
+	RateLimit            *RateLimit
+}
+
+// RouteOption annotates a route as it is added.
+type RouteOption func(route *Route)
+
+// ReadOnly marks a route as not changing any data, e.g. a search sent as a POST.
+func ReadOnly(route *Route) {
+	route.ReadOnly = true
+}
+
+// Mutating marks a route as changing data, e.g. a GET recording that something was seen.
+func Mutating(route *Route) {
+	route.ReadOnly = false
+}
+
+// AllowedInMaintenance lets a mutating route through the read-only maintenance mode, e.g.
+// the route turning it off.
+func AllowedInMaintenance(route *Route) {
+	route.AllowedInMaintenance = true
+}
+
+// Unsigned lets a mutating route through without a device signature, e.g. the route
+// provisioning the key of a new device or those called by automations with an API key.
+func Unsigned(route *Route) {
+	route.Unsigned = true
+}
+
+// isSafeMethod reports whether the HTTP method is one that shouldn't change any data.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
 }
 
 // Router is a collection of routes that can be served.
 type Router struct {
-	routes []Route
+	routes      []Route
+	middlewares []Middleware
+	basePath    string
 }
 
+// pathParamPattern matches named capture groups, e.g. (?P<groupId>[^/]+).
+var pathParamPattern = regexp.MustCompile(`\(\?P<(\w+)>[^)]*\)`)
+
 // NewRouter creates a new Router instance.
 func NewRouter() *Router {
 	return &Router{}
 }
 
-// AddRoute adds a new route to the router.
-func (r *Router) AddRoute(method, path string, handler HandlerFunc) {
+// AddRoute adds a new route to the router. The GET, HEAD and OPTIONS routes are read-only
+// and the others mutating, unless the options say otherwise.
+func (r *Router) AddRoute(method, path string, handler HandlerFunc, options ...RouteOption) {
 	route := Route{
-		Method:  method,
-		Path:    regexp.MustCompile("^" + path + "$"),
-		Handler: handler,
+		Method:   method,
+		Path:     regexp.MustCompile("^" + path + "$"),
+		Template: pathParamPattern.ReplaceAllString(path, "{$1}"),
+		Handler:  handler,
+		ReadOnly: isSafeMethod(method),
+	}
+	for _, option := range options {
+		option(&route)
 	}
 	r.routes = append(r.routes, route)
 }
 
+// Routes returns the registered routes, in registration order.
+func (r *Router) Routes() []Route {
+	return append([]Route(nil), r.routes...)
+}
+
+// SetBasePath sets the path the routes are served under, e.g. the proxy resource
+// /VassistantBackendProxy, stripped from the requests before they are matched. The requests
+// outside of it are answered with 404. An empty base path serves the routes from the root.
+func (r *Router) SetBasePath(basePath string) {
+	r.basePath = strings.TrimSuffix(basePath, "/")
+}
+
+// routePath returns the path of the request the routes are matched against, relative to the
+// base path, and whether the request is under it. The stage is stripped too when the path
+// starts with it, which API Gateway only does when requestContext.path is the same path,
+// e.g. for the HTTP APIs with named stages, unlike the REST APIs where only
+// requestContext.path starts with the stage.
+func (r *Router) routePath(request events.APIGatewayProxyRequest) (string, bool) {
+	path := request.Path
+	if stage := request.RequestContext.Stage; stage != "" && stage != "$default" && request.RequestContext.Path == path {
+		if rest, ok := strings.CutPrefix(path, "/"+stage); ok && (rest == "" || rest[0] == '/') {
+			path = rest
+		}
+	}
+	rest, ok := strings.CutPrefix(path, r.basePath)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return "", false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// Use registers a middleware applied to every matched route.
+// Middlewares run in the order they were registered.
+func (r *Router) Use(middleware Middleware) {
+	r.middlewares = append(r.middlewares, middleware)
+}
+
 // Serve handles the incoming request by finding the appropriate route.
 func (r *Router) Serve(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	path, ok := r.routePath(request)
+	if !ok {
+		return common.CreateErrorResponse(404, "Not Found")
+	}
 	for _, route := range r.routes {
 		if route.Method == request.HTTPMethod {
-			matches := route.Path.FindStringSubmatch(request.Path)
+			matches := route.Path.FindStringSubmatch(path)
 			if len(matches) > 0 {
 				// Extract path parameters
 				pathParams := make(map[string]string)
@@ -51,7 +156,17 @@ func (r *Router) Serve(request events.APIGatewayProxyRequest) (events.APIGateway
 					}
 				}
 				request.PathParameters = pathParams
-				return route.Handler(request)
+
+				// Wrap the handler so the first registered middleware runs first, the
+				// strict decoding of the body running last
+				handler := route.Handler
+				if route.StrictBody != nil {
+					handler = strictJSON(route.StrictBody, handler)
+				}
+				for i := len(r.middlewares) - 1; i >= 0; i-- {
+					handler = r.middlewares[i](route, handler)
+				}
+				return handler(request)
 			}
 		}
 	}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzRouterServe(f *testing.F) {
+	f.Add("GET", "/VassistantBackendProxy/financial/groups/group-1/expenses/expense-1")
+	f.Add("GET", "/VassistantBackendProxy/financial/groups//expenses/")
+	f.Add("GET", "/VassistantBackendProxy/financial/groups/%2e%2e%2f/expenses/x")
+	f.Add("GET", "/VassistantBackendProxy/financial/groups/a/b/expenses/c")
+	f.Add("POST", "/VassistantBackendProxy/financial/groups/group-1/expenses")
+	f.Add("GET", "/VassistantBackendProxy/financial/groups/"+strings.Repeat("(a+)+", 1000)+"/expenses/x")
+	f.Add("get", "/VassistantBackendProxy/financial/groups/\x00/expenses/\n")
+
+	var captured map[string]string
+	handler := func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		captured = request.PathParameters
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}
+
+	router := NewRouter()
+	router.AddRoute("GET", "/VassistantBackendProxy/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)", handler)
+	router.AddRoute("POST", "/VassistantBackendProxy/financial/groups/(?P<groupId>[^/]+)/expenses", handler)
+
+	f.Fuzz(func(t *testing.T, method, path string) {
+		captured = nil
+		response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: method, Path: path})
+		assert.NoError(t, err)
+		if response.StatusCode == http.StatusNotFound {
+			return
+		}
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		// Path parameters are never empty and never span several segments
+		for name, value := range captured {
+			assert.NotEmpty(t, value, name)
+			assert.NotContains(t, value, "/", name)
+		}
+
+		// The path is fully described by the route and its parameters
+		var expected string
+		switch method {
+		case "GET":
+			expected = "/VassistantBackendProxy/financial/groups/" + captured["groupId"] + "/expenses/" + captured["expenseId"]
+		case "POST":
+			expected = "/VassistantBackendProxy/financial/groups/" + captured["groupId"] + "/expenses"
+		default:
+			t.Fatalf("route matched unexpected method %q", method)
+		}
+		assert.Equal(t, expected, path)
+	})
+}
+
+func TestRouteTemplate(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)", nil)
+
+	assert.Equal(t, "/financial/groups/{groupId}/expenses/{expenseId}", router.routes[0].Template)
+}
+
+func TestRouterBasePath(t *testing.T) {
+	var served string
+	router := NewRouter()
+	router.SetBasePath("/VassistantBackendProxy")
+	router.AddRoute("GET", "/financial/groups", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		served = request.Path
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	})
+
+	serve := func(path, contextPath, stage string) int {
+		request := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: path}
+		request.RequestContext.Path = contextPath
+		request.RequestContext.Stage = stage
+		response, err := router.Serve(request)
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+
+	// The REST APIs only prefix requestContext.path with the stage
+	assert.Equal(t, http.StatusOK, serve("/VassistantBackendProxy/financial/groups", "/prod/VassistantBackendProxy/financial/groups", "prod"))
+	assert.Equal(t, "/VassistantBackendProxy/financial/groups", served)
+
+	// The HTTP APIs with named stages prefix both
+	assert.Equal(t, http.StatusOK, serve("/prod/VassistantBackendProxy/financial/groups", "/prod/VassistantBackendProxy/financial/groups", "prod"))
+	assert.Equal(t, http.StatusOK, serve("/VassistantBackendProxy/financial/groups", "/VassistantBackendProxy/financial/groups", "$default"))
+
+	// The requests outside of the base path, or of another proxy resource, are not found
+	assert.Equal(t, http.StatusNotFound, serve("/financial/groups", "/prod/financial/groups", "prod"))
+	assert.Equal(t, http.StatusNotFound, serve("/VassistantBackendProxyV2/financial/groups", "/prod/VassistantBackendProxyV2/financial/groups", "prod"))
+	assert.Equal(t, http.StatusNotFound, serve("/prod/VassistantBackendProxy/financial/groups", "/prod/prod/VassistantBackendProxy/financial/groups", "prod"))
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RateLimit is how many requests of each key a route serves per window, documented on the
+// route with RateLimited. The requests over it get a 429 with a Retry-After header.
+type RateLimit struct {
+	Limit         int    `json:"limit"`
+	WindowSeconds int    `json:"windowSeconds"`
+	Key           string `json:"key"`
+}
+
+// RateLimited documents the rate limit of a route, enforced by its handler.
+func RateLimited(limit RateLimit) RouteOption {
+	return func(route *Route) {
+		route.RateLimit = &limit
+	}
+}
+
+// PayloadLimits are the largest bodies the API accepts and returns, in bytes.
+type PayloadLimits struct {
+	RequestBytes  int `json:"requestBytes"`
+	ResponseBytes int `json:"responseBytes"`
+	UploadBytes   int `json:"uploadBytes"`
+	// OffloadedResponses tells whether the responses larger than ResponseBytes are stored and
+	// redirected to with a 303, rather than failing
+	OffloadedResponses bool `json:"offloadedResponses"`
+}
+
+// Capabilities describes how the clients should call the API, for the SDK generators to
+// configure their retries, pagination and limits.
+type Capabilities struct {
+	BasePath    string                  `json:"basePath"`
+	Idempotency IdempotencyCapabilities `json:"idempotency"`
+	Pagination  PaginationCapabilities  `json:"pagination"`
+	Retries     RetryCapabilities       `json:"retries"`
+	Payloads    PayloadLimits           `json:"payloads"`
+	Routes      []RouteCapabilities     `json:"routes"`
+}
+
+// IdempotencyCapabilities tells which requests can be retried safely. The API doesn't take
+// idempotency keys, so the routes creating resources aren't retry-safe.
+type IdempotencyCapabilities struct {
+	RetrySafeMethods []string `json:"retrySafeMethods"`
+	Notes            string   `json:"notes"`
+}
+
+// PaginationCapabilities is how the listings are paginated.
+type PaginationCapabilities struct {
+	LimitParameter  string `json:"limitParameter"`
+	CursorParameter string `json:"cursorParameter"`
+	CursorHeader    string `json:"cursorHeader"`
+	EnvelopeField   string `json:"envelopeField"`
+	CursorFormat    string `json:"cursorFormat"`
+}
+
+// RetryCapabilities is what the clients retrying a request should honour.
+type RetryCapabilities struct {
+	RetryableStatuses []int  `json:"retryableStatuses"`
+	RetryAfterHeader  string `json:"retryAfterHeader"`
+}
+
+// RouteCapabilities is what the capabilities say of a route.
+type RouteCapabilities struct {
+	Method    string     `json:"method"`
+	Path      string     `json:"path"`
+	ReadOnly  bool       `json:"readOnly"`
+	RetrySafe bool       `json:"retrySafe"`
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+}
+
+// retrySafe reports whether retrying a request of the route leaves the data as a single
+// request does: the read-only routes, and the PUT and DELETE ones, which set or remove a
+// resource whole. A retried PUT or DELETE may still answer 409 or 404 where the first one
+// succeeded.
+func retrySafe(route Route) bool {
+	return route.ReadOnly || route.Method == http.MethodPut || route.Method == http.MethodDelete
+}
+
+// Describe returns the capabilities of the routes of the router, with the payload limits.
+func (r *Router) Describe(payloads PayloadLimits) Capabilities {
+	capabilities := Capabilities{
+		BasePath: r.basePath,
+		Idempotency: IdempotencyCapabilities{
+			RetrySafeMethods: []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete},
+			Notes:            "Idempotency keys are not supported: a retried POST may create its resource twice, unless its route is retry-safe. Retry the other routes only after checking whether the first request succeeded.",
+		},
+		Pagination: PaginationCapabilities{
+			LimitParameter:  "limit",
+			CursorParameter: "cursor",
+			CursorHeader:    common.NextCursorHeader,
+			EnvelopeField:   "meta.pagination.nextCursor",
+			CursorFormat:    "Opaque URL-safe string, only valid for the listing and order it was returned for. The last page has none.",
+		},
+		Retries: RetryCapabilities{
+			RetryableStatuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+			RetryAfterHeader:  "Retry-After",
+		},
+		Payloads: payloads,
+		Routes:   make([]RouteCapabilities, 0, len(r.routes)),
+	}
+	for _, route := range r.routes {
+		capabilities.Routes = append(capabilities.Routes, RouteCapabilities{
+			Method:    route.Method,
+			Path:      route.Template,
+			ReadOnly:  route.ReadOnly,
+			RetrySafe: retrySafe(route),
+			RateLimit: route.RateLimit,
+		})
+	}
+	return capabilities
+}
+
+// CapabilitiesHandler serves the capabilities of the routes of the router, described when
+// requested so the routes registered after it are included. The payload limits are read
+// then too, as offloading may be configured after the routes are registered.
+func CapabilitiesHandler(router *Router, payloads func() PayloadLimits) HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		payload, err := json.Marshal(router.Describe(payloads()))
+		if err != nil {
+			log.Println("Error marshalling capabilities:", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(payload),
+		}, nil
+	}
+}
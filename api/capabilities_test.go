@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	ok := func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+	}
+	router := NewRouter()
+	router.SetBasePath("/VassistantBackendProxy")
+	router.AddRoute("GET", "/capabilities", CapabilitiesHandler(router, func() PayloadLimits {
+		return PayloadLimits{RequestBytes: 6 << 20, ResponseBytes: 6 << 20, UploadBytes: 5 << 20}
+	}))
+	router.AddRoute("POST", "/groups/(?P<groupId>[^/]+)/expenses", ok)
+	router.AddRoute("POST", "/search", ok, ReadOnly)
+	router.AddRoute("PUT", "/groups/(?P<groupId>[^/]+)/settings", ok)
+	router.AddRoute("POST", "/public/split-preview", ok, RateLimited(RateLimit{Limit: 60, WindowSeconds: 60, Key: "sourceIp"}))
+
+	response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/VassistantBackendProxy/capabilities"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var capabilities Capabilities
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &capabilities))
+
+	assert.Equal(t, "/VassistantBackendProxy", capabilities.BasePath)
+	assert.Equal(t, 5<<20, capabilities.Payloads.UploadBytes)
+	assert.Equal(t, "X-Next-Cursor", capabilities.Pagination.CursorHeader)
+	assert.Contains(t, capabilities.Retries.RetryableStatuses, http.StatusTooManyRequests)
+
+	// The routes registered after the handler are described, the POSTs creating resources
+	// being the only ones not safe to retry
+	assert.Equal(t, []RouteCapabilities{
+		{Method: "GET", Path: "/capabilities", ReadOnly: true, RetrySafe: true},
+		{Method: "POST", Path: "/groups/{groupId}/expenses"},
+		{Method: "POST", Path: "/search", ReadOnly: true, RetrySafe: true},
+		{Method: "PUT", Path: "/groups/{groupId}/settings", RetrySafe: true},
+		{Method: "POST", Path: "/public/split-preview", RateLimit: &RateLimit{Limit: 60, WindowSeconds: 60, Key: "sourceIp"}},
+	}, capabilities.Routes)
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ClientVersionHeader is the version of the app sending the request, e.g. X-Client-Version:
+// 2.3.1, optionally prefixed by the platform, e.g. ios/2.3.1.
+const ClientVersionHeader = "X-Client-Version"
+
+// CapabilitiesHeader lists the response shapes the client understands besides those of its
+// version, e.g. X-Client-Capabilities: multi-payer, so a build can opt in before its release.
+const CapabilitiesHeader = "X-Client-Capabilities"
+
+// CapabilityMultiPayer is the expenses paid by several members, with their payers.
+const CapabilityMultiPayer = "multi-payer"
+
+// capabilityVersions is the client version from which each capability is assumed.
+var capabilityVersions = map[string]string{
+	CapabilityMultiPayer: "2.0.0",
+}
+
+// Client is what the client sending a request understands of the responses.
+type Client struct {
+	// Version is the major, minor and patch of the client version, nil when it sent none
+	// that parses
+	Version      []int
+	Capabilities map[string]bool
+}
+
+// parseVersion parses a version like 2.3.1, v2.3 or ios/2.3.1-beta, the missing parts being
+// zero.
+func parseVersion(value string) []int {
+	value = strings.TrimSpace(value)
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		value = value[i+1:]
+	}
+	value = strings.TrimPrefix(strings.ToLower(value), "v")
+	if i := strings.IndexAny(value, "-+ "); i >= 0 {
+		value = value[:i]
+	}
+	parts := strings.Split(value, ".")
+	if len(parts) > 3 {
+		return nil
+	}
+	version := make([]int, 3)
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil
+		}
+		version[i] = number
+	}
+	return version
+}
+
+// compareVersions compares two parsed versions like strings.Compare does.
+func compareVersions(a, b []int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// ClientOf parses the version and capabilities headers of the request.
+func ClientOf(request events.APIGatewayProxyRequest) Client {
+	client := Client{Version: parseVersion(common.Header(request, ClientVersionHeader)), Capabilities: map[string]bool{}}
+	for _, capability := range strings.Split(common.Header(request, CapabilitiesHeader), ",") {
+		if capability = strings.ToLower(strings.TrimSpace(capability)); capability != "" {
+			client.Capabilities[capability] = true
+		}
+	}
+	return client
+}
+
+// Has reports whether the client understands the capability: it listed it, or its version
+// has it. The clients sending neither header, e.g. the scripts calling the API, are assumed
+// to understand every response as it is.
+func (c Client) Has(capability string) bool {
+	if c.Capabilities[capability] {
+		return true
+	}
+	if c.Version == nil {
+		return len(c.Capabilities) == 0
+	}
+	since, ok := capabilityVersions[capability]
+	return !ok || compareVersions(c.Version, parseVersion(since)) >= 0
+}
+
+// Shim down-converts the JSON values of a response for the clients without its capability.
+// Down is called with every object of the body, nested ones included, and changes it in
+// place, returning a warning for the client when something couldn't be shown as it is.
+type Shim struct {
+	Capability string
+	Down       func(object map[string]interface{}) string
+}
+
+// Shims are the down-conversions of the responses, applied in order.
+var Shims = []Shim{
+	{Capability: CapabilityMultiPayer, Down: downMultiPayer},
+}
+
+// downMultiPayer drops the payers of an expense, leaving its paidBy, the first of them. The
+// expenses of several payers are shown as paid by the first one, with a warning.
+func downMultiPayer(object map[string]interface{}) string {
+	payers, ok := object["payers"].([]interface{})
+	if _, hasPaidBy := object["paidBy"]; !ok || !hasPaidBy {
+		return ""
+	}
+	delete(object, "payers")
+	if len(payers) > 1 {
+		return "Some expenses were paid by several members, update the app to see them"
+	}
+	return ""
+}
+
+// shimValue applies the shim to the objects of the value, collecting their warnings.
+func shimValue(value interface{}, shim Shim, warnings *[]string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if warning := shim.Down(value); warning != "" && !slices.Contains(*warnings, warning) {
+			*warnings = append(*warnings, warning)
+		}
+		for _, nested := range value {
+			shimValue(nested, shim, warnings)
+		}
+	case []interface{}:
+		for _, nested := range value {
+			shimValue(nested, shim, warnings)
+		}
+	}
+}
+
+// Compatible down-converts the JSON responses of the routes for the clients older than their
+// shape, with the Shims of the capabilities the client doesn't have, so a new model can be
+// shipped without breaking the apps not updated yet.
+func Compatible(route Route, next HandlerFunc) HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+		if err != nil || response.IsBase64Encoded || !strings.HasPrefix(response.Headers["Content-Type"], "application/json") {
+			return response, err
+		}
+		client := ClientOf(request)
+		var shims []Shim
+		for _, shim := range Shims {
+			if !client.Has(shim.Capability) {
+				shims = append(shims, shim)
+			}
+		}
+		if len(shims) == 0 {
+			return response, nil
+		}
+
+		decoder := json.NewDecoder(strings.NewReader(response.Body))
+		decoder.UseNumber()
+		var body interface{}
+		if err := decoder.Decode(&body); err != nil {
+			return response, nil
+		}
+		var warnings []string
+		for _, shim := range shims {
+			shimValue(body, shim, &warnings)
+		}
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return response, err
+		}
+
+		// The headers may be shared with a cached response, so they are copied first
+		response.Headers = maps.Clone(response.Headers)
+		response.MultiValueHeaders = maps.Clone(response.MultiValueHeaders)
+		for _, warning := range warnings {
+			common.AddWarning(&response, warning)
+		}
+		response.Body = string(payload)
+		return response, nil
+	}
+}
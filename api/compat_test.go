@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientOf(t *testing.T) {
+	client := func(headers map[string]string) Client {
+		return ClientOf(events.APIGatewayProxyRequest{Headers: headers})
+	}
+
+	assert.Equal(t, []int{2, 3, 1}, client(map[string]string{"x-client-version": "ios/2.3.1-beta"}).Version)
+	assert.Equal(t, []int{1, 4, 0}, client(map[string]string{ClientVersionHeader: "v1.4"}).Version)
+	assert.Nil(t, client(map[string]string{ClientVersionHeader: "latest"}).Version)
+
+	// The clients sending neither header have every capability, the others those of their
+	// version and those they listed
+	assert.True(t, client(nil).Has(CapabilityMultiPayer))
+	assert.False(t, client(map[string]string{ClientVersionHeader: "1.9.9"}).Has(CapabilityMultiPayer))
+	assert.True(t, client(map[string]string{ClientVersionHeader: "2.0.0"}).Has(CapabilityMultiPayer))
+	assert.True(t, client(map[string]string{ClientVersionHeader: "1.9.9", CapabilitiesHeader: "dark-mode, Multi-Payer"}).Has(CapabilityMultiPayer))
+	assert.False(t, client(map[string]string{CapabilitiesHeader: "dark-mode"}).Has(CapabilityMultiPayer))
+	assert.True(t, client(map[string]string{ClientVersionHeader: "1.0.0"}).Has("unknown"))
+}
+
+func TestCompatible(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("GET", "/expenses", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body: `[
+				{"expenseId":"expense-1","amount":30.10,"paidBy":"user-1","payers":[{"userId":"user-1","amount":"30.10"}]},
+				{"expenseId":"expense-2","amount":"50","paidBy":"user-1","payers":[{"userId":"user-1","amount":"20"},{"userId":"user-2","amount":"30"}]}
+			]`,
+		}, nil
+	})
+	router.Use(Enveloped)
+	router.Use(Compatible)
+
+	serve := func(headers map[string]string) events.APIGatewayProxyResponse {
+		response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/expenses", Headers: headers})
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The clients understanding several payers get the expenses as they are
+	response := serve(map[string]string{ClientVersionHeader: "2.1.0"})
+	var expenses []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expenses))
+	assert.Len(t, expenses, 2)
+	assert.Contains(t, expenses[1], "payers")
+	assert.Empty(t, response.MultiValueHeaders)
+
+	// The older ones only get the paidBy, with a warning for the expenses they can't show
+	response = serve(map[string]string{ClientVersionHeader: "1.8.0"})
+	assert.JSONEq(t, `[
+		{"expenseId":"expense-1","amount":30.10,"paidBy":"user-1"},
+		{"expenseId":"expense-2","amount":"50","paidBy":"user-1"}
+	]`, response.Body)
+	assert.Len(t, response.MultiValueHeaders["X-Warning"], 1)
+
+	// The warning of an enveloped response is in its metadata
+	response = serve(map[string]string{ClientVersionHeader: "1.8.0", EnvelopeHeader: "true"})
+	var envelope Envelope
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &envelope))
+	assert.NotContains(t, string(envelope.Data), "payers")
+	assert.Len(t, envelope.Meta.Warnings, 1)
+}
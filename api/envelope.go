@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"strconv"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// EnvelopeHeader opts a request into the response envelope, e.g. X-Response-Envelope: true.
+const EnvelopeHeader = "X-Response-Envelope"
+
+// VersionHeader is the version of the API the client speaks. The responses of version
+// EnvelopeVersion and above are always enveloped.
+const VersionHeader = "X-Api-Version"
+
+// EnvelopeVersion is the first API version whose responses are enveloped.
+const EnvelopeVersion = 2
+
+// Envelope is the body of the enveloped responses: the body of the handler as data, or as
+// error for the error responses, with the metadata the plain responses carry in headers.
+type Envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error json.RawMessage `json:"error,omitempty"`
+	Meta  Meta            `json:"meta"`
+}
+
+// Meta is the metadata of an enveloped response.
+type Meta struct {
+	RequestID  string      `json:"requestId,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+	Warnings   []string    `json:"warnings,omitempty"`
+}
+
+// Pagination is the position of a page in a paginated listing.
+type Pagination struct {
+	NextCursor string `json:"nextCursor"`
+}
+
+// WantsEnvelope reports whether the client asked for enveloped responses, with the envelope
+// header or the API version.
+func WantsEnvelope(request events.APIGatewayProxyRequest) bool {
+	switch strings.ToLower(strings.TrimSpace(common.Header(request, EnvelopeHeader))) {
+	case "true", "1":
+		return true
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(common.Header(request, VersionHeader)), "v"))
+	return err == nil && version >= EnvelopeVersion
+}
+
+// Enveloped wraps the JSON responses of the routes in an Envelope for the clients asking for
+// it. The next page cursor and warning headers move into the metadata; the other responses,
+// e.g. CSV exports, are left as they are.
+func Enveloped(route Route, next HandlerFunc) HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+		if err != nil || !WantsEnvelope(request) {
+			return response, err
+		}
+		if response.IsBase64Encoded || !strings.HasPrefix(response.Headers["Content-Type"], "application/json") {
+			return response, nil
+		}
+		body := json.RawMessage(response.Body)
+		if len(body) == 0 {
+			body = json.RawMessage("null")
+		} else if !json.Valid(body) {
+			return response, nil
+		}
+
+		// The headers may be shared with a cached response, so they are copied before the
+		// metadata is moved out of them
+		response.Headers = maps.Clone(response.Headers)
+		response.MultiValueHeaders = maps.Clone(response.MultiValueHeaders)
+
+		envelope := Envelope{Data: body, Meta: Meta{RequestID: request.RequestContext.RequestID}}
+		if response.StatusCode >= 400 {
+			envelope = Envelope{Data: json.RawMessage("null"), Error: body, Meta: envelope.Meta}
+		}
+		if cursor, ok := response.Headers[common.NextCursorHeader]; ok {
+			envelope.Meta.Pagination = &Pagination{NextCursor: cursor}
+			delete(response.Headers, common.NextCursorHeader)
+		}
+		if warnings, ok := response.MultiValueHeaders[common.WarningHeader]; ok {
+			envelope.Meta.Warnings = warnings
+			delete(response.MultiValueHeaders, common.WarningHeader)
+		}
+
+		payload, err := json.Marshal(envelope)
+		if err != nil {
+			return response, err
+		}
+		response.Body = string(payload)
+		return response, nil
+	}
+}
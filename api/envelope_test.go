@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnveloped(t *testing.T) {
+	shared := map[string]string{"Content-Type": "application/json", common.NextCursorHeader: "next"}
+	router := NewRouter()
+	router.AddRoute("GET", "/items", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response := events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: shared, Body: `[{"id":"1"}]`}
+		common.AddWarning(&response, "2 users not found")
+		return response, nil
+	})
+	router.AddRoute("GET", "/missing", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return common.CreateErrorResponse(http.StatusNotFound, "Not found")
+	})
+	router.AddRoute("GET", "/export", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: map[string]string{"Content-Type": "text/csv"}, Body: "a,b"}, nil
+	})
+	router.Use(Enveloped)
+
+	serve := func(path string, headers map[string]string) events.APIGatewayProxyResponse {
+		response, err := router.Serve(events.APIGatewayProxyRequest{
+			HTTPMethod:     "GET",
+			Path:           path,
+			Headers:        headers,
+			RequestContext: events.APIGatewayProxyRequestContext{RequestID: "request-1"},
+		})
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Without asking, the responses are plain
+	response := serve("/items", nil)
+	assert.Equal(t, `[{"id":"1"}]`, response.Body)
+	assert.Equal(t, "next", response.Headers[common.NextCursorHeader])
+
+	// The envelope holds the data and the metadata of the headers
+	for _, headers := range []map[string]string{{"x-response-envelope": "true"}, {VersionHeader: "2"}} {
+		response = serve("/items", headers)
+		assert.JSONEq(t, `{"data":[{"id":"1"}],"meta":{"requestId":"request-1","pagination":{"nextCursor":"next"},"warnings":["2 users not found"]}}`, response.Body)
+		assert.NotContains(t, response.Headers, common.NextCursorHeader)
+		assert.NotContains(t, response.MultiValueHeaders, common.WarningHeader)
+	}
+	assert.Equal(t, "next", shared[common.NextCursorHeader])
+
+	// Errors are enveloped too, the other bodies aren't
+	response = serve("/missing", map[string]string{EnvelopeHeader: "true"})
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.JSONEq(t, `{"data":null,"error":{"error":"Not found"},"meta":{"requestId":"request-1"}}`, response.Body)
+	response = serve("/export", map[string]string{EnvelopeHeader: "true"})
+	assert.Equal(t, "a,b", response.Body)
+	assert.False(t, WantsEnvelope(events.APIGatewayProxyRequest{Headers: map[string]string{VersionHeader: "1"}}))
+}
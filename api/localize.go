@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"vassistant-backend/common"
+	"vassistant-backend/i18n"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// localizeError translates the message of a coded error body, or of the error of an
+// enveloped one, reporting whether it was.
+func localizeError(body []byte, language string) ([]byte, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, false
+	}
+
+	// The error of an envelope is an object of its own
+	if nested, ok := fields["error"]; ok && len(nested) > 0 && nested[0] == '{' {
+		localized, ok := localizeError(nested, language)
+		if !ok {
+			return nil, false
+		}
+		fields["error"] = localized
+		payload, err := json.Marshal(fields)
+		return payload, err == nil
+	}
+
+	var errorResponse common.ErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Code == "" {
+		return nil, false
+	}
+	message, ok := i18n.Render(language, "error."+errorResponse.Code, nil)
+	if !ok || message == errorResponse.Error {
+		return nil, false
+	}
+	localized, err := json.Marshal(message)
+	if err != nil {
+		return nil, false
+	}
+	fields["error"] = localized
+	payload, err := json.Marshal(fields)
+	return payload, err == nil
+}
+
+// Localized translates the messages of the coded error responses into the language of the
+// request, from the messages of the i18n catalogs keyed by "error." and the code. The code
+// stays as it is for the clients to branch on, and the messages without a translation are
+// left as the handlers wrote them.
+func Localized(route Route, next HandlerFunc) HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+		if err != nil || response.StatusCode < 400 || response.IsBase64Encoded {
+			return response, err
+		}
+
+		language := i18n.Language(request)
+		body, ok := localizeError([]byte(response.Body), language)
+		if !ok {
+			return response, nil
+		}
+
+		// The headers may be shared with a cached response, so they are copied first
+		response.Headers = maps.Clone(response.Headers)
+		if response.Headers == nil {
+			response.Headers = map[string]string{}
+		}
+		response.Headers["Content-Language"] = language
+		response.Body = string(body)
+		return response, nil
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalized(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("GET", "/groups/(?P<groupId>[^/]+)", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return common.CreateErrorResponse(http.StatusNotFound, "Group not found")
+	})
+	router.AddRoute("GET", "/own", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return common.CreateErrorResponse(http.StatusBadRequest, "Amount must be positive")
+	})
+	router.Use(Localized)
+	router.Use(Enveloped)
+
+	serve := func(path string, headers map[string]string) events.APIGatewayProxyResponse {
+		response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: path, Headers: headers})
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The message is translated and the code kept
+	response := serve("/groups/group-1", map[string]string{"Accept-Language": "pt-BR"})
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, "pt-BR", response.Headers["Content-Language"])
+	assert.JSONEq(t, `{"error":"Grupo não encontrado","code":"group_not_found"}`, response.Body)
+
+	// In English, or for a message of its own, the response is left as it is
+	response = serve("/groups/group-1", map[string]string{"Accept-Language": "en"})
+	assert.JSONEq(t, `{"error":"Group not found","code":"group_not_found"}`, response.Body)
+	assert.Empty(t, response.Headers["Content-Language"])
+	response = serve("/own", map[string]string{"Accept-Language": "pt-BR"})
+	assert.JSONEq(t, `{"error":"Amount must be positive"}`, response.Body)
+
+	// The error of an envelope is translated too
+	response = serve("/groups/group-1", map[string]string{"Accept-Language": "pt-BR", EnvelopeHeader: "true"})
+	var envelope Envelope
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &envelope))
+	assert.JSONEq(t, `{"error":"Grupo não encontrado","code":"group_not_found"}`, string(envelope.Error))
+}
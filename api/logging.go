@@ -0,0 +1,24 @@
+package api
+
+import (
+	"log"
+	"regexp"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// secretSegments matches the path segments carrying a credential, the tokens of the guest
+// links, whatever the stage or base path before them.
+var secretSegments = regexp.MustCompile(`(/public/guest/)[^/]+`)
+
+// LoggedPath returns the path of a request as it can be logged, with the credentials in it
+// redacted.
+func LoggedPath(path string) string {
+	return secretSegments.ReplaceAllString(path, "${1}REDACTED")
+}
+
+// LogRequest logs the path and method of a request before it is routed.
+func LogRequest(request events.APIGatewayProxyRequest) {
+	log.Println("Request path:", LoggedPath(request.Path))
+	log.Println("Request HTTP method:", request.HTTPMethod)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRequest(t *testing.T) {
+	var logs bytes.Buffer
+	output := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(output)
+
+	// The guest link tokens never reach the logs, whatever the prefix of the path
+	LogRequest(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/public/guest/secret-token"})
+	LogRequest(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/prod/VassistantBackendProxy/public/guest/other-token/"})
+	assert.NotContains(t, logs.String(), "secret-token")
+	assert.NotContains(t, logs.String(), "other-token")
+	assert.Contains(t, logs.String(), "Request path: /public/guest/REDACTED\n")
+	assert.Contains(t, logs.String(), "Request path: /prod/VassistantBackendProxy/public/guest/REDACTED/\n")
+
+	// The other paths are logged as they are
+	logs.Reset()
+	LogRequest(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/financial/groups/group-1/expenses"})
+	assert.Contains(t, logs.String(), "Request path: /financial/groups/group-1/expenses\n")
+	assert.Contains(t, logs.String(), "Request HTTP method: POST\n")
+}
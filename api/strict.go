@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// StrictJSON rejects the request bodies of the route with fields the body type doesn't
+// have, e.g. a misspelled "catagory", with a 400 listing them instead of dropping them
+// silently. The body type is given by a value of it, e.g. StrictJSON(GroupSettings{}).
+func StrictJSON(body any) RouteOption {
+	return func(route *Route) {
+		route.StrictBody = reflect.TypeOf(body)
+	}
+}
+
+// strictJSON wraps the handler of a route opting into StrictJSON. The bodies that aren't
+// valid JSON are left for the handler to reject.
+func strictJSON(bodyType reflect.Type, next HandlerFunc) HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if strings.TrimSpace(request.Body) == "" {
+			return next(request)
+		}
+		var body any
+		decoder := json.NewDecoder(strings.NewReader(request.Body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			return next(request)
+		}
+		if unknown := UnknownFields(body, bodyType); len(unknown) > 0 {
+			return common.CreateErrorResponse(400, "Unknown fields in request body: "+strings.Join(unknown, ", "))
+		}
+		return next(request)
+	}
+}
+
+// jsonUnmarshaler is implemented by the types decoding their JSON themselves, whose fields
+// can't be checked.
+var jsonUnmarshaler = reflect.TypeFor[json.Unmarshaler]()
+
+// UnknownFields returns the paths of the fields of the decoded JSON value that the type
+// doesn't have, e.g. participants[0].shaer, sorted. Like encoding/json, the names of the
+// fields are matched case-insensitively.
+func UnknownFields(value any, t reflect.Type) []string {
+	var unknown []string
+	collectUnknownFields(value, t, "", &unknown)
+	slices.Sort(unknown)
+	return unknown
+}
+
+func collectUnknownFields(value any, t reflect.Type, path string, unknown *[]string) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		for name, fieldValue := range object {
+			field, ok := fields[name]
+			if !ok {
+				for fieldName, candidate := range fields {
+					if strings.EqualFold(fieldName, name) {
+						field, ok = candidate, true
+						break
+					}
+				}
+			}
+			if !ok {
+				*unknown = append(*unknown, joinPath(path, name))
+				continue
+			}
+			collectUnknownFields(fieldValue, field, joinPath(path, name), unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]any)
+		if !ok {
+			return
+		}
+		for i, item := range items {
+			collectUnknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+		for key, item := range object {
+			collectUnknownFields(item, t.Elem(), joinPath(path, key), unknown)
+		}
+	}
+}
+
+// jsonFields maps the JSON names of the fields of a struct type to their types, including
+// those promoted from its embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for _, field := range reflect.VisibleFields(t) {
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" || (field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
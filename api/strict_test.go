@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type strictParticipant struct {
+	UserID string      `json:"userId"`
+	Share  json.Number `json:"share,omitempty"`
+}
+
+type strictBase struct {
+	Title string `json:"title"`
+}
+
+type strictExpense struct {
+	strictBase
+	Category     string              `json:"category"`
+	Participants []strictParticipant `json:"participants"`
+	Labels       map[string]strictBase
+	Extra        json.RawMessage `json:"extra"`
+	Internal     string          `json:"-"`
+}
+
+func TestUnknownFields(t *testing.T) {
+	decode := func(body string) any {
+		var value any
+		assert.NoError(t, json.Unmarshal([]byte(body), &value))
+		return value
+	}
+	expenseType := reflect.TypeFor[strictExpense]()
+
+	// The known fields, promoted or matched case-insensitively, are accepted
+	assert.Empty(t, UnknownFields(decode(`{"title": "Taxi", "Category": "FOOD", "participants": [{"userId": "u1"}], "Labels": {"en": {"title": "x"}}, "extra": {"any": 1}}`), expenseType))
+
+	// The unknown fields are listed with their paths, in order
+	assert.Equal(t, []string{"Internal", "Labels.en.name", "catagory", "participants[1].shaer"},
+		UnknownFields(decode(`{"catagory": "FOOD", "Internal": "x", "participants": [{"userId": "u1"}, {"shaer": 2}], "Labels": {"en": {"name": "x"}}}`), expenseType))
+}
+
+func TestStrictJSON(t *testing.T) {
+	router := NewRouter()
+	router.AddRoute("POST", "/expenses", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusCreated}, nil
+	}, StrictJSON(strictExpense{}))
+
+	serve := func(body string) events.APIGatewayProxyResponse {
+		response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/expenses", Body: body})
+		assert.NoError(t, err)
+		return response
+	}
+
+	assert.Equal(t, http.StatusCreated, serve(`{"title": "Taxi"}`).StatusCode)
+
+	response := serve(`{"title": "Taxi", "catagory": "FOOD"}`)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Contains(t, response.Body, "Unknown fields in request body: catagory")
+
+	// The invalid bodies are left for the handler
+	assert.Equal(t, http.StatusCreated, serve(`{"title": `).StatusCode)
+}
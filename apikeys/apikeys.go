@@ -0,0 +1,318 @@
+// Package apikeys lets the users create API keys for the automations that can't sign in
+// with Cognito, like iOS Shortcuts or Tasker, and authenticates the requests carrying them.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"vassistant-backend/api"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+var DynamoDbClient common.DynamoDBAPI
+
+// keysTable holds the API keys, keyed by keyId, with the userId-index to list the keys of
+// a user.
+const keysTable = "api-keys"
+
+// Header is the header carrying the API key, unless it is sent as a bearer token.
+const Header = "X-Api-Key"
+
+// maxKeysPerUser bounds how many API keys a user can have.
+const maxKeysPerUser = 10
+
+// maxKeyNameLength bounds the name telling the keys of a user apart.
+const maxKeyNameLength = 100
+
+// lastUsedResolution is how stale the last use of a key may get, so it isn't written on
+// every request.
+const lastUsedResolution = time.Hour
+
+// APIKey struct for the api-keys table. Only the hash of the secret of the key is stored,
+// the key is returned once when it is created.
+type APIKey struct {
+	KeyID      string `json:"keyId" dynamodbav:"keyId"`
+	UserID     string `json:"-" dynamodbav:"userId"`
+	Username   string `json:"-" dynamodbav:"username"`
+	Name       string `json:"name" dynamodbav:"name"`
+	CreatedAt  string `json:"createdAt" dynamodbav:"createdAt"`
+	LastUsedAt string `json:"lastUsedAt,omitempty" dynamodbav:"lastUsedAt,omitempty"`
+	SecretHash string `json:"-" dynamodbav:"secretHash"`
+}
+
+// CreatedAPIKey is the response of the key creation, the only one holding the key.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyRequest struct for the create API key request body
+type APIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// hashSecret returns the hash of the secret of a key stored in the table.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newKey generates a new key: its ID and a random secret.
+func newKey(keyId string) (key, secret string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(random)
+	return keyId + "." + secret, secret, nil
+}
+
+func getKey(ctx context.Context, keyId string) (*APIKey, error) {
+	result, err := DynamoDbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(keysTable),
+		Key: map[string]types.AttributeValue{
+			"keyId": &types.AttributeValueMemberS{Value: keyId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var key APIKey
+	err = attributevalue.UnmarshalMap(result.Item, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func listKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	result, err := DynamoDbClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(keysTable),
+		IndexName:              aws.String("userId-index"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := []APIKey{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Authenticate returns the key matching the API key, or nil if there is none.
+func Authenticate(ctx context.Context, apiKey string) (*APIKey, error) {
+	keyId, secret, ok := strings.Cut(apiKey, ".")
+	if !ok || keyId == "" || secret == "" {
+		return nil, nil
+	}
+
+	key, err := getKey(ctx, keyId)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, nil
+	}
+	return key, nil
+}
+
+// requestKey returns the API key of the request, from the API key header or the bearer token.
+func requestKey(request events.APIGatewayProxyRequest) string {
+	if key := common.Header(request, Header); key != "" {
+		return strings.TrimSpace(key)
+	}
+	token, ok := strings.CutPrefix(common.Header(request, "Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Authenticated serves the routes reached without the Cognito authorizer to the requests
+// with a valid API key, handing the handler the claims of the owner of the key like the
+// authorizer would. The others are rejected with 401.
+func Authenticated(next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		ctx := context.TODO()
+		apiKey := requestKey(request)
+		if apiKey == "" {
+			return common.CreateCodedErrorResponse(401, "missing_api_key", "Unauthorized: Missing API key")
+		}
+		key, err := Authenticate(ctx, apiKey)
+		if err != nil {
+			log.Printf("Error getting API key from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if key == nil {
+			return common.CreateCodedErrorResponse(401, "invalid_api_key", "Unauthorized: Invalid API key")
+		}
+
+		// The last use is informational, so failing to record it doesn't fail the request
+		lastUsedAt, _ := time.Parse(time.RFC3339, key.LastUsedAt)
+		if time.Since(lastUsedAt) > lastUsedResolution {
+			key.LastUsedAt = time.Now().UTC().Format(time.RFC3339)
+			err := common.ConditionalPutItem(ctx, DynamoDbClient, keysTable, key, common.IfExists("keyId"))
+			if err != nil {
+				log.Printf("Error recording the use of API key %s: %v", key.KeyID, err)
+			}
+		}
+
+		request.RequestContext.Authorizer = map[string]interface{}{
+			"claims": map[string]interface{}{"sub": key.UserID, "cognito:username": key.Username},
+		}
+		return next(request)
+	}
+}
+
+func GetAPIKeysHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	keys, err := listKeys(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error querying API keys from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return jsonResponse(200, keys)
+}
+
+func PostAPIKeyHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Parse the request body into an APIKeyRequest struct
+	var keyRequest APIKeyRequest
+	err = json.Unmarshal([]byte(request.Body), &keyRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	name := strings.TrimSpace(keyRequest.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxKeyNameLength {
+		return common.CreateErrorResponse(400, "Invalid API key name")
+	}
+
+	keys, err := listKeys(ctx, claims.Sub)
+	if err != nil {
+		log.Printf("Error querying API keys from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if len(keys) >= maxKeysPerUser {
+		return common.CreateErrorResponse(409, "Too many API keys, delete one first")
+	}
+
+	keyId := uuid.New().String()
+	apiKey, secret, err := newKey(keyId)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	key := APIKey{
+		KeyID:      keyId,
+		UserID:     claims.Sub,
+		Username:   claims.Username,
+		Name:       name,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+		SecretHash: hashSecret(secret),
+	}
+	err = common.ConditionalPutItem(ctx, DynamoDbClient, keysTable, key, common.IfNotExists("keyId"))
+	if err != nil {
+		log.Printf("Error putting API key into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Successfully created API key %s for user %s", keyId, claims.Sub)
+	return jsonResponse(201, CreatedAPIKey{APIKey: key, Key: apiKey})
+}
+
+func DeleteAPIKeyHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract keyId from path parameters
+	keyId := request.PathParameters["keyId"]
+	if keyId == "" {
+		return common.CreateErrorResponse(400, "Key ID is missing")
+	}
+
+	// Only the owner of a key can delete it
+	key, err := getKey(ctx, keyId)
+	if err != nil {
+		log.Printf("Error getting API key from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if key == nil || key.UserID != claims.Sub {
+		return common.CreateErrorResponse(404, "API key not found")
+	}
+
+	_, err = DynamoDbClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(keysTable),
+		Key: map[string]types.AttributeValue{
+			"keyId": &types.AttributeValueMemberS{Value: keyId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting API key from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+func jsonResponse(statusCode int, value interface{}) (events.APIGatewayProxyResponse, error) {
+	// Marshal the value into JSON for the payload
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshalling response:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,79 @@
+package apikeys
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/auth"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeys(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	// The key is only returned when it is created
+	response, err := PostAPIKeyHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithJSONBody(t, APIKeyRequest{Name: "Shortcuts"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var created CreatedAPIKey
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &created))
+	assert.Equal(t, "Shortcuts", created.Name)
+	assert.NotEmpty(t, created.Key)
+
+	response, err = GetAPIKeysHandler(testutil.NewRequest("GET", "").WithClaims("user-1", "alice").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.NotContains(t, response.Body, created.Key)
+	assert.Contains(t, response.Body, created.KeyID)
+
+	// The requests with the key reach the handler with the claims of its owner
+	var claims auth.Claims
+	handler := Authenticated(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		claims, err = auth.ParseClaims(request)
+		assert.NoError(t, err)
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	})
+	response, err = handler(testutil.NewRequest("GET", "").WithHeader("x-api-key", created.Key).Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, auth.Claims{Sub: "user-1", Username: "alice"}, claims)
+
+	response, err = handler(testutil.NewRequest("GET", "").WithHeader("Authorization", "Bearer "+created.Key).Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Missing and wrong keys are rejected
+	response, err = handler(testutil.NewRequest("GET", "").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, err = handler(testutil.NewRequest("GET", "").WithHeader(Header, created.KeyID+".wrong").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Only the owner can delete the key, which stops working
+	response, err = DeleteAPIKeyHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("keyId", created.KeyID).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response, err = DeleteAPIKeyHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("keyId", created.KeyID).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	response, err = handler(testutil.NewRequest("GET", "").WithHeader(Header, created.Key).Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Claims are the validated Cognito claims passed by the API Gateway authorizer.
+type Claims struct {
+	Sub      string
+	Username string
+	Email    string
+	Locale   string
+	Groups   []string
+	AuthTime time.Time // when the user signed in, zero when the token doesn't say
+}
+
+// ClaimsError describes why the authorizer claims of a request were rejected.
+type ClaimsError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *ClaimsError) Error() string {
+	return e.Message
+}
+
+// ParseClaims extracts and validates the Cognito claims of the request.
+// A request without claims is rejected with 401, claims of the wrong type with 403.
+func ParseClaims(request events.APIGatewayProxyRequest) (Claims, error) {
+	rawClaims, ok := request.RequestContext.Authorizer["claims"]
+	if !ok || rawClaims == nil {
+		return Claims{}, &ClaimsError{StatusCode: 401, Code: "missing_claims", Message: "Unauthorized: Missing claims"}
+	}
+	claimsMap, ok := rawClaims.(map[string]interface{})
+	if !ok {
+		return Claims{}, &ClaimsError{StatusCode: 403, Code: "invalid_claims", Message: "Unauthorized: Invalid claims format"}
+	}
+
+	var claims Claims
+	var err error
+
+	if claims.Sub, err = stringClaim(claimsMap, "sub"); err != nil {
+		return Claims{}, err
+	}
+	if claims.Sub == "" {
+		return Claims{}, &ClaimsError{StatusCode: 401, Code: "missing_claim", Message: "Unauthorized: Missing claim sub"}
+	}
+
+	// ID tokens carry cognito:username, access tokens carry username
+	if claims.Username, err = stringClaim(claimsMap, "cognito:username"); err != nil {
+		return Claims{}, err
+	}
+	if claims.Username == "" {
+		if claims.Username, err = stringClaim(claimsMap, "username"); err != nil {
+			return Claims{}, err
+		}
+	}
+
+	if claims.Email, err = stringClaim(claimsMap, "email"); err != nil {
+		return Claims{}, err
+	}
+	if claims.Locale, err = stringClaim(claimsMap, "locale"); err != nil {
+		return Claims{}, err
+	}
+	if claims.Groups, err = groupsClaim(claimsMap); err != nil {
+		return Claims{}, err
+	}
+	if claims.AuthTime, err = timeClaim(claimsMap, "auth_time"); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+// ErrorResponse converts an error returned by ParseClaims into an API response.
+func ErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	log.Printf("Error: rejected authorizer claims: %v", err)
+	var claimsErr *ClaimsError
+	if errors.As(err, &claimsErr) {
+		return common.CreateCodedErrorResponse(claimsErr.StatusCode, claimsErr.Code, claimsErr.Message)
+	}
+	return common.CreateErrorResponse(403, "Unauthorized: Invalid claims format")
+}
+
+// stringClaim returns the optional string claim, rejecting values of other types.
+func stringClaim(claims map[string]interface{}, name string) (string, error) {
+	value, ok := claims[name]
+	if !ok || value == nil {
+		return "", nil
+	}
+	stringValue, ok := value.(string)
+	if !ok {
+		return "", invalidClaim(name)
+	}
+	return stringValue, nil
+}
+
+// timeClaim returns the optional time claim in Unix seconds, which REST APIs pass as a
+// string and other integrations as a number.
+func timeClaim(claims map[string]interface{}, name string) (time.Time, error) {
+	switch value := claims[name].(type) {
+	case nil:
+		return time.Time{}, nil
+	case string:
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, invalidClaim(name)
+		}
+		return time.Unix(seconds, 0), nil
+	case float64:
+		return time.Unix(int64(value), 0), nil
+	default:
+		return time.Time{}, invalidClaim(name)
+	}
+}
+
+// groupsClaim parses cognito:groups, which REST APIs pass as a string like
+// "[admin users]" or "admin,users" and other integrations as a list.
+func groupsClaim(claims map[string]interface{}) ([]string, error) {
+	switch value := claims["cognito:groups"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		value = strings.Trim(value, "[]")
+		return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }), nil
+	case []interface{}:
+		groups := make([]string, 0, len(value))
+		for _, group := range value {
+			groupName, ok := group.(string)
+			if !ok {
+				return nil, invalidClaim("cognito:groups")
+			}
+			groups = append(groups, groupName)
+		}
+		return groups, nil
+	case []string:
+		return value, nil
+	default:
+		return nil, invalidClaim("cognito:groups")
+	}
+}
+
+func invalidClaim(name string) error {
+	return &ClaimsError{StatusCode: 403, Code: "invalid_claim", Message: fmt.Sprintf("Unauthorized: Invalid claim %s", name)}
+}
+
+// HasGroup reports whether the user belongs to the Cognito group.
+func (c Claims) HasGroup(group string) bool {
+	return slices.Contains(c.Groups, group)
+}
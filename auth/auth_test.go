@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithAuthorizer(authorizer map[string]interface{}) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{Authorizer: authorizer},
+	}
+}
+
+func TestParseClaims(t *testing.T) {
+	request := requestWithAuthorizer(map[string]interface{}{
+		"claims": map[string]interface{}{
+			"sub":              "test-user-id",
+			"cognito:username": "test-user",
+			"email":            "test@example.com",
+			"cognito:groups":   "[admin users]",
+		},
+	})
+
+	claims, err := ParseClaims(request)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-user-id", claims.Sub)
+	assert.Equal(t, "test-user", claims.Username)
+	assert.Equal(t, "test@example.com", claims.Email)
+	assert.Equal(t, []string{"admin", "users"}, claims.Groups)
+	assert.True(t, claims.HasGroup("admin"))
+	assert.False(t, claims.HasGroup("owners"))
+}
+
+func TestParseClaimsGroupFormats(t *testing.T) {
+	for _, groups := range []interface{}{"admin,users", "admin, users", []interface{}{"admin", "users"}} {
+		request := requestWithAuthorizer(map[string]interface{}{
+			"claims": map[string]interface{}{"sub": "test-user-id", "cognito:groups": groups},
+		})
+
+		claims, err := ParseClaims(request)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"admin", "users"}, claims.Groups)
+	}
+}
+
+func TestParseClaimsAccessTokenUsername(t *testing.T) {
+	request := requestWithAuthorizer(map[string]interface{}{
+		"claims": map[string]interface{}{"sub": "test-user-id", "username": "test-user"},
+	})
+
+	claims, err := ParseClaims(request)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-user", claims.Username)
+}
+
+func TestParseClaimsAuthTime(t *testing.T) {
+	for _, authTime := range []interface{}{"1700000000", float64(1700000000)} {
+		request := requestWithAuthorizer(map[string]interface{}{
+			"claims": map[string]interface{}{"sub": "test-user-id", "auth_time": authTime},
+		})
+
+		claims, err := ParseClaims(request)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1700000000), claims.AuthTime.Unix())
+	}
+}
+
+func TestParseClaimsRejectsInvalidClaims(t *testing.T) {
+	tests := []struct {
+		name       string
+		authorizer map[string]interface{}
+		statusCode int
+		code       string
+	}{
+		{"no authorizer", nil, http.StatusUnauthorized, "missing_claims"},
+		{"claims of the wrong type", map[string]interface{}{"claims": "sub=test-user-id"}, http.StatusForbidden, "invalid_claims"},
+		{"missing sub", map[string]interface{}{"claims": map[string]interface{}{"cognito:username": "test-user"}}, http.StatusUnauthorized, "missing_claim"},
+		{"empty sub", map[string]interface{}{"claims": map[string]interface{}{"sub": ""}}, http.StatusUnauthorized, "missing_claim"},
+		{"sub of the wrong type", map[string]interface{}{"claims": map[string]interface{}{"sub": 42}}, http.StatusForbidden, "invalid_claim"},
+		{"email of the wrong type", map[string]interface{}{"claims": map[string]interface{}{"sub": "test-user-id", "email": true}}, http.StatusForbidden, "invalid_claim"},
+		{"auth time of the wrong type", map[string]interface{}{"claims": map[string]interface{}{"sub": "test-user-id", "auth_time": "yesterday"}}, http.StatusForbidden, "invalid_claim"},
+		{"groups of the wrong type", map[string]interface{}{"claims": map[string]interface{}{"sub": "test-user-id", "cognito:groups": []interface{}{1}}}, http.StatusForbidden, "invalid_claim"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseClaims(requestWithAuthorizer(test.authorizer))
+			assert.Error(t, err)
+
+			// Check the structured error response
+			response, err := ErrorResponse(err)
+			assert.NoError(t, err)
+			assert.Equal(t, test.statusCode, response.StatusCode)
+
+			var body common.ErrorResponse
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &body))
+			assert.Equal(t, test.code, body.Code)
+			assert.NotEmpty(t, body.Error)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Package cache provides an opt-in response cache for read-heavy, rarely-changing routes
+// whose responses only depend on the language of the caller, like the expense categories.
+package cache
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/i18n"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	StatusCode int               `dynamodbav:"statusCode"`
+	Headers    map[string]string `dynamodbav:"headers"`
+	Body       string            `dynamodbav:"body"`
+	ExpiresAt  int64             `dynamodbav:"expiresAt"` // Unix seconds, also the DynamoDB TTL attribute
+}
+
+// Store keeps cached responses by key.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry Entry) error
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryStore is a Store kept in the memory of the Lambda instance. Every instance has its
+// own copy, so invalidations only reach the instance serving the write; use short TTLs or
+// a DynamoDBStore for data that must be invalidated everywhere.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]Entry{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().Unix() >= entry.ExpiresAt {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = entry
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// Key returns the cache key of a request: its method, path and sorted query parameters,
+// and the language of the response since labels are localized.
+func Key(request events.APIGatewayProxyRequest) string {
+	return request.HTTPMethod + " " + request.Path + queryString(request.QueryStringParameters) + " " + i18n.Language(request)
+}
+
+func queryString(params map[string]string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var query strings.Builder
+	for i, name := range names {
+		if i == 0 {
+			query.WriteString("?")
+		} else {
+			query.WriteString("&")
+		}
+		query.WriteString(url.QueryEscape(name) + "=" + url.QueryEscape(params[name]))
+	}
+	return query.String()
+}
+
+// Cached serves the handler responses from the store for ttl. Only successful responses
+// are cached, and store failures fall back to calling the handler. The X-Cache response
+// header tells whether the response was a HIT or a MISS.
+func Cached(store Store, ttl time.Duration, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		key := Key(request)
+
+		entry, err := store.Get(context.TODO(), key)
+		if err != nil {
+			log.Printf("Error reading cached response %s: %v", key, err)
+		}
+		if entry != nil {
+			return withCacheHeader(entry.StatusCode, entry.Headers, entry.Body, "HIT"), nil
+		}
+
+		response, err := next(request)
+		if err != nil || response.StatusCode != 200 {
+			return response, err
+		}
+
+		err = store.Set(context.TODO(), key, Entry{
+			StatusCode: response.StatusCode,
+			Headers:    response.Headers,
+			Body:       response.Body,
+			ExpiresAt:  time.Now().Add(ttl).Unix(),
+		})
+		if err != nil {
+			log.Printf("Error caching response %s: %v", key, err)
+		}
+		return withCacheHeader(response.StatusCode, response.Headers, response.Body, "MISS"), nil
+	}
+}
+
+// Invalidates drops the cached GET responses of the paths, in every language, once the
+// handler succeeds. It wraps the endpoints changing the data served by cached routes.
+func Invalidates(store Store, paths []string, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+		if err != nil || response.StatusCode < 200 || response.StatusCode >= 300 {
+			return response, err
+		}
+
+		for _, path := range paths {
+			for _, language := range i18n.Languages() {
+				key := Key(events.APIGatewayProxyRequest{
+					HTTPMethod: "GET",
+					Path:       path,
+					Headers:    map[string]string{"Accept-Language": language},
+				})
+				if err := store.Delete(context.TODO(), key); err != nil {
+					log.Printf("Error invalidating cached response %s: %v", key, err)
+				}
+			}
+		}
+		return response, nil
+	}
+}
+
+// withCacheHeader builds a response with a copy of the headers and the X-Cache header set.
+func withCacheHeader(statusCode int, headers map[string]string, body, status string) events.APIGatewayProxyResponse {
+	responseHeaders := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		responseHeaders[name] = value
+	}
+	responseHeaders["X-Cache"] = status
+	return events.APIGatewayProxyResponse{StatusCode: statusCode, Headers: responseHeaders, Body: body}
+}
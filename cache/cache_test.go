@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler returns a handler answering with the body, counting its calls.
+func countingHandler(calls *int, statusCode int) func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		*calls++
+		return events.APIGatewayProxyResponse{
+			StatusCode: statusCode,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `["FOOD"]`,
+		}, nil
+	}
+}
+
+func TestCached(t *testing.T) {
+	stores := map[string]Store{"memory": NewMemoryStore()}
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	stores["dynamodb"] = NewDynamoDBStore(fake, "response-cache")
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			handler := Cached(store, time.Hour, countingHandler(&calls, 200))
+			request := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/categories"}
+
+			// The first call is a miss, the second is served from the store
+			response, err := handler(request)
+			assert.NoError(t, err)
+			assert.Equal(t, "MISS", response.Headers["X-Cache"])
+			response, err = handler(request)
+			assert.NoError(t, err)
+			assert.Equal(t, "HIT", response.Headers["X-Cache"])
+			assert.Equal(t, `["FOOD"]`, response.Body)
+			assert.Equal(t, "application/json", response.Headers["Content-Type"])
+			assert.Equal(t, 1, calls)
+
+			// Other query parameters are cached separately
+			_, err = handler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/categories", QueryStringParameters: map[string]string{"lang": "pt"}})
+			assert.NoError(t, err)
+			assert.Equal(t, 2, calls)
+
+			// A successful write invalidates the cached path
+			write := Invalidates(store, []string{"/categories"}, countingHandler(new(int), 201))
+			_, err = write(events.APIGatewayProxyRequest{HTTPMethod: "POST", Path: "/categories"})
+			assert.NoError(t, err)
+			response, err = handler(request)
+			assert.NoError(t, err)
+			assert.Equal(t, "MISS", response.Headers["X-Cache"])
+			assert.Equal(t, 3, calls)
+		})
+	}
+}
+
+func TestCachedSkipsErrors(t *testing.T) {
+	calls := 0
+	handler := Cached(NewMemoryStore(), time.Hour, countingHandler(&calls, 500))
+	request := events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/categories"}
+
+	_, _ = handler(request)
+	_, _ = handler(request)
+	assert.Equal(t, 2, calls)
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	err := store.Set(context.TODO(), "key", Entry{StatusCode: 200, ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	assert.NoError(t, err)
+
+	entry, err := store.Get(context.TODO(), "key")
+	assert.NoError(t, err)
+	assert.Nil(t, entry)
+}
+
+func TestKey(t *testing.T) {
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:            "GET",
+		Path:                  "/categories",
+		QueryStringParameters: map[string]string{"b": "2", "a": "1 2"},
+	}
+	assert.Equal(t, "GET /categories?a=1+2&b=2 en", Key(request))
+
+	// Responses are cached per language
+	request.Headers = map[string]string{"Accept-Language": "pt-BR,pt;q=0.9"}
+	assert.Equal(t, "GET /categories?a=1+2&b=2 pt-BR", Key(request))
+}
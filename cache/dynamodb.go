@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBStore is a Store shared by all the Lambda instances, kept in a table keyed by
+// cacheKey with expiresAt as its TTL attribute.
+type DynamoDBStore struct {
+	Client    common.DynamoDBAPI
+	TableName string
+}
+
+// dynamoDBEntry is the item stored for an Entry.
+type dynamoDBEntry struct {
+	CacheKey string `dynamodbav:"cacheKey"`
+	Entry
+}
+
+// NewDynamoDBStore creates a DynamoDBStore on the table.
+func NewDynamoDBStore(client common.DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{Client: client, TableName: tableName}
+}
+
+func (s *DynamoDBStore) key(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"cacheKey": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+func (s *DynamoDBStore) Get(ctx context.Context, key string) (*Entry, error) {
+	result, err := s.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.TableName),
+		Key:       s.key(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var item dynamoDBEntry
+	err = attributevalue.UnmarshalMap(result.Item, &item)
+	if err != nil {
+		return nil, err
+	}
+	// DynamoDB deletes expired items lazily
+	if time.Now().Unix() >= item.ExpiresAt {
+		return nil, nil
+	}
+	return &item.Entry, nil
+}
+
+func (s *DynamoDBStore) Set(ctx context.Context, key string, entry Entry) error {
+	av, err := attributevalue.MarshalMap(dynamoDBEntry{CacheKey: key, Entry: entry})
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.TableName),
+		Item:      av,
+	})
+	return err
+}
+
+func (s *DynamoDBStore) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.TableName),
+		Key:       s.key(key),
+	})
+	return err
+}
@@ -0,0 +1,349 @@
+// Command api is the Lambda behind the REST API, serving every route of the routes package.
+// The other workloads have their own commands, streams, jobs, replies, scheduler, websocket
+// and cognito, so their dependencies and permissions don't weigh on it.
+package main
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the time zones of the groups, which the Lambda runtime lacks
+	"vassistant-backend/admin"
+	"vassistant-backend/analytics"
+	"vassistant-backend/api"
+	"vassistant-backend/apikeys"
+	"vassistant-backend/cache"
+	"vassistant-backend/common"
+	"vassistant-backend/devices"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/encryption"
+	"vassistant-backend/faults"
+	"vassistant-backend/financial"
+	"vassistant-backend/fx"
+	"vassistant-backend/integrations/sheets"
+	"vassistant-backend/integrations/verify"
+	"vassistant-backend/llm"
+	"vassistant-backend/messages"
+	"vassistant-backend/metrics"
+	"vassistant-backend/notifications"
+	"vassistant-backend/offload"
+	"vassistant-backend/openapi"
+	"vassistant-backend/plans"
+	"vassistant-backend/providers"
+	"vassistant-backend/queue"
+	"vassistant-backend/ratelimit"
+	"vassistant-backend/realtime"
+	"vassistant-backend/referrals"
+	"vassistant-backend/residency"
+	"vassistant-backend/routes"
+	"vassistant-backend/shadow"
+	"vassistant-backend/status"
+	"vassistant-backend/tools"
+	"vassistant-backend/uploads"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var router *api.Router
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// Allow overriding how far percentage shares may add up from 100
+	if value, ok := os.LookupEnv("PERCENTAGE_SPLIT_EPSILON"); ok {
+		epsilon, ok := new(big.Rat).SetString(value)
+		if !ok || epsilon.Sign() < 0 {
+			log.Fatalf("invalid PERCENTAGE_SPLIT_EPSILON %q", value)
+		}
+		financial.PercentageEpsilon = epsilon
+	}
+
+	// Allow overriding how long the deleted groups can be restored, which the purge-groups
+	// job of the scheduler must be configured with too
+	if value, ok := os.LookupEnv("DELETION_RETENTION_DAYS"); ok {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			log.Fatalf("invalid DELETION_RETENTION_DAYS %q", value)
+		}
+		financial.DeletionRetention = time.Duration(days) * 24 * time.Hour
+	}
+
+	// Limit how large the groups can grow, every resource being unlimited by default, e.g.
+	// GROUP_QUOTAS={"membersPerGroup": 50, "expensesPerGroup": 20000, "attachmentsPerExpense": 2}
+	if spec := os.Getenv("GROUP_QUOTAS"); spec != "" {
+		quotas, err := financial.ParseQuotas(spec)
+		if err != nil {
+			log.Fatalf("invalid GROUP_QUOTAS, %v", err)
+		}
+		financial.GroupQuotas = quotas
+	}
+
+	// Create DynamoDB client, instrumented to track the calls made per request
+	faultConfig, err := faults.FromEnv()
+	if err != nil {
+		log.Fatalf("invalid FAULT_INJECTION, %v", err)
+	}
+	var rawDynamoDbClient common.DynamoDBAPI = dynamodb.NewFromConfig(cfg)
+	if faultConfig != nil {
+		// Inject DynamoDB faults outside of production, when enabled
+		rawDynamoDbClient = faults.NewPartialBatches(dynamodb.NewFromConfig(cfg, faultConfig.Options()), faultConfig)
+	}
+
+	// Keep the data of the groups tagged with a region in the tables of that region, when
+	// DATA_RESIDENCY_REGIONS lists the regions offered
+	rawDynamoDbClient = residency.FromEnv(cfg, rawDynamoDbClient)
+	if router, ok := rawDynamoDbClient.(*residency.RoutingDynamoDB); ok {
+		residency.Default = router
+	}
+	dynamoDbClient := metrics.NewInstrumentedDynamoDB(rawDynamoDbClient)
+
+	// Encrypt the messages, memories and expense notes at rest with per-owner data keys, when
+	// a KMS key is configured
+	encryptingDynamoDbClient, err := encryption.FromEnv(cfg, dynamoDbClient)
+	if err != nil {
+		log.Fatalf("invalid encryption configuration, %v", err)
+	}
+	financialHandlers := financial.NewHandlers(encryptingDynamoDbClient)
+	messagesHandlers := messages.NewHandlers(encryptingDynamoDbClient, financialHandlers)
+	plansHandlers := plans.NewHandlers(encryptingDynamoDbClient)
+	devicesHandlers := devices.NewHandlers(encryptingDynamoDbClient)
+	referralsHandlers := referrals.NewHandlers(dynamoDbClient)
+	adminHandlers := admin.NewHandlers(encryptingDynamoDbClient) // the inspections breaking the glass show the content decrypted
+	fx.DynamoDbClient = dynamoDbClient
+	notifications.DynamoDbClient = dynamoDbClient
+	tools.DynamoDbClient = dynamoDbClient
+	realtime.DynamoDbClient = dynamoDbClient
+	status.DynamoDbClient = dynamoDbClient
+	apikeys.DynamoDbClient = dynamoDbClient
+
+	// Broadcast the new messages and expenses to the connected clients, when a WebSocket API
+	// is configured
+	if endpoint := os.Getenv("WEBSOCKET_ENDPOINT"); endpoint != "" {
+		realtime.Default = realtime.NewBroadcaster(cfg, endpoint)
+	}
+
+	// Export the group expenses to the linked Google Sheets when the OAuth credentials of the
+	// app are configured
+	if clientId := os.Getenv("GOOGLE_CLIENT_ID"); clientId != "" {
+		sheets.Default = sheets.NewClient(clientId, os.Getenv("GOOGLE_CLIENT_SECRET"))
+	}
+
+	// Backfill the historical exchange rates of the expense dates from the provider named by
+	// FX_PROVIDER, e.g. frankfurter for the ECB reference rates
+	fxProvider, err := providers.FXFromEnv()
+	if err != nil {
+		log.Fatalf("invalid FX provider configuration, %v", err)
+	}
+	if fxProvider != nil {
+		fx.DefaultProvider = fxProvider
+	}
+
+	// Hand out the inbound email addresses receipts are forwarded to, when SES receives the
+	// emails of a domain
+	financial.InboundEmailDomain = os.Getenv("EMAIL_IN_DOMAIN")
+
+	// Store the images uploaded through the API when an uploads bucket is configured
+	if bucket := os.Getenv("UPLOADS_BUCKET"); bucket != "" {
+		s3Client := s3.NewFromConfig(cfg)
+		uploads.S3Client = s3Client
+		uploads.Bucket = bucket
+		uploads.BaseURL = os.Getenv("UPLOADS_BASE_URL")
+		status.Checks = append(status.Checks, status.BucketCheck(s3Client, bucket))
+	}
+
+	// Offload the responses too large for Lambda to S3, when an offload bucket is configured
+	if bucket := os.Getenv("OFFLOAD_BUCKET"); bucket != "" {
+		s3Client := s3.NewFromConfig(cfg)
+		offload.S3Client = s3Client
+		offload.Presigner = s3.NewPresignClient(s3Client)
+		offload.Bucket = bucket
+		status.Checks = append(status.Checks, status.BucketCheck(s3Client, bucket))
+	}
+
+	// Send the language model requests to the provider named by LLM_PROVIDER, the gateway at
+	// LLM_ENDPOINT by default, when one is configured
+	llmProvider, err := providers.LLMFromEnv()
+	if err != nil {
+		log.Fatalf("invalid LLM provider configuration, %v", err)
+	}
+	if llmProvider != nil {
+		llm.DefaultProvider = llmProvider
+		messages.Tools = tools.NewDispatcher(financialHandlers.AssistantTools()...)
+	}
+
+	// Embed the messages, memories and expense titles with the provider named by
+	// EMBEDDINGS_PROVIDER, for the semantic search and the retrieval of the memories.
+	embedder, err := providers.EmbeddingsFromEnv()
+	if err != nil {
+		log.Fatalf("invalid embeddings provider configuration, %v", err)
+	}
+	if embedder != nil {
+		embeddings.DefaultEmbedder = embedder
+	}
+
+	// Route the requests between a fast and a strong model when LLM_ROUTING is on. Both
+	// models are needed, the gateway picks its default model otherwise.
+	if os.Getenv("LLM_ROUTING") == "on" {
+		fastModel, strongModel := os.Getenv("LLM_FAST_MODEL"), os.Getenv("LLM_STRONG_MODEL")
+		if fastModel == "" || strongModel == "" {
+			log.Fatal("LLM_ROUTING needs LLM_FAST_MODEL and LLM_STRONG_MODEL")
+		}
+		llm.DefaultRouter = llm.NewRouter(fastModel, strongModel)
+	}
+
+	// Let the users pick the models listed in LLM_CONVERSATION_MODELS for their conversations
+	if models := os.Getenv("LLM_CONVERSATION_MODELS"); models != "" {
+		messages.ConversationModels = strings.Split(models, ",")
+	}
+
+	// Queue the messages of the users over LLM_USER_RATE replies a minute in the SQS queue at
+	// REPLY_QUEUE_URL, for the replies worker to answer, instead of replying right away
+	if queueURL := os.Getenv("REPLY_QUEUE_URL"); queueURL != "" {
+		rate, err := strconv.Atoi(os.Getenv("LLM_USER_RATE"))
+		if err != nil || rate < 1 {
+			log.Fatalf("invalid LLM_USER_RATE %q", os.Getenv("LLM_USER_RATE"))
+		}
+		messages.ReplyLimiter = ratelimit.NewMemoryLimiter(rate, time.Minute)
+		messages.ReplySpacing = time.Minute / time.Duration(rate)
+		messages.ReplyQueue = queue.NewSQSQueue(queueURL, cfg)
+
+		// Let the admins inspect and requeue the replies failing too often, moved by the
+		// redrive policy of the queue to its dead-letter queue at REPLY_DEAD_LETTER_QUEUE_URL
+		if deadLetterURL := os.Getenv("REPLY_DEAD_LETTER_QUEUE_URL"); deadLetterURL != "" {
+			admin.DeadLetterQueues["replies"] = admin.DeadLetterQueue{
+				DeadLetters: queue.NewSQSQueue(deadLetterURL, cfg),
+				Source:      messages.ReplyQueue,
+			}
+		}
+	}
+
+	// Record the model, tokens and cost of the language model requests when a costs table is
+	// configured. Like the usage, they are written with the plain client.
+	if table := os.Getenv("LLM_COSTS_TABLE"); table != "" && llm.DefaultProvider != nil {
+		admin.LLMCosts = metrics.NewLLMCostRecorder(rawDynamoDbClient, table)
+		if spec := os.Getenv("LLM_PRICING"); spec != "" {
+			pricing, err := metrics.ParsePricing(spec)
+			if err != nil {
+				log.Fatalf("invalid LLM_PRICING, %v", err)
+			}
+			admin.LLMCosts.Pricing = pricing
+		}
+		llm.DefaultProvider = admin.LLMCosts.Provider(llm.DefaultProvider)
+	}
+
+	// Serve the statistics aggregations from OpenSearch, when a domain is configured
+	if endpoint := os.Getenv("OPENSEARCH_ENDPOINT"); endpoint != "" {
+		analytics.DefaultClient = analytics.NewClient(endpoint, cfg)
+	}
+
+	// Share the cached responses between the instances when a cache table is configured
+	if table := os.Getenv("RESPONSE_CACHE_TABLE"); table != "" {
+		routes.ResponseCache = cache.NewDynamoDBStore(dynamoDbClient, table)
+	}
+
+	// Record the calls per user and route when a usage table is configured. The calls are
+	// written with the plain client so they don't count against the calls of the request.
+	if table := os.Getenv("USAGE_TABLE"); table != "" {
+		admin.Usage = metrics.NewUsageRecorder(rawDynamoDbClient, table)
+	}
+
+	// Let the admins switch the API to read-only during migrations when a maintenance table
+	// is configured
+	if table := os.Getenv("MAINTENANCE_TABLE"); table != "" {
+		admin.Maintenance = admin.NewMaintenanceSwitch(dynamoDbClient, table)
+	}
+
+	// Limit the features to the entitlements of the plan of each user with PLAN_ENTITLEMENTS=on
+	plans.Enforced = os.Getenv("PLAN_ENTITLEMENTS") == "on"
+
+	// Verify the signatures of the mobile apps on the mutating routes, rejecting the unsigned
+	// requests with REQUEST_SIGNATURES=on. The signatures received are remembered in a table
+	// when one is configured, so a request isn't replayed on another instance.
+	devices.Required = os.Getenv("REQUEST_SIGNATURES") == "on"
+	if table := os.Getenv("SIGNATURE_REPLAY_TABLE"); table != "" {
+		devices.Replays = verify.NewDynamoDBReplayCache(dynamoDbClient, table)
+	}
+
+	// Move the users between the plans on the events of their Stripe subscriptions when the
+	// signing secret of the webhook endpoint is configured. Its deliveries are remembered
+	// with the device signatures.
+	if secret := os.Getenv("STRIPE_WEBHOOK_SECRET"); secret != "" {
+		plans.StripeWebhook = verify.NewVerifier("stripe", verify.Stripe(secret), devices.Replays)
+	}
+
+	// Check the main tables and the language model gateway in the deep health, next to the
+	// buckets configured above. The checks read with the plain client, like the usage.
+	for _, table := range []string{"splitter-expenses", "splitter-group-members", "chat"} {
+		status.Checks = append(status.Checks, status.TableCheck(rawDynamoDbClient, table))
+	}
+	if endpoint := os.Getenv("LLM_ENDPOINT"); endpoint != "" {
+		status.Checks = append(status.Checks, status.HTTPCheck("llm", endpoint, http.DefaultClient))
+	}
+
+	// Initialize the router under the proxy resource, configurable for the environments
+	// reached through a custom domain, where the path keeps the base path mapping, e.g.
+	// API_BASE_PATH=/v1/VassistantBackendProxy
+	router = api.NewRouter()
+	basePath := routes.DefaultBasePath
+	if value, ok := os.LookupEnv("API_BASE_PATH"); ok {
+		if value != "" && (!strings.HasPrefix(value, "/") || strings.HasSuffix(value, "/")) {
+			log.Fatalf("invalid API_BASE_PATH %q", value)
+		}
+		basePath = value
+	}
+	router.SetBasePath(basePath)
+	if admin.Usage != nil {
+		router.Use(admin.Usage.Middleware)
+	}
+	router.Use(api.Localized)
+	if admin.Maintenance != nil {
+		router.Use(admin.Maintenance.Middleware)
+	}
+	router.Use(devicesHandlers.Signed)
+	router.Use(dynamoDbClient.Middleware)
+	router.Use(api.Enveloped)
+	router.Use(api.Compatible)
+
+	// Log the responses drifting from the published OpenAPI spec, in the debug stages
+	spec, err := openapi.FromEnv()
+	if err != nil {
+		log.Fatalf("invalid DEBUG_RESPONSE_VALIDATION, %v", err)
+	}
+	if spec != nil {
+		router.Use(spec.Middleware)
+	}
+
+	// Send the share of the requests of the shadowed routes set by SHADOW_TRAFFIC to their
+	// candidate handlers too, e.g. SHADOW_TRAFFIC=net-debts=0.1, logging how they compare.
+	// The candidates read through the uninstrumented client, outliving the requests they
+	// shadow when timed out; they don't read encrypted attributes.
+	shadowRates, err := shadow.FromEnv()
+	if err != nil {
+		log.Fatalf("invalid SHADOW_TRAFFIC, %v", err)
+	}
+	shadow.Rates = shadowRates
+	candidateHandlers := financial.NewHandlers(rawDynamoDbClient)
+	routes.Register(router, routes.Handlers{Financial: financialHandlers, Messages: messagesHandlers, Plans: plansHandlers, Admin: adminHandlers, Devices: devicesHandlers, Referrals: referralsHandlers, Candidates: candidateHandlers})
+}
+
+func rootHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	api.LogRequest(request)
+	return router.Serve(request)
+}
+
+func main() {
+	lambda.Start(rootHandler)
+}
@@ -0,0 +1,38 @@
+// Command cognito is the Lambda of the triggers of the Cognito user pool. For now the post
+// confirmation trigger, generating the referral code of the new users and attributing them
+// to the code they signed up with.
+package main
+
+import (
+	"context"
+	"log"
+	"vassistant-backend/encryption"
+	"vassistant-backend/metrics"
+	"vassistant-backend/referrals"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+var handlers *referrals.Handlers
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// The rewards are granted on the user records, encrypted like in the API
+	dynamoDbClient := metrics.NewInstrumentedDynamoDB(dynamodb.NewFromConfig(cfg))
+	encryptingDynamoDbClient, err := encryption.FromEnv(cfg, dynamoDbClient)
+	if err != nil {
+		log.Fatalf("invalid encryption configuration, %v", err)
+	}
+	handlers = referrals.NewHandlers(encryptingDynamoDbClient)
+}
+
+func main() {
+	lambda.Start(handlers.PostConfirmationHandler)
+}
@@ -0,0 +1,119 @@
+// Command jobs is the Lambda running the background jobs triggered by events other than the
+// API requests. For now it drafts expenses from the receipts the users forward to their
+// inbound address: it is meant to be invoked by an SES receipt rule for EMAIL_IN_DOMAIN,
+// after an S3 action storing the messages in EMAIL_IN_BUCKET under EMAIL_IN_PREFIX, keyed
+// by their SES message ID.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"vassistant-backend/encryption"
+	"vassistant-backend/financial"
+	"vassistant-backend/metrics"
+	"vassistant-backend/notifications"
+	"vassistant-backend/residency"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxEmailSize bounds the size of the messages read, SES accepting up to 40 MB.
+const maxEmailSize = 10 << 20
+
+var (
+	s3Client *s3.Client
+	bucket   string
+	prefix   string
+	handlers *financial.Handlers
+)
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	financial.InboundEmailDomain = os.Getenv("EMAIL_IN_DOMAIN")
+	bucket, prefix = os.Getenv("EMAIL_IN_BUCKET"), os.Getenv("EMAIL_IN_PREFIX")
+	if financial.InboundEmailDomain == "" || bucket == "" {
+		log.Fatal("EMAIL_IN_DOMAIN and EMAIL_IN_BUCKET must be set")
+	}
+	s3Client = s3.NewFromConfig(cfg)
+
+	// The drafts hold the notes, encrypted like those of the expenses
+	dynamoDbClient := metrics.NewInstrumentedDynamoDB(residency.FromEnv(cfg, dynamodb.NewFromConfig(cfg)))
+	encryptingDynamoDbClient, err := encryption.FromEnv(cfg, dynamoDbClient)
+	if err != nil {
+		log.Fatalf("invalid encryption configuration, %v", err)
+	}
+	handlers = financial.NewHandlers(encryptingDynamoDbClient)
+	notifications.DynamoDbClient = dynamoDbClient
+}
+
+// rejected reports whether SES flagged the message as spam or carrying a virus.
+func rejected(receipt events.SimpleEmailReceipt) bool {
+	return receipt.SpamVerdict.Status == "FAIL" || receipt.VirusVerdict.Status == "FAIL"
+}
+
+func emailHandler(ctx context.Context, event events.SimpleEmailEvent) error {
+	for _, record := range event.Records {
+		mail, receipt := record.SES.Mail, record.SES.Receipt
+		if rejected(receipt) {
+			log.Printf("Dropping email %s flagged by SES: spam %s, virus %s", mail.MessageID, receipt.SpamVerdict.Status, receipt.VirusVerdict.Status)
+			continue
+		}
+		var inboxIds []string
+		for _, recipient := range receipt.Recipients {
+			if inboxId := financial.InboxID(recipient); inboxId != "" {
+				inboxIds = append(inboxIds, inboxId)
+			}
+		}
+		if len(inboxIds) == 0 {
+			continue
+		}
+
+		// Failing makes SES retry the invocation, so only the transient errors are returned
+		object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(prefix + mail.MessageID),
+		})
+		if err != nil {
+			log.Printf("Error getting email %s from S3: %v", mail.MessageID, err)
+			return err
+		}
+		raw, err := io.ReadAll(io.LimitReader(object.Body, maxEmailSize))
+		object.Body.Close()
+		if err != nil {
+			log.Printf("Error reading email %s from S3: %v", mail.MessageID, err)
+			return err
+		}
+
+		for _, inboxId := range inboxIds {
+			draft, err := handlers.DraftFromEmail(ctx, inboxId, bytes.NewReader(raw))
+			switch {
+			case errors.Is(err, financial.ErrUnknownInbox), errors.Is(err, financial.ErrNotGroupMember), errors.Is(err, financial.ErrUnreadableEmail):
+				log.Printf("Skipping email %s to inbox %s: %v", mail.MessageID, inboxId, err)
+			case err != nil:
+				log.Printf("Error drafting expense from email %s: %v", mail.MessageID, err)
+				return err
+			default:
+				log.Printf("Drafted expense %s from email %s", draft.DraftID, mail.MessageID)
+			}
+		}
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(emailHandler)
+}
@@ -0,0 +1,179 @@
+// Command loadgen generates load-test scenarios from the API route table.
+//
+// It prints either vegeta JSON targets or a k6 script hitting every route, with the
+// path parameters filled in from the command line:
+//
+//	go run ./cmd/loadgen -base-url https://api.example.com/prod -token $TOKEN \
+//		-param groupId=... -param expenseId=... | vegeta attack -format=json -rate=20 -duration=1m
+//
+// Mutating routes are only included when a request body for them is given in the
+// -bodies file, a JSON object keyed by "METHOD /template".
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"vassistant-backend/admin"
+	"vassistant-backend/api"
+	"vassistant-backend/devices"
+	"vassistant-backend/financial"
+	"vassistant-backend/messages"
+	"vassistant-backend/plans"
+	"vassistant-backend/referrals"
+	"vassistant-backend/routes"
+)
+
+// Target is a single request of the scenario, in vegeta's JSON target format.
+type Target struct {
+	Method string              `json:"method"`
+	URL    string              `json:"url"`
+	Header map[string][]string `json:"header,omitempty"`
+	Body   []byte              `json:"body,omitempty"`
+}
+
+// params collects the repeated -param name=value flags.
+type params map[string]string
+
+func (p params) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p params) Set(value string) error {
+	name, paramValue, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	p[name] = paramValue
+	return nil
+}
+
+var templateParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+func main() {
+	baseURL := flag.String("base-url", "", "base URL of the deployed API, including the stage")
+	basePath := flag.String("base-path", routes.DefaultBasePath, "path the routes are served under")
+	format := flag.String("format", "vegeta", "output format: vegeta or k6")
+	token := flag.String("token", "", "Cognito ID token sent as the Authorization header")
+	bodiesPath := flag.String("bodies", "", "JSON file with request bodies of mutating routes")
+	pathParams := params{}
+	flag.Var(pathParams, "param", "path parameter value as name=value, can be repeated")
+	flag.Parse()
+
+	if *baseURL == "" {
+		log.Fatal("-base-url is required")
+	}
+
+	bodies := map[string]json.RawMessage{}
+	if *bodiesPath != "" {
+		content, err := os.ReadFile(*bodiesPath)
+		if err != nil {
+			log.Fatalf("unable to read bodies, %v", err)
+		}
+		if err := json.Unmarshal(content, &bodies); err != nil {
+			log.Fatalf("unable to parse bodies, %v", err)
+		}
+	}
+
+	// The routes are only listed, so their handlers have no client
+	router := api.NewRouter()
+	routes.Register(router, routes.Handlers{Financial: financial.NewHandlers(nil), Messages: messages.NewHandlers(nil, nil), Plans: plans.NewHandlers(nil), Admin: admin.NewHandlers(nil), Devices: devices.NewHandlers(nil), Referrals: referrals.NewHandlers(nil), Candidates: financial.NewHandlers(nil)})
+
+	targets := buildTargets(router.Routes(), strings.TrimSuffix(*baseURL, "/")+strings.TrimSuffix(*basePath, "/"), *token, pathParams, bodies)
+
+	switch *format {
+	case "vegeta":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, target := range targets {
+			if err := encoder.Encode(target); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case "k6":
+		if err := writeK6Script(targets); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+}
+
+// buildTargets creates one target per route, skipping routes whose parameters or body are missing.
+func buildTargets(routeTable []api.Route, baseURL, token string, pathParams params, bodies map[string]json.RawMessage) []Target {
+	var targets []Target
+	for _, route := range routeTable {
+		name := route.Method + " " + route.Template
+
+		var missing []string
+		path := templateParamPattern.ReplaceAllStringFunc(route.Template, func(match string) string {
+			param := strings.Trim(match, "{}")
+			value, ok := pathParams[param]
+			if !ok {
+				missing = append(missing, param)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			log.Printf("Skipping %s, missing path parameters %v", name, missing)
+			continue
+		}
+
+		target := Target{Method: route.Method, URL: baseURL + path, Header: map[string][]string{}}
+		if token != "" {
+			target.Header["Authorization"] = []string{token}
+		}
+		if route.Method != "GET" {
+			body, ok := bodies[name]
+			if !ok {
+				log.Printf("Skipping %s, no request body given", name)
+				continue
+			}
+			target.Header["Content-Type"] = []string{"application/json"}
+			target.Body = body
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// writeK6Script prints a k6 script requesting every target once per iteration.
+func writeK6Script(targets []Target) error {
+	type k6Target struct {
+		Method  string            `json:"method"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body,omitempty"`
+	}
+
+	k6Targets := make([]k6Target, 0, len(targets))
+	for _, target := range targets {
+		headers := make(map[string]string, len(target.Header))
+		for name, values := range target.Header {
+			headers[name] = strings.Join(values, ",")
+		}
+		k6Targets = append(k6Targets, k6Target{Method: target.Method, URL: target.URL, Headers: headers, Body: string(target.Body)})
+	}
+
+	encoded, err := json.MarshalIndent(k6Targets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(`import http from 'k6/http';
+import { check } from 'k6';
+
+const targets = %s;
+
+export default function () {
+  for (const target of targets) {
+    const response = http.request(target.method, target.url, target.body || null, { headers: target.headers });
+    check(response, { [target.method + ' ' + target.url + ' is 2xx']: (r) => r.status >= 200 && r.status < 300 });
+  }
+}
+`, encoded)
+	return nil
+}
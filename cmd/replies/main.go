@@ -0,0 +1,83 @@
+// Command replies is the Lambda answering the messages the API queued in REPLY_QUEUE_URL when
+// their users went over LLM_USER_RATE, meant to be triggered by that SQS queue with
+// ReportBatchItemFailures on. The replies are saved and sent to the devices of the users over
+// the WebSocket API, so it needs the language model and WebSocket configuration of the API.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	_ "time/tzdata" // the time zones of the groups, which the Lambda runtime lacks
+	"vassistant-backend/embeddings"
+	"vassistant-backend/encryption"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/messages"
+	"vassistant-backend/metrics"
+	"vassistant-backend/providers"
+	"vassistant-backend/realtime"
+	"vassistant-backend/residency"
+	"vassistant-backend/tools"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+var handlers *messages.Handlers
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// The messages are encrypted like in the API
+	dynamoDbClient := metrics.NewInstrumentedDynamoDB(residency.FromEnv(cfg, dynamodb.NewFromConfig(cfg)))
+	encryptingDynamoDbClient, err := encryption.FromEnv(cfg, dynamoDbClient)
+	if err != nil {
+		log.Fatalf("invalid encryption configuration, %v", err)
+	}
+	financialHandlers := financial.NewHandlers(encryptingDynamoDbClient)
+
+	// The expenses added by the commands are limited like in the API
+	if spec := os.Getenv("GROUP_QUOTAS"); spec != "" {
+		quotas, err := financial.ParseQuotas(spec)
+		if err != nil {
+			log.Fatalf("invalid GROUP_QUOTAS, %v", err)
+		}
+		financial.GroupQuotas = quotas
+	}
+	handlers = messages.NewHandlers(encryptingDynamoDbClient, financialHandlers)
+	tools.DynamoDbClient = dynamoDbClient
+	realtime.DynamoDbClient = dynamoDbClient
+
+	if endpoint := os.Getenv("WEBSOCKET_ENDPOINT"); endpoint != "" {
+		realtime.Default = realtime.NewBroadcaster(cfg, endpoint)
+	}
+
+	llmProvider, err := providers.LLMFromEnv()
+	if err != nil {
+		log.Fatalf("invalid LLM provider configuration, %v", err)
+	}
+	if llmProvider == nil {
+		log.Fatal("the replies worker needs a language model, LLM_PROVIDER or LLM_ENDPOINT must be set")
+	}
+	llm.DefaultProvider = llmProvider
+	messages.Tools = tools.NewDispatcher(financialHandlers.AssistantTools()...)
+
+	// Embed the queued messages and their replies like the API does
+	embedder, err := providers.EmbeddingsFromEnv()
+	if err != nil {
+		log.Fatalf("invalid embeddings provider configuration, %v", err)
+	}
+	if embedder != nil {
+		embeddings.DefaultEmbedder = embedder
+	}
+}
+
+func main() {
+	lambda.Start(handlers.ReplyQueueHandler)
+}
@@ -0,0 +1,225 @@
+// Command scheduler is the Lambda running the scheduled jobs, each meant to be triggered by
+// its own EventBridge schedule with the constant input {"job": "<name>"}:
+//
+//   - purge-groups, e.g. once a day, permanently deletes the groups whose deletion
+//     retention has ended. DELETION_RETENTION_DAYS overrides the 30 days a deleted group is
+//     kept, PURGE_BATCH_SIZE the items deleted per batch and PURGE_DELETES_PER_SECOND bounds
+//     the deletes, unlimited when unset.
+//   - reconcile-balances, e.g. once a day, recomputes the balances of every group from its
+//     expenses and settlements and publishes their drift from the materialized balances.
+//     With RECONCILE_HEAL=true the drifted balances are rewritten.
+//   - proactive-assistant, e.g. once a day, sends the users who opted in a message of the
+//     assistant about their expenses due soon and unusual spending. With a language model
+//     provider configured by LLM_PROVIDER or LLM_ENDPOINT the messages are written by it,
+//     otherwise from plain templates.
+//   - weekly-digest, e.g. every Monday morning, notifies the members of the groups of their
+//     new expenses, balances and monthly expenses due over the week, unless they muted the
+//     WEEKLY_DIGEST notifications. With UNSUBSCRIBE_URL, the address of the public
+//     unsubscribe route, the digests link to it.
+//   - detect-recurring, e.g. once a day, analyzes the history of every group and suggests the
+//     expenses added every month as recurring expenses, which the members accept or dismiss.
+//
+// Every job publishes its counts as metrics with its name as the Job dimension.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+	_ "time/tzdata" // the time zones of the groups, which the Lambda runtime lacks
+	"vassistant-backend/encryption"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/messages"
+	"vassistant-backend/metrics"
+	"vassistant-backend/notifications"
+	"vassistant-backend/providers"
+	"vassistant-backend/realtime"
+	"vassistant-backend/residency"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// scheduledEvent is the constant input of the schedules, naming the job to run.
+type scheduledEvent struct {
+	Job string `json:"job"`
+}
+
+// jobs maps the names of the jobs to the functions running them.
+var jobs = map[string]func(ctx context.Context, now time.Time) error{
+	"purge-groups":        purgeGroups,
+	"reconcile-balances":  reconcileBalances,
+	"proactive-assistant": sendProactiveMessages,
+	"weekly-digest":       sendWeeklyDigests,
+	"detect-recurring":    detectRecurringExpenses,
+}
+
+var (
+	financialHandlers *financial.Handlers
+	messagesHandlers  *messages.Handlers
+	purgeOptions      financial.PurgeOptions
+	heal              bool
+)
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	// The proactive messages are stored encrypted like the others, the plain client is
+	// enough for the notifications and the connections
+	dynamoDbClient := metrics.NewInstrumentedDynamoDB(residency.FromEnv(cfg, dynamodb.NewFromConfig(cfg)))
+	encryptingDynamoDbClient, err := encryption.FromEnv(cfg, dynamoDbClient)
+	if err != nil {
+		log.Fatalf("invalid encryption configuration, %v", err)
+	}
+	financialHandlers = financial.NewHandlers(encryptingDynamoDbClient)
+	messagesHandlers = messages.NewHandlers(encryptingDynamoDbClient, financialHandlers)
+	notifications.DynamoDbClient = dynamoDbClient
+	realtime.DynamoDbClient = dynamoDbClient
+
+	if endpoint := os.Getenv("WEBSOCKET_ENDPOINT"); endpoint != "" {
+		realtime.Default = realtime.NewBroadcaster(cfg, endpoint)
+	}
+
+	llmProvider, err := providers.LLMFromEnv()
+	if err != nil {
+		log.Fatalf("invalid LLM provider configuration, %v", err)
+	}
+	if llmProvider != nil {
+		llm.DefaultProvider = llmProvider
+	}
+
+	if value, ok := os.LookupEnv("DELETION_RETENTION_DAYS"); ok {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 {
+			log.Fatalf("invalid DELETION_RETENTION_DAYS %q", value)
+		}
+		financial.DeletionRetention = time.Duration(days) * 24 * time.Hour
+	}
+	purgeOptions.BatchSize = envInt("PURGE_BATCH_SIZE")
+	purgeOptions.DeletesPerSecond = envInt("PURGE_DELETES_PER_SECOND")
+	heal = os.Getenv("RECONCILE_HEAL") == "true"
+	notifications.UnsubscribeURL = os.Getenv("UNSUBSCRIBE_URL")
+}
+
+// envInt reads an optional positive integer from the environment, 0 when unset.
+func envInt(name string) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		log.Fatalf("invalid %s %q", name, value)
+	}
+	return number
+}
+
+func purgeGroups(ctx context.Context, now time.Time) error {
+	result, err := financialHandlers.PurgeDeletedGroups(ctx, now, purgeOptions)
+
+	// The counts are published even when the run failed halfway, for what it purged
+	metrics.Emit(map[string]string{"Job": "purge-groups"},
+		metrics.Metric{Name: "PurgedGroups", Unit: metrics.UnitCount, Value: float64(result.Groups)},
+	)
+	for table, count := range result.Items {
+		metrics.Emit(map[string]string{"Job": "purge-groups", "Table": table},
+			metrics.Metric{Name: "PurgedItems", Unit: metrics.UnitCount, Value: float64(count)},
+		)
+	}
+	if err != nil {
+		log.Printf("Error purging deleted groups after %d purged: %v", result.Groups, err)
+		return err
+	}
+
+	log.Printf("Purged %d deleted groups", result.Groups)
+	return nil
+}
+
+func reconcileBalances(ctx context.Context, now time.Time) error {
+	result, err := financialHandlers.ReconcileBalances(ctx, heal, now)
+	if err != nil {
+		log.Printf("Error reconciling balances after %d groups: %v", result.Groups, err)
+		return err
+	}
+
+	metrics.Emit(map[string]string{"Job": "reconcile-balances"},
+		metrics.Metric{Name: "ReconciledGroups", Unit: metrics.UnitCount, Value: float64(result.Groups)},
+		metrics.Metric{Name: "DriftedGroups", Unit: metrics.UnitCount, Value: float64(result.Drifted)},
+		metrics.Metric{Name: "BalanceDrifts", Unit: metrics.UnitCount, Value: float64(result.Drifts)},
+		metrics.Metric{Name: "HealedGroups", Unit: metrics.UnitCount, Value: float64(result.Healed)},
+	)
+	log.Printf("Reconciled %d groups: %d drifted, %d healed", result.Groups, result.Drifted, result.Healed)
+	return nil
+}
+
+func sendProactiveMessages(ctx context.Context, now time.Time) error {
+	result, err := messagesHandlers.SendProactiveMessages(ctx, now)
+	if err != nil {
+		log.Printf("Error sending proactive messages after %d users: %v", result.Users, err)
+		return err
+	}
+
+	metrics.Emit(map[string]string{"Job": "proactive-assistant"},
+		metrics.Metric{Name: "ProactiveUsers", Unit: metrics.UnitCount, Value: float64(result.Users)},
+		metrics.Metric{Name: "ProactiveMessages", Unit: metrics.UnitCount, Value: float64(result.Sent)},
+		metrics.Metric{Name: "ProactiveFailures", Unit: metrics.UnitCount, Value: float64(result.Failed)},
+	)
+	log.Printf("Checked %d users: %d messages sent, %d failed", result.Users, result.Sent, result.Failed)
+	return nil
+}
+
+func sendWeeklyDigests(ctx context.Context, now time.Time) error {
+	result, err := financialHandlers.SendWeeklyDigests(ctx, now)
+	if err != nil {
+		log.Printf("Error sending weekly digests after %d users: %v", result.Users, err)
+		return err
+	}
+
+	metrics.Emit(map[string]string{"Job": "weekly-digest"},
+		metrics.Metric{Name: "DigestUsers", Unit: metrics.UnitCount, Value: float64(result.Users)},
+		metrics.Metric{Name: "DigestsSent", Unit: metrics.UnitCount, Value: float64(result.Sent)},
+		metrics.Metric{Name: "DigestsSkipped", Unit: metrics.UnitCount, Value: float64(result.Skipped)},
+		metrics.Metric{Name: "DigestFailures", Unit: metrics.UnitCount, Value: float64(result.Failed)},
+	)
+	log.Printf("Checked %d users: %d digests sent, %d skipped, %d failed", result.Users, result.Sent, result.Skipped, result.Failed)
+	return nil
+}
+
+func detectRecurringExpenses(ctx context.Context, now time.Time) error {
+	result, err := financialHandlers.DetectRecurringExpenses(ctx, now)
+	if err != nil {
+		log.Printf("Error detecting recurring expenses after %d groups: %v", result.Groups, err)
+		return err
+	}
+
+	metrics.Emit(map[string]string{"Job": "detect-recurring"},
+		metrics.Metric{Name: "AnalyzedGroups", Unit: metrics.UnitCount, Value: float64(result.Groups)},
+		metrics.Metric{Name: "RecurringSuggestions", Unit: metrics.UnitCount, Value: float64(result.Suggested)},
+	)
+	log.Printf("Analyzed %d groups: %d recurring expenses suggested", result.Groups, result.Suggested)
+	return nil
+}
+
+func scheduleHandler(ctx context.Context, event scheduledEvent) error {
+	log.Printf("event: %+v\n", event)
+
+	// Failing on an unknown job shows the misconfigured schedule in the errors of the Lambda
+	job, ok := jobs[event.Job]
+	if !ok {
+		return fmt.Errorf("unknown job %q", event.Job)
+	}
+	return job(ctx, time.Now())
+}
+
+func main() {
+	lambda.Start(scheduleHandler)
+}
@@ -0,0 +1,93 @@
+// Command streams is the Lambda handling the records of the DynamoDB table streams, each
+// handed to the handlers of the table it comes from. For now the stream of the
+// splitter-expenses table, with the NEW_IMAGE or NEW_AND_OLD_IMAGES view type, indexes the group
+// expenses into OpenSearch for the statistics dashboard when OPENSEARCH_ENDPOINT is set, and
+// embeds their titles for the semantic search when EMBEDDINGS_PROVIDER is set.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"vassistant-backend/analytics"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/providers"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// streamHandlers maps the tables to the handlers of the records of their stream, called in
+// turn.
+var streamHandlers = map[string][]func(ctx context.Context, event events.DynamoDBEvent) error{}
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	if endpoint := os.Getenv("OPENSEARCH_ENDPOINT"); endpoint != "" {
+		analytics.DefaultClient = analytics.NewClient(endpoint, cfg)
+
+		// Create the index on cold start, so the first documents get the expected mapping
+		if err := analytics.DefaultClient.EnsureIndex(context.TODO()); err != nil {
+			log.Fatalf("unable to create the %s index, %v", analytics.SharesIndex, err)
+		}
+		streamHandlers["splitter-expenses"] = append(streamHandlers["splitter-expenses"], analytics.DefaultClient.IndexStreamEvent)
+	}
+
+	embedder, err := providers.EmbeddingsFromEnv()
+	if err != nil {
+		log.Fatalf("invalid embeddings provider configuration, %v", err)
+	}
+	if embedder != nil {
+		embeddings.DefaultEmbedder = embedder
+		streamHandlers["splitter-expenses"] = append(streamHandlers["splitter-expenses"], embeddings.NewStore(dynamodb.NewFromConfig(cfg)).IndexExpenseStreamEvent)
+	}
+
+	if len(streamHandlers) == 0 {
+		log.Fatal("neither OPENSEARCH_ENDPOINT nor EMBEDDINGS_PROVIDER is set")
+	}
+}
+
+// streamTable returns the table of a stream from its ARN, e.g. splitter-expenses for
+// arn:aws:dynamodb:us-east-1:123456789012:table/splitter-expenses/stream/2024-01-01T00:00:00.000.
+func streamTable(arn string) string {
+	_, table, _ := strings.Cut(arn, ":table/")
+	table, _, _ = strings.Cut(table, "/")
+	return table
+}
+
+func streamHandler(ctx context.Context, event events.DynamoDBEvent) error {
+	log.Printf("Handling %d stream records", len(event.Records))
+	if len(event.Records) == 0 {
+		return nil
+	}
+
+	// The records of a batch all come from the stream of the event source mapping
+	table := streamTable(event.Records[0].EventSourceArn)
+	handlers, ok := streamHandlers[table]
+	if !ok {
+		log.Printf("Skipping the stream records of %s, which has no handler", table)
+		return nil
+	}
+
+	// Failing the batch makes Lambda retry it for every handler, so they must be idempotent
+	for _, handler := range handlers {
+		err := handler(ctx, event)
+		if err != nil {
+			log.Printf("Error handling the stream records of %s: %v", table, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(streamHandler)
+}
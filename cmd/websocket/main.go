@@ -0,0 +1,29 @@
+// Command websocket is the Lambda behind the routes of the WebSocket API, keeping the
+// registry of the connected clients the API broadcasts the events of the users to. The
+// authorizer of the $connect route must put the sub of the user in its context.
+package main
+
+import (
+	"context"
+	"log"
+	"vassistant-backend/metrics"
+	"vassistant-backend/realtime"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+func init() {
+	// Load the Shared AWS Configuration (~/.aws/config)
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatalf("unable to load SDK config, %v", err)
+	}
+
+	realtime.DynamoDbClient = metrics.NewInstrumentedDynamoDB(dynamodb.NewFromConfig(cfg))
+}
+
+func main() {
+	lambda.Start(realtime.WebsocketHandler)
+}
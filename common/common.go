@@ -16,16 +16,55 @@ type DynamoDBAPI interface {
 	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
 	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
 	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
 }
 
 // ErrorResponse struct for JSON error messages
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
-// CreateErrorResponse is a helper function to generate a JSON error response
+// errorCodes are the machine-readable codes of the messages shared by many endpoints, given
+// to their error responses so the clients and the localization of the messages can rely on
+// them rather than on the English text
+var errorCodes = map[string]string{
+	"Internal server error":                    "internal_error",
+	"Invalid request body":                     "invalid_request_body",
+	"Forbidden":                                "forbidden",
+	"Not Found":                                "not_found",
+	"Group ID is missing":                      "group_id_missing",
+	"Group not found":                          "group_not_found",
+	"Expense ID is missing":                    "expense_id_missing",
+	"Expense not found":                        "expense_not_found",
+	"Expense was modified concurrently":        "expense_conflict",
+	"Expenses were modified concurrently":      "expenses_conflict",
+	"Invalid from date":                        "invalid_from_date",
+	"Invalid to date":                          "invalid_to_date",
+	"The from date must be before the to date": "invalid_date_range",
+	"Analytics are not available":              "analytics_unavailable",
+	"Exchange rate not available":              "exchange_rate_unavailable",
+	"Invalid currency":                         "invalid_currency",
+	"Invalid cursor":                           "invalid_cursor",
+}
+
+// ErrorCode returns the code of a shared error message, "" for the messages of their own.
+func ErrorCode(message string) string {
+	return errorCodes[message]
+}
+
+// CreateErrorResponse is a helper function to generate a JSON error response, coded when
+// the message is a shared one
 func CreateErrorResponse(statusCode int, message string) (events.APIGatewayProxyResponse, error) {
-	body, err := json.Marshal(ErrorResponse{Error: message})
+	return CreateCodedErrorResponse(statusCode, ErrorCode(message), message)
+}
+
+// CreateCodedErrorResponse generates a JSON error response carrying a stable machine-readable
+// code next to the human-readable message
+func CreateCodedErrorResponse(statusCode int, code, message string) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(ErrorResponse{Error: message, Code: code})
 	if err != nil {
 		// This should not happen, but if it does, log it and return a generic error
 		log.Printf("Failed to marshal error response: %v", err)
@@ -42,3 +81,19 @@ func CreateErrorResponse(statusCode int, message string) (events.APIGatewayProxy
 		Body:       string(body),
 	}, nil
 }
+
+// NextCursorHeader is the response header carrying the cursor of the next page of a listing,
+// absent on the last page.
+const NextCursorHeader = "X-Next-Cursor"
+
+// WarningHeader is the response header carrying the non-fatal warnings of a response, once
+// per warning, e.g. when some of the users of a listing weren't found.
+const WarningHeader = "X-Warning"
+
+// AddWarning adds a non-fatal warning to the response.
+func AddWarning(response *events.APIGatewayProxyResponse, warning string) {
+	if response.MultiValueHeaders == nil {
+		response.MultiValueHeaders = map[string][]string{}
+	}
+	response.MultiValueHeaders[WarningHeader] = append(response.MultiValueHeaders[WarningHeader], warning)
+}
@@ -0,0 +1,34 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ConsistentReadHeader is the freshness hint header clients send on the reads that
+// directly follow one of their own mutations, e.g. the GET after a POST.
+const ConsistentReadHeader = "X-Consistent-Read"
+
+// Header returns the value of the request header, matching the name case-insensitively.
+func Header(request events.APIGatewayProxyRequest, name string) string {
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// ConsistentRead reports whether the request asks for strongly consistent reads.
+// The result is meant for the ConsistentRead field of GetItem and Query inputs on base
+// tables; global secondary indexes only support eventually consistent reads.
+func ConsistentRead(request events.APIGatewayProxyRequest) *bool {
+	switch strings.ToLower(strings.TrimSpace(Header(request, ConsistentReadHeader))) {
+	case "true", "1":
+		return aws.Bool(true)
+	default:
+		return nil
+	}
+}
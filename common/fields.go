@@ -0,0 +1,92 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// ParseFields reads the comma separated "fields" query parameter, validating each
+// field against the allowed ones. It returns nil when all fields should be returned.
+func ParseFields(request events.APIGatewayProxyRequest, allowed ...string) ([]string, error) {
+	value := strings.TrimSpace(request.QueryStringParameters["fields"])
+	if value == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || slices.Contains(fields, field) {
+			continue
+		}
+		if !slices.Contains(allowed, field) {
+			return nil, fmt.Errorf("Unknown field: %s", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// ProjectionExpression builds a DynamoDB projection expression for the given attributes.
+// Attribute names are always aliased so that reserved words can be projected.
+func ProjectionExpression(attributes []string) (*string, map[string]string) {
+	names := make(map[string]string, len(attributes))
+	placeholders := make([]string, 0, len(attributes))
+	var seen []string
+	for _, attribute := range attributes {
+		if slices.Contains(seen, attribute) {
+			continue
+		}
+		seen = append(seen, attribute)
+
+		placeholder := fmt.Sprintf("#p%d", len(placeholders))
+		names[placeholder] = attribute
+		placeholders = append(placeholders, placeholder)
+	}
+	return aws.String(strings.Join(placeholders, ", ")), names
+}
+
+// FilterFields marshals the value into JSON keeping only the given top-level fields.
+// Slices are filtered element by element.
+func FilterFields(value interface{}, fields []string) ([]byte, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode numbers as json.Number so amounts keep their exact representation
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(filterDocument(document, fields))
+}
+
+func filterDocument(document interface{}, fields []string) interface{} {
+	switch value := document.(type) {
+	case []interface{}:
+		for i, element := range value {
+			value[i] = filterDocument(element, fields)
+		}
+		return value
+	case map[string]interface{}:
+		filtered := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			if fieldValue, ok := value[field]; ok {
+				filtered[field] = fieldValue
+			}
+		}
+		return filtered
+	default:
+		return value
+	}
+}
@@ -0,0 +1,149 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrConditionFailed is returned when a conditional write is rejected because the
+// stored item does not match the expected state, e.g. it was changed concurrently.
+var ErrConditionFailed = errors.New("conditional check failed")
+
+// WriteCondition is a DynamoDB condition expression guarding a write.
+type WriteCondition struct {
+	Expression string
+	Names      map[string]string
+	Values     map[string]types.AttributeValue
+}
+
+// IfNotExists only allows the write when no item with the same key exists yet.
+func IfNotExists(keyAttribute string) WriteCondition {
+	return WriteCondition{
+		Expression: "attribute_not_exists(#key)",
+		Names:      map[string]string{"#key": keyAttribute},
+	}
+}
+
+// IfExists only allows the write when the item already exists.
+func IfExists(keyAttribute string) WriteCondition {
+	return WriteCondition{
+		Expression: "attribute_exists(#key)",
+		Names:      map[string]string{"#key": keyAttribute},
+	}
+}
+
+// IfEquals only allows the write when the stored string attribute has the expected value.
+func IfEquals(attribute, expected string) WriteCondition {
+	return WriteCondition{
+		Expression: "#attribute = :expected",
+		Names:      map[string]string{"#attribute": attribute},
+		Values: map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberS{Value: expected},
+		},
+	}
+}
+
+// IfVersion only allows the write when the stored "version" attribute still matches
+// the expected version. An expected version of 0 means the item must not exist yet.
+func IfVersion(expectedVersion int) WriteCondition {
+	if expectedVersion == 0 {
+		return WriteCondition{
+			Expression: "attribute_not_exists(#version)",
+			Names:      map[string]string{"#version": "version"},
+		}
+	}
+	return WriteCondition{
+		Expression: "#version = :expectedVersion",
+		Names:      map[string]string{"#version": "version"},
+		Values: map[string]types.AttributeValue{
+			":expectedVersion": &types.AttributeValueMemberN{Value: strconv.Itoa(expectedVersion)},
+		},
+	}
+}
+
+// ConditionalPutItem marshals the item and stores it only if the condition holds.
+// It returns ErrConditionFailed when DynamoDB rejects the write.
+func ConditionalPutItem(ctx context.Context, client DynamoDBAPI, tableName string, item interface{}, condition WriteCondition) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	putItemInput := &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                av,
+		ConditionExpression: aws.String(condition.Expression),
+	}
+	if len(condition.Names) > 0 {
+		putItemInput.ExpressionAttributeNames = condition.Names
+	}
+	if len(condition.Values) > 0 {
+		putItemInput.ExpressionAttributeValues = condition.Values
+	}
+
+	_, err = client.PutItem(ctx, putItemInput)
+	return conditionError(err)
+}
+
+// ConditionalPut is a single conditional put of a transaction.
+type ConditionalPut struct {
+	TableName string
+	Item      interface{}
+	Condition WriteCondition
+}
+
+// TransactPutItems marshals the items and stores them all or none, each one only if its
+// condition holds. It returns ErrConditionFailed when any of the conditions is rejected.
+func TransactPutItems(ctx context.Context, client DynamoDBAPI, puts []ConditionalPut) error {
+	items := make([]types.TransactWriteItem, 0, len(puts))
+	for _, put := range puts {
+		av, err := attributevalue.MarshalMap(put.Item)
+		if err != nil {
+			return err
+		}
+
+		transactPut := &types.Put{
+			TableName:           aws.String(put.TableName),
+			Item:                av,
+			ConditionExpression: aws.String(put.Condition.Expression),
+		}
+		if len(put.Condition.Names) > 0 {
+			transactPut.ExpressionAttributeNames = put.Condition.Names
+		}
+		if len(put.Condition.Values) > 0 {
+			transactPut.ExpressionAttributeValues = put.Condition.Values
+		}
+		items = append(items, types.TransactWriteItem{Put: transactPut})
+	}
+
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return transactionError(err)
+}
+
+// transactionError translates a transaction canceled by a failed condition into ErrConditionFailed.
+func transactionError(err error) error {
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return ErrConditionFailed
+			}
+		}
+	}
+	return err
+}
+
+// conditionError translates a ConditionalCheckFailedException into ErrConditionFailed.
+func conditionError(err error) error {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return ErrConditionFailed
+	}
+	return err
+}
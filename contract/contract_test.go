@@ -0,0 +1,135 @@
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"vassistant-backend/admin"
+	"vassistant-backend/api"
+	"vassistant-backend/cache"
+	"vassistant-backend/devices"
+	"vassistant-backend/financial"
+	"vassistant-backend/fx"
+	"vassistant-backend/messages"
+	"vassistant-backend/notifications"
+	"vassistant-backend/plans"
+	"vassistant-backend/referrals"
+	"vassistant-backend/routes"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current responses")
+
+// Fixture is a recorded API Gateway request replayed through the router.
+type Fixture struct {
+	Request events.APIGatewayProxyRequest `json:"request"`
+	// Volatile lists the response body fields whose values change on every call, like generated IDs.
+	Volatile []string `json:"volatile"`
+}
+
+// Golden is the expected response for a fixture.
+type Golden struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers"`
+	Body       interface{}       `json:"body"`
+}
+
+func TestContracts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "requests", "*.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			// Every fixture runs against freshly seeded tables
+			fake, err := testutil.NewFakeDynamoDB(loadSeed(t))
+			assert.NoError(t, err)
+			fx.DynamoDbClient = fake
+			notifications.DynamoDbClient = fake
+			routes.ResponseCache = cache.NewMemoryStore()
+
+			var fixture Fixture
+			readJSON(t, path, &fixture)
+
+			router := api.NewRouter()
+			router.SetBasePath(routes.DefaultBasePath)
+			financialHandlers := financial.NewHandlers(fake)
+			routes.Register(router, routes.Handlers{Financial: financialHandlers, Messages: messages.NewHandlers(fake, financialHandlers), Plans: plans.NewHandlers(fake), Admin: admin.NewHandlers(fake), Devices: devices.NewHandlers(fake), Referrals: referrals.NewHandlers(fake), Candidates: financial.NewHandlers(fake)})
+			response, err := router.Serve(fixture.Request)
+			assert.NoError(t, err)
+
+			var got bytes.Buffer
+			encoder := json.NewEncoder(&got)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(Golden{
+				StatusCode: response.StatusCode,
+				Headers:    response.Headers,
+				Body:       normalize(decodeBody(t, response.Body), fixture.Volatile),
+			})
+			assert.NoError(t, err)
+
+			goldenPath := filepath.Join("testdata", "golden", name+".json")
+			if *update {
+				assert.NoError(t, os.WriteFile(goldenPath, got.Bytes(), 0o644))
+			}
+
+			want, err := os.ReadFile(goldenPath)
+			assert.NoError(t, err, "missing golden file, run go test ./contract -update")
+			assert.JSONEq(t, string(want), got.String())
+		})
+	}
+}
+
+func loadSeed(t *testing.T) map[string][]map[string]interface{} {
+	var seed map[string][]map[string]interface{}
+	readJSON(t, filepath.Join("testdata", "seed.json"), &seed)
+	return seed
+}
+
+func readJSON(t *testing.T, path string, value interface{}) {
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	assert.NoError(t, decoder.Decode(value))
+}
+
+func decodeBody(t *testing.T, body string) interface{} {
+	if body == "" {
+		return nil
+	}
+	var document interface{}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	assert.NoError(t, decoder.Decode(&document))
+	return document
+}
+
+// normalize replaces the values of volatile fields so the body can be compared.
+func normalize(document interface{}, volatile []string) interface{} {
+	switch value := document.(type) {
+	case []interface{}:
+		for i, element := range value {
+			value[i] = normalize(element, volatile)
+		}
+	case map[string]interface{}:
+		for key, fieldValue := range value {
+			if slices.Contains(volatile, key) {
+				value[key] = "<volatile>"
+			} else {
+				value[key] = normalize(fieldValue, volatile)
+			}
+		}
+	}
+	return document
+}
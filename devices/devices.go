@@ -0,0 +1,352 @@
+// Package devices provisions a signing key per installation of the mobile apps and checks
+// the HMAC signatures of their requests, so a token copied out of the app can't be replayed
+// by another one. The mutating routes verify the signatures sent, and with
+// REQUEST_SIGNATURES=on reject the requests without one.
+package devices
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"vassistant-backend/api"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/integrations/verify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// Handlers serves the device routes and verifies the signed requests with the DynamoDB
+// client storing the device keys. Their secrets are encrypted at rest when the client
+// encrypts the device-keys table.
+type Handlers struct {
+	client common.DynamoDBAPI
+}
+
+// NewHandlers creates the device handlers storing the keys through the client.
+func NewHandlers(client common.DynamoDBAPI) *Handlers {
+	return &Handlers{client: client}
+}
+
+// Required rejects the unsigned requests to the mutating routes, instead of only verifying
+// the signed ones.
+var Required bool
+
+// Replays remembers the signatures already received, shared by the instances once set to a
+// verify.DynamoDBReplayCache.
+var Replays verify.ReplayCache = verify.NewMemoryReplayCache()
+
+// keysTable holds the device keys, keyed by userId and deviceId.
+const keysTable = "device-keys"
+
+// The headers of the signed requests.
+const (
+	DeviceHeader    = "X-Device-Id"
+	TimestampHeader = "X-Signature-Timestamp"
+	SignatureHeader = "X-Signature"
+)
+
+// maxDevicesPerUser bounds how many devices a user can have.
+const maxDevicesPerUser = 10
+
+// maxDeviceNameLength bounds the name telling the devices of a user apart.
+const maxDeviceNameLength = 100
+
+// maxAuthAge is how recently the user must have signed in to provision a device without the
+// signature of one of their devices.
+const maxAuthAge = 5 * time.Minute
+
+// errUnknownDevice is returned when a request is signed by a device the user doesn't have.
+var errUnknownDevice = errors.New("unknown device")
+
+// DeviceKey struct for the device-keys table. The secret is returned once, when the device
+// is provisioned.
+type DeviceKey struct {
+	UserID    string `json:"-" dynamodbav:"userId"`
+	DeviceID  string `json:"deviceId" dynamodbav:"deviceId"`
+	Name      string `json:"name" dynamodbav:"name"`
+	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+	Secret    string `json:"-" dynamodbav:"secret"`
+}
+
+// ProvisionedDevice is the response of the provisioning, the only one holding the secret.
+type ProvisionedDevice struct {
+	DeviceKey
+	Secret string `json:"secret"`
+}
+
+// DeviceRequest struct for the provision device request body
+type DeviceRequest struct {
+	Name string `json:"name"`
+}
+
+// signedMessage is what a device signs: the timestamp in Unix seconds, the method, the path
+// and the body of the request, on a line each.
+func signedMessage(request events.APIGatewayProxyRequest, timestamp, body string) string {
+	return timestamp + "\n" + request.HTTPMethod + "\n" + request.Path + "\n" + body
+}
+
+// scheme verifies the requests of a device, signed with an HMAC-SHA256 keyed with its secret.
+func scheme(key DeviceKey, request events.APIGatewayProxyRequest) verify.HMACScheme {
+	return verify.HMACScheme{
+		Secret:          []byte(key.Secret),
+		SignatureHeader: SignatureHeader,
+		TimestampHeader: TimestampHeader,
+		Message: func(timestamp, body string) string {
+			return signedMessage(request, timestamp, body)
+		},
+	}
+}
+
+func (h *Handlers) getKey(ctx context.Context, userId, deviceId string) (*DeviceKey, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(keysTable),
+		Key: map[string]types.AttributeValue{
+			"userId":   &types.AttributeValueMemberS{Value: userId},
+			"deviceId": &types.AttributeValueMemberS{Value: deviceId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var key DeviceKey
+	err = attributevalue.UnmarshalMap(result.Item, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (h *Handlers) listKeys(ctx context.Context, userId string) ([]DeviceKey, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(keysTable),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := []DeviceKey{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Signed verifies the device signatures of the requests to the mutating routes, unless the
+// route is Unsigned. The requests without claims are left to the handler, as there is no
+// user to look the device up for.
+func (h *Handlers) Signed(route api.Route, next api.HandlerFunc) api.HandlerFunc {
+	if route.ReadOnly || route.Unsigned {
+		return next
+	}
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		ctx := context.TODO()
+		claims, err := auth.ParseClaims(request)
+		if err != nil {
+			return next(request)
+		}
+
+		deviceId := strings.TrimSpace(common.Header(request, DeviceHeader))
+		if deviceId == "" {
+			if Required {
+				return common.CreateCodedErrorResponse(401, "signature_required", "Unauthorized: The request must be signed by the device")
+			}
+			return next(request)
+		}
+
+		err = h.verifySignature(ctx, claims.Sub, deviceId, request)
+		if err != nil {
+			return rejectSignature(claims.Sub, deviceId, err)
+		}
+		return next(request)
+	}
+}
+
+// verifySignature verifies the request was signed by the device of the user.
+func (h *Handlers) verifySignature(ctx context.Context, userId, deviceId string, request events.APIGatewayProxyRequest) error {
+	key, err := h.getKey(ctx, userId, deviceId)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errUnknownDevice
+	}
+	return verify.NewVerifier("device", scheme(*key, request), Replays).Verify(ctx, request)
+}
+
+// rejectSignature answers a request whose device signature failed verification with err.
+func rejectSignature(userId, deviceId string, err error) (events.APIGatewayProxyResponse, error) {
+	switch {
+	case errors.Is(err, errUnknownDevice):
+		log.Printf("Rejected request of user %s signed by unknown device %s", userId, deviceId)
+		return common.CreateCodedErrorResponse(401, "invalid_signature", "Unauthorized: Invalid signature")
+	case errors.Is(err, verify.ErrReplayed):
+		log.Printf("Rejected replayed request of device %s", deviceId)
+		return common.CreateCodedErrorResponse(409, "replayed_request", "Request already received")
+	case errors.Is(err, verify.ErrMissingSignature), errors.Is(err, verify.ErrInvalidSignature), errors.Is(err, verify.ErrStaleTimestamp):
+		log.Printf("Rejected request of device %s: %v", deviceId, err)
+		return common.CreateCodedErrorResponse(401, "invalid_signature", "Unauthorized: Invalid signature")
+	default:
+		log.Printf("Error verifying request of device %s: %v", deviceId, err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+}
+
+func (h *Handlers) GetDevicesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	keys, err := h.listKeys(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error querying device keys from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return jsonResponse(200, keys)
+}
+
+// PostDeviceHandler provisions the signing key of a new installation of the app. The
+// secret is generated here and returned once, for the app to keep in the keystore. The new
+// device must be vouched for by one of the devices of the user, signing the request, or by
+// a sign-in of the user moments ago, so a stolen token alone can't provision one.
+func (h *Handlers) PostDeviceHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Parse the request body into a DeviceRequest struct
+	var deviceRequest DeviceRequest
+	err = json.Unmarshal([]byte(request.Body), &deviceRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	name := strings.TrimSpace(deviceRequest.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxDeviceNameLength {
+		return common.CreateErrorResponse(400, "Invalid device name")
+	}
+
+	if deviceId := strings.TrimSpace(common.Header(request, DeviceHeader)); deviceId != "" {
+		err = h.verifySignature(ctx, claims.Sub, deviceId, request)
+		if err != nil {
+			return rejectSignature(claims.Sub, deviceId, err)
+		}
+	} else if time.Since(claims.AuthTime) > maxAuthAge {
+		log.Printf("Rejected device of user %s signed in at %s", claims.Sub, claims.AuthTime)
+		return common.CreateCodedErrorResponse(401, "reauthentication_required", "Unauthorized: Sign in again, or sign the request with one of your devices")
+	}
+
+	keys, err := h.listKeys(ctx, claims.Sub)
+	if err != nil {
+		log.Printf("Error querying device keys from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if len(keys) >= maxDevicesPerUser {
+		return common.CreateErrorResponse(409, "Too many devices, remove one first")
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		log.Printf("Error generating device key: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	key := DeviceKey{
+		UserID:    claims.Sub,
+		DeviceID:  uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Secret:    base64.RawURLEncoding.EncodeToString(random),
+	}
+	err = common.ConditionalPutItem(ctx, h.client, keysTable, key, common.IfNotExists("deviceId"))
+	if err != nil {
+		log.Printf("Error putting device key into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Successfully provisioned device %s for user %s", key.DeviceID, claims.Sub)
+	return jsonResponse(201, ProvisionedDevice{DeviceKey: key, Secret: key.Secret})
+}
+
+// DeleteDeviceHandler revokes the key of a device, whose signed requests are rejected from
+// then on.
+func (h *Handlers) DeleteDeviceHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract deviceId from path parameters
+	deviceId := request.PathParameters["deviceId"]
+	if deviceId == "" {
+		return common.CreateErrorResponse(400, "Device ID is missing")
+	}
+
+	key, err := h.getKey(ctx, claims.Sub, deviceId)
+	if err != nil {
+		log.Printf("Error getting device key from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if key == nil {
+		return common.CreateErrorResponse(404, "Device not found")
+	}
+
+	_, err = h.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(keysTable),
+		Key: map[string]types.AttributeValue{
+			"userId":   &types.AttributeValueMemberS{Value: claims.Sub},
+			"deviceId": &types.AttributeValueMemberS{Value: deviceId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting device key from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+func jsonResponse(statusCode int, value interface{}) (events.APIGatewayProxyResponse, error) {
+	// Marshal the value into JSON for the payload
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshalling response:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,134 @@
+package devices
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/integrations/verify"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigned(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	Replays = verify.NewMemoryReplayCache()
+	defer func() { Required = false }()
+
+	// The secret is only returned when the device is provisioned
+	response, err := h.PostDeviceHandler(testutil.NewRequest("POST", "/devices").
+		WithClaims("user-1", "alice").
+		WithClaim("auth_time", strconv.FormatInt(time.Now().Unix(), 10)).
+		WithJSONBody(t, DeviceRequest{Name: "Pixel 8"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var provisioned ProvisionedDevice
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &provisioned))
+	assert.NotEmpty(t, provisioned.Secret)
+
+	response, err = h.GetDevicesHandler(testutil.NewRequest("GET", "/devices").WithClaims("user-1", "alice").Build())
+	assert.NoError(t, err)
+	assert.Contains(t, response.Body, provisioned.DeviceID)
+	assert.NotContains(t, response.Body, provisioned.Secret)
+
+	next := func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusCreated}, nil
+	}
+	handler := h.Signed(api.Route{Method: "POST"}, next)
+	send := func(userId, deviceId, secret, body string, signedAt time.Time) int {
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "\nPOST\n/financial/groups/group-1/expenses\n" + body))
+		builder := testutil.NewRequest("POST", "/financial/groups/group-1/expenses").WithClaims(userId, "alice").WithBody(body)
+		if deviceId != "" {
+			builder = builder.
+				WithHeader(DeviceHeader, deviceId).
+				WithHeader(TimestampHeader, timestamp).
+				WithHeader(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		}
+		response, err := handler(builder.Build())
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+
+	// The signed requests go through once, the others are rejected
+	now := time.Now()
+	assert.Equal(t, http.StatusCreated, send("user-1", provisioned.DeviceID, provisioned.Secret, `{"amount":"10"}`, now))
+	assert.Equal(t, http.StatusConflict, send("user-1", provisioned.DeviceID, provisioned.Secret, `{"amount":"10"}`, now))
+	assert.Equal(t, http.StatusUnauthorized, send("user-1", provisioned.DeviceID, "stolen", `{"amount":"10"}`, now))
+	assert.Equal(t, http.StatusUnauthorized, send("user-1", provisioned.DeviceID, provisioned.Secret, `{"amount":"10"}`, now.Add(-time.Hour)))
+	assert.Equal(t, http.StatusUnauthorized, send("user-2", provisioned.DeviceID, provisioned.Secret, `{"amount":"10"}`, now))
+
+	// The unsigned requests go through unless the signatures are required
+	assert.Equal(t, http.StatusCreated, send("user-1", "", "", `{"amount":"10"}`, now))
+	Required = true
+	assert.Equal(t, http.StatusUnauthorized, send("user-1", "", "", `{"amount":"10"}`, now))
+
+	// The read-only and unsigned routes aren't checked
+	for _, route := range []api.Route{{Method: "GET", ReadOnly: true}, {Method: "POST", Unsigned: true}} {
+		response, err = h.Signed(route, next)(testutil.NewRequest(route.Method, "/devices").WithClaims("user-1", "alice").Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.StatusCode)
+	}
+
+	// A revoked device can't sign anymore
+	response, err = h.DeleteDeviceHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("deviceId", provisioned.DeviceID).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, send("user-1", provisioned.DeviceID, provisioned.Secret, `{"amount":"12"}`, now))
+}
+
+func TestPostDeviceHandlerVouched(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	Replays = verify.NewMemoryReplayCache()
+
+	provision := func(authTime time.Time, deviceId, secret string) events.APIGatewayProxyResponse {
+		body := `{"name":"Pixel 8"}`
+		builder := testutil.NewRequest("POST", "/devices").WithClaims("user-1", "alice").WithBody(body)
+		if !authTime.IsZero() {
+			builder = builder.WithClaim("auth_time", strconv.FormatInt(authTime.Unix(), 10))
+		}
+		if deviceId != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(timestamp + "\nPOST\n/devices\n" + body))
+			builder = builder.
+				WithHeader(DeviceHeader, deviceId).
+				WithHeader(TimestampHeader, timestamp).
+				WithHeader(SignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		}
+		response, err := h.PostDeviceHandler(builder.Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// A token alone can't provision a device
+	assert.Equal(t, http.StatusUnauthorized, provision(time.Time{}, "", "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, provision(time.Now().Add(-time.Hour), "", "").StatusCode)
+
+	// A fresh sign-in can
+	response := provision(time.Now(), "", "")
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var provisioned ProvisionedDevice
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &provisioned))
+
+	// And so can one of the devices of the user, however long ago they signed in
+	assert.Equal(t, http.StatusCreated, provision(time.Time{}, provisioned.DeviceID, provisioned.Secret).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, provision(time.Time{}, provisioned.DeviceID, "stolen").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, provision(time.Time{}, "unknown-device", provisioned.Secret).StatusCode)
+}
@@ -0,0 +1,143 @@
+// Package embeddings turns texts like the messages and the expense titles into vectors, and
+// stores them so they can be searched by meaning rather than by their words. The vectors are
+// computed by Bedrock and kept in DynamoDB next to the items they point to, without the texts,
+// which stay where they are encrypted.
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ErrNotConfigured is returned when no embedder or store is configured.
+var ErrNotConfigured = errors.New("embeddings are not configured")
+
+// DefaultModel is the Bedrock model of the embeddings unless configured otherwise.
+const DefaultModel = "amazon.titan-embed-text-v2:0"
+
+// DefaultDimensions is the length of the vectors asked to Bedrock, enough to tell short texts
+// apart while keeping the items small.
+const DefaultDimensions = 512
+
+// maxInputLength bounds the characters embedded, within what the Titan models accept.
+const maxInputLength = 20000
+
+// Embedder computes the vectors of texts.
+type Embedder interface {
+	// Embed returns the vector of the text, normalized to unit length.
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// Model names the model of the vectors, as those of different models can't be compared.
+	Model() string
+}
+
+// DefaultEmbedder computes the vectors indexed and searched. It is nil until one is
+// configured, and nothing is indexed until then.
+var DefaultEmbedder Embedder
+
+// BedrockEmbedder computes the vectors with a Titan text embeddings model of Bedrock, calling
+// the InvokeModel API signed with SigV4.
+type BedrockEmbedder struct {
+	ModelID     string
+	Dimensions  int
+	Endpoint    string // the Bedrock runtime endpoint of the region by default
+	Region      string
+	Credentials aws.CredentialsProvider
+	HTTPClient  *http.Client
+	signer      *v4.Signer
+}
+
+// NewBedrockEmbedder creates the embedder of the model in the region of the AWS config,
+// signing the requests with its credentials.
+func NewBedrockEmbedder(modelId string, cfg aws.Config) *BedrockEmbedder {
+	return &BedrockEmbedder{
+		ModelID:     modelId,
+		Dimensions:  DefaultDimensions,
+		Endpoint:    fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", cfg.Region),
+		Region:      cfg.Region,
+		Credentials: cfg.Credentials,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		signer:      v4.NewSigner(),
+	}
+}
+
+// titanRequest is the body of the InvokeModel request of the Titan text embeddings models.
+type titanRequest struct {
+	InputText  string `json:"inputText"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	Normalize  bool   `json:"normalize"`
+}
+
+// titanResponse is the body of the InvokeModel response of the Titan text embeddings models.
+type titanResponse struct {
+	Embedding []float32 `json:"embedding"`
+}
+
+func (e *BedrockEmbedder) Model() string {
+	return e.ModelID
+}
+
+func (e *BedrockEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if runes := []rune(text); len(runes) > maxInputLength {
+		text = string(runes[:maxInputLength])
+	}
+	body, err := json.Marshal(titanRequest{InputText: text, Dimensions: e.Dimensions, Normalize: true})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(e.Endpoint, "/") + "/model/" + url.PathEscape(e.ModelID) + "/invoke"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	if e.Credentials != nil {
+		credentials, err := e.Credentials.Retrieve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(body)
+		signer := e.signer
+		if signer == nil {
+			signer = v4.NewSigner()
+		}
+		err = signer.SignHTTP(ctx, credentials, request, hex.EncodeToString(hash[:]), "bedrock", e.Region, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := e.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return nil, fmt.Errorf("Bedrock InvokeModel of %s returned %s: %s", e.ModelID, response.Status, message)
+	}
+	var result titanResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Embedding) == 0 {
+		return nil, fmt.Errorf("Bedrock InvokeModel of %s returned no embedding", e.ModelID)
+	}
+	return result.Embedding, nil
+}
@@ -0,0 +1,129 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBedrockEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/model/amazon.titan-embed-text-v2:0/invoke", r.URL.Path)
+		var request titanRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, DefaultDimensions, request.Dimensions)
+		assert.True(t, request.Normalize)
+		if request.InputText == "fail" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"embedding": []float32{0.6, 0.8}, "inputTextTokenCount": 2})
+	}))
+	defer server.Close()
+	embedder := &BedrockEmbedder{ModelID: DefaultModel, Dimensions: DefaultDimensions, Endpoint: server.URL, HTTPClient: server.Client()}
+
+	vector, err := embedder.Embed(context.TODO(), "Dinner at Luigi's")
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{0.6, 0.8}, vector)
+
+	_, err = embedder.Embed(context.TODO(), "fail")
+	assert.Error(t, err)
+}
+
+func TestSearch(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	store := NewStore(fake)
+	DefaultEmbedder = Stub{}
+	defer func() { DefaultEmbedder = nil }()
+
+	ctx := context.TODO()
+	user, group := UserNamespace("user-1"), GroupNamespace("group-1")
+	assert.NoError(t, store.Index(ctx, user, Item{Kind: KindMessage, ID: "m1", Ref: "2024-05-01T10:00:00Z"}, "When is the rent due?"))
+	assert.NoError(t, store.Index(ctx, user, Item{Kind: KindMessage, ID: "m2", Ref: "2024-05-01T11:00:00Z"}, "Book a table for dinner"))
+	assert.NoError(t, store.Index(ctx, group, Item{Kind: KindExpense, ID: "e1"}, "Rent of May"))
+	assert.NoError(t, store.Index(ctx, GroupNamespace("group-2"), Item{Kind: KindExpense, ID: "e2"}, "Rent of the cabin"))
+
+	// The closest items of the namespaces and kinds searched come first
+	matches, err := store.Search(ctx, []string{user, group}, []string{KindMessage, KindExpense}, "rent", 10, 0.1)
+	assert.NoError(t, err)
+	if assert.Len(t, matches, 2) {
+		assert.Equal(t, Item{Kind: KindExpense, ID: "e1"}, matches[0].Item)
+		assert.Equal(t, Item{Kind: KindMessage, ID: "m1", Ref: "2024-05-01T10:00:00Z"}, matches[1].Item)
+		assert.Greater(t, matches[0].Score, matches[1].Score)
+	}
+	matches, err = store.Search(ctx, []string{user, group}, []string{KindMessage}, "rent", 10, 0.1)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+
+	// Indexing again replaces the vector, removing forgets it
+	assert.NoError(t, store.Index(ctx, group, Item{Kind: KindExpense, ID: "e1"}, "Groceries"))
+	matches, err = store.Search(ctx, []string{group}, []string{KindExpense}, "rent", 10, 0.1)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	assert.NoError(t, store.Remove(ctx, user, KindMessage, "m1"))
+	matches, err = store.Search(ctx, []string{user}, []string{KindMessage}, "rent", 10, 0.1)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	// The vectors of another model aren't compared
+	DefaultEmbedder = otherModel{}
+	matches, err = store.Search(ctx, []string{user}, []string{KindMessage}, "dinner", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+// otherModel embeds like the Stub under another model name.
+type otherModel struct{ Stub }
+
+func (otherModel) Model() string { return "other" }
+
+func TestIndexExpenseStreamEvent(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	store := NewStore(fake)
+	DefaultEmbedder = Stub{}
+	defer func() { DefaultEmbedder = nil }()
+
+	keys := map[string]events.DynamoDBAttributeValue{
+		"groupId":   events.NewStringAttribute("group-1"),
+		"expenseId": events.NewStringAttribute("expense-1"),
+	}
+	image := func(title string) map[string]events.DynamoDBAttributeValue {
+		return map[string]events.DynamoDBAttributeValue{
+			"groupId":   events.NewStringAttribute("group-1"),
+			"expenseId": events.NewStringAttribute("expense-1"),
+			"title":     events.NewStringAttribute(title),
+		}
+	}
+	search := func(query string) []Match {
+		matches, err := store.Search(context.TODO(), []string{GroupNamespace("group-1")}, []string{KindExpense}, query, 10, 0.5)
+		assert.NoError(t, err)
+		return matches
+	}
+
+	err = store.IndexExpenseStreamEvent(context.TODO(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{EventName: "INSERT", Change: events.DynamoDBStreamRecord{Keys: keys, NewImage: image("Taxi to the airport")}},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, search("airport taxi"), 1)
+
+	err = store.IndexExpenseStreamEvent(context.TODO(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{EventName: "MODIFY", Change: events.DynamoDBStreamRecord{Keys: keys, OldImage: image("Taxi to the airport"), NewImage: image("Hotel room")}},
+	}})
+	assert.NoError(t, err)
+	assert.Empty(t, search("airport taxi"))
+	assert.Len(t, search("hotel room"), 1)
+
+	err = store.IndexExpenseStreamEvent(context.TODO(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		{EventName: "REMOVE", Change: events.DynamoDBStreamRecord{Keys: keys, OldImage: image("Hotel room")}},
+	}})
+	assert.NoError(t, err)
+	assert.Empty(t, search("hotel room"))
+}
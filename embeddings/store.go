@@ -0,0 +1,228 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"slices"
+	"sort"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// table holds the vectors, keyed by namespace and item. The namespace is the owner of the
+// items, the user or the group, so a search reads the vectors of its owners only.
+const table = "embeddings"
+
+// Kinds of the items embedded
+const (
+	KindMessage = "message"
+	KindMemory  = "memory"
+	KindExpense = "expense"
+)
+
+// Store reads and writes the vectors on the embeddings table with the DynamoDB client it was
+// created with. The vectors hold no text, so the table isn't encrypted.
+type Store struct {
+	client common.DynamoDBAPI
+}
+
+// NewStore creates the store of the vectors reading and writing through the client.
+func NewStore(client common.DynamoDBAPI) *Store {
+	return &Store{client: client}
+}
+
+// Item is an item embedded: its kind, its ID and where it is found, like the createdAt sort
+// key of a message.
+type Item struct {
+	Kind string
+	ID   string
+	Ref  string
+}
+
+// Entry struct for the embeddings table
+type Entry struct {
+	Namespace string `dynamodbav:"namespace"`
+	ItemID    string `dynamodbav:"itemId"` // the kind and the ID of the item
+	Kind      string `dynamodbav:"kind"`
+	ID        string `dynamodbav:"id"`
+	Ref       string `dynamodbav:"ref,omitempty"`
+	Model     string `dynamodbav:"model"`
+	Vector    []byte `dynamodbav:"vector"` // little-endian float32s
+	IndexedAt string `dynamodbav:"indexedAt"`
+}
+
+// Match is an item found by a search, with the cosine similarity of its vector to the one
+// of the query.
+type Match struct {
+	Namespace string
+	Item
+	Score float64
+}
+
+// UserNamespace is the namespace of the items of the user, like their messages.
+func UserNamespace(userId string) string {
+	return "user#" + userId
+}
+
+// GroupNamespace is the namespace of the items of the group, like its expenses.
+func GroupNamespace(groupId string) string {
+	return "group#" + groupId
+}
+
+// Enabled reports whether the items are embedded, with an embedder and a store configured.
+func (s *Store) Enabled() bool {
+	return DefaultEmbedder != nil && s.client != nil
+}
+
+// Index embeds the text of the item and stores its vector in the namespace, replacing the
+// vector of its previous text. It does nothing unless Enabled.
+func (s *Store) Index(ctx context.Context, namespace string, item Item, text string) error {
+	if !s.Enabled() {
+		return nil
+	}
+	vector, err := DefaultEmbedder.Embed(ctx, text)
+	if err != nil {
+		return err
+	}
+	entry, err := attributevalue.MarshalMap(Entry{
+		Namespace: namespace,
+		ItemID:    item.Kind + "#" + item.ID,
+		Kind:      item.Kind,
+		ID:        item.ID,
+		Ref:       item.Ref,
+		Model:     DefaultEmbedder.Model(),
+		Vector:    encodeVector(vector),
+		IndexedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(table), Item: entry})
+	return err
+}
+
+// Remove removes the vector of the item from the namespace, e.g. once the item is deleted.
+func (s *Store) Remove(ctx context.Context, namespace, kind, id string) error {
+	if s.client == nil {
+		return nil
+	}
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"namespace": &types.AttributeValueMemberS{Value: namespace},
+			"itemId":    &types.AttributeValueMemberS{Value: kind + "#" + id},
+		},
+	})
+	return err
+}
+
+// Search returns the items of the kinds in the namespaces closest in meaning to the query,
+// the closest first, at most limit of them and none below minScore. The vectors of each
+// namespace are compared one by one, which is fast enough for the items of a user or a group.
+func (s *Store) Search(ctx context.Context, namespaces []string, kinds []string, query string, limit int, minScore float64) ([]Match, error) {
+	if !s.Enabled() {
+		return nil, ErrNotConfigured
+	}
+	vector, err := DefaultEmbedder.Embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	model := DefaultEmbedder.Model()
+
+	matches := []Match{}
+	for _, namespace := range namespaces {
+		entries, err := s.queryEntries(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			// The vectors of another model are skipped until the item is indexed again
+			if entry.Model != model || !slices.Contains(kinds, entry.Kind) {
+				continue
+			}
+			score := cosine(vector, decodeVector(entry.Vector))
+			if score >= minScore {
+				matches = append(matches, Match{Namespace: namespace, Item: Item{Kind: entry.Kind, ID: entry.ID, Ref: entry.Ref}, Score: score})
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// queryEntries returns the entries of the namespace, following the pages of the query.
+func (s *Store) queryEntries(ctx context.Context, namespace string) ([]Entry, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("#namespace = :namespace"),
+		ExpressionAttributeNames: map[string]string{
+			"#namespace": "namespace",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":namespace": &types.AttributeValueMemberS{Value: namespace},
+		},
+	}
+
+	var entries []Entry
+	for {
+		result, err := s.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var page []Entry
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func encodeVector(vector []float32) []byte {
+	encoded := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(value))
+	}
+	return encoded
+}
+
+func decodeVector(encoded []byte) []float32 {
+	vector := make([]float32, len(encoded)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return vector
+}
+
+// cosine returns the cosine similarity of the vectors, 0 when their lengths differ or either
+// is zero.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
@@ -0,0 +1,45 @@
+package embeddings
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// IndexExpenseStreamEvent applies the records of a splitter-expenses stream event to the
+// vectors of the expense titles, embedding the titles added or changed and removing those of
+// the expenses deleted. Records applied again only embed the titles again.
+func (s *Store) IndexExpenseStreamEvent(ctx context.Context, event events.DynamoDBEvent) error {
+	for _, record := range event.Records {
+		groupId := record.Change.Keys["groupId"].String()
+		expenseId := record.Change.Keys["expenseId"].String()
+		switch events.DynamoDBOperationType(record.EventName) {
+		case events.DynamoDBOperationTypeInsert, events.DynamoDBOperationTypeModify:
+			title := streamString(record.Change.NewImage, "title")
+			if title == "" || (record.Change.OldImage != nil && streamString(record.Change.OldImage, "title") == title) {
+				continue
+			}
+			err := s.Index(ctx, GroupNamespace(groupId), Item{Kind: KindExpense, ID: expenseId}, title)
+			if err != nil {
+				return err
+			}
+			log.Printf("Embedded the title of expense %s of group %s", expenseId, groupId)
+		case events.DynamoDBOperationTypeRemove:
+			err := s.Remove(ctx, GroupNamespace(groupId), KindExpense, expenseId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// streamString returns the string attribute of the stream image, or "" if it isn't one.
+func streamString(image map[string]events.DynamoDBAttributeValue, name string) string {
+	value, ok := image[name]
+	if !ok || value.DataType() != events.DataTypeString {
+		return ""
+	}
+	return value.String()
+}
@@ -0,0 +1,52 @@
+package embeddings
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// StubModel is the model of the vectors of the Stub embedder.
+const StubModel = "stub"
+
+// stubDimensions is the length of the vectors of the Stub embedder.
+const stubDimensions = 64
+
+// Stub computes the vectors from the words of the texts, each word hashed to a dimension,
+// without calling any model, for the tests and the end-to-end tests of the staging stages.
+// Texts sharing words are close, whatever their meaning.
+type Stub struct{}
+
+func (Stub) Model() string {
+	return StubModel
+}
+
+func (Stub) Embed(ctx context.Context, text string) ([]float32, error) {
+	vector := make([]float32, stubDimensions)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		hash := fnv.New32a()
+		hash.Write([]byte(word))
+		vector[hash.Sum32()%stubDimensions]++
+	}
+	return normalize(vector), nil
+}
+
+// normalize scales the vector to unit length, leaving the zero vector as it is.
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
@@ -0,0 +1,324 @@
+// Package encryption encrypts chosen attributes of the DynamoDB items at rest, like the
+// content of the messages, with a data key per owner generated by KMS (envelope
+// encryption). The EncryptingDynamoDB client encrypts the attributes when writing and
+// decrypts them when reading, so the handlers are unaware of it. It is enabled with
+// ENCRYPTION_KMS_KEY_ID.
+//
+// Encrypted attributes can't be used in key, filter or condition expressions. Attributes
+// written before the encryption was enabled are read as they are.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// prefix marks the encrypted values, followed by the owner and the sealed value.
+const prefix = "enc:v1:"
+
+// ErrCorrupted is returned for encrypted values which can't be decrypted.
+var ErrCorrupted = errors.New("encrypted attribute is corrupted")
+
+// Table lists the encrypted attributes of a table. The data key is the one of the owner of
+// the item, named by its OwnerKey attribute.
+type Table struct {
+	OwnerKey   string
+	Attributes []string
+}
+
+// DefaultTables are the tables with encrypted attributes: the messages, the summaries of
+// the conversations quoting them, the facts remembered from them and the signing keys of
+// the devices have a key per user, the expense notes, the group chat and the tokens of the
+// linked spreadsheet a key per group as every member reads or writes with them.
+var DefaultTables = map[string]Table{
+	"assistant-memories":   {OwnerKey: "userId", Attributes: []string{"fact"}},
+	"chat":                 {OwnerKey: "userId", Attributes: []string{"content"}},
+	"chat-conversations":   {OwnerKey: "userId", Attributes: []string{"lastMessage"}},
+	"device-keys":          {OwnerKey: "userId", Attributes: []string{"secret"}},
+	"splitter-expenses":    {OwnerKey: "groupId", Attributes: []string{"notes"}},
+	"splitter-group-chat":  {OwnerKey: "groupId", Attributes: []string{"content"}},
+	"splitter-sheet-links": {OwnerKey: "groupId", Attributes: []string{"accessToken", "refreshToken"}},
+}
+
+// EncryptingDynamoDB wraps a DynamoDB client, encrypting the attributes of the Tables in the
+// items written and decrypting them in the items read.
+type EncryptingDynamoDB struct {
+	Client common.DynamoDBAPI
+	Keys   *DataKeys
+	Tables map[string]Table
+}
+
+// NewEncryptingDynamoDB creates an EncryptingDynamoDB encrypting the DefaultTables.
+func NewEncryptingDynamoDB(client common.DynamoDBAPI, keys *DataKeys) *EncryptingDynamoDB {
+	return &EncryptingDynamoDB{Client: client, Keys: keys, Tables: DefaultTables}
+}
+
+// FromEnv wraps the client in an EncryptingDynamoDB when ENCRYPTION_KMS_KEY_ID is set, the
+// data keys being generated under that KMS key and stored in ENCRYPTION_KEYS_TABLE with
+// the client. It returns the client itself when the encryption is disabled.
+func FromEnv(cfg aws.Config, client common.DynamoDBAPI) (common.DynamoDBAPI, error) {
+	keyId := os.Getenv("ENCRYPTION_KMS_KEY_ID")
+	if keyId == "" {
+		return client, nil
+	}
+	table := os.Getenv("ENCRYPTION_KEYS_TABLE")
+	if table == "" {
+		return nil, errors.New("ENCRYPTION_KMS_KEY_ID needs ENCRYPTION_KEYS_TABLE")
+	}
+	keys := NewDataKeys(client, table, &KMSKeyService{Client: kms.NewFromConfig(cfg), KeyID: keyId})
+	return NewEncryptingDynamoDB(client, keys), nil
+}
+
+// additionalData binds a sealed value to where it is stored, so it can't be copied to
+// another owner or attribute.
+func additionalData(table, attribute, owner string) []byte {
+	return []byte(table + "|" + attribute + "|" + owner)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the value with AES-GCM under the key of the owner.
+func seal(key []byte, table, attribute, owner, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), additionalData(table, attribute, owner))
+	return prefix + base64.RawURLEncoding.EncodeToString([]byte(owner)) + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// splitSealed returns the owner and the sealed bytes of an encrypted value.
+func splitSealed(value string) (string, []byte, error) {
+	encodedOwner, encodedSealed, ok := strings.Cut(strings.TrimPrefix(value, prefix), ".")
+	if !ok {
+		return "", nil, ErrCorrupted
+	}
+	owner, err := base64.RawURLEncoding.DecodeString(encodedOwner)
+	if err != nil {
+		return "", nil, ErrCorrupted
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encodedSealed)
+	if err != nil {
+		return "", nil, ErrCorrupted
+	}
+	return string(owner), sealed, nil
+}
+
+// open decrypts a sealed value with the key of its owner.
+func open(key []byte, table, attribute, owner string, sealed []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrCorrupted
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData(table, attribute, owner))
+	if err != nil {
+		return "", ErrCorrupted
+	}
+	return string(plaintext), nil
+}
+
+// encryptItem returns a copy of the item with its encrypted attributes sealed, or the item
+// itself when its table has none.
+func (c *EncryptingDynamoDB) encryptItem(ctx context.Context, table string, item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	spec, ok := c.Tables[table]
+	if !ok {
+		return item, nil
+	}
+	owner, ok := item[spec.OwnerKey].(*types.AttributeValueMemberS)
+	if !ok || owner.Value == "" {
+		return nil, fmt.Errorf("item of %s without %s to encrypt it for", table, spec.OwnerKey)
+	}
+
+	encrypted := make(map[string]types.AttributeValue, len(item))
+	for name, value := range item {
+		encrypted[name] = value
+	}
+	for _, attribute := range spec.Attributes {
+		value, ok := item[attribute].(*types.AttributeValueMemberS)
+		if !ok || value.Value == "" {
+			continue
+		}
+		key, err := c.Keys.Key(ctx, owner.Value)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := seal(key, table, attribute, owner.Value, value.Value)
+		if err != nil {
+			return nil, err
+		}
+		encrypted[attribute] = &types.AttributeValueMemberS{Value: sealed}
+	}
+	return encrypted, nil
+}
+
+// decryptItem returns a copy of the item with its encrypted attributes decrypted, or the
+// item itself when it has none.
+func (c *EncryptingDynamoDB) decryptItem(ctx context.Context, table string, item map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	spec, ok := c.Tables[table]
+	if !ok {
+		return item, nil
+	}
+
+	var decrypted map[string]types.AttributeValue
+	for _, attribute := range spec.Attributes {
+		value, ok := item[attribute].(*types.AttributeValueMemberS)
+		if !ok || !strings.HasPrefix(value.Value, prefix) {
+			continue
+		}
+		owner, sealed, err := splitSealed(value.Value)
+		if err != nil {
+			return nil, err
+		}
+		key, err := c.Keys.Key(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := open(key, table, attribute, owner, sealed)
+		if err != nil {
+			return nil, err
+		}
+		if decrypted == nil {
+			decrypted = make(map[string]types.AttributeValue, len(item))
+			for name, value := range item {
+				decrypted[name] = value
+			}
+		}
+		decrypted[attribute] = &types.AttributeValueMemberS{Value: plaintext}
+	}
+	if decrypted == nil {
+		return item, nil
+	}
+	return decrypted, nil
+}
+
+// decryptItems returns the items with their encrypted attributes decrypted.
+func (c *EncryptingDynamoDB) decryptItems(ctx context.Context, table string, items []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	if _, ok := c.Tables[table]; !ok || len(items) == 0 {
+		return items, nil
+	}
+	decrypted := make([]map[string]types.AttributeValue, len(items))
+	for i, item := range items {
+		var err error
+		decrypted[i], err = c.decryptItem(ctx, table, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decrypted, nil
+}
+
+func (c *EncryptingDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	item, err := c.encryptItem(ctx, aws.ToString(params.TableName), params.Item)
+	if err != nil {
+		return nil, err
+	}
+	input := *params
+	input.Item = item
+	output, err := c.Client.PutItem(ctx, &input, optFns...)
+	if err != nil || output == nil || output.Attributes == nil {
+		return output, err
+	}
+	result := *output
+	result.Attributes, err = c.decryptItem(ctx, aws.ToString(params.TableName), output.Attributes)
+	return &result, err
+}
+
+func (c *EncryptingDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	input := *params
+	input.TransactItems = make([]types.TransactWriteItem, len(params.TransactItems))
+	for i, transactItem := range params.TransactItems {
+		if transactItem.Put != nil {
+			item, err := c.encryptItem(ctx, aws.ToString(transactItem.Put.TableName), transactItem.Put.Item)
+			if err != nil {
+				return nil, err
+			}
+			put := *transactItem.Put
+			put.Item = item
+			transactItem.Put = &put
+		}
+		input.TransactItems[i] = transactItem
+	}
+	return c.Client.TransactWriteItems(ctx, &input, optFns...)
+}
+
+func (c *EncryptingDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	output, err := c.Client.GetItem(ctx, params, optFns...)
+	if err != nil || output == nil || output.Item == nil {
+		return output, err
+	}
+	result := *output
+	result.Item, err = c.decryptItem(ctx, aws.ToString(params.TableName), output.Item)
+	return &result, err
+}
+
+func (c *EncryptingDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	output, err := c.Client.Query(ctx, params, optFns...)
+	if err != nil || output == nil {
+		return output, err
+	}
+	result := *output
+	result.Items, err = c.decryptItems(ctx, aws.ToString(params.TableName), output.Items)
+	return &result, err
+}
+
+func (c *EncryptingDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	output, err := c.Client.Scan(ctx, params, optFns...)
+	if err != nil || output == nil {
+		return output, err
+	}
+	result := *output
+	result.Items, err = c.decryptItems(ctx, aws.ToString(params.TableName), output.Items)
+	return &result, err
+}
+
+func (c *EncryptingDynamoDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	output, err := c.Client.BatchGetItem(ctx, params, optFns...)
+	if err != nil || output == nil {
+		return output, err
+	}
+	result := *output
+	result.Responses = make(map[string][]map[string]types.AttributeValue, len(output.Responses))
+	for table, items := range output.Responses {
+		result.Responses[table], err = c.decryptItems(ctx, table, items)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+func (c *EncryptingDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	output, err := c.Client.DeleteItem(ctx, params, optFns...)
+	if err != nil || output == nil || output.Attributes == nil {
+		return output, err
+	}
+	result := *output
+	result.Attributes, err = c.decryptItem(ctx, aws.ToString(params.TableName), output.Attributes)
+	return &result, err
+}
@@ -0,0 +1,120 @@
+package encryption
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+	"vassistant-backend/common"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKeyService "encrypts" the data keys by prefixing them with their owner, counting the calls.
+type fakeKeyService struct {
+	generated, decrypted int
+}
+
+func (s *fakeKeyService) GenerateDataKey(ctx context.Context, owner string) ([]byte, []byte, error) {
+	s.generated++
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key, append([]byte(owner+":"), key...), nil
+}
+
+func (s *fakeKeyService) Decrypt(ctx context.Context, owner string, encrypted []byte) ([]byte, error) {
+	s.decrypted++
+	key, ok := strings.CutPrefix(string(encrypted), owner+":")
+	if !ok {
+		return nil, errors.New("data key of another owner")
+	}
+	return []byte(key), nil
+}
+
+type message struct {
+	UserId    string `dynamodbav:"userId"`
+	CreatedAt string `dynamodbav:"createdAt"`
+	Content   string `dynamodbav:"content"`
+}
+
+func TestEncryptingDynamoDB(t *testing.T) {
+	// Set up the fake DynamoDB with a message written before the encryption was enabled
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"chat": {{"userId": "user-1", "createdAt": "2024-01-01T00:00:00Z", "content": "legacy"}},
+	})
+	assert.NoError(t, err)
+	service := &fakeKeyService{}
+	client := NewEncryptingDynamoDB(fake, NewDataKeys(fake, "encryption-keys", service))
+
+	// The content is stored encrypted, with a data key per user
+	for _, item := range []message{
+		{UserId: "user-1", CreatedAt: "2024-01-02T00:00:00Z", Content: "my bank PIN is 1234"},
+		{UserId: "user-2", CreatedAt: "2024-01-02T00:00:00Z", Content: "hello"},
+	} {
+		assert.NoError(t, common.ConditionalPutItem(context.TODO(), client, "chat", item, common.IfNotExists("userId")))
+	}
+	assert.Equal(t, 2, service.generated)
+
+	stored, err := fake.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("chat"),
+		Key: map[string]types.AttributeValue{
+			"userId":    &types.AttributeValueMemberS{Value: "user-1"},
+			"createdAt": &types.AttributeValueMemberS{Value: "2024-01-02T00:00:00Z"},
+		},
+	})
+	assert.NoError(t, err)
+	content := stored.Item["content"].(*types.AttributeValueMemberS).Value
+	assert.True(t, strings.HasPrefix(content, prefix))
+	assert.NotContains(t, content, "1234")
+
+	// Reading decrypts the content, and passes the legacy plaintext through
+	result, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("chat"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: "user-1"},
+		},
+	})
+	assert.NoError(t, err)
+	var messages []message
+	assert.NoError(t, attributevalue.UnmarshalListOfMaps(result.Items, &messages))
+	assert.Equal(t, []string{"legacy", "my bank PIN is 1234"}, []string{messages[0].Content, messages[1].Content})
+
+	// A new instance decrypts the stored data key once and keeps it in memory
+	service = &fakeKeyService{}
+	client = NewEncryptingDynamoDB(fake, NewDataKeys(fake, "encryption-keys", service))
+	for i := 0; i < 2; i++ {
+		output, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{TableName: aws.String("chat"), Key: stored.Item})
+		assert.NoError(t, err)
+		assert.Equal(t, "my bank PIN is 1234", output.Item["content"].(*types.AttributeValueMemberS).Value)
+	}
+	assert.Equal(t, 0, service.generated)
+	assert.Equal(t, 1, service.decrypted)
+}
+
+func TestOpenRejectsMovedValues(t *testing.T) {
+	key := make([]byte, 32)
+	sealed, err := seal(key, "chat", "content", "user-1", "secret")
+	assert.NoError(t, err)
+
+	owner, bytes, err := splitSealed(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", owner)
+	plaintext, err := open(key, "chat", "content", "user-1", bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", plaintext)
+
+	// The value can't be read as another attribute or owner
+	_, err = open(key, "chat", "lastMessage", "user-1", bytes)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	_, err = open(key, "chat", "content", "user-2", bytes)
+	assert.ErrorIs(t, err, ErrCorrupted)
+	_, _, err = splitSealed(prefix + "garbage")
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
@@ -0,0 +1,175 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// defaultKeyCacheTTL is how long the decrypted data keys are kept in memory.
+const defaultKeyCacheTTL = 15 * time.Minute
+
+// KeyService generates the data keys of the owners and decrypts them, binding each key to
+// its owner.
+type KeyService interface {
+	GenerateDataKey(ctx context.Context, owner string) (plaintext, encrypted []byte, err error)
+	Decrypt(ctx context.Context, owner string, encrypted []byte) ([]byte, error)
+}
+
+// KMSAPI is the part of the KMS client the KMSKeyService uses.
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeyService generates AES-256 data keys under a KMS key, with the owner as encryption
+// context so a data key can't be decrypted for another owner.
+type KMSKeyService struct {
+	Client KMSAPI
+	KeyID  string
+}
+
+func (s *KMSKeyService) GenerateDataKey(ctx context.Context, owner string) ([]byte, []byte, error) {
+	result, err := s.Client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(s.KeyID),
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: map[string]string{"owner": owner},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Plaintext, result.CiphertextBlob, nil
+}
+
+func (s *KMSKeyService) Decrypt(ctx context.Context, owner string, encrypted []byte) ([]byte, error) {
+	result, err := s.Client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(s.KeyID),
+		CiphertextBlob:    encrypted,
+		EncryptionContext: map[string]string{"owner": owner},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Plaintext, nil
+}
+
+// DataKey struct for the encryption keys table, the data key of an owner encrypted by the
+// KeyService. The plaintext key is never stored.
+type DataKey struct {
+	OwnerID      string `dynamodbav:"ownerId"`
+	EncryptedKey []byte `dynamodbav:"encryptedKey"`
+	CreatedAt    string `dynamodbav:"createdAt"`
+}
+
+type cachedKey struct {
+	key       []byte
+	expiresAt time.Time
+}
+
+// DataKeys returns the data key of each owner, creating it on first use and keeping the
+// decrypted keys in memory for CacheTTL so KMS isn't called for every item.
+type DataKeys struct {
+	Client    common.DynamoDBAPI
+	TableName string
+	Service   KeyService
+	CacheTTL  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+// NewDataKeys creates DataKeys stored in the table, with the default cache duration.
+func NewDataKeys(client common.DynamoDBAPI, tableName string, service KeyService) *DataKeys {
+	return &DataKeys{
+		Client:    client,
+		TableName: tableName,
+		Service:   service,
+		CacheTTL:  defaultKeyCacheTTL,
+		cache:     map[string]cachedKey{},
+	}
+}
+
+// getDataKey returns the stored data key of the owner, or nil if there is none yet.
+func (k *DataKeys) getDataKey(ctx context.Context, owner string) (*DataKey, error) {
+	result, err := k.Client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(k.TableName),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"ownerId": &dynamodbtypes.AttributeValueMemberS{Value: owner},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var dataKey DataKey
+	err = attributevalue.UnmarshalMap(result.Item, &dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey, nil
+}
+
+// Key returns the plaintext data key of the owner.
+func (k *DataKeys) Key(ctx context.Context, owner string) ([]byte, error) {
+	k.mu.Lock()
+	cached, ok := k.cache[owner]
+	k.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.key, nil
+	}
+
+	key, err := k.loadKey(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.cache[owner] = cachedKey{key: key, expiresAt: time.Now().Add(k.CacheTTL)}
+	k.mu.Unlock()
+	return key, nil
+}
+
+// loadKey decrypts the stored data key of the owner, generating and storing one when the
+// owner has none. Of two instances creating the key at once, the first one stored wins.
+func (k *DataKeys) loadKey(ctx context.Context, owner string) ([]byte, error) {
+	dataKey, err := k.getDataKey(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if dataKey != nil {
+		return k.Service.Decrypt(ctx, owner, dataKey.EncryptedKey)
+	}
+
+	plaintext, encrypted, err := k.Service.GenerateDataKey(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	dataKey = &DataKey{OwnerID: owner, EncryptedKey: encrypted, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	err = common.ConditionalPutItem(ctx, k.Client, k.TableName, dataKey, common.IfNotExists("ownerId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		dataKey, err = k.getDataKey(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		if dataKey == nil {
+			return nil, errors.New("data key vanished after a conflicting write")
+		}
+		return k.Service.Decrypt(ctx, owner, dataKey.EncryptedKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,180 @@
+// Package faults injects DynamoDB failures: throttling, latency and partial BatchGetItem
+// responses, so the retry and pagination logic is exercised against the failures DynamoDB
+// produces under load. It is enabled with FAULT_INJECTION outside of production only.
+package faults
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// productionStages are the values of STAGE in which FAULT_INJECTION is ignored.
+var productionStages = []string{"prod", "production"}
+
+// Config is how often each fault is injected, as the probability of a call failing.
+type Config struct {
+	ThrottleRate     float64
+	LatencyRate      float64
+	Latency          time.Duration
+	PartialBatchRate float64
+
+	// Rand returns a number in [0, 1), rand.Float64 when nil.
+	Rand func() float64
+}
+
+// Parse parses a spec like "throttle=0.05,latency=0.1,delay=300ms,partial=0.2". The delay is
+// the latency injected, 200ms unless given.
+func Parse(spec string) (*Config, error) {
+	config := &Config{Latency: 200 * time.Millisecond}
+	for _, field := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q", field)
+		}
+		if name == "delay" {
+			latency, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault delay %q", value)
+			}
+			config.Latency = latency
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid rate %q of fault %s", value, name)
+		}
+		switch name {
+		case "throttle":
+			config.ThrottleRate = rate
+		case "latency":
+			config.LatencyRate = rate
+		case "partial":
+			config.PartialBatchRate = rate
+		default:
+			return nil, fmt.Errorf("unknown fault %q", name)
+		}
+	}
+	return config, nil
+}
+
+// FromEnv returns the faults configured by FAULT_INJECTION, or nil when fault injection is
+// disabled, which it always is when STAGE is a production stage.
+func FromEnv() (*Config, error) {
+	spec := os.Getenv("FAULT_INJECTION")
+	if spec == "" {
+		return nil, nil
+	}
+	stage := strings.ToLower(os.Getenv("STAGE"))
+	for _, production := range productionStages {
+		if stage == production {
+			log.Printf("Warning: ignoring FAULT_INJECTION in stage %s", stage)
+			return nil, nil
+		}
+	}
+
+	config, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Warning: injecting DynamoDB faults: %s", spec)
+	return config, nil
+}
+
+func (c *Config) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	random := rand.Float64
+	if c.Rand != nil {
+		random = c.Rand
+	}
+	return random() < rate
+}
+
+// Options returns the client option injecting throttling and latency into every attempt of
+// the DynamoDB calls. The faults are injected after the retry middleware, so throttled
+// attempts are retried by the SDK like real ones.
+func (c *Config) Options() func(*dynamodb.Options) {
+	return func(options *dynamodb.Options) {
+		options.APIOptions = append(options.APIOptions, c.addMiddleware)
+	}
+}
+
+func (c *Config) addMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("FaultInjection", func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+		if c.roll(c.LatencyRate) {
+			select {
+			case <-time.After(c.Latency):
+			case <-ctx.Done():
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, ctx.Err()
+			}
+		}
+		if c.roll(c.ThrottleRate) {
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, &types.ProvisionedThroughputExceededException{Message: aws.String("injected throttling")}
+		}
+		return next.HandleFinalize(ctx, in)
+	}), middleware.After)
+}
+
+// PartialBatches is a DynamoDB client returning part of the keys of BatchGetItem calls as
+// unprocessed, like DynamoDB does when a batch exceeds the response size or is throttled.
+type PartialBatches struct {
+	common.DynamoDBAPI
+	Config *Config
+}
+
+// NewPartialBatches wraps the client, returning partial batches as configured.
+func NewPartialBatches(client common.DynamoDBAPI, config *Config) *PartialBatches {
+	return &PartialBatches{DynamoDBAPI: client, Config: config}
+}
+
+// BatchGetItem only requests the first half of the keys of a table when the fault is rolled,
+// returning the others as unprocessed keys. At least one key is always requested, so
+// callers retrying unprocessed keys make progress.
+func (p *PartialBatches) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	requested := make(map[string]types.KeysAndAttributes, len(params.RequestItems))
+	unprocessed := map[string]types.KeysAndAttributes{}
+	for table, request := range params.RequestItems {
+		if len(request.Keys) < 2 || !p.Config.roll(p.Config.PartialBatchRate) {
+			requested[table] = request
+			continue
+		}
+		half := (len(request.Keys) + 1) / 2
+		kept, left := request, request
+		kept.Keys, left.Keys = request.Keys[:half], request.Keys[half:]
+		requested[table], unprocessed[table] = kept, left
+	}
+	if len(unprocessed) == 0 {
+		return p.DynamoDBAPI.BatchGetItem(ctx, params, optFns...)
+	}
+
+	partial := *params
+	partial.RequestItems = requested
+	output, err := p.DynamoDBAPI.BatchGetItem(ctx, &partial, optFns...)
+	if err != nil {
+		return output, err
+	}
+	if output.UnprocessedKeys == nil {
+		output.UnprocessedKeys = map[string]types.KeysAndAttributes{}
+	}
+	for table, request := range unprocessed {
+		if existing, ok := output.UnprocessedKeys[table]; ok {
+			request.Keys = append(existing.Keys, request.Keys...)
+		}
+		output.UnprocessedKeys[table] = request
+	}
+	return output, nil
+}
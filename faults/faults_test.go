@@ -0,0 +1,94 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse("throttle=0.05, latency=0.1,delay=300ms,partial=1")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.05, config.ThrottleRate)
+	assert.Equal(t, 0.1, config.LatencyRate)
+	assert.Equal(t, 300*time.Millisecond, config.Latency)
+	assert.Equal(t, 1.0, config.PartialBatchRate)
+
+	for _, spec := range []string{"throttle", "throttle=2", "delay=soon", "crash=0.1"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestFromEnvIgnoredInProduction(t *testing.T) {
+	t.Setenv("FAULT_INJECTION", "throttle=1")
+	t.Setenv("STAGE", "prod")
+	config, err := FromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+// roundTripper counts the HTTP requests the SDK sends.
+type roundTripper struct{ calls int }
+
+func (r *roundTripper) Do(request *http.Request) (*http.Response, error) {
+	r.calls++
+	return nil, errors.New("unexpected request")
+}
+
+func TestThrottlingIsRetried(t *testing.T) {
+	config := &Config{ThrottleRate: 1}
+	transport := &roundTripper{}
+	client := dynamodb.New(dynamodb.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  transport,
+		// Retry once, without waiting
+		Retryer: retry.AddWithMaxBackoffDelay(retry.NewStandard(func(options *retry.StandardOptions) {
+			options.MaxAttempts = 2
+		}), time.Millisecond),
+	}, config.Options())
+
+	_, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("vassistant-users"),
+		Key:       map[string]types.AttributeValue{"userId": &types.AttributeValueMemberS{Value: "user-1"}},
+	})
+	var throttled *types.ProvisionedThroughputExceededException
+	assert.ErrorAs(t, err, &throttled)
+	assert.Contains(t, err.Error(), "exceeded maximum number of attempts")
+	assert.Equal(t, 0, transport.calls)
+}
+
+func TestPartialBatches(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"vassistant-users": {{"userId": "user-1"}, {"userId": "user-2"}, {"userId": "user-3"}},
+	})
+	assert.NoError(t, err)
+	client := NewPartialBatches(fake, &Config{PartialBatchRate: 1})
+
+	keys := []map[string]types.AttributeValue{}
+	for _, userId := range []string{"user-1", "user-2", "user-3"} {
+		keys = append(keys, map[string]types.AttributeValue{"userId": &types.AttributeValueMemberS{Value: userId}})
+	}
+
+	// Requesting the unprocessed keys again eventually returns every item
+	var items []map[string]types.AttributeValue
+	request := map[string]types.KeysAndAttributes{"vassistant-users": {Keys: keys}}
+	for attempt := 0; len(request) > 0; attempt++ {
+		assert.Less(t, attempt, 3)
+		output, err := client.BatchGetItem(context.TODO(), &dynamodb.BatchGetItemInput{RequestItems: request})
+		assert.NoError(t, err)
+		items = append(items, output.Responses["vassistant-users"]...)
+		request = output.UnprocessedKeys
+	}
+	assert.Len(t, items, 3)
+}
@@ -0,0 +1,137 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"vassistant-backend/analytics"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// GroupAnalytics is the response of the analytics endpoint.
+type GroupAnalytics struct {
+	GroupID       string             `json:"groupId"`
+	GroupBy       string             `json:"groupBy"`
+	MonthStartDay int                `json:"monthStartDay,omitempty"`
+	TimeZone      string             `json:"timeZone,omitempty"`
+	From          string             `json:"from,omitempty"`
+	To            string             `json:"to,omitempty"`
+	Buckets       []analytics.Bucket `json:"buckets"`
+}
+
+// parseAnalyticsDate parses an optional RFC 3339 date time or YYYY-MM-DD date of the
+// analytics range, the dates starting at midnight in the time zone of the group, returning
+// it in RFC 3339.
+func parseAnalyticsDate(value string, location *time.Location) (string, bool) {
+	if value == "" {
+		return "", true
+	}
+	if date, err := time.ParseInLocation(time.DateOnly, value, location); err == nil {
+		return date.Format(time.RFC3339), true
+	}
+	dateTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", false
+	}
+	return dateTime.UTC().Format(time.RFC3339), true
+}
+
+func (h *Handlers) GetGroupAnalyticsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the dimension, by month by default
+	groupBy := request.QueryStringParameters["groupBy"]
+	if groupBy == "" {
+		groupBy = analytics.ByMonth
+	}
+	if !analytics.ValidDimension(groupBy) {
+		return common.CreateErrorResponse(400, "Invalid groupBy, expected month, category or member")
+	}
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	// The days start at midnight in the time zone of the group, and the months on the day
+	// set for it
+	settings, err := h.getGroupSettings(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	location := settings.location()
+	monthStartDay, timeZone := 0, ""
+	if groupBy == analytics.ByMonth {
+		monthStartDay, timeZone = settings.MonthStartDay, location.String()
+	}
+
+	// Parse the optional range
+	from, ok := parseAnalyticsDate(request.QueryStringParameters["from"], location)
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid from date")
+	}
+	to, ok := parseAnalyticsDate(request.QueryStringParameters["to"], location)
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid to date")
+	}
+	if from != "" && to != "" && from >= to {
+		return common.CreateErrorResponse(400, "The from date must be before the to date")
+	}
+
+	if analytics.DefaultClient == nil {
+		return common.CreateErrorResponse(503, "Analytics are not available")
+	}
+	buckets, err := analytics.DefaultClient.Aggregate(context.TODO(), analytics.Query{
+		GroupID:       groupId,
+		GroupBy:       groupBy,
+		From:          from,
+		To:            to,
+		MonthStartDay: monthStartDay,
+		TimeZone:      timeZone,
+	})
+	if err != nil {
+		log.Printf("Error aggregating expenses in OpenSearch: %v", err)
+		return common.CreateErrorResponse(502, "Analytics could not be computed")
+	}
+
+	// Marshal the analytics into JSON for the payload
+	payload, err := json.Marshal(GroupAnalytics{
+		GroupID:       groupId,
+		GroupBy:       groupBy,
+		MonthStartDay: monthStartDay,
+		TimeZone:      timeZone,
+		From:          from,
+		To:            to,
+		Buckets:       buckets,
+	})
+	if err != nil {
+		log.Println("Error marshalling analytics:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
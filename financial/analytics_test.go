@@ -0,0 +1,87 @@
+package financial
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vassistant-backend/analytics"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGroupAnalyticsHandler(t *testing.T) {
+	// Set up the fake DynamoDB and an OpenSearch domain answering a member aggregation
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"aggregations":{"groups":{"buckets":[
+			{"key":"user-1","currencies":{"buckets":[{"key":"USD","total":{"value":42},"expenses":{"value":3}}]}}
+		]}}}`)
+	}))
+	defer server.Close()
+	analytics.DefaultClient = &analytics.Client{Endpoint: server.URL, HTTPClient: server.Client()}
+	defer func() { analytics.DefaultClient = nil }()
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("groupBy", "member").
+		WithQueryParam("from", "2024-01-01").
+		Build()
+
+	// Call the handler
+	response, err := h.GetGroupAnalyticsHandler(request)
+
+	// Check the response
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var result GroupAnalytics
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+	assert.Equal(t, "2024-01-01T00:00:00Z", result.From)
+	assert.Equal(t, []analytics.Bucket{{Key: "user-1", Currency: "USD", Total: "42.00", Expenses: 3}}, result.Buckets)
+}
+
+func TestGetGroupAnalyticsHandlerErrors(t *testing.T) {
+	// Set up the fake DynamoDB without an OpenSearch domain
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	analytics.DefaultClient = nil
+
+	tests := []struct {
+		name   string
+		user   string
+		query  map[string]string
+		status int
+	}{
+		{"InvalidGroupBy", "user-1", map[string]string{"groupBy": "weekday"}, http.StatusBadRequest},
+		{"InvalidDate", "user-1", map[string]string{"from": "yesterday"}, http.StatusBadRequest},
+		{"EmptyRange", "user-1", map[string]string{"from": "2024-02-01", "to": "2024-01-01"}, http.StatusBadRequest},
+		{"NotMember", "user-2", nil, http.StatusNotFound},
+		{"NotConfigured", "user-1", nil, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := testutil.NewRequest("GET", "").
+				WithClaims(tt.user, tt.user).
+				WithPathParam("groupId", "test-group-id")
+			for key, value := range tt.query {
+				builder = builder.WithQueryParam(key, value)
+			}
+
+			response, err := h.GetGroupAnalyticsHandler(builder.Build())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, response.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/fx"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+)
+
+// maxBatchExpenses is the maximum number of expenses created by a single batch call.
+const maxBatchExpenses = 25
+
+// ExpenseBatch struct for the batch expense creation request body
+type ExpenseBatch struct {
+	// Transactional stores all the expenses or none of them, instead of each on its own.
+	Transactional bool               `json:"transactional"`
+	Expenses      []FinancialExpense `json:"expenses"`
+}
+
+// ExpenseBatchResult is the outcome of one expense of a batch, in the order of the request.
+// An expense taking members over their spending cap is held for approval with a 202, or
+// stored with the warnings of the caps.
+type ExpenseBatchResult struct {
+	Index      int               `json:"index"`
+	StatusCode int               `json:"statusCode"`
+	Expense    *FinancialExpense `json:"expense,omitempty"`
+	Approval   *ExpenseApproval  `json:"approval,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// prepareExpense fills in a new expense of the user for the group and calculates its split.
+// It returns the message to send back when the expense is invalid, and an error when it
+// could not be prepared for another reason.
+func (h *Handlers) prepareExpense(ctx context.Context, groupId, userId string, expense *FinancialExpense) (string, error) {
+	// Validate the currency, if given
+	if expense.Currency != "" && !fx.ValidCurrency(expense.Currency) {
+		return "Invalid currency", nil
+	}
+	expense.Display = nil
+	expense.GroupAmount = nil
+	expense.Dispute = nil
+	clearSettlements(expense)
+
+	// Validate where the expense was paid, if given
+	if message := validateLocation(expense); message != "" {
+		return message, nil
+	}
+
+	// Generate a new UUID for the expense
+	expense.ExpenseID = uuid.New().String()
+	expense.GroupID = groupId
+	expense.CreatedBy = userId
+	expense.CreatedAt = time.Now().Format(time.RFC3339)
+
+	// Fill in the group defaults when the expense has no explicit participants
+	if len(expense.Participants) == 0 {
+		err := h.applyGroupDefaults(ctx, expense)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Calculate calculatedMoney for each participant
+	err := calculateParticipantMoney(expense)
+	if errors.Is(err, errInvalidAmount) {
+		log.Printf("Error parsing amount: %v", expense.Amount)
+		return "Invalid amount", nil
+	}
+	if errors.Is(err, errSharesTotal) {
+		return "Shares must add up to 100", nil
+	}
+	if err != nil {
+		log.Printf("Error parsing share: %v", err)
+		return "Invalid share", nil
+	}
+
+	// Validate who paid the expense
+	err = calculatePayers(expense)
+	if errors.Is(err, errPayersTotal) {
+		return "Payers must add up to the amount", nil
+	}
+	if err != nil {
+		return "Invalid payer", nil
+	}
+
+	// Resolve the members mentioned in the notes
+	expense.Mentions, err = h.mentionsOf(ctx, groupId, userId, expense.Notes)
+	if err != nil {
+		return "", err
+	}
+
+	// Record the rate the expense converts to the group currency at
+	err = h.convertToGroupCurrency(ctx, expense)
+	if err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func (h *Handlers) PostGroupExpenseBatchHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into an ExpenseBatch struct
+	var batch ExpenseBatch
+	err = json.Unmarshal([]byte(request.Body), &batch)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if len(batch.Expenses) == 0 {
+		return common.CreateErrorResponse(400, "No expenses given")
+	}
+	if len(batch.Expenses) > maxBatchExpenses {
+		return common.CreateErrorResponse(400, "Too many expenses")
+	}
+
+	// Refuse the whole batch when the group has no room left for it
+	quotaErr, err := h.checkExpenseQuota(context.TODO(), groupId, len(batch.Expenses))
+	if err != nil {
+		log.Printf("Error checking the expense quota: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if quotaErr != nil {
+		return quotaResponse(quotaErr)
+	}
+
+	// Validate every expense before writing any of them
+	results := make([]ExpenseBatchResult, len(batch.Expenses))
+	valid := true
+	for i := range batch.Expenses {
+		expense := &batch.Expenses[i]
+		results[i] = ExpenseBatchResult{Index: i}
+
+		message, err := h.prepareExpense(context.TODO(), groupId, claims.Sub, expense)
+		if err != nil {
+			log.Printf("Error preparing expense %d: %v", i, err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if message != "" {
+			results[i].StatusCode = 400
+			results[i].Error = message
+			valid = false
+		}
+	}
+
+	if batch.Transactional {
+		h.storeExpensesTransactionally(claims.Sub, batch.Expenses, results, valid)
+	} else {
+		h.storeExpenses(claims.Sub, batch.Expenses, results)
+	}
+
+	log.Printf("Processed a batch of %d expenses for group %s", len(batch.Expenses), groupId)
+	var created []FinancialExpense
+	for _, result := range results {
+		if result.Expense != nil {
+			created = append(created, *result.Expense)
+		}
+	}
+	h.announceExpenses(context.TODO(), groupId, created...)
+
+	// Marshal the results into JSON for the payload
+	payload, err := json.Marshal(results)
+	if err != nil {
+		log.Println("Error marshalling expense batch results:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// storeExpenses stores each valid expense of the user on its own, recording the outcome in
+// its result. The expenses are checked against the spending caps in turn, the previous
+// ones of the batch being stored already.
+func (h *Handlers) storeExpenses(userId string, expenses []FinancialExpense, results []ExpenseBatchResult) {
+	for i := range expenses {
+		if results[i].StatusCode != 0 {
+			continue
+		}
+
+		approval, warnings, err := h.checkSpendingCaps(context.TODO(), expenses[i], userId)
+		if err != nil {
+			log.Printf("Error checking the spending caps of item %d: %v", i, err)
+			results[i].StatusCode = 500
+			results[i].Error = "Internal server error"
+			continue
+		}
+		if approval != nil {
+			results[i].StatusCode = 202
+			results[i].Approval = approval
+			continue
+		}
+		results[i].Warnings = warnings
+
+		err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-expenses", expenses[i], common.IfNotExists("expenseId"))
+		switch {
+		case errors.Is(err, common.ErrConditionFailed):
+			results[i].StatusCode = 409
+			results[i].Error = "Expense already exists"
+		case err != nil:
+			log.Printf("Error putting item %d into DynamoDB: %v", i, err)
+			results[i].StatusCode = 500
+			results[i].Error = "Internal server error"
+		default:
+			results[i].StatusCode = 201
+			results[i].Expense = &expenses[i]
+		}
+	}
+}
+
+// storeExpensesTransactionally stores all the expenses of the user in a single transaction.
+// When any of them is invalid or the transaction fails, none is stored and the others are
+// failed along with it. As the expenses held for approval can't be stored with the others,
+// an expense that would be fails the batch too.
+func (h *Handlers) storeExpensesTransactionally(userId string, expenses []FinancialExpense, results []ExpenseBatchResult, valid bool) {
+	failAll := func(statusCode int, message string) {
+		for i := range results {
+			if results[i].StatusCode == 0 {
+				results[i].StatusCode = statusCode
+				results[i].Error = message
+			}
+		}
+	}
+	if !valid {
+		failAll(424, "Not stored, another expense of the batch is invalid")
+		return
+	}
+
+	// Each expense counts towards the caps after the previous ones of the batch
+	for i := range expenses {
+		exceeded, policy, err := h.exceededCaps(context.TODO(), expenses[i], expenses[:i])
+		if err == nil && len(exceeded) > 0 {
+			var held bool
+			_, held, err = h.needsApproval(context.TODO(), policy, expenses[i].GroupID, userId)
+			if err == nil && held {
+				results[i].StatusCode = 422
+				results[i].Error = "Takes members over their spending cap, add it on its own to have it approved"
+				failAll(424, "Not stored, another expense of the batch needs approval")
+				return
+			}
+			results[i].Warnings = capWarnings(exceeded)
+		}
+		if err != nil {
+			log.Printf("Error checking the spending caps of item %d: %v", i, err)
+			failAll(500, "Internal server error")
+			return
+		}
+	}
+
+	puts := make([]common.ConditionalPut, len(expenses))
+	for i, expense := range expenses {
+		puts[i] = common.ConditionalPut{TableName: "splitter-expenses", Item: expense, Condition: common.IfNotExists("expenseId")}
+	}
+	err := common.TransactPutItems(context.TODO(), h.client, puts)
+	if errors.Is(err, common.ErrConditionFailed) {
+		failAll(409, "Expense already exists")
+		return
+	}
+	if err != nil {
+		log.Printf("Error writing transaction to DynamoDB: %v", err)
+		failAll(500, "Internal server error")
+		return
+	}
+
+	for i := range expenses {
+		results[i].StatusCode = 201
+		results[i].Expense = &expenses[i]
+	}
+}
@@ -0,0 +1,112 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// postExpenseBatch calls the batch handler and returns the results of the batch.
+func postExpenseBatch(t *testing.T, h *Handlers, batch map[string]interface{}) []ExpenseBatchResult {
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, batch).
+		Build()
+
+	// Call the handler
+	response, err := h.PostGroupExpenseBatchHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var results []ExpenseBatchResult
+	err = json.Unmarshal([]byte(response.Body), &results)
+	assert.NoError(t, err)
+	return results
+}
+
+// storedExpenseCount returns the number of expenses stored for the test group.
+func storedExpenseCount(t *testing.T, fake *testutil.FakeDynamoDB) int {
+	result, err := fake.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: "test-group-id"},
+		},
+	})
+	assert.NoError(t, err)
+	return len(result.Items)
+}
+
+var batchExpenses = []map[string]interface{}{
+	{"title": "Taxi", "amount": 20, "paidBy": "user-1", "splitType": "PERCENTAGE", "participants": []map[string]interface{}{{"userId": "user-1", "share": 100}}},
+	{"title": "Dinner", "paidBy": "user-1", "splitType": "PERCENTAGE", "participants": []map[string]interface{}{{"userId": "user-1", "share": 100}}},
+	{"title": "Hotel", "amount": 300, "paidBy": "user-1", "splitType": "PERCENTAGE", "participants": []map[string]interface{}{{"userId": "user-1", "share": 60}}},
+	{"title": "Museum", "amount": 40, "paidBy": "user-1", "splitType": "PERCENTAGE", "participants": []map[string]interface{}{{"userId": "user-1", "share": 100}}},
+}
+
+func TestPostGroupExpenseBatchHandler(t *testing.T) {
+	// Set up the fake DynamoDB
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// The valid expenses are stored, the invalid ones are reported
+	results := postExpenseBatch(t, h, map[string]interface{}{"expenses": batchExpenses})
+	assert.Len(t, results, 4)
+	assert.Equal(t, http.StatusCreated, results[0].StatusCode)
+	assert.Equal(t, "Taxi", results[0].Expense.Title)
+	assert.Equal(t, http.StatusBadRequest, results[1].StatusCode)
+	assert.Equal(t, "Invalid amount", results[1].Error)
+	assert.Equal(t, http.StatusBadRequest, results[2].StatusCode)
+	assert.Equal(t, "Shares must add up to 100", results[2].Error)
+	assert.Equal(t, http.StatusCreated, results[3].StatusCode)
+	assert.Equal(t, 2, storedExpenseCount(t, fake))
+}
+
+func TestPostGroupExpenseBatchHandlerTransactional(t *testing.T) {
+	// Set up the fake DynamoDB
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Nothing is stored when any of the expenses is invalid
+	results := postExpenseBatch(t, h, map[string]interface{}{"transactional": true, "expenses": batchExpenses[2:]})
+	assert.Equal(t, http.StatusBadRequest, results[0].StatusCode)
+	assert.Equal(t, http.StatusFailedDependency, results[1].StatusCode)
+	assert.Equal(t, 0, storedExpenseCount(t, fake))
+
+	// Otherwise they're all stored
+	results = postExpenseBatch(t, h, map[string]interface{}{"transactional": true, "expenses": []map[string]interface{}{batchExpenses[0], batchExpenses[3]}})
+	assert.Equal(t, http.StatusCreated, results[0].StatusCode)
+	assert.Equal(t, http.StatusCreated, results[1].StatusCode)
+	assert.Equal(t, 2, storedExpenseCount(t, fake))
+}
+
+func TestPostGroupExpenseBatchHandlerTooManyExpenses(t *testing.T) {
+	expenses := make([]map[string]interface{}, maxBatchExpenses+1)
+	for i := range expenses {
+		expenses[i] = batchExpenses[0]
+	}
+
+	// Create a sample request
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, map[string]interface{}{"expenses": expenses}).
+		Build()
+
+	// Call the handler
+	response, err := NewHandlers(nil).PostGroupExpenseBatchHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response for 400 Bad Request
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
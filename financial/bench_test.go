@@ -0,0 +1,134 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// benchmarkLatency is the simulated round trip of every DynamoDB call.
+const benchmarkLatency = 2 * time.Millisecond
+
+// discardLogs silences the handler logs for the duration of the benchmark.
+func discardLogs(b *testing.B) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(output) })
+}
+
+// newBenchmarkClient creates a mock client serving a group with the given number of
+// members and expenses, sleeping benchmarkLatency on every call.
+func newBenchmarkClient(b *testing.B, memberCount, expenseCount int) *testutil.MockDynamoDBClient {
+	expenses := make([]map[string]types.AttributeValue, 0, expenseCount)
+	for i := 0; i < expenseCount; i++ {
+		expense := FinancialExpense{
+			ExpenseID: fmt.Sprintf("expense-%d", i),
+			GroupID:   "test-group-id",
+			Title:     "Benchmark expense",
+			Amount:    "100",
+			DateTime:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+			PaidBy:    fmt.Sprintf("user-%d", i%memberCount),
+			CreatedBy: fmt.Sprintf("user-%d", (i+1)%memberCount),
+		}
+		for j := 0; j < memberCount; j++ {
+			expense.Participants = append(expense.Participants, Participant{UserID: fmt.Sprintf("user-%d", j), Share: json.Number("1")})
+		}
+		av, err := attributevalue.MarshalMap(expense)
+		if err != nil {
+			b.Fatal(err)
+		}
+		expenses = append(expenses, av)
+	}
+
+	return &testutil.MockDynamoDBClient{
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			time.Sleep(benchmarkLatency)
+			return &dynamodb.QueryOutput{Items: expenses, Count: int32(len(expenses))}, nil
+		},
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			time.Sleep(benchmarkLatency)
+			// The group has no spending caps
+			if aws.ToString(params.TableName) == "splitter-spending-caps" {
+				return &dynamodb.GetItemOutput{}, nil
+			}
+			return &dynamodb.GetItemOutput{Item: expenses[0]}, nil
+		},
+		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+			time.Sleep(benchmarkLatency)
+			keys := params.RequestItems["vassistant-users"].Keys
+			return &dynamodb.BatchGetItemOutput{
+				Responses: map[string][]map[string]types.AttributeValue{"vassistant-users": keys},
+			}, nil
+		},
+		PutItemFunc: func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+			time.Sleep(benchmarkLatency)
+			return &dynamodb.PutItemOutput{}, nil
+		},
+	}
+}
+
+func BenchmarkGetGroupExpensesHandler(b *testing.B) {
+	discardLogs(b)
+	for _, size := range []struct{ members, expenses int }{{4, 50}, {50, 200}, {300, 500}} {
+		b.Run(fmt.Sprintf("members=%d/expenses=%d", size.members, size.expenses), func(b *testing.B) {
+			h := NewHandlers(newBenchmarkClient(b, size.members, size.expenses))
+			request := events.APIGatewayProxyRequest{
+				PathParameters: map[string]string{"groupId": "test-group-id"},
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if response, _ := h.GetGroupExpensesHandler(request); response.StatusCode != 200 {
+					b.Fatalf("unexpected status code %d", response.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetExpenseHandler(b *testing.B) {
+	discardLogs(b)
+	h := NewHandlers(newBenchmarkClient(b, 10, 1))
+	request := events.APIGatewayProxyRequest{
+		PathParameters: map[string]string{"groupId": "test-group-id", "expenseId": "expense-0"},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if response, _ := h.GetExpenseHandler(request); response.StatusCode != 200 {
+			b.Fatalf("unexpected status code %d", response.StatusCode)
+		}
+	}
+}
+
+func BenchmarkPostGroupExpenseHandler(b *testing.B) {
+	discardLogs(b)
+	h := NewHandlers(newBenchmarkClient(b, 10, 0))
+	request := events.APIGatewayProxyRequest{
+		RequestContext: events.APIGatewayProxyRequestContext{
+			Authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{"sub": "test-user-id"},
+			},
+		},
+		PathParameters: map[string]string{"groupId": "test-group-id"},
+		Body:           `{"title":"Benchmark","amount":"100","participants":[{"userId":"user-1","share":"33.33"},{"userId":"user-2","share":"33.33"},{"userId":"user-3","share":"33.34"}]}`,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if response, _ := h.PostGroupExpenseHandler(request); response.StatusCode != 201 {
+			b.Fatalf("unexpected status code %d", response.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,696 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"sort"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Spending cap policies, what happens to an expense taking a member over their cap
+const (
+	CapPolicyWarn     = "WARN"     // the expense is added with a warning
+	CapPolicyApproval = "APPROVAL" // the expense waits for an admin to approve it
+)
+
+// Expense approval statuses
+const (
+	ExpenseApprovalPending  = "PENDING"
+	ExpenseApprovalApproved = "APPROVED"
+	ExpenseApprovalDenied   = "DENIED"
+)
+
+// Notifications of the expense approvals
+const (
+	NotificationApprovalRequested = "EXPENSE_APPROVAL_REQUESTED"
+	NotificationExpenseApproved   = "EXPENSE_APPROVED"
+	NotificationExpenseDenied     = "EXPENSE_DENIED"
+)
+
+// SpendingCaps struct for the splitter-spending-caps table, the monthly caps of the members
+// of a group on their shares of the expenses, in the default currency of the group. The
+// months are the ones of the reports of the group.
+type SpendingCaps struct {
+	GroupID   string                 `json:"groupId" dynamodbav:"groupId"`
+	Policy    string                 `json:"policy" dynamodbav:"policy"`
+	Caps      map[string]json.Number `json:"caps" dynamodbav:"caps"` // by user ID
+	UpdatedBy string                 `json:"updatedBy" dynamodbav:"updatedBy"`
+	UpdatedAt string                 `json:"updatedAt" dynamodbav:"updatedAt"`
+	Version   int                    `json:"version" dynamodbav:"version"`
+}
+
+// CapStatus is how much of their cap a member spent in a month of the group.
+type CapStatus struct {
+	UserID    string      `json:"userId"`
+	Period    string      `json:"period"`
+	Currency  string      `json:"currency"`
+	Cap       json.Number `json:"cap"`
+	Spent     json.Number `json:"spent"`
+	Remaining json.Number `json:"remaining"`
+	Exceeded  bool        `json:"exceeded"`
+}
+
+// GroupSpendingCaps is the response of the spending caps endpoints, with the status of the
+// caps in the current month.
+type GroupSpendingCaps struct {
+	SpendingCaps
+	Statuses []CapStatus `json:"statuses"`
+}
+
+// ExpenseApproval struct for the splitter-expense-approvals table, an expense taking members
+// over their cap held until an admin approves it.
+type ExpenseApproval struct {
+	GroupID   string           `json:"groupId" dynamodbav:"groupId"`
+	ExpenseID string           `json:"expenseId" dynamodbav:"expenseId"`
+	Status    string           `json:"status" dynamodbav:"status"`
+	Expense   FinancialExpense `json:"expense" dynamodbav:"expense"`
+	Exceeded  []CapStatus      `json:"exceeded" dynamodbav:"exceeded"`
+	CreatedBy string           `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt string           `json:"createdAt" dynamodbav:"createdAt"`
+	DecidedBy string           `json:"decidedBy,omitempty" dynamodbav:"decidedBy,omitempty"`
+	DecidedAt string           `json:"decidedAt,omitempty" dynamodbav:"decidedAt,omitempty"`
+}
+
+// getSpendingCaps returns the spending caps of the group, or empty caps if none were set.
+func (h *Handlers) getSpendingCaps(ctx context.Context, groupId string) (SpendingCaps, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-spending-caps"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return SpendingCaps{}, err
+	}
+
+	caps := SpendingCaps{GroupID: groupId, Policy: CapPolicyWarn, Caps: map[string]json.Number{}}
+	if result.Item == nil {
+		return caps, nil
+	}
+	err = attributevalue.UnmarshalMap(result.Item, &caps)
+	return caps, err
+}
+
+// shareInCurrency returns the share of the participant in the expense in the currency, the
+// expenses in another currency counting at the rate they were converted to the group
+// currency at. It reports false when the expense can't be counted in the currency.
+func shareInCurrency(expense FinancialExpense, participant Participant, currency string) (*big.Rat, bool) {
+	share, ok := new(big.Rat).SetString(string(participant.CalculatedMoney))
+	if !ok {
+		return nil, false
+	}
+	if expense.Currency == "" || expense.Currency == currency {
+		return share, true
+	}
+	if expense.GroupAmount == nil || expense.GroupAmount.Currency != currency {
+		return nil, false
+	}
+	rate, ok := new(big.Rat).SetString(string(expense.GroupAmount.Rate))
+	if !ok {
+		return nil, false
+	}
+	return share.Mul(share, rate), true
+}
+
+// memberSpending totals the shares of every participant of the expenses of the period, in
+// the currency.
+func memberSpending(expenses []FinancialExpense, period string, startDay int, location *time.Location, currency string) map[string]*big.Rat {
+	spent := map[string]*big.Rat{}
+	for _, expense := range expenses {
+		dateTime, err := time.Parse(time.RFC3339, expense.DateTime)
+		if err != nil || fiscalMonth(dateTime, startDay, location).Format(periodLayout) != period {
+			continue
+		}
+		addShares(spent, expense, currency)
+	}
+	return spent
+}
+
+// addShares adds the shares of the participants of the expense, in the currency, to what
+// they spent.
+func addShares(spent map[string]*big.Rat, expense FinancialExpense, currency string) {
+	for _, participant := range expense.Participants {
+		share, ok := shareInCurrency(expense, participant, currency)
+		if !ok {
+			continue
+		}
+		if spent[participant.UserID] == nil {
+			spent[participant.UserID] = new(big.Rat)
+		}
+		spent[participant.UserID].Add(spent[participant.UserID], share)
+	}
+}
+
+// capStatuses returns the status of every cap in the period, by user ID.
+func capStatuses(caps SpendingCaps, spent map[string]*big.Rat, period, currency string) []CapStatus {
+	statuses := []CapStatus{}
+	for userId, capAmount := range caps.Caps {
+		limit, ok := new(big.Rat).SetString(string(capAmount))
+		if !ok {
+			continue
+		}
+		total := spent[userId]
+		if total == nil {
+			total = new(big.Rat)
+		}
+		statuses = append(statuses, CapStatus{
+			UserID:    userId,
+			Period:    period,
+			Currency:  currency,
+			Cap:       json.Number(limit.FloatString(2)),
+			Spent:     json.Number(total.FloatString(2)),
+			Remaining: json.Number(new(big.Rat).Sub(limit, total).FloatString(2)),
+			Exceeded:  total.Cmp(limit) > 0,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].UserID < statuses[j].UserID })
+	return statuses
+}
+
+// queryGroupExpenses returns every expense of the group.
+func (h *Handlers) queryGroupExpenses(ctx context.Context, groupId string) ([]FinancialExpense, error) {
+	return h.queryExpenses(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+}
+
+// exceededCaps returns the caps of the participants of a new expense it takes over their
+// cap in its month, the current one when its date can't be read, and the policy of the
+// group. The pending expenses are those not stored yet that will be before it, like the
+// previous ones of a transactional batch.
+func (h *Handlers) exceededCaps(ctx context.Context, expense FinancialExpense, pending []FinancialExpense) ([]CapStatus, string, error) {
+	caps, err := h.getSpendingCaps(ctx, expense.GroupID)
+	if err != nil || len(caps.Caps) == 0 {
+		return nil, caps.Policy, err
+	}
+	dateTime, err := time.Parse(time.RFC3339, expense.DateTime)
+	if err != nil {
+		dateTime = time.Now()
+	}
+	settings, err := h.getGroupSettings(ctx, expense.GroupID)
+	if err != nil {
+		return nil, caps.Policy, err
+	}
+	expenses, err := h.queryGroupExpenses(ctx, expense.GroupID)
+	if err != nil {
+		return nil, caps.Policy, err
+	}
+
+	period := fiscalMonth(dateTime, settings.MonthStartDay, settings.location()).Format(periodLayout)
+	expenses = append(expenses, pending...)
+	spent := memberSpending(expenses, period, settings.MonthStartDay, settings.location(), settings.DefaultCurrency)
+	addShares(spent, expense, settings.DefaultCurrency)
+	var exceeded []CapStatus
+	for _, status := range capStatuses(caps, spent, period, settings.DefaultCurrency) {
+		participates := slices.ContainsFunc(expense.Participants, func(p Participant) bool { return p.UserID == status.UserID })
+		if status.Exceeded && participates {
+			exceeded = append(exceeded, status)
+		}
+	}
+	return exceeded, caps.Policy, nil
+}
+
+// capWarning describes an exceeded cap for the warnings of the responses.
+func capWarning(status CapStatus) string {
+	return fmt.Sprintf("User %s exceeds the monthly spending cap of %s %s with %s %s", status.UserID, status.Cap, status.Currency, status.Spent, status.Currency)
+}
+
+// checkSpendingCaps checks a new expense added by the user against the caps of the group. In
+// a group with the approval policy, an expense taking members over their cap that wasn't
+// added by an admin is held for approval, and returned; otherwise it returns the warnings
+// of the exceeded caps.
+func (h *Handlers) checkSpendingCaps(ctx context.Context, expense FinancialExpense, userId string) (*ExpenseApproval, []string, error) {
+	exceeded, policy, err := h.exceededCaps(ctx, expense, nil)
+	if err != nil || len(exceeded) == 0 {
+		return nil, nil, err
+	}
+	creator, held, err := h.needsApproval(ctx, policy, expense.GroupID, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if held {
+		approval, err := h.holdForApproval(ctx, expense, creator, exceeded)
+		return approval, nil, err
+	}
+	return nil, capWarnings(exceeded), nil
+}
+
+// needsApproval reports whether the expenses of the user exceeding caps are held for
+// approval in the group with the policy, which they are unless the user is an admin. It
+// returns the user as the creator of the held expenses.
+func (h *Handlers) needsApproval(ctx context.Context, policy, groupId, userId string) (*GroupMember, bool, error) {
+	if policy != CapPolicyApproval {
+		return nil, false, nil
+	}
+	creator, err := h.getGroupMember(ctx, userId, groupId)
+	if err != nil {
+		return nil, false, err
+	}
+	if creator == nil {
+		creator = &GroupMember{UserID: userId, GroupID: groupId}
+	}
+	return creator, creator.Role != RoleAdmin, nil
+}
+
+// capWarnings returns the warnings of the exceeded caps.
+func capWarnings(exceeded []CapStatus) []string {
+	warnings := make([]string, 0, len(exceeded))
+	for _, status := range exceeded {
+		warnings = append(warnings, capWarning(status))
+	}
+	return warnings
+}
+
+// holdForApproval stores the approval of the new expense and notifies the admins, the
+// expense itself isn't stored until it is approved.
+func (h *Handlers) holdForApproval(ctx context.Context, expense FinancialExpense, creator *GroupMember, exceeded []CapStatus) (*ExpenseApproval, error) {
+	approval := ExpenseApproval{
+		GroupID:   expense.GroupID,
+		ExpenseID: expense.ExpenseID,
+		Status:    ExpenseApprovalPending,
+		Expense:   expense,
+		Exceeded:  exceeded,
+		CreatedBy: creator.UserID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	err := common.ConditionalPutItem(ctx, h.client, "splitter-expense-approvals", approval, common.IfNotExists("expenseId"))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Expense %s of group %s waits for approval, %d caps exceeded", expense.ExpenseID, expense.GroupID, len(exceeded))
+
+	// Let the admins know there is an expense to approve
+	members, err := h.getGroupMembers(ctx, expense.GroupID)
+	if err != nil {
+		log.Printf("Error getting group members from DynamoDB: %v", err)
+		return &approval, nil
+	}
+	var admins []string
+	for _, member := range members {
+		if member.Role == RoleAdmin {
+			admins = append(admins, member.UserID)
+		}
+	}
+	notifyMembers(ctx, admins, NotificationApprovalRequested, map[string]string{
+		"groupId": expense.GroupID, "groupName": creator.GroupName, "expenseId": expense.ExpenseID, "title": expense.Title,
+	})
+	return &approval, nil
+}
+
+// approvalResponse answers the creation of an expense held for approval.
+func approvalResponse(approval *ExpenseApproval) (events.APIGatewayProxyResponse, error) {
+	// Marshal the approval into JSON for the payload
+	payload, err := json.Marshal(approval)
+	if err != nil {
+		log.Println("Error marshalling expense approval:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 202,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// groupSpendingCaps returns the caps of the group with their status in the current month.
+func (h *Handlers) groupSpendingCaps(ctx context.Context, caps SpendingCaps, now time.Time) (GroupSpendingCaps, error) {
+	settings, err := h.getGroupSettings(ctx, caps.GroupID)
+	if err != nil {
+		return GroupSpendingCaps{}, err
+	}
+	statuses := []CapStatus{}
+	if len(caps.Caps) > 0 {
+		expenses, err := h.queryGroupExpenses(ctx, caps.GroupID)
+		if err != nil {
+			return GroupSpendingCaps{}, err
+		}
+		period := fiscalMonth(now, settings.MonthStartDay, settings.location()).Format(periodLayout)
+		spent := memberSpending(expenses, period, settings.MonthStartDay, settings.location(), settings.DefaultCurrency)
+		statuses = capStatuses(caps, spent, period, settings.DefaultCurrency)
+	}
+	return GroupSpendingCaps{SpendingCaps: caps, Statuses: statuses}, nil
+}
+
+// spendingCapsResponse answers with the caps of the group and their status.
+func (h *Handlers) spendingCapsResponse(caps SpendingCaps) (events.APIGatewayProxyResponse, error) {
+	status, err := h.groupSpendingCaps(context.TODO(), caps, time.Now())
+	if err != nil {
+		log.Printf("Error getting spending caps status from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the spending caps into JSON for the payload
+	payload, err := json.Marshal(status)
+	if err != nil {
+		log.Println("Error marshalling spending caps:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) GetSpendingCapsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only members can see the spending caps
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	caps, err := h.getSpendingCaps(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting spending caps from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return h.spendingCapsResponse(caps)
+}
+
+func (h *Handlers) PutSpendingCapsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a SpendingCaps struct
+	var caps SpendingCaps
+	err = json.Unmarshal([]byte(request.Body), &caps)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if caps.Policy == "" {
+		caps.Policy = CapPolicyWarn
+	}
+	if caps.Policy != CapPolicyWarn && caps.Policy != CapPolicyApproval {
+		return common.CreateErrorResponse(400, "Invalid policy, expected WARN or APPROVAL")
+	}
+	if caps.Caps == nil {
+		caps.Caps = map[string]json.Number{}
+	}
+	for userId, capAmount := range caps.Caps {
+		limit, ok := new(big.Rat).SetString(string(capAmount))
+		if !ok || limit.Sign() <= 0 {
+			return common.CreateErrorResponse(400, "Invalid cap of user "+userId)
+		}
+		caps.Caps[userId] = json.Number(limit.FloatString(2))
+	}
+
+	// Only admins can set the spending caps
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can set the spending caps")
+	}
+
+	// The capped users must be members of the group
+	if len(caps.Caps) > 0 {
+		memberIds, err := h.getGroupMemberIds(context.TODO(), groupId)
+		if err != nil {
+			log.Printf("Error querying group members from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		for userId := range caps.Caps {
+			if !slices.Contains(memberIds, userId) {
+				return common.CreateErrorResponse(400, "Capped user is not a group member")
+			}
+		}
+	}
+
+	// Save the caps, only if nobody changed them since the client read them
+	expectedVersion := caps.Version
+	caps.GroupID = groupId
+	caps.UpdatedBy = claims.Sub
+	caps.UpdatedAt = time.Now().Format(time.RFC3339)
+	caps.Version = expectedVersion + 1
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-spending-caps", caps, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Spending caps were changed by someone else")
+	}
+	if err != nil {
+		log.Printf("Error putting spending caps into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Admin %s set %d spending caps of group %s with policy %s", claims.Sub, len(caps.Caps), groupId, caps.Policy)
+	return h.spendingCapsResponse(caps)
+}
+
+// getExpenseApproval returns the approval of the expense of the group, or nil if there is none.
+func (h *Handlers) getExpenseApproval(ctx context.Context, groupId, expenseId string) (*ExpenseApproval, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-expense-approvals"),
+		Key: map[string]types.AttributeValue{
+			"groupId":   &types.AttributeValueMemberS{Value: groupId},
+			"expenseId": &types.AttributeValueMemberS{Value: expenseId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var approval ExpenseApproval
+	err = attributevalue.UnmarshalMap(result.Item, &approval)
+	if err != nil {
+		return nil, err
+	}
+	return &approval, nil
+}
+
+func (h *Handlers) GetExpenseApprovalsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only admins can manage the expense approvals of the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can manage expense approvals")
+	}
+
+	// Make the DynamoDB Query API call
+	result, err := h.client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expense-approvals"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ConsistentRead: common.ConsistentRead(request),
+	})
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Unmarshal the Items into a slice of ExpenseApproval structs
+	var approvals []ExpenseApproval
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &approvals)
+	if err != nil {
+		log.Printf("Error unmarshalling expense approvals: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Keep the pending approvals only
+	pending := make([]ExpenseApproval, 0, len(approvals))
+	for _, approval := range approvals {
+		if approval.Status == ExpenseApprovalPending {
+			pending = append(pending, approval)
+		}
+	}
+
+	log.Printf("Successfully retrieved %d pending expense approvals for group %s", len(pending), groupId)
+
+	// Marshal the expense approvals into JSON for the payload
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		log.Println("Error marshalling expense approvals:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) ApproveExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.decideExpenseApproval(request, ExpenseApprovalApproved)
+}
+
+func (h *Handlers) DenyExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.decideExpenseApproval(request, ExpenseApprovalDenied)
+}
+
+// decideExpenseApproval approves or denies an expense held for approval, storing it when
+// approved and notifying its creator of the decision.
+func (h *Handlers) decideExpenseApproval(request events.APIGatewayProxyRequest, status string) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and expenseId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Expense ID is missing")
+	}
+
+	// Only admins can manage the expense approvals of the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can manage expense approvals")
+	}
+
+	approval, err := h.getExpenseApproval(context.TODO(), groupId, expenseId)
+	if err != nil {
+		log.Printf("Error getting expense approval from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if approval == nil {
+		return common.CreateErrorResponse(404, "Expense approval not found")
+	}
+	if approval.Status != ExpenseApprovalPending {
+		return common.CreateErrorResponse(409, "Expense approval already decided")
+	}
+
+	// Record the decision, unless another admin decided in the meantime, storing the
+	// approved expense in the same transaction
+	approval.Status = status
+	approval.DecidedBy = claims.Sub
+	approval.DecidedAt = time.Now().Format(time.RFC3339)
+	puts := []common.ConditionalPut{
+		{TableName: "splitter-expense-approvals", Item: approval, Condition: common.IfEquals("status", ExpenseApprovalPending)},
+	}
+	if status == ExpenseApprovalApproved {
+		puts = append(puts, common.ConditionalPut{TableName: "splitter-expenses", Item: approval.Expense, Condition: common.IfNotExists("expenseId")})
+	}
+	err = common.TransactPutItems(context.TODO(), h.client, puts)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expense approval already decided")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Expense %s of group %s was %s by %s", expenseId, groupId, status, claims.Sub)
+	if status == ExpenseApprovalApproved {
+		if approval.Expense.ReceiptID != "" {
+			if err := h.assignApprovedReceipt(context.TODO(), approval.Expense); err != nil {
+				log.Printf("Error assigning receipt %s of expense %s: %v", approval.Expense.ReceiptID, expenseId, err)
+			}
+		}
+		h.announceExpenses(context.TODO(), groupId, approval.Expense)
+	}
+
+	// Let the creator know, without failing the decision if the notification can't be stored
+	notificationType := NotificationExpenseApproved
+	if status == ExpenseApprovalDenied {
+		notificationType = NotificationExpenseDenied
+	}
+	notifyMembers(context.TODO(), []string{approval.CreatedBy}, notificationType, map[string]string{
+		"groupId": groupId, "groupName": admin.GroupName, "expenseId": expenseId, "title": approval.Expense.Title,
+	})
+
+	// Marshal the expense approval into JSON for the payload
+	payload, err := json.Marshal(approval)
+	if err != nil {
+		log.Println("Error marshalling expense approval:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
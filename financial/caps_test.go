@@ -0,0 +1,286 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/fx"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCapsFake(t *testing.T, policy string) (*Handlers, *testutil.FakeDynamoDB) {
+	thisMonth := time.Now().UTC().Format(time.RFC3339)
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House", "role": RoleMember},
+		},
+		"splitter-group-settings": {{"groupId": "test-group-id", "defaultCurrency": "EUR"}},
+		"splitter-spending-caps": {{
+			"groupId": "test-group-id", "policy": policy, "caps": map[string]interface{}{"user-2": "100.00"}, "version": 1,
+		}},
+		"splitter-expenses": {{
+			"groupId": "test-group-id", "expenseId": "groceries", "title": "Groceries", "amount": "160", "currency": "EUR",
+			"paidBy": "user-1", "dateTime": thisMonth,
+			"participants": []map[string]interface{}{{"userId": "user-1", "calculatedMoney": "80.00"}, {"userId": "user-2", "calculatedMoney": "80.00"}},
+		}},
+	})
+	assert.NoError(t, err)
+	notifications.DynamoDbClient = fake
+	return NewHandlers(fake), fake
+}
+
+func postCappedExpense(t *testing.T, h *Handlers, userId, amount string) events.APIGatewayProxyResponse {
+	response, err := h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims(userId, userId).
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, FinancialExpense{
+			Title:        "Dinner",
+			Amount:       json.Number(amount),
+			Currency:     "EUR",
+			DateTime:     time.Now().UTC().Format(time.RFC3339),
+			PaidBy:       userId,
+			Participants: []Participant{{UserID: "user-1", Share: "50"}, {UserID: "user-2", Share: "50"}},
+		}).
+		Build())
+	assert.NoError(t, err)
+	return response
+}
+
+func TestSpendingCapsWarn(t *testing.T) {
+	h, _ := newCapsFake(t, CapPolicyWarn)
+
+	// Within the cap, the expense is added without a warning
+	response := postCappedExpense(t, h, "user-2", "20")
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Empty(t, response.MultiValueHeaders[common.WarningHeader])
+
+	// Over it, the expense is added with a warning
+	response = postCappedExpense(t, h, "user-2", "30")
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, []string{"User user-2 exceeds the monthly spending cap of 100.00 EUR with 105.00 EUR"}, response.MultiValueHeaders[common.WarningHeader])
+
+	// The members see how much of the caps was spent this month
+	response, err := h.GetSpendingCapsHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	var caps GroupSpendingCaps
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &caps))
+	assert.Len(t, caps.Statuses, 1)
+	assert.Equal(t, json.Number("105.00"), caps.Statuses[0].Spent)
+	assert.Equal(t, json.Number("-5.00"), caps.Statuses[0].Remaining)
+	assert.True(t, caps.Statuses[0].Exceeded)
+}
+
+func TestSpendingCapsApproval(t *testing.T) {
+	h, fake := newCapsFake(t, CapPolicyApproval)
+
+	// The member's expense over the cap waits for an admin
+	response := postCappedExpense(t, h, "user-2", "50")
+	assert.Equal(t, http.StatusAccepted, response.StatusCode)
+	var approval ExpenseApproval
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &approval))
+	assert.Equal(t, ExpenseApprovalPending, approval.Status)
+	assert.Equal(t, "user-2", approval.Exceeded[0].UserID)
+	expense, err := h.getExpense(t.Context(), "test-group-id", approval.ExpenseID)
+	assert.NoError(t, err)
+	assert.Nil(t, expense)
+	assert.Equal(t, []string{NotificationApprovalRequested}, notificationsOf(t, fake, "user-1"))
+
+	// An admin's expense is only warned about
+	response = postCappedExpense(t, h, "user-1", "50")
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Len(t, response.MultiValueHeaders[common.WarningHeader], 1)
+
+	// Only the admins can decide, and only once
+	decide := func(userId string, approve bool) int {
+		request := testutil.NewRequest("POST", "").
+			WithClaims(userId, userId).
+			WithPathParam("groupId", "test-group-id").
+			WithPathParam("expenseId", approval.ExpenseID).
+			Build()
+		handler := h.DenyExpenseHandler
+		if approve {
+			handler = h.ApproveExpenseHandler
+		}
+		response, err := handler(request)
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+	assert.Equal(t, http.StatusForbidden, decide("user-2", true))
+	assert.Equal(t, http.StatusOK, decide("user-1", true))
+	assert.Equal(t, http.StatusConflict, decide("user-1", false))
+
+	expense, err = h.getExpense(t.Context(), "test-group-id", approval.ExpenseID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Dinner", expense.Title)
+	assert.Equal(t, []string{NotificationExpenseApproved}, notificationsOf(t, fake, "user-2"))
+}
+
+// concurrentDenial is a DynamoDB client on which another admin denies an expense approval
+// right after it is read.
+type concurrentDenial struct {
+	*testutil.FakeDynamoDB
+}
+
+func (c concurrentDenial) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	result, err := c.FakeDynamoDB.GetItem(ctx, params, optFns...)
+	if err != nil || result.Item == nil || aws.ToString(params.TableName) != "splitter-expense-approvals" {
+		return result, err
+	}
+	denied := maps.Clone(result.Item)
+	denied["status"] = &types.AttributeValueMemberS{Value: ExpenseApprovalDenied}
+	_, err = c.FakeDynamoDB.PutItem(ctx, &dynamodb.PutItemInput{TableName: params.TableName, Item: denied})
+	return result, err
+}
+
+func TestSpendingCapsApprovalRace(t *testing.T) {
+	h, fake := newCapsFake(t, CapPolicyApproval)
+	response := postCappedExpense(t, h, "user-2", "50")
+	assert.Equal(t, http.StatusAccepted, response.StatusCode)
+	var approval ExpenseApproval
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &approval))
+
+	// The approval losing to a denial doesn't store the expense
+	response, err := NewHandlers(concurrentDenial{fake}).ApproveExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", approval.ExpenseID).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+	assert.Equal(t, 1, storedExpenseCount(t, fake))
+	decided, err := h.getExpenseApproval(t.Context(), "test-group-id", approval.ExpenseID)
+	assert.NoError(t, err)
+	assert.Equal(t, ExpenseApprovalDenied, decided.Status)
+}
+
+func TestSpendingCapsUnreadableDate(t *testing.T) {
+	h, _ := newCapsFake(t, CapPolicyApproval)
+
+	// An expense without a date readable as RFC 3339 counts in the current month
+	response, err := h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, FinancialExpense{
+			Title:        "Dinner",
+			Amount:       "50",
+			Currency:     "EUR",
+			DateTime:     "yesterday",
+			PaidBy:       "user-2",
+			Participants: []Participant{{UserID: "user-1", Share: "50"}, {UserID: "user-2", Share: "50"}},
+		}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, response.StatusCode)
+}
+
+func TestPutSpendingCapsHandler(t *testing.T) {
+	h, _ := newCapsFake(t, CapPolicyWarn)
+	put := func(userId string, body map[string]interface{}) (int, GroupSpendingCaps) {
+		response, err := h.PutSpendingCapsHandler(testutil.NewRequest("PUT", "").
+			WithClaims(userId, userId).
+			WithPathParam("groupId", "test-group-id").
+			WithJSONBody(t, body).
+			Build())
+		assert.NoError(t, err)
+		var caps GroupSpendingCaps
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &caps))
+		}
+		return response.StatusCode, caps
+	}
+
+	statusCode, _ := put("user-2", map[string]interface{}{"caps": map[string]string{"user-2": "500"}, "version": 1})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	statusCode, _ = put("user-1", map[string]interface{}{"caps": map[string]string{"user-2": "-5"}, "version": 1})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = put("user-1", map[string]interface{}{"caps": map[string]string{"user-3": "50"}, "version": 1})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = put("user-1", map[string]interface{}{"policy": "BLOCK", "version": 1})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = put("user-1", map[string]interface{}{"caps": map[string]string{"user-2": "500"}, "version": 0})
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	statusCode, caps := put("user-1", map[string]interface{}{"policy": CapPolicyApproval, "caps": map[string]string{"user-1": "50", "user-2": "500"}, "version": 1})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, 2, caps.Version)
+	assert.Equal(t, map[string]json.Number{"user-1": "50.00", "user-2": "500.00"}, caps.Caps)
+	assert.Equal(t, []bool{true, false}, []bool{caps.Statuses[0].Exceeded, caps.Statuses[1].Exceeded})
+}
+
+func TestShareInCurrency(t *testing.T) {
+	participant := Participant{UserID: "user-1", CalculatedMoney: "10.00"}
+
+	share, ok := shareInCurrency(FinancialExpense{Currency: "EUR"}, participant, "EUR")
+	assert.True(t, ok)
+	assert.Equal(t, "10.00", share.FloatString(2))
+
+	// The expenses in another currency count at the rate they were converted at
+	share, ok = shareInCurrency(FinancialExpense{Currency: "USD", GroupAmount: &fx.Conversion{Currency: "EUR", Rate: "0.9"}}, participant, "EUR")
+	assert.True(t, ok)
+	assert.Equal(t, "9.00", share.FloatString(2))
+
+	_, ok = shareInCurrency(FinancialExpense{Currency: "USD"}, participant, "EUR")
+	assert.False(t, ok)
+}
+
+func TestSpendingCapsApprovalElsewhere(t *testing.T) {
+	dinner := func(amount string) FinancialExpense {
+		return FinancialExpense{
+			Title:        "Dinner",
+			Amount:       json.Number(amount),
+			Currency:     "EUR",
+			DateTime:     time.Now().UTC().Format(time.RFC3339),
+			PaidBy:       "user-2",
+			Participants: []Participant{{UserID: "user-1", Share: "50"}, {UserID: "user-2", Share: "50"}},
+		}
+	}
+	postBatch := func(h *Handlers, transactional bool, expenses ...FinancialExpense) []ExpenseBatchResult {
+		response, err := h.PostGroupExpenseBatchHandler(testutil.NewRequest("POST", "").
+			WithClaims("user-2", "bob").
+			WithPathParam("groupId", "test-group-id").
+			WithJSONBody(t, ExpenseBatch{Transactional: transactional, Expenses: expenses}).
+			Build())
+		assert.NoError(t, err)
+		var results []ExpenseBatchResult
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &results))
+		return results
+	}
+
+	// An expense added by a command waits for an admin like the others
+	h, fake := newCapsFake(t, CapPolicyApproval)
+	added, err := h.AddExpense(t.Context(), "user-2", "test-group-id", dinner("50"))
+	assert.NoError(t, err)
+	assert.NotNil(t, added.Approval)
+	expense, err := h.getExpense(t.Context(), "test-group-id", added.Expense.ExpenseID)
+	assert.NoError(t, err)
+	assert.Nil(t, expense)
+
+	// In a batch, the expense going over the cap after the previous ones is held
+	results := postBatch(h, false, dinner("30"), dinner("30"))
+	assert.Equal(t, http.StatusCreated, results[0].StatusCode)
+	assert.Equal(t, http.StatusAccepted, results[1].StatusCode)
+	assert.Equal(t, ExpenseApprovalPending, results[1].Approval.Status)
+	assert.Equal(t, 2, storedExpenseCount(t, fake))
+
+	// In a transactional batch, it fails the batch
+	h, fake = newCapsFake(t, CapPolicyApproval)
+	results = postBatch(h, true, dinner("30"), dinner("30"))
+	assert.Equal(t, http.StatusFailedDependency, results[0].StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, results[1].StatusCode)
+	assert.Equal(t, 1, storedExpenseCount(t, fake))
+}
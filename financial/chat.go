@@ -0,0 +1,293 @@
+package financial
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/realtime"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// chatTimeLayout is a fixed-width RFC 3339 layout, so the creation times of the messages,
+// the sort key of the chat, sort in order as strings.
+const chatTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// The limits of the chat listing and messages.
+const (
+	defaultChatPageSize = 50
+	maxChatPageSize     = 100
+	maxChatMessageRunes = 2000
+)
+
+// GroupChatMessage struct for the splitter-group-chat table, a message of a member to the
+// other members of the group, apart from the conversations with the assistant.
+type GroupChatMessage struct {
+	GroupID   string    `json:"groupId" dynamodbav:"groupId"`
+	CreatedAt string    `json:"createdAt" dynamodbav:"createdAt"`
+	MessageID string    `json:"messageId" dynamodbav:"messageId"`
+	UserID    string    `json:"userId" dynamodbav:"userId"`
+	User      User      `json:"user" dynamodbav:"-"`
+	Content   string    `json:"content" dynamodbav:"content"`
+	Mentions  []Mention `json:"mentions,omitempty" dynamodbav:"mentions,omitempty"`
+}
+
+// chatCursor points right after the last message of a page, by its creation time.
+type chatCursor struct {
+	Before string `json:"before"`
+}
+
+// parseChatPage reads the optional limit and cursor query parameters of the chat listing.
+func parseChatPage(request events.APIGatewayProxyRequest) (int, string, error) {
+	limit := defaultChatPageSize
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxChatPageSize {
+			return 0, "", errInvalidLimit
+		}
+		limit = parsed
+	}
+
+	var before string
+	if value := request.QueryStringParameters["cursor"]; value != "" {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return 0, "", errInvalidCursor
+		}
+		var cursor chatCursor
+		if err := json.Unmarshal(data, &cursor); err != nil || cursor.Before == "" {
+			return 0, "", errInvalidCursor
+		}
+		before = cursor.Before
+	}
+	return limit, before, nil
+}
+
+// queryGroupChat returns a page of the messages of the group, newest first, created before
+// the given time when not empty, along with the cursor of the next page, or "" on the last page.
+func (h *Handlers) queryGroupChat(ctx context.Context, groupId string, limit int, before string) ([]GroupChatMessage, string, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-group-chat"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ScanIndexForward: aws.Bool(false),
+		// One more message than asked for tells whether there is a next page
+		Limit: aws.Int32(int32(limit + 1)),
+	}
+	if before != "" {
+		queryInput.KeyConditionExpression = aws.String("groupId = :groupId AND createdAt < :before")
+		queryInput.ExpressionAttributeValues[":before"] = &types.AttributeValueMemberS{Value: before}
+	}
+
+	result, err := h.client.Query(ctx, queryInput)
+	if err != nil {
+		return nil, "", err
+	}
+	messages := []GroupChatMessage{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &messages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(messages) <= limit {
+		return messages, "", nil
+	}
+	messages = messages[:limit]
+	data, err := json.Marshal(chatCursor{Before: messages[limit-1].CreatedAt})
+	if err != nil {
+		return nil, "", err
+	}
+	return messages, base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// populateChatUsers fills in the details of the authors of the messages, with the nicknames
+// the viewer gave them.
+func (h *Handlers) populateChatUsers(ctx context.Context, viewerId string, messages []GroupChatMessage) error {
+	userIds := make(map[string]struct{})
+	for _, message := range messages {
+		userIds[message.UserID] = struct{}{}
+	}
+	userMap, err := h.getUsersForViewer(ctx, viewerId, userIds)
+	if err != nil {
+		return err
+	}
+	for i, message := range messages {
+		messages[i].User = userMap.User(message.UserID)
+	}
+	return nil
+}
+
+// GetGroupChatHandler lists the chat messages of the group, newest first, a page at a time:
+// the X-Next-Cursor header of the response is the cursor query parameter of the next page.
+func (h *Handlers) GetGroupChatHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the optional page
+	limit, before, err := parseChatPage(request)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	messages, nextCursor, err := h.queryGroupChat(context.TODO(), groupId, limit, before)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Fetch the details of the authors, batching the BatchGetItem calls
+	err = h.populateChatUsers(context.TODO(), claims.Sub, messages)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the messages into JSON for the payload
+	payload, err := json.Marshal(messages)
+	if err != nil {
+		log.Println("Error marshalling group chat messages:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	if nextCursor != "" {
+		headers[NextCursorHeader] = nextCursor
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    headers,
+		Body:       string(payload),
+	}, nil
+}
+
+// PostGroupChatHandler posts a message of the user to the chat of the group, broadcasting
+// it to the connected clients of the members.
+func (h *Handlers) PostGroupChatHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a GroupChatMessage struct
+	var message GroupChatMessage
+	err = json.Unmarshal([]byte(request.Body), &message)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	message.Content = strings.TrimSpace(message.Content)
+	if message.Content == "" {
+		return common.CreateErrorResponse(400, "Message content is missing")
+	}
+	if utf8.RuneCountInString(message.Content) > maxChatMessageRunes {
+		return common.CreateErrorResponse(400, "Message is too long")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	mentions, err := h.mentionsOf(context.TODO(), groupId, claims.Sub, message.Content)
+	if err != nil {
+		log.Printf("Error resolving mentions: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	message = GroupChatMessage{
+		GroupID:   groupId,
+		CreatedAt: time.Now().UTC().Format(chatTimeLayout),
+		MessageID: uuid.New().String(),
+		UserID:    claims.Sub,
+		Content:   message.Content,
+		Mentions:  mentions,
+	}
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-group-chat", message, common.IfNotExists("groupId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Message already exists")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s posted chat message %s to group %s", claims.Sub, message.MessageID, groupId)
+	h.notifyMentioned(context.TODO(), groupId, claims.Sub, mentions, NotificationMentionedInChat, map[string]string{"messageId": message.MessageID})
+
+	// The message is broadcast to every member, so without the nicknames of the caller
+	posted := []GroupChatMessage{message}
+	err = h.populateChatUsers(context.TODO(), "", posted)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+	}
+	message = posted[0]
+	if realtime.Enabled() {
+		userIds, err := h.getGroupMemberIds(context.TODO(), groupId)
+		if err != nil {
+			log.Printf("Error getting the members of group %s to broadcast to: %v", groupId, err)
+		} else {
+			realtime.Publish(context.TODO(), realtime.EventGroupMessageCreated, message, userIds...)
+		}
+	}
+
+	// Marshal the message into JSON for the payload
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Println("Error marshalling group chat message:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,80 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupChat(t *testing.T) {
+	// Set up the fake DynamoDB with two housemates and an outsider
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice", "showableName": "Alice"},
+			{"userId": "user-2", "username": "bob", "showableName": "Bob"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	post := func(sub, content string) int {
+		response, err := h.PostGroupChatHandler(testutil.NewRequest("POST", "").
+			WithClaims(sub, sub).
+			WithPathParam("groupId", "house").
+			WithJSONBody(t, map[string]string{"content": content}).
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+	assert.Equal(t, http.StatusCreated, post("user-1", "Who buys the milk?"))
+	assert.Equal(t, http.StatusCreated, post("user-2", "  Me!  "))
+	assert.Equal(t, http.StatusCreated, post("user-1", "Thanks"))
+	assert.Equal(t, http.StatusBadRequest, post("user-1", "   "))
+	assert.Equal(t, http.StatusNotFound, post("user-3", "Hi"))
+
+	get := func(cursor string) ([]GroupChatMessage, string) {
+		builder := testutil.NewRequest("GET", "").
+			WithClaims("user-2", "bob").
+			WithPathParam("groupId", "house").
+			WithQueryParam("limit", "2")
+		if cursor != "" {
+			builder = builder.WithQueryParam("cursor", cursor)
+		}
+		response, err := h.GetGroupChatHandler(builder.Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var messages []GroupChatMessage
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &messages))
+		return messages, response.Headers[NextCursorHeader]
+	}
+
+	// The newest messages come first, with their authors
+	messages, cursor := get("")
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "Thanks", messages[0].Content)
+	assert.Equal(t, "Me!", messages[1].Content)
+	assert.Equal(t, "Bob", messages[1].User.ShowableName)
+	assert.NotEmpty(t, cursor)
+
+	// The next page holds the rest
+	messages, cursor = get(cursor)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "Who buys the milk?", messages[0].Content)
+	assert.Equal(t, "Alice", messages[0].User.ShowableName)
+	assert.Empty(t, cursor)
+
+	// The outsider can't read the chat
+	response, err := h.GetGroupChatHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-3", "carol").
+		WithPathParam("groupId", "house").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
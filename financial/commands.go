@@ -0,0 +1,218 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	// ErrGroupNotFound is returned when no group of the user has the name looked up.
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrAmbiguousGroup is returned when the name looked up matches several groups of the user.
+	ErrAmbiguousGroup = errors.New("more than one group matches")
+)
+
+// InvalidExpenseError is returned by AddExpense for expenses failing validation, with the
+// message the expense endpoints answer with.
+type InvalidExpenseError struct {
+	Message string
+}
+
+func (e *InvalidExpenseError) Error() string {
+	return e.Message
+}
+
+// MemberDebt is what another member owes the user in a currency; negative when the
+// user owes them.
+type MemberDebt struct {
+	UserID   string      `json:"userId"`
+	User     User        `json:"user"`
+	Currency string      `json:"currency"`
+	Amount   json.Number `json:"amount"`
+}
+
+// UserGroupBalance is what the other members of a group owe the user.
+type UserGroupBalance struct {
+	GroupID   string       `json:"groupId"`
+	GroupName string       `json:"groupName"`
+	Balances  []MemberDebt `json:"balances"`
+}
+
+// UserGroups returns the groups of the user.
+func (h *Handlers) UserGroups(ctx context.Context, userId string) ([]GroupMember, error) {
+	return h.listUserGroups(ctx, userId)
+}
+
+// UserGroup returns the membership of the user in the group, or nil if the user isn't a member.
+func (h *Handlers) UserGroup(ctx context.Context, userId, groupId string) (*GroupMember, error) {
+	return h.getGroupMember(ctx, userId, groupId)
+}
+
+// Expense returns the expense of the group, or nil if there is none.
+func (h *Handlers) Expense(ctx context.Context, groupId, expenseId string) (*FinancialExpense, error) {
+	return h.getExpense(ctx, groupId, expenseId)
+}
+
+// GroupSetUp reports whether the group has default settings, chosen by its members or
+// seeded from a template.
+func (h *Handlers) GroupSetUp(ctx context.Context, groupId string) (bool, error) {
+	settings, err := h.getGroupSettings(ctx, groupId)
+	if err != nil {
+		return false, err
+	}
+	return settings.DefaultCategory != "" || settings.DefaultSplitType != "" || settings.DefaultCurrency != "", nil
+}
+
+// HasExpenseOf reports whether the group has an expense the user added or paid.
+func (h *Handlers) HasExpenseOf(ctx context.Context, userId, groupId string) (bool, error) {
+	expenses, err := h.queryGroupExpenses(ctx, groupId)
+	if err != nil {
+		return false, err
+	}
+	for _, expense := range expenses {
+		if expense.CreatedBy == userId || expense.PaidBy == userId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FindUserGroup returns the group of the user with the name, ignoring case. A prefix of the
+// name is enough when it matches a single group, and no name at all when the user has a
+// single group.
+func (h *Handlers) FindUserGroup(ctx context.Context, userId, name string) (GroupMember, error) {
+	groups, err := h.listUserGroups(ctx, userId)
+	if err != nil {
+		return GroupMember{}, err
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	var prefixed []GroupMember
+	for _, group := range groups {
+		groupName := strings.ToLower(group.GroupName)
+		if name != "" && groupName == name {
+			return group, nil
+		}
+		if strings.HasPrefix(groupName, name) {
+			prefixed = append(prefixed, group)
+		}
+	}
+	switch len(prefixed) {
+	case 0:
+		return GroupMember{}, ErrGroupNotFound
+	case 1:
+		return prefixed[0], nil
+	default:
+		return GroupMember{}, ErrAmbiguousGroup
+	}
+}
+
+// GetUserGroupBalance returns what the other members of the group owe the user, per member
+// and currency, leaving out the settled members.
+func (h *Handlers) GetUserGroupBalance(ctx context.Context, userId string, group GroupMember) (UserGroupBalance, error) {
+	settings, err := h.getGroupSettings(ctx, group.GroupID)
+	if err != nil {
+		return UserGroupBalance{}, err
+	}
+	expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: group.GroupID},
+		},
+	})
+	if err != nil {
+		return UserGroupBalance{}, err
+	}
+
+	balance := UserGroupBalance{GroupID: group.GroupID, GroupName: group.GroupName, Balances: []MemberDebt{}}
+	userIds := map[string]struct{}{}
+	for key, amount := range groupDebts(expenses, userId, settings.DefaultCurrency) {
+		if amount.Sign() == 0 {
+			continue
+		}
+		balance.Balances = append(balance.Balances, MemberDebt{
+			UserID:   key.userId,
+			Currency: key.currency,
+			Amount:   json.Number(amount.FloatString(2)),
+		})
+		userIds[key.userId] = struct{}{}
+	}
+	sort.Slice(balance.Balances, func(i, j int) bool {
+		if balance.Balances[i].UserID != balance.Balances[j].UserID {
+			return balance.Balances[i].UserID < balance.Balances[j].UserID
+		}
+		return balance.Balances[i].Currency < balance.Balances[j].Currency
+	})
+
+	users, err := h.getUsersForViewer(ctx, userId, userIds)
+	if err != nil {
+		return UserGroupBalance{}, err
+	}
+	for i := range balance.Balances {
+		balance.Balances[i].User = users.User(balance.Balances[i].UserID)
+	}
+	return balance, nil
+}
+
+// AddedExpense is what AddExpense did with an expense: stored it, with the warnings of the
+// spending caps it exceeds, or held it for the approval of an admin.
+type AddedExpense struct {
+	Expense  FinancialExpense
+	Approval *ExpenseApproval
+	Warnings []string
+}
+
+// AddExpense adds an expense of the user to the group, like the expense endpoint of the
+// group does. The user must be a member of the group, and the group have room for the
+// expense, a *QuotaError being returned otherwise. The expense is held for approval when
+// it takes members over their spending cap in a group with the approval policy.
+func (h *Handlers) AddExpense(ctx context.Context, userId, groupId string, expense FinancialExpense) (AddedExpense, error) {
+	member, err := h.getGroupMember(ctx, userId, groupId)
+	if err != nil {
+		return AddedExpense{}, err
+	}
+	if member == nil {
+		return AddedExpense{}, ErrNotGroupMember
+	}
+
+	message, err := h.prepareExpense(ctx, groupId, userId, &expense)
+	if err != nil {
+		return AddedExpense{}, err
+	}
+	if message != "" {
+		return AddedExpense{}, &InvalidExpenseError{Message: message}
+	}
+	quotaErr, err := h.checkExpenseQuota(ctx, groupId, 1)
+	if err != nil {
+		return AddedExpense{}, err
+	}
+	if quotaErr != nil {
+		return AddedExpense{}, quotaErr
+	}
+	approval, warnings, err := h.checkSpendingCaps(ctx, expense, userId)
+	if err != nil {
+		return AddedExpense{}, err
+	}
+	if approval != nil {
+		return AddedExpense{Expense: expense, Approval: approval}, nil
+	}
+
+	err = common.ConditionalPutItem(ctx, h.client, "splitter-expenses", expense, common.IfNotExists("expenseId"))
+	if err != nil {
+		return AddedExpense{}, err
+	}
+
+	log.Printf("Successfully created expense %s for group %s", expense.ExpenseID, expense.GroupID)
+	h.announceExpenses(ctx, expense.GroupID, expense)
+	return AddedExpense{Expense: expense, Warnings: warnings}, nil
+}
@@ -0,0 +1,163 @@
+package financial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"vassistant-backend/fx"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// errInvalidCurrency is returned for currency codes that are not ISO 4217 codes.
+var errInvalidCurrency = errors.New("Invalid display currency")
+
+// parseDisplayCurrency reads the optional displayCurrency query parameter.
+func parseDisplayCurrency(request events.APIGatewayProxyRequest) (string, error) {
+	currency := request.QueryStringParameters["displayCurrency"]
+	if currency != "" && !fx.ValidCurrency(currency) {
+		return "", errInvalidCurrency
+	}
+	return currency, nil
+}
+
+// expenseDate returns the date of the expense for its exchange rates in the time zone of the
+// group, today when it has none.
+func expenseDate(expense FinancialExpense, location *time.Location) string {
+	if dateTime, err := time.Parse(time.RFC3339, expense.DateTime); err == nil {
+		return dateTime.In(location).Format(time.DateOnly)
+	}
+	if len(expense.DateTime) >= len(time.DateOnly) {
+		if date, err := time.Parse(time.DateOnly, expense.DateTime[:len(time.DateOnly)]); err == nil {
+			return date.Format(time.DateOnly)
+		}
+	}
+	return time.Now().In(location).Format(time.DateOnly)
+}
+
+// convertToGroupCurrency records the amount of an expense in another currency than the
+// group default currency, at the rate of the expense date, so the rate applied stays known.
+// The expense is left without one when no rate is known.
+func (h *Handlers) convertToGroupCurrency(ctx context.Context, expense *FinancialExpense) error {
+	if expense.Currency == "" {
+		return nil
+	}
+	settings, err := h.getGroupSettings(ctx, expense.GroupID)
+	if err != nil {
+		return err
+	}
+	if settings.DefaultCurrency == "" || settings.DefaultCurrency == expense.Currency {
+		return nil
+	}
+
+	rate, err := fx.HistoricalRate(ctx, expense.Currency, settings.DefaultCurrency, expenseDate(*expense, settings.location()))
+	if errors.Is(err, fx.ErrRateNotFound) {
+		log.Printf("No %s to %s rate for expense %s", expense.Currency, settings.DefaultCurrency, expense.ExpenseID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	conversion, err := fx.Convert(expense.Amount, settings.DefaultCurrency, rate)
+	if err != nil {
+		return err
+	}
+	expense.GroupAmount = &conversion
+	return nil
+}
+
+// convertExpenses sets the amount of each expense in the display currency, using the rates
+// of the expense dates. The amount recorded in the group currency is reused when that is the
+// display currency. Expenses without a currency are taken to be in the group default
+// currency; when the group has none either, they are left unconverted.
+func (h *Handlers) convertExpenses(ctx context.Context, groupId string, expenses []FinancialExpense, displayCurrency string) error {
+	rates := map[string]fx.Rate{}
+	var settings *GroupSettings
+	loadSettings := func() error {
+		if settings != nil {
+			return nil
+		}
+		loaded, err := h.getGroupSettings(ctx, groupId)
+		settings = &loaded
+		return err
+	}
+
+	for i, expense := range expenses {
+		currency := expense.Currency
+		if currency == "" {
+			if err := loadSettings(); err != nil {
+				return err
+			}
+			currency = settings.DefaultCurrency
+		}
+		if currency == "" {
+			log.Printf("Expense %s has no currency, not converting it", expense.ExpenseID)
+			continue
+		}
+
+		if expense.GroupAmount != nil && expense.GroupAmount.Currency == displayCurrency {
+			conversion := *expense.GroupAmount
+			expenses[i].Display = &conversion
+			continue
+		}
+
+		// Fetch each rate once per request, for the date of the expense in the group
+		if err := loadSettings(); err != nil {
+			return err
+		}
+		date := expenseDate(expense, settings.location())
+		rate, ok := rates[currency+"/"+date]
+		if !ok {
+			var err error
+			rate, err = fx.HistoricalRate(ctx, currency, displayCurrency, date)
+			if err != nil {
+				return fmt.Errorf("converting %s to %s: %w", currency, displayCurrency, err)
+			}
+			rates[currency+"/"+date] = rate
+		}
+
+		conversion, err := fx.Convert(expense.Amount, displayCurrency, rate)
+		if err != nil {
+			return err
+		}
+		expenses[i].Display = &conversion
+	}
+	return nil
+}
+
+// isFormattedField reports whether the expense field holds amounts formatted for display.
+func isFormattedField(field string) bool {
+	return field == "amountText" || field == "display" || field == "groupAmount"
+}
+
+// formatExpenses sets the display strings of the amounts of the expenses, formatted with the
+// rules of the group. Groups without rules get none, leaving the formatting to the clients.
+// Expenses without a currency are taken to be in the group default currency.
+func (h *Handlers) formatExpenses(ctx context.Context, groupId string, expenses []FinancialExpense) error {
+	settings, err := h.getGroupSettings(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	if settings.Formatting == nil {
+		return nil
+	}
+
+	format := *settings.Formatting
+	for i, expense := range expenses {
+		currency := expense.Currency
+		if currency == "" {
+			currency = settings.DefaultCurrency
+		}
+		if expense.Amount != "" {
+			expenses[i].AmountText = format.Amount(expense.Amount, currency)
+		}
+		for _, conversion := range []*fx.Conversion{expense.Display, expense.GroupAmount} {
+			if conversion != nil {
+				conversion.Text = format.Amount(conversion.Amount, conversion.Currency)
+			}
+		}
+	}
+	return nil
+}
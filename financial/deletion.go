@@ -0,0 +1,478 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// GroupDeletedPending is the status of a deleted group that can still be restored.
+const GroupDeletedPending = "DELETED_PENDING"
+
+// DeletionRetention is how long a deleted group can be restored before it is purged.
+var DeletionRetention = 30 * 24 * time.Hour
+
+// GroupDeletion struct for the splitter-group-deletions table, listing the groups waiting to be purged
+type GroupDeletion struct {
+	GroupID    string `json:"groupId" dynamodbav:"groupId"`
+	Status     string `json:"status" dynamodbav:"status"`
+	DeletedBy  string `json:"deletedBy" dynamodbav:"deletedBy"`
+	DeletedAt  string `json:"deletedAt" dynamodbav:"deletedAt"`
+	PurgeAfter string `json:"purgeAfter" dynamodbav:"purgeAfter"`
+}
+
+// groupKeyedTables lists the tables holding the data purged with a group, with the index to
+// query them by group when the group ID isn't their partition key, and their key attributes.
+var groupKeyedTables = []struct {
+	Table string
+	Index string
+	Keys  []string
+}{
+	{"splitter-expenses", "groupId-dateTime-index", []string{"groupId", "expenseId"}},
+	{"splitter-join-requests", "", []string{"groupId", "userId"}},
+	{"splitter-group-chat", "", []string{"groupId", "createdAt"}},
+	{"splitter-group-members", "groupId-index", []string{"userId", "groupId"}},
+	{"splitter-sheet-links", "", []string{"groupId"}},
+	{"splitter-reimbursements", "", []string{"groupId", "reimbursementId"}},
+	{"splitter-expense-approvals", "", []string{"groupId", "expenseId"}},
+	{"splitter-spending-caps", "", []string{"groupId"}},
+	{"splitter-shopping-items", "", []string{"groupId", "itemId"}},
+	{"splitter-ledger", "", []string{"groupId", "sequence"}},
+	{"splitter-recurring-expenses", "", []string{"groupId", "recurringId"}},
+	{"splitter-recurring-suggestions", "", []string{"groupId", "suggestionId"}},
+	{"splitter-guest-links", "groupId-index", []string{"linkId"}},
+	{"splitter-group-insights", "", []string{"groupId", "period"}},
+	{"splitter-group-balances", "", []string{"groupId"}},
+	{"splitter-receipts", "groupId-index", []string{"receiptId"}},
+	{"splitter-email-inboxes", "groupId-index", []string{"inboxId"}},
+}
+
+// setGroupStatus sets the status of every membership of the group, which is what the group
+// listings read. The memberships are read back from the base table because the groupId
+// index only projects the keys.
+func (h *Handlers) setGroupStatus(ctx context.Context, groupId, status string) error {
+	memberIds, err := h.getGroupMemberIds(ctx, groupId)
+	if err != nil {
+		return err
+	}
+
+	for _, userId := range memberIds {
+		member, err := h.getGroupMember(ctx, userId, groupId)
+		if err != nil {
+			return err
+		}
+		if member == nil {
+			continue
+		}
+		member.Status = status
+		err = common.ConditionalPutItem(ctx, h.client, "splitter-group-members", member, common.IfExists("userId"))
+		if err != nil && !errors.Is(err, common.ErrConditionFailed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// getGroupDeletion returns the pending deletion of the group, or nil if the group isn't deleted.
+func (h *Handlers) getGroupDeletion(ctx context.Context, groupId string) (*GroupDeletion, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-group-deletions"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var deletion GroupDeletion
+	err = attributevalue.UnmarshalMap(result.Item, &deletion)
+	if err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+func (h *Handlers) DeleteGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only admins can delete the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can delete the group")
+	}
+
+	// Record the deletion first, so the purge job finds the group even if hiding it fails halfway
+	now := time.Now()
+	deletion := GroupDeletion{
+		GroupID:    groupId,
+		Status:     GroupDeletedPending,
+		DeletedBy:  claims.Sub,
+		DeletedAt:  now.Format(time.RFC3339),
+		PurgeAfter: now.Add(DeletionRetention).Format(time.RFC3339),
+	}
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-group-deletions", deletion, common.IfNotExists("groupId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Group already deleted")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	err = h.setGroupStatus(context.TODO(), groupId, GroupDeletedPending)
+	if err != nil {
+		log.Printf("Error hiding deleted group %s: %v", groupId, err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Group %s was deleted by %s and will be purged after %s", groupId, claims.Sub, deletion.PurgeAfter)
+
+	// Marshal the deletion into JSON for the payload
+	payload, err := json.Marshal(deletion)
+	if err != nil {
+		log.Println("Error marshalling group deletion:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) RestoreGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only admins can restore the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can restore the group")
+	}
+
+	deletion, err := h.getGroupDeletion(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group deletion from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if deletion == nil {
+		return common.CreateErrorResponse(409, "Group is not deleted")
+	}
+	purgeAfter, err := time.Parse(time.RFC3339, deletion.PurgeAfter)
+	if err != nil || !time.Now().Before(purgeAfter) {
+		return common.CreateErrorResponse(410, "Group can no longer be restored")
+	}
+
+	// Show the group again before dropping the deletion, so a failure can be retried
+	err = h.setGroupStatus(context.TODO(), groupId, "")
+	if err != nil {
+		log.Printf("Error restoring group %s: %v", groupId, err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	_, err = h.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("splitter-group-deletions"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting item from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Group %s was restored by %s", groupId, claims.Sub)
+
+	// Marshal the restored membership into JSON for the payload
+	admin.Status = ""
+	payload, err := json.Marshal(admin)
+	if err != nil {
+		log.Println("Error marshalling group member:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// PurgeOptions paces the purge job, so deleting large groups doesn't eat the write
+// capacity the API needs.
+type PurgeOptions struct {
+	BatchSize        int // the items read and deleted per batch, defaultPurgeBatchSize when unset
+	DeletesPerSecond int // unlimited when unset
+}
+
+// PurgeResult counts what a purge run deleted.
+type PurgeResult struct {
+	Groups int
+	Items  map[string]int // per table
+}
+
+// defaultPurgeBatchSize is the number of items read and deleted per batch by default.
+const defaultPurgeBatchSize = 100
+
+// pause waits long enough after deleting count items to keep to the deletes per second.
+func (o PurgeOptions) pause(ctx context.Context, count int) error {
+	if o.DeletesPerSecond <= 0 || count == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(time.Duration(count) * time.Second / time.Duration(o.DeletesPerSecond)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// purgeDue reports whether the deleted group is past its retention. The group is never
+// purged before the date it was promised when deleted, even if the retention was shortened
+// since, but is kept longer when it was lengthened.
+func purgeDue(deletion GroupDeletion, now time.Time) (bool, error) {
+	purgeAfter, err := time.Parse(time.RFC3339, deletion.PurgeAfter)
+	if err != nil {
+		return false, err
+	}
+	if deletedAt, err := time.Parse(time.RFC3339, deletion.DeletedAt); err == nil && deletedAt.Add(DeletionRetention).After(purgeAfter) {
+		purgeAfter = deletedAt.Add(DeletionRetention)
+	}
+	return !now.Before(purgeAfter), nil
+}
+
+// PurgeDeletedGroups permanently deletes the data of the groups whose retention ended
+// before now, in batches paced by the options. It is run on a schedule by the purge-groups
+// job of the scheduler and returns what was purged, up to the error if any.
+func (h *Handlers) PurgeDeletedGroups(ctx context.Context, now time.Time, options PurgeOptions) (PurgeResult, error) {
+	result := PurgeResult{Items: map[string]int{}}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultPurgeBatchSize
+	}
+
+	var deletions []GroupDeletion
+	var startKey map[string]types.AttributeValue
+	for {
+		output, err := h.client.Scan(ctx, &dynamodb.ScanInput{
+			TableName:         aws.String("splitter-group-deletions"),
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		var page []GroupDeletion
+		err = attributevalue.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return result, err
+		}
+		deletions = append(deletions, page...)
+
+		startKey = output.LastEvaluatedKey
+		if len(startKey) == 0 {
+			break
+		}
+	}
+
+	for _, deletion := range deletions {
+		due, err := purgeDue(deletion, now)
+		if err != nil {
+			log.Printf("Skipping deletion of group %s with invalid purgeAfter %q", deletion.GroupID, deletion.PurgeAfter)
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		err = h.purgeGroup(ctx, deletion.GroupID, options, result.Items)
+		if err != nil {
+			return result, err
+		}
+		result.Groups++
+		log.Printf("Purged group %s deleted at %s", deletion.GroupID, deletion.DeletedAt)
+	}
+	return result, nil
+}
+
+// purgeGroup deletes every item of the group, counting them per table, and drops the
+// deletion record last so an interrupted purge is picked up again by the next run.
+func (h *Handlers) purgeGroup(ctx context.Context, groupId string, options PurgeOptions, purged map[string]int) error {
+	// The nicknames are purged while the members of the group are still known
+	err := h.purgeNicknames(ctx, groupId, purged)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range groupKeyedTables {
+		err := h.purgeGroupItems(ctx, source.Table, source.Index, source.Keys, groupId, options, purged)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The residency goes last but for the deletion record, as it routes the group to its region
+	for _, table := range []string{"splitter-group-settings", "splitter-group-residency", "splitter-group-deletions"} {
+		_, err := h.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(table),
+			Key: map[string]types.AttributeValue{
+				"groupId": &types.AttributeValueMemberS{Value: groupId},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeNicknames deletes the nicknames the members of the group gave each other, unless
+// they share another group. The nicknames are keyed by user rather than group, following a
+// member across the groups shared with them, so they go with the last group shared.
+func (h *Handlers) purgeNicknames(ctx context.Context, groupId string, purged map[string]int) error {
+	memberIds, err := h.getGroupMemberIds(ctx, groupId)
+	if err != nil {
+		return err
+	}
+	groupsOf := make(map[string]map[string]bool, len(memberIds))
+	for _, userId := range memberIds {
+		groups, err := h.listUserGroups(ctx, userId)
+		if err != nil {
+			return err
+		}
+		groupsOf[userId] = map[string]bool{}
+		for _, group := range groups {
+			if group.GroupID != groupId {
+				groupsOf[userId][group.GroupID] = true
+			}
+		}
+	}
+	shareGroup := func(userId, memberId string) bool {
+		for other := range groupsOf[userId] {
+			if groupsOf[memberId][other] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, userId := range memberIds {
+		nicknames, err := h.getNicknames(ctx, userId)
+		if err != nil {
+			return err
+		}
+		for memberId := range nicknames {
+			if _, member := groupsOf[memberId]; !member || shareGroup(userId, memberId) {
+				continue
+			}
+			_, err = h.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName: aws.String("splitter-nicknames"),
+				Key: map[string]types.AttributeValue{
+					"userId":   &types.AttributeValueMemberS{Value: userId},
+					"memberId": &types.AttributeValueMemberS{Value: memberId},
+				},
+			})
+			if err != nil {
+				return err
+			}
+			purged["splitter-nicknames"]++
+		}
+	}
+	return nil
+}
+
+// purgeGroupItems deletes all the items of the group in the table, a page of keys at a time.
+func (h *Handlers) purgeGroupItems(ctx context.Context, table, index string, keyAttributes []string, groupId string, options PurgeOptions, purged map[string]int) error {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		Limit: aws.Int32(int32(options.BatchSize)),
+	}
+	if index != "" {
+		queryInput.IndexName = aws.String(index)
+	}
+	queryInput.ProjectionExpression, queryInput.ExpressionAttributeNames = common.ProjectionExpression(keyAttributes)
+
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return err
+		}
+		for _, item := range result.Items {
+			key := make(map[string]types.AttributeValue, len(keyAttributes))
+			for _, name := range keyAttributes {
+				key[name] = item[name]
+			}
+			_, err = h.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName: aws.String(table),
+				Key:       key,
+			})
+			if err != nil {
+				return err
+			}
+			purged[table]++
+		}
+		if err := options.pause(ctx, len(result.Items)); err != nil {
+			return err
+		}
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return nil
+		}
+	}
+}
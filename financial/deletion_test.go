@@ -0,0 +1,242 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/residency"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newDeletionFake seeds a group administered by user-1 with one expense.
+func newDeletionFake(t *testing.T, deletions ...map[string]interface{}) (*Handlers, *testutil.FakeDynamoDB) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House", "role": RoleMember},
+		},
+		"splitter-expenses": {
+			{"groupId": "test-group-id", "expenseId": "expense-1", "amount": 10, "dateTime": "2024-01-01T10:00:00Z"},
+		},
+		"splitter-group-settings": {
+			{"groupId": "test-group-id", "defaultCategory": "FOOD"},
+		},
+		"splitter-group-deletions": deletions,
+	})
+	assert.NoError(t, err)
+	return NewHandlers(fake), fake
+}
+
+// listGroups returns the groups the user sees in the group listing.
+func listGroups(t *testing.T, h *Handlers, userId string) []GroupMember {
+	response, err := h.GetGroupsHandler(testutil.NewRequest("GET", "").WithClaims(userId, "").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var groups []GroupMember
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &groups))
+	return groups
+}
+
+func TestDeleteAndRestoreGroup(t *testing.T) {
+	h, _ := newDeletionFake(t)
+	request := testutil.NewRequest("DELETE", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+
+	// Members who aren't admins can't delete the group
+	response, err := h.DeleteGroupHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// The deleted group is hidden from the listings of all the members
+	response, err = h.DeleteGroupHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, listGroups(t, h, "user-1"))
+	assert.Empty(t, listGroups(t, h, "user-2"))
+
+	response, err = h.DeleteGroupHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+
+	// Restoring shows it again
+	response, err = h.RestoreGroupHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Len(t, listGroups(t, h, "user-2"), 1)
+
+	response, err = h.RestoreGroupHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+}
+
+func TestRestoreGroupHandlerAfterGracePeriod(t *testing.T) {
+	h, _ := newDeletionFake(t, map[string]interface{}{
+		"groupId": "test-group-id", "status": GroupDeletedPending, "purgeAfter": time.Now().Add(-time.Hour).Format(time.RFC3339),
+	})
+
+	// Create a sample request
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+
+	// Call the handler
+	response, err := h.RestoreGroupHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response
+	assert.Equal(t, http.StatusGone, response.StatusCode)
+}
+
+func TestPurgeDeletedGroups(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	h, _ := newDeletionFake(t,
+		map[string]interface{}{"groupId": "test-group-id", "status": GroupDeletedPending, "purgeAfter": "2024-02-28T00:00:00Z"},
+		map[string]interface{}{"groupId": "other-group-id", "status": GroupDeletedPending, "purgeAfter": "2024-03-15T00:00:00Z"},
+	)
+
+	// Only the groups past their grace period are purged
+	result, err := h.PurgeDeletedGroups(context.TODO(), now, PurgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Groups)
+	assert.Equal(t, 1, result.Items["splitter-expenses"])
+	assert.Equal(t, 2, result.Items["splitter-group-members"])
+
+	memberIds, err := h.getGroupMemberIds(context.TODO(), "test-group-id")
+	assert.NoError(t, err)
+	assert.Empty(t, memberIds)
+
+	settings, err := h.getGroupSettings(context.TODO(), "test-group-id")
+	assert.NoError(t, err)
+	assert.Empty(t, settings.DefaultCategory)
+
+	deletion, err := h.getGroupDeletion(context.TODO(), "test-group-id")
+	assert.NoError(t, err)
+	assert.Nil(t, deletion)
+
+	deletion, err = h.getGroupDeletion(context.TODO(), "other-group-id")
+	assert.NoError(t, err)
+	assert.NotNil(t, deletion)
+
+	// Running again has nothing left to purge
+	result, err = h.PurgeDeletedGroups(context.TODO(), now, PurgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Groups)
+}
+
+func TestPurgeDeletedGroupsRetention(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	h, fake := newDeletionFake(t,
+		map[string]interface{}{"groupId": "test-group-id", "status": GroupDeletedPending, "deletedAt": "2024-01-20T00:00:00Z", "purgeAfter": "2024-02-19T00:00:00Z"},
+	)
+	for i := 2; i <= 5; i++ {
+		err := common.ConditionalPutItem(context.TODO(), fake, "splitter-expenses", FinancialExpense{GroupID: "test-group-id", ExpenseID: fmt.Sprintf("expense-%d", i), DateTime: "2024-01-01T10:00:00Z"}, common.IfNotExists("expenseId"))
+		assert.NoError(t, err)
+	}
+
+	// A lengthened retention keeps the groups deleted before it changed longer
+	retention := DeletionRetention
+	DeletionRetention = 60 * 24 * time.Hour
+	defer func() { DeletionRetention = retention }()
+	result, err := h.PurgeDeletedGroups(context.TODO(), now, PurgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Groups)
+
+	// A shortened one never purges them before their purge date, and the items are deleted
+	// a batch at a time
+	DeletionRetention = 24 * time.Hour
+	result, err = h.PurgeDeletedGroups(context.TODO(), time.Date(2024, 2, 18, 0, 0, 0, 0, time.UTC), PurgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Groups)
+	result, err = h.PurgeDeletedGroups(context.TODO(), now, PurgeOptions{BatchSize: 2, DeletesPerSecond: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Groups)
+	assert.Equal(t, 5, result.Items["splitter-expenses"])
+
+	expenses, err := fake.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: "test-group-id"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, expenses.Items)
+}
+
+func TestGroupTablesPurged(t *testing.T) {
+	purged := map[string]bool{
+		"splitter-group-settings":  true,
+		"splitter-group-residency": true,
+		"splitter-group-deletions": true,
+		"splitter-nicknames":       true,
+	}
+	for _, source := range groupKeyedTables {
+		purged[source.Table] = true
+	}
+
+	// Every table keyed by group, or routed to the region of the group, is purged with it
+	for table, keys := range testutil.TableKeys() {
+		if slices.Contains(keys, "groupId") && !purged[table] {
+			t.Errorf("table %s is keyed by groupId but isn't purged with the group, add it to groupKeyedTables", table)
+		}
+	}
+	for table := range residency.DefaultTables {
+		if !purged[table] {
+			t.Errorf("table %s holds group data but isn't purged with the group, add it to groupKeyedTables", table)
+		}
+	}
+}
+
+func TestPurgeGroupNicknames(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id"},
+			{"userId": "user-2", "groupId": "test-group-id"},
+			{"userId": "user-3", "groupId": "test-group-id"},
+			{"userId": "user-1", "groupId": "other-group-id"},
+			{"userId": "user-3", "groupId": "other-group-id"},
+		},
+		"splitter-nicknames": {
+			{"userId": "user-1", "memberId": "user-2", "nickname": "Bobby"},
+			{"userId": "user-2", "memberId": "user-1", "nickname": "Al"},
+			{"userId": "user-1", "memberId": "user-3", "nickname": "Cici"},
+			{"userId": "user-3", "memberId": "user-1", "nickname": "Ally"},
+		},
+		"splitter-group-insights": {{"groupId": "test-group-id", "period": "2024-02"}},
+		"splitter-group-deletions": {
+			{"groupId": "test-group-id", "status": GroupDeletedPending, "purgeAfter": "2024-02-28T00:00:00Z"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// The nicknames go with the last group their members share
+	result, err := h.PurgeDeletedGroups(t.Context(), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), PurgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Items["splitter-nicknames"])
+	assert.Equal(t, 1, result.Items["splitter-group-insights"])
+
+	nicknames, err := h.getNicknames(t.Context(), "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"user-3": "Cici"}, nicknames)
+	nicknames, err = h.getNicknames(t.Context(), "user-2")
+	assert.NoError(t, err)
+	assert.Empty(t, nicknames)
+}
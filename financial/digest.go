@@ -0,0 +1,222 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// recurringLookback is how far back the occurrences of monthly expenses are looked for.
+	recurringLookback = 100 * 24 * time.Hour
+	// minRecurringGap and maxRecurringGap bound the days between two occurrences of a
+	// monthly expense.
+	minRecurringGap = 26 * 24 * time.Hour
+	maxRecurringGap = 35 * 24 * time.Hour
+	// upcomingHorizon is how far ahead the assistant announces a monthly expense.
+	upcomingHorizon = 3 * 24 * time.Hour
+
+	// spendingWeeks is how many weeks before the last one make up the usual weekly spending.
+	spendingWeeks = 8
+	// unusualSpendingFactor is how many times the usual weekly spending makes the last week unusual.
+	unusualSpendingFactor = 2
+)
+
+// UpcomingExpense is an expense the user took part in every month, expected again soon.
+type UpcomingExpense struct {
+	GroupID   string      `json:"groupId"`
+	GroupName string      `json:"groupName"`
+	Title     string      `json:"title"`
+	Amount    json.Number `json:"amount"`
+	Currency  string      `json:"currency"`
+	DueAt     string      `json:"dueAt"`
+}
+
+// UnusualSpending is a category in which the share of the user over the last week is well
+// above their usual weekly share.
+type UnusualSpending struct {
+	GroupID   string      `json:"groupId"`
+	GroupName string      `json:"groupName"`
+	Category  string      `json:"category"`
+	Currency  string      `json:"currency"`
+	Amount    json.Number `json:"amount"`
+	Usual     json.Number `json:"usual"`
+}
+
+// Digest is what the assistant may tell the user on its own about their groups.
+type Digest struct {
+	Upcoming []UpcomingExpense `json:"upcoming"`
+	Unusual  []UnusualSpending `json:"unusual"`
+}
+
+// Empty reports whether the digest has nothing to tell.
+func (d Digest) Empty() bool {
+	return len(d.Upcoming) == 0 && len(d.Unusual) == 0
+}
+
+// AssistantDigest returns the upcoming monthly expenses and the unusual spending of the
+// user across their groups, each in the days of its time zone.
+func (h *Handlers) AssistantDigest(ctx context.Context, userId string, now time.Time) (Digest, error) {
+	groups, err := h.listUserGroups(ctx, userId)
+	if err != nil {
+		return Digest{}, err
+	}
+
+	digest := Digest{Upcoming: []UpcomingExpense{}, Unusual: []UnusualSpending{}}
+	for _, group := range groups {
+		settings, err := h.getGroupSettings(ctx, group.GroupID)
+		if err != nil {
+			return Digest{}, err
+		}
+		expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String("splitter-expenses"),
+			KeyConditionExpression: aws.String("groupId = :groupId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":groupId": &types.AttributeValueMemberS{Value: group.GroupID},
+			},
+		})
+		if err != nil {
+			return Digest{}, err
+		}
+		groupNow := now.In(settings.location())
+		digest.Upcoming = append(digest.Upcoming, upcomingExpenses(group, expenses, userId, settings.DefaultCurrency, groupNow, upcomingHorizon)...)
+		digest.Unusual = append(digest.Unusual, unusualSpending(group, expenses, userId, settings.DefaultCurrency, groupNow)...)
+	}
+	return digest, nil
+}
+
+// takesPart reports whether the user paid or shares the expense.
+func takesPart(expense FinancialExpense, userId string) bool {
+	if expense.PaidBy == userId {
+		return true
+	}
+	for _, participant := range expense.Participants {
+		if participant.UserID == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// upcomingExpenses returns the expenses of the group the user took part in about every
+// month, with the same title and currency, whose next occurrence is within the horizon. The
+// occurrences are due in the time zone of now.
+func upcomingExpenses(group GroupMember, expenses []FinancialExpense, userId, defaultCurrency string, now time.Time, horizon time.Duration) []UpcomingExpense {
+	type occurrence struct {
+		at      time.Time
+		expense FinancialExpense
+	}
+	type key struct{ title, currency string }
+	series := map[key][]occurrence{}
+	for _, expense := range expenses {
+		at, err := time.Parse(time.RFC3339, expense.DateTime)
+		if err != nil || at.After(now) || now.Sub(at) > recurringLookback || !takesPart(expense, userId) {
+			continue
+		}
+		currency := expense.Currency
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		k := key{strings.ToLower(strings.TrimSpace(expense.Title)), currency}
+		series[k] = append(series[k], occurrence{at, expense})
+	}
+
+	var upcoming []UpcomingExpense
+	for k, occurrences := range series {
+		if len(occurrences) < 2 {
+			continue
+		}
+		sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].at.Before(occurrences[j].at) })
+		monthly := true
+		for i := 1; i < len(occurrences); i++ {
+			gap := occurrences[i].at.Sub(occurrences[i-1].at)
+			monthly = monthly && gap >= minRecurringGap && gap <= maxRecurringGap
+		}
+		last := occurrences[len(occurrences)-1]
+		due := last.at.AddDate(0, 1, 0)
+		if !monthly || !due.After(now) || due.Sub(now) > horizon {
+			continue
+		}
+		upcoming = append(upcoming, UpcomingExpense{
+			GroupID:   group.GroupID,
+			GroupName: group.GroupName,
+			Title:     last.expense.Title,
+			Amount:    last.expense.Amount,
+			Currency:  k.currency,
+			DueAt:     due.In(now.Location()).Format(time.RFC3339),
+		})
+	}
+	sort.Slice(upcoming, func(i, j int) bool { return upcoming[i].DueAt < upcoming[j].DueAt })
+	return upcoming
+}
+
+// unusualSpending returns the categories of the group in which the share of the user over
+// the last week is at least unusualSpendingFactor times their average over the weeks
+// before. The weeks are whole days in the time zone of now, the last one ending today.
+// Categories without spending in the weeks before have no usual spending to compare with
+// and are left out.
+func unusualSpending(group GroupMember, expenses []FinancialExpense, userId, defaultCurrency string, now time.Time) []UnusualSpending {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	weekStart := today.AddDate(0, 0, -6)
+	baselineStart := weekStart.AddDate(0, 0, -7*spendingWeeks)
+
+	type key struct{ category, currency string }
+	type totals struct{ week, baseline *big.Rat }
+	spending := map[key]*totals{}
+	for _, expense := range expenses {
+		at, err := time.Parse(time.RFC3339, expense.DateTime)
+		if err != nil || at.After(now) || at.Before(baselineStart) || isDisputed(expense) {
+			continue
+		}
+		for _, participant := range expense.Participants {
+			share, ok := new(big.Rat).SetString(string(participant.CalculatedMoney))
+			if participant.UserID != userId || !ok {
+				continue
+			}
+			currency := expense.Currency
+			if currency == "" {
+				currency = defaultCurrency
+			}
+			k := key{expense.Category, currency}
+			if spending[k] == nil {
+				spending[k] = &totals{new(big.Rat), new(big.Rat)}
+			}
+			if at.Before(weekStart) {
+				spending[k].baseline.Add(spending[k].baseline, share)
+			} else {
+				spending[k].week.Add(spending[k].week, share)
+			}
+		}
+	}
+
+	var unusual []UnusualSpending
+	for k, total := range spending {
+		usual := new(big.Rat).Quo(total.baseline, big.NewRat(spendingWeeks, 1))
+		threshold := new(big.Rat).Mul(usual, big.NewRat(unusualSpendingFactor, 1))
+		if usual.Sign() <= 0 || total.week.Cmp(threshold) < 0 {
+			continue
+		}
+		unusual = append(unusual, UnusualSpending{
+			GroupID:   group.GroupID,
+			GroupName: group.GroupName,
+			Category:  k.category,
+			Currency:  k.currency,
+			Amount:    json.Number(total.week.FloatString(2)),
+			Usual:     json.Number(usual.FloatString(2)),
+		})
+	}
+	sort.Slice(unusual, func(i, j int) bool {
+		if unusual[i].Category != unusual[j].Category {
+			return unusual[i].Category < unusual[j].Category
+		}
+		return unusual[i].Currency < unusual[j].Currency
+	})
+	return unusual
+}
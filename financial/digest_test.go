@@ -0,0 +1,84 @@
+package financial
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sharedExpense is an expense of user-1 shared equally with user-2.
+func sharedExpense(title, category, amount, half, dateTime string) FinancialExpense {
+	return FinancialExpense{
+		Title:    title,
+		Category: category,
+		Amount:   json.Number(amount),
+		PaidBy:   "user-1",
+		DateTime: dateTime,
+		Participants: []Participant{
+			{UserID: "user-1", CalculatedMoney: json.Number(half)},
+			{UserID: "user-2", CalculatedMoney: json.Number(half)},
+		},
+	}
+}
+
+func TestUpcomingExpenses(t *testing.T) {
+	group := GroupMember{GroupID: "house", GroupName: "House"}
+	now := time.Date(2024, 4, 13, 9, 0, 0, 0, time.UTC)
+	expenses := []FinancialExpense{
+		sharedExpense("Rent", "HOME", "1000", "500", "2024-02-15T10:00:00Z"),
+		sharedExpense("rent", "HOME", "1000", "500", "2024-03-15T10:00:00Z"),
+		// Monthly too, but due after the horizon
+		sharedExpense("Internet", "HOME", "40", "20", "2024-02-25T10:00:00Z"),
+		sharedExpense("Internet", "HOME", "40", "20", "2024-03-25T10:00:00Z"),
+		// Twice, but not a month apart
+		sharedExpense("Pizza", "FOOD", "30", "15", "2024-03-14T10:00:00Z"),
+		sharedExpense("Pizza", "FOOD", "30", "15", "2024-03-01T10:00:00Z"),
+	}
+
+	upcoming := upcomingExpenses(group, expenses, "user-2", "EUR", now, upcomingHorizon)
+	assert.Equal(t, []UpcomingExpense{{
+		GroupID:   "house",
+		GroupName: "House",
+		Title:     "rent",
+		Amount:    "1000",
+		Currency:  "EUR",
+		DueAt:     "2024-04-15T10:00:00Z",
+	}}, upcoming)
+
+	// Only the expenses the user takes part in count
+	assert.Empty(t, upcomingExpenses(group, expenses, "user-3", "EUR", now, upcomingHorizon))
+
+	// The occurrences are due in the time zone of the group
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	assert.NoError(t, err)
+	upcoming = upcomingExpenses(group, expenses, "user-2", "EUR", now.In(saoPaulo), upcomingHorizon)
+	assert.Len(t, upcoming, 1)
+	assert.Equal(t, "2024-04-15T07:00:00-03:00", upcoming[0].DueAt)
+}
+
+func TestUnusualSpending(t *testing.T) {
+	group := GroupMember{GroupID: "house", GroupName: "House"}
+	now := time.Date(2024, 4, 13, 9, 0, 0, 0, time.UTC)
+	expenses := []FinancialExpense{
+		// 80 of food over the 8 weeks before, 10 a usual week, then 25 this week
+		sharedExpense("Groceries", "FOOD", "80", "40", "2024-03-01T10:00:00Z"),
+		sharedExpense("Groceries", "FOOD", "80", "40", "2024-02-20T10:00:00Z"),
+		sharedExpense("Dinner", "FOOD", "50", "25", "2024-04-10T10:00:00Z"),
+		// Home spending this week as usual
+		sharedExpense("Cleaning", "HOME", "160", "80", "2024-03-05T10:00:00Z"),
+		sharedExpense("Cleaning", "HOME", "20", "10", "2024-04-11T10:00:00Z"),
+		// A new category has no usual spending to compare with
+		sharedExpense("Cinema", "FUN", "100", "50", "2024-04-12T10:00:00Z"),
+	}
+
+	assert.Equal(t, []UnusualSpending{{
+		GroupID:   "house",
+		GroupName: "House",
+		Category:  "FOOD",
+		Currency:  "EUR",
+		Amount:    "25.00",
+		Usual:     "10.00",
+	}}, unusualSpending(group, expenses, "user-1", "EUR", now))
+}
@@ -0,0 +1,370 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/notifications"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Statuses of a dispute
+const (
+	DisputeOpen     = "OPEN"
+	DisputeResolved = "RESOLVED"
+)
+
+// maxDisputeReasonLength bounds the reason and resolution of a dispute.
+const maxDisputeReasonLength = 500
+
+var errExpenseDisputed = errors.New("Expense is disputed")
+
+// Dispute is raised by a participant who disagrees with an expense and resolved by an admin.
+type Dispute struct {
+	Status     string `json:"status" dynamodbav:"status"`
+	Reason     string `json:"reason" dynamodbav:"reason"`
+	RaisedBy   string `json:"raisedBy" dynamodbav:"raisedBy"`
+	RaisedAt   string `json:"raisedAt" dynamodbav:"raisedAt"`
+	Resolution string `json:"resolution,omitempty" dynamodbav:"resolution,omitempty"`
+	ResolvedBy string `json:"resolvedBy,omitempty" dynamodbav:"resolvedBy,omitempty"`
+	ResolvedAt string `json:"resolvedAt,omitempty" dynamodbav:"resolvedAt,omitempty"`
+}
+
+// DisputeRequest struct for the dispute request body
+type DisputeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// DisputeResolution struct for the resolve and adjust request bodies. Adjusting also replaces
+// the amount and split of the expense.
+type DisputeResolution struct {
+	Resolution   string        `json:"resolution"`
+	Amount       json.Number   `json:"amount"`
+	Participants []Participant `json:"participants"`
+	Payers       []Payer       `json:"payers"`
+}
+
+// isDisputed reports whether the expense has an open dispute, keeping it out of settle-up.
+func isDisputed(expense FinancialExpense) bool {
+	return expense.Dispute != nil && expense.Dispute.Status == DisputeOpen
+}
+
+// involvedMembers returns the members concerned by the expense: its creator, payers and participants.
+func involvedMembers(expense FinancialExpense) []string {
+	seen := map[string]struct{}{}
+	var userIds []string
+	add := func(userId string) {
+		if _, ok := seen[userId]; ok || userId == "" {
+			return
+		}
+		seen[userId] = struct{}{}
+		userIds = append(userIds, userId)
+	}
+	add(expense.CreatedBy)
+	for _, payer := range expense.Payers {
+		add(payer.UserID)
+	}
+	for _, participant := range expense.Participants {
+		add(participant.UserID)
+	}
+	return userIds
+}
+
+// notifyMembers notifies the members, logging the notifications that can't be stored
+// rather than failing the request.
+func notifyMembers(ctx context.Context, userIds []string, notificationType string, data map[string]string) {
+	for _, userId := range userIds {
+		err := notifications.Notify(ctx, userId, notificationType, data)
+		if err != nil {
+			log.Printf("Error notifying user %s: %v", userId, err)
+		}
+	}
+}
+
+// parseDisputeText validates a dispute reason or resolution.
+func parseDisputeText(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	return text, text != "" && len(text) <= maxDisputeReasonLength
+}
+
+// storeDisputedExpense stores the expense, unless it was changed since it was read.
+func (h *Handlers) storeDisputedExpense(expense *FinancialExpense) (events.APIGatewayProxyResponse, error) {
+	expectedVersion := expense.Version
+	expense.Version = expectedVersion + 1
+	err := common.ConditionalPutItem(context.TODO(), h.client, "splitter-expenses", expense, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expense was modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the expense into JSON for the payload
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		log.Println("Error marshalling expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) DisputeExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and expenseId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Expense ID is missing")
+	}
+
+	// Parse the request body into a DisputeRequest struct
+	var disputeRequest DisputeRequest
+	err = json.Unmarshal([]byte(request.Body), &disputeRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	reason, ok := parseDisputeText(disputeRequest.Reason)
+	if !ok {
+		return common.CreateErrorResponse(400, "A reason of at most 500 characters is required")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	expense, err := h.getExpense(context.TODO(), groupId, expenseId)
+	if err != nil {
+		log.Printf("Error getting expense from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if expense == nil {
+		return common.CreateErrorResponse(404, "Expense not found")
+	}
+
+	// Only the members sharing or paying the expense can dispute it
+	involved := false
+	for _, participant := range expense.Participants {
+		involved = involved || participant.UserID == claims.Sub
+	}
+	if !involved && !isPayer(*expense, claims.Sub) {
+		return common.CreateErrorResponse(403, "Only the participants can dispute the expense")
+	}
+	if isDisputed(*expense) {
+		return common.CreateErrorResponse(409, "Expense is already disputed")
+	}
+
+	expense.Dispute = &Dispute{
+		Status:   DisputeOpen,
+		Reason:   reason,
+		RaisedBy: claims.Sub,
+		RaisedAt: time.Now().Format(time.RFC3339),
+	}
+	response, err := h.storeDisputedExpense(expense)
+	if err != nil || response.StatusCode != 200 {
+		return response, err
+	}
+
+	log.Printf("User %s disputed expense %s of group %s", claims.Sub, expenseId, groupId)
+
+	// Let the admins know there is a dispute to resolve
+	members, err := h.getGroupMembers(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group members from DynamoDB: %v", err)
+		return response, nil
+	}
+	var admins []string
+	for _, groupMember := range members {
+		if groupMember.Role == RoleAdmin && groupMember.UserID != claims.Sub {
+			admins = append(admins, groupMember.UserID)
+		}
+	}
+	notifyMembers(context.TODO(), admins, "EXPENSE_DISPUTED", map[string]string{
+		"groupId": groupId, "groupName": member.GroupName, "expenseId": expenseId, "title": expense.Title,
+	})
+	return response, nil
+}
+
+// ResolveDisputeHandler closes the open dispute of an expense, keeping the expense as is.
+func (h *Handlers) ResolveDisputeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.resolveDispute(request, false)
+}
+
+// AdjustDisputeHandler closes the open dispute of an expense, replacing its amount and split.
+func (h *Handlers) AdjustDisputeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.resolveDispute(request, true)
+}
+
+func (h *Handlers) resolveDispute(request events.APIGatewayProxyRequest, adjust bool) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and expenseId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Expense ID is missing")
+	}
+
+	// Parse the request body into a DisputeResolution struct
+	var resolution DisputeResolution
+	err = json.Unmarshal([]byte(request.Body), &resolution)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	text, ok := parseDisputeText(resolution.Resolution)
+	if !ok {
+		return common.CreateErrorResponse(400, "A resolution of at most 500 characters is required")
+	}
+
+	// Only admins can resolve the disputes of the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can resolve disputes")
+	}
+
+	expense, err := h.getExpense(context.TODO(), groupId, expenseId)
+	if err != nil {
+		log.Printf("Error getting expense from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if expense == nil {
+		return common.CreateErrorResponse(404, "Expense not found")
+	}
+	if !isDisputed(*expense) {
+		return common.CreateErrorResponse(409, "Expense is not disputed")
+	}
+
+	// Notify the members involved before and after an adjustment
+	involved := involvedMembers(*expense)
+	notificationType := "DISPUTE_RESOLVED"
+	if adjust {
+		message, err := adjustExpense(expense, resolution)
+		if err != nil {
+			log.Printf("Error adjusting expense: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if message != "" {
+			return common.CreateErrorResponse(400, message)
+		}
+		for _, userId := range involvedMembers(*expense) {
+			if !slices.Contains(involved, userId) {
+				involved = append(involved, userId)
+			}
+		}
+		notificationType = "DISPUTE_ADJUSTED"
+	}
+
+	expense.Dispute.Status = DisputeResolved
+	expense.Dispute.Resolution = text
+	expense.Dispute.ResolvedBy = claims.Sub
+	expense.Dispute.ResolvedAt = time.Now().Format(time.RFC3339)
+	response, err := h.storeDisputedExpense(expense)
+	if err != nil || response.StatusCode != 200 {
+		return response, err
+	}
+
+	log.Printf("Admin %s resolved the dispute of expense %s of group %s", claims.Sub, expenseId, groupId)
+
+	notifyMembers(context.TODO(), involved, notificationType, map[string]string{
+		"groupId": groupId, "groupName": admin.GroupName, "expenseId": expenseId, "title": expense.Title,
+	})
+	return response, nil
+}
+
+// adjustExpense replaces the amount, participants and payers of the expense given in the
+// resolution and calculates the split again, keeping what the remaining participants
+// settled already. It returns the message to send back when the adjustment is invalid.
+func adjustExpense(expense *FinancialExpense, resolution DisputeResolution) (string, error) {
+	if resolution.Amount == "" && resolution.Participants == nil && resolution.Payers == nil {
+		return "Nothing to adjust", nil
+	}
+
+	settled := map[string]Participant{}
+	for _, participant := range expense.Participants {
+		settled[participant.UserID] = participant
+	}
+	if resolution.Amount != "" {
+		expense.Amount = resolution.Amount
+		// Payers default to the single payer of the expense, paying the new amount
+		if resolution.Payers == nil && len(expense.Payers) == 1 {
+			expense.Payers = nil
+		}
+	}
+	if resolution.Participants != nil {
+		expense.Participants = resolution.Participants
+	}
+	if resolution.Payers != nil {
+		expense.Payers = resolution.Payers
+	}
+
+	err := calculateParticipantMoney(expense)
+	if errors.Is(err, errInvalidAmount) {
+		return "Invalid amount", nil
+	}
+	if errors.Is(err, errSharesTotal) {
+		return "Shares must add up to 100", nil
+	}
+	if err != nil {
+		return "Invalid share", nil
+	}
+	err = calculatePayers(expense)
+	if errors.Is(err, errPayersTotal) {
+		return "Payers must add up to the amount", nil
+	}
+	if err != nil {
+		return "Invalid payer", nil
+	}
+
+	for i := range expense.Participants {
+		participant := &expense.Participants[i]
+		previous := settled[participant.UserID]
+		participant.SettledAmount = previous.SettledAmount
+		participant.SettledAt = previous.SettledAt
+		participant.Settled = participant.SettledAmount != "" && outstanding(*expense, *participant).Sign() == 0
+	}
+	return "", nil
+}
@@ -0,0 +1,128 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newDisputesFake seeds an expense paid by user-1 and shared with user-2, in a group
+// administered by user-3.
+func newDisputesFake(t *testing.T) (*Handlers, *testutil.FakeDynamoDB) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House"},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House"},
+			{"userId": "user-3", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+		},
+		"splitter-expenses": {{
+			"groupId": "test-group-id", "expenseId": "expense-1", "title": "Internet", "amount": "60", "paidBy": "user-1",
+			"createdBy": "user-1", "dateTime": "2024-01-01T00:00:00Z", "participants": []map[string]interface{}{
+				{"userId": "user-1", "share": "50.00", "calculatedMoney": "30.00"},
+				{"userId": "user-2", "share": "50.00", "calculatedMoney": "30.00"},
+			},
+		}},
+	})
+	assert.NoError(t, err)
+	notifications.DynamoDbClient = fake
+	return NewHandlers(fake), fake
+}
+
+func callDisputeHandler(t *testing.T, handler func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error), user string, body map[string]interface{}) (int, FinancialExpense) {
+	request := testutil.NewRequest("POST", "").
+		WithClaims(user, user).
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", "expense-1").
+		WithJSONBody(t, body).
+		Build()
+
+	// Call the handler
+	response, err := handler(request)
+	assert.NoError(t, err)
+
+	var expense FinancialExpense
+	if response.StatusCode == http.StatusOK {
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &expense))
+	}
+	return response.StatusCode, expense
+}
+
+// notificationsOf returns the types of the notifications of the user.
+func notificationsOf(t *testing.T, fake *testutil.FakeDynamoDB, userId string) []string {
+	result, err := fake.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("notifications"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	assert.NoError(t, err)
+	var notificationTypes []string
+	for _, item := range result.Items {
+		notificationTypes = append(notificationTypes, item["type"].(*types.AttributeValueMemberS).Value)
+	}
+	return notificationTypes
+}
+
+func TestDisputeAndResolve(t *testing.T) {
+	h, fake := newDisputesFake(t)
+
+	// Outsiders to the expense can't dispute it
+	statusCode, _ := callDisputeHandler(t, h.DisputeExpenseHandler, "user-3", map[string]interface{}{"reason": "Too expensive"})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+
+	// A participant disputes it and the admin is notified
+	statusCode, expense := callDisputeHandler(t, h.DisputeExpenseHandler, "user-2", map[string]interface{}{"reason": "We cancelled in March"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, DisputeOpen, expense.Dispute.Status)
+	assert.Equal(t, "user-2", expense.Dispute.RaisedBy)
+	assert.Equal(t, []string{"EXPENSE_DISPUTED"}, notificationsOf(t, fake, "user-3"))
+
+	statusCode, _ = callDisputeHandler(t, h.DisputeExpenseHandler, "user-1", map[string]interface{}{"reason": "Again"})
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	// The expense can't be settled while disputed
+	statusCode, _ = settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-2"})
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	// Only admins resolve disputes
+	statusCode, _ = callDisputeHandler(t, h.ResolveDisputeHandler, "user-1", map[string]interface{}{"resolution": "Fine"})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+
+	statusCode, expense = callDisputeHandler(t, h.ResolveDisputeHandler, "user-3", map[string]interface{}{"resolution": "Cancelled in April"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, DisputeResolved, expense.Dispute.Status)
+	assert.Equal(t, "user-3", expense.Dispute.ResolvedBy)
+	assert.Equal(t, []string{"DISPUTE_RESOLVED"}, notificationsOf(t, fake, "user-2"))
+
+	// And the expense can be settled again
+	statusCode, _ = settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-2"})
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestDisputeAdjust(t *testing.T) {
+	h, _ := newDisputesFake(t)
+
+	statusCode, _ := callDisputeHandler(t, h.DisputeExpenseHandler, "user-2", map[string]interface{}{"reason": "It was 40"})
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, _ = callDisputeHandler(t, h.AdjustDisputeHandler, "user-3", map[string]interface{}{"resolution": "Fixed"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	// Adjusting the amount splits it again, paid by the same payer
+	statusCode, expense := callDisputeHandler(t, h.AdjustDisputeHandler, "user-3", map[string]interface{}{"resolution": "Fixed the amount", "amount": "40"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, json.Number("40"), expense.Amount)
+	assert.Equal(t, json.Number("20.00"), expense.Participants[1].CalculatedMoney)
+	assert.Equal(t, []Payer{{UserID: "user-1", Amount: "40.00"}}, expense.Payers)
+	assert.Equal(t, DisputeResolved, expense.Dispute.Status)
+}
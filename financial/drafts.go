@@ -0,0 +1,246 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/fx"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// draftTTL is how long a proposed expense can be confirmed before it expires.
+const draftTTL = 24 * time.Hour
+
+// ErrNotGroupMember is returned when proposing an expense for a group the user isn't a member of.
+var ErrNotGroupMember = errors.New("user is not a member of the group")
+
+// ExpenseDraft struct for the splitter-expense-drafts table, holding an expense proposed by
+// the assistant until the user confirms it
+type ExpenseDraft struct {
+	DraftID   string           `json:"draftId" dynamodbav:"draftId"`
+	UserID    string           `json:"userId" dynamodbav:"userId"`
+	Expense   FinancialExpense `json:"expense" dynamodbav:"expense"`
+	CreatedAt string           `json:"createdAt" dynamodbav:"createdAt"`
+	ExpiresAt int64            `json:"expiresAt" dynamodbav:"expiresAt"` // DynamoDB TTL attribute, in Unix seconds
+}
+
+// ProposeExpense stores the expense as a draft of the user, to be written to the group
+// expenses only once the user confirms it. The split is calculated up front, so the
+// draft shows what every participant would pay.
+func (h *Handlers) ProposeExpense(ctx context.Context, userId, groupId string, expense FinancialExpense) (ExpenseDraft, error) {
+	member, err := h.getGroupMember(ctx, userId, groupId)
+	if err != nil {
+		return ExpenseDraft{}, err
+	}
+	if member == nil {
+		return ExpenseDraft{}, ErrNotGroupMember
+	}
+
+	// The draft ID becomes the expense ID, so a draft can only be confirmed once
+	now := time.Now()
+	draft := ExpenseDraft{
+		DraftID:   uuid.New().String(),
+		UserID:    userId,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(draftTTL).Unix(),
+	}
+	expense.ExpenseID = draft.DraftID
+	expense.GroupID = groupId
+	expense.CreatedBy = userId
+	expense.Display = nil
+	expense.Dispute = nil
+	clearSettlements(&expense)
+	if expense.Currency != "" && !fx.ValidCurrency(expense.Currency) {
+		return ExpenseDraft{}, errInvalidCurrency
+	}
+
+	if len(expense.Participants) == 0 {
+		err = h.applyGroupDefaults(ctx, &expense)
+		if err != nil {
+			return ExpenseDraft{}, err
+		}
+	}
+	err = calculateParticipantMoney(&expense)
+	if err != nil {
+		return ExpenseDraft{}, err
+	}
+	err = calculatePayers(&expense)
+	if err != nil {
+		return ExpenseDraft{}, err
+	}
+	draft.Expense = expense
+
+	err = common.ConditionalPutItem(ctx, h.client, "splitter-expense-drafts", draft, common.IfNotExists("draftId"))
+	if err != nil {
+		return ExpenseDraft{}, err
+	}
+
+	log.Printf("Stored expense draft %s for user %s in group %s", draft.DraftID, userId, groupId)
+	return draft, nil
+}
+
+// getExpenseDraft returns the pending draft of the user, or nil if there is no such draft
+// or it has expired. DynamoDB deletes expired items lazily, so the expiry is checked here too.
+func (h *Handlers) getExpenseDraft(ctx context.Context, userId, draftId string) (*ExpenseDraft, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-expense-drafts"),
+		Key: map[string]types.AttributeValue{
+			"draftId": &types.AttributeValueMemberS{Value: draftId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var draft ExpenseDraft
+	err = attributevalue.UnmarshalMap(result.Item, &draft)
+	if err != nil {
+		return nil, err
+	}
+	if draft.UserID != userId || time.Now().Unix() >= draft.ExpiresAt {
+		return nil, nil
+	}
+	return &draft, nil
+}
+
+func (h *Handlers) GetExpenseDraftHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract draftId from path parameters
+	draftId, ok := request.PathParameters["draftId"]
+	if !ok || draftId == "" {
+		return common.CreateErrorResponse(400, "Draft ID is missing")
+	}
+
+	draft, err := h.getExpenseDraft(context.TODO(), claims.Sub, draftId)
+	if err != nil {
+		log.Printf("Error getting expense draft from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if draft == nil {
+		return common.CreateErrorResponse(404, "Draft not found")
+	}
+
+	// Marshal the draft into JSON for the payload
+	payload, err := json.Marshal(draft)
+	if err != nil {
+		log.Println("Error marshalling expense draft:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) ConfirmExpenseDraftHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract draftId from path parameters
+	draftId, ok := request.PathParameters["draftId"]
+	if !ok || draftId == "" {
+		return common.CreateErrorResponse(400, "Draft ID is missing")
+	}
+
+	draft, err := h.getExpenseDraft(context.TODO(), claims.Sub, draftId)
+	if err != nil {
+		log.Printf("Error getting expense draft from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if draft == nil {
+		return common.CreateErrorResponse(404, "Draft not found")
+	}
+
+	// The user may have left the group since the expense was proposed
+	expense := draft.Expense
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, expense.GroupID)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	// Refuse the expense when the group has no room left for it
+	quotaErr, err := h.checkExpenseQuota(context.TODO(), expense.GroupID, 1)
+	if err != nil {
+		log.Printf("Error checking the expense quota: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if quotaErr != nil {
+		return quotaResponse(quotaErr)
+	}
+
+	// Hold the expense for approval when it takes members over their spending cap
+	expense.CreatedAt = time.Now().Format(time.RFC3339)
+	approval, warnings, err := h.checkSpendingCaps(context.TODO(), expense, claims.Sub)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Draft already confirmed")
+	}
+	if err != nil {
+		log.Printf("Error checking spending caps: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if approval != nil {
+		return approvalResponse(approval)
+	}
+
+	// Store the expense, refusing to confirm the same draft twice
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-expenses", expense, common.IfNotExists("expenseId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Draft already confirmed")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Confirmed expense draft %s into expense %s for group %s", draftId, expense.ExpenseID, expense.GroupID)
+	h.announceExpenses(context.TODO(), expense.GroupID, expense)
+
+	// Marshal the expense into JSON for the payload
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		log.Println("Error marshalling expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	response := events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}
+	for _, warning := range warnings {
+		common.AddWarning(&response, warning)
+	}
+	return response, nil
+}
@@ -0,0 +1,108 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProposeAndConfirmExpense(t *testing.T) {
+	// Set up the fake DynamoDB client with a two member group
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id"},
+			{"userId": "user-2", "groupId": "test-group-id"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Propose an expense without participants, split between all the members
+	draft, err := h.ProposeExpense(context.TODO(), "user-1", "test-group-id", FinancialExpense{Title: "Dinner", Amount: "50", PaidBy: "user-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, draft.DraftID, draft.Expense.ExpenseID)
+	assert.Len(t, draft.Expense.Participants, 2)
+	assert.Equal(t, "25.00", string(draft.Expense.Participants[0].CalculatedMoney))
+
+	// The draft is not an expense yet
+	stored, err := fake.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-expenses"),
+		Key: map[string]types.AttributeValue{
+			"groupId":   &types.AttributeValueMemberS{Value: "test-group-id"},
+			"expenseId": &types.AttributeValueMemberS{Value: draft.DraftID},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, stored.Item)
+
+	// Another user can't see or confirm the draft
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("draftId", draft.DraftID).
+		Build()
+	response, err := h.ConfirmExpenseDraftHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	// Confirm the draft
+	request = testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("draftId", draft.DraftID).
+		Build()
+	response, err = h.ConfirmExpenseDraftHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	var expense FinancialExpense
+	err = json.Unmarshal([]byte(response.Body), &expense)
+	assert.NoError(t, err)
+	assert.Equal(t, draft.DraftID, expense.ExpenseID)
+	assert.Equal(t, "Dinner", expense.Title)
+
+	// A draft can only be confirmed once
+	response, err = h.ConfirmExpenseDraftHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+}
+
+func TestProposeExpenseRequiresMembership(t *testing.T) {
+	// Set up the fake DynamoDB client without memberships
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	_, err = h.ProposeExpense(context.TODO(), "user-1", "test-group-id", FinancialExpense{Amount: "50"})
+	assert.ErrorIs(t, err, ErrNotGroupMember)
+}
+
+func TestConfirmExpenseDraftHandlerExpired(t *testing.T) {
+	// Set up the fake DynamoDB client with a draft that expired but wasn't deleted yet
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-expense-drafts": {
+			{"draftId": "draft-1", "userId": "user-1", "expiresAt": time.Now().Add(-time.Minute).Unix(), "expense": map[string]interface{}{"expenseId": "draft-1", "groupId": "test-group-id"}},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Create a sample request
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("draftId", "draft-1").
+		Build()
+
+	// Call the handler
+	response, err := h.ConfirmExpenseDraftHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
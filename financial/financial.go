@@ -3,17 +3,21 @@ package financial
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"math/big"
-	"time"
+	"slices"
+	"vassistant-backend/auth"
 	"vassistant-backend/common"
+	"vassistant-backend/fx"
+	"vassistant-backend/i18n"
+	"vassistant-backend/plans"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/google/uuid"
 )
 
 // Participant struct for financial expense participants
@@ -21,6 +25,9 @@ type Participant struct {
 	UserID          string      `json:"userId" dynamodbav:"userId"`
 	Share           json.Number `json:"share" dynamodbav:"share"`
 	CalculatedMoney json.Number `json:"calculatedMoney" dynamodbav:"calculatedMoney"`
+	SettledAmount   json.Number `json:"settledAmount,omitempty" dynamodbav:"settledAmount,omitempty"`
+	Settled         bool        `json:"settled,omitempty" dynamodbav:"settled,omitempty"`
+	SettledAt       string      `json:"settledAt,omitempty" dynamodbav:"settledAt,omitempty"`
 	User            `dynamodbav:"-"`
 }
 
@@ -30,6 +37,8 @@ type User struct {
 	Username     string `json:"username" dynamodbav:"username"`
 	ShowableName string `json:"showableName" dynamodbav:"showableName"`
 	Role         string `json:"role" dynamodbav:"role"`
+	// Nickname is the name the user making the request gave this user, if any
+	Nickname string `json:"nickname,omitempty" dynamodbav:"-"`
 }
 
 // FinancialExpense struct for the "get financial" response
@@ -39,8 +48,11 @@ type FinancialExpense struct {
 	Title  string        `json:"title" dynamodbav:"title"`
 	Category     string        `json:"category" dynamodbav:"category"`
 	Amount       json.Number   `json:"amount" dynamodbav:"amount"`
+	Currency     string        `json:"currency" dynamodbav:"currency"`
+	AmountText   string        `json:"amountText,omitempty" dynamodbav:"-"` // the amount formatted with the rules of the group, if it has any
 	DateTime     string        `json:"dateTime" dynamodbav:"dateTime"`
-	PaidBy       string        `json:"paidBy" dynamodbav:"paidBy"`
+	PaidBy       string        `json:"paidBy" dynamodbav:"paidBy"` // first of the payers, kept for the clients predating them
+	Payers       []Payer       `json:"payers,omitempty" dynamodbav:"payers,omitempty"`
 	ImageURL     string        `json:"imageUrl" dynamodbav:"imageUrl"`
 	SplitType    string        `json:"splitType" dynamodbav:"splitType"`
 	Participants []Participant `json:"participants" dynamodbav:"participants"`
@@ -48,6 +60,17 @@ type FinancialExpense struct {
 	CreatedBy      string        `json:"createdBy" dynamodbav:"createdBy"`
 	CreatedAt      string        `json:"createdAt" dynamodbav:"createdAt"`
 	CreatedByUser  User          `json:"createdByUser" dynamodbav:"-"`
+	Display        *fx.Conversion `json:"display,omitempty" dynamodbav:"-"`
+	GroupAmount    *fx.Conversion `json:"groupAmount,omitempty" dynamodbav:"groupAmount,omitempty"` // in the group default currency, at the rate of the expense date
+	Version        int            `json:"version,omitempty" dynamodbav:"version,omitempty"`
+	Items          []ExpenseItem  `json:"items,omitempty" dynamodbav:"items,omitempty"`
+	ReceiptID      string         `json:"receiptId,omitempty" dynamodbav:"receiptId,omitempty"`
+	ReimbursementIDs []string     `json:"reimbursementIds,omitempty" dynamodbav:"reimbursementIds,omitempty"` // the reimbursements settling shares of the expense
+	ReceiptWarnings []ReceiptWarning `json:"receiptWarnings,omitempty" dynamodbav:"receiptWarnings,omitempty"` // where the scanned receipt disagrees with the expense
+	Dispute        *Dispute       `json:"dispute,omitempty" dynamodbav:"dispute,omitempty"`
+	Notes          string         `json:"notes,omitempty" dynamodbav:"notes,omitempty"` // encrypted at rest when the encryption is enabled
+	Mentions       []Mention      `json:"mentions,omitempty" dynamodbav:"mentions,omitempty"` // the members mentioned in the notes
+	Location       *Location      `json:"location,omitempty" dynamodbav:"location,omitempty"` // where the expense was paid
 }
 
 // GroupMember struct for the splitter-group-members table
@@ -56,11 +79,99 @@ type GroupMember struct {
 	GroupID    string `json:"groupId" dynamodbav:"groupId"`
 	GroupName  string `json:"groupName" dynamodbav:"groupName"`
 	GroupImage string `json:"groupImage" dynamodbav:"groupImage"`
+	Role       string `json:"role" dynamodbav:"role"`
+	Status     string `json:"status,omitempty" dynamodbav:"status,omitempty"`
 }
 
-var DynamoDbClient common.DynamoDBAPI
+// Handlers serves the financial routes and jobs with the DynamoDB client it was created
+// with, so that each instance, e.g. of a test or a tenant, has its own.
+type Handlers struct {
+	client common.DynamoDBAPI
+	plans  *plans.Handlers
+}
+
+// NewHandlers creates the financial handlers reading and writing through the client, the
+// plans of the users included.
+func NewHandlers(client common.DynamoDBAPI) *Handlers {
+	return &Handlers{client: client, plans: plans.NewHandlers(client)}
+}
+
+// expenseCategories lists the supported expense categories
+var expenseCategories = []string{"FOOD"}
+
+// splitTypes lists the supported expense split types
+var splitTypes = []string{"PERCENTAGE"}
+
+// expenseFields lists the expense fields that can be selected with the fields query parameter
+var expenseFields = []string{
+	"expenseId", "groupId", "title", "category", "amount", "currency", "amountText", "dateTime", "paidBy", "payers", "imageUrl",
+	"splitType", "participants", "paidByUser", "createdBy", "createdAt", "createdByUser", "display", "groupAmount",
+	"items", "receiptId", "receiptWarnings", "reimbursementIds", "dispute", "notes", "mentions", "location",
+}
+
+// groupFields lists the group fields that can be selected with the fields query parameter
+var groupFields = []string{"userId", "groupId", "groupName", "groupImage", "role", "status"}
+
+// expenseAttributes maps the selected expense fields to the DynamoDB attributes they are built from.
+// The table keys are always projected.
+func expenseAttributes(fields []string) []string {
+	attributes := []string{"groupId", "expenseId"}
+	for _, field := range fields {
+		switch field {
+		case "paidByUser":
+			attributes = append(attributes, "paidBy")
+		case "payers":
+			// Expenses stored before payers are read from paidBy and amount
+			attributes = append(attributes, "payers", "paidBy", "amount")
+		case "createdByUser":
+			attributes = append(attributes, "createdBy")
+		case "amountText":
+			attributes = append(attributes, "amount", "currency")
+		case "display":
+			attributes = append(attributes, "amount", "currency", "dateTime", "groupAmount")
+		default:
+			attributes = append(attributes, field)
+		}
+	}
+	return attributes
+}
+
+// marshalFields marshals the payload into JSON, keeping only the selected fields when given.
+func marshalFields(payload interface{}, fields []string) ([]byte, error) {
+	if fields == nil {
+		return json.Marshal(payload)
+	}
+	return common.FilterFields(payload, fields)
+}
+
+// queryExpenses runs the query, following the pages of the result, and unmarshals the expenses.
+func (h *Handlers) queryExpenses(ctx context.Context, queryInput *dynamodb.QueryInput) ([]FinancialExpense, error) {
+	var expenses []FinancialExpense
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
 
-func GetGroupExpensesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		// Unmarshal the Items into a slice of FinancialExpense structs
+		var items []FinancialExpense
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+		for i := range items {
+			normalizePayers(&items[i])
+		}
+		expenses = append(expenses, items...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return expenses, nil
+		}
+	}
+}
+
+func (h *Handlers) GetGroupExpensesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
 	// Extract groupId from path parameters
@@ -69,6 +180,30 @@ func GetGroupExpensesHandler(request events.APIGatewayProxyRequest) (events.APIG
 		return common.CreateErrorResponse(400, "Group ID is missing")
 	}
 
+	// Parse the optional response field selection
+	fields, err := common.ParseFields(request, expenseFields...)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Parse the optional currency the amounts are displayed in
+	displayCurrency, err := parseDisplayCurrency(request)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Parse the optional sort order and page
+	page, err := parseExpensePage(request)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Parse the optional filter on where the expenses were paid
+	near, err := parseNear(request)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
 	// Build the query input
 	queryInput := &dynamodb.QueryInput{
 		TableName:              aws.String("splitter-expenses"),
@@ -77,30 +212,53 @@ func GetGroupExpensesHandler(request events.APIGatewayProxyRequest) (events.APIG
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":groupId": &types.AttributeValueMemberS{Value: groupId},
 		},
-		ScanIndexForward: aws.Bool(false),
+		ScanIndexForward: aws.Bool(page.Sort.Field == "dateTime" && !page.Sort.Descending),
+	}
+	// The index can't be read consistently, so the reads asking for it go to the table, in
+	// the order of the expense IDs as every page is read and sorted below anyway
+	if consistentRead := common.ConsistentRead(request); consistentRead != nil {
+		queryInput.IndexName = nil
+		queryInput.ConsistentRead = consistentRead
+	}
+	if fields != nil {
+		// The sort field is needed to order and paginate, and the location to filter, even if
+		// not returned
+		attributes := append(expenseAttributes(fields), page.Sort.Field)
+		if near != nil {
+			attributes = append(attributes, "location")
+		}
+		queryInput.ProjectionExpression, queryInput.ExpressionAttributeNames = common.ProjectionExpression(attributes)
 	}
 
-	// Make the DynamoDB Query API call
-	result, err := DynamoDbClient.Query(context.TODO(), queryInput)
+	// Make the DynamoDB Query API calls, following the pages of the result so every
+	// expense of the group is sorted, not just the first page
+	expenses, err := h.queryExpenses(context.TODO(), queryInput)
 	if err != nil {
 		log.Printf("Error querying DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
-	// Unmarshal the Items into a slice of FinancialExpense structs
-	var expenses []FinancialExpense
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &expenses)
+	log.Printf("Successfully retrieved %d expenses for group %s", len(expenses), groupId)
+
+	// Keep the expenses paid near the point only, before paginating them
+	if near != nil {
+		expenses = slices.DeleteFunc(expenses, func(expense FinancialExpense) bool { return !near.matches(expense) })
+	}
+
+	// Sort the expenses and keep the requested page only
+	expenses, nextCursor, err := paginateExpenses(expenses, page)
 	if err != nil {
-		log.Printf("Error unmarshalling expenses: %v", err)
+		log.Printf("Error paginating expenses: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
-	log.Printf("Successfully retrieved %d expenses for group %s", len(expenses), groupId)
-
 	// Collect all unique user IDs from all participants
 	userIds := make(map[string]struct{})
 	for _, expense := range expenses {
 		userIds[expense.PaidBy] = struct{}{}
+		for _, payer := range expense.Payers {
+			userIds[payer.UserID] = struct{}{}
+		}
 		if expense.CreatedBy != "" {
 			userIds[expense.CreatedBy] = struct{}{}
 		}
@@ -109,75 +267,79 @@ func GetGroupExpensesHandler(request events.APIGatewayProxyRequest) (events.APIG
 		}
 	}
 
-	// Prepare keys for BatchGetItem
-	keys := make([]map[string]types.AttributeValue, 0, len(userIds))
+	// Fetch all user details, batching and parallelizing the BatchGetItem calls, with the
+	// nicknames the caller gave them
+	userMap, err := h.getUsersForViewer(context.TODO(), viewerOf(request), userIds)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Count the users whose details weren't found, e.g. deleted accounts, to warn about them
+	missingUsers := 0
 	for userId := range userIds {
-		keys = append(keys, map[string]types.AttributeValue{
-			"userId": &types.AttributeValueMemberS{Value: userId},
-		})
-	}
-
-	// Fetch all user details in a single BatchGetItem call
-	if len(keys) > 0 {
-		batchGetItemInput := &dynamodb.BatchGetItemInput{
-			RequestItems: map[string]types.KeysAndAttributes{
-				"vassistant-users": {
-					Keys: keys,
-				},
-			},
+		if _, ok := userMap[userId]; !ok && userId != "" {
+			missingUsers++
 		}
+	}
 
-		userResult, err := DynamoDbClient.BatchGetItem(context.TODO(), batchGetItemInput)
-		if err != nil {
-			log.Printf("Error getting user details from DynamoDB: %v", err)
-			return common.CreateErrorResponse(500, "Internal server error")
+	// Populate the user details in the expenses
+	for i, expense := range expenses {
+		expenses[i].PaidByUser = userMap.User(expense.PaidBy)
+		expenses[i].CreatedByUser = userMap.User(expense.CreatedBy)
+		for j, payer := range expense.Payers {
+			expenses[i].Payers[j].User = userMap.User(payer.UserID)
+		}
+		for j, participant := range expense.Participants {
+			expenses[i].Participants[j].User = userMap.User(participant.UserID)
 		}
+	}
 
-		// Create a map of userId to User for easy lookup
-		userMap := make(map[string]User)
-		userItems := userResult.Responses["vassistant-users"]
-
-		for _, item := range userItems {
-			var user User
-			err = attributevalue.UnmarshalMap(item, &user)
-			if err != nil {
-				log.Printf("Error unmarshalling user: %v", err)
-				return common.CreateErrorResponse(500, "Internal server error")
-			}
-			userMap[user.UserID] = user
+	// Convert the amounts into the display currency
+	if displayCurrency != "" && (fields == nil || slices.Contains(fields, "display")) {
+		err = h.convertExpenses(context.TODO(), groupId, expenses, displayCurrency)
+		if errors.Is(err, fx.ErrRateNotFound) {
+			return common.CreateErrorResponse(422, "Exchange rate not available")
 		}
+		if err != nil {
+			log.Printf("Error converting expenses: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
 
-		// Populate the user details in the expenses
-		for i, expense := range expenses {
-			if user, ok := userMap[expense.PaidBy]; ok {
-				expenses[i].PaidByUser = user
-			}
-			if user, ok := userMap[expense.CreatedBy]; ok {
-				expenses[i].CreatedByUser = user
-			}
-			for j, participant := range expense.Participants {
-				if user, ok := userMap[participant.UserID]; ok {
-					expenses[i].Participants[j].User = user
-				}
-			}
+	// Format the amounts for display with the rules of the group
+	if fields == nil || slices.ContainsFunc(fields, isFormattedField) {
+		err = h.formatExpenses(context.TODO(), groupId, expenses)
+		if err != nil {
+			log.Printf("Error formatting expenses: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
 		}
 	}
 
 	// Marshal the expenses into JSON for the payload
-	payload, err := json.Marshal(expenses)
+	payload, err := marshalFields(expenses, fields)
 	if err != nil {
 		log.Println("Error marshalling expenses:", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
-	return events.APIGatewayProxyResponse{
+	headers := map[string]string{"Content-Type": "application/json"}
+	if nextCursor != "" {
+		headers[NextCursorHeader] = nextCursor
+	}
+
+	response := events.APIGatewayProxyResponse{
 		StatusCode: 200,
-		Headers:    map[string]string{"Content-Type": "application/json"},
+		Headers:    headers,
 		Body:       string(payload),
-	}, nil
+	}
+	if missingUsers > 0 {
+		common.AddWarning(&response, fmt.Sprintf("%d users not found", missingUsers))
+	}
+	return response, nil
 }
 
-func GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handlers) GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
 	// Extract groupId and expenseId from path parameters
@@ -190,6 +352,18 @@ func GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 		return common.CreateErrorResponse(400, "Expense ID is missing")
 	}
 
+	// Parse the optional response field selection
+	fields, err := common.ParseFields(request, expenseFields...)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Parse the optional currency the amount is displayed in
+	displayCurrency, err := parseDisplayCurrency(request)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
 	// Build the get item input
 	getItemInput := &dynamodb.GetItemInput{
 		TableName: aws.String("splitter-expenses"),
@@ -197,10 +371,14 @@ func GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 			"groupId":   &types.AttributeValueMemberS{Value: groupId},
 			"expenseId": &types.AttributeValueMemberS{Value: expenseId},
 		},
+		ConsistentRead: common.ConsistentRead(request),
+	}
+	if fields != nil {
+		getItemInput.ProjectionExpression, getItemInput.ExpressionAttributeNames = common.ProjectionExpression(expenseAttributes(fields))
 	}
 
 	// Make the DynamoDB GetItem API call
-	result, err := DynamoDbClient.GetItem(context.TODO(), getItemInput)
+	result, err := h.client.GetItem(context.TODO(), getItemInput)
 	if err != nil {
 		log.Printf("Error getting item from DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -219,11 +397,16 @@ func GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
+	normalizePayers(&expense)
+
 	log.Printf("Successfully retrieved expense %s for group %s", expense.ExpenseID, expense.GroupID)
 
 	// Collect all unique user IDs
 	userIds := make(map[string]struct{})
 	userIds[expense.PaidBy] = struct{}{}
+	for _, payer := range expense.Payers {
+		userIds[payer.UserID] = struct{}{}
+	}
 	if expense.CreatedBy != "" {
 		userIds[expense.CreatedBy] = struct{}{}
 	}
@@ -231,60 +414,51 @@ func GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 		userIds[participant.UserID] = struct{}{}
 	}
 
-	// Prepare keys for BatchGetItem
-	keys := make([]map[string]types.AttributeValue, 0, len(userIds))
-	for userId := range userIds {
-		keys = append(keys, map[string]types.AttributeValue{
-			"userId": &types.AttributeValueMemberS{Value: userId},
-		})
-	}
-
-	// Fetch all user details in a single BatchGetItem call
-	if len(keys) > 0 {
-		batchGetItemInput := &dynamodb.BatchGetItemInput{
-			RequestItems: map[string]types.KeysAndAttributes{
-				"vassistant-users": {
-					Keys: keys,
-				},
-			},
-		}
+	// Fetch all user details, batching and parallelizing the BatchGetItem calls, with the
+	// nicknames the caller gave them
+	userMap, err := h.getUsersForViewer(context.TODO(), viewerOf(request), userIds)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
 
-		userResult, err := DynamoDbClient.BatchGetItem(context.TODO(), batchGetItemInput)
+	// Populate the user details in the expense
+	expense.PaidByUser = userMap.User(expense.PaidBy)
+	expense.CreatedByUser = userMap.User(expense.CreatedBy)
+	for j, payer := range expense.Payers {
+		expense.Payers[j].User = userMap.User(payer.UserID)
+	}
+	for j, participant := range expense.Participants {
+		expense.Participants[j].User = userMap.User(participant.UserID)
+	}
+
+	// Convert the amount into the display currency
+	if displayCurrency != "" && (fields == nil || slices.Contains(fields, "display")) {
+		converted := []FinancialExpense{expense}
+		err = h.convertExpenses(context.TODO(), groupId, converted, displayCurrency)
+		if errors.Is(err, fx.ErrRateNotFound) {
+			return common.CreateErrorResponse(422, "Exchange rate not available")
+		}
 		if err != nil {
-			log.Printf("Error getting user details from DynamoDB: %v", err)
+			log.Printf("Error converting expense: %v", err)
 			return common.CreateErrorResponse(500, "Internal server error")
 		}
+		expense = converted[0]
+	}
 
-		// Create a map of userId to User for easy lookup
-		userMap := make(map[string]User)
-		userItems := userResult.Responses["vassistant-users"]
-
-		for _, item := range userItems {
-			var user User
-			err = attributevalue.UnmarshalMap(item, &user)
-			if err != nil {
-				log.Printf("Error unmarshalling user: %v", err)
-				return common.CreateErrorResponse(500, "Internal server error")
-			}
-			userMap[user.UserID] = user
-		}
-
-		// Populate the user details in the expense
-		if user, ok := userMap[expense.PaidBy]; ok {
-			expense.PaidByUser = user
-		}
-		if user, ok := userMap[expense.CreatedBy]; ok {
-			expense.CreatedByUser = user
-		}
-		for j, participant := range expense.Participants {
-			if user, ok := userMap[participant.UserID]; ok {
-				expense.Participants[j].User = user
-			}
+	// Format the amounts for display with the rules of the group
+	if fields == nil || slices.ContainsFunc(fields, isFormattedField) {
+		formatted := []FinancialExpense{expense}
+		err = h.formatExpenses(context.TODO(), groupId, formatted)
+		if err != nil {
+			log.Printf("Error formatting expense: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
 		}
+		expense = formatted[0]
 	}
 
 	// Marshal the expense into JSON for the payload
-	payload, err := json.Marshal(expense)
+	payload, err := marshalFields(expense, fields)
 	if err != nil {
 		log.Println("Error marshalling expense:", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -300,9 +474,17 @@ func GetExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGateway
 func GetExpenseCategoriesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	categories := []string{"FOOD"}
-
-	payload, err := json.Marshal(categories)
+	// Return the codes with their localized labels when asked to
+	var payload []byte
+	var err error
+	headers := map[string]string{"Content-Type": "application/json"}
+	if request.QueryStringParameters["labels"] == "true" {
+		language := i18n.Language(request)
+		headers["Content-Language"] = language
+		payload, err = json.Marshal(i18n.Labels(language, "category.", expenseCategories))
+	} else {
+		payload, err = json.Marshal(expenseCategories)
+	}
 	if err != nil {
 		log.Println("Error marshalling categories:", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -310,7 +492,7 @@ func GetExpenseCategoriesHandler(request events.APIGatewayProxyRequest) (events.
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
-		Headers:    map[string]string{"Content-Type": "application/json"},
+		Headers:    headers,
 		Body:       string(payload),
 	}, nil
 }
@@ -318,9 +500,17 @@ func GetExpenseCategoriesHandler(request events.APIGatewayProxyRequest) (events.
 func GetExpenseSplitTypeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	splitTypes := []string{"PERCENTAGE"}
-
-	payload, err := json.Marshal(splitTypes)
+	// Return the codes with their localized labels when asked to
+	var payload []byte
+	var err error
+	headers := map[string]string{"Content-Type": "application/json"}
+	if request.QueryStringParameters["labels"] == "true" {
+		language := i18n.Language(request)
+		headers["Content-Language"] = language
+		payload, err = json.Marshal(i18n.Labels(language, "splitType.", splitTypes))
+	} else {
+		payload, err = json.Marshal(splitTypes)
+	}
 	if err != nil {
 		log.Println("Error marshalling split types:", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -328,12 +518,12 @@ func GetExpenseSplitTypeHandler(request events.APIGatewayProxyRequest) (events.A
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: 200,
-		Headers:    map[string]string{"Content-Type": "application/json"},
+		Headers:    headers,
 		Body:       string(payload),
 	}, nil
 }
 
-func GetGroupUsersHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handlers) GetGroupUsersHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
 	// Extract groupId from path parameters
@@ -354,7 +544,7 @@ func GetGroupUsersHandler(request events.APIGatewayProxyRequest) (events.APIGate
 	}
 
 	// Make the DynamoDB Query API call
-	result, err := DynamoDbClient.Query(context.TODO(), queryInput)
+	result, err := h.client.Query(context.TODO(), queryInput)
 	if err != nil {
 		log.Printf("Error querying DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -376,38 +566,18 @@ func GetGroupUsersHandler(request events.APIGatewayProxyRequest) (events.APIGate
 		userIds[member.UserID] = struct{}{}
 	}
 
-	// Prepare keys for BatchGetItem
-	keys := make([]map[string]types.AttributeValue, 0, len(userIds))
-	for userId := range userIds {
-		keys = append(keys, map[string]types.AttributeValue{
-			"userId": &types.AttributeValueMemberS{Value: userId},
-		})
+	// Fetch all user details, batching and parallelizing the BatchGetItem calls, with the
+	// nicknames the caller gave them
+	userMap, err := h.getUsersForViewer(context.TODO(), viewerOf(request), userIds)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
-	var users []User
-
-	// Fetch all user details in a single BatchGetItem call
-	if len(keys) > 0 {
-		batchGetItemInput := &dynamodb.BatchGetItemInput{
-			RequestItems: map[string]types.KeysAndAttributes{
-				"vassistant-users": {
-					Keys: keys,
-				},
-			},
-		}
-
-		userResult, err := DynamoDbClient.BatchGetItem(context.TODO(), batchGetItemInput)
-		if err != nil {
-			log.Printf("Error getting user details from DynamoDB: %v", err)
-			return common.CreateErrorResponse(500, "Internal server error")
-		}
-
-		userItems := userResult.Responses["vassistant-users"]
-		err = attributevalue.UnmarshalListOfMaps(userItems, &users)
-		if err != nil {
-			log.Printf("Error unmarshalling user: %v", err)
-			return common.CreateErrorResponse(500, "Internal server error")
-		}
+	// Keep the users in the same order as the group members
+	users := make([]User, 0, len(groupMembers))
+	for _, member := range groupMembers {
+		users = append(users, userMap.User(member.UserID))
 	}
 
 	log.Printf("Successfully retrieved %d users for group %s", len(users), groupId)
@@ -426,17 +596,15 @@ func GetGroupUsersHandler(request events.APIGatewayProxyRequest) (events.APIGate
 	}, nil
 }
 
-func GetGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handlers) GetGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	// Extract claims from the authorizer
-	authorizer := request.RequestContext.Authorizer
-	claims, ok := authorizer["claims"].(map[string]interface{})
-	if !ok {
-		log.Println("Error: Invalid claims format")
-		return common.CreateErrorResponse(403, "Unauthorized: Invalid claims format")
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
 	}
-	sub, _ := claims["sub"].(string)
+	sub := claims.Sub
 
 	// Extract groupId from path parameters
 	groupId, ok := request.PathParameters["groupId"]
@@ -451,10 +619,11 @@ func GetGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayPr
 			"userId":  &types.AttributeValueMemberS{Value: sub},
 			"groupId": &types.AttributeValueMemberS{Value: groupId},
 		},
+		ConsistentRead: common.ConsistentRead(request),
 	}
 
 	// Make the DynamoDB Query API call
-	result, err := DynamoDbClient.GetItem(context.TODO(), queryInput)
+	result, err := h.client.GetItem(context.TODO(), queryInput)
 	if err != nil {
 		log.Printf("Error querying DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -491,17 +660,15 @@ func GetGroupHandler(request events.APIGatewayProxyRequest) (events.APIGatewayPr
 	}, nil
 }
 
-func PostGroupExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handlers) PostGroupExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	// Extract claims from the authorizer
-	authorizer := request.RequestContext.Authorizer
-	claims, ok := authorizer["claims"].(map[string]interface{})
-	if !ok {
-		log.Println("Error: Invalid claims format")
-		return common.CreateErrorResponse(403, "Unauthorized: Invalid claims format")
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
 	}
-	sub, _ := claims["sub"].(string)
+	sub := claims.Sub
 
 	// Extract groupId from path parameters
 	groupId, ok := request.PathParameters["groupId"]
@@ -511,76 +678,54 @@ func PostGroupExpenseHandler(request events.APIGatewayProxyRequest) (events.APIG
 
 	// Parse the request body into a FinancialExpense struct
 	var expense FinancialExpense
-	err := json.Unmarshal([]byte(request.Body), &expense)
+	err = json.Unmarshal([]byte(request.Body), &expense)
 	if err != nil {
 		log.Printf("Error unmarshalling request body: %v", err)
 		return common.CreateErrorResponse(400, "Invalid request body")
 	}
 
-	// Generate a new UUID for the expense
-	expense.ExpenseID = uuid.New().String()
-	expense.GroupID = groupId
-	expense.CreatedBy = sub
-	expense.CreatedAt = time.Now().Format(time.RFC3339)
-
-	// Calculate calculatedMoney for each participant
-	amount, _, err := new(big.Float).Parse(string(expense.Amount), 10)
+	// Validate the expense and calculate the split
+	message, err := h.prepareExpense(context.TODO(), groupId, sub, &expense)
 	if err != nil {
-		log.Printf("Error parsing amount: %v", err)
-		return common.CreateErrorResponse(400, "Invalid amount")
+		log.Printf("Error preparing expense: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
 	}
-
-	var totalCalculated big.Float
-	for i := range expense.Participants {
-		share, _, err := new(big.Float).Parse(string(expense.Participants[i].Share), 10)
-		if err != nil {
-			log.Printf("Error parsing share: %v", err)
-			return common.CreateErrorResponse(400, "Invalid share")
-		}
-
-		// calculatedMoney = (amount * share) / 100
-		calculatedMoney := new(big.Float).Quo(new(big.Float).Mul(amount, share), big.NewFloat(100))
-		calculatedMoney.SetMode(big.ToNearestEven)
-		calculatedMoney.SetPrec(64)
-		calculatedMoneyStr := calculatedMoney.Text('f', 2)
-
-		// To avoid rounding errors, the last participant gets the remaining amount
-		if i == len(expense.Participants)-1 {
-			remaining := new(big.Float).Sub(amount, &totalCalculated)
-			remainingStr := remaining.Text('f', 2)
-			expense.Participants[i].CalculatedMoney = json.Number(remainingStr)
-		} else {
-			calculated, _, err := new(big.Float).Parse(calculatedMoneyStr, 10)
-			if err != nil {
-				log.Printf("Error parsing calculated money: %v", err)
-				return common.CreateErrorResponse(500, "Internal server error")
-			}
-			totalCalculated.Add(&totalCalculated, calculated)
-			expense.Participants[i].CalculatedMoney = json.Number(calculatedMoneyStr)
-		}
+	if message != "" {
+		return common.CreateErrorResponse(400, message)
 	}
 
-	// Marshal the expense into a DynamoDB attribute value map
-	av, err := attributevalue.MarshalMap(expense)
+	// Refuse the expense when the group has no room left for it
+	quotaErr, err := h.checkExpenseQuota(context.TODO(), groupId, 1)
 	if err != nil {
-		log.Printf("Error marshalling expense: %v", err)
+		log.Printf("Error checking the expense quota: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
+	if quotaErr != nil {
+		return quotaResponse(quotaErr)
+	}
 
-	// Build the PutItem input
-	putInput := &dynamodb.PutItemInput{
-		TableName: aws.String("splitter-expenses"),
-		Item:      av,
+	// Hold the expense for approval when it takes members over their spending cap
+	approval, warnings, err := h.checkSpendingCaps(context.TODO(), expense, sub)
+	if err != nil {
+		log.Printf("Error checking spending caps: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if approval != nil {
+		return approvalResponse(approval)
 	}
 
-	// Make the DynamoDB PutItem API call
-	_, err = DynamoDbClient.PutItem(context.TODO(), putInput)
+	// Store the expense, refusing to overwrite an existing one
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-expenses", expense, common.IfNotExists("expenseId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expense already exists")
+	}
 	if err != nil {
 		log.Printf("Error putting item into DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
 	log.Printf("Successfully created expense %s for group %s", expense.ExpenseID, expense.GroupID)
+	h.announceExpenses(context.TODO(), expense.GroupID, expense)
 
 	// Marshal the expense into JSON for the payload
 	payload, err := json.Marshal(expense)
@@ -589,24 +734,32 @@ func PostGroupExpenseHandler(request events.APIGatewayProxyRequest) (events.APIG
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
-	return events.APIGatewayProxyResponse{
+	response := events.APIGatewayProxyResponse{
 		StatusCode: 201,
 		Headers:    map[string]string{"Content-Type": "application/json"},
 		Body:       string(payload),
-	}, nil
+	}
+	for _, warning := range warnings {
+		common.AddWarning(&response, warning)
+	}
+	return response, nil
 }
 
-func GetGroupsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (h *Handlers) GetGroupsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	// Extract claims from the authorizer
-	authorizer := request.RequestContext.Authorizer
-	claims, ok := authorizer["claims"].(map[string]interface{})
-	if !ok {
-		log.Println("Error: Invalid claims format")
-		return common.CreateErrorResponse(403, "Unauthorized: Invalid claims format")
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	sub := claims.Sub
+
+	// Parse the optional response field selection
+	fields, err := common.ParseFields(request, groupFields...)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
 	}
-	sub, _ := claims["sub"].(string)
 
 	// Build the query input
 	queryInput := &dynamodb.QueryInput{
@@ -615,11 +768,19 @@ func GetGroupsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayP
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":userId": &types.AttributeValueMemberS{Value: sub},
 		},
-		ProjectionExpression: aws.String("userId, groupId, groupName"),
+		ProjectionExpression: aws.String("userId, groupId, groupName, #role, #status"),
+		ExpressionAttributeNames: map[string]string{
+			"#role":   "role",
+			"#status": "status",
+		},
+		ConsistentRead:       common.ConsistentRead(request),
+	}
+	if fields != nil {
+		queryInput.ProjectionExpression, queryInput.ExpressionAttributeNames = common.ProjectionExpression(append([]string{"userId", "groupId", "status"}, fields...))
 	}
 
 	// Make the DynamoDB Query API call
-	result, err := DynamoDbClient.Query(context.TODO(), queryInput)
+	result, err := h.client.Query(context.TODO(), queryInput)
 	if err != nil {
 		log.Printf("Error querying DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
@@ -633,10 +794,15 @@ func GetGroupsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayP
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
+	// Hide the groups waiting to be purged
+	groupMembers = slices.DeleteFunc(groupMembers, func(member GroupMember) bool {
+		return member.Status == GroupDeletedPending
+	})
+
 	log.Printf("Successfully retrieved %d groups for user %s", len(groupMembers), sub)
 
 	// Marshal the group members into JSON for the payload
-	payload, err := json.Marshal(groupMembers)
+	payload, err := marshalFields(groupMembers, fields)
 	if err != nil {
 		log.Println("Error marshalling group members:", err)
 		return common.CreateErrorResponse(500, "Internal server error")
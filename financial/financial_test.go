@@ -3,81 +3,51 @@ package financial
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
+	"vassistant-backend/fx"
+	"vassistant-backend/testutil"
 
-	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/stretchr/testify/assert"
-	"vassistant-backend/common"
 )
-// MockDynamoDBClient is a mock implementation of the DynamoDBAPI interface
-type MockDynamoDBClient struct {
-	common.DynamoDBAPI
-	QueryFunc        func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
-	BatchGetItemFunc func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
-	GetItemFunc      func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
-	PutItemFunc      func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
-}
 
 func TestGetGroupUsersHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
 			// Create sample group members
-			groupMember1 := GroupMember{
-				UserID: "user-1",
-			}
-			groupMember2 := GroupMember{
-				UserID: "user-2",
-			}
-
-			// Marshal the group members
-			av1, err := attributevalue.MarshalMap(groupMember1)
-			if err != nil {
-				return nil, err
-			}
-			av2, err := attributevalue.MarshalMap(groupMember2)
-			if err != nil {
-				return nil, err
-			}
-
 			return &dynamodb.QueryOutput{
-				Items: []map[string]types.AttributeValue{av1, av2},
+				Items: []map[string]types.AttributeValue{
+					testutil.MarshalItem(t, GroupMember{UserID: "user-1"}),
+					testutil.MarshalItem(t, GroupMember{UserID: "user-2"}),
+				},
 				Count: 2,
 			}, nil
 		},
 		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
 			// Create sample user data
-			user1 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-1"},
-				"showableName": &types.AttributeValueMemberS{Value: "User One"},
-			}
-			user2 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-2"},
-				"showableName": &types.AttributeValueMemberS{Value: "User Two"},
-			}
-			return &dynamodb.BatchGetItemOutput{
-				Responses: map[string][]map[string]types.AttributeValue{
-					"vassistant-users": {user1, user2},
-				},
-			}, nil
+			return testutil.UsersOutput(
+				testutil.UserItem("user-1", "User One"),
+				testutil.UserItem("user-2", "User Two"),
+			), nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		PathParameters: map[string]string{
-			"groupId": "test-group-id",
-		},
-	}
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		Build()
 
 	// Call the handler
-	response, err := GetGroupUsersHandler(request)
+	response, err := h.GetGroupUsersHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -95,25 +65,9 @@ func TestGetGroupUsersHandler(t *testing.T) {
 	assert.Equal(t, "User Two", users[1].ShowableName)
 }
 
-func (m *MockDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
-	return m.GetItemFunc(ctx, params, optFns...)
-}
-
-func (m *MockDynamoDBClient) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
-	return m.BatchGetItemFunc(ctx, params, optFns...)
-}
-
-func (m *MockDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
-	return m.PutItemFunc(ctx, params, optFns...)
-}
-
-func (m *MockDynamoDBClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
-	return m.QueryFunc(ctx, params, optFns...)
-}
-
 func TestGetGroupsHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
 			// Create a sample group member
 			groupMember := GroupMember{
@@ -122,32 +76,21 @@ func TestGetGroupsHandler(t *testing.T) {
 				GroupName:  "Test Group",
 				GroupImage: "test-image-url",
 			}
-			// Marshal the group member into a DynamoDB attribute value map
-			av, err := attributevalue.MarshalMap(groupMember)
-			if err != nil {
-				return nil, err
-			}
 			return &dynamodb.QueryOutput{
-				Items: []map[string]types.AttributeValue{av},
+				Items: []map[string]types.AttributeValue{testutil.MarshalItem(t, groupMember)},
 				Count: 1,
 			}, nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		RequestContext: events.APIGatewayProxyRequestContext{
-			Authorizer: map[string]interface{}{
-				"claims": map[string]interface{}{
-					"sub": "test-user-id",
-				},
-			},
-		},
-	}
+	request := testutil.NewRequest("GET", "").
+		WithClaims("test-user-id", "").
+		Build()
 
 	// Call the handler
-	response, err := GetGroupsHandler(request)
+	response, err := h.GetGroupsHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -167,7 +110,7 @@ func TestGetGroupsHandler(t *testing.T) {
 
 func TestGetGroupExpensesHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
 			// Assert that the query is asking for descending order
 			assert.NotNil(t, params.ScanIndexForward)
@@ -175,80 +118,64 @@ func TestGetGroupExpensesHandler(t *testing.T) {
 
 			// Create sample expenses
 			expense1 := FinancialExpense{
-				ExpenseID:   "test-expense-1",
-				GroupID:     "test-group-id",
-				Title: "Older Expense",
-				DateTime:    "2023-01-01T00:00:00Z",
-				Amount:      "100",
-				PaidBy:      "user-1",
-				CreatedBy:     "user-3",
+				ExpenseID: "test-expense-1",
+				GroupID:   "test-group-id",
+				Title:     "Older Expense",
+				DateTime:  "2023-01-01T00:00:00Z",
+				Amount:    "100",
+				PaidBy:    "user-1",
+				CreatedBy: "user-3",
 				Participants: []Participant{
 					{UserID: "user-1", Share: "50"},
 					{UserID: "user-2", Share: "50"},
 				},
 			}
 			expense2 := FinancialExpense{
-				ExpenseID:   "test-expense-2",
-				GroupID:     "test-group-id",
-				Title: "Newer Expense",
-				DateTime:    "2023-01-02T00:00:00Z",
-				Amount:      "200",
-				PaidBy:      "user-2",
-				CreatedBy:     "user-3",
+				ExpenseID: "test-expense-2",
+				GroupID:   "test-group-id",
+				Title:     "Newer Expense",
+				DateTime:  "2023-01-02T00:00:00Z",
+				Amount:    "200",
+				PaidBy:    "user-2",
+				CreatedBy: "user-3",
 				Participants: []Participant{
 					{UserID: "user-1", Share: "100"},
 					{UserID: "user-2", Share: "100"},
 				},
 			}
 
-			// Marshal the expenses
-			av1, err := attributevalue.MarshalMap(expense1)
-			if err != nil {
-				return nil, err
-			}
-			av2, err := attributevalue.MarshalMap(expense2)
-			if err != nil {
-				return nil, err
-			}
-
 			// Return the items as DynamoDB would: sorted descending
 			return &dynamodb.QueryOutput{
-				Items: []map[string]types.AttributeValue{av2, av1},
+				Items: []map[string]types.AttributeValue{
+					testutil.MarshalItem(t, expense2),
+					testutil.MarshalItem(t, expense1),
+				},
 				Count: 2,
 			}, nil
 		},
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			// The group has no settings
+			assert.Equal(t, "splitter-group-settings", *params.TableName)
+			return &dynamodb.GetItemOutput{}, nil
+		},
 		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
 			// Create sample user data
-			user1 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-1"},
-				"showableName": &types.AttributeValueMemberS{Value: "User One"},
-			}
-			user2 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-2"},
-				"showableName": &types.AttributeValueMemberS{Value: "User Two"},
-			}
-			user3 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-3"},
-				"showableName": &types.AttributeValueMemberS{Value: "User Three"},
-			}
-			return &dynamodb.BatchGetItemOutput{
-				Responses: map[string][]map[string]types.AttributeValue{
-					"vassistant-users": {user1, user2, user3},
-				},
-			}, nil
+			return testutil.UsersOutput(
+				testutil.UserItem("user-1", "User One"),
+				testutil.UserItem("user-2", "User Two"),
+				testutil.UserItem("user-3", "User Three"),
+			), nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		PathParameters: map[string]string{
-			"groupId": "test-group-id",
-		},
-	}
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		Build()
 
 	// Call the handler
-	response, err := GetGroupExpensesHandler(request)
+	response, err := h.GetGroupExpensesHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -283,7 +210,7 @@ func TestGetGroupExpensesHandler(t *testing.T) {
 
 func TestGetGroupHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
 			// Create a sample group member
 			groupMember := GroupMember{
@@ -292,34 +219,21 @@ func TestGetGroupHandler(t *testing.T) {
 				GroupName:  "Test Group",
 				GroupImage: "test-image-url",
 			}
-			// Marshal the group member into a DynamoDB attribute value map
-			av, err := attributevalue.MarshalMap(groupMember)
-			if err != nil {
-				return nil, err
-			}
 			return &dynamodb.GetItemOutput{
-				Item: av,
+				Item: testutil.MarshalItem(t, groupMember),
 			}, nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		RequestContext: events.APIGatewayProxyRequestContext{
-			Authorizer: map[string]interface{}{
-				"claims": map[string]interface{}{
-					"sub": "test-user-id",
-				},
-			},
-		},
-		PathParameters: map[string]string{
-			"groupId": "test-group-id",
-		},
-	}
+	request := testutil.NewRequest("GET", "").
+		WithClaims("test-user-id", "").
+		WithPathParam("groupId", "test-group-id").
+		Build()
 
 	// Call the handler
-	response, err := GetGroupHandler(request)
+	response, err := h.GetGroupHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -338,45 +252,39 @@ func TestGetGroupHandler(t *testing.T) {
 
 func TestPostGroupExpenseHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
+		// The group has no spending caps
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{}, nil
+		},
 		PutItemFunc: func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
 			return &dynamodb.PutItemOutput{}, nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request body
 	testDateTime := "2024-01-02T15:04:05Z"
 	expense := FinancialExpense{
-		Title: "Test Expense",
-		Amount:      "100",
-		DateTime:    testDateTime,
-		PaidBy:      "user-1",
+		Title:    "Test Expense",
+		Amount:   "100",
+		DateTime: testDateTime,
+		PaidBy:   "user-1",
 		Participants: []Participant{
 			{UserID: "user-1", Share: "50"},
 			{UserID: "user-2", Share: "50"},
 		},
 	}
-	body, err := json.Marshal(expense)
-	assert.NoError(t, err)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		RequestContext: events.APIGatewayProxyRequestContext{
-			Authorizer: map[string]interface{}{
-				"claims": map[string]interface{}{
-					"sub": "test-user-id",
-				},
-			},
-		},
-		PathParameters: map[string]string{
-			"groupId": "test-group-id",
-		},
-		Body: string(body),
-	}
+	request := testutil.NewRequest("POST", "").
+		WithClaims("test-user-id", "").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, expense).
+		Build()
 
 	// Call the handler
-	response, err := PostGroupExpenseHandler(request)
+	response, err := h.PostGroupExpenseHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -402,12 +310,16 @@ func TestPostGroupExpenseHandler(t *testing.T) {
 
 func TestPostGroupExpenseHandlerWithRounding(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
+		// The group has no spending caps
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{}, nil
+		},
 		PutItemFunc: func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
 			return &dynamodb.PutItemOutput{}, nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request body
 	expense := FinancialExpense{
@@ -418,26 +330,16 @@ func TestPostGroupExpenseHandlerWithRounding(t *testing.T) {
 			{UserID: "user-3", Share: "33.34"},
 		},
 	}
-	body, err := json.Marshal(expense)
-	assert.NoError(t, err)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		RequestContext: events.APIGatewayProxyRequestContext{
-			Authorizer: map[string]interface{}{
-				"claims": map[string]interface{}{
-					"sub": "test-user-id",
-				},
-			},
-		},
-		PathParameters: map[string]string{
-			"groupId": "test-group-id",
-		},
-		Body: string(body),
-	}
+	request := testutil.NewRequest("POST", "").
+		WithClaims("test-user-id", "").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, expense).
+		Build()
 
 	// Call the handler
-	response, err := PostGroupExpenseHandler(request)
+	response, err := h.PostGroupExpenseHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -455,7 +357,7 @@ func TestPostGroupExpenseHandlerWithRounding(t *testing.T) {
 
 func TestGetExpenseCategoriesHandler(t *testing.T) {
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{}
+	request := testutil.NewRequest("GET", "").Build()
 
 	// Call the handler
 	response, err := GetExpenseCategoriesHandler(request)
@@ -473,57 +375,41 @@ func TestGetExpenseCategoriesHandler(t *testing.T) {
 
 func TestGetExpenseHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
 			// Create a sample expense
 			expense := FinancialExpense{
 				ExpenseID: "test-expense-id",
 				GroupID:   "test-group-id",
 				PaidBy:    "user-1",
-				CreatedBy:   "user-2",
+				CreatedBy: "user-2",
 				Participants: []Participant{
 					{UserID: "user-1", Share: "50"},
 					{UserID: "user-2", Share: "50"},
 				},
 			}
-			// Marshal the expense into a DynamoDB attribute value map
-			av, err := attributevalue.MarshalMap(expense)
-			if err != nil {
-				return nil, err
-			}
 			return &dynamodb.GetItemOutput{
-				Item: av,
+				Item: testutil.MarshalItem(t, expense),
 			}, nil
 		},
 		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
 			// Create sample user data
-			user1 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-1"},
-				"showableName": &types.AttributeValueMemberS{Value: "User One"},
-			}
-			user2 := map[string]types.AttributeValue{
-				"userId":       &types.AttributeValueMemberS{Value: "user-2"},
-				"showableName": &types.AttributeValueMemberS{Value: "User Two"},
-			}
-			return &dynamodb.BatchGetItemOutput{
-				Responses: map[string][]map[string]types.AttributeValue{
-					"vassistant-users": {user1, user2},
-				},
-			}, nil
+			return testutil.UsersOutput(
+				testutil.UserItem("user-1", "User One"),
+				testutil.UserItem("user-2", "User Two"),
+			), nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		PathParameters: map[string]string{
-			"groupId":   "test-group-id",
-			"expenseId": "test-expense-id",
-		},
-	}
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", "test-expense-id").
+		Build()
 
 	// Call the handler
-	response, err := GetExpenseHandler(request)
+	response, err := h.GetExpenseHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -542,27 +428,359 @@ func TestGetExpenseHandler(t *testing.T) {
 
 func TestGetExpenseHandlerNotFound(t *testing.T) {
 	// Set up the mock DynamoDB client to return not found
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
 			return &dynamodb.GetItemOutput{
 				Item: nil, // Simulate not found
 			}, nil
 		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		PathParameters: map[string]string{
-			"groupId":   "test-group-id",
-			"expenseId": "non-existent-expense-id",
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", "non-existent-expense-id").
+		Build()
+
+	// Call the handler
+	response, err := h.GetExpenseHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response for 404 Not Found
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestGetGroupUsersHandlerBatchesLargeGroups(t *testing.T) {
+	const memberCount = 250
+
+	var batchCalls atomic.Int32
+	mockClient := &testutil.MockDynamoDBClient{
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			items := make([]map[string]types.AttributeValue, 0, memberCount)
+			for i := 0; i < memberCount; i++ {
+				items = append(items, testutil.GroupMemberItem(fmt.Sprintf("user-%d", i), "test-group-id", "Test Group"))
+			}
+			return &dynamodb.QueryOutput{Items: items, Count: memberCount}, nil
+		},
+		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+			batchCalls.Add(1)
+
+			// DynamoDB rejects batches with more than 100 keys
+			keys := params.RequestItems["vassistant-users"].Keys
+			assert.LessOrEqual(t, len(keys), 100)
+
+			// Echo the requested keys back as users
+			return testutil.UsersOutput(keys...), nil
 		},
 	}
+	h := NewHandlers(mockClient)
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		Build()
 
 	// Call the handler
-	response, err := GetExpenseHandler(request)
+	response, err := h.GetGroupUsersHandler(request)
 	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
 
-	// Check the response for 404 Not Found
+	var users []User
+	err = json.Unmarshal([]byte(response.Body), &users)
+	assert.NoError(t, err)
+	assert.Len(t, users, memberCount)
+	assert.Equal(t, int32(3), batchCalls.Load())
+
+	// Verify the users keep the group member order
+	assert.Equal(t, "user-0", users[0].UserID)
+	assert.Equal(t, "user-249", users[memberCount-1].UserID)
+}
+
+func TestGetGroupExpensesHandlerWithFields(t *testing.T) {
+	// Set up the mock DynamoDB client
+	mockClient := &testutil.MockDynamoDBClient{
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			// Assert that only the selected attributes, the keys and the sort field are projected
+			assert.NotNil(t, params.ProjectionExpression)
+			assert.ElementsMatch(t, []string{"groupId", "expenseId", "title", "amount", "paidBy", "dateTime"}, slices.Collect(maps.Values(params.ExpressionAttributeNames)))
+
+			expense := FinancialExpense{
+				ExpenseID: "test-expense-1",
+				GroupID:   "test-group-id",
+				Title:     "Dinner",
+				Amount:    "33.33",
+				PaidBy:    "user-1",
+			}
+			return &dynamodb.QueryOutput{
+				Items: []map[string]types.AttributeValue{testutil.MarshalItem(t, expense)},
+				Count: 1,
+			}, nil
+		},
+		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+			return testutil.UsersOutput(testutil.UserItem("user-1", "User One")), nil
+		},
+	}
+	h := NewHandlers(mockClient)
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("fields", "title,amount,paidByUser").
+		Build()
+
+	// Call the handler
+	response, err := h.GetGroupExpensesHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Verify only the selected fields are returned
+	var expenses []map[string]interface{}
+	err = json.Unmarshal([]byte(response.Body), &expenses)
+	assert.NoError(t, err)
+	assert.Len(t, expenses, 1)
+	assert.Len(t, expenses[0], 3)
+	assert.Equal(t, "Dinner", expenses[0]["title"])
+	assert.Equal(t, 33.33, expenses[0]["amount"])
+	assert.Equal(t, "User One", expenses[0]["paidByUser"].(map[string]interface{})["showableName"])
+}
+
+func TestGetGroupExpensesHandlerWithUnknownField(t *testing.T) {
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("fields", "title,catagory").
+		Build()
+
+	// Call the handler
+	response, err := NewHandlers(nil).GetGroupExpensesHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response for 400 Bad Request
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.Contains(t, response.Body, "catagory")
+}
+
+func TestGetGroupExpensesHandlerWithDisplayCurrency(t *testing.T) {
+	// Set up the fake DynamoDB client without a USD to JPY rate
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-expenses": {
+			{"groupId": "test-group-id", "expenseId": "expense-1", "amount": 10, "currency": "USD", "dateTime": "2024-01-01T10:00:00Z"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	fx.DynamoDbClient = fake
+
+	// An invalid currency code is rejected
+	request := testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("displayCurrency", "yen").
+		Build()
+	response, err := h.GetGroupExpensesHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	// A currency without a known rate cannot be displayed
+	request = testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("displayCurrency", "JPY").
+		Build()
+	response, err = h.GetGroupExpensesHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, response.StatusCode)
+}
+
+func TestGetGroupExpensesHandlerFormatting(t *testing.T) {
+	// Set up the fake DynamoDB client with a group displaying whole amounts the Brazilian way
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-expenses": {
+			{"groupId": "test-group-id", "expenseId": "expense-1", "amount": 1234.5, "currency": "BRL", "dateTime": "2024-01-01T10:00:00Z"},
+		},
+		"splitter-group-settings": {
+			{"groupId": "test-group-id", "formatting": map[string]interface{}{"decimalPlaces": 0, "rounding": "HALF_EVEN", "locale": "pt-BR"}},
+		},
+		"fx-rates": {
+			{"pair": "BRL-USD", "date": "2024-01-01", "rate": 0.2},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	fx.DynamoDbClient = fake
+
+	// The display strings are set next to the raw amounts
+	response, err := h.GetGroupExpensesHandler(testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("displayCurrency", "USD").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var expenses []FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expenses))
+	assert.Len(t, expenses, 1)
+	assert.Equal(t, "1234.5", expenses[0].Amount.String())
+	assert.Equal(t, "1.234 BRL", expenses[0].AmountText)
+	assert.Equal(t, "247 USD", expenses[0].Display.Text)
+
+	// Invalid rules are rejected
+	response, err = h.PutGroupSettingsHandler(testutil.NewRequest("PUT", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithBody(`{"formatting":{"decimalPlaces":7}}`).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestPutGroupSettingsHandlerTimeZone(t *testing.T) {
+	// Set up the fake DynamoDB client with a member of the group
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Only the IANA time zones are accepted
+	for _, timeZone := range []string{"Mars/Olympus_Mons", "Local"} {
+		response, err := h.PutGroupSettingsHandler(testutil.NewRequest("PUT", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "test-group-id").
+			WithJSONBody(t, GroupSettings{TimeZone: timeZone}).
+			Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, timeZone)
+	}
+
+	response, err := h.PutGroupSettingsHandler(testutil.NewRequest("PUT", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, GroupSettings{TimeZone: "America/Sao_Paulo"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var settings GroupSettings
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &settings))
+	assert.Equal(t, "America/Sao_Paulo", settings.TimeZone)
+}
+
+func TestPostGroupExpenseHandlerGroupAmount(t *testing.T) {
+	// Set up the fake DynamoDB client with a EUR group and the USD rates around the expense
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-settings": {{"groupId": "test-group-id", "defaultCurrency": "EUR"}},
+		"fx-rates": {
+			{"pair": "USD-EUR", "date": "2024-01-01", "rate": 0.9},
+			{"pair": "USD-EUR", "date": "2024-03-01", "rate": 0.95},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	fx.DynamoDbClient = fake
+
+	// The expense records the rate of its date rather than today's
+	request := testutil.NewRequest("POST", "").
+		WithClaims("test-user-id", "").
+		WithPathParam("groupId", "test-group-id").
+		WithBody(`{"amount": 10, "currency": "USD", "dateTime": "2024-02-10T12:00:00Z", "participants": [{"userId": "user-1", "share": 100}]}`).
+		Build()
+	response, err := h.PostGroupExpenseHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var expense FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expense))
+	assert.Equal(t, &fx.Conversion{Currency: "EUR", Amount: "9.00", Rate: "0.9", RateDate: "2024-01-01"}, expense.GroupAmount)
+
+	// The recorded amount is the one displayed in the group currency
+	request = testutil.NewRequest("GET", "").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", expense.ExpenseID).
+		WithQueryParam("displayCurrency", "EUR").
+		Build()
+	response, err = h.GetExpenseHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expense))
+	assert.Equal(t, expense.GroupAmount, expense.Display)
+}
+
+func TestPostGroupExpenseHandlerConflict(t *testing.T) {
+	// Set up the mock DynamoDB client to reject the conditional write
+	mockClient := &testutil.MockDynamoDBClient{
+		// The group has no spending caps
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{}, nil
+		},
+		PutItemFunc: func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+			assert.Equal(t, "attribute_not_exists(#key)", *params.ConditionExpression)
+			assert.Equal(t, "expenseId", params.ExpressionAttributeNames["#key"])
+			return nil, &types.ConditionalCheckFailedException{}
+		},
+	}
+	h := NewHandlers(mockClient)
+
+	// Create a sample request
+	request := testutil.NewRequest("POST", "").
+		WithClaims("test-user-id", "").
+		WithPathParam("groupId", "test-group-id").
+		WithBody(`{"amount": 10, "participants": [{"userId": "user-1", "share": 100}]}`).
+		Build()
+
+	// Call the handler
+	response, err := h.PostGroupExpenseHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response for 409 Conflict
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+}
+
+func TestGetGroupHandlerConsistentRead(t *testing.T) {
+	// Set up the mock DynamoDB client
+	mockClient := &testutil.MockDynamoDBClient{
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			// Assert that the freshness hint turns on strongly consistent reads
+			assert.NotNil(t, params.ConsistentRead)
+			assert.True(t, *params.ConsistentRead)
+			return &dynamodb.GetItemOutput{Item: nil}, nil
+		},
+	}
+	h := NewHandlers(mockClient)
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithHeader("x-consistent-read", "true").
+		WithClaims("test-user-id", "").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+
+	// Call the handler
+	response, err := h.GetGroupHandler(request)
+	assert.NoError(t, err)
 	assert.Equal(t, http.StatusNotFound, response.StatusCode)
 }
+
+func TestGetGroupExpensesHandlerConsistentRead(t *testing.T) {
+	// Set up the mock DynamoDB client
+	mockClient := &testutil.MockDynamoDBClient{
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			// Assert that the freshness hint reads the table, as the index can't be read consistently
+			assert.Nil(t, params.IndexName)
+			assert.NotNil(t, params.ConsistentRead)
+			assert.True(t, *params.ConsistentRead)
+			return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{}}, nil
+		},
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{Item: nil}, nil
+		},
+	}
+	h := NewHandlers(mockClient)
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithHeader("X-Consistent-Read", "true").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+
+	// Call the handler
+	response, err := h.GetGroupExpensesHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
@@ -0,0 +1,99 @@
+package financial
+
+import (
+	"context"
+	"log"
+	"vassistant-backend/realtime"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// getGroupMember returns the membership of the user in the group, or nil if the user isn't a member.
+func (h *Handlers) getGroupMember(ctx context.Context, userId, groupId string) (*GroupMember, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-group-members"),
+		Key: map[string]types.AttributeValue{
+			"userId":  &types.AttributeValueMemberS{Value: userId},
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var groupMember GroupMember
+	err = attributevalue.UnmarshalMap(result.Item, &groupMember)
+	if err != nil {
+		return nil, err
+	}
+	return &groupMember, nil
+}
+
+// getGroupMembers returns the user IDs and roles of all the members of the group.
+func (h *Handlers) getGroupMembers(ctx context.Context, groupId string) ([]GroupMember, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-group-members"),
+		IndexName:              aws.String("groupId-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ProjectionExpression:     aws.String("userId, #role"),
+		ExpressionAttributeNames: map[string]string{"#role": "role"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var groupMembers []GroupMember
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &groupMembers)
+	if err != nil {
+		return nil, err
+	}
+	return groupMembers, nil
+}
+
+// getGroupMemberIds returns the user IDs of all the members of the group.
+func (h *Handlers) getGroupMemberIds(ctx context.Context, groupId string) ([]string, error) {
+	groupMembers, err := h.getGroupMembers(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := make([]string, 0, len(groupMembers))
+	for _, member := range groupMembers {
+		userIds = append(userIds, member.UserID)
+	}
+	return userIds, nil
+}
+
+// announceExpenses broadcasts the new expenses of the group to the connected clients of all
+// its members and notifies the members mentioned in their notes. The expenses are stored
+// already, so a failure is only logged.
+func (h *Handlers) announceExpenses(ctx context.Context, groupId string, expenses ...FinancialExpense) {
+	for _, expense := range expenses {
+		h.notifyMentioned(ctx, groupId, expense.CreatedBy, expense.Mentions, NotificationMentionedInExpense, map[string]string{
+			"expenseId": expense.ExpenseID, "title": expense.Title,
+		})
+	}
+
+	h.appendToSheet(ctx, groupId, expenses)
+
+	if !realtime.Enabled() || len(expenses) == 0 {
+		return
+	}
+	userIds, err := h.getGroupMemberIds(ctx, groupId)
+	if err != nil {
+		log.Printf("Error getting the members of group %s to broadcast to: %v", groupId, err)
+		return
+	}
+	for _, expense := range expenses {
+		realtime.Publish(ctx, realtime.EventExpenseCreated, expense, userIds...)
+	}
+}
@@ -0,0 +1,465 @@
+package financial
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// maxGuestLinkLabelLength bounds the label telling the links of a group apart.
+const maxGuestLinkLabelLength = 100
+
+// GuestLink struct for the splitter-guest-links table. Only the hash of the secret of the
+// link is stored, the token is returned once when the link is created.
+type GuestLink struct {
+	LinkID     string `json:"linkId" dynamodbav:"linkId"`
+	GroupID    string `json:"groupId" dynamodbav:"groupId"`
+	GroupName  string `json:"groupName" dynamodbav:"groupName"`
+	Label      string `json:"label" dynamodbav:"label"`
+	CreatedBy  string `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt  string `json:"createdAt" dynamodbav:"createdAt"`
+	RevokedAt  string `json:"revokedAt,omitempty" dynamodbav:"revokedAt,omitempty"`
+	SecretHash string `json:"-" dynamodbav:"secretHash"`
+}
+
+// CreatedGuestLink is the response of the link creation, the only one holding its token.
+type CreatedGuestLink struct {
+	GuestLink
+	Token string `json:"token"`
+}
+
+// GuestLinkRequest struct for the create guest link request body
+type GuestLinkRequest struct {
+	Label string `json:"label"`
+}
+
+// GuestShare is an amount of a member in the guest view, who is only known by their showable name.
+type GuestShare struct {
+	Name   string      `json:"name"`
+	Amount json.Number `json:"amount"`
+}
+
+// GuestExpense is an expense in the guest view.
+type GuestExpense struct {
+	Title        string       `json:"title"`
+	Category     string       `json:"category"`
+	Amount       json.Number  `json:"amount"`
+	Currency     string       `json:"currency"`
+	DateTime     string       `json:"dateTime"`
+	Payers       []GuestShare `json:"payers"`
+	Participants []GuestShare `json:"participants"`
+	Disputed     bool         `json:"disputed,omitempty"`
+}
+
+// GuestBalance is what a member is owed in a currency, negative when they owe.
+type GuestBalance struct {
+	Name     string      `json:"name"`
+	Currency string      `json:"currency"`
+	Amount   json.Number `json:"amount"`
+}
+
+// GuestView is the read-only view of a group served through a guest link.
+type GuestView struct {
+	GroupName string         `json:"groupName"`
+	Expenses  []GuestExpense `json:"expenses"`
+	Balances  []GuestBalance `json:"balances"`
+}
+
+// hashSecret returns the hash of the secret of a guest link stored in the table.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newGuestToken generates the token of a new link: its ID and a random secret.
+func newGuestToken(linkId string) (token, secret string, err error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(random)
+	return linkId + "." + secret, secret, nil
+}
+
+// getGuestLink returns the link of the token, or nil if there is none or it was revoked.
+func (h *Handlers) getGuestLink(ctx context.Context, token string) (*GuestLink, error) {
+	linkId, secret, ok := strings.Cut(token, ".")
+	if !ok || linkId == "" || secret == "" {
+		return nil, nil
+	}
+
+	link, err := h.getGuestLinkById(ctx, linkId)
+	if err != nil || link == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(link.SecretHash)) != 1 || link.RevokedAt != "" {
+		return nil, nil
+	}
+	return link, nil
+}
+
+func (h *Handlers) getGuestLinkById(ctx context.Context, linkId string) (*GuestLink, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-guest-links"),
+		Key: map[string]types.AttributeValue{
+			"linkId": &types.AttributeValueMemberS{Value: linkId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var link GuestLink
+	err = attributevalue.UnmarshalMap(result.Item, &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// getGuestLinkAdmin returns the membership of the caller when they are an admin of the group,
+// or the response to send back otherwise.
+func (h *Handlers) getGuestLinkAdmin(request events.APIGatewayProxyRequest) (*GroupMember, *events.APIGatewayProxyResponse) {
+	reject := func(statusCode int, message string) (*GroupMember, *events.APIGatewayProxyResponse) {
+		response, _ := common.CreateErrorResponse(statusCode, message)
+		return nil, &response
+	}
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		response, _ := auth.ErrorResponse(err)
+		return nil, &response
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return reject(400, "Group ID is missing")
+	}
+
+	// Only admins can manage the guest links of the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if admin == nil {
+		return reject(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return reject(403, "Only group admins can manage guest links")
+	}
+	return admin, nil
+}
+
+func (h *Handlers) PostGuestLinkHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	admin, rejection := h.getGuestLinkAdmin(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	// Parse the request body into a GuestLinkRequest struct
+	var linkRequest GuestLinkRequest
+	err := json.Unmarshal([]byte(request.Body), &linkRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	label := strings.TrimSpace(linkRequest.Label)
+	if len(label) > maxGuestLinkLabelLength {
+		return common.CreateErrorResponse(400, "Label is too long")
+	}
+
+	link := GuestLink{
+		LinkID:    uuid.New().String(),
+		GroupID:   admin.GroupID,
+		GroupName: admin.GroupName,
+		Label:     label,
+		CreatedBy: admin.UserID,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	token, secret, err := newGuestToken(link.LinkID)
+	if err != nil {
+		log.Printf("Error generating guest link token: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	link.SecretHash = hashSecret(secret)
+
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-guest-links", link, common.IfNotExists("linkId"))
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Admin %s created guest link %s for group %s", admin.UserID, link.LinkID, link.GroupID)
+
+	// Marshal the link and its token into JSON for the payload
+	payload, err := json.Marshal(CreatedGuestLink{GuestLink: link, Token: token})
+	if err != nil {
+		log.Println("Error marshalling guest link:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) GetGuestLinksHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	admin, rejection := h.getGuestLinkAdmin(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	// Make the DynamoDB Query API call
+	result, err := h.client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-guest-links"),
+		IndexName:              aws.String("groupId-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: admin.GroupID},
+		},
+	})
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Unmarshal the Items into a slice of GuestLink structs
+	links := []GuestLink{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &links)
+	if err != nil {
+		log.Printf("Error unmarshalling guest links: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the links into JSON for the payload
+	payload, err := json.Marshal(links)
+	if err != nil {
+		log.Println("Error marshalling guest links:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) RevokeGuestLinkHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	admin, rejection := h.getGuestLinkAdmin(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	// Extract linkId from path parameters
+	linkId, ok := request.PathParameters["linkId"]
+	if !ok || linkId == "" {
+		return common.CreateErrorResponse(400, "Link ID is missing")
+	}
+
+	link, err := h.getGuestLinkById(context.TODO(), linkId)
+	if err != nil {
+		log.Printf("Error getting guest link from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if link == nil || link.GroupID != admin.GroupID {
+		return common.CreateErrorResponse(404, "Link not found")
+	}
+
+	// Revoked links are kept, so the admins can still see who had access
+	if link.RevokedAt == "" {
+		link.RevokedAt = time.Now().Format(time.RFC3339)
+		err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-guest-links", link, common.IfExists("linkId"))
+		if err != nil && !errors.Is(err, common.ErrConditionFailed) {
+			log.Printf("Error putting item into DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		log.Printf("Admin %s revoked guest link %s of group %s", admin.UserID, linkId, link.GroupID)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+// GetGuestViewHandler serves the read-only view of a group to the holder of a guest link.
+// It is a public route: the token is the only credential, so it is never logged.
+func (h *Handlers) GetGuestViewHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %s guest view\n", request.HTTPMethod)
+
+	link, err := h.getGuestLink(context.TODO(), request.PathParameters["token"])
+	if err != nil {
+		log.Printf("Error getting guest link from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if link == nil {
+		return common.CreateErrorResponse(404, "Link not found")
+	}
+
+	// The links of a deleted group stop opening while it can still be restored
+	deletion, err := h.getGroupDeletion(context.TODO(), link.GroupID)
+	if err != nil {
+		log.Printf("Error getting group deletion from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if deletion != nil {
+		return common.CreateErrorResponse(404, "Link not found")
+	}
+
+	expenses, err := h.queryExpenses(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		IndexName:              aws.String("groupId-dateTime-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: link.GroupID},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	settings, err := h.getGroupSettings(context.TODO(), link.GroupID)
+	if err != nil {
+		log.Printf("Error getting group settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Members are only shown by their showable names
+	userIds := make(map[string]struct{})
+	for _, expense := range expenses {
+		for _, userId := range involvedMembers(expense) {
+			userIds[userId] = struct{}{}
+		}
+	}
+	userMap, err := h.getUsersByIds(context.TODO(), userIds)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	view := guestView(link.GroupName, expenses, settings.DefaultCurrency, userMap)
+	log.Printf("Served guest link %s of group %s", link.LinkID, link.GroupID)
+
+	// Marshal the view into JSON for the payload
+	payload, err := json.Marshal(view)
+	if err != nil {
+		log.Println("Error marshalling guest view:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json", "Cache-Control": "no-store"},
+		Body:       string(payload),
+	}, nil
+}
+
+// guestView builds the guest view of the expenses, naming the members by their showable names.
+func guestView(groupName string, expenses []FinancialExpense, defaultCurrency string, userMap userDirectory) GuestView {
+	name := func(userId string) string {
+		return userMap.User(userId).ShowableName
+	}
+
+	view := GuestView{GroupName: groupName, Expenses: make([]GuestExpense, 0, len(expenses))}
+	for _, expense := range expenses {
+		guestExpense := GuestExpense{
+			Title:        expense.Title,
+			Category:     expense.Category,
+			Amount:       expense.Amount,
+			Currency:     expense.Currency,
+			DateTime:     expense.DateTime,
+			Payers:       make([]GuestShare, 0, len(expense.Payers)),
+			Participants: make([]GuestShare, 0, len(expense.Participants)),
+			Disputed:     isDisputed(expense),
+		}
+		if guestExpense.Currency == "" {
+			guestExpense.Currency = defaultCurrency
+		}
+		for _, payer := range expense.Payers {
+			guestExpense.Payers = append(guestExpense.Payers, GuestShare{Name: name(payer.UserID), Amount: payer.Amount})
+		}
+		for _, participant := range expense.Participants {
+			guestExpense.Participants = append(guestExpense.Participants, GuestShare{Name: name(participant.UserID), Amount: participant.CalculatedMoney})
+		}
+		view.Expenses = append(view.Expenses, guestExpense)
+	}
+
+	balances := groupBalances(expenses, defaultCurrency)
+	view.Balances = make([]GuestBalance, 0, len(balances))
+	for key, amount := range balances {
+		view.Balances = append(view.Balances, GuestBalance{Name: name(key.userId), Currency: key.currency, Amount: json.Number(amount.FloatString(2))})
+	}
+	sort.Slice(view.Balances, func(i, j int) bool {
+		if view.Balances[i].Currency != view.Balances[j].Currency {
+			return view.Balances[i].Currency < view.Balances[j].Currency
+		}
+		return view.Balances[i].Name < view.Balances[j].Name
+	})
+	return view
+}
+
+// groupBalances returns the outstanding balance of every member of the group per currency:
+// what they are owed, negative when they owe. Disputed expenses are left out until resolved.
+func groupBalances(expenses []FinancialExpense, defaultCurrency string) map[debtKey]*big.Rat {
+	balances := map[debtKey]*big.Rat{}
+	add := func(userId, currency string, amount *big.Rat) {
+		key := debtKey{userId, currency}
+		if balances[key] == nil {
+			balances[key] = new(big.Rat)
+		}
+		balances[key].Add(balances[key], amount)
+	}
+
+	for _, expense := range expenses {
+		if isDisputed(expense) {
+			continue
+		}
+		currency := expense.Currency
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		for creditor := range credits(expense) {
+			for _, participant := range expense.Participants {
+				owed := owedTo(expense, participant, creditor)
+				if owed.Sign() == 0 {
+					continue
+				}
+				add(creditor, currency, owed)
+				add(participant.UserID, currency, new(big.Rat).Neg(owed))
+			}
+		}
+	}
+	return balances
+}
@@ -0,0 +1,149 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newGuestLinksFake seeds a group administered by user-1 with an expense paid by user-1 and
+// shared with user-2.
+func newGuestLinksFake(t *testing.T) *Handlers {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House"},
+		},
+		"splitter-expenses": {{
+			"groupId": "test-group-id", "expenseId": "expense-1", "title": "Internet", "amount": "60", "paidBy": "user-1",
+			"currency": "EUR", "dateTime": "2024-01-01T00:00:00Z", "participants": []map[string]interface{}{
+				{"userId": "user-1", "share": "50.00", "calculatedMoney": "30.00"},
+				{"userId": "user-2", "share": "50.00", "calculatedMoney": "30.00"},
+			},
+		}},
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice@example.com", "showableName": "Alice"},
+			{"userId": "user-2", "username": "bob@example.com", "showableName": "Bob"},
+		},
+	})
+	assert.NoError(t, err)
+	return NewHandlers(fake)
+}
+
+func TestGuestLinks(t *testing.T) {
+	h := newGuestLinksFake(t)
+
+	// Only admins can create guest links
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, map[string]string{"label": "Accountant"}).
+		Build()
+	response, err := h.PostGuestLinkHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Create a sample request
+	request = testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, map[string]string{"label": "Accountant"}).
+		Build()
+
+	// Call the handler
+	response, err = h.PostGuestLinkHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var created CreatedGuestLink
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &created))
+	assert.Equal(t, "Accountant", created.Label)
+	assert.True(t, strings.HasPrefix(created.Token, created.LinkID+"."))
+
+	// The guest sees the expenses and balances by showable name only
+	guestRequest := testutil.NewRequest("GET", "").WithPathParam("token", created.Token).Build()
+	response, err = h.GetGuestViewHandler(guestRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.NotContains(t, response.Body, "user-1")
+	assert.NotContains(t, response.Body, "example.com")
+	var view GuestView
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &view))
+	assert.Equal(t, "House", view.GroupName)
+	assert.Len(t, view.Expenses, 1)
+	assert.Equal(t, []GuestShare{{Name: "Alice", Amount: "60"}}, view.Expenses[0].Payers)
+	assert.Equal(t, []GuestBalance{
+		{Name: "Alice", Currency: "EUR", Amount: "30.00"},
+		{Name: "Bob", Currency: "EUR", Amount: "-30.00"},
+	}, view.Balances)
+
+	// A wrong secret doesn't open the link
+	wrongRequest := testutil.NewRequest("GET", "").WithPathParam("token", created.LinkID+".wrong").Build()
+	response, err = h.GetGuestViewHandler(wrongRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	// The link is listed without its token
+	listRequest := testutil.NewRequest("GET", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+	response, err = h.GetGuestLinksHandler(listRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, response.Body, created.LinkID)
+	assert.NotContains(t, response.Body, created.Token)
+
+	// Once revoked, the link no longer opens
+	revokeRequest := testutil.NewRequest("DELETE", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("linkId", created.LinkID).
+		Build()
+	response, err = h.RevokeGuestLinkHandler(revokeRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	response, err = h.GetGuestViewHandler(guestRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestGuestLinkOfDeletedGroup(t *testing.T) {
+	h := newGuestLinksFake(t)
+	response, err := h.PostGuestLinkHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, map[string]string{"label": "Accountant"}).
+		Build())
+	assert.NoError(t, err)
+	var created CreatedGuestLink
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &created))
+
+	// The link stops opening once the group is deleted
+	response, err = h.DeleteGroupHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	guestRequest := testutil.NewRequest("GET", "").WithPathParam("token", created.Token).Build()
+	response, err = h.GetGuestViewHandler(guestRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	// And is purged with the group
+	result, err := h.PurgeDeletedGroups(t.Context(), time.Now().Add(DeletionRetention+time.Hour), PurgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Items["splitter-guest-links"])
+	link, err := h.getGuestLinkById(t.Context(), created.LinkID)
+	assert.NoError(t, err)
+	assert.Nil(t, link)
+}
@@ -0,0 +1,103 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/uploads"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+)
+
+// PutExpenseImageHandler stores the image uploaded as the "image" field of a multipart body
+// and sets it as the image of the expense.
+func (h *Handlers) PutExpenseImageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// The body holds the uploaded file, so only its size is logged
+	log.Printf("request: %s %s (%d bytes)\n", request.HTTPMethod, request.Path, len(request.Body))
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and expenseId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Expense ID is missing")
+	}
+
+	// Validate the upload before touching DynamoDB or S3
+	file, err := uploads.ParseMultipart(request, "image")
+	if err != nil {
+		return uploads.ErrorResponse(err)
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	expense, err := h.getExpense(context.TODO(), groupId, expenseId)
+	if err != nil {
+		log.Printf("Error getting expense from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if expense == nil {
+		return common.CreateErrorResponse(404, "Expense not found")
+	}
+
+	// Refuse a new attachment past the quota before storing it, replacing the image is fine
+	if expense.ImageURL == "" {
+		if quotaErr := checkQuota(QuotaAttachments, attachments(*expense), 1, GroupQuotas.AttachmentsPerExpense); quotaErr != nil {
+			return quotaResponse(quotaErr)
+		}
+	}
+
+	// Every upload gets its own key, so cached copies of a replaced image are never served
+	imageURL, err := uploads.Store(context.TODO(), "expenses/"+groupId+"/"+expenseId+"/"+uuid.New().String(), file)
+	if err != nil {
+		log.Printf("Error putting object into S3: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Store the expense, unless it was changed since it was read
+	expense.ImageURL = imageURL
+	expectedVersion := expense.Version
+	expense.Version = expectedVersion + 1
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-expenses", expense, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expense was modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Stored image %s (%d bytes) for expense %s", imageURL, len(file.Data), expenseId)
+
+	// Marshal the expense into JSON for the payload
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		log.Println("Error marshalling expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
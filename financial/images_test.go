@@ -0,0 +1,92 @@
+package financial
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"vassistant-backend/testutil"
+	"vassistant-backend/uploads"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockS3Client struct {
+	keys []string
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	m.keys = append(m.keys, aws.ToString(params.Key))
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestPutExpenseImageHandler(t *testing.T) {
+	// Set up the fake DynamoDB and the mock S3 client
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+		"splitter-expenses":      {{"groupId": "test-group-id", "expenseId": "expense-1", "title": "Dinner"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	s3Client := &mockS3Client{}
+	uploads.S3Client = s3Client
+	uploads.Bucket = "uploads-bucket"
+
+	// Create a sample request uploading a PNG image
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("image", "receipt.png")
+	assert.NoError(t, err)
+	_, err = part.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	request := testutil.NewRequest("PUT", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", "expense-1").
+		WithHeader("Content-Type", writer.FormDataContentType()).
+		WithBody(body.String()).
+		Build()
+
+	// Call the handler
+	response, err := h.PutExpenseImageHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var expense FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expense))
+	assert.Len(t, s3Client.keys, 1)
+	assert.True(t, strings.HasPrefix(s3Client.keys[0], "expenses/test-group-id/expense-1/"))
+	assert.Equal(t, "https://uploads-bucket.s3.amazonaws.com/"+s3Client.keys[0], expense.ImageURL)
+
+	// The image is stored with the expense
+	stored, err := h.getExpense(context.TODO(), "test-group-id", "expense-1")
+	assert.NoError(t, err)
+	assert.Equal(t, expense.ImageURL, stored.ImageURL)
+	assert.Equal(t, "Dinner", stored.Title)
+}
+
+func TestPutExpenseImageHandlerUnsupportedType(t *testing.T) {
+	// Create a sample request with a body that isn't multipart
+	request := testutil.NewRequest("PUT", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", "expense-1").
+		WithHeader("Content-Type", "image/png").
+		WithBody("\x89PNG").
+		Build()
+
+	// Call the handler
+	response, err := NewHandlers(nil).PutExpenseImageHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response for 400 Bad Request
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
@@ -0,0 +1,307 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/integrations/email"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// The types of the notifications sent about the forwarded emails.
+const (
+	NotificationEmailDrafted    = "EMAIL_EXPENSE_DRAFTED"
+	NotificationEmailUnreadable = "EMAIL_EXPENSE_UNREADABLE"
+)
+
+// inboxesTable holds the inbound email addresses, keyed by inboxId, with the userId-index
+// to find the address of a user.
+const inboxesTable = "splitter-email-inboxes"
+
+// ErrUnknownInbox is returned for the emails sent to an address nobody owns, e.g. one
+// rotated since.
+var ErrUnknownInbox = errors.New("unknown inbound email address")
+
+// ErrUnreadableEmail is returned for the emails no expense could be read from, e.g. without
+// a total.
+var ErrUnreadableEmail = errors.New("no expense could be read from the email")
+
+// InboundEmailDomain is the domain of the inbound email addresses, received by SES. Email-in
+// is disabled when empty.
+var InboundEmailDomain string
+
+// EmailInbox is the address a user forwards receipts to, drafting expenses in the group. The
+// inbox ID is random, so the address can't be guessed from the user.
+type EmailInbox struct {
+	InboxID   string `json:"-" dynamodbav:"inboxId"`
+	UserID    string `json:"-" dynamodbav:"userId"`
+	GroupID   string `json:"groupId" dynamodbav:"groupId"`
+	Address   string `json:"address" dynamodbav:"-"`
+	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// EmailInboxRequest is the body of the inbox endpoint. Rotate replaces the address, e.g.
+// once it leaked.
+type EmailInboxRequest struct {
+	GroupID string `json:"groupId"`
+	Rotate  bool   `json:"rotate"`
+}
+
+// InboxID returns the inbox ID of a recipient address of the inbound domain, ignoring its
+// +tag, or "" for the other addresses.
+func InboxID(recipient string) string {
+	local, domain, ok := strings.Cut(strings.ToLower(strings.TrimSpace(recipient)), "@")
+	if !ok || InboundEmailDomain == "" || domain != strings.ToLower(InboundEmailDomain) {
+		return ""
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local
+}
+
+func (h *Handlers) getInbox(ctx context.Context, inboxId string) (*EmailInbox, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(inboxesTable),
+		Key: map[string]types.AttributeValue{
+			"inboxId": &types.AttributeValueMemberS{Value: inboxId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var inbox EmailInbox
+	if err := attributevalue.UnmarshalMap(result.Item, &inbox); err != nil {
+		return nil, err
+	}
+	return &inbox, nil
+}
+
+// getUserInbox returns the inbox of the user, or nil when the user has none.
+func (h *Handlers) getUserInbox(ctx context.Context, userId string) (*EmailInbox, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(inboxesTable),
+		IndexName:              aws.String("userId-index"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, nil
+	}
+	var inbox EmailInbox
+	if err := attributevalue.UnmarshalMap(result.Items[0], &inbox); err != nil {
+		return nil, err
+	}
+	return &inbox, nil
+}
+
+func (h *Handlers) deleteInbox(ctx context.Context, inboxId string) error {
+	_, err := h.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(inboxesTable),
+		Key: map[string]types.AttributeValue{
+			"inboxId": &types.AttributeValueMemberS{Value: inboxId},
+		},
+	})
+	return err
+}
+
+// DraftFromEmail drafts the expense of the receipt forwarded to the inbox, paid by its owner,
+// and notifies the owner to confirm it in the app. The owner is told about the emails no
+// expense could be read from too.
+func (h *Handlers) DraftFromEmail(ctx context.Context, inboxId string, raw io.Reader) (*ExpenseDraft, error) {
+	inbox, err := h.getInbox(ctx, inboxId)
+	if err != nil {
+		return nil, err
+	}
+	if inbox == nil {
+		return nil, ErrUnknownInbox
+	}
+
+	receipt, err := email.Parse(raw)
+	if err != nil {
+		notifyMembers(ctx, []string{inbox.UserID}, NotificationEmailUnreadable, map[string]string{"subject": receipt.Subject})
+		return nil, fmt.Errorf("%w: %w", ErrUnreadableEmail, err)
+	}
+
+	title := receipt.Merchant
+	if title == "" {
+		title = receipt.Subject
+	}
+	expense := FinancialExpense{
+		Title:    title,
+		Amount:   json.Number(receipt.Amount),
+		Currency: receipt.Currency,
+		PaidBy:   inbox.UserID,
+		Notes:    receipt.Subject,
+	}
+	if !receipt.Date.IsZero() {
+		expense.DateTime = receipt.Date.UTC().Format(time.RFC3339)
+	}
+	draft, err := h.ProposeExpense(ctx, inbox.UserID, inbox.GroupID, expense)
+	if err != nil {
+		return nil, err
+	}
+
+	notifyMembers(ctx, []string{inbox.UserID}, NotificationEmailDrafted, map[string]string{
+		"draftId": draft.DraftID, "title": title, "amount": receipt.Amount, "currency": receipt.Currency,
+	})
+	return &draft, nil
+}
+
+func (h *Handlers) GetEmailInboxHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if InboundEmailDomain == "" {
+		return common.CreateErrorResponse(503, "Email-in is not available")
+	}
+
+	inbox, err := h.getUserInbox(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error querying email inbox from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if inbox == nil {
+		return common.CreateErrorResponse(404, "No email inbox")
+	}
+	return inboxResponse(200, inbox)
+}
+
+func (h *Handlers) PutEmailInboxHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if InboundEmailDomain == "" {
+		return common.CreateErrorResponse(503, "Email-in is not available")
+	}
+
+	// Parse the request body into an EmailInboxRequest struct
+	var inboxRequest EmailInboxRequest
+	err = json.Unmarshal([]byte(request.Body), &inboxRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if inboxRequest.GroupID == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// The drafts go to a group of the user
+	member, err := h.getGroupMember(ctx, claims.Sub, inboxRequest.GroupID)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	existing, err := h.getUserInbox(ctx, claims.Sub)
+	if err != nil {
+		log.Printf("Error querying email inbox from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	inbox := existing
+	if inbox == nil || inboxRequest.Rotate {
+		inbox = &EmailInbox{
+			InboxID:   strings.ReplaceAll(uuid.New().String(), "-", ""),
+			UserID:    claims.Sub,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+	inbox.GroupID = inboxRequest.GroupID
+
+	av, err := attributevalue.MarshalMap(inbox)
+	if err != nil {
+		log.Printf("Error marshalling email inbox: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(inboxesTable),
+		Item:      av,
+	})
+	if err != nil {
+		log.Printf("Error putting email inbox into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// The rotated address stops working right away
+	if existing != nil && existing.InboxID != inbox.InboxID {
+		if err := h.deleteInbox(ctx, existing.InboxID); err != nil {
+			log.Printf("Error deleting email inbox from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+
+	log.Printf("Successfully set the email inbox of user %s to group %s", claims.Sub, inbox.GroupID)
+	return inboxResponse(200, inbox)
+}
+
+func (h *Handlers) DeleteEmailInboxHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	inbox, err := h.getUserInbox(ctx, claims.Sub)
+	if err != nil {
+		log.Printf("Error querying email inbox from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if inbox != nil {
+		if err := h.deleteInbox(ctx, inbox.InboxID); err != nil {
+			log.Printf("Error deleting email inbox from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+func inboxResponse(statusCode int, inbox *EmailInbox) (events.APIGatewayProxyResponse, error) {
+	inbox.Address = inbox.InboxID + "@" + InboundEmailDomain
+
+	// Marshal the inbox into JSON for the payload
+	payload, err := json.Marshal(inbox)
+	if err != nil {
+		log.Println("Error marshalling email inbox:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
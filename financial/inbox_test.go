@@ -0,0 +1,67 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDraftFromEmail(t *testing.T) {
+	// Set up the fake DynamoDB with two housemates
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	notifications.DynamoDbClient = fake
+	InboundEmailDomain = "in.example.com"
+	defer func() { InboundEmailDomain = "" }()
+
+	putInbox := func(body EmailInboxRequest) EmailInbox {
+		response, err := h.PutEmailInboxHandler(testutil.NewRequest("PUT", "").
+			WithClaims("user-1", "alice").
+			WithJSONBody(t, body).
+			Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var inbox EmailInbox
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &inbox))
+		return inbox
+	}
+	inbox := putInbox(EmailInboxRequest{GroupID: "house"})
+	assert.True(t, strings.HasSuffix(inbox.Address, "@in.example.com"))
+	assert.Equal(t, inbox, putInbox(EmailInboxRequest{GroupID: "house"}))
+
+	// A forwarded receipt becomes a draft of its owner, split with the group
+	inboxId := InboxID(strings.ToUpper(strings.Replace(inbox.Address, "@", "+receipts@", 1)))
+	raw := "From: Pizza Place <orders@pizza.example>\r\nSubject: Fwd: Your receipt\r\nDate: Tue, 02 Jan 2024 20:00:00 +0000\r\n\r\nTotal: 30.00 EUR\r\n"
+	draft, err := h.DraftFromEmail(t.Context(), inboxId, strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", draft.UserID)
+	assert.Equal(t, "Pizza Place", draft.Expense.Title)
+	assert.Equal(t, "30.00", draft.Expense.Amount.String())
+	assert.Equal(t, "EUR", draft.Expense.Currency)
+	assert.Equal(t, "2024-01-02T20:00:00Z", draft.Expense.DateTime)
+	assert.Len(t, draft.Expense.Participants, 2)
+	assert.Equal(t, []string{NotificationEmailDrafted}, notificationsOf(t, fake, "user-1"))
+
+	// The owner is told when nothing could be read
+	_, err = h.DraftFromEmail(t.Context(), inboxId, strings.NewReader("Subject: Hi\r\n\r\nNothing to see"))
+	assert.ErrorIs(t, err, ErrUnreadableEmail)
+	assert.Equal(t, []string{NotificationEmailDrafted, NotificationEmailUnreadable}, notificationsOf(t, fake, "user-1"))
+
+	// A rotated address stops working
+	rotated := putInbox(EmailInboxRequest{GroupID: "house", Rotate: true})
+	assert.NotEqual(t, inbox.Address, rotated.Address)
+	_, err = h.DraftFromEmail(t.Context(), inboxId, strings.NewReader(raw))
+	assert.ErrorIs(t, err, ErrUnknownInbox)
+	assert.Empty(t, InboxID("someone@example.com"))
+}
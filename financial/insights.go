@@ -0,0 +1,316 @@
+package financial
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/llm"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// periodLayout is the layout of the insight periods, the months of the group in its time zone.
+const periodLayout = "2006-01"
+
+// insightsRefreshInterval is how long cached insights are served after the spending they
+// describe changed, bounding how often new expenses regenerate them.
+const insightsRefreshInterval = 6 * time.Hour
+
+// insightsTTL is how long generated insights are cached.
+const insightsTTL = 30 * 24 * time.Hour
+
+// insightsSystemPrompt instructs the model to describe the spending summary only.
+const insightsSystemPrompt = `You write short spending insights for a group sharing expenses.
+You get a JSON summary of the spending per category and currency for a month and the month before.
+Write 2 to 4 short sentences comparing both months, like "Food spending is up 30% compared to last month".
+Only use the figures in the summary, never make up numbers, and don't give financial advice.`
+
+// CategorySpend is the spending of a group in a category and currency over a period and the one before.
+type CategorySpend struct {
+	Category      string      `json:"category" dynamodbav:"category"`
+	Currency      string      `json:"currency" dynamodbav:"currency"`
+	Total         json.Number `json:"total" dynamodbav:"total"`
+	PreviousTotal json.Number `json:"previousTotal" dynamodbav:"previousTotal"`
+}
+
+// GroupInsights struct for the splitter-group-insights table, caching the insights of a period
+type GroupInsights struct {
+	GroupID     string          `json:"groupId" dynamodbav:"groupId"`
+	Period      string          `json:"period" dynamodbav:"period"`
+	Spending    []CategorySpend `json:"spending" dynamodbav:"spending"`
+	Insights    string          `json:"insights" dynamodbav:"insights"`
+	AIGenerated bool            `json:"aiGenerated" dynamodbav:"aiGenerated"`
+	Model       string          `json:"model" dynamodbav:"model"`
+	GeneratedAt string          `json:"generatedAt" dynamodbav:"generatedAt"`
+	Fingerprint string          `json:"-" dynamodbav:"fingerprint"`
+	ExpiresAt   int64           `json:"-" dynamodbav:"expiresAt"`      // DynamoDB TTL attribute, in Unix seconds
+	Caps        []CapStatus     `json:"caps,omitempty" dynamodbav:"-"` // the spending caps of the members in the period, never cached
+}
+
+// summarizeSpending totals the expenses per category and currency in the period and the
+// one before it, the months starting on the start day in the time zone of the group.
+// Expenses without a currency are counted in the default currency.
+func summarizeSpending(expenses []FinancialExpense, period time.Time, startDay int, location *time.Location, defaultCurrency string) []CategorySpend {
+	previous := period.AddDate(0, -1, 0)
+	type key struct{ category, currency string }
+	totals := map[key][2]*big.Rat{}
+
+	for _, expense := range expenses {
+		dateTime, err := time.Parse(time.RFC3339, expense.DateTime)
+		if err != nil {
+			continue
+		}
+		index := -1
+		switch fiscalMonth(dateTime, startDay, location).Format(periodLayout) {
+		case period.Format(periodLayout):
+			index = 1
+		case previous.Format(periodLayout):
+			index = 0
+		}
+		amount, ok := new(big.Rat).SetString(string(expense.Amount))
+		if index < 0 || !ok {
+			continue
+		}
+
+		currency := expense.Currency
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		k := key{expense.Category, currency}
+		total, ok := totals[k]
+		if !ok {
+			total = [2]*big.Rat{new(big.Rat), new(big.Rat)}
+			totals[k] = total
+		}
+		total[index].Add(total[index], amount)
+	}
+
+	spending := make([]CategorySpend, 0, len(totals))
+	for k, total := range totals {
+		spending = append(spending, CategorySpend{
+			Category:      k.category,
+			Currency:      k.currency,
+			Total:         json.Number(total[1].FloatString(2)),
+			PreviousTotal: json.Number(total[0].FloatString(2)),
+		})
+	}
+	sort.Slice(spending, func(i, j int) bool {
+		if spending[i].Category != spending[j].Category {
+			return spending[i].Category < spending[j].Category
+		}
+		return spending[i].Currency < spending[j].Currency
+	})
+	return spending
+}
+
+// spendingFingerprint identifies a spending summary, so cached insights are regenerated
+// once the figures they describe change.
+func spendingFingerprint(spending []CategorySpend) string {
+	var builder strings.Builder
+	for _, spend := range spending {
+		fmt.Fprintf(&builder, "%s|%s|%s|%s\n", spend.Category, spend.Currency, spend.Total, spend.PreviousTotal)
+	}
+	sum := sha256.Sum256([]byte(builder.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// getCachedInsights returns the cached insights of the period, or nil if there are none
+// or they have expired.
+func (h *Handlers) getCachedInsights(ctx context.Context, groupId, period string) (*GroupInsights, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-group-insights"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+			"period":  &types.AttributeValueMemberS{Value: period},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var insights GroupInsights
+	err = attributevalue.UnmarshalMap(result.Item, &insights)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= insights.ExpiresAt {
+		return nil, nil
+	}
+	return &insights, nil
+}
+
+// insightsUpToDate reports whether the cached insights can be served: they describe the
+// same figures, or were generated less than insightsRefreshInterval ago.
+func insightsUpToDate(insights *GroupInsights, fingerprint string, now time.Time) bool {
+	if insights == nil {
+		return false
+	}
+	if insights.Fingerprint == fingerprint {
+		return true
+	}
+	generatedAt, err := time.Parse(time.RFC3339, insights.GeneratedAt)
+	return err == nil && now.Sub(generatedAt) < insightsRefreshInterval
+}
+
+func (h *Handlers) GetGroupInsightsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	settings, err := h.getGroupSettings(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Parse the optional period, the current month of the group by default
+	now := time.Now().UTC()
+	currentPeriod := fiscalMonth(now, settings.MonthStartDay, settings.location()).Format(periodLayout)
+	period := request.QueryStringParameters["period"]
+	if period == "" {
+		period = currentPeriod
+	}
+	periodStart, err := time.Parse(periodLayout, period)
+	if err != nil || period > currentPeriod {
+		return common.CreateErrorResponse(400, "Invalid period")
+	}
+
+	// Aggregate the spending of the period and the one before
+	expenses, err := h.queryExpenses(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		IndexName:              aws.String("groupId-dateTime-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ProjectionExpression:     aws.String("#category, #currency, #amount, #dateTime, #participants, #groupAmount"),
+		ExpressionAttributeNames: map[string]string{"#category": "category", "#currency": "currency", "#amount": "amount", "#dateTime": "dateTime", "#participants": "participants", "#groupAmount": "groupAmount"},
+	})
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	spending := summarizeSpending(expenses, periodStart, settings.MonthStartDay, settings.location(), settings.DefaultCurrency)
+	fingerprint := spendingFingerprint(spending)
+
+	// Serve the cached insights while they describe the same figures
+	insights, err := h.getCachedInsights(context.TODO(), groupId, period)
+	if err != nil {
+		log.Printf("Error getting cached insights from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if !insightsUpToDate(insights, fingerprint, now) {
+		insights, err = h.generateInsights(context.TODO(), claims.Sub, groupId, period, spending, fingerprint, now)
+		if errors.Is(err, llm.ErrNotConfigured) {
+			return common.CreateErrorResponse(503, "Insights are not available")
+		}
+		if err != nil {
+			log.Printf("Error generating insights: %v", err)
+			return common.CreateErrorResponse(502, "Insights could not be generated")
+		}
+	}
+
+	// Add how much of their spending cap the members spent in the period
+	caps, err := h.getSpendingCaps(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting spending caps from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if len(caps.Caps) > 0 {
+		spent := memberSpending(expenses, period, settings.MonthStartDay, settings.location(), settings.DefaultCurrency)
+		insights.Caps = capStatuses(caps, spent, period, settings.DefaultCurrency)
+	}
+
+	// Marshal the insights into JSON for the payload
+	payload, err := json.Marshal(insights)
+	if err != nil {
+		log.Println("Error marshalling insights:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json", "X-AI-Generated": "true"},
+		Body:       string(payload),
+	}, nil
+}
+
+// generateInsights asks the language model to describe the spending for the user and caches the result.
+func (h *Handlers) generateInsights(ctx context.Context, userId, groupId, period string, spending []CategorySpend, fingerprint string, now time.Time) (*GroupInsights, error) {
+	summary, err := json.Marshal(map[string]interface{}{"period": period, "spending": spending})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := llm.Complete(ctx, llm.Request{
+		System:    insightsSystemPrompt,
+		Messages:  []llm.Message{{Role: llm.RoleUser, Content: string(summary)}},
+		MaxTokens: 300,
+		User:      userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	insights := &GroupInsights{
+		GroupID:     groupId,
+		Period:      period,
+		Spending:    spending,
+		Insights:    strings.TrimSpace(response.Content),
+		AIGenerated: true,
+		Model:       response.Model,
+		GeneratedAt: now.Format(time.RFC3339),
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(insightsTTL).Unix(),
+	}
+
+	// Failing to cache the insights doesn't fail the request, they are generated again next time
+	av, err := attributevalue.MarshalMap(insights)
+	if err == nil {
+		_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String("splitter-group-insights"),
+			Item:      av,
+		})
+	}
+	if err != nil {
+		log.Printf("Error caching insights of group %s: %v", groupId, err)
+	}
+
+	log.Printf("Generated insights of group %s for %s with %d input tokens", groupId, period, response.InputTokens)
+	return insights, nil
+}
@@ -0,0 +1,140 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeSpending(t *testing.T) {
+	expenses := []FinancialExpense{
+		{Category: "FOOD", Amount: "10.50", Currency: "USD", DateTime: "2024-02-03T10:00:00Z"},
+		{Category: "FOOD", Amount: "4.50", Currency: "USD", DateTime: "2024-02-29T23:59:59Z"},
+		{Category: "FOOD", Amount: "20", DateTime: "2024-01-15T10:00:00Z"},
+		{Category: "FOOD", Amount: "99", Currency: "USD", DateTime: "2023-12-31T10:00:00Z"},
+		{Category: "TRAVEL", Amount: "100", Currency: "BRL", DateTime: "2024-02-10T10:00:00Z"},
+	}
+
+	spending := summarizeSpending(expenses, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), 0, time.UTC, "USD")
+	assert.Equal(t, []CategorySpend{
+		{Category: "FOOD", Currency: "USD", Total: "15.00", PreviousTotal: "20.00"},
+		{Category: "TRAVEL", Currency: "BRL", Total: "100.00", PreviousTotal: "0.00"},
+	}, spending)
+
+	// With the months starting on the 15th, February runs until March 14th
+	spending = summarizeSpending(expenses, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), 15, time.UTC, "USD")
+	assert.Equal(t, []CategorySpend{
+		{Category: "FOOD", Currency: "USD", Total: "4.50", PreviousTotal: "30.50"},
+		{Category: "TRAVEL", Currency: "BRL", Total: "0.00", PreviousTotal: "100.00"},
+	}, spending)
+
+	// In Tokyo, the last expense of February falls on March 1st
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+	spending = summarizeSpending(expenses, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), 0, tokyo, "USD")
+	assert.Equal(t, []CategorySpend{
+		{Category: "FOOD", Currency: "USD", Total: "10.50", PreviousTotal: "20.00"},
+		{Category: "TRAVEL", Currency: "BRL", Total: "100.00", PreviousTotal: "0.00"},
+	}, spending)
+}
+
+func TestFiscalMonth(t *testing.T) {
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), fiscalMonth(time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC), 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), fiscalMonth(time.Date(2024, 1, 25, 0, 0, 0, 0, time.UTC), 25, time.UTC))
+	assert.Equal(t, time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), fiscalMonth(time.Date(2024, 1, 24, 23, 59, 0, 0, time.UTC), 25, time.UTC))
+	assert.Equal(t, time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), fiscalMonth(time.Date(2024, 1, 2, 1, 0, 0, 0, time.FixedZone("BRT", 3*3600)), 2, time.UTC))
+
+	// Late at night in São Paulo is already the next month in UTC
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), fiscalMonth(time.Date(2024, 2, 1, 1, 0, 0, 0, time.UTC), 0, saoPaulo))
+}
+
+func TestGetGroupInsightsHandler(t *testing.T) {
+	// Set up the fake DynamoDB and a model counting its calls
+	now := time.Now().UTC()
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+		"splitter-expenses": {
+			{"groupId": "test-group-id", "expenseId": "expense-1", "category": "FOOD", "amount": "30", "currency": "USD", "dateTime": now.Format(time.RFC3339)},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	calls := 0
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		calls++
+		assert.Contains(t, request.Messages[0].Content, `"total":30.00`)
+		return llm.Response{Content: " Food spending is 30 USD this month. ", Model: "test-model"}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	getInsights := func() GroupInsights {
+		request := testutil.NewRequest("GET", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "test-group-id").
+			Build()
+		response, err := h.GetGroupInsightsHandler(request)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "true", response.Headers["X-AI-Generated"])
+
+		var insights GroupInsights
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &insights))
+		return insights
+	}
+
+	// The insights are generated and labeled as such
+	insights := getInsights()
+	assert.True(t, insights.AIGenerated)
+	assert.Equal(t, "Food spending is 30 USD this month.", insights.Insights)
+	assert.Equal(t, now.Format(periodLayout), insights.Period)
+	assert.Equal(t, 1, calls)
+
+	// Then served from the cache
+	getInsights()
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetGroupInsightsHandlerNotConfigured(t *testing.T) {
+	// Set up the fake DynamoDB without a model
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	llm.DefaultProvider = nil
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("period", "2024-01").
+		Build()
+
+	// Call the handler
+	response, err := h.GetGroupInsightsHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response for 503 Service Unavailable
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+}
+
+func TestInsightsUpToDate(t *testing.T) {
+	now := time.Now()
+	insights := &GroupInsights{Fingerprint: "a", GeneratedAt: now.Add(-time.Hour).Format(time.RFC3339)}
+
+	assert.False(t, insightsUpToDate(nil, "a", now))
+	assert.True(t, insightsUpToDate(insights, "a", now))
+
+	// Changed figures are regenerated at most every insightsRefreshInterval
+	assert.True(t, insightsUpToDate(insights, "b", now))
+	assert.False(t, insightsUpToDate(insights, "b", now.Add(insightsRefreshInterval)))
+}
@@ -0,0 +1,507 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/notifications"
+	"vassistant-backend/plans"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// Group member roles
+const (
+	RoleAdmin  = "ADMIN"
+	RoleMember = "MEMBER"
+)
+
+// Join request statuses
+const (
+	JoinRequestPending  = "PENDING"
+	JoinRequestApproved = "APPROVED"
+	JoinRequestDenied   = "DENIED"
+)
+
+// JoinRequest struct for the splitter-join-requests table
+type JoinRequest struct {
+	GroupID   string `json:"groupId" dynamodbav:"groupId"`
+	UserID    string `json:"userId" dynamodbav:"userId"`
+	Status    string `json:"status" dynamodbav:"status"`
+	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+	DecidedBy string `json:"decidedBy" dynamodbav:"decidedBy"`
+	DecidedAt string `json:"decidedAt" dynamodbav:"decidedAt"`
+	User      User   `json:"user" dynamodbav:"-"`
+}
+
+// getJoinRequest returns the join request of the user to the group, or nil if there is none.
+func (h *Handlers) getJoinRequest(ctx context.Context, groupId, userId string) (*JoinRequest, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-join-requests"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+			"userId":  &types.AttributeValueMemberS{Value: userId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var joinRequest JoinRequest
+	err = attributevalue.UnmarshalMap(result.Item, &joinRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &joinRequest, nil
+}
+
+func (h *Handlers) PostJoinRequestHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only discoverable groups accept join requests; others look like they don't exist
+	settings, err := h.getGroupSettings(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if !settings.Discoverable {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member != nil {
+		return common.CreateErrorResponse(409, "Already a group member")
+	}
+
+	// The plan of the user bounds how many groups they can be in
+	groups, err := h.listUserGroups(context.TODO(), claims.Sub)
+	if err == nil {
+		err = h.plans.Allows(context.TODO(), claims.Sub, plans.Groups, len(groups))
+	}
+	var limitErr *plans.LimitError
+	if errors.As(err, &limitErr) {
+		return plans.ErrorResponse(limitErr)
+	}
+	if err != nil {
+		log.Printf("Error checking the group quota: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Store the request, allowing a single pending request per user and group. A decided
+	// request is replaced, so a denied user, or one who left the group, can ask again.
+	existing, err := h.getJoinRequest(context.TODO(), groupId, claims.Sub)
+	if err != nil {
+		log.Printf("Error getting join request from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	condition := common.IfNotExists("userId")
+	if existing != nil {
+		if existing.Status == JoinRequestPending {
+			return common.CreateErrorResponse(409, "Join request already exists")
+		}
+		condition = common.IfEquals("status", existing.Status)
+	}
+	joinRequest := JoinRequest{
+		GroupID:   groupId,
+		UserID:    claims.Sub,
+		Status:    JoinRequestPending,
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-join-requests", joinRequest, condition)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Join request already exists")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s requested to join group %s", claims.Sub, groupId)
+
+	// Marshal the join request into JSON for the payload
+	payload, err := json.Marshal(joinRequest)
+	if err != nil {
+		log.Println("Error marshalling join request:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) GetJoinRequestsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only admins can manage the join requests of the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can manage join requests")
+	}
+
+	// Build the query input
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-join-requests"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ConsistentRead: common.ConsistentRead(request),
+	}
+
+	// Make the DynamoDB Query API call
+	result, err := h.client.Query(context.TODO(), queryInput)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Unmarshal the Items into a slice of JoinRequest structs
+	var joinRequests []JoinRequest
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &joinRequests)
+	if err != nil {
+		log.Printf("Error unmarshalling join requests: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Keep the pending requests only
+	pending := make([]JoinRequest, 0, len(joinRequests))
+	userIds := make(map[string]struct{})
+	for _, joinRequest := range joinRequests {
+		if joinRequest.Status == JoinRequestPending {
+			pending = append(pending, joinRequest)
+			userIds[joinRequest.UserID] = struct{}{}
+		}
+	}
+
+	// Fetch the details of the requesting users
+	userMap, err := h.getUsersForViewer(context.TODO(), claims.Sub, userIds)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	for i, joinRequest := range pending {
+		pending[i].User = userMap.User(joinRequest.UserID)
+	}
+
+	log.Printf("Successfully retrieved %d pending join requests for group %s", len(pending), groupId)
+
+	// Marshal the join requests into JSON for the payload
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		log.Println("Error marshalling join requests:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) ApproveJoinRequestHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.decideJoinRequest(request, JoinRequestApproved)
+}
+
+func (h *Handlers) DenyJoinRequestHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.decideJoinRequest(request, JoinRequestDenied)
+}
+
+// decideJoinRequest approves or denies a pending join request, adding the user to the group
+// when approved and notifying them of the decision.
+func (h *Handlers) decideJoinRequest(request events.APIGatewayProxyRequest, status string) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and userId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	userId, ok := request.PathParameters["userId"]
+	if !ok || userId == "" {
+		return common.CreateErrorResponse(400, "User ID is missing")
+	}
+
+	// Only admins can manage the join requests of the group
+	admin, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can manage join requests")
+	}
+
+	joinRequest, err := h.getJoinRequest(context.TODO(), groupId, userId)
+	if err != nil {
+		log.Printf("Error getting join request from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if joinRequest == nil {
+		return common.CreateErrorResponse(404, "Join request not found")
+	}
+	if joinRequest.Status != JoinRequestPending {
+		return common.CreateErrorResponse(409, "Join request already decided")
+	}
+
+	// Add the user to the group before recording the decision, so a failure can be retried
+	if status == JoinRequestApproved {
+		quotaErr, err := h.checkMemberQuota(context.TODO(), groupId)
+		if err != nil {
+			log.Printf("Error checking the member quota: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if quotaErr != nil {
+			return quotaResponse(quotaErr)
+		}
+
+		newMember := GroupMember{
+			UserID:     userId,
+			GroupID:    groupId,
+			GroupName:  admin.GroupName,
+			GroupImage: admin.GroupImage,
+			Role:       RoleMember,
+		}
+		err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-group-members", newMember, common.IfNotExists("userId"))
+		if err != nil && !errors.Is(err, common.ErrConditionFailed) {
+			log.Printf("Error putting item into DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+
+	// Record the decision, unless another admin decided in the meantime
+	joinRequest.Status = status
+	joinRequest.DecidedBy = claims.Sub
+	joinRequest.DecidedAt = time.Now().Format(time.RFC3339)
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-join-requests", joinRequest, common.IfEquals("status", JoinRequestPending))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Join request already decided")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Join request of user %s to group %s was %s by %s", userId, groupId, status, claims.Sub)
+
+	// Let the user know, without failing the decision if the notification can't be stored
+	notificationType := "JOIN_REQUEST_APPROVED"
+	if status == JoinRequestDenied {
+		notificationType = "JOIN_REQUEST_DENIED"
+	}
+	err = notifications.Notify(context.TODO(), userId, notificationType, map[string]string{"groupId": groupId, "groupName": admin.GroupName})
+	if err != nil {
+		log.Printf("Error notifying user %s: %v", userId, err)
+	}
+
+	// Marshal the join request into JSON for the payload
+	payload, err := json.Marshal(joinRequest)
+	if err != nil {
+		log.Println("Error marshalling join request:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// DiscoverableGroup is a group accepting join requests, listed to the users who aren't in it.
+type DiscoverableGroup struct {
+	GroupID     string `json:"groupId"`
+	GroupName   string `json:"groupName"`
+	GroupImage  string `json:"groupImage"`
+	MemberCount int    `json:"memberCount"`
+}
+
+// discoverableGroupIds returns the IDs of the discoverable groups, following the pages of
+// the settings.
+func (h *Handlers) discoverableGroupIds(ctx context.Context) ([]string, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName:            aws.String("splitter-group-settings"),
+		ProjectionExpression: aws.String("groupId, discoverable"),
+	}
+
+	var groupIds []string
+	for {
+		result, err := h.client.Scan(ctx, scanInput)
+		if err != nil {
+			return nil, err
+		}
+		var items []GroupSettings
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Discoverable {
+				groupIds = append(groupIds, item.GroupID)
+			}
+		}
+
+		scanInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(scanInput.ExclusiveStartKey) == 0 {
+			return groupIds, nil
+		}
+	}
+}
+
+// discoverableGroup returns the discoverable group as listed to the user, or nil when the
+// user is a member already or the group has no members left or waits to be purged.
+func (h *Handlers) discoverableGroup(ctx context.Context, userId, groupId string) (*DiscoverableGroup, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-group-members"),
+		IndexName:              aws.String("groupId-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var members []GroupMember
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &members)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	for _, member := range members {
+		if member.UserID == userId || member.Status == GroupDeletedPending {
+			return nil, nil
+		}
+	}
+	return &DiscoverableGroup{
+		GroupID:     groupId,
+		GroupName:   members[0].GroupName,
+		GroupImage:  members[0].GroupImage,
+		MemberCount: len(members),
+	}, nil
+}
+
+// GetDiscoverableGroupsHandler lists the discoverable groups the user isn't in, the groups
+// they can send a join request to.
+func (h *Handlers) GetDiscoverableGroupsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	groupIds, err := h.discoverableGroupIds(context.TODO())
+	if err != nil {
+		log.Printf("Error scanning group settings in DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	listed := make([]*DiscoverableGroup, len(groupIds))
+	g, ctx := errgroup.WithContext(context.TODO())
+	g.SetLimit(maxParallelGroups)
+	for i, groupId := range groupIds {
+		g.Go(func() error {
+			group, err := h.discoverableGroup(ctx, claims.Sub, groupId)
+			listed[i] = group
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		log.Printf("Error getting group members from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	groups := make([]DiscoverableGroup, 0, len(listed))
+	for _, group := range listed {
+		if group != nil {
+			groups = append(groups, *group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].GroupName != groups[j].GroupName {
+			return groups[i].GroupName < groups[j].GroupName
+		}
+		return groups[i].GroupID < groups[j].GroupID
+	})
+
+	log.Printf("Listed %d discoverable groups for user %s", len(groups), claims.Sub)
+
+	// Marshal the groups into JSON for the payload
+	payload, err := json.Marshal(groups)
+	if err != nil {
+		log.Println("Error marshalling discoverable groups:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
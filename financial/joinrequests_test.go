@@ -0,0 +1,166 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newJoinRequestsFake seeds a discoverable group administered by user-1.
+func newJoinRequestsFake(t *testing.T, discoverable bool) (*Handlers, *testutil.FakeDynamoDB) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House", "role": RoleMember},
+		},
+		"splitter-group-settings": {
+			{"groupId": "test-group-id", "discoverable": discoverable},
+		},
+	})
+	assert.NoError(t, err)
+	notifications.DynamoDbClient = fake
+	return NewHandlers(fake), fake
+}
+
+func TestJoinRequestApproval(t *testing.T) {
+	h, fake := newJoinRequestsFake(t, true)
+
+	// Request to join the group
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-3", "carol").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+	response, err := h.PostJoinRequestHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	// Members who aren't admins can't approve it
+	request = testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("userId", "user-3").
+		Build()
+	response, err = h.ApproveJoinRequestHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// The admin approves it
+	request = testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("userId", "user-3").
+		Build()
+	response, err = h.ApproveJoinRequestHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// The user is now a member and was notified
+	member, err := h.getGroupMember(context.TODO(), "user-3", "test-group-id")
+	assert.NoError(t, err)
+	assert.NotNil(t, member)
+	assert.Equal(t, RoleMember, member.Role)
+	assert.Equal(t, "House", member.GroupName)
+
+	notified, err := fake.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("notifications"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: "user-3"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, notified.Items, 1)
+
+	// A decided request can't be decided again
+	response, err = h.DenyJoinRequestHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+}
+
+func TestPostJoinRequestHandlerNotDiscoverable(t *testing.T) {
+	h, _ := newJoinRequestsFake(t, false)
+
+	// Create a sample request
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-3", "carol").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+
+	// Call the handler
+	response, err := h.PostJoinRequestHandler(request)
+	assert.NoError(t, err)
+
+	// Check the response
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestJoinRequestAfterDenial(t *testing.T) {
+	h, _ := newJoinRequestsFake(t, true)
+	post := func() int {
+		response, err := h.PostJoinRequestHandler(testutil.NewRequest("POST", "").
+			WithClaims("user-3", "carol").
+			WithPathParam("groupId", "test-group-id").
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+
+	// A single request is pending at a time
+	assert.Equal(t, http.StatusCreated, post())
+	assert.Equal(t, http.StatusConflict, post())
+
+	response, err := h.DenyJoinRequestHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("userId", "user-3").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Once denied, the user can ask again
+	assert.Equal(t, http.StatusCreated, post())
+	joinRequest, err := h.getJoinRequest(context.TODO(), "test-group-id", "user-3")
+	assert.NoError(t, err)
+	assert.Equal(t, JoinRequestPending, joinRequest.Status)
+	assert.Empty(t, joinRequest.DecidedBy)
+}
+
+func TestGetDiscoverableGroupsHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "house", "groupName": "House", "role": RoleMember},
+			{"userId": "user-1", "groupId": "club", "groupName": "Book club", "groupImage": "books.png", "role": RoleAdmin},
+			{"userId": "user-3", "groupId": "trip", "groupName": "Trip", "role": RoleAdmin},
+			{"userId": "user-1", "groupId": "old", "groupName": "Old", "role": RoleAdmin, "status": GroupDeletedPending},
+		},
+		"splitter-group-settings": {
+			{"groupId": "house", "discoverable": true},
+			{"groupId": "club", "discoverable": true},
+			{"groupId": "trip", "discoverable": true},
+			{"groupId": "old", "discoverable": true},
+			{"groupId": "secret", "discoverable": false},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// The groups of the user, hidden ones and those waiting to be purged are left out
+	response, err := h.GetDiscoverableGroupsHandler(testutil.NewRequest("GET", "").WithClaims("user-3", "carol").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var groups []DiscoverableGroup
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &groups))
+	assert.Equal(t, []DiscoverableGroup{
+		{GroupID: "club", GroupName: "Book club", GroupImage: "books.png", MemberCount: 1},
+		{GroupID: "house", GroupName: "House", MemberCount: 2},
+	}, groups)
+}
@@ -0,0 +1,535 @@
+package financial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/offload"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ledgerTable holds the ledger entries, keyed by groupId and sequence.
+const ledgerTable = "splitter-ledger"
+
+// ledgerPageSize is the number of ledger entries read at a time, so a ledger is never held
+// whole in memory.
+const ledgerPageSize = 1000
+
+// The financial events recorded in the ledger.
+const (
+	LedgerExpenseRecorded       = "EXPENSE_RECORDED"
+	LedgerExpenseChanged        = "EXPENSE_CHANGED"
+	LedgerExpenseRemoved        = "EXPENSE_REMOVED"
+	LedgerShareSettled          = "SHARE_SETTLED"
+	LedgerReimbursementRecorded = "REIMBURSEMENT_RECORDED"
+)
+
+// ledgerGenesisHash is the previous hash of the first entry of a ledger.
+var ledgerGenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// LedgerEntry struct for the splitter-ledger table: a financial event of the group, chained
+// to the entry before it by its hash. Entries are only ever appended, so a copy of the
+// ledger exported earlier stays a prefix of the later ones.
+type LedgerEntry struct {
+	GroupID      string          `json:"groupId" dynamodbav:"groupId"`
+	Sequence     int             `json:"sequence" dynamodbav:"sequence"`
+	Type         string          `json:"type" dynamodbav:"type"`
+	OccurredAt   string          `json:"occurredAt,omitempty" dynamodbav:"occurredAt,omitempty"`
+	RecordedAt   string          `json:"recordedAt" dynamodbav:"recordedAt"`
+	Data         json.RawMessage `json:"data" dynamodbav:"data"`
+	PreviousHash string          `json:"previousHash" dynamodbav:"previousHash"`
+	Hash         string          `json:"hash,omitempty" dynamodbav:"hash"`
+}
+
+// LedgerVerification is the result of verifying an exported ledger. The ledger is valid when
+// every entry follows the one before it and hashes to its hash; it is recorded when the
+// group's own ledger has the same head at the same sequence.
+type LedgerVerification struct {
+	Valid                bool   `json:"valid"`
+	Recorded             bool   `json:"recorded"`
+	Entries              int    `json:"entries"`
+	HeadHash             string `json:"headHash,omitempty"`
+	FirstInvalidSequence int    `json:"firstInvalidSequence,omitempty"`
+	Reason               string `json:"reason,omitempty"`
+}
+
+// ledgerExpense is the data of the expense events: what the expense charges to whom.
+type ledgerExpense struct {
+	ExpenseID    string              `json:"expenseId"`
+	Title        string              `json:"title,omitempty"`
+	Category     string              `json:"category,omitempty"`
+	Amount       json.Number         `json:"amount,omitempty"`
+	Currency     string              `json:"currency,omitempty"`
+	DateTime     string              `json:"dateTime,omitempty"`
+	PaidBy       string              `json:"paidBy,omitempty"`
+	Payers       []ledgerPayer       `json:"payers,omitempty"`
+	Participants []ledgerParticipant `json:"participants,omitempty"`
+}
+
+type ledgerPayer struct {
+	UserID string      `json:"userId"`
+	Amount json.Number `json:"amount"`
+}
+
+type ledgerParticipant struct {
+	UserID          string      `json:"userId"`
+	Share           json.Number `json:"share,omitempty"`
+	CalculatedMoney json.Number `json:"calculatedMoney,omitempty"`
+}
+
+// ledgerSettlement is the data of the settlement events: how much of their share a
+// participant settled so far.
+type ledgerSettlement struct {
+	ExpenseID     string      `json:"expenseId"`
+	UserID        string      `json:"userId"`
+	SettledAmount json.Number `json:"settledAmount,omitempty"`
+	Settled       bool        `json:"settled,omitempty"`
+}
+
+// computeHash returns the SHA-256 of the entry without its hash, in hex.
+func (entry LedgerEntry) computeHash() (string, error) {
+	entry.Hash = ""
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ledgerState is what the ledger last recorded of each expense, settlement and
+// reimbursement, to tell which events are new, and where the ledger ends.
+type ledgerState struct {
+	expenses       map[string]string
+	settlements    map[string]string
+	reimbursements map[string]bool
+	entries        int
+	headHash       string
+}
+
+func newLedgerState() *ledgerState {
+	return &ledgerState{
+		expenses:       map[string]string{},
+		settlements:    map[string]string{},
+		reimbursements: map[string]bool{},
+		headHash:       ledgerGenesisHash,
+	}
+}
+
+// record applies the next entry of the ledger to the state.
+func (state *ledgerState) record(entry LedgerEntry) error {
+	switch entry.Type {
+	case LedgerExpenseRecorded, LedgerExpenseChanged:
+		var expense ledgerExpense
+		if err := json.Unmarshal(entry.Data, &expense); err != nil {
+			return err
+		}
+		state.expenses[expense.ExpenseID] = string(entry.Data)
+	case LedgerExpenseRemoved:
+		var expense ledgerExpense
+		if err := json.Unmarshal(entry.Data, &expense); err != nil {
+			return err
+		}
+		delete(state.expenses, expense.ExpenseID)
+	case LedgerShareSettled:
+		var settlement ledgerSettlement
+		if err := json.Unmarshal(entry.Data, &settlement); err != nil {
+			return err
+		}
+		state.settlements[settlement.ExpenseID+"/"+settlement.UserID] = string(entry.Data)
+	case LedgerReimbursementRecorded:
+		var reimbursement Reimbursement
+		if err := json.Unmarshal(entry.Data, &reimbursement); err != nil {
+			return err
+		}
+		state.reimbursements[reimbursement.ReimbursementID] = true
+	}
+	state.entries++
+	state.headHash = entry.Hash
+	return nil
+}
+
+// newLedgerEvents returns the events the ledger hasn't recorded yet, unchained: the expenses
+// in the order they were created with their settlements, then the reimbursements, then the
+// expenses removed since.
+func newLedgerEvents(state *ledgerState, expenses []FinancialExpense, reimbursements []Reimbursement) ([]LedgerEntry, error) {
+	var pending []LedgerEntry
+	appendEvent := func(eventType, occurredAt string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, LedgerEntry{Type: eventType, OccurredAt: occurredAt, Data: payload})
+		return nil
+	}
+
+	slices.SortFunc(expenses, func(a, b FinancialExpense) int {
+		if c := strings.Compare(a.CreatedAt, b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ExpenseID, b.ExpenseID)
+	})
+	current := map[string]bool{}
+	for _, expense := range expenses {
+		current[expense.ExpenseID] = true
+		data := ledgerExpense{
+			ExpenseID: expense.ExpenseID,
+			Title:     expense.Title,
+			Category:  expense.Category,
+			Amount:    expense.Amount,
+			Currency:  expense.Currency,
+			DateTime:  expense.DateTime,
+			PaidBy:    expense.PaidBy,
+		}
+		for _, payer := range expense.Payers {
+			data.Payers = append(data.Payers, ledgerPayer{UserID: payer.UserID, Amount: payer.Amount})
+		}
+		for _, participant := range expense.Participants {
+			data.Participants = append(data.Participants, ledgerParticipant{UserID: participant.UserID, Share: participant.Share, CalculatedMoney: participant.CalculatedMoney})
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		recorded, ok := state.expenses[expense.ExpenseID]
+		if !ok {
+			pending = append(pending, LedgerEntry{Type: LedgerExpenseRecorded, OccurredAt: expense.CreatedAt, Data: payload})
+		} else if recorded != string(payload) {
+			pending = append(pending, LedgerEntry{Type: LedgerExpenseChanged, Data: payload})
+		}
+
+		participants := slices.Clone(expense.Participants)
+		slices.SortFunc(participants, func(a, b Participant) int { return strings.Compare(a.UserID, b.UserID) })
+		for _, participant := range participants {
+			settlement := ledgerSettlement{ExpenseID: expense.ExpenseID, UserID: participant.UserID, SettledAmount: participant.SettledAmount, Settled: participant.Settled}
+			payload, err := json.Marshal(settlement)
+			if err != nil {
+				return nil, err
+			}
+			key := expense.ExpenseID + "/" + participant.UserID
+			recorded, ok := state.settlements[key]
+			if !ok && settlement.SettledAmount == "" && !settlement.Settled {
+				continue
+			}
+			if recorded != string(payload) {
+				pending = append(pending, LedgerEntry{Type: LedgerShareSettled, OccurredAt: participant.SettledAt, Data: payload})
+			}
+		}
+	}
+
+	slices.SortFunc(reimbursements, func(a, b Reimbursement) int {
+		if c := strings.Compare(a.CreatedAt, b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ReimbursementID, b.ReimbursementID)
+	})
+	for _, reimbursement := range reimbursements {
+		if state.reimbursements[reimbursement.ReimbursementID] {
+			continue
+		}
+		if err := appendEvent(LedgerReimbursementRecorded, reimbursement.CreatedAt, reimbursement); err != nil {
+			return nil, err
+		}
+	}
+
+	var removed []string
+	for expenseId := range state.expenses {
+		if !current[expenseId] {
+			removed = append(removed, expenseId)
+		}
+	}
+	slices.Sort(removed)
+	for _, expenseId := range removed {
+		if err := appendEvent(LedgerExpenseRemoved, "", ledgerExpense{ExpenseID: expenseId}); err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// pageLedger visits the entries of the ledger of the group a page at a time, in sequence.
+func (h *Handlers) pageLedger(ctx context.Context, groupId string, visit func([]LedgerEntry) error) error {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(ledgerTable),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		Limit: aws.Int32(ledgerPageSize),
+	}
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return err
+		}
+		var page []LedgerEntry
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return err
+		}
+		if len(page) > 0 {
+			if err := visit(page); err != nil {
+				return err
+			}
+		}
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return nil
+		}
+	}
+}
+
+// getLedgerEntry returns the entry of the ledger of the group at the sequence, nil when the
+// ledger is shorter.
+func (h *Handlers) getLedgerEntry(ctx context.Context, groupId string, sequence int) (*LedgerEntry, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(ledgerTable),
+		Key: map[string]types.AttributeValue{
+			"groupId":  &types.AttributeValueMemberS{Value: groupId},
+			"sequence": &types.AttributeValueMemberN{Value: strconv.Itoa(sequence)},
+		},
+	})
+	if err != nil || result.Item == nil {
+		return nil, err
+	}
+	var entry LedgerEntry
+	if err := attributevalue.UnmarshalMap(result.Item, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// appendLedger records the financial events of the group the ledger doesn't have yet and
+// returns how many it appended. It returns common.ErrConditionFailed when another export
+// appended the same sequence first.
+func (h *Handlers) appendLedger(ctx context.Context, groupId string, now time.Time) (int, error) {
+	state := newLedgerState()
+	err := h.pageLedger(ctx, groupId, func(page []LedgerEntry) error {
+		for _, entry := range page {
+			if err := state.record(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	expenses, err := h.queryGroupExpenses(ctx, groupId)
+	if err != nil {
+		return 0, err
+	}
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(reimbursementsTable),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var reimbursements []Reimbursement
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &reimbursements); err != nil {
+		return 0, err
+	}
+
+	pending, err := newLedgerEvents(state, expenses, reimbursements)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range pending {
+		entry.GroupID = groupId
+		entry.Sequence = state.entries + 1
+		entry.RecordedAt = now.UTC().Format(time.RFC3339)
+		entry.PreviousHash = state.headHash
+		entry.Hash, err = entry.computeHash()
+		if err != nil {
+			return 0, err
+		}
+		err = common.ConditionalPutItem(ctx, h.client, ledgerTable, entry, common.IfNotExists("sequence"))
+		if err != nil {
+			return 0, err
+		}
+		state.entries++
+		state.headHash = entry.Hash
+	}
+	if len(pending) > 0 {
+		log.Printf("Appended %d events to the ledger of group %s", len(pending), groupId)
+	}
+	return len(pending), nil
+}
+
+// verifyLedger checks that the entries chain from the genesis hash, each hashing to its hash.
+func verifyLedger(groupId string, entries []LedgerEntry) LedgerVerification {
+	verification := LedgerVerification{Valid: true, Entries: len(entries)}
+	previousHash := ledgerGenesisHash
+	for i, entry := range entries {
+		invalid := func(reason string) LedgerVerification {
+			return LedgerVerification{Entries: len(entries), FirstInvalidSequence: i + 1, Reason: reason}
+		}
+		if entry.GroupID != groupId {
+			return invalid("entry of another group")
+		}
+		if entry.Sequence != i+1 {
+			return invalid("expected sequence " + strconv.Itoa(i+1))
+		}
+		if entry.PreviousHash != previousHash {
+			return invalid("previous hash doesn't match the entry before")
+		}
+		hash, err := entry.computeHash()
+		if err != nil || hash != entry.Hash {
+			return invalid("hash doesn't match the entry")
+		}
+		previousHash = entry.Hash
+		verification.HeadHash = entry.Hash
+	}
+	return verification
+}
+
+// GetLedgerHandler exports the ledger of the group, one JSON entry per line, after appending
+// the financial events since the last export. Each entry holds the hash of the one before,
+// so editing or dropping an entry of an export breaks the chain. The ledger is read a page at
+// a time and streamed to the offload bucket once too large to be returned, so its size isn't
+// bound by the memory of the function.
+func (h *Handlers) GetLedgerHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	_, err = h.appendLedger(ctx, groupId, time.Now())
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Ledger was appended concurrently")
+	}
+	if err != nil {
+		log.Printf("Error appending the ledger: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	writer := offload.NewWriter(ctx, map[string]string{
+		"Content-Type":        "application/x-ndjson",
+		"Content-Disposition": fmt.Sprintf("attachment; filename=\"ledger-%s.jsonl\"", groupId),
+	})
+	encoder := json.NewEncoder(writer)
+	err = h.pageLedger(ctx, groupId, func(page []LedgerEntry) error {
+		for _, entry := range page {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error exporting the ledger: %v", err)
+		writer.Abort()
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return writer.Response()
+}
+
+// VerifyLedgerHandler verifies a ledger exported by GetLedgerHandler, sent as the body, and
+// tells whether its head is still the entry recorded at its sequence.
+func (h *Handlers) VerifyLedgerHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(strings.NewReader(request.Body))
+	scanner.Buffer(nil, len(request.Body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry LedgerEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return common.CreateErrorResponse(400, fmt.Sprintf("Invalid ledger entry on line %d", len(entries)+1))
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return common.CreateErrorResponse(400, "The ledger is empty")
+	}
+
+	verification := verifyLedger(groupId, entries)
+	if verification.Valid {
+		recorded, err := h.getLedgerEntry(ctx, groupId, len(entries))
+		if err != nil {
+			log.Printf("Error getting the ledger entry from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		verification.Recorded = recorded != nil && recorded.Hash == verification.HeadHash
+	}
+
+	payload, err := json.Marshal(verification)
+	if err != nil {
+		log.Println("Error marshalling ledger verification:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,139 @@
+package financial
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportLedger(t *testing.T, h *Handlers, userId string) (int, string) {
+	response, err := h.GetLedgerHandler(testutil.NewRequest("GET", "").
+		WithClaims(userId, userId).
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	return response.StatusCode, response.Body
+}
+
+func verifyLedgerExport(t *testing.T, h *Handlers, ledger string) LedgerVerification {
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		Build()
+	request.Body = ledger
+	response, err := h.VerifyLedgerHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var verification LedgerVerification
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &verification))
+	return verification
+}
+
+func ledgerTypes(t *testing.T, ledger string) []string {
+	var entryTypes []string
+	for _, line := range strings.Split(strings.TrimSpace(ledger), "\n") {
+		var entry LedgerEntry
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entryTypes = append(entryTypes, entry.Type)
+	}
+	return entryTypes
+}
+
+func TestLedgerHandlers(t *testing.T) {
+	h, fake := newSettlementsFake(t)
+	notifications.DynamoDbClient = fake
+
+	statusCode, _ := exportLedger(t, h, "user-3")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, first := exportLedger(t, h, "user-1")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, []string{LedgerExpenseRecorded, LedgerExpenseRecorded}, ledgerTypes(t, first))
+
+	// Exporting again appends nothing
+	_, again := exportLedger(t, h, "user-1")
+	assert.Equal(t, first, again)
+
+	// The settlements and removals since are appended after the entries already exported
+	statusCode, _ = reimburse(t, h, "user-2", ReimbursementRequest{ToUserID: "user-1", ExpenseIDs: []string{"expense-1"}})
+	assert.Equal(t, http.StatusCreated, statusCode)
+	_, err := fake.DeleteItem(t.Context(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("splitter-expenses"),
+		Key: map[string]types.AttributeValue{
+			"groupId":   &types.AttributeValueMemberS{Value: "test-group-id"},
+			"expenseId": &types.AttributeValueMemberS{Value: "expense-2"},
+		},
+	})
+	assert.NoError(t, err)
+	_, second := exportLedger(t, h, "user-1")
+	assert.True(t, strings.HasPrefix(second, first))
+	assert.Equal(t, []string{
+		LedgerExpenseRecorded, LedgerExpenseRecorded,
+		LedgerShareSettled, LedgerReimbursementRecorded, LedgerExpenseRemoved,
+	}, ledgerTypes(t, second))
+
+	// Both exports verify and are what the group recorded
+	verification := verifyLedgerExport(t, h, first)
+	assert.True(t, verification.Valid)
+	assert.True(t, verification.Recorded)
+	assert.Equal(t, 2, verification.Entries)
+	verification = verifyLedgerExport(t, h, second)
+	assert.True(t, verification.Valid)
+	assert.Equal(t, 5, verification.Entries)
+
+	// Editing an entry breaks the chain at it
+	tampered := strings.Replace(second, `"amount":30,`, `"amount":3,`, 1)
+	verification = verifyLedgerExport(t, h, tampered)
+	assert.False(t, verification.Valid)
+	assert.Equal(t, 2, verification.FirstInvalidSequence)
+
+	// So does dropping one
+	lines := strings.SplitAfter(second, "\n")
+	verification = verifyLedgerExport(t, h, lines[0]+strings.Join(lines[2:], ""))
+	assert.False(t, verification.Valid)
+	assert.Equal(t, 2, verification.FirstInvalidSequence)
+}
+
+func TestLedgerPages(t *testing.T) {
+	h, fake := newSettlementsFake(t)
+	for i := 3; i <= ledgerPageSize+500; i++ {
+		_, err := fake.PutItem(t.Context(), &dynamodb.PutItemInput{
+			TableName: aws.String("splitter-expenses"),
+			Item: map[string]types.AttributeValue{
+				"groupId":   &types.AttributeValueMemberS{Value: "test-group-id"},
+				"expenseId": &types.AttributeValueMemberS{Value: fmt.Sprintf("expense-%d", i)},
+				"createdAt": &types.AttributeValueMemberS{Value: fmt.Sprintf("2024-02-01T00:00:00.%06dZ", i)},
+				"amount":    &types.AttributeValueMemberN{Value: "10"},
+				"paidBy":    &types.AttributeValueMemberS{Value: "user-1"},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	// The ledger spans several pages, exported in sequence
+	statusCode, ledger := exportLedger(t, h, "user-1")
+	assert.Equal(t, http.StatusOK, statusCode)
+	lines := strings.Split(strings.TrimSpace(ledger), "\n")
+	assert.Len(t, lines, ledgerPageSize+500)
+	for i, line := range lines {
+		var entry LedgerEntry
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, i+1, entry.Sequence)
+	}
+	verification := verifyLedgerExport(t, h, ledger)
+	assert.True(t, verification.Valid)
+	assert.True(t, verification.Recorded)
+
+	// Exporting again reads the ledger a page at a time and appends nothing
+	_, again := exportLedger(t, h, "user-1")
+	assert.Equal(t, ledger, again)
+}
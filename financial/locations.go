@@ -0,0 +1,135 @@
+package financial
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// maxPlaceName is the maximum length of the place name of an expense, in characters.
+	maxPlaceName = 200
+	// defaultNearRadius and maxNearRadius bound the distance of the near filter, in kilometres.
+	defaultNearRadius = 10.0
+	maxNearRadius     = 1000.0
+	// earthRadius is the mean radius of the Earth, in kilometres.
+	earthRadius = 6371.0
+)
+
+var errInvalidNear = errors.New("Invalid near, expected latitude,longitude[,radius in km]")
+
+// Location is where an expense was paid, so the expenses of a trip can be shown on a map.
+// It has the coordinates, the name of the place, or both.
+type Location struct {
+	Latitude  *float64 `json:"latitude,omitempty" dynamodbav:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty" dynamodbav:"longitude,omitempty"`
+	PlaceName string   `json:"placeName,omitempty" dynamodbav:"placeName,omitempty"`
+}
+
+// hasCoordinates reports whether the location is on the map.
+func (l *Location) hasCoordinates() bool {
+	return l != nil && l.Latitude != nil && l.Longitude != nil
+}
+
+// validateLocation trims the place name of the location and checks it, returning a message
+// when it is invalid. An empty location is dropped.
+func validateLocation(expense *FinancialExpense) string {
+	location := expense.Location
+	if location == nil {
+		return ""
+	}
+	location.PlaceName = strings.TrimSpace(location.PlaceName)
+	if utf8.RuneCountInString(location.PlaceName) > maxPlaceName {
+		return "Place name is too long"
+	}
+	if (location.Latitude == nil) != (location.Longitude == nil) {
+		return "Location needs both a latitude and a longitude"
+	}
+	if location.Latitude == nil && location.PlaceName == "" {
+		expense.Location = nil
+		return ""
+	}
+	if location.Latitude != nil && !validCoordinates(*location.Latitude, *location.Longitude) {
+		return "Invalid location coordinates"
+	}
+	return ""
+}
+
+func validCoordinates(latitude, longitude float64) bool {
+	return latitude >= -90 && latitude <= 90 && longitude >= -180 && longitude <= 180
+}
+
+// nearFilter keeps the expenses paid within the radius of a point.
+type nearFilter struct {
+	Latitude  float64
+	Longitude float64
+	Radius    float64
+}
+
+// parseNear parses the optional near query parameter, "latitude,longitude" or
+// "latitude,longitude,radius" with the radius in kilometres, 10 by default.
+func parseNear(request events.APIGatewayProxyRequest) (*nearFilter, error) {
+	value := request.QueryStringParameters["near"]
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, errInvalidNear
+	}
+	numbers := make([]float64, len(parts))
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, errInvalidNear
+		}
+		numbers[i] = number
+	}
+
+	near := &nearFilter{Latitude: numbers[0], Longitude: numbers[1], Radius: defaultNearRadius}
+	if len(numbers) == 3 {
+		near.Radius = numbers[2]
+	}
+	if !validCoordinates(near.Latitude, near.Longitude) || near.Radius <= 0 || near.Radius > maxNearRadius {
+		return nil, errInvalidNear
+	}
+	return near, nil
+}
+
+// matches reports whether the expense was paid within the radius. The expenses without
+// coordinates never are.
+func (n *nearFilter) matches(expense FinancialExpense) bool {
+	if !expense.Location.hasCoordinates() {
+		return false
+	}
+	return distance(n.Latitude, n.Longitude, *expense.Location.Latitude, *expense.Location.Longitude) <= n.Radius
+}
+
+// distance returns the great-circle distance between two points, in kilometres.
+func distance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	deltaLatitude := toRadians(latitude2 - latitude1)
+	deltaLongitude := toRadians(longitude2 - longitude1)
+	a := math.Sin(deltaLatitude/2)*math.Sin(deltaLatitude/2) +
+		math.Cos(toRadians(latitude1))*math.Cos(toRadians(latitude2))*math.Sin(deltaLongitude/2)*math.Sin(deltaLongitude/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// locationColumns returns the place name and coordinates of the expense written to the
+// sheets, empty when unknown.
+func locationColumns(location *Location) []string {
+	columns := []string{"", "", ""}
+	if location == nil {
+		return columns
+	}
+	columns[0] = location.PlaceName
+	if location.hasCoordinates() {
+		columns[1] = strconv.FormatFloat(*location.Latitude, 'f', -1, 64)
+		columns[2] = strconv.FormatFloat(*location.Longitude, 'f', -1, 64)
+	}
+	return columns
+}
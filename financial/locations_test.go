@@ -0,0 +1,89 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpenseLocations(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "trip"},
+			{"userId": "user-2", "groupId": "trip"},
+		},
+		"splitter-group-settings": {{"groupId": "trip", "defaultCurrency": "EUR"}},
+	})
+	assert.NoError(t, err)
+	notifications.DynamoDbClient = fake
+	h := NewHandlers(fake)
+
+	post := func(title string, location map[string]interface{}) (int, string) {
+		response, err := h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "trip").
+			WithJSONBody(t, map[string]interface{}{
+				"title": title, "amount": "20", "currency": "EUR", "dateTime": "2024-06-01T12:00:00Z", "paidBy": "user-1",
+				"location": location,
+			}).
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode, response.Body
+	}
+
+	for _, location := range []map[string]interface{}{
+		{"latitude": 91, "longitude": 2.35},
+		{"latitude": 48.85},
+		{"placeName": strings.Repeat("a", maxPlaceName+1)},
+	} {
+		statusCode, _ := post("Invalid", location)
+		assert.Equal(t, http.StatusBadRequest, statusCode, location)
+	}
+
+	statusCode, body := post("Louvre", map[string]interface{}{"latitude": 48.8606, "longitude": 2.3376, "placeName": " Louvre "})
+	assert.Equal(t, http.StatusCreated, statusCode)
+	var expense FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(body), &expense))
+	if assert.NotNil(t, expense.Location) {
+		assert.Equal(t, "Louvre", expense.Location.PlaceName)
+	}
+	statusCode, _ = post("Versailles", map[string]interface{}{"latitude": 48.8049, "longitude": 2.1204})
+	assert.Equal(t, http.StatusCreated, statusCode)
+	statusCode, _ = post("Souvenirs", map[string]interface{}{"placeName": "Somewhere in Paris"})
+	assert.Equal(t, http.StatusCreated, statusCode)
+
+	list := func(near string) (int, []string) {
+		response, err := h.GetGroupExpensesHandler(testutil.NewRequest("GET", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "trip").
+			WithQueryParam("near", near).
+			WithQueryParam("fields", "title").
+			Build())
+		assert.NoError(t, err)
+		var expenses []FinancialExpense
+		json.Unmarshal([]byte(response.Body), &expenses)
+		var titles []string
+		for _, expense := range expenses {
+			titles = append(titles, expense.Title)
+		}
+		return response.StatusCode, titles
+	}
+
+	// Versailles is about 17 km from the centre of Paris, the expenses without coordinates are
+	// never near
+	statusCode, titles := list("48.8566,2.3522")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, []string{"Louvre"}, titles)
+	_, titles = list("48.8566,2.3522,25")
+	assert.ElementsMatch(t, []string{"Louvre", "Versailles"}, titles)
+
+	for _, near := range []string{"48.8566", "48.8566,2.3522,0", "95,2.3522", "48.8566,east"} {
+		statusCode, _ := list(near)
+		assert.Equal(t, http.StatusBadRequest, statusCode, near)
+	}
+}
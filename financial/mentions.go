@@ -0,0 +1,109 @@
+package financial
+
+import (
+	"context"
+	"log"
+	"maps"
+	"regexp"
+	"strings"
+)
+
+// The types of the notifications sent to the mentioned members.
+const (
+	NotificationMentionedInChat    = "MENTIONED_IN_CHAT"
+	NotificationMentionedInExpense = "MENTIONED_IN_EXPENSE"
+)
+
+// mentionPattern matches the @username mentions of a text. The @ must start a word, so
+// email addresses aren't taken for mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// Mention is a member of the group mentioned in a chat message or expense note.
+type Mention struct {
+	UserID   string `json:"userId" dynamodbav:"userId"`
+	Username string `json:"username" dynamodbav:"username"`
+}
+
+// parseMentions returns the usernames mentioned in the text, lowercased, once each.
+func parseMentions(text string) []string {
+	seen := map[string]bool{}
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		// A mention ending a sentence doesn't include the period
+		username := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if username == "" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// mentionsOf returns the members of the group mentioned in the text, in order. The mentions
+// of users outside the group and of the author are left out.
+func (h *Handlers) mentionsOf(ctx context.Context, groupId, authorId, text string) ([]Mention, error) {
+	usernames := parseMentions(text)
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+
+	memberIds, err := h.getGroupMemberIds(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	userIds := make(map[string]struct{}, len(memberIds))
+	for _, userId := range memberIds {
+		userIds[userId] = struct{}{}
+	}
+	userMap, err := h.getUsersByIds(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+	byUsername := make(map[string]User, len(userMap))
+	for _, user := range userMap {
+		byUsername[strings.ToLower(user.Username)] = user
+	}
+
+	var mentions []Mention
+	for _, username := range usernames {
+		user, ok := byUsername[username]
+		if !ok || user.UserID == authorId {
+			continue
+		}
+		mentions = append(mentions, Mention{UserID: user.UserID, Username: user.Username})
+	}
+	return mentions, nil
+}
+
+// notifyMentioned notifies the mentioned members, with the group and author added to the
+// data. The text is stored already, so the failures are only logged.
+func (h *Handlers) notifyMentioned(ctx context.Context, groupId, authorId string, mentions []Mention, notificationType string, data map[string]string) {
+	if len(mentions) == 0 {
+		return
+	}
+
+	data = maps.Clone(data)
+	data["groupId"] = groupId
+	member, err := h.getGroupMember(ctx, authorId, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+	} else if member != nil {
+		data["groupName"] = member.GroupName
+	}
+	userMap, err := h.getUsersByIds(ctx, map[string]struct{}{authorId: {}})
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+	}
+	author := userMap.User(authorId)
+	data["author"] = author.ShowableName
+	if data["author"] == "" {
+		data["author"] = author.Username
+	}
+
+	userIds := make([]string, len(mentions))
+	for i, mention := range mentions {
+		userIds[i] = mention.UserID
+	}
+	notifyMembers(ctx, userIds, notificationType, data)
+}
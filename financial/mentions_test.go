@@ -0,0 +1,66 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMentions(t *testing.T) {
+	assert.Equal(t, []string{"bob", "carol.s"}, parseMentions("@Bob can you pay? cc @carol.s. and @bob again"))
+	assert.Empty(t, parseMentions("mail me at alice@example.com, or @@bob"))
+}
+
+func TestMentions(t *testing.T) {
+	// Set up the fake DynamoDB with two housemates and an outsider
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice", "showableName": "Alice"},
+			{"userId": "user-2", "username": "bob", "showableName": "Bob"},
+			{"userId": "user-3", "username": "carol", "showableName": "Carol"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	notifications.DynamoDbClient = fake
+
+	// Only the members other than the author are mentioned in the chat
+	response, err := h.PostGroupChatHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, map[string]string{"content": "@bob @carol @alice the rent is due"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var message GroupChatMessage
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &message))
+	assert.Equal(t, []Mention{{UserID: "user-2", Username: "bob"}}, message.Mentions)
+	assert.Equal(t, []string{NotificationMentionedInChat}, notificationsOf(t, fake, "user-2"))
+	assert.Empty(t, notificationsOf(t, fake, "user-1"))
+	assert.Empty(t, notificationsOf(t, fake, "user-3"))
+
+	// The notes of an expense mention the members too
+	response, err = h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, FinancialExpense{
+			Title: "Groceries", Amount: "30", DateTime: "2024-01-02T15:04:05Z", PaidBy: "user-2",
+			Participants: []Participant{{UserID: "user-1", Share: "50"}, {UserID: "user-2", Share: "50"}},
+			Notes:        "@alice I kept the receipt",
+		}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var expense FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expense))
+	assert.Equal(t, []Mention{{UserID: "user-1", Username: "alice"}}, expense.Mentions)
+	assert.Equal(t, []string{NotificationMentionedInExpense}, notificationsOf(t, fake, "user-1"))
+}
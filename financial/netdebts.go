@@ -0,0 +1,363 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/fx"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxParallelGroups bounds how many groups have their expenses queried at the same time.
+const maxParallelGroups = 4
+
+// debtAttributes are the attributes of the expenses the debts are computed from. Reading only
+// them leaves out the notes, decrypted for nothing, and the items of the receipts.
+var debtAttributes = []string{"amount", "currency", "paidBy", "payers", "participants", "dispute"}
+
+// GroupDebt is what another member owes the user in one group; negative when the user owes them.
+type GroupDebt struct {
+	GroupID   string      `json:"groupId"`
+	GroupName string      `json:"groupName"`
+	Amount    json.Number `json:"amount"`
+}
+
+// NetDebt is what another member owes the user in a currency across all the groups they
+// share, netted; negative when the user owes them. Display is the amount in the display
+// currency asked for, if any.
+type NetDebt struct {
+	UserID   string         `json:"userId"`
+	User     User           `json:"user"`
+	Currency string         `json:"currency"`
+	Amount   json.Number    `json:"amount"`
+	Display  *fx.Conversion `json:"display,omitempty"`
+	Groups   []GroupDebt    `json:"groups"`
+}
+
+// GroupSettlement is a settlement to record in a group, with the settlements endpoint of the group.
+type GroupSettlement struct {
+	GroupID string `json:"groupId"`
+	MemberSettlement
+}
+
+// SettleUpSuggestion is a single payment settling the debts between two members in a
+// currency across their groups, with the settlements to record in each group once paid.
+type SettleUpSuggestion struct {
+	FromUserID  string            `json:"fromUserId"`
+	ToUserID    string            `json:"toUserId"`
+	Currency    string            `json:"currency"`
+	Amount      json.Number       `json:"amount"`
+	Settlements []GroupSettlement `json:"settlements"`
+}
+
+// NetDebts struct for the net debts response
+type NetDebts struct {
+	UserID      string               `json:"userId"`
+	Debts       []NetDebt            `json:"debts"`
+	Suggestions []SettleUpSuggestion `json:"suggestions,omitempty"`
+}
+
+// debtKey identifies the debts between the user and another member in a currency.
+type debtKey struct{ userId, currency string }
+
+// groupDebts returns what the other members owe the user on the expenses of a group, per
+// member and currency; negative amounts are owed by the user. Expenses without a currency
+// are in the default currency of the group, disputed expenses are left out until resolved.
+func groupDebts(expenses []FinancialExpense, userId, defaultCurrency string) map[debtKey]*big.Rat {
+	debts := map[debtKey]*big.Rat{}
+	add := func(other, currency string, amount *big.Rat) {
+		key := debtKey{other, currency}
+		if debts[key] == nil {
+			debts[key] = new(big.Rat)
+		}
+		debts[key].Add(debts[key], amount)
+	}
+
+	for _, expense := range expenses {
+		if isDisputed(expense) {
+			continue
+		}
+		currency := expense.Currency
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		for creditor := range credits(expense) {
+			for _, participant := range expense.Participants {
+				if participant.UserID != userId && creditor != userId {
+					continue
+				}
+				owed := owedTo(expense, participant, creditor)
+				if owed.Sign() == 0 {
+					continue
+				}
+				if creditor == userId {
+					add(participant.UserID, currency, owed)
+				} else {
+					add(creditor, currency, owed.Neg(owed))
+				}
+			}
+		}
+	}
+	return debts
+}
+
+// listUserGroups returns the memberships of the user, following the pages of the result and
+// leaving out the groups waiting to be purged.
+func (h *Handlers) listUserGroups(ctx context.Context, userId string) ([]GroupMember, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-group-members"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	}
+
+	var members []GroupMember
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var items []GroupMember
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Status != GroupDeletedPending {
+				members = append(members, item)
+			}
+		}
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return members, nil
+		}
+	}
+}
+
+// netDebts nets the debts of every group of the user, per member and currency. The expenses
+// are read whole, or only their attributes given.
+func (h *Handlers) netDebts(ctx context.Context, userId string, attributes []string) ([]NetDebt, error) {
+	groups, err := h.listUserGroups(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	perGroup := make([]map[debtKey]*big.Rat, len(groups))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelGroups)
+	for i, group := range groups {
+		g.Go(func() error {
+			settings, err := h.getGroupSettings(ctx, group.GroupID)
+			if err != nil {
+				return err
+			}
+			queryInput := &dynamodb.QueryInput{
+				TableName:              aws.String("splitter-expenses"),
+				KeyConditionExpression: aws.String("groupId = :groupId"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":groupId": &types.AttributeValueMemberS{Value: group.GroupID},
+				},
+			}
+			if attributes != nil {
+				queryInput.ProjectionExpression, queryInput.ExpressionAttributeNames = common.ProjectionExpression(attributes)
+			}
+			expenses, err := h.queryExpenses(ctx, queryInput)
+			if err != nil {
+				return err
+			}
+			perGroup[i] = groupDebts(expenses, userId, settings.DefaultCurrency)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// Net the groups, keeping the amount of each group
+	net := map[debtKey]*NetDebt{}
+	totals := map[debtKey]*big.Rat{}
+	for i, debts := range perGroup {
+		for key, amount := range debts {
+			if amount.Sign() == 0 {
+				continue
+			}
+			if net[key] == nil {
+				net[key] = &NetDebt{UserID: key.userId, Currency: key.currency}
+				totals[key] = new(big.Rat)
+			}
+			net[key].Groups = append(net[key].Groups, GroupDebt{
+				GroupID:   groups[i].GroupID,
+				GroupName: groups[i].GroupName,
+				Amount:    json.Number(amount.FloatString(2)),
+			})
+			totals[key].Add(totals[key], amount)
+		}
+	}
+
+	result := make([]NetDebt, 0, len(net))
+	for key, debt := range net {
+		debt.Amount = json.Number(totals[key].FloatString(2))
+		sort.Slice(debt.Groups, func(i, j int) bool { return debt.Groups[i].GroupID < debt.Groups[j].GroupID })
+		result = append(result, *debt)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].UserID != result[j].UserID {
+			return result[i].UserID < result[j].UserID
+		}
+		return result[i].Currency < result[j].Currency
+	})
+	return result, nil
+}
+
+// convertNetDebts sets the amount of each net debt in the display currency, at the rates of
+// today, fetching each rate once.
+func convertNetDebts(ctx context.Context, debts []NetDebt, displayCurrency string) error {
+	today := time.Now().UTC().Format(time.DateOnly)
+	rates := map[string]fx.Rate{}
+	for i, debt := range debts {
+		rate, ok := rates[debt.Currency]
+		if !ok {
+			var err error
+			rate, err = fx.HistoricalRate(ctx, debt.Currency, displayCurrency, today)
+			if err != nil {
+				return fmt.Errorf("converting %s to %s: %w", debt.Currency, displayCurrency, err)
+			}
+			rates[debt.Currency] = rate
+		}
+
+		conversion, err := fx.Convert(debt.Amount, displayCurrency, rate)
+		if err != nil {
+			return err
+		}
+		debts[i].Display = &conversion
+	}
+	return nil
+}
+
+// suggestSettleUp suggests a single payment per member and currency: the net amount, paid
+// by whoever owes it, recorded as a settlement in each group in the direction of its debt.
+func suggestSettleUp(userId string, debts []NetDebt) []SettleUpSuggestion {
+	var suggestions []SettleUpSuggestion
+	for _, debt := range debts {
+		amount, ok := new(big.Rat).SetString(string(debt.Amount))
+		if !ok || amount.Sign() == 0 {
+			continue
+		}
+		suggestion := SettleUpSuggestion{FromUserID: debt.UserID, ToUserID: userId, Currency: debt.Currency}
+		if amount.Sign() < 0 {
+			suggestion.FromUserID, suggestion.ToUserID = userId, debt.UserID
+		}
+		suggestion.Amount = json.Number(amount.Abs(amount).FloatString(2))
+
+		for _, group := range debt.Groups {
+			owed, ok := new(big.Rat).SetString(string(group.Amount))
+			if !ok {
+				continue
+			}
+			settlement := MemberSettlement{FromUserID: debt.UserID, ToUserID: userId}
+			if owed.Sign() < 0 {
+				settlement.FromUserID, settlement.ToUserID = userId, debt.UserID
+			}
+			settlement.Amount = json.Number(owed.Abs(owed).FloatString(2))
+			suggestion.Settlements = append(suggestion.Settlements, GroupSettlement{GroupID: group.GroupID, MemberSettlement: settlement})
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions
+}
+
+func (h *Handlers) GetNetDebtsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.serveNetDebts(request, nil)
+}
+
+// GetProjectedNetDebtsHandler serves the net debts reading only the debtAttributes of the
+// expenses. It is the candidate of the net-debts shadow experiment, checked against
+// GetNetDebtsHandler before replacing it.
+func (h *Handlers) GetProjectedNetDebtsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return h.serveNetDebts(request, debtAttributes)
+}
+
+// serveNetDebts serves the net debts of the user, reading the expenses whole or only their
+// attributes given.
+func (h *Handlers) serveNetDebts(request events.APIGatewayProxyRequest, attributes []string) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Parse the optional currency the amounts are displayed in
+	displayCurrency, err := parseDisplayCurrency(request)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	debts, err := h.netDebts(context.TODO(), claims.Sub, attributes)
+	if err != nil {
+		log.Printf("Error computing net debts: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Convert the net amounts into the display currency
+	if displayCurrency != "" {
+		err = convertNetDebts(context.TODO(), debts, displayCurrency)
+		if errors.Is(err, fx.ErrRateNotFound) {
+			return common.CreateErrorResponse(422, "Exchange rate not available")
+		}
+		if err != nil {
+			log.Printf("Error converting net debts: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+
+	// Populate the user details of the other members
+	userIds := make(map[string]struct{}, len(debts))
+	for _, debt := range debts {
+		userIds[debt.UserID] = struct{}{}
+	}
+	userMap, err := h.getUsersForViewer(context.TODO(), claims.Sub, userIds)
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	for i, debt := range debts {
+		debts[i].User = userMap.User(debt.UserID)
+	}
+
+	response := NetDebts{UserID: claims.Sub, Debts: debts}
+	if request.QueryStringParameters["suggest"] == "true" {
+		response.Suggestions = suggestSettleUp(claims.Sub, debts)
+	}
+
+	log.Printf("Computed net debts of user %s with %d members", claims.Sub, len(debts))
+
+	// Marshal the net debts into JSON for the payload
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshalling net debts:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
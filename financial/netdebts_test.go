@@ -0,0 +1,176 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/fx"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNetDebtsHandler(t *testing.T) {
+	// Set up the fake DynamoDB: alice and bob share a house and a trip
+	halves := func(owed string) []map[string]interface{} {
+		return []map[string]interface{}{
+			{"userId": "alice", "share": "50.00", "calculatedMoney": owed},
+			{"userId": "bob", "share": "50.00", "calculatedMoney": owed},
+		}
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "alice", "groupId": "house", "groupName": "House"},
+			{"userId": "alice", "groupId": "trip", "groupName": "Trip"},
+			{"userId": "alice", "groupId": "old", "groupName": "Old", "status": GroupDeletedPending},
+			{"userId": "bob", "groupId": "house"},
+			{"userId": "bob", "groupId": "trip"},
+		},
+		"splitter-group-settings": {{"groupId": "trip", "defaultCurrency": "EUR"}},
+		"splitter-expenses": {
+			// Bob owes alice 30 for the rent, alice owes bob 10 for the groceries, already settled in part
+			{"groupId": "house", "expenseId": "rent", "amount": "60", "currency": "USD", "paidBy": "alice", "participants": halves("30.00")},
+			{"groupId": "house", "expenseId": "groceries", "amount": "30", "currency": "USD", "paidBy": "bob", "participants": []map[string]interface{}{
+				{"userId": "alice", "share": "50.00", "calculatedMoney": "15.00", "settledAmount": "5.00"},
+				{"userId": "bob", "share": "50.00", "calculatedMoney": "15.00"},
+			}},
+			// Alice owes bob 40 for the hotel, in the default currency of the trip
+			{"groupId": "trip", "expenseId": "hotel", "amount": "80", "paidBy": "bob", "participants": halves("40.00")},
+			{"groupId": "trip", "expenseId": "taxi", "amount": "20", "currency": "USD", "paidBy": "bob", "participants": halves("10.00")},
+			{"groupId": "old", "expenseId": "ignored", "amount": "100", "currency": "USD", "paidBy": "bob", "participants": halves("50.00")},
+		},
+		"vassistant-users": {{"userId": "bob", "username": "bob", "showableName": "Bob"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Create a sample request
+	request := testutil.NewRequest("GET", "").
+		WithClaims("alice", "alice").
+		WithQueryParam("suggest", "true").
+		Build()
+
+	// Call the handler
+	response, err := h.GetNetDebtsHandler(request)
+
+	// Check the response
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var result NetDebts
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+
+	assert.Equal(t, []NetDebt{
+		{UserID: "bob", User: User{UserID: "bob", Username: "bob", ShowableName: "Bob"}, Currency: "EUR", Amount: "-40.00", Groups: []GroupDebt{
+			{GroupID: "trip", GroupName: "Trip", Amount: "-40.00"},
+		}},
+		{UserID: "bob", User: User{UserID: "bob", Username: "bob", ShowableName: "Bob"}, Currency: "USD", Amount: "10.00", Groups: []GroupDebt{
+			{GroupID: "house", GroupName: "House", Amount: "20.00"},
+			{GroupID: "trip", GroupName: "Trip", Amount: "-10.00"},
+		}},
+	}, result.Debts)
+
+	// A single payment per currency, recorded in each group
+	assert.Len(t, result.Suggestions, 2)
+	usd := result.Suggestions[1]
+	assert.Equal(t, "bob", usd.FromUserID)
+	assert.Equal(t, "alice", usd.ToUserID)
+	assert.Equal(t, json.Number("10.00"), usd.Amount)
+	assert.Equal(t, []GroupSettlement{
+		{GroupID: "house", MemberSettlement: MemberSettlement{FromUserID: "bob", ToUserID: "alice", Amount: "20.00"}},
+		{GroupID: "trip", MemberSettlement: MemberSettlement{FromUserID: "alice", ToUserID: "bob", Amount: "10.00"}},
+	}, usd.Settlements)
+}
+
+func TestGetNetDebtsHandlerDisplayCurrency(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "alice", "groupId": "trip", "groupName": "Trip"},
+			{"userId": "bob", "groupId": "trip"},
+		},
+		"splitter-expenses": {
+			{"groupId": "trip", "expenseId": "hotel", "amount": "80", "currency": "EUR", "paidBy": "bob", "participants": []map[string]interface{}{
+				{"userId": "alice", "share": "50.00", "calculatedMoney": "40.00"},
+				{"userId": "bob", "share": "50.00", "calculatedMoney": "40.00"},
+			}},
+		},
+		"fx-rates": {
+			{"pair": "EUR-USD", "date": "2024-01-01", "rate": 1.1},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	fx.DynamoDbClient = fake
+
+	get := func(displayCurrency string) events.APIGatewayProxyResponse {
+		response, err := h.GetNetDebtsHandler(testutil.NewRequest("GET", "").
+			WithClaims("alice", "alice").
+			WithQueryParam("displayCurrency", displayCurrency).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The net amounts are converted at the latest rate, next to the amounts in their currency
+	response := get("USD")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var result NetDebts
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+	if assert.Len(t, result.Debts, 1) {
+		assert.Equal(t, json.Number("-40.00"), result.Debts[0].Amount)
+		if assert.NotNil(t, result.Debts[0].Display) {
+			assert.Equal(t, "USD", result.Debts[0].Display.Currency)
+			assert.Equal(t, json.Number("-44.00"), result.Debts[0].Display.Amount)
+		}
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get("dollars").StatusCode)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("JPY").StatusCode)
+}
+
+func TestGetProjectedNetDebtsHandler(t *testing.T) {
+	// Set up the fake DynamoDB with expenses using every attribute the debts depend on
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "alice", "groupId": "house", "groupName": "House"},
+			{"userId": "bob", "groupId": "house"},
+			{"userId": "carol", "groupId": "house"},
+		},
+		"splitter-group-settings": {{"groupId": "house", "defaultCurrency": "EUR"}},
+		"splitter-expenses": {
+			{"groupId": "house", "expenseId": "rent", "amount": "90", "paidBy": "alice", "notes": "March", "participants": []map[string]interface{}{
+				{"userId": "alice", "calculatedMoney": "30.00"},
+				{"userId": "bob", "calculatedMoney": "30.00", "settledAmount": "10.00"},
+				{"userId": "carol", "calculatedMoney": "30.00"},
+			}},
+			{"groupId": "house", "expenseId": "dinner", "amount": "60", "currency": "USD", "paidBy": "bob",
+				"payers": []map[string]interface{}{{"userId": "bob", "amount": "30.00"}, {"userId": "carol", "amount": "30.00"}},
+				"items":  []map[string]interface{}{{"name": "Pizza", "amount": "60.00"}},
+				"participants": []map[string]interface{}{
+					{"userId": "alice", "calculatedMoney": "20.00"},
+					{"userId": "bob", "calculatedMoney": "20.00"},
+					{"userId": "carol", "calculatedMoney": "20.00"},
+				}},
+			{"groupId": "house", "expenseId": "taxi", "amount": "20", "paidBy": "carol", "dispute": map[string]interface{}{"status": DisputeOpen},
+				"participants": []map[string]interface{}{{"userId": "alice", "calculatedMoney": "20.00"}}},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// The candidate reading the debt attributes only answers like the handler
+	request := testutil.NewRequest("GET", "").
+		WithClaims("alice", "alice").
+		WithQueryParam("suggest", "true").
+		Build()
+	expected, err := h.GetNetDebtsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, expected.StatusCode)
+	actual, err := h.GetProjectedNetDebtsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.StatusCode, actual.StatusCode)
+	assert.JSONEq(t, expected.Body, actual.Body)
+	var result NetDebts
+	assert.NoError(t, json.Unmarshal([]byte(actual.Body), &result))
+	assert.Len(t, result.Debts, 4)
+}
@@ -0,0 +1,226 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxNickname is the maximum length of a nickname, in characters.
+const maxNickname = 40
+
+// Nickname struct for the splitter-nicknames table, the name a user gave another member of
+// their groups, e.g. "Mom". Nicknames are private to the user who set them: they are shown to
+// that user only, and leave the profile of the member unchanged.
+type Nickname struct {
+	UserID    string `json:"userId" dynamodbav:"userId"`
+	MemberID  string `json:"memberId" dynamodbav:"memberId"`
+	Nickname  string `json:"nickname" dynamodbav:"nickname"`
+	UpdatedAt string `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+// viewerOf returns the user making the request, whose nicknames are shown, or "" when the
+// request has no valid claims.
+func viewerOf(request events.APIGatewayProxyRequest) string {
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return ""
+	}
+	return claims.Sub
+}
+
+// getNicknames returns the nicknames the user gave the other members, keyed by member ID.
+func (h *Handlers) getNicknames(ctx context.Context, userId string) (map[string]string, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-nicknames"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	}
+
+	nicknames := map[string]string{}
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var page []Nickname
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, nickname := range page {
+			nicknames[nickname.MemberID] = nickname.Nickname
+		}
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+	return nicknames, nil
+}
+
+// getUsersForViewer fetches the details of the given users like getUsersByIds, with the
+// nicknames the viewer gave them. The nicknames are cosmetic, so failing to read them only
+// leaves them out.
+func (h *Handlers) getUsersForViewer(ctx context.Context, viewerId string, userIds map[string]struct{}) (userDirectory, error) {
+	userMap, err := h.getUsersByIds(ctx, userIds)
+	if err != nil || viewerId == "" || len(userMap) == 0 {
+		return userMap, err
+	}
+
+	nicknames, err := h.getNicknames(ctx, viewerId)
+	if err != nil {
+		log.Printf("Error getting the nicknames of user %s from DynamoDB: %v", viewerId, err)
+		return userMap, nil
+	}
+	for userId, user := range userMap {
+		if nickname, ok := nicknames[userId]; ok {
+			user.Nickname = nickname
+			userMap[userId] = user
+		}
+	}
+	return userMap, nil
+}
+
+// getNicknameTarget returns the caller and the member they nickname in the request, or the
+// response rejecting the request. Both must be members of the group of the request, so the
+// callers only nickname the people they share a group with.
+func (h *Handlers) getNicknameTarget(request events.APIGatewayProxyRequest) (string, string, *events.APIGatewayProxyResponse) {
+	reject := func(statusCode int, message string) (string, string, *events.APIGatewayProxyResponse) {
+		response, _ := common.CreateErrorResponse(statusCode, message)
+		return "", "", &response
+	}
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		response, _ := auth.ErrorResponse(err)
+		return "", "", &response
+	}
+
+	// Extract groupId and userId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return reject(400, "Group ID is missing")
+	}
+	memberId, ok := request.PathParameters["userId"]
+	if !ok || memberId == "" {
+		return reject(400, "User ID is missing")
+	}
+	if memberId == claims.Sub {
+		return reject(400, "You can't nickname yourself")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if member == nil {
+		return reject(404, "Group not found")
+	}
+	member, err = h.getGroupMember(context.TODO(), memberId, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if member == nil {
+		return reject(404, "Member not found")
+	}
+	return claims.Sub, memberId, nil
+}
+
+// PutNicknameHandler sets the nickname the caller gives a member of one of their groups. The
+// nickname applies wherever the member shows up for the caller, not just in that group.
+func (h *Handlers) PutNicknameHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	userId, memberId, rejection := h.getNicknameTarget(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	var nickname Nickname
+	if err := json.Unmarshal([]byte(request.Body), &nickname); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	nickname.Nickname = strings.TrimSpace(nickname.Nickname)
+	if nickname.Nickname == "" {
+		return common.CreateErrorResponse(400, "Nickname is missing")
+	}
+	if utf8.RuneCountInString(nickname.Nickname) > maxNickname {
+		return common.CreateErrorResponse(400, "Nickname is too long")
+	}
+	nickname.UserID = userId
+	nickname.MemberID = memberId
+	nickname.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	item, err := attributevalue.MarshalMap(nickname)
+	if err != nil {
+		log.Printf("Error marshalling nickname: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	_, err = h.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("splitter-nicknames"),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("Error putting nickname into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s nicknamed member %s", userId, memberId)
+
+	// Marshal the nickname into JSON for the payload
+	payload, err := json.Marshal(nickname)
+	if err != nil {
+		log.Println("Error marshalling nickname:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// DeleteNicknameHandler removes the nickname the caller gave a member, who shows up with
+// their own name again.
+func (h *Handlers) DeleteNicknameHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	userId, memberId, rejection := h.getNicknameTarget(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	_, err := h.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("splitter-nicknames"),
+		Key: map[string]types.AttributeValue{
+			"userId":   &types.AttributeValueMemberS{Value: userId},
+			"memberId": &types.AttributeValueMemberS{Value: memberId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting nickname from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s removed the nickname of member %s", userId, memberId)
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
@@ -0,0 +1,76 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNicknames(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id"},
+			{"userId": "user-2", "groupId": "test-group-id"},
+			{"userId": "user-3", "groupId": "other-group-id"},
+		},
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice", "showableName": "Alice"},
+			{"userId": "user-2", "username": "carol", "showableName": "Carol"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	nickname := func(method, userId, memberId string, body map[string]interface{}) int {
+		request := testutil.NewRequest(method, "").
+			WithClaims(userId, userId).
+			WithPathParam("groupId", "test-group-id").
+			WithPathParam("userId", memberId)
+		if body != nil {
+			request = request.WithJSONBody(t, body)
+		}
+		handler := h.PutNicknameHandler
+		if method == "DELETE" {
+			handler = h.DeleteNicknameHandler
+		}
+		response, err := handler(request.Build())
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+	groupUsers := func(userId string) map[string]User {
+		response, err := h.GetGroupUsersHandler(testutil.NewRequest("GET", "").
+			WithClaims(userId, userId).
+			WithPathParam("groupId", "test-group-id").
+			Build())
+		assert.NoError(t, err)
+		var users []User
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &users))
+		byId := map[string]User{}
+		for _, user := range users {
+			byId[user.UserID] = user
+		}
+		return byId
+	}
+
+	// Only the members of a shared group can be nicknamed, by a member
+	assert.Equal(t, http.StatusNotFound, nickname("PUT", "user-1", "user-3", map[string]interface{}{"nickname": "Bob"}))
+	assert.Equal(t, http.StatusNotFound, nickname("PUT", "user-3", "user-1", map[string]interface{}{"nickname": "Al"}))
+	assert.Equal(t, http.StatusBadRequest, nickname("PUT", "user-1", "user-1", map[string]interface{}{"nickname": "Me"}))
+	assert.Equal(t, http.StatusBadRequest, nickname("PUT", "user-1", "user-2", map[string]interface{}{"nickname": "  "}))
+	assert.Equal(t, http.StatusBadRequest, nickname("PUT", "user-1", "user-2", map[string]interface{}{"nickname": "A nickname far longer than forty characters"}))
+
+	// The nickname shows up for the user who set it only, the name stays as it is
+	assert.Equal(t, http.StatusOK, nickname("PUT", "user-1", "user-2", map[string]interface{}{"nickname": " Mom "}))
+	users := groupUsers("user-1")
+	assert.Equal(t, "Mom", users["user-2"].Nickname)
+	assert.Equal(t, "Carol", users["user-2"].ShowableName)
+	assert.Empty(t, users["user-1"].Nickname)
+	assert.Empty(t, groupUsers("user-2")["user-2"].Nickname)
+
+	// Removing it shows the name alone again
+	assert.Equal(t, http.StatusNoContent, nickname("DELETE", "user-1", "user-2", nil))
+	assert.Empty(t, groupUsers("user-1")["user-2"].Nickname)
+}
@@ -0,0 +1,112 @@
+package financial
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+var (
+	errInvalidPayer = errors.New("invalid payer")
+	errPayersTotal  = errors.New("payers don't add up to the amount")
+)
+
+// Payer struct for the members who paid an expense and how much each of them paid
+type Payer struct {
+	UserID string      `json:"userId" dynamodbav:"userId"`
+	Amount json.Number `json:"amount" dynamodbav:"amount"`
+	User   `dynamodbav:"-"`
+}
+
+// normalizePayers fills in the payers of an expense stored before expenses could have
+// several payers, from its single paidBy, and the legacy paidBy of an expense from its
+// first payer, so both are always set for readers of either.
+func normalizePayers(expense *FinancialExpense) {
+	if len(expense.Payers) == 0 && expense.PaidBy != "" {
+		expense.Payers = []Payer{{UserID: expense.PaidBy, Amount: expense.Amount}}
+	}
+	if expense.PaidBy == "" && len(expense.Payers) > 0 {
+		expense.PaidBy = expense.Payers[0].UserID
+	}
+}
+
+// calculatePayers validates the payers of a new expense and formats their amounts with two
+// decimals. An expense with a paidBy and no payers was fully paid by that member. The
+// amounts of the payers must add up to the expense amount, which must be valid already.
+func calculatePayers(expense *FinancialExpense) error {
+	if len(expense.Payers) == 0 {
+		normalizePayers(expense)
+		if len(expense.Payers) == 0 {
+			return nil
+		}
+	}
+
+	amount, ok := new(big.Rat).SetString(string(expense.Amount))
+	if !ok {
+		return errInvalidAmount
+	}
+	seen := make(map[string]struct{}, len(expense.Payers))
+	total := new(big.Rat)
+	for i, payer := range expense.Payers {
+		paid, ok := new(big.Rat).SetString(string(payer.Amount))
+		if !ok || paid.Sign() <= 0 || payer.UserID == "" {
+			return errInvalidPayer
+		}
+		if _, ok := seen[payer.UserID]; ok {
+			return errInvalidPayer
+		}
+		seen[payer.UserID] = struct{}{}
+		expense.Payers[i].Amount = json.Number(paid.FloatString(2))
+		total.Add(total, paid)
+	}
+	if total.Cmp(amount) != 0 {
+		return errPayersTotal
+	}
+
+	expense.PaidBy = expense.Payers[0].UserID
+	return nil
+}
+
+// paidAmount returns how much the member paid of the expense.
+func paidAmount(expense FinancialExpense, userId string) *big.Rat {
+	for _, payer := range expense.Payers {
+		if payer.UserID != userId {
+			continue
+		}
+		if paid, ok := new(big.Rat).SetString(string(payer.Amount)); ok {
+			return paid
+		}
+	}
+	return new(big.Rat)
+}
+
+// isPayer reports whether the member paid part of the expense.
+func isPayer(expense FinancialExpense, userId string) bool {
+	for _, payer := range expense.Payers {
+		if payer.UserID == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// credits returns how much each payer is owed by the other participants of the expense:
+// what they paid beyond their own share.
+func credits(expense FinancialExpense) map[string]*big.Rat {
+	result := map[string]*big.Rat{}
+	for _, payer := range expense.Payers {
+		credit := paidAmount(expense, payer.UserID)
+		for _, participant := range expense.Participants {
+			if participant.UserID != payer.UserID {
+				continue
+			}
+			if share, ok := new(big.Rat).SetString(string(participant.CalculatedMoney)); ok {
+				credit.Sub(credit, share)
+			}
+		}
+		if credit.Sign() > 0 {
+			result[payer.UserID] = credit
+		}
+	}
+	return result
+}
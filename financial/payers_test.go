@@ -0,0 +1,101 @@
+package financial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculatePayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		expense FinancialExpense
+		payers  []Payer
+		paidBy  string
+		err     error
+	}{
+		{
+			name:    "SinglePaidBy",
+			expense: FinancialExpense{Amount: "30", PaidBy: "user-1"},
+			payers:  []Payer{{UserID: "user-1", Amount: "30.00"}},
+			paidBy:  "user-1",
+		},
+		{
+			name:    "PayersDontAddUp",
+			expense: FinancialExpense{Amount: "300", Payers: []Payer{{UserID: "user-2", Amount: "200"}, {UserID: "user-1", Amount: "100.5"}}},
+			err:     errPayersTotal,
+		},
+		{
+			name:    "PayersAddUp",
+			expense: FinancialExpense{Amount: "300", PaidBy: "user-1", Payers: []Payer{{UserID: "user-2", Amount: "200"}, {UserID: "user-1", Amount: "100"}}},
+			payers:  []Payer{{UserID: "user-2", Amount: "200.00"}, {UserID: "user-1", Amount: "100.00"}},
+			paidBy:  "user-2",
+		},
+		{
+			name:    "DuplicatePayer",
+			expense: FinancialExpense{Amount: "20", Payers: []Payer{{UserID: "user-1", Amount: "10"}, {UserID: "user-1", Amount: "10"}}},
+			err:     errInvalidPayer,
+		},
+		{
+			name:    "NegativeAmount",
+			expense: FinancialExpense{Amount: "20", Payers: []Payer{{UserID: "user-1", Amount: "30"}, {UserID: "user-2", Amount: "-10"}}},
+			err:     errInvalidPayer,
+		},
+		{
+			name:    "NoPayer",
+			expense: FinancialExpense{Amount: "20"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expense := tt.expense
+			err := calculatePayers(&expense)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.payers, expense.Payers)
+			assert.Equal(t, tt.paidBy, expense.PaidBy)
+		})
+	}
+}
+
+func TestOwedToSeveralPayers(t *testing.T) {
+	// Alice paid 200 and Bob 100 of a 300 hotel shared by three
+	expense := FinancialExpense{
+		Amount: "300",
+		Payers: []Payer{{UserID: "alice", Amount: "200"}, {UserID: "bob", Amount: "100"}},
+		Participants: []Participant{
+			{UserID: "alice", CalculatedMoney: "100"},
+			{UserID: "bob", CalculatedMoney: "100"},
+			{UserID: "carol", CalculatedMoney: "100"},
+		},
+	}
+
+	// Only Carol owes anything, all of it to Alice who paid beyond her share
+	assert.Equal(t, "100.00", owedTo(expense, expense.Participants[2], "alice").FloatString(2))
+	assert.Equal(t, "0.00", owedTo(expense, expense.Participants[2], "bob").FloatString(2))
+	assert.Equal(t, "0.00", outstanding(expense, expense.Participants[1]).FloatString(2))
+
+	// With Dave joining, Alice and Bob are owed in proportion to what they advanced
+	expense.Amount = "400"
+	expense.Payers = []Payer{{UserID: "alice", Amount: "250"}, {UserID: "bob", Amount: "150"}}
+	for i := range expense.Participants {
+		expense.Participants[i].CalculatedMoney = "100"
+	}
+	expense.Participants = append(expense.Participants, Participant{UserID: "dave", CalculatedMoney: "100"})
+	assert.Equal(t, "75.00", owedTo(expense, expense.Participants[3], "alice").FloatString(2))
+	assert.Equal(t, "25.00", owedTo(expense, expense.Participants[3], "bob").FloatString(2))
+}
+
+func TestNormalizePayers(t *testing.T) {
+	// Expenses stored before payers get their paidBy as single payer
+	legacy := FinancialExpense{Amount: "12.50", PaidBy: "user-1"}
+	normalizePayers(&legacy)
+	assert.Equal(t, []Payer{{UserID: "user-1", Amount: "12.50"}}, legacy.Payers)
+
+	stored := FinancialExpense{Amount: "12.50", Payers: []Payer{{UserID: "user-2", Amount: "12.50"}}}
+	normalizePayers(&stored)
+	assert.Equal(t, "user-2", stored.PaidBy)
+}
@@ -0,0 +1,238 @@
+package financial
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"slices"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The ways a split preview can split the amount
+const (
+	// PreviewEqual splits the amount equally between the participants.
+	PreviewEqual = "EQUAL"
+	// PreviewPercentage splits the amount by the percentages of the participants.
+	PreviewPercentage = "PERCENTAGE"
+	// PreviewShares splits the amount in proportion to the shares of the participants, e.g.
+	// 2 and 1 for a couple paying twice as much as a single.
+	PreviewShares = "SHARES"
+	// PreviewItemized gives every participant the items assigned to them and the rest of the
+	// amount, like taxes and tips, in proportion to their items.
+	PreviewItemized = "ITEMIZED"
+)
+
+// maxPreviewParticipants bounds the participants of a split preview.
+const maxPreviewParticipants = 50
+
+// maxPreviewItems bounds the items of an itemized split preview.
+const maxPreviewItems = 200
+
+var (
+	errPreviewSplitType     = errors.New("Invalid split type, expected EQUAL, PERCENTAGE, SHARES or ITEMIZED")
+	errPreviewParticipants  = errors.New("The split needs between 1 and 50 participants with distinct IDs")
+	errPreviewItems         = errors.New("An itemized split needs between 1 and 200 items, each assigned to a participant")
+	errPreviewItemsTotal    = errors.New("The items add up to more than the amount")
+	errPreviewInvalidAmount = errors.New("Invalid amount")
+)
+
+// SplitPreviewParticipant is a participant of a split preview. The IDs only tell the
+// participants apart, they don't need to be users. The value is their percentage or their
+// shares, depending on the split type.
+type SplitPreviewParticipant struct {
+	UserID string      `json:"userId"`
+	Value  json.Number `json:"value,omitempty"`
+}
+
+// SplitPreviewRequest struct for the split preview request body. The participants of an
+// itemized split are those its items are assigned to.
+type SplitPreviewRequest struct {
+	Amount       json.Number               `json:"amount"`
+	Currency     string                    `json:"currency,omitempty"`
+	SplitType    string                    `json:"splitType"`
+	Participants []SplitPreviewParticipant `json:"participants,omitempty"`
+	Items        []ExpenseItem             `json:"items,omitempty"`
+}
+
+// SplitPreviewShare is the computed share of a participant.
+type SplitPreviewShare struct {
+	UserID          string      `json:"userId"`
+	Share           json.Number `json:"share"` // percentage of the amount
+	CalculatedMoney json.Number `json:"calculatedMoney"`
+}
+
+// SplitPreview is the response of the split preview.
+type SplitPreview struct {
+	Amount    json.Number         `json:"amount"`
+	Currency  string              `json:"currency,omitempty"`
+	SplitType string              `json:"splitType"`
+	Shares    []SplitPreviewShare `json:"shares"`
+}
+
+// previewWeights returns the participants of the split and their weights, in the order they
+// were given.
+func previewWeights(request SplitPreviewRequest, amount *big.Rat) ([]string, []*big.Rat, error) {
+	if request.SplitType == PreviewItemized {
+		return itemWeights(request.Items, amount)
+	}
+
+	if len(request.Participants) == 0 || len(request.Participants) > maxPreviewParticipants {
+		return nil, nil, errPreviewParticipants
+	}
+	userIds := make([]string, 0, len(request.Participants))
+	weights := make([]*big.Rat, 0, len(request.Participants))
+	for _, participant := range request.Participants {
+		if participant.UserID == "" || slices.Contains(userIds, participant.UserID) {
+			return nil, nil, errPreviewParticipants
+		}
+		weight := big.NewRat(1, 1)
+		if request.SplitType != PreviewEqual {
+			value, ok := new(big.Rat).SetString(string(participant.Value))
+			if !ok || value.Sign() < 0 {
+				return nil, nil, errInvalidShare
+			}
+			weight = value
+		}
+		userIds = append(userIds, participant.UserID)
+		weights = append(weights, weight)
+	}
+	return userIds, weights, nil
+}
+
+// itemWeights weighs the participants of an itemized split by the items assigned to them,
+// shared equally when assigned to several. The rest of the amount is split like the items.
+func itemWeights(items []ExpenseItem, amount *big.Rat) ([]string, []*big.Rat, error) {
+	if len(items) == 0 || len(items) > maxPreviewItems {
+		return nil, nil, errPreviewItems
+	}
+	var userIds []string
+	owed := map[string]*big.Rat{}
+	itemsTotal := new(big.Rat)
+	for _, item := range items {
+		itemAmount, ok := new(big.Rat).SetString(string(item.Amount))
+		if !ok || itemAmount.Sign() < 0 {
+			return nil, nil, errPreviewInvalidAmount
+		}
+		itemsTotal.Add(itemsTotal, itemAmount)
+
+		assigned := slices.Compact(slices.Sorted(slices.Values(item.UserIDs)))
+		if len(assigned) == 0 || slices.Contains(assigned, "") {
+			return nil, nil, errPreviewItems
+		}
+		part := new(big.Rat).Quo(itemAmount, big.NewRat(int64(len(assigned)), 1))
+		for _, userId := range assigned {
+			if owed[userId] == nil {
+				owed[userId] = new(big.Rat)
+				userIds = append(userIds, userId)
+			}
+			owed[userId].Add(owed[userId], part)
+		}
+	}
+	if len(userIds) > maxPreviewParticipants {
+		return nil, nil, errPreviewParticipants
+	}
+	if itemsTotal.Sign() == 0 {
+		return nil, nil, errPreviewInvalidAmount
+	}
+	if itemsTotal.Cmp(amount) > 0 {
+		return nil, nil, errPreviewItemsTotal
+	}
+
+	weights := make([]*big.Rat, len(userIds))
+	for i, userId := range userIds {
+		weights[i] = owed[userId]
+	}
+	return userIds, weights, nil
+}
+
+// previewSplit computes the shares of the split preview with the split engine of the
+// expenses: the weights of the participants are turned into percentages, so every split
+// type is rounded to the cent like a percentage expense.
+func previewSplit(request SplitPreviewRequest) (SplitPreview, error) {
+	switch request.SplitType {
+	case PreviewEqual, PreviewPercentage, PreviewShares, PreviewItemized:
+	default:
+		return SplitPreview{}, errPreviewSplitType
+	}
+	amount, ok := new(big.Rat).SetString(string(request.Amount))
+	if !ok || amount.Sign() <= 0 {
+		return SplitPreview{}, errPreviewInvalidAmount
+	}
+
+	userIds, weights, err := previewWeights(request, amount)
+	if err != nil {
+		return SplitPreview{}, err
+	}
+	totalWeight := new(big.Rat)
+	for _, weight := range weights {
+		totalWeight.Add(totalWeight, weight)
+	}
+	if totalWeight.Sign() == 0 {
+		return SplitPreview{}, errSharesTotal
+	}
+
+	expense := FinancialExpense{Amount: request.Amount, SplitType: "PERCENTAGE"}
+	for i, userId := range userIds {
+		share := weights[i]
+		if request.SplitType != PreviewPercentage {
+			share = new(big.Rat).Mul(weights[i], big.NewRat(100, 1))
+			share.Quo(share, totalWeight)
+		}
+		expense.Participants = append(expense.Participants, Participant{UserID: userId, Share: json.Number(share.FloatString(10))})
+	}
+	if err := calculateParticipantMoney(&expense); err != nil {
+		return SplitPreview{}, err
+	}
+
+	preview := SplitPreview{Amount: request.Amount, Currency: request.Currency, SplitType: request.SplitType}
+	for _, participant := range expense.Participants {
+		preview.Shares = append(preview.Shares, SplitPreviewShare{
+			UserID:          participant.UserID,
+			Share:           participant.Share,
+			CalculatedMoney: participant.CalculatedMoney,
+		})
+	}
+	return preview, nil
+}
+
+// SplitPreviewHandler runs the split engine on the submitted amount and participants and
+// returns their shares, without reading or writing anything. It is public, for the marketing
+// site and the apps to preview a split before there is an expense or even an account.
+func SplitPreviewHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	var incoming SplitPreviewRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	preview, err := previewSplit(incoming)
+	if errors.Is(err, errSharesTotal) {
+		if incoming.SplitType == PreviewPercentage {
+			return common.CreateErrorResponse(400, "Shares must add up to 100")
+		}
+		return common.CreateErrorResponse(400, "Shares must add up to more than 0")
+	}
+	if errors.Is(err, errInvalidShare) {
+		return common.CreateErrorResponse(400, "Invalid share")
+	}
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Marshal the preview into JSON for the payload
+	payload, err := json.Marshal(preview)
+	if err != nil {
+		log.Println("Error marshalling split preview:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,77 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPreviewHandler(t *testing.T) {
+	preview := func(body string) (int, map[string]string) {
+		response, err := SplitPreviewHandler(testutil.NewRequest("POST", "/VassistantBackendProxy/public/split-preview").
+			WithBody(body).
+			Build())
+		assert.NoError(t, err)
+		if response.StatusCode != http.StatusOK {
+			return response.StatusCode, nil
+		}
+		var split SplitPreview
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &split))
+		money := map[string]string{}
+		for _, share := range split.Shares {
+			money[share.UserID] = string(share.CalculatedMoney)
+		}
+		return response.StatusCode, money
+	}
+
+	cases := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{
+			name: "equal, the leftover cent going to the first",
+			body: `{"amount": "100", "splitType": "EQUAL", "participants": [{"userId": "ana"}, {"userId": "bia"}, {"userId": "caio"}]}`,
+			want: map[string]string{"ana": "33.34", "bia": "33.33", "caio": "33.33"},
+		},
+		{
+			name: "percentage",
+			body: `{"amount": "80", "splitType": "PERCENTAGE", "participants": [{"userId": "ana", "value": 75}, {"userId": "bia", "value": 25}]}`,
+			want: map[string]string{"ana": "60.00", "bia": "20.00"},
+		},
+		{
+			name: "shares",
+			body: `{"amount": "90", "splitType": "SHARES", "participants": [{"userId": "couple", "value": 2}, {"userId": "single", "value": 1}]}`,
+			want: map[string]string{"couple": "60.00", "single": "30.00"},
+		},
+		{
+			name: "itemized, the tip split like the items",
+			body: `{"amount": "33", "splitType": "ITEMIZED", "items": [` +
+				`{"description": "Pizza", "amount": "20", "userIds": ["ana", "bia"]},` +
+				`{"description": "Wine", "amount": "10", "userIds": ["ana"]}]}`,
+			want: map[string]string{"ana": "22.00", "bia": "11.00"},
+		},
+	}
+	for _, c := range cases {
+		status, money := preview(c.body)
+		assert.Equal(t, http.StatusOK, status, c.name)
+		assert.Equal(t, c.want, money, c.name)
+	}
+
+	for _, body := range []string{
+		`{"amount": "10", "splitType": "EXACT", "participants": [{"userId": "ana"}]}`,
+		`{"amount": "-10", "splitType": "EQUAL", "participants": [{"userId": "ana"}]}`,
+		`{"amount": "10", "splitType": "EQUAL", "participants": []}`,
+		`{"amount": "10", "splitType": "EQUAL", "participants": [{"userId": "ana"}, {"userId": "ana"}]}`,
+		`{"amount": "10", "splitType": "PERCENTAGE", "participants": [{"userId": "ana", "value": 60}]}`,
+		`{"amount": "10", "splitType": "SHARES", "participants": [{"userId": "ana", "value": 0}]}`,
+		`{"amount": "10", "splitType": "ITEMIZED", "items": [{"description": "Pizza", "amount": "20", "userIds": ["ana"]}]}`,
+		`{"amount": "10", "splitType": "ITEMIZED", "items": [{"description": "Pizza", "amount": "5", "userIds": []}]}`,
+	} {
+		status, _ := preview(body)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	}
+}
@@ -0,0 +1,171 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The resources limited by the quotas
+const (
+	QuotaMembers     = "members"
+	QuotaExpenses    = "expenses"
+	QuotaAttachments = "attachments"
+)
+
+// QuotaCode is the code of the responses refusing what would take a group over its quota.
+const QuotaCode = "quota_exceeded"
+
+// Quotas are the soft limits on the growth of the groups, protecting the queries reading a
+// group whole from its partition. They are soft as they are checked against a count read
+// before writing, so concurrent writes may go slightly over. A limit of 0 is unlimited.
+type Quotas struct {
+	MembersPerGroup       int `json:"membersPerGroup"`
+	ExpensesPerGroup      int `json:"expensesPerGroup"`
+	AttachmentsPerExpense int `json:"attachmentsPerExpense"`
+}
+
+// GroupQuotas are the quotas enforced. Every resource is unlimited until configured.
+var GroupQuotas Quotas
+
+// ParseQuotas parses a JSON object of quotas, like {"membersPerGroup": 50}, the missing ones
+// being unlimited.
+func ParseQuotas(spec string) (Quotas, error) {
+	var quotas Quotas
+	if err := json.Unmarshal([]byte(spec), &quotas); err != nil {
+		return Quotas{}, err
+	}
+	if quotas.MembersPerGroup < 0 || quotas.ExpensesPerGroup < 0 || quotas.AttachmentsPerExpense < 0 {
+		return Quotas{}, errors.New("quotas can't be negative")
+	}
+	return quotas, nil
+}
+
+// QuotaError is what would go over a quota: how many of the resource there are, how many
+// more were requested and the limit.
+type QuotaError struct {
+	Resource  string `json:"resource"`
+	Usage     int    `json:"usage"`
+	Requested int    `json:"requested"`
+	Limit     int    `json:"limit"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%d %s and %d more requested, over the quota of %d", e.Usage, e.Resource, e.Requested, e.Limit)
+}
+
+// QuotaResponse is the body of the responses refusing what would go over a quota.
+type QuotaResponse struct {
+	common.ErrorResponse
+	Quota QuotaError `json:"quota"`
+}
+
+// checkQuota returns a *QuotaError when adding the requested resources to those used goes
+// over the limit, nil otherwise or when unlimited.
+func checkQuota(resource string, usage, requested, limit int) *QuotaError {
+	if limit == 0 || requested <= 0 || usage+requested <= limit {
+		return nil
+	}
+	return &QuotaError{Resource: resource, Usage: usage, Requested: requested, Limit: limit}
+}
+
+// quotaResponse refuses what would go over the quota, with 409 when the quota is reached
+// already, and with 422 when only the request is too large for what is left of it.
+func quotaResponse(quotaErr *QuotaError) (events.APIGatewayProxyResponse, error) {
+	statusCode, message := 409, fmt.Sprintf("The quota of %d %s is reached", quotaErr.Limit, quotaErr.Resource)
+	if quotaErr.Usage < quotaErr.Limit {
+		statusCode, message = 422, fmt.Sprintf("Only %d more %s fit in the quota of %d", quotaErr.Limit-quotaErr.Usage, quotaErr.Resource, quotaErr.Limit)
+	}
+	payload, err := json.Marshal(QuotaResponse{
+		ErrorResponse: common.ErrorResponse{Error: message, Code: QuotaCode},
+		Quota:         *quotaErr,
+	})
+	if err != nil {
+		log.Println("Error marshalling quota response:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// countGroupExpenses returns how many expenses the group has, counted by DynamoDB without
+// reading them.
+func (h *Handlers) countGroupExpenses(ctx context.Context, groupId string) (int, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		Select: types.SelectCount,
+	}
+	count := 0
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return 0, err
+		}
+		count += int(result.Count)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return count, nil
+		}
+	}
+}
+
+// checkExpenseQuota checks the group has room for the expenses added. It returns a
+// *QuotaError when it hasn't, and an error when the expenses could not be counted.
+func (h *Handlers) checkExpenseQuota(ctx context.Context, groupId string, adding int) (*QuotaError, error) {
+	if GroupQuotas.ExpensesPerGroup == 0 {
+		return nil, nil
+	}
+	count, err := h.countGroupExpenses(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	return checkQuota(QuotaExpenses, count, adding, GroupQuotas.ExpensesPerGroup), nil
+}
+
+// checkMemberQuota checks the group has room for a new member. It returns a *QuotaError when
+// it hasn't, and an error when the members could not be counted.
+func (h *Handlers) checkMemberQuota(ctx context.Context, groupId string) (*QuotaError, error) {
+	if GroupQuotas.MembersPerGroup == 0 {
+		return nil, nil
+	}
+	members, err := h.getGroupMembers(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	return checkQuota(QuotaMembers, len(members), 1, GroupQuotas.MembersPerGroup), nil
+}
+
+// attachments returns how many files are attached to the expense: its image and its receipt.
+func attachments(expense FinancialExpense) int {
+	count := 0
+	if expense.ImageURL != "" {
+		count++
+	}
+	if expense.ReceiptID != "" {
+		count++
+	}
+	return count
+}
+
+// checkAttachmentQuota checks an expense changed from before to after doesn't go over the
+// attachments quota. Replacing an attachment never does.
+func checkAttachmentQuota(before, after FinancialExpense) *QuotaError {
+	usage := attachments(before)
+	return checkQuota(QuotaAttachments, usage, attachments(after)-usage, GroupQuotas.AttachmentsPerExpense)
+}
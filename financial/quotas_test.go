@@ -0,0 +1,116 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuotas(t *testing.T) {
+	quotas, err := ParseQuotas(`{"membersPerGroup": 50, "expensesPerGroup": 20000}`)
+	assert.NoError(t, err)
+	assert.Equal(t, Quotas{MembersPerGroup: 50, ExpensesPerGroup: 20000}, quotas)
+
+	_, err = ParseQuotas(`{"attachmentsPerExpense": -1}`)
+	assert.Error(t, err)
+	_, err = ParseQuotas(`50`)
+	assert.Error(t, err)
+}
+
+func TestGroupQuotas(t *testing.T) {
+	GroupQuotas = Quotas{MembersPerGroup: 2, ExpensesPerGroup: 3}
+	t.Cleanup(func() { GroupQuotas = Quotas{} })
+
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "house", "role": RoleMember},
+		},
+		"splitter-group-settings": {{"groupId": "house", "discoverable": true}},
+	})
+	assert.NoError(t, err)
+	notifications.DynamoDbClient = fake
+	h := NewHandlers(fake)
+
+	quotaOf := func(body string) QuotaResponse {
+		var response QuotaResponse
+		assert.NoError(t, json.Unmarshal([]byte(body), &response))
+		return response
+	}
+	expense := map[string]interface{}{"title": "Groceries", "amount": "10", "currency": "EUR", "dateTime": "2024-06-01T12:00:00Z", "paidBy": "user-1"}
+
+	response, err := h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, expense).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	// A batch larger than what is left of the quota is refused whole
+	batch := func(count int) (int, string) {
+		expenses := make([]map[string]interface{}, count)
+		for i := range expenses {
+			expenses[i] = expense
+		}
+		response, err := h.PostGroupExpenseBatchHandler(testutil.NewRequest("POST", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "house").
+			WithJSONBody(t, map[string]interface{}{"expenses": expenses}).
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode, response.Body
+	}
+	statusCode, body := batch(3)
+	assert.Equal(t, http.StatusUnprocessableEntity, statusCode)
+	quota := quotaOf(body)
+	assert.Equal(t, QuotaCode, quota.Code)
+	assert.Equal(t, QuotaError{Resource: QuotaExpenses, Usage: 1, Requested: 3, Limit: 3}, quota.Quota)
+	statusCode, _ = batch(2)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	// Once the quota is reached, no more expenses are added
+	response, err = h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, expense).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+	assert.Equal(t, QuotaError{Resource: QuotaExpenses, Usage: 3, Requested: 1, Limit: 3}, quotaOf(response.Body).Quota)
+
+	// Nor members
+	response, err = h.PostJoinRequestHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-3", "carol").
+		WithPathParam("groupId", "house").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	response, err = h.ApproveJoinRequestHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "house").
+		WithPathParam("userId", "user-3").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+	assert.Equal(t, QuotaError{Resource: QuotaMembers, Usage: 2, Requested: 1, Limit: 2}, quotaOf(response.Body).Quota)
+	member, err := h.getGroupMember(t.Context(), "user-3", "house")
+	assert.NoError(t, err)
+	assert.Nil(t, member)
+}
+
+func TestCheckAttachmentQuota(t *testing.T) {
+	GroupQuotas = Quotas{AttachmentsPerExpense: 1}
+	t.Cleanup(func() { GroupQuotas = Quotas{} })
+
+	withImage := FinancialExpense{ImageURL: "https://uploads/image.jpg"}
+	assert.Nil(t, checkAttachmentQuota(FinancialExpense{}, withImage))
+	assert.Nil(t, checkAttachmentQuota(withImage, FinancialExpense{ImageURL: "https://uploads/other.jpg"}))
+	withReceipt := withImage
+	withReceipt.ReceiptID = "receipt-1"
+	assert.Equal(t, &QuotaError{Resource: QuotaAttachments, Usage: 1, Requested: 1, Limit: 1}, checkAttachmentQuota(withImage, withReceipt))
+}
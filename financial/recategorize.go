@@ -0,0 +1,195 @@
+package financial
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxRecategorizeBatch is the maximum number of expenses moved by a single call, the next
+// ones being moved by the calls with the returned cursor.
+const maxRecategorizeBatch = 25
+
+// RecategorizeRequest struct for the bulk recategorization request body. The expenses in
+// FromCategory, "" for the uncategorized ones, and with TitleContains in their title move
+// to Category. At least one of the filters must be given, a missing fromCategory matching
+// every category.
+type RecategorizeRequest struct {
+	FromCategory  *string `json:"fromCategory"`
+	TitleContains string  `json:"titleContains"`
+	Category      string  `json:"category"`
+}
+
+// RecategorizeResult summarizes a call of the bulk recategorization. The expenses changed
+// concurrently are left as they were, and NextCursor is the cursor query parameter of the
+// next call until every matching expense was looked at.
+type RecategorizeResult struct {
+	Category   string         `json:"category"`
+	Matched    int            `json:"matched"`
+	Updated    int            `json:"updated"`
+	Conflicts  []string       `json:"conflicts"`
+	From       map[string]int `json:"from"` // the number of expenses moved out of each category
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// matches reports whether the expense is moved by the request.
+func (r RecategorizeRequest) matches(expense FinancialExpense) bool {
+	if expense.Category == r.Category {
+		return false
+	}
+	if r.FromCategory != nil && expense.Category != *r.FromCategory {
+		return false
+	}
+	return r.TitleContains == "" || strings.Contains(strings.ToLower(expense.Title), strings.ToLower(r.TitleContains))
+}
+
+// parseRecategorizeCursor reads the ID of the last expense looked at by the previous call.
+func parseRecategorizeCursor(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	expenseId, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(expenseId) == 0 {
+		return "", errInvalidCursor
+	}
+	return string(expenseId), nil
+}
+
+// recategorizeBatch picks the next batch of the matching expenses after the cursor, by
+// expense ID, and the cursor of the next call, "" when none is left.
+func recategorizeBatch(expenses []FinancialExpense, filter RecategorizeRequest, after string) ([]FinancialExpense, string) {
+	slices.SortFunc(expenses, func(a, b FinancialExpense) int { return strings.Compare(a.ExpenseID, b.ExpenseID) })
+	var batch []FinancialExpense
+	for _, expense := range expenses {
+		if expense.ExpenseID <= after || !filter.matches(expense) {
+			continue
+		}
+		if len(batch) == maxRecategorizeBatch {
+			last := batch[len(batch)-1].ExpenseID
+			return batch, base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+		batch = append(batch, expense)
+	}
+	return batch, ""
+}
+
+// RecategorizeExpensesHandler moves the expenses of the group matching the filters of the
+// request to another category, in batches of maxRecategorizeBatch expenses. Only the group
+// admins can, as it changes the expenses of every member.
+func (h *Handlers) RecategorizeExpensesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a RecategorizeRequest struct
+	var recategorizeRequest RecategorizeRequest
+	err = json.Unmarshal([]byte(request.Body), &recategorizeRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	recategorizeRequest.TitleContains = strings.TrimSpace(recategorizeRequest.TitleContains)
+	if !slices.Contains(expenseCategories, recategorizeRequest.Category) {
+		return common.CreateErrorResponse(400, "Invalid category")
+	}
+	if recategorizeRequest.FromCategory == nil && recategorizeRequest.TitleContains == "" {
+		return common.CreateErrorResponse(400, "A fromCategory or titleContains filter is required")
+	}
+	after, err := parseRecategorizeCursor(request.QueryStringParameters["cursor"])
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Only admins can recategorize the expenses
+	admin, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if admin == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if admin.Role != RoleAdmin {
+		return common.CreateErrorResponse(403, "Only group admins can recategorize the expenses")
+	}
+
+	expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	batch, nextCursor := recategorizeBatch(expenses, recategorizeRequest, after)
+
+	// Store every expense on its own, unless it changed since it was read
+	result := RecategorizeResult{
+		Category:   recategorizeRequest.Category,
+		Matched:    len(batch),
+		Conflicts:  []string{},
+		From:       map[string]int{},
+		NextCursor: nextCursor,
+	}
+	for _, expense := range batch {
+		from := expense.Category
+		expectedVersion := expense.Version
+		expense.Category = recategorizeRequest.Category
+		expense.Version = expectedVersion + 1
+		err = common.ConditionalPutItem(ctx, h.client, "splitter-expenses", expense, common.IfVersion(expectedVersion))
+		if errors.Is(err, common.ErrConditionFailed) {
+			result.Conflicts = append(result.Conflicts, expense.ExpenseID)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error putting expense into DynamoDB after %d recategorized: %v", result.Updated, err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		result.Updated++
+		result.From[from]++
+	}
+
+	log.Printf("Admin %s recategorized %d expenses of group %s to %s, %d conflicts", claims.Sub, result.Updated, groupId, result.Category, len(result.Conflicts))
+
+	// Marshal the result into JSON for the payload
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Println("Error marshalling recategorize result:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	headers := map[string]string{"Content-Type": "application/json"}
+	if nextCursor != "" {
+		headers[NextCursorHeader] = nextCursor
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    headers,
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,108 @@
+package financial
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recategorize(t *testing.T, h *Handlers, userId, cursor string, body map[string]interface{}) (int, RecategorizeResult) {
+	builder := testutil.NewRequest("POST", "").
+		WithClaims(userId, userId).
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, body)
+	if cursor != "" {
+		builder = builder.WithQueryParam("cursor", cursor)
+	}
+	response, err := h.RecategorizeExpensesHandler(builder.Build())
+	assert.NoError(t, err)
+	var result RecategorizeResult
+	if response.StatusCode == http.StatusOK {
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+		assert.Equal(t, result.NextCursor, response.Headers[NextCursorHeader])
+	}
+	return response.StatusCode, result
+}
+
+func TestRecategorizeExpensesHandler(t *testing.T) {
+	// Set up the fake DynamoDB with 30 uncategorized pizzas and a few other expenses
+	expenses := []map[string]interface{}{
+		{"groupId": "test-group-id", "expenseId": "coffee", "title": "Coffee", "category": "DRINKS", "amount": "4", "paidBy": "user-1", "dateTime": "2024-01-01T00:00:00Z"},
+		{"groupId": "test-group-id", "expenseId": "taxi", "title": "Taxi", "amount": "20", "paidBy": "user-1", "dateTime": "2024-01-01T00:00:00Z"},
+		{"groupId": "test-group-id", "expenseId": "lunch", "title": "Lunch", "category": "FOOD", "amount": "12", "paidBy": "user-1", "dateTime": "2024-01-01T00:00:00Z"},
+	}
+	for i := range 30 {
+		expenses = append(expenses, map[string]interface{}{
+			"groupId": "test-group-id", "expenseId": fmt.Sprintf("pizza-%02d", i), "title": "Friday PIZZA", "amount": "30",
+			"paidBy": "user-1", "dateTime": "2024-01-01T00:00:00Z", "version": 1,
+		})
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House", "role": RoleMember},
+		},
+		"splitter-expenses": expenses,
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Only the admins can recategorize, to a known category and with a filter
+	statusCode, _ := recategorize(t, h, "user-2", "", map[string]interface{}{"titleContains": "pizza", "category": "FOOD"})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	statusCode, _ = recategorize(t, h, "user-1", "", map[string]interface{}{"titleContains": "pizza", "category": "PIZZA"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = recategorize(t, h, "user-1", "", map[string]interface{}{"category": "FOOD"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = recategorize(t, h, "user-1", "not base64!", map[string]interface{}{"titleContains": "pizza", "category": "FOOD"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	// The title matches whatever the case, the pizzas being moved in two batches
+	body := map[string]interface{}{"fromCategory": "", "titleContains": "pizza", "category": "FOOD"}
+	statusCode, result := recategorize(t, h, "user-1", "", body)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, maxRecategorizeBatch, result.Matched)
+	assert.Equal(t, maxRecategorizeBatch, result.Updated)
+	assert.Equal(t, map[string]int{"": maxRecategorizeBatch}, result.From)
+	assert.NotEmpty(t, result.NextCursor)
+
+	statusCode, result = recategorize(t, h, "user-1", result.NextCursor, body)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, RecategorizeResult{Category: "FOOD", Matched: 5, Updated: 5, Conflicts: []string{}, From: map[string]int{"": 5}}, result)
+
+	expense, err := h.getExpense(t.Context(), "test-group-id", "pizza-29")
+	assert.NoError(t, err)
+	assert.Equal(t, "FOOD", expense.Category)
+	assert.Equal(t, 2, expense.Version)
+
+	// The uncategorized expenses left are moved without a title filter, the ones already in
+	// the category being left out
+	statusCode, result = recategorize(t, h, "user-1", "", map[string]interface{}{"fromCategory": "", "category": "FOOD"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, RecategorizeResult{Category: "FOOD", Matched: 1, Updated: 1, Conflicts: []string{}, From: map[string]int{"": 1}}, result)
+
+	expense, err = h.getExpense(t.Context(), "test-group-id", "coffee")
+	assert.NoError(t, err)
+	assert.Equal(t, "DRINKS", expense.Category)
+}
+
+func TestRecategorizeBatch(t *testing.T) {
+	expenses := []FinancialExpense{
+		{ExpenseID: "c", Title: "Pizza"},
+		{ExpenseID: "a", Title: "Pizza", Category: "FOOD"},
+		{ExpenseID: "b", Title: "Pizza"},
+	}
+	filter := RecategorizeRequest{TitleContains: "pizza", Category: "FOOD"}
+
+	batch, cursor := recategorizeBatch(expenses, filter, "")
+	assert.Equal(t, []string{"b", "c"}, []string{batch[0].ExpenseID, batch[1].ExpenseID})
+	assert.Empty(t, cursor)
+
+	batch, _ = recategorizeBatch(expenses, filter, "b")
+	assert.Len(t, batch, 1)
+	assert.Equal(t, "c", batch[0].ExpenseID)
+}
@@ -0,0 +1,182 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// receiptMatchWindow is how far the date of an expense may be from the receipt's.
+	receiptMatchWindow = 7 * 24 * time.Hour
+	// receiptMatchTolerance is how far, relative to the total, the amount of an expense may
+	// be from the receipt's, e.g. the tip left out of the expense.
+	receiptMatchTolerance = 0.2
+	// receiptMatchMinScore is the score below which expenses aren't suggested.
+	receiptMatchMinScore = 0.4
+	// receiptMatchLimit is the number of expenses suggested at most.
+	receiptMatchLimit = 5
+)
+
+// ReceiptSuggestion is an expense the receipt may belong to, scored from 0 to 1, with the
+// fields that matched: "total", "date" and "merchant".
+type ReceiptSuggestion struct {
+	Expense FinancialExpense `json:"expense"`
+	Score   float64          `json:"score"`
+	Matches []string         `json:"matches"`
+}
+
+// matchReceipt scores how likely the receipt belongs to the expense. The total weighs the
+// most, then how close the dates are, then the merchant appearing in the title. Totals in
+// another currency never match.
+func matchReceipt(receipt Receipt, receiptTime time.Time, expense FinancialExpense) ReceiptSuggestion {
+	suggestion := ReceiptSuggestion{Expense: expense, Matches: []string{}}
+
+	total, totalOk := new(big.Rat).SetString(string(receipt.Total))
+	amount, amountOk := new(big.Rat).SetString(string(expense.Amount))
+	sameCurrency := receipt.Currency == "" || expense.Currency == "" || strings.EqualFold(receipt.Currency, expense.Currency)
+	if totalOk && amountOk && total.Sign() > 0 && sameCurrency {
+		difference, _ := new(big.Rat).Quo(new(big.Rat).Abs(new(big.Rat).Sub(amount, total)), total).Float64()
+		if difference <= receiptMatchTolerance {
+			suggestion.Score += 0.6 * (1 - difference/receiptMatchTolerance)
+			suggestion.Matches = append(suggestion.Matches, "total")
+		}
+	}
+
+	dateTime, err := time.Parse(time.RFC3339, expense.DateTime)
+	if err == nil {
+		distance := math.Abs(float64(dateTime.Sub(receiptTime)))
+		if distance <= float64(receiptMatchWindow) {
+			suggestion.Score += 0.3 * (1 - distance/float64(receiptMatchWindow))
+			suggestion.Matches = append(suggestion.Matches, "date")
+		}
+	}
+
+	merchant := strings.ToLower(strings.TrimSpace(receipt.Merchant))
+	if merchant != "" && strings.Contains(strings.ToLower(expense.Title), merchant) {
+		suggestion.Score += 0.1
+		suggestion.Matches = append(suggestion.Matches, "merchant")
+	}
+
+	suggestion.Score = math.Round(suggestion.Score*100) / 100
+	return suggestion
+}
+
+// suggestReceiptExpenses returns the expenses of the group within the window around the date
+// of the receipt it most likely belongs to, best first. The expenses with a receipt already
+// are left out.
+func (h *Handlers) suggestReceiptExpenses(ctx context.Context, receipt Receipt, now time.Time) ([]ReceiptSuggestion, error) {
+	receiptTime, err := time.Parse(time.RFC3339, receipt.DateTime)
+	if err != nil {
+		receiptTime = now
+	}
+
+	expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		IndexName:              aws.String("groupId-dateTime-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId AND dateTime >= :from"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: receipt.GroupID},
+			":from":    &types.AttributeValueMemberS{Value: receiptTime.Add(-receiptMatchWindow).UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []ReceiptSuggestion{}
+	for _, expense := range expenses {
+		if expense.ReceiptID != "" {
+			continue
+		}
+		suggestion := matchReceipt(receipt, receiptTime, expense)
+		if slices.Contains(suggestion.Matches, "date") && suggestion.Score >= receiptMatchMinScore {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	slices.SortStableFunc(suggestions, func(a, b ReceiptSuggestion) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(b.Expense.DateTime, a.Expense.DateTime)
+	})
+	if len(suggestions) > receiptMatchLimit {
+		suggestions = suggestions[:receiptMatchLimit]
+	}
+	return suggestions, nil
+}
+
+// GetReceiptSuggestionsHandler suggests the recent expenses of the group a receipt uploaded
+// without an expense may belong to, to attach it with AttachReceiptHandler.
+func (h *Handlers) GetReceiptSuggestionsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract receiptId from path parameters
+	receiptId, ok := request.PathParameters["receiptId"]
+	if !ok || receiptId == "" {
+		return common.CreateErrorResponse(400, "Receipt ID is missing")
+	}
+
+	receipt, err := h.getReceipt(ctx, receiptId)
+	if err != nil {
+		log.Printf("Error getting receipt from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if receipt == nil {
+		return common.CreateErrorResponse(404, "Receipt not found")
+	}
+
+	// Only the members of the group of the receipt can see it
+	member, err := h.getGroupMember(ctx, claims.Sub, receipt.GroupID)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Receipt not found")
+	}
+	if receipt.Status == ReceiptAssigned {
+		return common.CreateErrorResponse(409, "Receipt already assigned")
+	}
+
+	suggestions, err := h.suggestReceiptExpenses(ctx, *receipt, time.Now())
+	if err != nil {
+		log.Printf("Error querying expenses from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	log.Printf("Suggested %d expenses for receipt %s", len(suggestions), receiptId)
+
+	payload, err := json.Marshal(suggestions)
+	if err != nil {
+		log.Println("Error marshalling receipt suggestions:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,51 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/common"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReceiptSuggestionsHandler(t *testing.T) {
+	h, fake := newReceiptsFake(t)
+	for _, expense := range []FinancialExpense{
+		{GroupID: "test-group-id", ExpenseID: "dinner", Title: "Dinner at Trattoria", Amount: "50", Currency: "EUR", DateTime: "2024-03-01T21:00:00Z"},
+		{GroupID: "test-group-id", ExpenseID: "groceries", Title: "Groceries", Amount: "54", Currency: "EUR", DateTime: "2024-03-05T10:00:00Z"},
+		{GroupID: "test-group-id", ExpenseID: "taxi", Title: "Taxi", Amount: "55", Currency: "USD", DateTime: "2024-03-01T23:00:00Z"},
+		{GroupID: "test-group-id", ExpenseID: "lunch", Title: "Lunch", Amount: "55", Currency: "EUR", DateTime: "2024-02-01T12:00:00Z"},
+		{GroupID: "test-group-id", ExpenseID: "attached", Title: "Trattoria", Amount: "55", Currency: "EUR", DateTime: "2024-03-01T20:00:00Z", ReceiptID: "receipt-0"},
+	} {
+		assert.NoError(t, common.ConditionalPutItem(context.TODO(), fake, "splitter-expenses", expense, common.IfNotExists("expenseId")))
+	}
+
+	suggest := func(userId string) (int, string) {
+		response, err := h.GetReceiptSuggestionsHandler(testutil.NewRequest("GET", "").
+			WithClaims(userId, userId).
+			WithPathParam("receiptId", "receipt-1").
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode, response.Body
+	}
+
+	statusCode, _ := suggest("user-3")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	// The dinner without the tip, the same evening, beats the groceries closer in total but days
+	// later; the taxi in dollars, the lunch a month before and the expense with a receipt
+	// already aren't suggested
+	statusCode, body := suggest("user-2")
+	assert.Equal(t, http.StatusOK, statusCode)
+	var suggestions []ReceiptSuggestion
+	assert.NoError(t, json.Unmarshal([]byte(body), &suggestions))
+	if assert.Len(t, suggestions, 2) {
+		assert.Equal(t, "dinner", suggestions[0].Expense.ExpenseID)
+		assert.Equal(t, []string{"total", "date", "merchant"}, suggestions[0].Matches)
+		assert.Equal(t, "groceries", suggestions[1].Expense.ExpenseID)
+		assert.Greater(t, suggestions[0].Score, suggestions[1].Score)
+	}
+}
@@ -0,0 +1,479 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"slices"
+	"strings"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Statuses of a receipt
+const (
+	ReceiptPending  = "PENDING"
+	ReceiptAssigned = "ASSIGNED"
+)
+
+var (
+	errUnknownItem    = errors.New("Unknown line item")
+	errItemAssigned   = errors.New("Line item assigned more than once")
+	errItemUnassigned = errors.New("Every line item must be assigned to a member")
+	errNotMember      = errors.New("Line items can only be assigned to group members")
+)
+
+// ReceiptItem is a line item extracted from a receipt by Textract.
+type ReceiptItem struct {
+	ItemID      string      `json:"itemId" dynamodbav:"itemId"`
+	Description string      `json:"description" dynamodbav:"description"`
+	Amount      json.Number `json:"amount" dynamodbav:"amount"`
+}
+
+// Receipt struct for the splitter-receipts table, holding the line items extracted from a
+// receipt until they are assigned. The total includes what isn't a line item, like taxes
+// and tips.
+type Receipt struct {
+	ReceiptID string        `json:"receiptId" dynamodbav:"receiptId"`
+	GroupID   string        `json:"groupId" dynamodbav:"groupId"`
+	UserID    string        `json:"userId" dynamodbav:"userId"`
+	Merchant  string        `json:"merchant" dynamodbav:"merchant"`
+	Currency  string        `json:"currency" dynamodbav:"currency"`
+	DateTime  string        `json:"dateTime" dynamodbav:"dateTime"`
+	ImageURL  string        `json:"imageUrl" dynamodbav:"imageUrl"`
+	Items     []ReceiptItem `json:"items" dynamodbav:"items"`
+	Total     json.Number   `json:"total" dynamodbav:"total"`
+	Status    string        `json:"status" dynamodbav:"status"`
+	ExpenseID string        `json:"expenseId,omitempty" dynamodbav:"expenseId,omitempty"`
+	Version   int           `json:"version,omitempty" dynamodbav:"version,omitempty"`
+}
+
+// ReceiptWarning tells where a receipt scanned for an existing expense disagrees with it,
+// likely a typo in the expense. Field is "amount" or "currency".
+type ReceiptWarning struct {
+	Field   string `json:"field" dynamodbav:"field"`
+	Expense string `json:"expense" dynamodbav:"expense"`
+	Receipt string `json:"receipt" dynamodbav:"receipt"`
+	Message string `json:"message" dynamodbav:"message"`
+}
+
+// ExpenseItem is a line item of an itemized expense and the members sharing it.
+type ExpenseItem struct {
+	Description string      `json:"description" dynamodbav:"description"`
+	Amount      json.Number `json:"amount" dynamodbav:"amount"`
+	UserIDs     []string    `json:"userIds" dynamodbav:"userIds"`
+}
+
+// ItemAssignment assigns a line item to the members sharing it equally.
+type ItemAssignment struct {
+	ItemID  string   `json:"itemId"`
+	UserIDs []string `json:"userIds"`
+}
+
+// ReceiptAssignment struct for the assign receipt request body. The expense is paid by the
+// member who uploaded the receipt unless paidBy is given.
+type ReceiptAssignment struct {
+	Assignments []ItemAssignment `json:"assignments"`
+	Title       string           `json:"title"`
+	Category    string           `json:"category"`
+	PaidBy      string           `json:"paidBy"`
+}
+
+// ReceiptAttachment struct for the attach receipt request body
+type ReceiptAttachment struct {
+	ReceiptID string `json:"receiptId"`
+}
+
+// getReceipt returns the receipt, or nil if there is none.
+func (h *Handlers) getReceipt(ctx context.Context, receiptId string) (*Receipt, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-receipts"),
+		Key: map[string]types.AttributeValue{
+			"receiptId": &types.AttributeValueMemberS{Value: receiptId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var receipt Receipt
+	err = attributevalue.UnmarshalMap(result.Item, &receipt)
+	if err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// assignApprovedReceipt marks the receipt of an expense held for approval as assigned to it,
+// once the expense is approved. A receipt assigned in the meantime is left as it is.
+func (h *Handlers) assignApprovedReceipt(ctx context.Context, expense FinancialExpense) error {
+	receipt, err := h.getReceipt(ctx, expense.ReceiptID)
+	if err != nil || receipt == nil || receipt.GroupID != expense.GroupID || receipt.Status == ReceiptAssigned {
+		return err
+	}
+	expectedVersion := receipt.Version
+	receipt.Version = expectedVersion + 1
+	receipt.Status = ReceiptAssigned
+	receipt.ExpenseID = expense.ExpenseID
+	err = common.ConditionalPutItem(ctx, h.client, "splitter-receipts", receipt, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// itemizeReceipt builds the itemized expense of the receipt from the assignments. Every
+// member gets the items assigned to them, split equally when shared, and the rest of the
+// total, like taxes and tips, in proportion to their items. The shares are percentages of
+// the items, so the expense splits like any percentage expense.
+func itemizeReceipt(receipt Receipt, assignments []ItemAssignment, members []string) (FinancialExpense, error) {
+	items := make(map[string]ReceiptItem, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items[item.ItemID] = item
+	}
+
+	var expenseItems []ExpenseItem
+	owed := map[string]*big.Rat{}
+	var order []string
+	itemsTotal := new(big.Rat)
+	assigned := make(map[string]struct{}, len(assignments))
+	for _, assignment := range assignments {
+		if _, ok := assigned[assignment.ItemID]; ok {
+			return FinancialExpense{}, errItemAssigned
+		}
+		item, ok := items[assignment.ItemID]
+		if !ok {
+			return FinancialExpense{}, errUnknownItem
+		}
+		assigned[assignment.ItemID] = struct{}{}
+		if len(assignment.UserIDs) == 0 {
+			return FinancialExpense{}, errItemUnassigned
+		}
+		amount, ok := new(big.Rat).SetString(string(item.Amount))
+		if !ok || amount.Sign() < 0 {
+			return FinancialExpense{}, errInvalidAmount
+		}
+		itemsTotal.Add(itemsTotal, amount)
+
+		userIds := slices.Compact(slices.Sorted(slices.Values(assignment.UserIDs)))
+		part := new(big.Rat).Quo(amount, big.NewRat(int64(len(userIds)), 1))
+		for _, userId := range userIds {
+			if !slices.Contains(members, userId) {
+				return FinancialExpense{}, errNotMember
+			}
+			if owed[userId] == nil {
+				owed[userId] = new(big.Rat)
+				order = append(order, userId)
+			}
+			owed[userId].Add(owed[userId], part)
+		}
+		expenseItems = append(expenseItems, ExpenseItem{Description: item.Description, Amount: item.Amount, UserIDs: userIds})
+	}
+	if len(assigned) != len(items) {
+		return FinancialExpense{}, errItemUnassigned
+	}
+	if itemsTotal.Sign() == 0 {
+		return FinancialExpense{}, errInvalidAmount
+	}
+
+	participants := make([]Participant, 0, len(order))
+	for _, userId := range order {
+		share := new(big.Rat).Mul(owed[userId], big.NewRat(100, 1))
+		share.Quo(share, itemsTotal)
+		participants = append(participants, Participant{UserID: userId, Share: json.Number(share.FloatString(10))})
+	}
+
+	return FinancialExpense{
+		Title:        receipt.Merchant,
+		Amount:       receipt.Total,
+		Currency:     receipt.Currency,
+		DateTime:     receipt.DateTime,
+		ImageURL:     receipt.ImageURL,
+		SplitType:    "PERCENTAGE",
+		Participants: participants,
+		Items:        expenseItems,
+		ReceiptID:    receipt.ReceiptID,
+	}, nil
+}
+
+func (h *Handlers) AssignReceiptHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract receiptId from path parameters
+	receiptId, ok := request.PathParameters["receiptId"]
+	if !ok || receiptId == "" {
+		return common.CreateErrorResponse(400, "Receipt ID is missing")
+	}
+
+	// Parse the request body into a ReceiptAssignment struct
+	var assignment ReceiptAssignment
+	err = json.Unmarshal([]byte(request.Body), &assignment)
+	if err != nil || len(assignment.Assignments) == 0 {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	receipt, err := h.getReceipt(context.TODO(), receiptId)
+	if err != nil {
+		log.Printf("Error getting receipt from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if receipt == nil {
+		return common.CreateErrorResponse(404, "Receipt not found")
+	}
+
+	// Only the members of the group of the receipt can see it
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, receipt.GroupID)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Receipt not found")
+	}
+	if receipt.Status == ReceiptAssigned {
+		return common.CreateErrorResponse(409, "Receipt already assigned")
+	}
+
+	members, err := h.getGroupMemberIds(context.TODO(), receipt.GroupID)
+	if err != nil {
+		log.Printf("Error getting group members from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	expense, err := itemizeReceipt(*receipt, assignment.Assignments, members)
+	if errors.Is(err, errInvalidAmount) {
+		return common.CreateErrorResponse(422, "Receipt amounts are invalid")
+	}
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+	if assignment.Title != "" {
+		expense.Title = assignment.Title
+	}
+	expense.Category = assignment.Category
+	expense.PaidBy = receipt.UserID
+	if assignment.PaidBy != "" {
+		if !slices.Contains(members, assignment.PaidBy) {
+			return common.CreateErrorResponse(400, "The payer must be a group member")
+		}
+		expense.PaidBy = assignment.PaidBy
+	}
+
+	message, err := h.prepareExpense(context.TODO(), receipt.GroupID, claims.Sub, &expense)
+	if err != nil {
+		log.Printf("Error preparing expense: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if message != "" {
+		return common.CreateErrorResponse(422, message)
+	}
+
+	// Refuse the expense when the group has no room left for it
+	quotaErr, err := h.checkExpenseQuota(context.TODO(), receipt.GroupID, 1)
+	if err != nil {
+		log.Printf("Error checking the expense quota: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if quotaErr != nil {
+		return quotaResponse(quotaErr)
+	}
+
+	// Hold the expense for approval when it takes members over their spending cap, the
+	// receipt staying pending until it is approved
+	approval, warnings, err := h.checkSpendingCaps(context.TODO(), expense, claims.Sub)
+	if err != nil {
+		log.Printf("Error checking spending caps: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if approval != nil {
+		return approvalResponse(approval)
+	}
+
+	// Store the expense and mark the receipt as assigned together, so a receipt can only
+	// become one expense
+	expectedVersion := receipt.Version
+	receipt.Version = expectedVersion + 1
+	receipt.Status = ReceiptAssigned
+	receipt.ExpenseID = expense.ExpenseID
+	err = common.TransactPutItems(context.TODO(), h.client, []common.ConditionalPut{
+		{TableName: "splitter-expenses", Item: expense, Condition: common.IfNotExists("expenseId")},
+		{TableName: "splitter-receipts", Item: receipt, Condition: common.IfVersion(expectedVersion)},
+	})
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Receipt was modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error writing transaction to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Created expense %s from receipt %s in group %s", expense.ExpenseID, receiptId, receipt.GroupID)
+
+	// Marshal the expense into JSON for the payload
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		log.Println("Error marshalling expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	response := events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}
+	for _, warning := range warnings {
+		common.AddWarning(&response, warning)
+	}
+	return response, nil
+}
+
+// receiptMismatches compares the total and currency scanned from the receipt with the
+// expense. The amounts are only compared in the same currency.
+func receiptMismatches(expense FinancialExpense, receipt Receipt) []ReceiptWarning {
+	var warnings []ReceiptWarning
+	if receipt.Currency != "" && expense.Currency != "" && !strings.EqualFold(receipt.Currency, expense.Currency) {
+		return append(warnings, ReceiptWarning{
+			Field:   "currency",
+			Expense: expense.Currency,
+			Receipt: strings.ToUpper(receipt.Currency),
+			Message: "The receipt is in " + strings.ToUpper(receipt.Currency) + ", not " + expense.Currency,
+		})
+	}
+
+	total, ok := new(big.Rat).SetString(string(receipt.Total))
+	if !ok || total.Sign() <= 0 {
+		return warnings
+	}
+	amount, ok := new(big.Rat).SetString(string(expense.Amount))
+	if ok && amount.Cmp(total) != 0 {
+		warnings = append(warnings, ReceiptWarning{
+			Field:   "amount",
+			Expense: string(expense.Amount),
+			Receipt: string(receipt.Total),
+			Message: "The receipt total is " + string(receipt.Total) + ", not " + string(expense.Amount),
+		})
+	}
+	return warnings
+}
+
+// AttachReceiptHandler attaches a scanned receipt to an existing expense, warning in the
+// expense about the total or currency of the receipt disagreeing with it.
+func (h *Handlers) AttachReceiptHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and expenseId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Expense ID is missing")
+	}
+
+	// Parse the request body into a ReceiptAttachment struct
+	var attachment ReceiptAttachment
+	err = json.Unmarshal([]byte(request.Body), &attachment)
+	if err != nil || attachment.ReceiptID == "" {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	member, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	expense, err := h.getExpense(ctx, groupId, expenseId)
+	if err != nil {
+		log.Printf("Error getting expense from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if expense == nil {
+		return common.CreateErrorResponse(404, "Expense not found")
+	}
+
+	// Only the receipts of the group can be attached, and to a single expense
+	receipt, err := h.getReceipt(ctx, attachment.ReceiptID)
+	if err != nil {
+		log.Printf("Error getting receipt from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if receipt == nil || receipt.GroupID != groupId {
+		return common.CreateErrorResponse(404, "Receipt not found")
+	}
+	if receipt.Status == ReceiptAssigned && receipt.ExpenseID != expenseId {
+		return common.CreateErrorResponse(409, "Receipt already assigned")
+	}
+
+	before := *expense
+	expense.ReceiptID = receipt.ReceiptID
+	expense.ReceiptWarnings = receiptMismatches(*expense, *receipt)
+	if expense.ImageURL == "" {
+		expense.ImageURL = receipt.ImageURL
+	}
+	if quotaErr := checkAttachmentQuota(before, *expense); quotaErr != nil {
+		return quotaResponse(quotaErr)
+	}
+	expectedExpenseVersion := expense.Version
+	expense.Version = expectedExpenseVersion + 1
+	expectedReceiptVersion := receipt.Version
+	receipt.Version = expectedReceiptVersion + 1
+	receipt.Status = ReceiptAssigned
+	receipt.ExpenseID = expenseId
+	err = common.TransactPutItems(ctx, h.client, []common.ConditionalPut{
+		{TableName: "splitter-expenses", Item: expense, Condition: common.IfVersion(expectedExpenseVersion)},
+		{TableName: "splitter-receipts", Item: receipt, Condition: common.IfVersion(expectedReceiptVersion)},
+	})
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expense or receipt was modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error writing transaction to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Attached receipt %s to expense %s with %d warnings", receipt.ReceiptID, expenseId, len(expense.ReceiptWarnings))
+
+	// Marshal the expense into JSON for the payload
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		log.Println("Error marshalling expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,176 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// newReceiptsFake seeds a dinner receipt of user-1 with two dishes and a shared bottle,
+// plus 10% of taxes and tip.
+func newReceiptsFake(t *testing.T) (*Handlers, *testutil.FakeDynamoDB) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id"},
+			{"userId": "user-2", "groupId": "test-group-id"},
+		},
+		"splitter-receipts": {{
+			"receiptId": "receipt-1", "groupId": "test-group-id", "userId": "user-1", "merchant": "Trattoria",
+			"currency": "EUR", "dateTime": "2024-03-01T20:00:00Z", "total": "55", "status": ReceiptPending,
+			"items": []map[string]interface{}{
+				{"itemId": "1", "description": "Pasta", "amount": "12"},
+				{"itemId": "2", "description": "Steak", "amount": "18"},
+				{"itemId": "3", "description": "Wine", "amount": "20"},
+			},
+		}},
+	})
+	assert.NoError(t, err)
+	return NewHandlers(fake), fake
+}
+
+func assignReceipt(t *testing.T, h *Handlers, body map[string]interface{}) (int, string) {
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("receiptId", "receipt-1").
+		WithJSONBody(t, body).
+		Build()
+
+	// Call the handler
+	response, err := h.AssignReceiptHandler(request)
+	assert.NoError(t, err)
+	return response.StatusCode, response.Body
+}
+
+func TestAssignReceiptHandler(t *testing.T) {
+	h, fake := newReceiptsFake(t)
+
+	statusCode, body := assignReceipt(t, h, map[string]interface{}{
+		"assignments": []map[string]interface{}{
+			{"itemId": "1", "userIds": []string{"user-1"}},
+			{"itemId": "2", "userIds": []string{"user-2"}},
+			{"itemId": "3", "userIds": []string{"user-1", "user-2"}},
+		},
+	})
+
+	// Check the response: user-1 had 22 of the 50 of items, user-2 28, plus taxes and tip
+	assert.Equal(t, http.StatusCreated, statusCode)
+	var expense FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(body), &expense))
+	assert.Equal(t, "Trattoria", expense.Title)
+	assert.Equal(t, "user-1", expense.PaidBy)
+	assert.Equal(t, "receipt-1", expense.ReceiptID)
+	assert.Len(t, expense.Items, 3)
+	assert.Equal(t, []string{"user-1", "user-2"}, expense.Items[2].UserIDs)
+	assert.Equal(t, json.Number("24.20"), expense.Participants[0].CalculatedMoney)
+	assert.Equal(t, json.Number("44.00"), expense.Participants[0].Share)
+	assert.Equal(t, json.Number("30.80"), expense.Participants[1].CalculatedMoney)
+
+	// The receipt is marked as assigned to the expense
+	result, err := fake.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-receipts"),
+		Key:       map[string]types.AttributeValue{"receiptId": &types.AttributeValueMemberS{Value: "receipt-1"}},
+	})
+	assert.NoError(t, err)
+	var receipt Receipt
+	assert.NoError(t, attributevalue.UnmarshalMap(result.Item, &receipt))
+	assert.Equal(t, ReceiptAssigned, receipt.Status)
+	assert.Equal(t, expense.ExpenseID, receipt.ExpenseID)
+
+	// And can't be assigned again
+	statusCode, _ = assignReceipt(t, h, map[string]interface{}{
+		"assignments": []map[string]interface{}{{"itemId": "1", "userIds": []string{"user-1"}}},
+	})
+	assert.Equal(t, http.StatusConflict, statusCode)
+}
+
+func TestAssignReceiptHandlerInvalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		assignments []map[string]interface{}
+		message     string
+	}{
+		{"Unassigned", []map[string]interface{}{
+			{"itemId": "1", "userIds": []string{"user-1"}},
+			{"itemId": "2", "userIds": []string{"user-2"}},
+		}, errItemUnassigned.Error()},
+		{"AssignedTwice", []map[string]interface{}{
+			{"itemId": "1", "userIds": []string{"user-1"}},
+			{"itemId": "1", "userIds": []string{"user-2"}},
+		}, errItemAssigned.Error()},
+		{"UnknownItem", []map[string]interface{}{{"itemId": "9", "userIds": []string{"user-1"}}}, errUnknownItem.Error()},
+		{"NotMember", []map[string]interface{}{
+			{"itemId": "1", "userIds": []string{"user-1"}},
+			{"itemId": "2", "userIds": []string{"user-3"}},
+			{"itemId": "3", "userIds": []string{"user-1"}},
+		}, errNotMember.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newReceiptsFake(t)
+			statusCode, body := assignReceipt(t, h, map[string]interface{}{"assignments": tt.assignments})
+			assert.Equal(t, http.StatusBadRequest, statusCode)
+			assert.Contains(t, body, tt.message)
+		})
+	}
+}
+
+func TestAttachReceiptHandler(t *testing.T) {
+	h, fake := newReceiptsFake(t)
+	for _, expenseId := range []string{"expense-1", "expense-2"} {
+		item, err := attributevalue.MarshalMap(FinancialExpense{
+			ExpenseID: expenseId, GroupID: "test-group-id", Title: "Dinner", Amount: "5.5", Currency: "EUR", PaidBy: "user-1",
+		})
+		assert.NoError(t, err)
+		_, err = fake.PutItem(context.TODO(), &dynamodb.PutItemInput{TableName: aws.String("splitter-expenses"), Item: item})
+		assert.NoError(t, err)
+	}
+	attach := func(expenseId string) (int, string) {
+		response, err := h.AttachReceiptHandler(testutil.NewRequest("PUT", "").
+			WithClaims("user-2", "bob").
+			WithPathParam("groupId", "test-group-id").
+			WithPathParam("expenseId", expenseId).
+			WithJSONBody(t, ReceiptAttachment{ReceiptID: "receipt-1"}).
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode, response.Body
+	}
+
+	// The total of 55 on the receipt catches the missing digit of the expense
+	statusCode, body := attach("expense-1")
+	assert.Equal(t, http.StatusOK, statusCode)
+	var expense FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(body), &expense))
+	assert.Equal(t, "receipt-1", expense.ReceiptID)
+	assert.Equal(t, []ReceiptWarning{{Field: "amount", Expense: "5.5", Receipt: "55", Message: "The receipt total is 55, not 5.5"}}, expense.ReceiptWarnings)
+
+	// The warning is kept with the expense
+	response, err := h.GetExpenseHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", "expense-1").
+		Build())
+	assert.NoError(t, err)
+	assert.Contains(t, response.Body, `"receiptWarnings":[{"field":"amount"`)
+
+	// A receipt is attached to a single expense
+	statusCode, _ = attach("expense-2")
+	assert.Equal(t, http.StatusConflict, statusCode)
+	statusCode, _ = attach("expense-1")
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestReceiptMismatches(t *testing.T) {
+	receipt := Receipt{Currency: "usd", Total: "12.00"}
+	assert.Empty(t, receiptMismatches(FinancialExpense{Amount: "12", Currency: "USD"}, receipt))
+	assert.Equal(t, []ReceiptWarning{{Field: "currency", Expense: "EUR", Receipt: "USD", Message: "The receipt is in USD, not EUR"}},
+		receiptMismatches(FinancialExpense{Amount: "21", Currency: "EUR"}, receipt))
+	assert.Empty(t, receiptMismatches(FinancialExpense{Amount: "21", Currency: "USD"}, Receipt{Currency: "USD"}))
+}
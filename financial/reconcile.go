@@ -0,0 +1,249 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MemberBalance is what a member is owed in a currency, negative when they owe.
+type MemberBalance struct {
+	UserID   string      `json:"userId" dynamodbav:"userId"`
+	Currency string      `json:"currency" dynamodbav:"currency"`
+	Amount   json.Number `json:"amount" dynamodbav:"amount"`
+}
+
+// GroupBalances struct for the splitter-group-balances table, the materialized balances of
+// the members of a group.
+type GroupBalances struct {
+	GroupID    string          `json:"groupId" dynamodbav:"groupId"`
+	Balances   []MemberBalance `json:"balances" dynamodbav:"balances"`
+	ComputedAt string          `json:"computedAt" dynamodbav:"computedAt"`
+	Version    int             `json:"version,omitempty" dynamodbav:"version,omitempty"`
+}
+
+// BalanceDrift is a materialized balance differing from the one recomputed from the expenses.
+type BalanceDrift struct {
+	GroupID  string
+	UserID   string
+	Currency string
+	Expected string
+	Stored   string
+}
+
+// ReconcileResult summarizes a reconciliation run.
+type ReconcileResult struct {
+	Groups  int
+	Drifted int
+	Drifts  int
+	Healed  int
+}
+
+// getGroupBalances returns the materialized balances of the group, or nil if there are none.
+func (h *Handlers) getGroupBalances(ctx context.Context, groupId string) (*GroupBalances, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-group-balances"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var balances GroupBalances
+	err = attributevalue.UnmarshalMap(result.Item, &balances)
+	if err != nil {
+		return nil, err
+	}
+	return &balances, nil
+}
+
+// listGroupIds returns the IDs of every group with members, leaving out the groups waiting
+// to be purged.
+func (h *Handlers) listGroupIds(ctx context.Context) ([]string, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName:                aws.String("splitter-group-members"),
+		ProjectionExpression:     aws.String("groupId, #status"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+	}
+
+	seen := map[string]struct{}{}
+	for {
+		result, err := h.client.Scan(ctx, scanInput)
+		if err != nil {
+			return nil, err
+		}
+		var members []GroupMember
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &members)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.Status != GroupDeletedPending {
+				seen[member.GroupID] = struct{}{}
+			}
+		}
+
+		scanInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(scanInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+
+	groupIds := make([]string, 0, len(seen))
+	for groupId := range seen {
+		groupIds = append(groupIds, groupId)
+	}
+	sort.Strings(groupIds)
+	return groupIds, nil
+}
+
+// memberBalances converts the balances computed from the expenses into records, sorted by
+// member and currency and without the settled ones.
+func memberBalances(balances map[debtKey]*big.Rat) []MemberBalance {
+	records := make([]MemberBalance, 0, len(balances))
+	for key, amount := range balances {
+		if amount.Sign() == 0 {
+			continue
+		}
+		records = append(records, MemberBalance{UserID: key.userId, Currency: key.currency, Amount: json.Number(amount.FloatString(2))})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].UserID != records[j].UserID {
+			return records[i].UserID < records[j].UserID
+		}
+		return records[i].Currency < records[j].Currency
+	})
+	return records
+}
+
+// diffBalances returns the balances of the stored records differing from the expected ones,
+// comparing the amounts as numbers. Missing balances are zero.
+func diffBalances(groupId string, expected, stored []MemberBalance) []BalanceDrift {
+	type entry struct{ expected, stored string }
+	entries := map[debtKey]*entry{}
+	for _, balance := range expected {
+		entries[debtKey{balance.UserID, balance.Currency}] = &entry{expected: string(balance.Amount), stored: "0"}
+	}
+	for _, balance := range stored {
+		key := debtKey{balance.UserID, balance.Currency}
+		if entries[key] == nil {
+			entries[key] = &entry{expected: "0"}
+		}
+		entries[key].stored = string(balance.Amount)
+	}
+
+	var drifts []BalanceDrift
+	for key, entry := range entries {
+		want, _ := new(big.Rat).SetString(entry.expected)
+		got, ok := new(big.Rat).SetString(entry.stored)
+		if ok && want.Cmp(got) == 0 {
+			continue
+		}
+		drifts = append(drifts, BalanceDrift{GroupID: groupId, UserID: key.userId, Currency: key.currency, Expected: entry.expected, Stored: entry.stored})
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].UserID != drifts[j].UserID {
+			return drifts[i].UserID < drifts[j].UserID
+		}
+		return drifts[i].Currency < drifts[j].Currency
+	})
+	return drifts
+}
+
+// reconcileGroup recomputes the balances of the group from its expenses and settlements and
+// compares them with the materialized ones. When healing, drifted or missing balances are
+// replaced with the recomputed ones; it returns whether they were.
+func (h *Handlers) reconcileGroup(ctx context.Context, groupId string, heal bool, now time.Time) ([]BalanceDrift, bool, error) {
+	settings, err := h.getGroupSettings(ctx, groupId)
+	if err != nil {
+		return nil, false, err
+	}
+	expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	expected := memberBalances(groupBalances(expenses, settings.DefaultCurrency))
+
+	stored, err := h.getGroupBalances(ctx, groupId)
+	if err != nil {
+		return nil, false, err
+	}
+	var drifts []BalanceDrift
+	expectedVersion := 0
+	if stored != nil {
+		drifts = diffBalances(groupId, expected, stored.Balances)
+		expectedVersion = stored.Version
+		if len(drifts) == 0 {
+			return nil, false, nil
+		}
+	}
+	if !heal {
+		return drifts, false, nil
+	}
+
+	// An expense written while the group was reconciled fails the write, the next run
+	// reconciles the group again
+	balances := GroupBalances{GroupID: groupId, Balances: expected, ComputedAt: now.Format(time.RFC3339), Version: expectedVersion + 1}
+	err = common.ConditionalPutItem(ctx, h.client, "splitter-group-balances", balances, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		log.Printf("Balances of group %s changed while reconciling, skipping", groupId)
+		return drifts, false, nil
+	}
+	if err != nil {
+		return drifts, false, err
+	}
+	return drifts, true, nil
+}
+
+// ReconcileBalances recomputes the balances of every group and logs the ones drifting from
+// the materialized balances. When healing, the materialized balances are rewritten from the
+// recomputed ones, including those of the groups without any yet.
+func (h *Handlers) ReconcileBalances(ctx context.Context, heal bool, now time.Time) (ReconcileResult, error) {
+	var result ReconcileResult
+	groupIds, err := h.listGroupIds(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for _, groupId := range groupIds {
+		drifts, healed, err := h.reconcileGroup(ctx, groupId, heal, now)
+		if err != nil {
+			return result, err
+		}
+		result.Groups++
+		for _, drift := range drifts {
+			log.Printf("Warning: balance drift in group %s for user %s in %s: expected %s, stored %s", drift.GroupID, drift.UserID, drift.Currency, drift.Expected, drift.Stored)
+		}
+		if len(drifts) > 0 {
+			result.Drifted++
+			result.Drifts += len(drifts)
+		}
+		if healed {
+			result.Healed++
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package financial
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newReconcileFake seeds two groups with an expense paid by user-1 and shared with user-2:
+// the balances of group-1 are materialized correctly, those of group-2 drifted.
+func newReconcileFake(t *testing.T) *Handlers {
+	expense := func(groupId string) map[string]interface{} {
+		return map[string]interface{}{
+			"groupId": groupId, "expenseId": "expense-1", "amount": "60", "paidBy": "user-1", "currency": "EUR",
+			"dateTime": "2024-01-01T00:00:00Z", "participants": []map[string]interface{}{
+				{"userId": "user-1", "share": "50.00", "calculatedMoney": "30.00"},
+				{"userId": "user-2", "share": "50.00", "calculatedMoney": "30.00"},
+			},
+		}
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "group-1"},
+			{"userId": "user-2", "groupId": "group-1"},
+			{"userId": "user-1", "groupId": "group-2"},
+			{"userId": "user-2", "groupId": "group-2"},
+			{"userId": "user-1", "groupId": "group-3", "status": GroupDeletedPending},
+		},
+		"splitter-expenses": {expense("group-1"), expense("group-2")},
+		"splitter-group-balances": {
+			{"groupId": "group-1", "version": 1, "balances": []map[string]interface{}{
+				{"userId": "user-1", "currency": "EUR", "amount": "30.00"},
+				{"userId": "user-2", "currency": "EUR", "amount": "-30.00"},
+			}},
+			{"groupId": "group-2", "version": 1, "balances": []map[string]interface{}{
+				{"userId": "user-1", "currency": "EUR", "amount": "45.00"},
+				{"userId": "user-2", "currency": "EUR", "amount": "-30"},
+			}},
+		},
+	})
+	assert.NoError(t, err)
+	return NewHandlers(fake)
+}
+
+func TestReconcileBalances(t *testing.T) {
+	h := newReconcileFake(t)
+
+	// Detecting only leaves the drifted balances as they are
+	result, err := h.ReconcileBalances(context.TODO(), false, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileResult{Groups: 2, Drifted: 1, Drifts: 1}, result)
+
+	// Healing rewrites them, after which nothing drifts
+	result, err = h.ReconcileBalances(context.TODO(), true, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileResult{Groups: 2, Drifted: 1, Drifts: 1, Healed: 1}, result)
+
+	balances, err := h.getGroupBalances(context.TODO(), "group-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, balances.Version)
+	assert.Equal(t, []MemberBalance{
+		{UserID: "user-1", Currency: "EUR", Amount: "30.00"},
+		{UserID: "user-2", Currency: "EUR", Amount: "-30.00"},
+	}, balances.Balances)
+
+	result, err = h.ReconcileBalances(context.TODO(), true, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ReconcileResult{Groups: 2}, result)
+}
+
+func TestDiffBalances(t *testing.T) {
+	drifts := diffBalances("group-1",
+		[]MemberBalance{{UserID: "user-1", Currency: "EUR", Amount: "10.00"}},
+		[]MemberBalance{{UserID: "user-1", Currency: "EUR", Amount: "10"}, {UserID: "user-2", Currency: "USD", Amount: "5.00"}},
+	)
+	assert.Equal(t, []BalanceDrift{{GroupID: "group-1", UserID: "user-2", Currency: "USD", Expected: "0", Stored: "5.00"}}, drifts)
+}
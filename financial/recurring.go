@@ -0,0 +1,471 @@
+package financial
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// The statuses of the recurring expense suggestions
+const (
+	SuggestionPending   = "PENDING"
+	SuggestionAccepted  = "ACCEPTED"
+	SuggestionDismissed = "DISMISSED"
+)
+
+const (
+	// suggestionLookback is how far back the history of a group is analyzed.
+	suggestionLookback = 200 * 24 * time.Hour
+	// minSuggestionOccurrences is the number of months in a row an expense must have been
+	// added for to be suggested as recurring.
+	minSuggestionOccurrences = 3
+)
+
+// RecurringExpense struct for the splitter-recurring-expenses table, an expense a group has
+// every month on the same day.
+type RecurringExpense struct {
+	GroupID      string        `json:"groupId" dynamodbav:"groupId"`
+	RecurringID  string        `json:"recurringId" dynamodbav:"recurringId"`
+	Title        string        `json:"title" dynamodbav:"title"`
+	Category     string        `json:"category,omitempty" dynamodbav:"category,omitempty"`
+	Amount       json.Number   `json:"amount" dynamodbav:"amount"`
+	Currency     string        `json:"currency" dynamodbav:"currency"`
+	PaidBy       string        `json:"paidBy" dynamodbav:"paidBy"`
+	SplitType    string        `json:"splitType,omitempty" dynamodbav:"splitType,omitempty"`
+	Participants []Participant `json:"participants" dynamodbav:"participants"`
+	DayOfMonth   int           `json:"dayOfMonth" dynamodbav:"dayOfMonth"`
+	NextDueAt    string        `json:"nextDueAt" dynamodbav:"nextDueAt"`
+	SuggestionID string        `json:"suggestionId,omitempty" dynamodbav:"suggestionId,omitempty"` // the suggestion it was accepted from
+	CreatedBy    string        `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt    string        `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// RecurringSuggestionItem struct for the splitter-recurring-suggestions table, an expense
+// found added about every month in the history of a group, which the members can accept as
+// a recurring expense. The suggestion ID derives from the title, currency and amount, so the
+// same pattern is suggested once, whether accepted or dismissed.
+type RecurringSuggestionItem struct {
+	GroupID        string           `json:"groupId" dynamodbav:"groupId"`
+	SuggestionID   string           `json:"suggestionId" dynamodbav:"suggestionId"`
+	Status         string           `json:"status" dynamodbav:"status"`
+	Expense        RecurringExpense `json:"expense" dynamodbav:"expense"` // the recurring expense created when accepted
+	Occurrences    int              `json:"occurrences" dynamodbav:"occurrences"`
+	LastOccurredAt string           `json:"lastOccurredAt" dynamodbav:"lastOccurredAt"`
+	DetectedAt     string           `json:"detectedAt" dynamodbav:"detectedAt"`
+	RecurringID    string           `json:"recurringId,omitempty" dynamodbav:"recurringId,omitempty"`
+}
+
+// RecurringDetectionResult counts what a run of DetectRecurringExpenses did.
+type RecurringDetectionResult struct {
+	Groups    int
+	Suggested int
+}
+
+// recurringKey identifies the expenses of a pattern: the same title, currency and amount.
+type recurringKey struct{ title, currency, amount string }
+
+func (k recurringKey) suggestionId() string {
+	sum := sha256.Sum256([]byte(k.title + "\x00" + k.currency + "\x00" + k.amount))
+	return hex.EncodeToString(sum[:8])
+}
+
+// detectRecurring returns the expenses of the group added every month, with the same title,
+// currency and amount, for at least minSuggestionOccurrences months in a row up to the last
+// month. The suggested recurring expense copies the last occurrence, due a month after it.
+func detectRecurring(groupId string, expenses []FinancialExpense, defaultCurrency string, now time.Time) []RecurringSuggestionItem {
+	type occurrence struct {
+		at      time.Time
+		expense FinancialExpense
+	}
+	series := map[recurringKey][]occurrence{}
+	for _, expense := range expenses {
+		at, err := time.Parse(time.RFC3339, expense.DateTime)
+		if err != nil || at.After(now) || now.Sub(at) > suggestionLookback || isDisputed(expense) {
+			continue
+		}
+		amount, ok := new(big.Rat).SetString(expense.Amount.String())
+		if !ok {
+			continue
+		}
+		currency := expense.Currency
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		k := recurringKey{strings.ToLower(strings.TrimSpace(expense.Title)), currency, amount.FloatString(2)}
+		series[k] = append(series[k], occurrence{at, expense})
+	}
+
+	var suggestions []RecurringSuggestionItem
+	for k, occurrences := range series {
+		if len(occurrences) < minSuggestionOccurrences {
+			continue
+		}
+		sort.Slice(occurrences, func(i, j int) bool { return occurrences[i].at.Before(occurrences[j].at) })
+
+		// Count the months in a row ending with the last occurrence, which must be recent
+		// enough for the pattern to still hold
+		last := occurrences[len(occurrences)-1]
+		if now.Sub(last.at) > maxRecurringGap {
+			continue
+		}
+		run := 1
+		for i := len(occurrences) - 1; i > 0; i-- {
+			gap := occurrences[i].at.Sub(occurrences[i-1].at)
+			if gap < minRecurringGap || gap > maxRecurringGap {
+				break
+			}
+			run++
+		}
+		if run < minSuggestionOccurrences {
+			continue
+		}
+
+		participants := make([]Participant, 0, len(last.expense.Participants))
+		for _, participant := range last.expense.Participants {
+			participants = append(participants, Participant{UserID: participant.UserID, Share: participant.Share})
+		}
+		suggestions = append(suggestions, RecurringSuggestionItem{
+			GroupID:      groupId,
+			SuggestionID: k.suggestionId(),
+			Status:       SuggestionPending,
+			Expense: RecurringExpense{
+				GroupID:      groupId,
+				Title:        strings.TrimSpace(last.expense.Title),
+				Category:     last.expense.Category,
+				Amount:       json.Number(k.amount),
+				Currency:     k.currency,
+				PaidBy:       last.expense.PaidBy,
+				SplitType:    last.expense.SplitType,
+				Participants: participants,
+				DayOfMonth:   last.at.Day(),
+				NextDueAt:    last.at.AddDate(0, 1, 0).Format(time.RFC3339),
+			},
+			Occurrences:    run,
+			LastOccurredAt: last.at.Format(time.RFC3339),
+			DetectedAt:     now.Format(time.RFC3339),
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].Expense.NextDueAt < suggestions[j].Expense.NextDueAt })
+	return suggestions
+}
+
+// queryRecurringSuggestions returns every suggestion made to the group, whatever its status.
+func (h *Handlers) queryRecurringSuggestions(ctx context.Context, groupId string) ([]RecurringSuggestionItem, error) {
+	items, err := h.queryGroupItems(ctx, "splitter-recurring-suggestions", groupId)
+	if err != nil {
+		return nil, err
+	}
+	suggestions := []RecurringSuggestionItem{}
+	err = attributevalue.UnmarshalListOfMaps(items, &suggestions)
+	return suggestions, err
+}
+
+// queryRecurringExpenses returns the recurring expenses of the group.
+func (h *Handlers) queryRecurringExpenses(ctx context.Context, groupId string) ([]RecurringExpense, error) {
+	items, err := h.queryGroupItems(ctx, "splitter-recurring-expenses", groupId)
+	if err != nil {
+		return nil, err
+	}
+	recurring := []RecurringExpense{}
+	err = attributevalue.UnmarshalListOfMaps(items, &recurring)
+	return recurring, err
+}
+
+// queryGroupItems returns every item of the group in a table partitioned by group ID.
+func (h *Handlers) queryGroupItems(ctx context.Context, table, groupId string) ([]map[string]types.AttributeValue, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	}
+
+	var items []map[string]types.AttributeValue
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+	return items, nil
+}
+
+// suggestRecurringExpenses stores the recurring expenses detected in the history of the
+// group that weren't suggested before, nor match a recurring expense of the group already,
+// returning how many it suggested.
+func (h *Handlers) suggestRecurringExpenses(ctx context.Context, groupId string, now time.Time) (int, error) {
+	settings, err := h.getGroupSettings(ctx, groupId)
+	if err != nil {
+		return 0, err
+	}
+	expenses, err := h.queryGroupExpenses(ctx, groupId)
+	if err != nil {
+		return 0, err
+	}
+	detected := detectRecurring(groupId, expenses, settings.DefaultCurrency, now)
+	if len(detected) == 0 {
+		return 0, nil
+	}
+
+	recurring, err := h.queryRecurringExpenses(ctx, groupId)
+	if err != nil {
+		return 0, err
+	}
+	existing := map[string]bool{}
+	for _, expense := range recurring {
+		existing[strings.ToLower(expense.Title)+"\x00"+expense.Currency] = true
+	}
+
+	suggested := 0
+	for _, suggestion := range detected {
+		if existing[strings.ToLower(suggestion.Expense.Title)+"\x00"+suggestion.Expense.Currency] {
+			continue
+		}
+		err := common.ConditionalPutItem(ctx, h.client, "splitter-recurring-suggestions", suggestion, common.IfNotExists("suggestionId"))
+		if errors.Is(err, common.ErrConditionFailed) {
+			continue
+		}
+		if err != nil {
+			return suggested, err
+		}
+		suggested++
+	}
+	return suggested, nil
+}
+
+// DetectRecurringExpenses analyzes the history of every group and suggests the expenses
+// added every month as recurring expenses.
+func (h *Handlers) DetectRecurringExpenses(ctx context.Context, now time.Time) (RecurringDetectionResult, error) {
+	var result RecurringDetectionResult
+	groupIds, err := h.listGroupIds(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for _, groupId := range groupIds {
+		suggested, err := h.suggestRecurringExpenses(ctx, groupId, now)
+		if err != nil {
+			return result, err
+		}
+		result.Groups++
+		result.Suggested += suggested
+	}
+	return result, nil
+}
+
+func recurringResponse(statusCode int, value interface{}) (events.APIGatewayProxyResponse, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshalling recurring expenses:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// getRecurringMember returns the membership of the caller in the group of the request, or
+// the response rejecting the request.
+func (h *Handlers) getRecurringMember(request events.APIGatewayProxyRequest) (*GroupMember, *events.APIGatewayProxyResponse) {
+	reject := func(statusCode int, message string) (*GroupMember, *events.APIGatewayProxyResponse) {
+		response, _ := common.CreateErrorResponse(statusCode, message)
+		return nil, &response
+	}
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		response, _ := auth.ErrorResponse(err)
+		return nil, &response
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return reject(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if member == nil {
+		return reject(404, "Group not found")
+	}
+	return member, nil
+}
+
+// GetRecurringExpensesHandler lists the recurring expenses of the group.
+func (h *Handlers) GetRecurringExpensesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	member, rejection := h.getRecurringMember(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	recurring, err := h.queryRecurringExpenses(context.TODO(), member.GroupID)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	sort.Slice(recurring, func(i, j int) bool { return recurring[i].NextDueAt < recurring[j].NextDueAt })
+	return recurringResponse(200, recurring)
+}
+
+// GetRecurringSuggestionsHandler lists the recurring expenses suggested to the group from
+// its history and not accepted nor dismissed yet, the soonest due first.
+func (h *Handlers) GetRecurringSuggestionsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	member, rejection := h.getRecurringMember(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	suggestions, err := h.queryRecurringSuggestions(context.TODO(), member.GroupID)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	pending := []RecurringSuggestionItem{}
+	for _, suggestion := range suggestions {
+		if suggestion.Status == SuggestionPending {
+			pending = append(pending, suggestion)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Expense.NextDueAt < pending[j].Expense.NextDueAt })
+	return recurringResponse(200, pending)
+}
+
+// getPendingSuggestion returns the suggestion of the request, or the response rejecting it
+// when it doesn't exist or was accepted or dismissed already.
+func (h *Handlers) getPendingSuggestion(request events.APIGatewayProxyRequest, groupId string) (*RecurringSuggestionItem, *events.APIGatewayProxyResponse) {
+	reject := func(statusCode int, message string) (*RecurringSuggestionItem, *events.APIGatewayProxyResponse) {
+		response, _ := common.CreateErrorResponse(statusCode, message)
+		return nil, &response
+	}
+
+	suggestionId, ok := request.PathParameters["suggestionId"]
+	if !ok || suggestionId == "" {
+		return reject(400, "Suggestion ID is missing")
+	}
+
+	result, err := h.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-recurring-suggestions"),
+		Key: map[string]types.AttributeValue{
+			"groupId":      &types.AttributeValueMemberS{Value: groupId},
+			"suggestionId": &types.AttributeValueMemberS{Value: suggestionId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error getting recurring suggestion from DynamoDB: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if result.Item == nil {
+		return reject(404, "Suggestion not found")
+	}
+	var suggestion RecurringSuggestionItem
+	if err := attributevalue.UnmarshalMap(result.Item, &suggestion); err != nil {
+		log.Printf("Error unmarshalling recurring suggestion: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if suggestion.Status != SuggestionPending {
+		return reject(409, "Suggestion was already "+strings.ToLower(suggestion.Status))
+	}
+	return &suggestion, nil
+}
+
+// AcceptRecurringSuggestionHandler turns a suggestion into a recurring expense of the group.
+// The recurring expense is created and the suggestion marked accepted together, so a
+// suggestion is accepted once.
+func (h *Handlers) AcceptRecurringSuggestionHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	member, rejection := h.getRecurringMember(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+	suggestion, rejection := h.getPendingSuggestion(request, member.GroupID)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	recurring := suggestion.Expense
+	recurring.GroupID = member.GroupID
+	recurring.RecurringID = uuid.New().String()
+	recurring.SuggestionID = suggestion.SuggestionID
+	recurring.CreatedBy = member.UserID
+	recurring.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	suggestion.Status = SuggestionAccepted
+	suggestion.RecurringID = recurring.RecurringID
+
+	err := common.TransactPutItems(context.TODO(), h.client, []common.ConditionalPut{
+		{TableName: "splitter-recurring-expenses", Item: recurring, Condition: common.IfNotExists("recurringId")},
+		{TableName: "splitter-recurring-suggestions", Item: *suggestion, Condition: common.IfEquals("status", SuggestionPending)},
+	})
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Suggestion was already accepted or dismissed")
+	}
+	if err != nil {
+		log.Printf("Error accepting recurring suggestion: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s accepted recurring suggestion %s of group %s as %s", member.UserID, suggestion.SuggestionID, member.GroupID, recurring.RecurringID)
+	return recurringResponse(201, recurring)
+}
+
+// DismissRecurringSuggestionHandler dismisses a suggestion, which isn't made again.
+func (h *Handlers) DismissRecurringSuggestionHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	member, rejection := h.getRecurringMember(request)
+	if rejection != nil {
+		return *rejection, nil
+	}
+	suggestion, rejection := h.getPendingSuggestion(request, member.GroupID)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	suggestion.Status = SuggestionDismissed
+	err := common.ConditionalPutItem(context.TODO(), h.client, "splitter-recurring-suggestions", *suggestion, common.IfEquals("status", SuggestionPending))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Suggestion was already accepted or dismissed")
+	}
+	if err != nil {
+		log.Printf("Error putting recurring suggestion into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s dismissed recurring suggestion %s of group %s", member.UserID, suggestion.SuggestionID, member.GroupID)
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
@@ -0,0 +1,125 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectRecurring(t *testing.T) {
+	now := time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC)
+	monthly := func(title, amount string, days ...string) []FinancialExpense {
+		var expenses []FinancialExpense
+		for _, day := range days {
+			expenses = append(expenses, FinancialExpense{
+				ExpenseID: title + day, Title: title, Amount: json.Number(amount), Currency: "EUR", DateTime: day + "T09:00:00Z", PaidBy: "user-1",
+				Participants: []Participant{{UserID: "user-1", Share: "50", CalculatedMoney: "400"}, {UserID: "user-2", Share: "50", CalculatedMoney: "400"}},
+			})
+		}
+		return expenses
+	}
+
+	var expenses []FinancialExpense
+	expenses = append(expenses, monthly("Rent", "800", "2024-03-05", "2024-04-05", "2024-05-05", "2024-06-05")...)
+	// Twice only, in a row
+	expenses = append(expenses, monthly("Gym", "30", "2024-05-10", "2024-06-10")...)
+	// Monthly until March, no longer
+	expenses = append(expenses, monthly("Magazine", "5", "2024-01-02", "2024-02-02", "2024-03-02")...)
+	// Not every month
+	expenses = append(expenses, monthly("Cinema", "12", "2024-04-01", "2024-04-08", "2024-06-01")...)
+	// The amount changed
+	expenses = append(expenses, monthly("Electricity", "60", "2024-04-15", "2024-05-15")...)
+	expenses = append(expenses, monthly("Electricity", "75", "2024-06-15")...)
+
+	suggestions := detectRecurring("house", expenses, "EUR", now)
+	if assert.Len(t, suggestions, 1) {
+		rent := suggestions[0]
+		assert.Equal(t, 4, rent.Occurrences)
+		assert.Equal(t, "Rent", rent.Expense.Title)
+		assert.Equal(t, json.Number("800.00"), rent.Expense.Amount)
+		assert.Equal(t, 5, rent.Expense.DayOfMonth)
+		assert.Equal(t, "2024-07-05T09:00:00Z", rent.Expense.NextDueAt)
+		assert.Equal(t, []Participant{{UserID: "user-1", Share: "50"}, {UserID: "user-2", Share: "50"}}, rent.Expense.Participants)
+	}
+}
+
+func TestRecurringSuggestions(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house"},
+			{"userId": "user-2", "groupId": "house"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	for _, day := range []string{"2024-04-05", "2024-05-05", "2024-06-05"} {
+		for _, title := range []string{"Rent", "Internet"} {
+			expense := FinancialExpense{GroupID: "house", ExpenseID: title + day, Title: title, Amount: "100", Currency: "EUR", DateTime: day + "T09:00:00Z", PaidBy: "user-1"}
+			assert.NoError(t, common.ConditionalPutItem(context.TODO(), fake, "splitter-expenses", expense, common.IfNotExists("expenseId")))
+		}
+	}
+
+	now := time.Date(2024, 6, 20, 12, 0, 0, 0, time.UTC)
+	result, err := h.DetectRecurringExpenses(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, RecurringDetectionResult{Groups: 1, Suggested: 2}, result)
+
+	call := func(handler func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error), userId, suggestionId string) (int, string) {
+		response, err := handler(testutil.NewRequest("GET", "").
+			WithClaims(userId, userId).
+			WithPathParam("groupId", "house").
+			WithPathParam("suggestionId", suggestionId).
+			Build())
+		assert.NoError(t, err)
+		return response.StatusCode, response.Body
+	}
+
+	statusCode, _ := call(h.GetRecurringSuggestionsHandler, "user-3", "")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	statusCode, body := call(h.GetRecurringSuggestionsHandler, "user-2", "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	var suggestions []RecurringSuggestionItem
+	assert.NoError(t, json.Unmarshal([]byte(body), &suggestions))
+	assert.Len(t, suggestions, 2)
+	ids := map[string]string{}
+	for _, suggestion := range suggestions {
+		ids[suggestion.Expense.Title] = suggestion.SuggestionID
+	}
+
+	// Accepting a suggestion creates the recurring expense, once
+	statusCode, body = call(h.AcceptRecurringSuggestionHandler, "user-2", ids["Rent"])
+	assert.Equal(t, http.StatusCreated, statusCode)
+	var recurring RecurringExpense
+	assert.NoError(t, json.Unmarshal([]byte(body), &recurring))
+	assert.Equal(t, "Rent", recurring.Title)
+	assert.Equal(t, "user-2", recurring.CreatedBy)
+	assert.Equal(t, ids["Rent"], recurring.SuggestionID)
+	statusCode, _ = call(h.AcceptRecurringSuggestionHandler, "user-2", ids["Rent"])
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	statusCode, _ = call(h.DismissRecurringSuggestionHandler, "user-1", ids["Internet"])
+	assert.Equal(t, http.StatusNoContent, statusCode)
+	statusCode, _ = call(h.AcceptRecurringSuggestionHandler, "user-1", ids["Internet"])
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	_, body = call(h.GetRecurringSuggestionsHandler, "user-2", "")
+	assert.JSONEq(t, `[]`, body)
+	_, body = call(h.GetRecurringExpensesHandler, "user-1", "")
+	var definitions []RecurringExpense
+	assert.NoError(t, json.Unmarshal([]byte(body), &definitions))
+	if assert.Len(t, definitions, 1) {
+		assert.Equal(t, recurring.RecurringID, definitions[0].RecurringID)
+	}
+
+	// The accepted and dismissed suggestions aren't made again
+	result, err = h.DetectRecurringExpenses(context.TODO(), now.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Suggested)
+}
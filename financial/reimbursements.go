@@ -0,0 +1,272 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// NotificationReimbursed is sent to the other member of a reimbursement.
+const NotificationReimbursed = "EXPENSES_REIMBURSED"
+
+// reimbursementsTable holds the reimbursements, keyed by groupId and reimbursementId.
+const reimbursementsTable = "splitter-reimbursements"
+
+// Reimbursement struct for the splitter-reimbursements table: the debtor paid the creditor
+// back for the expenses. Unlike the settlements between members, it names the expenses it
+// settles, and they name it back.
+type Reimbursement struct {
+	GroupID         string              `json:"groupId" dynamodbav:"groupId"`
+	ReimbursementID string              `json:"reimbursementId" dynamodbav:"reimbursementId"`
+	FromUserID      string              `json:"fromUserId" dynamodbav:"fromUserId"`
+	ToUserID        string              `json:"toUserId" dynamodbav:"toUserId"`
+	Expenses        []ReimbursedExpense `json:"expenses" dynamodbav:"expenses"`
+	CreatedBy       string              `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt       string              `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// ReimbursedExpense is an expense settled by a reimbursement, and the amount settled.
+type ReimbursedExpense struct {
+	ExpenseID string      `json:"expenseId" dynamodbav:"expenseId"`
+	Title     string      `json:"title" dynamodbav:"title"`
+	Amount    json.Number `json:"amount" dynamodbav:"amount"`
+	Currency  string      `json:"currency" dynamodbav:"currency"`
+}
+
+// ReimbursementRequest struct for the reimburse request body. The debtor is the member
+// sending the request unless fromUserId is given, e.g. by the creditor marking being paid back.
+type ReimbursementRequest struct {
+	FromUserID string   `json:"fromUserId"`
+	ToUserID   string   `json:"toUserId"`
+	ExpenseIDs []string `json:"expenseIds"`
+}
+
+// reimburseExpenses settles everything the debtor owes the creditor on each expense, linking
+// the expenses to the reimbursement.
+func reimburseExpenses(expenses []FinancialExpense, reimbursement *Reimbursement) error {
+	for i := range expenses {
+		expense := &expenses[i]
+		if isDisputed(*expense) {
+			return errExpenseDisputed
+		}
+		index := slices.IndexFunc(expense.Participants, func(participant Participant) bool {
+			return participant.UserID == reimbursement.FromUserID
+		})
+		if index < 0 {
+			return errNothingOutstanding
+		}
+		owed := owedTo(*expense, expense.Participants[index], reimbursement.ToUserID)
+		if owed.Sign() == 0 {
+			return errNothingOutstanding
+		}
+
+		settleParticipant(expense, index, owed, reimbursement.CreatedAt)
+		expense.ReimbursementIDs = append(expense.ReimbursementIDs, reimbursement.ReimbursementID)
+		reimbursement.Expenses = append(reimbursement.Expenses, ReimbursedExpense{
+			ExpenseID: expense.ExpenseID,
+			Title:     expense.Title,
+			Amount:    json.Number(owed.FloatString(2)),
+			Currency:  expense.Currency,
+		})
+	}
+	return nil
+}
+
+func (h *Handlers) ReimburseExpensesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a ReimbursementRequest struct
+	var reimbursementRequest ReimbursementRequest
+	err = json.Unmarshal([]byte(request.Body), &reimbursementRequest)
+	if err != nil || reimbursementRequest.ToUserID == "" || len(reimbursementRequest.ExpenseIDs) == 0 {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if reimbursementRequest.FromUserID == "" {
+		reimbursementRequest.FromUserID = claims.Sub
+	}
+	if reimbursementRequest.FromUserID == reimbursementRequest.ToUserID {
+		return common.CreateErrorResponse(400, errSettleWithThemselves.Error())
+	}
+	expenseIds := slices.Compact(slices.Sorted(slices.Values(reimbursementRequest.ExpenseIDs)))
+	if len(expenseIds) > maxSettlementExpenses {
+		return common.CreateErrorResponse(400, errTooManySettlements.Error())
+	}
+
+	// Only the two members involved can record the reimbursement
+	if claims.Sub != reimbursementRequest.FromUserID && claims.Sub != reimbursementRequest.ToUserID {
+		return common.CreateErrorResponse(403, "Only the debtor or the creditor can record a reimbursement")
+	}
+	member, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	expenses := make([]FinancialExpense, 0, len(expenseIds))
+	for _, expenseId := range expenseIds {
+		expense, err := h.getExpense(ctx, groupId, expenseId)
+		if err != nil {
+			log.Printf("Error getting expense from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if expense == nil {
+			return common.CreateErrorResponse(404, "Expense not found")
+		}
+		expenses = append(expenses, *expense)
+	}
+
+	reimbursement := Reimbursement{
+		GroupID:         groupId,
+		ReimbursementID: uuid.New().String(),
+		FromUserID:      reimbursementRequest.FromUserID,
+		ToUserID:        reimbursementRequest.ToUserID,
+		CreatedBy:       claims.Sub,
+		CreatedAt:       time.Now().Format(time.RFC3339),
+	}
+	err = reimburseExpenses(expenses, &reimbursement)
+	if errors.Is(err, errNothingOutstanding) || errors.Is(err, errExpenseDisputed) {
+		return common.CreateErrorResponse(409, err.Error())
+	}
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Store the reimbursement and the expenses it settles together, unless any of them
+	// changed since it was read
+	puts := []common.ConditionalPut{{TableName: reimbursementsTable, Item: reimbursement, Condition: common.IfNotExists("reimbursementId")}}
+	for i := range expenses {
+		expectedVersion := expenses[i].Version
+		expenses[i].Version = expectedVersion + 1
+		puts = append(puts, common.ConditionalPut{TableName: "splitter-expenses", Item: expenses[i], Condition: common.IfVersion(expectedVersion)})
+	}
+	err = common.TransactPutItems(ctx, h.client, puts)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expenses were modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error writing transaction to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Reimbursed %d expenses from user %s to user %s in group %s", len(expenses), reimbursement.FromUserID, reimbursement.ToUserID, groupId)
+
+	// Tell the other member of the reimbursement
+	other := reimbursement.ToUserID
+	if claims.Sub == other {
+		other = reimbursement.FromUserID
+	}
+	userMap, err := h.getUsersByIds(ctx, map[string]struct{}{claims.Sub: {}})
+	if err != nil {
+		log.Printf("Error getting user details from DynamoDB: %v", err)
+	}
+	author := userMap.User(claims.Sub)
+	authorName := author.ShowableName
+	if authorName == "" {
+		authorName = author.Username
+	}
+	notifyMembers(ctx, []string{other}, NotificationReimbursed, map[string]string{
+		"author":          authorName,
+		"groupId":         groupId,
+		"groupName":       member.GroupName,
+		"count":           strconv.Itoa(len(expenses)),
+		"reimbursementId": reimbursement.ReimbursementID,
+	})
+
+	return reimbursementResponse(201, reimbursement)
+}
+
+func (h *Handlers) GetReimbursementsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(reimbursementsTable),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error querying reimbursements from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	reimbursements := []Reimbursement{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &reimbursements)
+	if err != nil {
+		log.Printf("Error unmarshalling reimbursements: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Latest first
+	slices.SortFunc(reimbursements, func(a, b Reimbursement) int {
+		return strings.Compare(b.CreatedAt, a.CreatedAt)
+	})
+	return reimbursementResponse(200, reimbursements)
+}
+
+func reimbursementResponse(statusCode int, value interface{}) (events.APIGatewayProxyResponse, error) {
+	// Marshal the reimbursements into JSON for the payload
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshalling reimbursements:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
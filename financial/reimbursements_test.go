@@ -0,0 +1,63 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reimburse(t *testing.T, h *Handlers, userId string, body ReimbursementRequest) (int, string) {
+	response, err := h.ReimburseExpensesHandler(testutil.NewRequest("POST", "").
+		WithClaims(userId, userId).
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, body).
+		Build())
+	assert.NoError(t, err)
+	return response.StatusCode, response.Body
+}
+
+func TestReimburseExpensesHandler(t *testing.T) {
+	h, fake := newSettlementsFake(t)
+	notifications.DynamoDbClient = fake
+
+	// Outsiders can't record that others paid back
+	statusCode, _ := reimburse(t, h, "user-3", ReimbursementRequest{FromUserID: "user-2", ToUserID: "user-1", ExpenseIDs: []string{"expense-1"}})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+
+	// Paying back both expenses settles the whole share of each
+	statusCode, body := reimburse(t, h, "user-2", ReimbursementRequest{ToUserID: "user-1", ExpenseIDs: []string{"expense-2", "expense-1"}})
+	assert.Equal(t, http.StatusCreated, statusCode)
+	var reimbursement Reimbursement
+	assert.NoError(t, json.Unmarshal([]byte(body), &reimbursement))
+	assert.Equal(t, "user-2", reimbursement.FromUserID)
+	assert.Equal(t, []ReimbursedExpense{
+		{ExpenseID: "expense-1", Amount: "20.00"},
+		{ExpenseID: "expense-2", Amount: "15.00"},
+	}, reimbursement.Expenses)
+	assert.Equal(t, []string{NotificationReimbursed}, notificationsOf(t, fake, "user-1"))
+
+	// The expenses link back to the reimbursement
+	expense, err := h.getExpense(t.Context(), "test-group-id", "expense-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{reimbursement.ReimbursementID}, expense.ReimbursementIDs)
+	assert.True(t, expense.Participants[1].Settled)
+	assert.Equal(t, 1, expense.Version)
+
+	// Nothing is left to pay back
+	statusCode, _ = reimburse(t, h, "user-1", ReimbursementRequest{FromUserID: "user-2", ToUserID: "user-1", ExpenseIDs: []string{"expense-1"}})
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	response, err := h.GetReimbursementsHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var reimbursements []Reimbursement
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &reimbursements))
+	assert.Equal(t, []Reimbursement{reimbursement}, reimbursements)
+}
@@ -0,0 +1,241 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+	"vassistant-backend/analytics"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// seriesPeriods is the number of periods of each granularity the series covers when no from
+// date is given.
+var seriesPeriods = map[string]int{
+	analytics.ByDay:   30,
+	analytics.ByWeek:  12,
+	analytics.ByMonth: 12,
+}
+
+// maxSeriesPoints bounds the number of periods of a series, a year of days.
+const maxSeriesPoints = 366
+
+// SeriesPoint is the spending of a group over a period of the series, with a total for
+// every currency of the series, zero when nothing was spent in it.
+type SeriesPoint struct {
+	Key      string                 `json:"key"`   // the date the period starts on, e.g. 2024-02-12, or its month, e.g. 2024-02
+	Start    string                 `json:"start"` // when the period starts, in RFC 3339
+	Totals   map[string]json.Number `json:"totals"`
+	Expenses int                    `json:"expenses"`
+}
+
+// GroupSeries is the response of the series endpoint, a point per period from From to To
+// with none missing so the clients can chart it as it is.
+type GroupSeries struct {
+	GroupID       string        `json:"groupId"`
+	Granularity   string        `json:"granularity"`
+	MonthStartDay int           `json:"monthStartDay,omitempty"`
+	TimeZone      string        `json:"timeZone"`
+	From          string        `json:"from"`
+	To            string        `json:"to"`
+	Currencies    []string      `json:"currencies"`
+	Points        []SeriesPoint `json:"points"`
+}
+
+// periodStart returns when the period of the granularity the time falls in starts, at
+// midnight in the time zone of the group. The weeks start on Mondays and the months on the
+// start day of the group.
+func periodStart(t time.Time, granularity string, startDay int, location *time.Location) time.Time {
+	t = t.In(location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+	switch granularity {
+	case analytics.ByWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case analytics.ByMonth:
+		month := fiscalMonth(t, startDay, location)
+		return time.Date(month.Year(), month.Month(), max(startDay, 1), 0, 0, 0, 0, location)
+	default:
+		return day
+	}
+}
+
+// addPeriods moves the start of a period by n periods of the granularity.
+func addPeriods(start time.Time, granularity string, n int) time.Time {
+	switch granularity {
+	case analytics.ByWeek:
+		return start.AddDate(0, 0, 7*n)
+	case analytics.ByMonth:
+		return start.AddDate(0, n, 0)
+	default:
+		return start.AddDate(0, 0, n)
+	}
+}
+
+// periodKey returns the key the analytics bucket of the period starting at start has.
+func periodKey(start time.Time, granularity string) string {
+	if granularity == analytics.ByMonth {
+		return start.Format("2006-01")
+	}
+	return start.Format(time.DateOnly)
+}
+
+// seriesRange returns the range of the series, widened to whole periods. Without a to date
+// it ends with the current period, and without a from date it covers the seriesPeriods
+// periods before to.
+func seriesRange(from, to string, granularity string, startDay int, location *time.Location, now time.Time) (time.Time, time.Time) {
+	end := addPeriods(periodStart(now, granularity, startDay, location), granularity, 1)
+	if to != "" {
+		parsed, _ := time.Parse(time.RFC3339, to)
+		end = periodStart(parsed, granularity, startDay, location)
+		if !end.Equal(parsed) {
+			end = addPeriods(end, granularity, 1)
+		}
+	}
+	start := addPeriods(end, granularity, -seriesPeriods[granularity])
+	if from != "" {
+		parsed, _ := time.Parse(time.RFC3339, from)
+		start = periodStart(parsed, granularity, startDay, location)
+	}
+	return start, end
+}
+
+// groupSeries lays the analytics buckets out as a point per period from start to end, with
+// a total for every currency spent in any of them.
+func groupSeries(buckets []analytics.Bucket, granularity string, start, end time.Time) ([]string, []SeriesPoint) {
+	currencies := []string{}
+	byKey := map[string][]analytics.Bucket{}
+	for _, bucket := range buckets {
+		byKey[bucket.Key] = append(byKey[bucket.Key], bucket)
+		if !slices.Contains(currencies, bucket.Currency) {
+			currencies = append(currencies, bucket.Currency)
+		}
+	}
+	slices.Sort(currencies)
+
+	points := []SeriesPoint{}
+	for period := start; period.Before(end); period = addPeriods(period, granularity, 1) {
+		point := SeriesPoint{
+			Key:    periodKey(period, granularity),
+			Start:  period.Format(time.RFC3339),
+			Totals: map[string]json.Number{},
+		}
+		for _, currency := range currencies {
+			point.Totals[currency] = "0.00"
+		}
+		for _, bucket := range byKey[point.Key] {
+			point.Totals[bucket.Currency] = bucket.Total
+			point.Expenses += bucket.Expenses
+		}
+		points = append(points, point)
+	}
+	return currencies, points
+}
+
+// GetGroupSeriesHandler returns the spending of the group bucketed into days, weeks or
+// months for the charts. It is computed by the analytics domain from the indexed shares,
+// so the expenses aren't read.
+func (h *Handlers) GetGroupSeriesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the granularity, by month by default
+	granularity := request.QueryStringParameters["granularity"]
+	if granularity == "" {
+		granularity = analytics.ByMonth
+	}
+	if !analytics.ValidGranularity(granularity) {
+		return common.CreateErrorResponse(400, "Invalid granularity, expected day, week or month")
+	}
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	// The periods start at midnight in the time zone of the group, and the months on the
+	// day set for it
+	settings, err := h.getGroupSettings(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	location := settings.location()
+	monthStartDay := 0
+	if granularity == analytics.ByMonth {
+		monthStartDay = settings.MonthStartDay
+	}
+
+	// Parse the optional range
+	from, ok := parseAnalyticsDate(request.QueryStringParameters["from"], location)
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid from date")
+	}
+	to, ok := parseAnalyticsDate(request.QueryStringParameters["to"], location)
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid to date")
+	}
+	start, end := seriesRange(from, to, granularity, monthStartDay, location, time.Now())
+	if !start.Before(end) {
+		return common.CreateErrorResponse(400, "The from date must be before the to date")
+	}
+	if addPeriods(start, granularity, maxSeriesPoints).Before(end) {
+		return common.CreateErrorResponse(400, "The range has too many periods, use a coarser granularity")
+	}
+
+	if analytics.DefaultClient == nil {
+		return common.CreateErrorResponse(503, "Analytics are not available")
+	}
+	buckets, err := analytics.DefaultClient.Aggregate(context.TODO(), analytics.Query{
+		GroupID:       groupId,
+		GroupBy:       granularity,
+		From:          start.Format(time.RFC3339),
+		To:            end.Format(time.RFC3339),
+		MonthStartDay: monthStartDay,
+		TimeZone:      location.String(),
+	})
+	if err != nil {
+		log.Printf("Error aggregating expenses in OpenSearch: %v", err)
+		return common.CreateErrorResponse(502, "Analytics could not be computed")
+	}
+	currencies, points := groupSeries(buckets, granularity, start, end)
+
+	// Marshal the series into JSON for the payload
+	payload, err := json.Marshal(GroupSeries{
+		GroupID:       groupId,
+		Granularity:   granularity,
+		MonthStartDay: monthStartDay,
+		TimeZone:      location.String(),
+		From:          start.Format(time.RFC3339),
+		To:            end.Format(time.RFC3339),
+		Currencies:    currencies,
+		Points:        points,
+	})
+	if err != nil {
+		log.Println("Error marshalling series:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,117 @@
+package financial
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vassistant-backend/analytics"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGroupSeriesHandler(t *testing.T) {
+	// Set up the fake DynamoDB and an OpenSearch domain answering a week histogram with
+	// nothing spent in the second week
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members":  {{"userId": "user-1", "groupId": "test-group-id"}},
+		"splitter-group-settings": {{"groupId": "test-group-id", "timeZone": "America/Sao_Paulo", "monthStartDay": 25}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		io.WriteString(w, `{"aggregations":{"groups":{"buckets":[
+			{"key":1,"key_as_string":"2024-01-01","currencies":{"buckets":[{"key":"BRL","total":{"value":42},"expenses":{"value":3}}]}},
+			{"key":2,"key_as_string":"2024-01-15","currencies":{"buckets":[
+				{"key":"BRL","total":{"value":10},"expenses":{"value":1}},
+				{"key":"USD","total":{"value":5.5},"expenses":{"value":1}}
+			]}}
+		]}}}`)
+	}))
+	defer server.Close()
+	analytics.DefaultClient = &analytics.Client{Endpoint: server.URL, HTTPClient: server.Client()}
+	defer func() { analytics.DefaultClient = nil }()
+
+	// The range is widened to whole weeks, from Monday to Monday
+	request := testutil.NewRequest("GET", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithQueryParam("granularity", "week").
+		WithQueryParam("from", "2024-01-03").
+		WithQueryParam("to", "2024-01-20").
+		Build()
+	response, err := h.GetGroupSeriesHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, string(body), `"gte":"2024-01-01T00:00:00-03:00","lt":"2024-01-22T00:00:00-03:00"`)
+	assert.Contains(t, string(body), `"time_zone":"America/Sao_Paulo"`)
+
+	var series GroupSeries
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &series))
+	assert.Equal(t, 0, series.MonthStartDay)
+	assert.Equal(t, []string{"BRL", "USD"}, series.Currencies)
+	assert.Equal(t, []SeriesPoint{
+		{Key: "2024-01-01", Start: "2024-01-01T00:00:00-03:00", Totals: map[string]json.Number{"BRL": "42.00", "USD": "0.00"}, Expenses: 3},
+		{Key: "2024-01-08", Start: "2024-01-08T00:00:00-03:00", Totals: map[string]json.Number{"BRL": "0.00", "USD": "0.00"}},
+		{Key: "2024-01-15", Start: "2024-01-15T00:00:00-03:00", Totals: map[string]json.Number{"BRL": "10.00", "USD": "5.50"}, Expenses: 2},
+	}, series.Points)
+}
+
+func TestSeriesRange(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// The last 12 months of the group starting on the 25th, up to the current one from
+	// February 25th
+	start, end := seriesRange("", "", analytics.ByMonth, 25, time.UTC, now)
+	assert.Equal(t, time.Date(2023, 3, 25, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 3, 25, 0, 0, 0, 0, time.UTC), end)
+	assert.Equal(t, "2023-03", periodKey(start, analytics.ByMonth))
+
+	// A to date starting a period isn't widened
+	start, end = seriesRange("", "2024-03-01T00:00:00Z", analytics.ByDay, 0, time.UTC, now)
+	assert.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), end)
+}
+
+func TestGetGroupSeriesHandlerErrors(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {{"userId": "user-1", "groupId": "test-group-id"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	analytics.DefaultClient = nil
+
+	tests := []struct {
+		name   string
+		user   string
+		query  map[string]string
+		status int
+	}{
+		{"InvalidGranularity", "user-1", map[string]string{"granularity": "hour"}, http.StatusBadRequest},
+		{"InvalidDate", "user-1", map[string]string{"to": "tomorrow"}, http.StatusBadRequest},
+		{"EmptyRange", "user-1", map[string]string{"from": "2024-02-01", "to": "2024-01-01"}, http.StatusBadRequest},
+		{"TooManyDays", "user-1", map[string]string{"granularity": "day", "from": "2022-01-01", "to": "2024-01-01"}, http.StatusBadRequest},
+		{"NotMember", "user-2", nil, http.StatusNotFound},
+		{"NotConfigured", "user-1", nil, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := testutil.NewRequest("GET", "").
+				WithClaims(tt.user, tt.user).
+				WithPathParam("groupId", "test-group-id")
+			for key, value := range tt.query {
+				builder = builder.WithQueryParam(key, value)
+			}
+
+			response, err := h.GetGroupSeriesHandler(builder.Build())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, response.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/fx"
+	"vassistant-backend/i18n"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// GroupSettings struct for the splitter-group-settings table
+type GroupSettings struct {
+	GroupID             string             `json:"groupId" dynamodbav:"groupId"`
+	DefaultSplitType    string             `json:"defaultSplitType" dynamodbav:"defaultSplitType"`
+	DefaultCategory     string             `json:"defaultCategory" dynamodbav:"defaultCategory"`
+	DefaultCurrency     string             `json:"defaultCurrency" dynamodbav:"defaultCurrency"`
+	DefaultParticipants []string           `json:"defaultParticipants" dynamodbav:"defaultParticipants"`
+	Discoverable        bool               `json:"discoverable" dynamodbav:"discoverable"`
+	MonthStartDay       int                `json:"monthStartDay,omitempty" dynamodbav:"monthStartDay,omitempty"` // the day the months of the reports start on, the 1st when unset
+	Formatting          *i18n.NumberFormat `json:"formatting,omitempty" dynamodbav:"formatting,omitempty"`       // how the amounts are displayed, left to the clients when unset
+	TimeZone            string             `json:"timeZone,omitempty" dynamodbav:"timeZone,omitempty"`           // the IANA time zone the expenses are bucketed into days and months in, UTC when unset
+	Version             int                `json:"version" dynamodbav:"version"`
+}
+
+// maxMonthStartDay is the last day a month of the reports can start on, so every calendar
+// month has it.
+const maxMonthStartDay = 28
+
+// location returns the time zone of the group, UTC when unset or unknown.
+func (s GroupSettings) location() *time.Location {
+	if s.TimeZone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		log.Printf("Error loading time zone %s of group %s: %v", s.TimeZone, s.GroupID, err)
+		return time.UTC
+	}
+	return location
+}
+
+// fiscalMonth returns the first day of the calendar month naming the month of the reports the
+// time falls in, in the time zone of the group. With the months starting on the 25th, the
+// month of 2024-01 runs from January 25th to February 24th.
+func fiscalMonth(t time.Time, startDay int, location *time.Location) time.Time {
+	t = t.In(location)
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if t.Day() < startDay {
+		month = month.AddDate(0, -1, 0)
+	}
+	return month
+}
+
+// getGroupSettings returns the settings of the group, or empty settings if none were saved yet.
+func (h *Handlers) getGroupSettings(ctx context.Context, groupId string) (GroupSettings, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-group-settings"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return GroupSettings{}, err
+	}
+
+	settings := GroupSettings{GroupID: groupId}
+	if result.Item == nil {
+		return settings, nil
+	}
+	err = attributevalue.UnmarshalMap(result.Item, &settings)
+	return settings, err
+}
+
+// applyGroupDefaults fills in the split type, category, currency and participants of an expense
+// from the group settings. Without default participants, the expense is split equally
+// between all the group members.
+func (h *Handlers) applyGroupDefaults(ctx context.Context, expense *FinancialExpense) error {
+	settings, err := h.getGroupSettings(ctx, expense.GroupID)
+	if err != nil {
+		return err
+	}
+
+	if expense.SplitType == "" {
+		expense.SplitType = settings.DefaultSplitType
+	}
+	if expense.SplitType == "" {
+		expense.SplitType = "PERCENTAGE"
+	}
+	if expense.Category == "" {
+		expense.Category = settings.DefaultCategory
+	}
+	if expense.Currency == "" {
+		expense.Currency = settings.DefaultCurrency
+	}
+
+	participantIds := settings.DefaultParticipants
+	if len(participantIds) == 0 {
+		participantIds, err = h.getGroupMemberIds(ctx, expense.GroupID)
+		if err != nil {
+			return err
+		}
+	}
+
+	shares := equalShares(len(participantIds))
+	expense.Participants = make([]Participant, 0, len(participantIds))
+	for i, userId := range participantIds {
+		expense.Participants = append(expense.Participants, Participant{UserID: userId, Share: shares[i]})
+	}
+	return nil
+}
+
+// equalShares splits 100 percent into count shares with two decimals, giving the
+// leftover hundredths to the first shares so they always add up to 100.
+func equalShares(count int) []json.Number {
+	if count == 0 {
+		return nil
+	}
+	basisPoints := 10000 / count
+	leftover := 10000 - basisPoints*count
+
+	shares := make([]json.Number, count)
+	for i := range shares {
+		share := basisPoints
+		if i < leftover {
+			share++
+		}
+		shares[i] = json.Number(fmt.Sprintf("%d.%02d", share/100, share%100))
+	}
+	return shares
+}
+
+func (h *Handlers) GetGroupSettingsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Only members can see the group settings
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	settings, err := h.getGroupSettings(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the settings into JSON for the payload
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		log.Println("Error marshalling group settings:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) PutGroupSettingsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a GroupSettings struct
+	var settings GroupSettings
+	err = json.Unmarshal([]byte(request.Body), &settings)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if settings.DefaultSplitType != "" && !slices.Contains(splitTypes, settings.DefaultSplitType) {
+		return common.CreateErrorResponse(400, "Invalid default split type")
+	}
+	if settings.DefaultCategory != "" && !slices.Contains(expenseCategories, settings.DefaultCategory) {
+		return common.CreateErrorResponse(400, "Invalid default category")
+	}
+	if settings.DefaultCurrency != "" && !fx.ValidCurrency(settings.DefaultCurrency) {
+		return common.CreateErrorResponse(400, "Invalid default currency")
+	}
+	if settings.MonthStartDay < 0 || settings.MonthStartDay > maxMonthStartDay {
+		return common.CreateErrorResponse(400, "Invalid month start day, expected 1 to 28")
+	}
+	if settings.Formatting != nil {
+		if err := settings.Formatting.Validate(); err != nil {
+			return common.CreateErrorResponse(400, "Invalid formatting: "+err.Error())
+		}
+	}
+	if settings.TimeZone != "" {
+		if _, err := time.LoadLocation(settings.TimeZone); err != nil || settings.TimeZone == "Local" {
+			return common.CreateErrorResponse(400, "Invalid time zone, expected an IANA time zone like America/Sao_Paulo")
+		}
+	}
+
+	// Only members can change the group settings
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	// Default participants must be members of the group
+	if len(settings.DefaultParticipants) > 0 {
+		memberIds, err := h.getGroupMemberIds(context.TODO(), groupId)
+		if err != nil {
+			log.Printf("Error querying group members from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		for _, userId := range settings.DefaultParticipants {
+			if !slices.Contains(memberIds, userId) {
+				return common.CreateErrorResponse(400, "Default participant is not a group member")
+			}
+		}
+	}
+
+	// Save the settings, only if nobody changed them since the client read them
+	expectedVersion := settings.Version
+	settings.GroupID = groupId
+	settings.Version = expectedVersion + 1
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-group-settings", settings, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Group settings were changed by someone else")
+	}
+	if err != nil {
+		log.Printf("Error putting group settings into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Successfully updated settings of group %s to version %d", groupId, settings.Version)
+
+	// Marshal the settings into JSON for the payload
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		log.Println("Error marshalling group settings:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,395 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxSettlementExpenses bounds how many expenses a settlement between two members can touch,
+// as they are all updated in a single transaction.
+const maxSettlementExpenses = 25
+
+var (
+	errInvalidSettlement    = errors.New("Invalid settlement amount")
+	errSettlementTooLarge   = errors.New("Amount exceeds the outstanding debt")
+	errNothingOutstanding   = errors.New("Nothing outstanding to settle")
+	errTooManySettlements   = errors.New("Settlement spans too many expenses")
+	errSettleWithThemselves = errors.New("The payer has nothing to settle with themselves")
+)
+
+// ExpenseSettlement struct for the settle expense request body. Without an amount, the whole
+// outstanding share of the participant is settled.
+type ExpenseSettlement struct {
+	UserID string      `json:"userId"`
+	Amount json.Number `json:"amount"`
+}
+
+// MemberSettlement struct for the settle between members request body: the debtor paid the
+// amount back to the creditor.
+type MemberSettlement struct {
+	FromUserID string      `json:"fromUserId"`
+	ToUserID   string      `json:"toUserId"`
+	Amount     json.Number `json:"amount"`
+}
+
+// clearSettlements drops any settlement state from a new expense.
+func clearSettlements(expense *FinancialExpense) {
+	expense.Version = 0
+	expense.ReimbursementIDs = nil
+	for i := range expense.Participants {
+		expense.Participants[i].SettledAmount = ""
+		expense.Participants[i].Settled = false
+		expense.Participants[i].SettledAt = ""
+	}
+}
+
+// parseMoney parses an amount of money; an empty amount parses to nil.
+func parseMoney(amount json.Number) (*big.Rat, error) {
+	if amount == "" {
+		return nil, nil
+	}
+	value, ok := new(big.Rat).SetString(string(amount))
+	if !ok || value.Sign() <= 0 {
+		return nil, errInvalidSettlement
+	}
+	return value, nil
+}
+
+// outstanding returns what the participant still owes to the payers of the expense: their
+// share, less what they paid of the expense themselves and what they settled already.
+func outstanding(expense FinancialExpense, participant Participant) *big.Rat {
+	owed, ok := new(big.Rat).SetString(string(participant.CalculatedMoney))
+	if !ok {
+		return new(big.Rat)
+	}
+	owed.Sub(owed, paidAmount(expense, participant.UserID))
+	if settled, ok := new(big.Rat).SetString(string(participant.SettledAmount)); ok {
+		owed.Sub(owed, settled)
+	}
+	if owed.Sign() < 0 {
+		return new(big.Rat)
+	}
+	return owed
+}
+
+// settleParticipant records that the participant paid the amount of their share back to the
+// payers, marking the share as settled once nothing is outstanding.
+func settleParticipant(expense *FinancialExpense, index int, amount *big.Rat, settledAt string) {
+	participant := &expense.Participants[index]
+	settled, ok := new(big.Rat).SetString(string(participant.SettledAmount))
+	if !ok {
+		settled = new(big.Rat)
+	}
+	settled.Add(settled, amount)
+
+	participant.SettledAmount = json.Number(settled.FloatString(2))
+	participant.SettledAt = settledAt
+	participant.Settled = outstanding(*expense, *participant).Sign() == 0
+}
+
+// getExpense returns the expense of the group, or nil if there is none.
+func (h *Handlers) getExpense(ctx context.Context, groupId, expenseId string) (*FinancialExpense, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-expenses"),
+		Key: map[string]types.AttributeValue{
+			"groupId":   &types.AttributeValueMemberS{Value: groupId},
+			"expenseId": &types.AttributeValueMemberS{Value: expenseId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var expense FinancialExpense
+	err = attributevalue.UnmarshalMap(result.Item, &expense)
+	if err != nil {
+		return nil, err
+	}
+	normalizePayers(&expense)
+	return &expense, nil
+}
+
+func (h *Handlers) SettleExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and expenseId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	expenseId, ok := request.PathParameters["expenseId"]
+	if !ok || expenseId == "" {
+		return common.CreateErrorResponse(400, "Expense ID is missing")
+	}
+
+	// Parse the request body into an ExpenseSettlement struct
+	var settlement ExpenseSettlement
+	err = json.Unmarshal([]byte(request.Body), &settlement)
+	if err != nil || settlement.UserID == "" {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	amount, err := parseMoney(settlement.Amount)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	expense, err := h.getExpense(context.TODO(), groupId, expenseId)
+	if err != nil {
+		log.Printf("Error getting expense from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if expense == nil {
+		return common.CreateErrorResponse(404, "Expense not found")
+	}
+
+	// Disputed expenses are settled once the dispute is resolved
+	if isDisputed(*expense) {
+		return common.CreateErrorResponse(409, errExpenseDisputed.Error())
+	}
+
+	// Only the members involved can record the settlement
+	if claims.Sub != settlement.UserID && !isPayer(*expense, claims.Sub) {
+		return common.CreateErrorResponse(403, "Only the participant or a payer can settle the expense")
+	}
+	if len(expense.Payers) == 1 && settlement.UserID == expense.PaidBy {
+		return common.CreateErrorResponse(400, errSettleWithThemselves.Error())
+	}
+
+	index := -1
+	for i, participant := range expense.Participants {
+		if participant.UserID == settlement.UserID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return common.CreateErrorResponse(404, "Participant not found")
+	}
+
+	owed := outstanding(*expense, expense.Participants[index])
+	if owed.Sign() == 0 {
+		return common.CreateErrorResponse(409, errNothingOutstanding.Error())
+	}
+	if amount == nil {
+		amount = owed
+	}
+	if amount.Cmp(owed) > 0 {
+		return common.CreateErrorResponse(400, errSettlementTooLarge.Error())
+	}
+	settleParticipant(expense, index, amount, time.Now().Format(time.RFC3339))
+
+	// Store the expense, unless it was changed since it was read
+	expectedVersion := expense.Version
+	expense.Version = expectedVersion + 1
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-expenses", expense, common.IfVersion(expectedVersion))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expense was modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error putting item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Settled %s of user %s on expense %s", amount.FloatString(2), settlement.UserID, expenseId)
+
+	// Marshal the expense into JSON for the payload
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		log.Println("Error marshalling expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// owedTo returns what the participant still owes to the creditor on the expense. When
+// several payers paid beyond their share, the outstanding debt is owed to each of them in
+// proportion to what they are owed, rounded to cents.
+func owedTo(expense FinancialExpense, participant Participant, creditor string) *big.Rat {
+	credit := credits(expense)
+	if _, ok := credit[creditor]; !ok || participant.UserID == creditor {
+		return new(big.Rat)
+	}
+	owed := outstanding(expense, participant)
+	if len(credit) == 1 {
+		return owed
+	}
+
+	totalCredit := new(big.Rat)
+	for _, value := range credit {
+		totalCredit.Add(totalCredit, value)
+	}
+	owed.Mul(owed, credit[creditor]).Quo(owed, totalCredit)
+	cents := roundRat(owed.Mul(owed, big.NewRat(100, 1)))
+	return new(big.Rat).SetFrac(cents, big.NewInt(100))
+}
+
+// settleBetweenMembers applies a payment of the debtor to the creditor onto the expenses the
+// creditor paid for, oldest first, and returns the expenses that changed. Disputed expenses
+// are left out until their dispute is resolved.
+func settleBetweenMembers(expenses []FinancialExpense, settlement MemberSettlement, amount *big.Rat, settledAt string) ([]FinancialExpense, error) {
+	remaining := new(big.Rat).Set(amount)
+	var changed []FinancialExpense
+	for _, expense := range expenses {
+		if remaining.Sign() == 0 {
+			break
+		}
+		if !isPayer(expense, settlement.ToUserID) || isDisputed(expense) {
+			continue
+		}
+		for i, participant := range expense.Participants {
+			if participant.UserID != settlement.FromUserID {
+				continue
+			}
+			owed := owedTo(expense, participant, settlement.ToUserID)
+			if owed.Sign() == 0 {
+				continue
+			}
+
+			settled := owed
+			if remaining.Cmp(owed) < 0 {
+				settled = new(big.Rat).Set(remaining)
+			}
+			settleParticipant(&expense, i, settled, settledAt)
+			remaining.Sub(remaining, settled)
+			changed = append(changed, expense)
+			break
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil, errNothingOutstanding
+	}
+	if remaining.Sign() > 0 {
+		return nil, errSettlementTooLarge
+	}
+	if len(changed) > maxSettlementExpenses {
+		return nil, errTooManySettlements
+	}
+	return changed, nil
+}
+
+func (h *Handlers) SettleBetweenMembersHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a MemberSettlement struct
+	var settlement MemberSettlement
+	err = json.Unmarshal([]byte(request.Body), &settlement)
+	if err != nil || settlement.FromUserID == "" || settlement.ToUserID == "" || settlement.Amount == "" {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if settlement.FromUserID == settlement.ToUserID {
+		return common.CreateErrorResponse(400, errSettleWithThemselves.Error())
+	}
+	amount, err := parseMoney(settlement.Amount)
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Only the two members involved can record the settlement
+	if claims.Sub != settlement.FromUserID && claims.Sub != settlement.ToUserID {
+		return common.CreateErrorResponse(403, "Only the debtor or the creditor can record a settlement")
+	}
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	// Get the expenses of the group, oldest first
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-expenses"),
+		IndexName:              aws.String("groupId-dateTime-index"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ScanIndexForward: aws.Bool(true),
+	}
+	expenses, err := h.queryExpenses(context.TODO(), queryInput)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	changed, err := settleBetweenMembers(expenses, settlement, amount, time.Now().Format(time.RFC3339))
+	if errors.Is(err, errNothingOutstanding) {
+		return common.CreateErrorResponse(409, err.Error())
+	}
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	// Store the changed expenses together, unless any of them changed since it was read
+	puts := make([]common.ConditionalPut, len(changed))
+	for i := range changed {
+		expectedVersion := changed[i].Version
+		changed[i].Version = expectedVersion + 1
+		puts[i] = common.ConditionalPut{TableName: "splitter-expenses", Item: changed[i], Condition: common.IfVersion(expectedVersion)}
+	}
+	err = common.TransactPutItems(context.TODO(), h.client, puts)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Expenses were modified concurrently")
+	}
+	if err != nil {
+		log.Printf("Error writing transaction to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("Settled %s from user %s to user %s over %d expenses", amount.FloatString(2), settlement.FromUserID, settlement.ToUserID, len(changed))
+
+	// Marshal the settled expenses into JSON for the payload
+	payload, err := json.Marshal(changed)
+	if err != nil {
+		log.Println("Error marshalling expenses:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
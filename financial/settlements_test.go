@@ -0,0 +1,116 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSettlementsFake seeds two expenses paid by user-1 and shared with user-2.
+func newSettlementsFake(t *testing.T) (*Handlers, *testutil.FakeDynamoDB) {
+	participants := func(owed string) []map[string]interface{} {
+		return []map[string]interface{}{
+			{"userId": "user-1", "share": "50.00", "calculatedMoney": owed},
+			{"userId": "user-2", "share": "50.00", "calculatedMoney": owed},
+		}
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id"},
+			{"userId": "user-2", "groupId": "test-group-id"},
+		},
+		"splitter-expenses": {
+			{"groupId": "test-group-id", "expenseId": "expense-1", "amount": "40", "paidBy": "user-1", "dateTime": "2024-01-01T00:00:00Z", "participants": participants("20.00")},
+			{"groupId": "test-group-id", "expenseId": "expense-2", "amount": "30", "paidBy": "user-1", "dateTime": "2024-01-02T00:00:00Z", "participants": participants("15.00")},
+		},
+	})
+	assert.NoError(t, err)
+	return NewHandlers(fake), fake
+}
+
+// settleExpense settles the share of user-2 on the expense, as user-2.
+func settleExpense(t *testing.T, h *Handlers, expenseId string, body map[string]interface{}) (int, FinancialExpense) {
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("expenseId", expenseId).
+		WithJSONBody(t, body).
+		Build()
+
+	// Call the handler
+	response, err := h.SettleExpenseHandler(request)
+	assert.NoError(t, err)
+
+	var expense FinancialExpense
+	if response.StatusCode == http.StatusOK {
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &expense))
+	}
+	return response.StatusCode, expense
+}
+
+func TestSettleExpenseHandler(t *testing.T) {
+	h, _ := newSettlementsFake(t)
+
+	// Settle part of the share
+	statusCode, expense := settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-2", "amount": "5"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, json.Number("5.00"), expense.Participants[1].SettledAmount)
+	assert.False(t, expense.Participants[1].Settled)
+	assert.Equal(t, 1, expense.Version)
+
+	// More than what's outstanding is refused
+	statusCode, _ = settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-2", "amount": "15.01"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	// Settle the rest, the expense is kept with its history
+	statusCode, expense = settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-2"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, json.Number("20.00"), expense.Participants[1].SettledAmount)
+	assert.True(t, expense.Participants[1].Settled)
+	assert.Equal(t, json.Number("20.00"), expense.Participants[1].CalculatedMoney)
+
+	statusCode, _ = settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-2"})
+	assert.Equal(t, http.StatusConflict, statusCode)
+
+	// The payer has nothing to settle
+	statusCode, _ = settleExpense(t, h, "expense-1", map[string]interface{}{"userId": "user-1"})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+}
+
+func TestSettleBetweenMembersHandler(t *testing.T) {
+	h, _ := newSettlementsFake(t)
+
+	settle := func(amount string) int {
+		request := testutil.NewRequest("POST", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "test-group-id").
+			WithJSONBody(t, map[string]interface{}{"fromUserId": "user-2", "toUserId": "user-1", "amount": amount}).
+			Build()
+		response, err := h.SettleBetweenMembersHandler(request)
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+
+	// More than the outstanding debt of 35 is refused
+	assert.Equal(t, http.StatusBadRequest, settle("35.01"))
+
+	// The payment settles the oldest expense first
+	assert.Equal(t, http.StatusOK, settle("25"))
+
+	first, err := h.getExpense(context.TODO(), "test-group-id", "expense-1")
+	assert.NoError(t, err)
+	assert.True(t, first.Participants[1].Settled)
+	second, err := h.getExpense(context.TODO(), "test-group-id", "expense-2")
+	assert.NoError(t, err)
+	assert.False(t, second.Participants[1].Settled)
+	assert.Equal(t, json.Number("5.00"), second.Participants[1].SettledAmount)
+	assert.Equal(t, "10", outstanding(*second, second.Participants[1]).RatString())
+
+	// Settling what's left clears the debt
+	assert.Equal(t, http.StatusOK, settle("10"))
+	assert.Equal(t, http.StatusConflict, settle("1"))
+}
@@ -0,0 +1,442 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/integrations/sheets"
+	"vassistant-backend/plans"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// sheetLinksTable holds the spreadsheet linked to each group, keyed by groupId.
+const sheetLinksTable = "splitter-sheet-links"
+
+// defaultSheetName is the sheet the expenses are written to when none is given.
+const defaultSheetName = "Expenses"
+
+// spreadsheetIdPattern matches the IDs of the Google spreadsheets, as found in their URLs.
+var spreadsheetIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{20,100}$`)
+
+// sheetHeader is the first row of the exported sheets.
+var sheetHeader = []string{"Date", "Title", "Category", "Amount", "Currency", "Paid by", "Participants", "Notes", "Expense ID", "Place", "Latitude", "Longitude"}
+
+// SheetLink is the Google spreadsheet the expenses of a group are exported to. With AutoSync,
+// every new expense is appended to it. The tokens are those of the admin who linked it.
+type SheetLink struct {
+	GroupID        string `json:"groupId" dynamodbav:"groupId"`
+	SpreadsheetID  string `json:"spreadsheetId" dynamodbav:"spreadsheetId"`
+	SheetName      string `json:"sheetName" dynamodbav:"sheetName"`
+	AutoSync       bool   `json:"autoSync" dynamodbav:"autoSync"`
+	LinkedBy       string `json:"linkedBy" dynamodbav:"linkedBy"`
+	LinkedAt       string `json:"linkedAt" dynamodbav:"linkedAt"`
+	LastSyncedAt   string `json:"lastSyncedAt,omitempty" dynamodbav:"lastSyncedAt,omitempty"`
+	LastError      string `json:"lastError,omitempty" dynamodbav:"lastError,omitempty"`
+	AccessToken    string `json:"-" dynamodbav:"accessToken"`  // encrypted at rest when the encryption is enabled
+	RefreshToken   string `json:"-" dynamodbav:"refreshToken"` // encrypted at rest when the encryption is enabled
+	TokenExpiresAt string `json:"-" dynamodbav:"tokenExpiresAt"`
+}
+
+// SheetLinkRequest links a spreadsheet to a group, with the authorization code the client got
+// from the Google consent screen. The consent must be requested with offline access, so the
+// expenses can be pushed when the admin isn't around.
+type SheetLinkRequest struct {
+	Code          string `json:"code"`
+	RedirectURI   string `json:"redirectUri"`
+	SpreadsheetID string `json:"spreadsheetId"`
+	SheetName     string `json:"sheetName"`
+	AutoSync      bool   `json:"autoSync"`
+}
+
+// getSheetLink returns the spreadsheet linked to the group, or nil when there is none.
+func (h *Handlers) getSheetLink(ctx context.Context, groupId string) (*SheetLink, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(sheetLinksTable),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var link SheetLink
+	if err := attributevalue.UnmarshalMap(result.Item, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (h *Handlers) saveSheetLink(ctx context.Context, link *SheetLink) error {
+	av, err := attributevalue.MarshalMap(link)
+	if err != nil {
+		return err
+	}
+	_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(sheetLinksTable),
+		Item:      av,
+	})
+	return err
+}
+
+// setToken stores the token in the link, without saving it.
+func (l *SheetLink) setToken(token sheets.Token) {
+	l.AccessToken = token.AccessToken
+	l.RefreshToken = token.RefreshToken
+	l.TokenExpiresAt = token.ExpiresAt.UTC().Format(time.RFC3339)
+}
+
+// linkAccessToken returns a valid access token of the link, refreshing and saving it when it
+// expired.
+func (h *Handlers) linkAccessToken(ctx context.Context, l *SheetLink) (string, error) {
+	expiresAt, _ := time.Parse(time.RFC3339, l.TokenExpiresAt)
+	token := sheets.Token{AccessToken: l.AccessToken, RefreshToken: l.RefreshToken, ExpiresAt: expiresAt}
+	if !token.Expired(time.Now()) {
+		return token.AccessToken, nil
+	}
+
+	token, err := sheets.Default.Refresh(ctx, l.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	l.setToken(token)
+	if err := h.saveSheetLink(ctx, l); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// recordSync saves the outcome of a push to the spreadsheet, so the admins can see when the
+// link broke.
+func (h *Handlers) recordSync(ctx context.Context, l *SheetLink, syncErr error) {
+	if syncErr != nil {
+		l.LastError = syncErr.Error()
+		if errors.Is(syncErr, sheets.ErrUnauthorized) {
+			l.LastError = "Google Sheets access was revoked, the spreadsheet must be linked again"
+		}
+	} else {
+		l.LastError = ""
+		l.LastSyncedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	if err := h.saveSheetLink(ctx, l); err != nil {
+		log.Printf("Error putting sheet link into DynamoDB: %v", err)
+	}
+}
+
+// sheetUserName is the name of the user written to the sheets.
+func sheetUserName(user User) string {
+	if user.ShowableName != "" {
+		return user.ShowableName
+	}
+	if user.Username != "" {
+		return user.Username
+	}
+	return user.UserID
+}
+
+// expenseRows converts the expenses into rows of the sheet, naming the users.
+func (h *Handlers) expenseRows(ctx context.Context, expenses []FinancialExpense) ([][]string, error) {
+	userIds := make(map[string]struct{})
+	for _, expense := range expenses {
+		for _, payer := range expense.Payers {
+			userIds[payer.UserID] = struct{}{}
+		}
+		for _, participant := range expense.Participants {
+			userIds[participant.UserID] = struct{}{}
+		}
+	}
+	userMap, err := h.getUsersByIds(ctx, userIds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]string, 0, len(expenses))
+	for _, expense := range expenses {
+		payers := make([]string, 0, len(expense.Payers))
+		for _, payer := range expense.Payers {
+			payers = append(payers, sheetUserName(userMap.User(payer.UserID)))
+		}
+		participants := make([]string, 0, len(expense.Participants))
+		for _, participant := range expense.Participants {
+			participants = append(participants, sheetUserName(userMap.User(participant.UserID))+": "+participant.CalculatedMoney.String())
+		}
+		row := []string{
+			expense.DateTime,
+			expense.Title,
+			expense.Category,
+			expense.Amount.String(),
+			expense.Currency,
+			strings.Join(payers, ", "),
+			strings.Join(participants, "; "),
+			expense.Notes,
+			expense.ExpenseID,
+		}
+		rows = append(rows, append(row, locationColumns(expense.Location)...))
+	}
+	return rows, nil
+}
+
+// syncSheet overwrites the linked sheet with every expense of the group, oldest first, and
+// records the outcome.
+func (h *Handlers) syncSheet(ctx context.Context, link *SheetLink) error {
+	err := func() error {
+		expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String("splitter-expenses"),
+			IndexName:              aws.String("groupId-dateTime-index"),
+			KeyConditionExpression: aws.String("groupId = :groupId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":groupId": &types.AttributeValueMemberS{Value: link.GroupID},
+			},
+			ScanIndexForward: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		rows, err := h.expenseRows(ctx, expenses)
+		if err != nil {
+			return err
+		}
+		accessToken, err := h.linkAccessToken(ctx, link)
+		if err != nil {
+			return err
+		}
+		return sheets.Default.Replace(ctx, accessToken, link.SpreadsheetID, link.SheetName, append([][]string{sheetHeader}, rows...))
+	}()
+	h.recordSync(ctx, link, err)
+	return err
+}
+
+// appendToSheet appends the new expenses to the spreadsheet of the group, when one is linked
+// with AutoSync. The expenses are stored already, so the failures are only logged and
+// recorded in the link.
+func (h *Handlers) appendToSheet(ctx context.Context, groupId string, expenses []FinancialExpense) {
+	if sheets.Default == nil || len(expenses) == 0 {
+		return
+	}
+	link, err := h.getSheetLink(ctx, groupId)
+	if err != nil {
+		log.Printf("Error getting sheet link from DynamoDB: %v", err)
+		return
+	}
+	if link == nil || !link.AutoSync {
+		return
+	}
+
+	err = func() error {
+		rows, err := h.expenseRows(ctx, expenses)
+		if err != nil {
+			return err
+		}
+		accessToken, err := h.linkAccessToken(ctx, link)
+		if err != nil {
+			return err
+		}
+		return sheets.Default.Append(ctx, accessToken, link.SpreadsheetID, link.SheetName, rows)
+	}()
+	if err != nil {
+		log.Printf("Error appending expenses of group %s to its sheet: %v", groupId, err)
+	}
+	h.recordSync(ctx, link, err)
+}
+
+// getSheetLinkMember returns the membership of the caller in the group of the request, or the
+// response rejecting the request. With admin, only the group admins are accepted.
+func (h *Handlers) getSheetLinkMember(request events.APIGatewayProxyRequest, admin bool) (*GroupMember, *events.APIGatewayProxyResponse) {
+	reject := func(statusCode int, message string) (*GroupMember, *events.APIGatewayProxyResponse) {
+		response, _ := common.CreateErrorResponse(statusCode, message)
+		return nil, &response
+	}
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		response, _ := auth.ErrorResponse(err)
+		return nil, &response
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return reject(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return reject(500, "Internal server error")
+	}
+	if member == nil {
+		return reject(404, "Group not found")
+	}
+	if admin && member.Role != RoleAdmin {
+		return reject(403, "Only group admins can manage the linked spreadsheet")
+	}
+	if sheets.Default == nil {
+		return reject(503, "Google Sheets is not available")
+	}
+	return member, nil
+}
+
+func (h *Handlers) PutSheetLinkHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	admin, rejection := h.getSheetLinkMember(request, true)
+	if rejection != nil {
+		return *rejection, nil
+	}
+	err := h.plans.AllowsExport(ctx, admin.UserID, plans.GoogleSheetsExport)
+	var limitErr *plans.LimitError
+	if errors.As(err, &limitErr) {
+		return plans.ErrorResponse(limitErr)
+	}
+	if err != nil {
+		log.Printf("Error checking the plan: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Parse the request body into a SheetLinkRequest struct
+	var linkRequest SheetLinkRequest
+	err = json.Unmarshal([]byte(request.Body), &linkRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if linkRequest.Code == "" || linkRequest.RedirectURI == "" {
+		return common.CreateErrorResponse(400, "code and redirectUri are required")
+	}
+	if !spreadsheetIdPattern.MatchString(linkRequest.SpreadsheetID) {
+		return common.CreateErrorResponse(400, "Invalid spreadsheet ID")
+	}
+	sheetName := strings.TrimSpace(linkRequest.SheetName)
+	if sheetName == "" {
+		sheetName = defaultSheetName
+	}
+
+	token, err := sheets.Default.Exchange(ctx, linkRequest.Code, linkRequest.RedirectURI)
+	if errors.Is(err, sheets.ErrUnauthorized) {
+		return common.CreateErrorResponse(400, "Invalid authorization code")
+	}
+	if err != nil {
+		log.Printf("Error exchanging the Google authorization code: %v", err)
+		return common.CreateErrorResponse(502, "Google Sheets is not responding")
+	}
+	if token.RefreshToken == "" {
+		return common.CreateErrorResponse(400, "The authorization must grant offline access")
+	}
+
+	link := &SheetLink{
+		GroupID:       admin.GroupID,
+		SpreadsheetID: linkRequest.SpreadsheetID,
+		SheetName:     sheetName,
+		AutoSync:      linkRequest.AutoSync,
+		LinkedBy:      admin.UserID,
+		LinkedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+	link.setToken(token)
+
+	// The first export tells right away whether the spreadsheet can be written to
+	if err := h.syncSheet(ctx, link); err != nil {
+		log.Printf("Error exporting expenses of group %s to its sheet: %v", admin.GroupID, err)
+	}
+
+	log.Printf("Successfully linked spreadsheet %s to group %s", link.SpreadsheetID, admin.GroupID)
+	return sheetLinkResponse(201, link)
+}
+
+func (h *Handlers) GetSheetLinkHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	member, rejection := h.getSheetLinkMember(request, false)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	link, err := h.getSheetLink(context.TODO(), member.GroupID)
+	if err != nil {
+		log.Printf("Error getting sheet link from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if link == nil {
+		return common.CreateErrorResponse(404, "No spreadsheet is linked")
+	}
+	return sheetLinkResponse(200, link)
+}
+
+func (h *Handlers) SyncSheetHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	member, rejection := h.getSheetLinkMember(request, false)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	link, err := h.getSheetLink(ctx, member.GroupID)
+	if err != nil {
+		log.Printf("Error getting sheet link from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if link == nil {
+		return common.CreateErrorResponse(404, "No spreadsheet is linked")
+	}
+
+	err = h.syncSheet(ctx, link)
+	if errors.Is(err, sheets.ErrUnauthorized) {
+		return common.CreateErrorResponse(409, link.LastError)
+	}
+	if err != nil {
+		log.Printf("Error exporting expenses of group %s to its sheet: %v", member.GroupID, err)
+		return common.CreateErrorResponse(502, "Google Sheets is not responding")
+	}
+	return sheetLinkResponse(200, link)
+}
+
+func (h *Handlers) DeleteSheetLinkHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	admin, rejection := h.getSheetLinkMember(request, true)
+	if rejection != nil {
+		return *rejection, nil
+	}
+
+	_, err := h.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(sheetLinksTable),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: admin.GroupID},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting sheet link from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+func sheetLinkResponse(statusCode int, link *SheetLink) (events.APIGatewayProxyResponse, error) {
+	// Marshal the link into JSON for the payload
+	payload, err := json.Marshal(link)
+	if err != nil {
+		log.Println("Error marshalling sheet link:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package financial
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"vassistant-backend/integrations/sheets"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSheetLink(t *testing.T) {
+	// Set up the fake DynamoDB with a group whose admin is alice
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+		"splitter-expenses": {{
+			"groupId": "house", "expenseId": "rent", "title": "Rent", "amount": "100", "currency": "EUR", "paidBy": "user-1", "dateTime": "2024-01-01T00:00:00Z",
+			"participants": []map[string]interface{}{{"userId": "user-1", "calculatedMoney": "50"}, {"userId": "user-2", "calculatedMoney": "50"}},
+		}},
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice", "showableName": "Alice"},
+			{"userId": "user-2", "username": "bob", "showableName": "Bob"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Set up Google, recording the rows written to the sheet
+	var written [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "the-code", r.PostForm.Get("code"))
+			io.WriteString(w, `{"access_token":"access","refresh_token":"refresh","expires_in":3600}`)
+			return
+		}
+		assert.Equal(t, "Bearer access", r.Header.Get("Authorization"))
+		path, _ := url.PathUnescape(r.URL.EscapedPath())
+		assert.True(t, strings.HasPrefix(path, "/spreadsheets/sheet-0123456789abcdefghij/values/'Expenses'"), path)
+		var body struct {
+			Values [][]string `json:"values"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case strings.HasSuffix(path, ":clear"):
+			written = nil
+		default:
+			written = append(written, body.Values...)
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer server.Close()
+	sheets.Default = &sheets.Client{TokenURL: server.URL + "/token", BaseURL: server.URL + "/spreadsheets", HTTPClient: server.Client()}
+	defer func() { sheets.Default = nil }()
+
+	// Only the admins link a spreadsheet
+	link := SheetLinkRequest{Code: "the-code", RedirectURI: "app://oauth", SpreadsheetID: "sheet-0123456789abcdefghij", AutoSync: true}
+	response, err := h.PutSheetLinkHandler(testutil.NewRequest("PUT", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, link).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Linking exports the expenses of the group, without returning the tokens
+	response, err = h.PutSheetLinkHandler(testutil.NewRequest("PUT", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, link).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.NotContains(t, response.Body, "refresh")
+	var linked SheetLink
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &linked))
+	assert.NotEmpty(t, linked.LastSyncedAt)
+	assert.Empty(t, linked.LastError)
+	assert.Equal(t, [][]string{
+		sheetHeader,
+		{"2024-01-01T00:00:00Z", "Rent", "", "100", "EUR", "Alice", "Alice: 50; Bob: 50", "", "rent", "", "", ""},
+	}, written)
+
+	// The new expenses are appended
+	response, err = h.PostGroupExpenseHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "house").
+		WithJSONBody(t, FinancialExpense{
+			Title: "Groceries", Amount: "30", Currency: "EUR", DateTime: "2024-01-02T15:04:05Z", PaidBy: "user-2",
+			Participants: []Participant{{UserID: "user-1", Share: "50"}, {UserID: "user-2", Share: "50"}},
+		}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Len(t, written, 3)
+	assert.Equal(t, "Groceries", written[2][1])
+	assert.Equal(t, "Bob", written[2][5])
+
+	// Any member can export again on demand
+	response, err = h.SyncSheetHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "house").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Len(t, written, 3)
+}
@@ -0,0 +1,584 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// maxShoppingItemName is the maximum length of the name of a shopping item.
+const maxShoppingItemName = 200
+
+// maxShoppingExpenseItems bounds the number of items bought in a single expense, as they are
+// stored with it in one transaction.
+const maxShoppingExpenseItems = 50
+
+// ShoppingItem struct for the splitter-shopping-items table, an item of the shopping list of
+// a group. Once bought in an expense it keeps the ID of the expense and can no longer change.
+type ShoppingItem struct {
+	GroupID    string `json:"groupId" dynamodbav:"groupId"`
+	ItemID     string `json:"itemId" dynamodbav:"itemId"`
+	Name       string `json:"name" dynamodbav:"name"`
+	Quantity   string `json:"quantity,omitempty" dynamodbav:"quantity,omitempty"` // free text, e.g. 2 kg
+	AssigneeID string `json:"assigneeId,omitempty" dynamodbav:"assigneeId,omitempty"`
+	Checked    bool   `json:"checked" dynamodbav:"checked"`
+	CheckedBy  string `json:"checkedBy,omitempty" dynamodbav:"checkedBy,omitempty"`
+	CheckedAt  string `json:"checkedAt,omitempty" dynamodbav:"checkedAt,omitempty"`
+	ExpenseID  string `json:"expenseId,omitempty" dynamodbav:"expenseId,omitempty"`
+	CreatedBy  string `json:"createdBy" dynamodbav:"createdBy"`
+	CreatedAt  string `json:"createdAt" dynamodbav:"createdAt"`
+	Version    int    `json:"version" dynamodbav:"version"`
+}
+
+// ShoppingExpenseRequest struct for the request body turning bought items into an expense.
+// The expense is given like to the expenses endpoint, its title defaulting to the names of
+// the items. Without itemIds every checked item not bought yet is.
+type ShoppingExpenseRequest struct {
+	ItemIDs []string         `json:"itemIds"`
+	Expense FinancialExpense `json:"expense"`
+}
+
+// ShoppingExpense is the response of the shopping expense endpoint, the expense added and
+// the items bought in it.
+type ShoppingExpense struct {
+	Expense FinancialExpense `json:"expense"`
+	Items   []ShoppingItem   `json:"items"`
+}
+
+// validate checks the item as sent by the client and trims its text fields.
+func (item *ShoppingItem) validate(memberIds []string) string {
+	item.Name = strings.TrimSpace(item.Name)
+	item.Quantity = strings.TrimSpace(item.Quantity)
+	if item.Name == "" {
+		return "Item name is required"
+	}
+	if len(item.Name) > maxShoppingItemName {
+		return "Item name is too long"
+	}
+	if item.AssigneeID != "" && !slices.Contains(memberIds, item.AssigneeID) {
+		return "The assignee is not a member of the group"
+	}
+	return ""
+}
+
+// check sets or clears the checked state of the item, recording who checked it.
+func (item *ShoppingItem) check(checked bool, userId string, now time.Time) {
+	if checked == item.Checked {
+		return
+	}
+	item.Checked = checked
+	item.CheckedBy = ""
+	item.CheckedAt = ""
+	if checked {
+		item.CheckedBy = userId
+		item.CheckedAt = now.Format(time.RFC3339)
+	}
+}
+
+// getShoppingItem returns the item of the shopping list of the group, or nil if there is none.
+func (h *Handlers) getShoppingItem(ctx context.Context, groupId, itemId string) (*ShoppingItem, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-shopping-items"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+			"itemId":  &types.AttributeValueMemberS{Value: itemId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var item ShoppingItem
+	err = attributevalue.UnmarshalMap(result.Item, &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// queryShoppingItems returns every item of the shopping list of the group, the oldest first.
+func (h *Handlers) queryShoppingItems(ctx context.Context, groupId string, consistentRead *bool) ([]ShoppingItem, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("splitter-shopping-items"),
+		KeyConditionExpression: aws.String("groupId = :groupId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+		ConsistentRead: consistentRead,
+	}
+
+	items := []ShoppingItem{}
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var page []ShoppingItem
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+
+	slices.SortFunc(items, func(a, b ShoppingItem) int {
+		if c := strings.Compare(a.CreatedAt, b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ItemID, b.ItemID)
+	})
+	return items, nil
+}
+
+// shoppingItemResponse answers with the item as JSON.
+func shoppingItemResponse(statusCode int, item ShoppingItem) (events.APIGatewayProxyResponse, error) {
+	// Marshal the item into JSON for the payload
+	payload, err := json.Marshal(item)
+	if err != nil {
+		log.Println("Error marshalling shopping item:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// GetShoppingListHandler returns the shopping list of the group. With the open query
+// parameter set to true the items already bought in an expense are left out.
+func (h *Handlers) GetShoppingListHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	items, err := h.queryShoppingItems(context.TODO(), groupId, common.ConsistentRead(request))
+	if err != nil {
+		log.Printf("Error querying shopping items from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if request.QueryStringParameters["open"] == "true" {
+		items = slices.DeleteFunc(items, func(item ShoppingItem) bool { return item.ExpenseID != "" })
+	}
+
+	log.Printf("Successfully retrieved %d shopping items for group %s", len(items), groupId)
+
+	// Marshal the shopping items into JSON for the payload
+	payload, err := json.Marshal(items)
+	if err != nil {
+		log.Println("Error marshalling shopping items:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// PostShoppingItemHandler adds an item to the shopping list of the group. Any member can,
+// and assign it to any member.
+func (h *Handlers) PostShoppingItemHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a ShoppingItem struct
+	var item ShoppingItem
+	err = json.Unmarshal([]byte(request.Body), &item)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	memberIds, err := h.getGroupMemberIds(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group members from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if !slices.Contains(memberIds, claims.Sub) {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if message := item.validate(memberIds); message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	now := time.Now()
+	checked := item.Checked
+	item = ShoppingItem{
+		GroupID:    groupId,
+		ItemID:     uuid.New().String(),
+		Name:       item.Name,
+		Quantity:   item.Quantity,
+		AssigneeID: item.AssigneeID,
+		CreatedBy:  claims.Sub,
+		CreatedAt:  now.Format(time.RFC3339),
+		Version:    1,
+	}
+	item.check(checked, claims.Sub, now)
+
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-shopping-items", item, common.IfNotExists("itemId"))
+	if err != nil {
+		log.Printf("Error putting shopping item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s added shopping item %s to group %s", claims.Sub, item.ItemID, groupId)
+	return shoppingItemResponse(201, item)
+}
+
+// PutShoppingItemHandler updates the name, quantity, assignee and checked state of an item
+// of the shopping list, unless it changed since the version the client read.
+func (h *Handlers) PutShoppingItemHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and itemId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	itemId, ok := request.PathParameters["itemId"]
+	if !ok || itemId == "" {
+		return common.CreateErrorResponse(400, "Item ID is missing")
+	}
+
+	// Parse the request body into a ShoppingItem struct
+	var update ShoppingItem
+	err = json.Unmarshal([]byte(request.Body), &update)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	memberIds, err := h.getGroupMemberIds(context.TODO(), groupId)
+	if err != nil {
+		log.Printf("Error getting group members from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if !slices.Contains(memberIds, claims.Sub) {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+	if message := update.validate(memberIds); message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	item, err := h.getShoppingItem(context.TODO(), groupId, itemId)
+	if err != nil {
+		log.Printf("Error getting shopping item from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if item == nil {
+		return common.CreateErrorResponse(404, "Shopping item not found")
+	}
+	if item.ExpenseID != "" {
+		return common.CreateErrorResponse(409, "Item was already bought in an expense")
+	}
+	if update.Version != item.Version {
+		return common.CreateErrorResponse(409, "Shopping item was changed, reload it and retry")
+	}
+
+	item.Name = update.Name
+	item.Quantity = update.Quantity
+	item.AssigneeID = update.AssigneeID
+	item.check(update.Checked, claims.Sub, time.Now())
+	item.Version = update.Version + 1
+
+	err = common.ConditionalPutItem(context.TODO(), h.client, "splitter-shopping-items", *item, common.IfVersion(update.Version))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Shopping item was changed, reload it and retry")
+	}
+	if err != nil {
+		log.Printf("Error putting shopping item into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s updated shopping item %s of group %s to version %d", claims.Sub, itemId, groupId, item.Version)
+	return shoppingItemResponse(200, *item)
+}
+
+// DeleteShoppingItemHandler removes an item from the shopping list. The items bought in an
+// expense can be removed too, the expense staying as it is.
+func (h *Handlers) DeleteShoppingItemHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId and itemId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+	itemId, ok := request.PathParameters["itemId"]
+	if !ok || itemId == "" {
+		return common.CreateErrorResponse(400, "Item ID is missing")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	_, err = h.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("splitter-shopping-items"),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+			"itemId":  &types.AttributeValueMemberS{Value: itemId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting shopping item from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s removed shopping item %s from group %s", claims.Sub, itemId, groupId)
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+// pickBoughtItems returns the items of the list with the IDs, or every checked item not
+// bought yet when there are none. It returns a message when an item is missing or can't be
+// bought.
+func pickBoughtItems(items []ShoppingItem, itemIds []string) ([]ShoppingItem, int, string) {
+	if len(itemIds) == 0 {
+		var bought []ShoppingItem
+		for _, item := range items {
+			if item.Checked && item.ExpenseID == "" {
+				bought = append(bought, item)
+			}
+		}
+		if len(bought) == 0 {
+			return nil, 400, "No checked item to buy"
+		}
+		return bought, 0, ""
+	}
+
+	bought := make([]ShoppingItem, 0, len(itemIds))
+	for _, itemId := range itemIds {
+		i := slices.IndexFunc(items, func(item ShoppingItem) bool { return item.ItemID == itemId })
+		if i < 0 {
+			return nil, 404, "Shopping item not found"
+		}
+		if items[i].ExpenseID != "" {
+			return nil, 409, "Item was already bought in an expense"
+		}
+		if !slices.ContainsFunc(bought, func(item ShoppingItem) bool { return item.ItemID == itemId }) {
+			bought = append(bought, items[i])
+		}
+	}
+	return bought, 0, ""
+}
+
+// shoppingTitle is the default title of the expense the items were bought in, their names.
+func shoppingTitle(items []ShoppingItem) string {
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	title := strings.Join(names, ", ")
+	if len(title) > maxShoppingItemName {
+		title = strings.ToValidUTF8(title[:maxShoppingItemName-3], "") + "..."
+	}
+	return title
+}
+
+// PostShoppingExpenseHandler turns bought items of the shopping list into an expense of the
+// group. The expense is stored with the items, which are checked and keep its ID, in one
+// transaction so no item is bought twice. An expense held for approval by the spending caps
+// leaves the items on the list.
+func (h *Handlers) PostShoppingExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a ShoppingExpenseRequest struct
+	var shoppingRequest ShoppingExpenseRequest
+	err = json.Unmarshal([]byte(request.Body), &shoppingRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	member, err := h.getGroupMember(ctx, claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	items, err := h.queryShoppingItems(ctx, groupId, aws.Bool(true))
+	if err != nil {
+		log.Printf("Error querying shopping items from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	bought, statusCode, message := pickBoughtItems(items, shoppingRequest.ItemIDs)
+	if message != "" {
+		return common.CreateErrorResponse(statusCode, message)
+	}
+	if len(bought) > maxShoppingExpenseItems {
+		return common.CreateErrorResponse(400, "Too many items for a single expense")
+	}
+
+	// Fill in the expense like the expenses endpoint does
+	now := time.Now()
+	expense := shoppingRequest.Expense
+	if strings.TrimSpace(expense.Title) == "" {
+		expense.Title = shoppingTitle(bought)
+	}
+	if expense.DateTime == "" {
+		expense.DateTime = now.Format(time.RFC3339)
+	}
+	if expense.PaidBy == "" {
+		expense.PaidBy = claims.Sub
+	}
+	message, err = h.prepareExpense(ctx, groupId, claims.Sub, &expense)
+	if err != nil {
+		log.Printf("Error preparing expense: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	// Refuse the expense when the group has no room left for it
+	quotaErr, err := h.checkExpenseQuota(ctx, groupId, 1)
+	if err != nil {
+		log.Printf("Error checking the expense quota: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if quotaErr != nil {
+		return quotaResponse(quotaErr)
+	}
+
+	// Hold the expense for approval when it takes members over their spending cap
+	approval, warnings, err := h.checkSpendingCaps(ctx, expense, claims.Sub)
+	if err != nil {
+		log.Printf("Error checking spending caps: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if approval != nil {
+		return approvalResponse(approval)
+	}
+
+	// Store the expense with the items bought in it, unless any item changed since it was read
+	puts := []common.ConditionalPut{
+		{TableName: "splitter-expenses", Item: expense, Condition: common.IfNotExists("expenseId")},
+	}
+	for i := range bought {
+		expectedVersion := bought[i].Version
+		bought[i].check(true, claims.Sub, now)
+		bought[i].ExpenseID = expense.ExpenseID
+		bought[i].Version = expectedVersion + 1
+		puts = append(puts, common.ConditionalPut{
+			TableName: "splitter-shopping-items",
+			Item:      bought[i],
+			Condition: common.IfVersion(expectedVersion),
+		})
+	}
+	err = common.TransactPutItems(ctx, h.client, puts)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Shopping list was changed, reload it and retry")
+	}
+	if err != nil {
+		log.Printf("Error storing shopping expense in DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s bought %d shopping items of group %s in expense %s", claims.Sub, len(bought), groupId, expense.ExpenseID)
+	h.announceExpenses(ctx, groupId, expense)
+
+	// Marshal the shopping expense into JSON for the payload
+	payload, err := json.Marshal(ShoppingExpense{Expense: expense, Items: bought})
+	if err != nil {
+		log.Println("Error marshalling shopping expense:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	response := events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}
+	for _, warning := range warnings {
+		common.AddWarning(&response, warning)
+	}
+	return response, nil
+}
@@ -0,0 +1,159 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newShoppingFake(t *testing.T) (*Handlers, *testutil.FakeDynamoDB) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleAdmin},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "House", "role": RoleMember},
+		},
+		"splitter-group-settings": {{"groupId": "test-group-id", "defaultCurrency": "EUR"}},
+		"splitter-shopping-items": {
+			{"groupId": "test-group-id", "itemId": "milk", "name": "Milk", "checked": true, "createdAt": "2024-03-01T10:00:00Z", "version": 1},
+			{"groupId": "test-group-id", "itemId": "eggs", "name": "Eggs", "quantity": "12", "checked": true, "createdAt": "2024-03-01T11:00:00Z", "version": 1},
+			{"groupId": "test-group-id", "itemId": "bread", "name": "Bread", "createdAt": "2024-03-02T10:00:00Z", "version": 1},
+		},
+	})
+	assert.NoError(t, err)
+	return NewHandlers(fake), fake
+}
+
+func TestShoppingList(t *testing.T) {
+	h, _ := newShoppingFake(t)
+
+	// Adding an item assigned to a member
+	response, err := h.PostShoppingItemHandler(testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithJSONBody(t, map[string]interface{}{"name": " Coffee ", "assigneeId": "user-2"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var item ShoppingItem
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &item))
+	assert.Equal(t, "Coffee", item.Name)
+	assert.Equal(t, "user-2", item.AssigneeID)
+	assert.Equal(t, 1, item.Version)
+
+	// Checking it records who did
+	itemId := item.ItemID
+	put := func(body map[string]interface{}) int {
+		response, err := h.PutShoppingItemHandler(testutil.NewRequest("PUT", "").
+			WithClaims("user-2", "bob").
+			WithPathParam("groupId", "test-group-id").
+			WithPathParam("itemId", itemId).
+			WithJSONBody(t, body).
+			Build())
+		assert.NoError(t, err)
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &item))
+		}
+		return response.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, put(map[string]interface{}{"name": "Coffee", "assigneeId": "user-2", "checked": true, "version": 1}))
+	assert.True(t, item.Checked)
+	assert.Equal(t, "user-2", item.CheckedBy)
+	assert.Equal(t, 2, item.Version)
+
+	// A stale version, an unknown assignee and a missing name are refused
+	assert.Equal(t, http.StatusConflict, put(map[string]interface{}{"name": "Coffee", "version": 1}))
+	assert.Equal(t, http.StatusBadRequest, put(map[string]interface{}{"name": "Coffee", "assigneeId": "user-3", "version": 2}))
+	assert.Equal(t, http.StatusBadRequest, put(map[string]interface{}{"name": " ", "version": 2}))
+
+	// The list is sorted by creation
+	response, err = h.GetShoppingListHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-2", "bob").
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	var items []ShoppingItem
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &items))
+	assert.Equal(t, []string{"milk", "eggs", "bread", itemId}, shoppingItemIds(items))
+
+	// Removing an item
+	response, err = h.DeleteShoppingItemHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "test-group-id").
+		WithPathParam("itemId", "bread").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	removed, err := h.getShoppingItem(t.Context(), "test-group-id", "bread")
+	assert.NoError(t, err)
+	assert.Nil(t, removed)
+
+	// Non-members don't see the list
+	response, err = h.GetShoppingListHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-3", "eve").
+		WithPathParam("groupId", "test-group-id").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func shoppingItemIds(items []ShoppingItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ItemID)
+	}
+	return ids
+}
+
+func TestPostShoppingExpenseHandler(t *testing.T) {
+	h, _ := newShoppingFake(t)
+	post := func(body map[string]interface{}) (int, ShoppingExpense) {
+		response, err := h.PostShoppingExpenseHandler(testutil.NewRequest("POST", "").
+			WithClaims("user-1", "alice").
+			WithPathParam("groupId", "test-group-id").
+			WithJSONBody(t, body).
+			Build())
+		assert.NoError(t, err)
+		var shopping ShoppingExpense
+		if response.StatusCode == http.StatusCreated {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &shopping))
+		}
+		return response.StatusCode, shopping
+	}
+
+	// An unknown item is refused
+	statusCode, _ := post(map[string]interface{}{"itemIds": []string{"cheese"}, "expense": map[string]interface{}{"amount": "6.50"}})
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	// Without item IDs the checked items are bought, split between every member
+	statusCode, shopping := post(map[string]interface{}{"expense": map[string]interface{}{"amount": "6.50"}})
+	assert.Equal(t, http.StatusCreated, statusCode)
+	assert.Equal(t, "Milk, Eggs", shopping.Expense.Title)
+	assert.Equal(t, "user-1", shopping.Expense.PaidBy)
+	assert.Equal(t, "EUR", shopping.Expense.Currency)
+	assert.Len(t, shopping.Expense.Participants, 2)
+	assert.Equal(t, []string{"milk", "eggs"}, shoppingItemIds(shopping.Items))
+
+	expense, err := h.getExpense(t.Context(), "test-group-id", shopping.Expense.ExpenseID)
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("6.50"), expense.Amount)
+	milk, err := h.getShoppingItem(t.Context(), "test-group-id", "milk")
+	assert.NoError(t, err)
+	assert.Equal(t, shopping.Expense.ExpenseID, milk.ExpenseID)
+	assert.Equal(t, 2, milk.Version)
+
+	// The items can't be bought again, and nothing is left to buy
+	statusCode, _ = post(map[string]interface{}{"itemIds": []string{"milk"}, "expense": map[string]interface{}{"amount": "1"}})
+	assert.Equal(t, http.StatusConflict, statusCode)
+	statusCode, _ = post(map[string]interface{}{"expense": map[string]interface{}{"amount": "1"}})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	// An unchecked item given by ID is checked as it is bought
+	statusCode, shopping = post(map[string]interface{}{"itemIds": []string{"bread"}, "expense": map[string]interface{}{"title": "Bakery", "amount": "2"}})
+	assert.Equal(t, http.StatusCreated, statusCode)
+	assert.Equal(t, "Bakery", shopping.Expense.Title)
+	assert.True(t, shopping.Items[0].Checked)
+	assert.Equal(t, "user-1", shopping.Items[0].CheckedBy)
+}
@@ -0,0 +1,186 @@
+package financial
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// NextCursorHeader carries the cursor of the next page of a paginated listing.
+const NextCursorHeader = common.NextCursorHeader
+
+// maxExpensePageSize caps the limit query parameter of the expense listing.
+const maxExpensePageSize = 100
+
+// expenseSortFields lists the fields expenses can be sorted by. The default sort is
+// newest dateTime first.
+var expenseSortFields = []string{"dateTime", "createdAt", "amount"}
+
+var (
+	errInvalidSort   = errors.New("Invalid sort")
+	errInvalidLimit  = errors.New("Invalid limit")
+	errInvalidCursor = errors.New("Invalid cursor")
+)
+
+// expenseSort is the order of an expense listing: a field, ascending unless descending.
+type expenseSort struct {
+	Field      string
+	Descending bool
+}
+
+func (s expenseSort) String() string {
+	if s.Descending {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// expenseCursor points right after the last expense of a page. It holds the sort value
+// and the ID of that expense rather than an offset, so pages don't skip or repeat
+// expenses when others are added or removed in between.
+type expenseCursor struct {
+	Sort      string `json:"sort"`
+	Value     string `json:"value"`
+	ExpenseID string `json:"expenseId"`
+}
+
+// expensePage is the requested page of an expense listing. A zero Limit means all expenses.
+type expensePage struct {
+	Sort   expenseSort
+	Limit  int
+	Cursor *expenseCursor
+}
+
+// parseExpensePage reads the optional sort, limit and cursor query parameters. The sort is
+// a field name, prefixed with "-" for descending order, e.g. sort=-amount.
+func parseExpensePage(request events.APIGatewayProxyRequest) (expensePage, error) {
+	page := expensePage{Sort: expenseSort{Field: "dateTime", Descending: true}}
+
+	if value := strings.TrimSpace(request.QueryStringParameters["sort"]); value != "" {
+		field, descending := strings.CutPrefix(value, "-")
+		if !slices.Contains(expenseSortFields, field) {
+			return expensePage{}, errInvalidSort
+		}
+		page.Sort = expenseSort{Field: field, Descending: descending}
+	}
+
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxExpensePageSize {
+			return expensePage{}, errInvalidLimit
+		}
+		page.Limit = limit
+	}
+
+	if value := request.QueryStringParameters["cursor"]; value != "" {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return expensePage{}, errInvalidCursor
+		}
+		var cursor expenseCursor
+		if err := json.Unmarshal(data, &cursor); err != nil || cursor.ExpenseID == "" {
+			return expensePage{}, errInvalidCursor
+		}
+		// A cursor is only meaningful for the order it was issued for
+		if cursor.Sort != page.Sort.String() {
+			return expensePage{}, errInvalidCursor
+		}
+		page.Cursor = &cursor
+	}
+	return page, nil
+}
+
+// sortValue returns the value of the expense the listing is sorted by.
+func sortValue(expense FinancialExpense, field string) string {
+	switch field {
+	case "createdAt":
+		return expense.CreatedAt
+	case "amount":
+		return expense.Amount.String()
+	default:
+		return expense.DateTime
+	}
+}
+
+// compareExpenses orders two expenses by sort value, breaking ties by expense ID so
+// the order is total and stable across requests.
+func compareExpenses(field, valueA, idA, valueB, idB string) int {
+	var result int
+	if field == "amount" {
+		result = compareAmounts(valueA, valueB)
+	} else {
+		result = strings.Compare(valueA, valueB)
+	}
+	if result != 0 {
+		return result
+	}
+	return strings.Compare(idA, idB)
+}
+
+// compareAmounts compares two decimal amounts, ordering invalid or missing ones first.
+func compareAmounts(a, b string) int {
+	ratA, okA := new(big.Rat).SetString(a)
+	ratB, okB := new(big.Rat).SetString(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	default:
+		return ratA.Cmp(ratB)
+	}
+}
+
+// paginateExpenses sorts the expenses and returns the requested page, along with the
+// cursor of the next page, or "" on the last page.
+func paginateExpenses(expenses []FinancialExpense, page expensePage) ([]FinancialExpense, string, error) {
+	field := page.Sort.Field
+	compare := func(a, b FinancialExpense) int {
+		result := compareExpenses(field, sortValue(a, field), a.ExpenseID, sortValue(b, field), b.ExpenseID)
+		if page.Sort.Descending {
+			return -result
+		}
+		return result
+	}
+	sort.SliceStable(expenses, func(i, j int) bool {
+		return compare(expenses[i], expenses[j]) < 0
+	})
+
+	// Skip the expenses up to the cursor
+	if page.Cursor != nil {
+		start := sort.Search(len(expenses), func(i int) bool {
+			result := compareExpenses(field, sortValue(expenses[i], field), expenses[i].ExpenseID, page.Cursor.Value, page.Cursor.ExpenseID)
+			if page.Sort.Descending {
+				return result < 0
+			}
+			return result > 0
+		})
+		expenses = expenses[start:]
+	}
+
+	if page.Limit == 0 || len(expenses) <= page.Limit {
+		return expenses, "", nil
+	}
+	expenses = expenses[:page.Limit]
+
+	last := expenses[len(expenses)-1]
+	data, err := json.Marshal(expenseCursor{
+		Sort:      page.Sort.String(),
+		Value:     sortValue(last, field),
+		ExpenseID: last.ExpenseID,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return expenses, base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,134 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// sortedExpensesClient returns the expenses over two DynamoDB result pages.
+func sortedExpensesClient(t *testing.T) *testutil.MockDynamoDBClient {
+	expenses := []FinancialExpense{
+		{ExpenseID: "expense-a", GroupID: "group-1", Amount: "10", DateTime: "2024-01-03T00:00:00Z", CreatedAt: "2024-01-05T00:00:00Z"},
+		{ExpenseID: "expense-b", GroupID: "group-1", Amount: "9.5", DateTime: "2024-01-02T00:00:00Z", CreatedAt: "2024-01-04T00:00:00Z"},
+		{ExpenseID: "expense-c", GroupID: "group-1", Amount: "10", DateTime: "2024-01-02T00:00:00Z", CreatedAt: "2024-01-06T00:00:00Z"},
+		{ExpenseID: "expense-d", GroupID: "group-1", Amount: "120", DateTime: "2024-01-01T00:00:00Z", CreatedAt: "2024-01-01T00:00:00Z"},
+	}
+	lastKey := map[string]types.AttributeValue{"expenseId": &types.AttributeValueMemberS{Value: "expense-b"}}
+
+	return &testutil.MockDynamoDBClient{
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			if params.ExclusiveStartKey == nil {
+				return &dynamodb.QueryOutput{
+					Items:            []map[string]types.AttributeValue{testutil.MarshalItem(t, expenses[0]), testutil.MarshalItem(t, expenses[1])},
+					LastEvaluatedKey: lastKey,
+				}, nil
+			}
+			assert.Equal(t, lastKey, params.ExclusiveStartKey)
+			return &dynamodb.QueryOutput{
+				Items: []map[string]types.AttributeValue{testutil.MarshalItem(t, expenses[2]), testutil.MarshalItem(t, expenses[3])},
+			}, nil
+		},
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			// The group has no settings
+			assert.Equal(t, "splitter-group-settings", *params.TableName)
+			return &dynamodb.GetItemOutput{}, nil
+		},
+		BatchGetItemFunc: func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+			return testutil.UsersOutput(), nil
+		},
+	}
+}
+
+// listExpenseIds calls the expense listing and returns the IDs of the expenses with the next cursor.
+func listExpenseIds(t *testing.T, h *Handlers, query map[string]string) ([]string, string) {
+	builder := testutil.NewRequest("GET", "").WithPathParam("groupId", "group-1")
+	for name, value := range query {
+		builder = builder.WithQueryParam(name, value)
+	}
+
+	response, err := h.GetGroupExpensesHandler(builder.Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var expenses []FinancialExpense
+	err = json.Unmarshal([]byte(response.Body), &expenses)
+	assert.NoError(t, err)
+
+	ids := make([]string, len(expenses))
+	for i, expense := range expenses {
+		ids[i] = expense.ExpenseID
+	}
+	return ids, response.Headers[NextCursorHeader]
+}
+
+func TestGetGroupExpensesHandlerSorted(t *testing.T) {
+	h := NewHandlers(sortedExpensesClient(t))
+
+	// Newest first by default, ties broken by expense ID
+	ids, cursor := listExpenseIds(t, h, nil)
+	assert.Equal(t, []string{"expense-a", "expense-c", "expense-b", "expense-d"}, ids)
+	assert.Empty(t, cursor)
+
+	// Oldest first, e.g. for statements
+	ids, _ = listExpenseIds(t, h, map[string]string{"sort": "dateTime"})
+	assert.Equal(t, []string{"expense-d", "expense-b", "expense-c", "expense-a"}, ids)
+
+	// Amounts are compared as numbers
+	ids, _ = listExpenseIds(t, h, map[string]string{"sort": "amount"})
+	assert.Equal(t, []string{"expense-b", "expense-a", "expense-c", "expense-d"}, ids)
+
+	ids, _ = listExpenseIds(t, h, map[string]string{"sort": "-createdAt"})
+	assert.Equal(t, []string{"expense-c", "expense-a", "expense-b", "expense-d"}, ids)
+}
+
+func TestGetGroupExpensesHandlerPaginated(t *testing.T) {
+	h := NewHandlers(sortedExpensesClient(t))
+
+	// Walk the pages, sorted by amount, highest first
+	var ids []string
+	query := map[string]string{"sort": "-amount", "limit": "3"}
+	for {
+		page, cursor := listExpenseIds(t, h, query)
+		ids = append(ids, page...)
+		if cursor == "" {
+			break
+		}
+		query["cursor"] = cursor
+	}
+	assert.Equal(t, []string{"expense-d", "expense-c", "expense-a", "expense-b"}, ids)
+}
+
+func TestGetGroupExpensesHandlerInvalidPage(t *testing.T) {
+	h := NewHandlers(sortedExpensesClient(t))
+
+	// Get a cursor issued for the amount order
+	_, cursor := listExpenseIds(t, h, map[string]string{"sort": "amount", "limit": "1"})
+	assert.NotEmpty(t, cursor)
+
+	for _, query := range []map[string]string{
+		{"sort": "title"},
+		{"limit": "0"},
+		{"limit": "101"},
+		{"cursor": "not a cursor"},
+		{"sort": "-amount", "cursor": cursor},
+	} {
+		builder := testutil.NewRequest("GET", "").WithPathParam("groupId", "group-1")
+		for name, value := range query {
+			builder = builder.WithQueryParam(name, value)
+		}
+
+		// Call the handler
+		response, err := h.GetGroupExpensesHandler(builder.Build())
+		assert.NoError(t, err)
+
+		// Check the response for 400 Bad Request
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, "query %v", query)
+	}
+}
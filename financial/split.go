@@ -0,0 +1,102 @@
+package financial
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+var (
+	errInvalidAmount = errors.New("invalid amount")
+	errInvalidShare  = errors.New("invalid share")
+	errSharesTotal   = errors.New("shares don't add up to 100")
+)
+
+// PercentageEpsilon is how far the percentage shares of an expense may add up from 100,
+// to allow for rounding like three shares of 33.33.
+var PercentageEpsilon = big.NewRat(1, 100)
+
+// calculateParticipantMoney validates the percentage shares of the participants, formats
+// them with two decimals and sets the calculatedMoney of each participant from the
+// expense amount.
+//
+// The shares must add up to 100 within PercentageEpsilon; every participant pays their
+// share of the total shares. The amount is split in whole cents: every participant gets
+// their share rounded down and the cents left over go to the participants with the largest
+// remainders, so the calculated amounts always add up to the expense amount.
+func calculateParticipantMoney(expense *FinancialExpense) error {
+	amount, ok := new(big.Rat).SetString(string(expense.Amount))
+	if !ok {
+		return errInvalidAmount
+	}
+	totalCents := roundRat(new(big.Rat).Mul(amount, big.NewRat(100, 1)))
+
+	participants := expense.Participants
+	if len(participants) == 0 {
+		return nil
+	}
+
+	shares := make([]*big.Rat, len(participants))
+	totalShares := new(big.Rat)
+	for i := range participants {
+		share, ok := new(big.Rat).SetString(string(participants[i].Share))
+		if !ok || share.Sign() < 0 {
+			return errInvalidShare
+		}
+		shares[i] = share
+		totalShares.Add(totalShares, share)
+	}
+	difference := new(big.Rat).Sub(totalShares, big.NewRat(100, 1))
+	if totalShares.Sign() == 0 || difference.Abs(difference).Cmp(PercentageEpsilon) > 0 {
+		return errSharesTotal
+	}
+
+	cents := make([]*big.Int, len(participants))
+	remainders := make([]*big.Rat, len(participants))
+	allocated := new(big.Int)
+	for i, share := range shares {
+		// exact = totalCents * share / totalShares
+		exact := new(big.Rat).Mul(new(big.Rat).SetInt(totalCents), share)
+		exact.Quo(exact, totalShares)
+
+		floor := new(big.Int).Quo(exact.Num(), exact.Denom())
+		cents[i] = floor
+		remainders[i] = new(big.Rat).Sub(exact, new(big.Rat).SetInt(floor))
+		allocated.Add(allocated, floor)
+	}
+
+	// Hand out the leftover cents, one per participant, largest remainders first
+	leftover := new(big.Int).Sub(totalCents, allocated)
+	order := make([]int, len(participants))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]].Cmp(remainders[order[b]]) > 0
+	})
+	for _, i := range order {
+		if leftover.Sign() <= 0 {
+			break
+		}
+		cents[i].Add(cents[i], big.NewInt(1))
+		leftover.Sub(leftover, big.NewInt(1))
+	}
+
+	for i := range participants {
+		money := new(big.Rat).SetFrac(cents[i], big.NewInt(100))
+		participants[i].CalculatedMoney = json.Number(money.FloatString(2))
+		participants[i].Share = json.Number(shares[i].FloatString(2))
+	}
+	return nil
+}
+
+// roundRat rounds the value to the nearest integer, halves away from zero.
+func roundRat(value *big.Rat) *big.Int {
+	half := big.NewRat(1, 2)
+	if value.Sign() < 0 {
+		half.Neg(half)
+	}
+	shifted := new(big.Rat).Add(value, half)
+	return new(big.Int).Quo(shifted.Num(), shifted.Denom())
+}
@@ -0,0 +1,156 @@
+package financial
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// percentageSplit is a random expense amount with percentage shares adding up to 100.
+type percentageSplit struct {
+	Amount string
+	Shares []string
+}
+
+// Generate implements quick.Generator.
+func (percentageSplit) Generate(r *rand.Rand, size int) reflect.Value {
+	amountCents := r.Int63n(100_000_000)
+
+	// Cut 10000 basis points into a random number of shares, zero shares included
+	count := 1 + r.Intn(10)
+	cuts := []int{0, 10000}
+	for i := 1; i < count; i++ {
+		cuts = append(cuts, r.Intn(10001))
+	}
+	slices.Sort(cuts)
+
+	split := percentageSplit{Amount: fmt.Sprintf("%d.%02d", amountCents/100, amountCents%100)}
+	for i := 1; i < len(cuts); i++ {
+		basisPoints := cuts[i] - cuts[i-1]
+		split.Shares = append(split.Shares, fmt.Sprintf("%d.%02d", basisPoints/100, basisPoints%100))
+	}
+	return reflect.ValueOf(split)
+}
+
+func TestCalculateParticipantMoneyProperties(t *testing.T) {
+	property := func(split percentageSplit) bool {
+		expense := FinancialExpense{Amount: json.Number(split.Amount)}
+		for i, share := range split.Shares {
+			expense.Participants = append(expense.Participants, Participant{UserID: fmt.Sprintf("user-%d", i), Share: json.Number(share)})
+		}
+		if err := calculateParticipantMoney(&expense); err != nil {
+			t.Logf("unexpected error for %+v: %v", split, err)
+			return false
+		}
+
+		amount, _ := new(big.Rat).SetString(split.Amount)
+		total := new(big.Rat)
+		for _, participant := range expense.Participants {
+			money, ok := new(big.Rat).SetString(string(participant.CalculatedMoney))
+			// No participant ever owes a negative amount
+			if !ok || money.Sign() < 0 {
+				t.Logf("invalid calculated money %q for %+v", participant.CalculatedMoney, split)
+				return false
+			}
+			total.Add(total, money)
+		}
+
+		// The calculated amounts always add up to the expense amount
+		if total.Cmp(amount) != 0 {
+			t.Logf("calculated money adds up to %s instead of %s for %+v", total.FloatString(2), split.Amount, split)
+			return false
+		}
+		return true
+	}
+
+	assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 2000}))
+}
+
+func TestCalculateParticipantMoneyTinyAmounts(t *testing.T) {
+	// A single cent can't be rounded up for both halves
+	expense := FinancialExpense{
+		Amount: "0.01",
+		Participants: []Participant{
+			{UserID: "user-1", Share: "50"},
+			{UserID: "user-2", Share: "50"},
+			{UserID: "user-3", Share: "0"},
+		},
+	}
+
+	err := calculateParticipantMoney(&expense)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.01", string(expense.Participants[0].CalculatedMoney))
+	assert.Equal(t, "0.00", string(expense.Participants[1].CalculatedMoney))
+	assert.Equal(t, "0.00", string(expense.Participants[2].CalculatedMoney))
+}
+
+func TestCalculateParticipantMoneyRejectsNegativeShares(t *testing.T) {
+	expense := FinancialExpense{
+		Amount: "10",
+		Participants: []Participant{
+			{UserID: "user-1", Share: "150"},
+			{UserID: "user-2", Share: "-50"},
+		},
+	}
+
+	err := calculateParticipantMoney(&expense)
+	assert.ErrorIs(t, err, errInvalidShare)
+}
+
+func TestCalculateParticipantMoneyValidatesShareTotal(t *testing.T) {
+	tests := []struct {
+		name   string
+		shares []json.Number
+		want   error
+	}{
+		{"exactly 100", []json.Number{"40", "60"}, nil},
+		{"within epsilon", []json.Number{"33.33", "33.33", "33.33"}, nil},
+		{"below 100", []json.Number{"50", "40"}, errSharesTotal},
+		{"above 100", []json.Number{"100", "100"}, errSharesTotal},
+		{"all zero", []json.Number{"0", "0"}, errSharesTotal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expense := FinancialExpense{Amount: "10"}
+			for i, share := range test.shares {
+				expense.Participants = append(expense.Participants, Participant{UserID: fmt.Sprintf("user-%d", i), Share: share})
+			}
+			assert.ErrorIs(t, calculateParticipantMoney(&expense), test.want)
+		})
+	}
+}
+
+func TestCalculateParticipantMoneyNormalizesShares(t *testing.T) {
+	// Shares within epsilon of 100 are split in proportion and formatted with two decimals
+	expense := FinancialExpense{
+		Amount: "10",
+		Participants: []Participant{
+			{UserID: "user-1", Share: "33.333"},
+			{UserID: "user-2", Share: "33.333"},
+			{UserID: "user-3", Share: "33.333"},
+		},
+	}
+
+	err := calculateParticipantMoney(&expense)
+	assert.NoError(t, err)
+	for _, participant := range expense.Participants {
+		assert.Equal(t, "33.33", string(participant.Share))
+	}
+	assert.Equal(t, "3.34", string(expense.Participants[0].CalculatedMoney))
+	assert.Equal(t, "3.33", string(expense.Participants[1].CalculatedMoney))
+	assert.Equal(t, "3.33", string(expense.Participants[2].CalculatedMoney))
+}
+
+func TestEqualShares(t *testing.T) {
+	assert.Equal(t, []json.Number{"100.00"}, equalShares(1))
+	assert.Equal(t, []json.Number{"33.34", "33.33", "33.33"}, equalShares(3))
+	assert.Equal(t, []json.Number{"14.29", "14.29", "14.29", "14.29", "14.28", "14.28", "14.28"}, equalShares(7))
+	assert.Empty(t, equalShares(0))
+}
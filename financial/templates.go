@@ -0,0 +1,218 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/fx"
+	"vassistant-backend/i18n"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RecurringSuggestion is an expense a group of a template usually has every month, suggested
+// to the members setting the group up.
+type RecurringSuggestion struct {
+	Code       string `json:"code"`
+	Title      string `json:"title"`
+	Category   string `json:"category,omitempty"` // the default category of the group when unset
+	DayOfMonth int    `json:"dayOfMonth"`
+}
+
+// GroupTemplate is a predefined setup of a group, seeding its default settings.
+type GroupTemplate struct {
+	Code             string                `json:"code"`
+	Label            string                `json:"label"`
+	DefaultCategory  string                `json:"defaultCategory"`
+	DefaultSplitType string                `json:"defaultSplitType"`
+	Suggestions      []RecurringSuggestion `json:"suggestions"`
+}
+
+// groupTemplates lists the templates groups can be set up with. Their labels and the titles
+// of their suggestions are localized under "template." and "template.suggestion.".
+var groupTemplates = []GroupTemplate{
+	{
+		Code:             "HOUSEHOLD",
+		DefaultCategory:  "FOOD",
+		DefaultSplitType: "PERCENTAGE",
+		Suggestions: []RecurringSuggestion{
+			{Code: "RENT", DayOfMonth: 5},
+			{Code: "UTILITIES", DayOfMonth: 10},
+			{Code: "INTERNET", DayOfMonth: 15},
+			{Code: "GROCERIES", Category: "FOOD", DayOfMonth: 1},
+		},
+	},
+	{
+		Code:             "TRIP",
+		DefaultCategory:  "FOOD",
+		DefaultSplitType: "PERCENTAGE",
+		Suggestions:      []RecurringSuggestion{},
+	},
+	{
+		Code:             "COUPLE",
+		DefaultCategory:  "FOOD",
+		DefaultSplitType: "PERCENTAGE",
+		Suggestions: []RecurringSuggestion{
+			{Code: "RENT", DayOfMonth: 5},
+			{Code: "GROCERIES", Category: "FOOD", DayOfMonth: 1},
+			{Code: "STREAMING", DayOfMonth: 20},
+		},
+	},
+}
+
+// GroupTemplateRequest struct for the request body setting a group up with a template. The
+// currency, if given, becomes the default currency of the group.
+type GroupTemplateRequest struct {
+	Template string `json:"template"`
+	Currency string `json:"currency"`
+}
+
+// GroupTemplateResult is the response of the template endpoint, the settings of the group
+// and the template they were seeded from.
+type GroupTemplateResult struct {
+	Settings GroupSettings `json:"settings"`
+	Template GroupTemplate `json:"template"`
+}
+
+// localizedTemplate returns the template with the given code in the language, or false if
+// there is none.
+func localizedTemplate(language, code string) (GroupTemplate, bool) {
+	i := slices.IndexFunc(groupTemplates, func(template GroupTemplate) bool { return template.Code == code })
+	if i < 0 {
+		return GroupTemplate{}, false
+	}
+	template := groupTemplates[i]
+	template.Label = i18n.T(language, "template."+template.Code, nil)
+	template.Suggestions = slices.Clone(template.Suggestions)
+	for j := range template.Suggestions {
+		template.Suggestions[j].Title = i18n.T(language, "template.suggestion."+template.Suggestions[j].Code, nil)
+	}
+	return template, true
+}
+
+// seed fills in the settings the group has no value for from the template, keeping the
+// ones the members already chose.
+func (template GroupTemplate) seed(settings *GroupSettings, currency string) {
+	if settings.DefaultCategory == "" {
+		settings.DefaultCategory = template.DefaultCategory
+	}
+	if settings.DefaultSplitType == "" {
+		settings.DefaultSplitType = template.DefaultSplitType
+	}
+	if settings.DefaultCurrency == "" {
+		settings.DefaultCurrency = currency
+	}
+}
+
+// applyGroupTemplate seeds the settings of the group from the template and saves them, only
+// if nobody changed them since they were read, returning common.ErrConditionFailed otherwise.
+func (h *Handlers) applyGroupTemplate(ctx context.Context, groupId string, template GroupTemplate, currency string) (GroupSettings, error) {
+	settings, err := h.getGroupSettings(ctx, groupId)
+	if err != nil {
+		return GroupSettings{}, err
+	}
+
+	expectedVersion := settings.Version
+	template.seed(&settings, currency)
+	settings.Version = expectedVersion + 1
+	err = common.ConditionalPutItem(ctx, h.client, "splitter-group-settings", settings, common.IfVersion(expectedVersion))
+	if err != nil {
+		return GroupSettings{}, err
+	}
+	return settings, nil
+}
+
+func GetGroupTemplatesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	language := i18n.Language(request)
+	templates := make([]GroupTemplate, 0, len(groupTemplates))
+	for _, template := range groupTemplates {
+		localized, _ := localizedTemplate(language, template.Code)
+		templates = append(templates, localized)
+	}
+
+	// Marshal the templates into JSON for the payload
+	payload, err := json.Marshal(templates)
+	if err != nil {
+		log.Println("Error marshalling group templates:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json", "Content-Language": language},
+		Body:       string(payload),
+	}, nil
+}
+
+// ApplyGroupTemplateHandler sets a group up with a template, seeding the default category,
+// split type and currency it has none of yet, and returns the recurring expenses the
+// template suggests. Any member can, like they can change the settings.
+func (h *Handlers) ApplyGroupTemplateHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the request body into a GroupTemplateRequest struct
+	var templateRequest GroupTemplateRequest
+	err = json.Unmarshal([]byte(request.Body), &templateRequest)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	template, ok := localizedTemplate(i18n.Language(request), templateRequest.Template)
+	if !ok {
+		return common.CreateErrorResponse(400, "Invalid template, expected HOUSEHOLD, TRIP or COUPLE")
+	}
+	if templateRequest.Currency != "" && !fx.ValidCurrency(templateRequest.Currency) {
+		return common.CreateErrorResponse(400, "Invalid currency")
+	}
+
+	member, err := h.getGroupMember(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if member == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	settings, err := h.applyGroupTemplate(context.TODO(), groupId, template, templateRequest.Currency)
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Group settings were changed by someone else")
+	}
+	if err != nil {
+		log.Printf("Error seeding group settings in DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s set group %s up with template %s", claims.Sub, groupId, template.Code)
+
+	// Marshal the result into JSON for the payload
+	payload, err := json.Marshal(GroupTemplateResult{Settings: settings, Template: template})
+	if err != nil {
+		log.Println("Error marshalling group template result:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
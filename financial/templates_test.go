@@ -0,0 +1,72 @@
+package financial
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGroupTemplatesHandler(t *testing.T) {
+	response, err := GetGroupTemplatesHandler(testutil.NewRequest("GET", "/financial/group-templates").
+		WithHeader("Accept-Language", "pt-BR").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, "pt-BR", response.Headers["Content-Language"])
+
+	var templates []GroupTemplate
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &templates))
+	assert.Len(t, templates, 3)
+	assert.Equal(t, "Casa", templates[0].Label)
+	assert.Equal(t, "Aluguel", templates[0].Suggestions[0].Title)
+
+	// The registry itself isn't localized
+	assert.Empty(t, groupTemplates[0].Suggestions[0].Title)
+}
+
+func TestApplyGroupTemplateHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "House", "role": RoleMember},
+		},
+		"splitter-group-settings": {{"groupId": "test-group-id", "defaultCurrency": "EUR", "version": 1}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	apply := func(userId string, body GroupTemplateRequest) (int, GroupTemplateResult) {
+		response, err := h.ApplyGroupTemplateHandler(testutil.NewRequest("POST", "").
+			WithClaims(userId, userId).
+			WithPathParam("groupId", "test-group-id").
+			WithJSONBody(t, body).
+			Build())
+		assert.NoError(t, err)
+		var result GroupTemplateResult
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &result))
+		}
+		return response.StatusCode, result
+	}
+
+	statusCode, _ := apply("user-1", GroupTemplateRequest{Template: "OFFICE"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = apply("user-1", GroupTemplateRequest{Template: "TRIP", Currency: "brl"})
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = apply("user-2", GroupTemplateRequest{Template: "TRIP"})
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	// The settings the group has are kept, the others seeded
+	statusCode, result := apply("user-1", GroupTemplateRequest{Template: "HOUSEHOLD", Currency: "BRL"})
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "EUR", result.Settings.DefaultCurrency)
+	assert.Equal(t, "FOOD", result.Settings.DefaultCategory)
+	assert.Equal(t, "PERCENTAGE", result.Settings.DefaultSplitType)
+	assert.Equal(t, 2, result.Settings.Version)
+	assert.Equal(t, "Household", result.Template.Label)
+	assert.Len(t, result.Template.Suggestions, 4)
+
+	settings, err := h.getGroupSettings(t.Context(), "test-group-id")
+	assert.NoError(t, err)
+	assert.Equal(t, "FOOD", settings.DefaultCategory)
+}
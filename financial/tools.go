@@ -0,0 +1,183 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"vassistant-backend/fx"
+	"vassistant-backend/i18n"
+	"vassistant-backend/tools"
+)
+
+// proposedExpense is the arguments of the propose_expense tool.
+type proposedExpense struct {
+	GroupID  string      `json:"groupId"`
+	Title    string      `json:"title"`
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+	Category string      `json:"category"`
+}
+
+// groupSetup is the arguments of the set_up_group tool.
+type groupSetup struct {
+	GroupID  string `json:"groupId"`
+	Template string `json:"template"`
+	Currency string `json:"currency"`
+}
+
+// errOutsideGroup is returned by the tools asked for another group than the one the
+// conversation is about.
+var errOutsideGroup = errors.New("this conversation is about another group")
+
+// AssistantTools returns the financial tools of the assistant: reading the groups and
+// debts of the user, setting a group up with a template, and proposing expenses as drafts
+// the user confirms. In a conversation about a group, the tools are limited to that group.
+func (h *Handlers) AssistantTools() []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "list_groups",
+			Description: "Lists the expense groups of the user, with their IDs and names.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+			Access:      tools.ReadAccess,
+			Run:         h.listGroupsTool,
+		},
+		{
+			Name:        "get_net_debts",
+			Description: "Returns what the other members owe the user across all their groups, or in the group of the conversation, per member and currency. Negative amounts are owed by the user.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+			Access:      tools.ReadAccess,
+			Run:         h.netDebtsTool,
+		},
+		{
+			Name:        "set_up_group",
+			Description: "Sets one of the groups of the user up with a template, HOUSEHOLD, TRIP or COUPLE, seeding the default category, split type and currency it has none of yet. Returns the recurring expenses the template suggests.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"groupId":{"type":"string"},"template":{"type":"string","enum":["HOUSEHOLD","TRIP","COUPLE"]},` +
+				`"currency":{"type":"string"}},` +
+				`"required":["groupId","template"]}`),
+			Access: tools.WriteAccess,
+			Run:    h.setUpGroupTool,
+		},
+		{
+			Name:        "propose_expense",
+			Description: "Proposes an expense paid by the user in one of their groups, split with the group defaults. The user confirms it before it is added.",
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"groupId":{"type":"string"},"title":{"type":"string"},"amount":{"type":"string"},` +
+				`"currency":{"type":"string"},"category":{"type":"string"}},` +
+				`"required":["groupId","title","amount"]}`),
+			Access: tools.WriteAccess,
+			Run:    h.proposeExpenseTool,
+		},
+	}
+}
+
+func (h *Handlers) listGroupsTool(ctx context.Context, call tools.Call) (string, error) {
+	groups, err := h.listUserGroups(ctx, call.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	type group struct {
+		GroupID   string `json:"groupId"`
+		GroupName string `json:"groupName"`
+	}
+	result := make([]group, 0, len(groups))
+	for _, member := range groups {
+		if call.GroupID != "" && member.GroupID != call.GroupID {
+			continue
+		}
+		result = append(result, group{GroupID: member.GroupID, GroupName: member.GroupName})
+	}
+	payload, err := json.Marshal(result)
+	return string(payload), err
+}
+
+func (h *Handlers) netDebtsTool(ctx context.Context, call tools.Call) (string, error) {
+	if call.GroupID != "" {
+		member, err := h.getGroupMember(ctx, call.UserID, call.GroupID)
+		if err != nil {
+			return "", err
+		}
+		if member == nil {
+			return "", errOutsideGroup
+		}
+		balance, err := h.GetUserGroupBalance(ctx, call.UserID, *member)
+		if err != nil {
+			return "", err
+		}
+		payload, err := json.Marshal(balance)
+		return string(payload), err
+	}
+
+	debts, err := h.netDebts(ctx, call.UserID, nil)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(debts)
+	return string(payload), err
+}
+
+func (h *Handlers) setUpGroupTool(ctx context.Context, call tools.Call) (string, error) {
+	var arguments groupSetup
+	if err := json.Unmarshal(call.Arguments, &arguments); err != nil {
+		return "", err
+	}
+	if call.GroupID != "" {
+		if arguments.GroupID != "" && arguments.GroupID != call.GroupID {
+			return "", errOutsideGroup
+		}
+		arguments.GroupID = call.GroupID
+	}
+	template, ok := localizedTemplate(i18n.DefaultLanguage, arguments.Template)
+	if !ok {
+		return "", errors.New("template must be HOUSEHOLD, TRIP or COUPLE")
+	}
+	if arguments.Currency != "" && !fx.ValidCurrency(arguments.Currency) {
+		return "", errInvalidCurrency
+	}
+
+	member, err := h.getGroupMember(ctx, call.UserID, arguments.GroupID)
+	if err != nil {
+		return "", err
+	}
+	if member == nil {
+		return "", ErrNotGroupMember
+	}
+	settings, err := h.applyGroupTemplate(ctx, arguments.GroupID, template, arguments.Currency)
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(GroupTemplateResult{Settings: settings, Template: template})
+	return string(payload), err
+}
+
+func (h *Handlers) proposeExpenseTool(ctx context.Context, call tools.Call) (string, error) {
+	var arguments proposedExpense
+	if err := json.Unmarshal(call.Arguments, &arguments); err != nil {
+		return "", err
+	}
+	if call.GroupID != "" {
+		if arguments.GroupID != "" && arguments.GroupID != call.GroupID {
+			return "", errOutsideGroup
+		}
+		arguments.GroupID = call.GroupID
+	}
+	if arguments.GroupID == "" || arguments.Title == "" {
+		return "", errors.New("groupId and title are required")
+	}
+
+	draft, err := h.ProposeExpense(ctx, call.UserID, arguments.GroupID, FinancialExpense{
+		Title:    arguments.Title,
+		Amount:   arguments.Amount,
+		Currency: arguments.Currency,
+		Category: arguments.Category,
+		PaidBy:   call.UserID,
+		DateTime: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(draft)
+	return string(payload), err
+}
@@ -0,0 +1,67 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"vassistant-backend/testutil"
+	"vassistant-backend/tools"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssistantTools(t *testing.T) {
+	// Set up the fake DynamoDB client with a two member group
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "test-group-id", "groupName": "Flat"},
+			{"userId": "user-2", "groupId": "test-group-id", "groupName": "Flat"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	assistantTools := map[string]tools.Tool{}
+	for _, tool := range h.AssistantTools() {
+		assert.True(t, json.Valid(tool.Parameters), tool.Name)
+		assistantTools[tool.Name] = tool
+	}
+	assert.Equal(t, tools.ReadAccess, assistantTools["list_groups"].Access)
+	assert.Equal(t, tools.WriteAccess, assistantTools["propose_expense"].Access)
+	assert.Equal(t, tools.WriteAccess, assistantTools["set_up_group"].Access)
+
+	result, err := assistantTools["list_groups"].Run(context.TODO(), tools.Call{UserID: "user-1"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{"groupId": "test-group-id", "groupName": "Flat"}]`, result)
+
+	// Expenses are proposed as drafts paid by the user
+	result, err = assistantTools["propose_expense"].Run(context.TODO(), tools.Call{
+		UserID:    "user-1",
+		Arguments: json.RawMessage(`{"groupId": "test-group-id", "title": "Dinner", "amount": "30"}`),
+	})
+	assert.NoError(t, err)
+	var draft ExpenseDraft
+	assert.NoError(t, json.Unmarshal([]byte(result), &draft))
+	assert.Equal(t, "user-1", draft.Expense.PaidBy)
+	assert.Len(t, draft.Expense.Participants, 2)
+
+	// Groups are set up with a template
+	result, err = assistantTools["set_up_group"].Run(context.TODO(), tools.Call{
+		UserID:    "user-1",
+		Arguments: json.RawMessage(`{"groupId": "test-group-id", "template": "TRIP", "currency": "EUR"}`),
+	})
+	assert.NoError(t, err)
+	var setup GroupTemplateResult
+	assert.NoError(t, json.Unmarshal([]byte(result), &setup))
+	assert.Equal(t, "EUR", setup.Settings.DefaultCurrency)
+	setUp, err := h.GroupSetUp(context.TODO(), "test-group-id")
+	assert.NoError(t, err)
+	assert.True(t, setUp)
+
+	// Only in the groups of the user
+	_, err = assistantTools["propose_expense"].Run(context.TODO(), tools.Call{
+		UserID:    "user-3",
+		Arguments: json.RawMessage(`{"groupId": "test-group-id", "title": "Dinner", "amount": "30"}`),
+	})
+	assert.ErrorIs(t, err, ErrNotGroupMember)
+}
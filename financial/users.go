@@ -0,0 +1,143 @@
+package financial
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxBatchGetKeys is the maximum number of keys DynamoDB accepts in a single BatchGetItem call.
+const maxBatchGetKeys = 100
+
+// maxParallelBatches bounds how many BatchGetItem calls are in flight at the same time.
+const maxParallelBatches = 4
+
+// maxUnprocessedRetries bounds how many times unprocessed keys of a batch are requested again.
+const maxUnprocessedRetries = 3
+
+// unprocessedBackoff is the longest wait before the unprocessed keys of a batch are first
+// requested again, doubled at each retry. DynamoDB mostly leaves keys unprocessed when
+// throttled, so asking again right away would only be throttled too.
+const unprocessedBackoff = 50 * time.Millisecond
+
+// retryJitter picks the wait before a retry, up to the longest one (full jitter), so the
+// batches throttled together don't retry together.
+var retryJitter = rand.N[time.Duration]
+
+// DeletedUserName is the showable name of the placeholder of the users still referenced,
+// e.g. by the expenses they took part in, who no longer exist.
+const DeletedUserName = "Deleted user"
+
+// userDirectory holds the details of the users found, keyed by user ID.
+type userDirectory map[string]User
+
+// User returns the details of the user, or a placeholder naming the user as deleted when
+// they weren't found. There are no details for an empty ID, which references no one.
+func (d userDirectory) User(userId string) User {
+	if user, ok := d[userId]; ok {
+		return user
+	}
+	if userId == "" {
+		return User{}
+	}
+	return User{UserID: userId, ShowableName: DeletedUserName}
+}
+
+// getUsersByIds fetches the details of the given users from the vassistant-users table.
+// Keys are split into batches of at most maxBatchGetKeys which are fetched concurrently.
+func (h *Handlers) getUsersByIds(ctx context.Context, userIds map[string]struct{}) (userDirectory, error) {
+	userMap := make(userDirectory, len(userIds))
+	if len(userIds) == 0 {
+		return userMap, nil
+	}
+
+	// Prepare keys for BatchGetItem
+	keys := make([]map[string]types.AttributeValue, 0, len(userIds))
+	for userId := range userIds {
+		if userId == "" {
+			continue
+		}
+		keys = append(keys, map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		})
+	}
+
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelBatches)
+
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := min(start+maxBatchGetKeys, len(keys))
+		batch := keys[start:end]
+
+		g.Go(func() error {
+			users, err := h.batchGetUsers(ctx, batch)
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, user := range users {
+				userMap[user.UserID] = user
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return userMap, nil
+}
+
+// batchGetUsers fetches a single batch of users, requesting unprocessed keys again with an
+// exponential backoff.
+func (h *Handlers) batchGetUsers(ctx context.Context, keys []map[string]types.AttributeValue) ([]User, error) {
+	var users []User
+
+	for attempt := 0; len(keys) > 0 && attempt <= maxUnprocessedRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryJitter(unprocessedBackoff << (attempt - 1))):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		batchGetItemInput := &dynamodb.BatchGetItemInput{
+			RequestItems: map[string]types.KeysAndAttributes{
+				"vassistant-users": {
+					Keys: keys,
+				},
+			},
+		}
+
+		userResult, err := h.client.BatchGetItem(ctx, batchGetItemInput)
+		if err != nil {
+			return nil, err
+		}
+
+		var batchUsers []User
+		err = attributevalue.UnmarshalListOfMaps(userResult.Responses["vassistant-users"], &batchUsers)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, batchUsers...)
+
+		keys = userResult.UnprocessedKeys["vassistant-users"].Keys
+	}
+
+	if len(keys) > 0 {
+		log.Printf("Warning: %d user keys were left unprocessed by BatchGetItem", len(keys))
+	}
+
+	return users, nil
+}
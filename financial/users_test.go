@@ -0,0 +1,72 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/faults"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletedUserPlaceholder(t *testing.T) {
+	// Set up the fake DynamoDB with an expense paid by a user who deleted their account
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-expenses": {{
+			"groupId": "house", "expenseId": "rent", "title": "Rent", "amount": "100", "paidBy": "gone", "dateTime": "2024-01-01T00:00:00Z",
+			"participants": []map[string]interface{}{{"userId": "gone", "calculatedMoney": "50"}, {"userId": "user-1", "calculatedMoney": "50"}},
+		}},
+		"vassistant-users": {{"userId": "user-1", "username": "alice", "showableName": "Alice"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	response, err := h.GetGroupExpensesHandler(testutil.NewRequest("GET", "").
+		WithClaims("user-1", "alice").
+		WithPathParam("groupId", "house").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var expenses []FinancialExpense
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &expenses))
+
+	// The deleted user is named by the placeholder, the others as usual
+	placeholder := User{UserID: "gone", ShowableName: DeletedUserName}
+	assert.Equal(t, placeholder, expenses[0].PaidByUser)
+	assert.Equal(t, DeletedUserName, expenses[0].Participants[0].ShowableName)
+	assert.Equal(t, "Alice", expenses[0].Participants[1].User.ShowableName)
+	assert.Equal(t, User{}, expenses[0].CreatedByUser)
+	assert.Equal(t, []string{"1 users not found"}, response.MultiValueHeaders[common.WarningHeader])
+}
+
+func TestGetUsersByIdsUnprocessedKeys(t *testing.T) {
+	// Set up the fake DynamoDB leaving half of the keys of every batch unprocessed
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"vassistant-users": {
+			{"userId": "user-1", "showableName": "Alice"},
+			{"userId": "user-2", "showableName": "Bob"},
+			{"userId": "user-3", "showableName": "Carol"},
+			{"userId": "user-4", "showableName": "Dave"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(faults.NewPartialBatches(fake, &faults.Config{PartialBatchRate: 1}))
+	var waits []time.Duration
+	retryJitter = func(ceiling time.Duration) time.Duration {
+		waits = append(waits, ceiling)
+		return 0
+	}
+	defer func() { retryJitter = rand.N[time.Duration] }()
+
+	// The unprocessed keys are requested again, waiting longer each time
+	users, err := h.getUsersByIds(context.TODO(), map[string]struct{}{"user-1": {}, "user-2": {}, "user-3": {}, "user-4": {}})
+	assert.NoError(t, err)
+	assert.Len(t, users, 4)
+	assert.Equal(t, "Dave", users.User("user-4").ShowableName)
+	assert.Equal(t, []time.Duration{unprocessedBackoff, 2 * unprocessedBackoff}, waits)
+}
@@ -0,0 +1,306 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/i18n"
+	"vassistant-backend/notifications"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WeeklyDigestNotification is the notification type of the weekly digests, muted by the
+// unsubscribe links in them.
+const WeeklyDigestNotification = "WEEKLY_DIGEST"
+
+// weeklyHorizon is how far ahead the weekly digest announces the monthly expenses.
+const weeklyHorizon = 7 * 24 * time.Hour
+
+// WeeklyBalance is the balance of the user in a currency of a group, with how much of it
+// the expenses added over the week make up.
+type WeeklyBalance struct {
+	Currency string      `json:"currency"`
+	Balance  json.Number `json:"balance"`
+	Change   json.Number `json:"change"`
+}
+
+// WeeklyGroupDigest is what happened in a group over the week, from Since to Until in the
+// days of its time zone.
+type WeeklyGroupDigest struct {
+	GroupID     string            `json:"groupId"`
+	GroupName   string            `json:"groupName"`
+	Since       string            `json:"since"`
+	Until       string            `json:"until"`
+	NewExpenses int               `json:"newExpenses"`
+	Balances    []WeeklyBalance   `json:"balances"`
+	Upcoming    []UpcomingExpense `json:"upcoming"`
+}
+
+// WeeklyDigest is the weekly summary of the groups of a user.
+type WeeklyDigest struct {
+	Groups []WeeklyGroupDigest `json:"groups"`
+}
+
+// Empty reports whether nothing happened in the groups and nothing is outstanding or due.
+func (d WeeklyDigest) Empty() bool {
+	for _, group := range d.Groups {
+		if group.NewExpenses > 0 || len(group.Balances) > 0 || len(group.Upcoming) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WeeklyDigestResult summarizes a run of the weekly digests.
+type WeeklyDigestResult struct {
+	Users   int
+	Sent    int
+	Skipped int
+	Failed  int
+}
+
+// weeklyGroupDigest summarizes the expenses of the group for the user over the 7 whole days
+// before today, in the time zone of now. The expenses are new by when they were added, so
+// the backdated ones count too.
+func weeklyGroupDigest(group GroupMember, expenses []FinancialExpense, userId, defaultCurrency string, now time.Time) WeeklyGroupDigest {
+	until := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	since := until.AddDate(0, 0, -7)
+
+	var added []FinancialExpense
+	for _, expense := range expenses {
+		at, err := time.Parse(time.RFC3339, expense.CreatedAt)
+		if err != nil || at.Before(since) || !at.Before(until) {
+			continue
+		}
+		added = append(added, expense)
+	}
+
+	balances := groupBalances(expenses, defaultCurrency)
+	changes := groupBalances(added, defaultCurrency)
+	digest := WeeklyGroupDigest{
+		GroupID:     group.GroupID,
+		GroupName:   group.GroupName,
+		Since:       since.Format(time.RFC3339),
+		Until:       until.Format(time.RFC3339),
+		NewExpenses: len(added),
+		Balances:    []WeeklyBalance{},
+		Upcoming:    upcomingExpenses(group, expenses, userId, defaultCurrency, now, weeklyHorizon),
+	}
+	if digest.Upcoming == nil {
+		digest.Upcoming = []UpcomingExpense{}
+	}
+	for key, balance := range balances {
+		change := changes[key]
+		if change == nil {
+			change = new(big.Rat)
+		}
+		if key.userId != userId || (balance.Sign() == 0 && change.Sign() == 0) {
+			continue
+		}
+		digest.Balances = append(digest.Balances, WeeklyBalance{
+			Currency: key.currency,
+			Balance:  json.Number(balance.FloatString(2)),
+			Change:   json.Number(change.FloatString(2)),
+		})
+	}
+	sort.Slice(digest.Balances, func(i, j int) bool { return digest.Balances[i].Currency < digest.Balances[j].Currency })
+	return digest
+}
+
+// WeeklyDigest returns the weekly summary of every group of the user, each in the days of
+// its time zone.
+func (h *Handlers) WeeklyDigest(ctx context.Context, userId string, now time.Time) (WeeklyDigest, error) {
+	groups, err := h.listUserGroups(ctx, userId)
+	if err != nil {
+		return WeeklyDigest{}, err
+	}
+
+	digest := WeeklyDigest{Groups: []WeeklyGroupDigest{}}
+	for _, group := range groups {
+		settings, err := h.getGroupSettings(ctx, group.GroupID)
+		if err != nil {
+			return WeeklyDigest{}, err
+		}
+		expenses, err := h.queryExpenses(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String("splitter-expenses"),
+			KeyConditionExpression: aws.String("groupId = :groupId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":groupId": &types.AttributeValueMemberS{Value: group.GroupID},
+			},
+		})
+		if err != nil {
+			return WeeklyDigest{}, err
+		}
+		groupNow := now.In(settings.location())
+		digest.Groups = append(digest.Groups, weeklyGroupDigest(group, expenses, userId, settings.DefaultCurrency, groupNow))
+	}
+	sort.Slice(digest.Groups, func(i, j int) bool { return digest.Groups[i].GroupName < digest.Groups[j].GroupName })
+	return digest, nil
+}
+
+// signed formats a change with its sign, e.g. +12.50.
+func signed(amount json.Number) string {
+	if strings.HasPrefix(string(amount), "-") {
+		return string(amount)
+	}
+	return "+" + string(amount)
+}
+
+// RenderWeeklyDigest writes the text of the digest from the digest.weekly templates of the
+// catalog, a line per new expenses, balance and upcoming expense of the groups.
+func RenderWeeklyDigest(language string, digest WeeklyDigest) string {
+	var lines []string
+	for _, group := range digest.Groups {
+		if group.NewExpenses > 0 {
+			lines = append(lines, i18n.T(language, "digest.weekly.expenses", map[string]string{
+				"groupName": group.GroupName,
+				"count":     strconv.Itoa(group.NewExpenses),
+			}))
+		}
+		for _, balance := range group.Balances {
+			data := map[string]string{
+				"groupName": group.GroupName,
+				"currency":  balance.Currency,
+				"amount":    strings.TrimPrefix(string(balance.Balance), "-"),
+				"change":    signed(balance.Change),
+			}
+			key := "digest.weekly.owed"
+			if amount, ok := new(big.Rat).SetString(string(balance.Balance)); ok && amount.Sign() < 0 {
+				key = "digest.weekly.owes"
+			} else if ok && amount.Sign() == 0 {
+				key = "digest.weekly.settled"
+			}
+			lines = append(lines, i18n.T(language, key, data))
+		}
+		for _, upcoming := range group.Upcoming {
+			lines = append(lines, i18n.T(language, "digest.weekly.upcoming", map[string]string{
+				"title":     upcoming.Title,
+				"groupName": upcoming.GroupName,
+				"amount":    string(upcoming.Amount),
+				"currency":  upcoming.Currency,
+				"date":      upcoming.DueAt[:len("2006-01-02")],
+			}))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// listMemberIds returns the users who are members of a group not waiting to be purged,
+// following the pages of the scan.
+func (h *Handlers) listMemberIds(ctx context.Context) ([]string, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName:                aws.String("splitter-group-members"),
+		ProjectionExpression:     aws.String("userId, #status"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+	}
+
+	seen := map[string]struct{}{}
+	for {
+		result, err := h.client.Scan(ctx, scanInput)
+		if err != nil {
+			return nil, err
+		}
+		var members []GroupMember
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &members)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if member.Status != GroupDeletedPending {
+				seen[member.UserID] = struct{}{}
+			}
+		}
+
+		scanInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(scanInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+
+	userIds := make([]string, 0, len(seen))
+	for userId := range seen {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return userIds, nil
+}
+
+// sendWeeklyDigest notifies the user of their weekly digest, unless they unsubscribed or it
+// is empty. It reports whether the digest was sent.
+func (h *Handlers) sendWeeklyDigest(ctx context.Context, userId string, now time.Time) (bool, error) {
+	preferences, err := notifications.GetPreferences(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if !preferences.Sends(WeeklyDigestNotification) {
+		return false, nil
+	}
+	digest, err := h.WeeklyDigest(ctx, userId, now)
+	if err != nil {
+		return false, err
+	}
+	if digest.Empty() {
+		return false, nil
+	}
+
+	link, err := notifications.UnsubscribeLink(ctx, userId, WeeklyDigestNotification)
+	if err != nil {
+		return false, err
+	}
+	newExpenses := 0
+	for _, group := range digest.Groups {
+		newExpenses += group.NewExpenses
+	}
+
+	// Like the messages of the notifications, the text is stored in the default language
+	text := RenderWeeklyDigest(i18n.DefaultLanguage, digest)
+	data := map[string]string{
+		"groupCount":   strconv.Itoa(len(digest.Groups)),
+		"expenseCount": strconv.Itoa(newExpenses),
+		"digest":       text,
+	}
+	if link != "" {
+		data["unsubscribeUrl"] = link
+		data["digest"] = text + "\n" + i18n.T(i18n.DefaultLanguage, "digest.weekly.unsubscribe", map[string]string{"url": link})
+	}
+	err = notifications.Notify(ctx, userId, WeeklyDigestNotification, data)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SendWeeklyDigests sends their weekly digest to every member of a group who didn't
+// unsubscribe from it. A user failing is logged and counted without stopping the run.
+func (h *Handlers) SendWeeklyDigests(ctx context.Context, now time.Time) (WeeklyDigestResult, error) {
+	var result WeeklyDigestResult
+	userIds, err := h.listMemberIds(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for _, userId := range userIds {
+		result.Users++
+		sent, err := h.sendWeeklyDigest(ctx, userId, now)
+		if err != nil {
+			log.Printf("Error sending the weekly digest of user %s: %v", userId, err)
+			result.Failed++
+			continue
+		}
+		if sent {
+			result.Sent++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
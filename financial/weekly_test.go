@@ -0,0 +1,145 @@
+package financial
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendWeeklyDigests(t *testing.T) {
+	// Set up the fake DynamoDB with a monthly rent due this week and groceries added last
+	// week in House, and a group without anything going on
+	now := time.Date(2024, 4, 15, 8, 0, 0, 0, time.UTC)
+	expense := func(title, amount, half, dateTime, createdAt string) map[string]interface{} {
+		return map[string]interface{}{
+			"groupId": "house", "expenseId": dateTime, "title": title, "amount": amount, "paidBy": "user-1",
+			"dateTime": dateTime, "createdAt": createdAt,
+			"participants": []map[string]interface{}{{"userId": "user-1", "calculatedMoney": half}, {"userId": "user-2", "calculatedMoney": half}},
+		}
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+			{"userId": "user-3", "groupId": "trip", "groupName": "Trip"},
+		},
+		"splitter-group-settings": {{"groupId": "house", "defaultCurrency": "EUR"}, {"groupId": "trip", "defaultCurrency": "EUR"}},
+		"splitter-expenses": {
+			expense("Rent", "1000", "500", "2024-02-20T10:00:00Z", "2024-02-20T10:00:00Z"),
+			expense("Rent", "1000", "500", "2024-03-20T10:00:00Z", "2024-03-20T10:00:00Z"),
+			// Backdated, but added last week
+			expense("Groceries", "60", "30", "2024-03-30T18:00:00Z", "2024-04-10T12:00:00Z"),
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+	notifications.DynamoDbClient = fake
+	notifications.UnsubscribeURL = "https://api.example.com/public/unsubscribe"
+	defer func() { notifications.UnsubscribeURL = "" }()
+
+	result, err := h.SendWeeklyDigests(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, WeeklyDigestResult{Users: 3, Sent: 2, Skipped: 1}, result)
+
+	request := testutil.NewRequest("GET", "/notifications").WithClaims("user-2", "bob").Build()
+	response, err := notifications.GetNotificationsHandler(request)
+	assert.NoError(t, err)
+	var received []notifications.Notification
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &received))
+	assert.Len(t, received, 1)
+	assert.Equal(t, WeeklyDigestNotification, received[0].Type)
+	assert.Equal(t, "Your week: 1 new expenses in 1 groups", received[0].Message)
+	link := received[0].Data["unsubscribeUrl"]
+	assert.Equal(t, "House: 1 new expenses this week\n"+
+		"House: you owe 1030.00 EUR, -30.00 this week\n"+
+		"Rent in House, usually 1000 EUR, is due on 2024-04-20\n"+
+		"Stop the weekly digests: "+link, received[0].Data["digest"])
+
+	// The link unsubscribes the user without signing in, a tampered one is turned away
+	parsed, err := url.Parse(link)
+	assert.NoError(t, err)
+	request = testutil.NewRequest("POST", "/public/unsubscribe").
+		WithQueryParam("token", parsed.Query().Get("token")+"x").
+		WithQueryParam("type", WeeklyDigestNotification).
+		Build()
+	response, err = notifications.UnsubscribeHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	// Opening the link only asks to confirm
+	request = testutil.NewRequest("GET", "/public/unsubscribe").
+		WithQueryParam("token", parsed.Query().Get("token")).
+		WithQueryParam("type", WeeklyDigestNotification).
+		Build()
+	response, err = notifications.GetUnsubscribeHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, response.Body, `<form method="post">`)
+	preferences, err := notifications.GetPreferences(context.TODO(), "user-2")
+	assert.NoError(t, err)
+	assert.Empty(t, preferences.Muted)
+
+	request.HTTPMethod = "POST"
+	response, err = notifications.UnsubscribeHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// The next digests aren't sent to the user who unsubscribed
+	result, err = h.SendWeeklyDigests(context.TODO(), now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	assert.Equal(t, WeeklyDigestResult{Users: 3, Sent: 1, Skipped: 2}, result)
+	preferences, err = notifications.GetPreferences(context.TODO(), "user-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{WeeklyDigestNotification}, preferences.Muted)
+}
+
+func TestWeeklyGroupDigest(t *testing.T) {
+	group := GroupMember{GroupID: "house", GroupName: "House"}
+	added := func(expense FinancialExpense, createdAt string) FinancialExpense {
+		expense.CreatedAt = createdAt
+		normalizePayers(&expense)
+		return expense
+	}
+	expenses := []FinancialExpense{
+		added(sharedExpense("Pizza", "FOOD", "30", "15", "2024-04-13T20:00:00Z"), "2024-04-15T01:30:00Z"),
+		added(sharedExpense("Coffee", "FOOD", "8", "4", "2024-04-07T20:00:00Z"), "2024-04-07T20:00:00Z"),
+	}
+
+	// The week is made of whole days in the time zone of the group: in São Paulo the pizza
+	// was added on Sunday, and the coffee a week before the digest of Monday
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	assert.NoError(t, err)
+	now := time.Date(2024, 4, 15, 8, 0, 0, 0, saoPaulo)
+	digest := weeklyGroupDigest(group, expenses, "user-1", "EUR", now)
+	assert.Equal(t, "2024-04-08T00:00:00-03:00", digest.Since)
+	assert.Equal(t, "2024-04-15T00:00:00-03:00", digest.Until)
+	assert.Equal(t, 1, digest.NewExpenses)
+	assert.Equal(t, []WeeklyBalance{{Currency: "EUR", Balance: "19.00", Change: "15.00"}}, digest.Balances)
+	assert.Empty(t, digest.Upcoming)
+
+	// In UTC the pizza was added on Monday, the day of the digest
+	digest = weeklyGroupDigest(group, expenses, "user-1", "EUR", now.UTC())
+	assert.Equal(t, 0, digest.NewExpenses)
+	assert.Equal(t, []WeeklyBalance{{Currency: "EUR", Balance: "19.00", Change: "0.00"}}, digest.Balances)
+}
+
+func TestRenderWeeklyDigest(t *testing.T) {
+	digest := WeeklyDigest{Groups: []WeeklyGroupDigest{{
+		GroupName:   "Casa",
+		NewExpenses: 2,
+		Balances: []WeeklyBalance{
+			{Currency: "BRL", Balance: "0.00", Change: "-12.00"},
+			{Currency: "EUR", Balance: "25.50", Change: "10.00"},
+		},
+	}}}
+	assert.Equal(t, "Casa: 2 novas despesas nesta semana\n"+
+		"Casa: você está quite em BRL, -12.00 nesta semana\n"+
+		"Casa: você tem 25.50 EUR a receber, +10.00 nesta semana", RenderWeeklyDigest("pt-BR", digest))
+}
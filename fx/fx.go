@@ -0,0 +1,122 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrRateNotFound is returned when no exchange rate is known for a currency pair.
+var ErrRateNotFound = errors.New("exchange rate not found")
+
+// Rate struct for the fx-rates table, holding how many units of To one unit of From buys
+type Rate struct {
+	Pair string      `json:"pair" dynamodbav:"pair"`
+	Date string      `json:"date" dynamodbav:"date"`
+	Rate json.Number `json:"rate" dynamodbav:"rate"`
+}
+
+// Conversion describes an amount converted into another currency
+type Conversion struct {
+	Currency string      `json:"currency" dynamodbav:"currency"`
+	Amount   json.Number `json:"amount" dynamodbav:"amount"`
+	Rate     json.Number `json:"rate" dynamodbav:"rate"`
+	RateDate string      `json:"rateDate" dynamodbav:"rateDate"`
+	Text     string      `json:"text,omitempty" dynamodbav:"-"` // the amount formatted for display, when asked for
+}
+
+var DynamoDbClient common.DynamoDBAPI
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidCurrency reports whether the code looks like an ISO 4217 currency code.
+func ValidCurrency(code string) bool {
+	return currencyPattern.MatchString(code)
+}
+
+// pairKey is the partition key of the rates of a currency pair, e.g. USD-BRL.
+func pairKey(from, to string) string {
+	return from + "-" + to
+}
+
+// GetRate returns the latest exchange rate from one currency to another published on or
+// before the date (formatted as 2006-01-02). When only the inverse pair is known its
+// inverse rate is returned.
+func GetRate(ctx context.Context, from, to, date string) (Rate, error) {
+	if from == to {
+		return Rate{Pair: pairKey(from, to), Date: date, Rate: "1"}, nil
+	}
+
+	rate, err := queryRate(ctx, pairKey(from, to), date)
+	if err == nil || !errors.Is(err, ErrRateNotFound) {
+		return rate, err
+	}
+
+	inverse, err := queryRate(ctx, pairKey(to, from), date)
+	if err != nil {
+		return Rate{}, err
+	}
+	value, ok := new(big.Rat).SetString(string(inverse.Rate))
+	if !ok || value.Sign() == 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q for %s", inverse.Rate, inverse.Pair)
+	}
+	return Rate{
+		Pair: pairKey(from, to),
+		Date: inverse.Date,
+		Rate: json.Number(new(big.Rat).Inv(value).FloatString(8)),
+	}, nil
+}
+
+func queryRate(ctx context.Context, pair, date string) (Rate, error) {
+	result, err := DynamoDbClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("fx-rates"),
+		KeyConditionExpression: aws.String("pair = :pair AND #date <= :date"),
+		ExpressionAttributeNames: map[string]string{
+			"#date": "date",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pair": &types.AttributeValueMemberS{Value: pair},
+			":date": &types.AttributeValueMemberS{Value: date},
+		},
+		ScanIndexForward: aws.Bool(false), // Latest rate first
+		Limit:            aws.Int32(1),
+	})
+	if err != nil {
+		return Rate{}, err
+	}
+	if len(result.Items) == 0 {
+		return Rate{}, ErrRateNotFound
+	}
+
+	var rate Rate
+	err = attributevalue.UnmarshalMap(result.Items[0], &rate)
+	return rate, err
+}
+
+// Convert converts the amount with the rate, rounding to cents.
+func Convert(amount json.Number, currency string, rate Rate) (Conversion, error) {
+	value, ok := new(big.Rat).SetString(string(amount))
+	if !ok {
+		return Conversion{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	rateValue, ok := new(big.Rat).SetString(string(rate.Rate))
+	if !ok {
+		return Conversion{}, fmt.Errorf("invalid rate %q", rate.Rate)
+	}
+
+	return Conversion{
+		Currency: currency,
+		Amount:   json.Number(new(big.Rat).Mul(value, rateValue).FloatString(2)),
+		Rate:     rate.Rate,
+		RateDate: rate.Date,
+	}, nil
+}
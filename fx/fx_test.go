@@ -0,0 +1,157 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRate(t *testing.T) {
+	// Set up the fake DynamoDB client with two rates of the same pair
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"fx-rates": {
+			{"pair": "USD-BRL", "date": "2024-01-01", "rate": 5},
+			{"pair": "USD-BRL", "date": "2024-01-03", "rate": 4.8},
+		},
+	})
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	tests := []struct {
+		name     string
+		from, to string
+		date     string
+		want     Rate
+	}{
+		{"latest rate", "USD", "BRL", "2024-01-05", Rate{Pair: "USD-BRL", Date: "2024-01-03", Rate: "4.8"}},
+		{"rate on or before the date", "USD", "BRL", "2024-01-02", Rate{Pair: "USD-BRL", Date: "2024-01-01", Rate: "5"}},
+		{"inverse pair", "BRL", "USD", "2024-01-05", Rate{Pair: "BRL-USD", Date: "2024-01-03", Rate: "0.20833333"}},
+		{"same currency", "BRL", "BRL", "2024-01-05", Rate{Pair: "BRL-BRL", Date: "2024-01-05", Rate: "1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rate, err := GetRate(context.TODO(), test.from, test.to, test.date)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, rate)
+		})
+	}
+}
+
+func TestGetRateNotFound(t *testing.T) {
+	// Set up the mock DynamoDB client returning no rates
+	DynamoDbClient = &testutil.MockDynamoDBClient{
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			assert.Equal(t, "fx-rates", aws.ToString(params.TableName))
+			assert.False(t, aws.ToBool(params.ScanIndexForward))
+			return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{}}, nil
+		},
+	}
+
+	_, err := GetRate(context.TODO(), "USD", "JPY", "2024-01-05")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
+
+func TestConvert(t *testing.T) {
+	conversion, err := Convert("30.50", "EUR", Rate{Pair: "BRL-EUR", Date: "2024-01-02", Rate: "0.18181818"})
+	assert.NoError(t, err)
+	assert.Equal(t, Conversion{Currency: "EUR", Amount: "5.55", Rate: "0.18181818", RateDate: "2024-01-02"}, conversion)
+
+	_, err = Convert(json.Number("abc"), "EUR", Rate{Rate: "1"})
+	assert.Error(t, err)
+}
+
+func TestValidCurrency(t *testing.T) {
+	assert.True(t, ValidCurrency("BRL"))
+	assert.False(t, ValidCurrency("brl"))
+	assert.False(t, ValidCurrency("BRLX"))
+	assert.False(t, ValidCurrency(""))
+}
+
+func TestHistoricalRate(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"fx-rates": {{"pair": "USD-BRL", "date": "2024-01-01", "rate": 5}},
+	})
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	// Without a provider the latest stored rate is used
+	rate, err := HistoricalRate(context.TODO(), "USD", "BRL", "2024-01-03")
+	assert.NoError(t, err)
+	assert.Equal(t, Rate{Pair: "USD-BRL", Date: "2024-01-01", Rate: "5"}, rate)
+
+	// The rate of the date is fetched once, then read from the table
+	var fetches []string
+	DefaultProvider = ProviderFunc(func(ctx context.Context, from, to, date string) (Rate, error) {
+		fetches = append(fetches, from+"-"+to+" "+date)
+		return Rate{Pair: from + "-" + to, Date: "2024-01-02", Rate: "4.9"}, nil
+	})
+	defer func() { DefaultProvider = nil }()
+	for range 2 {
+		rate, err = HistoricalRate(context.TODO(), "USD", "BRL", "2024-01-03")
+		assert.NoError(t, err)
+		assert.Equal(t, Rate{Pair: "USD-BRL", Date: "2024-01-03", Rate: "4.9"}, rate)
+	}
+	assert.Equal(t, []string{"USD-BRL 2024-01-03"}, fetches)
+
+	// A failing provider falls back to the stored rates
+	DefaultProvider = ProviderFunc(func(ctx context.Context, from, to, date string) (Rate, error) {
+		return Rate{}, errors.New("unavailable")
+	})
+	rate, err = HistoricalRate(context.TODO(), "USD", "BRL", "2024-01-02")
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01", rate.Date)
+	_, err = HistoricalRate(context.TODO(), "USD", "JPY", "2024-01-02")
+	assert.Error(t, err)
+}
+
+func TestFrankfurter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbols") == "XXX" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "/2024-01-06", r.URL.Path)
+		assert.Equal(t, "USD", r.URL.Query().Get("base"))
+		w.Write([]byte(`{"amount":1.0,"base":"USD","date":"2024-01-05","rates":{"BRL":4.8853}}`))
+	}))
+	defer server.Close()
+	provider := &Frankfurter{BaseURL: server.URL, HTTPClient: server.Client()}
+
+	rate, err := provider.FetchRate(context.TODO(), "USD", "BRL", "2024-01-06")
+	assert.NoError(t, err)
+	assert.Equal(t, Rate{Pair: "USD-BRL", Date: "2024-01-05", Rate: "4.8853"}, rate)
+
+	_, err = provider.FetchRate(context.TODO(), "USD", "XXX", "2024-01-06")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
+
+func TestStub(t *testing.T) {
+	rate, err := Stub{}.FetchRate(context.TODO(), "USD", "BRL", "2024-03-02")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD-BRL", rate.Pair)
+	assert.Equal(t, "2024-03-02", rate.Date)
+
+	// The rates are the same every time and the inverse pair gets the inverse rate
+	again, err := Stub{}.FetchRate(context.TODO(), "USD", "BRL", "2025-01-01")
+	assert.NoError(t, err)
+	assert.Equal(t, rate.Rate, again.Rate)
+	inverse, err := Stub{}.FetchRate(context.TODO(), "BRL", "USD", "2024-03-02")
+	assert.NoError(t, err)
+	product, _ := new(big.Rat).SetString(string(rate.Rate))
+	inverseRate, _ := new(big.Rat).SetString(string(inverse.Rate))
+	identity, _ := product.Mul(product, inverseRate).Float64()
+	assert.InDelta(t, 1, identity, 0.0001)
+
+	_, err = Stub{}.FetchRate(context.TODO(), "USD", "bitcoin", "2024-03-02")
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
@@ -0,0 +1,122 @@
+package fx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Provider fetches the exchange rate of a currency pair published for a date (formatted as
+// 2006-01-02), or the last one published before it, e.g. on weekends.
+type Provider interface {
+	FetchRate(ctx context.Context, from, to, date string) (Rate, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface.
+type ProviderFunc func(ctx context.Context, from, to, date string) (Rate, error)
+
+func (f ProviderFunc) FetchRate(ctx context.Context, from, to, date string) (Rate, error) {
+	return f(ctx, from, to, date)
+}
+
+// DefaultProvider backfills the fx-rates table with the historical rates missing from it.
+// It is nil until one is configured, leaving only the rates already stored.
+var DefaultProvider Provider
+
+// Frankfurter fetches the reference rates of the European Central Bank from the Frankfurter
+// API, which needs no API key.
+type Frankfurter struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewFrankfurter creates a provider calling the public Frankfurter API.
+func NewFrankfurter() *Frankfurter {
+	return &Frankfurter{
+		BaseURL:    "https://api.frankfurter.dev/v1",
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (f *Frankfurter) FetchRate(ctx context.Context, from, to, date string) (Rate, error) {
+	target := fmt.Sprintf("%s/%s?%s", f.BaseURL, date, url.Values{"base": {from}, "symbols": {to}}.Encode())
+	request, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return Rate{}, err
+	}
+	response, err := f.HTTPClient.Do(request)
+	if err != nil {
+		return Rate{}, err
+	}
+	defer response.Body.Close()
+
+	// Unknown currencies are answered with 404
+	if response.StatusCode == http.StatusNotFound {
+		return Rate{}, ErrRateNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return Rate{}, fmt.Errorf("Frankfurter returned %s for %s-%s on %s", response.Status, from, to, date)
+	}
+
+	var body struct {
+		Date  string                 `json:"date"`
+		Rates map[string]json.Number `json:"rates"`
+	}
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return Rate{}, err
+	}
+	rate, ok := body.Rates[to]
+	if !ok {
+		return Rate{}, ErrRateNotFound
+	}
+	return Rate{Pair: pairKey(from, to), Date: body.Date, Rate: rate}, nil
+}
+
+// HistoricalRate returns the exchange rate from one currency to another on the date. A rate
+// missing from the fx-rates table is fetched from the DefaultProvider and stored for the
+// date, so each pair and date is only fetched once. Without a provider, or when it fails,
+// the latest rate stored before the date is returned like GetRate does.
+func HistoricalRate(ctx context.Context, from, to, date string) (Rate, error) {
+	stored, err := GetRate(ctx, from, to, date)
+	if err == nil && stored.Date == date {
+		return stored, nil
+	}
+	if DefaultProvider == nil || (err != nil && !errors.Is(err, ErrRateNotFound)) {
+		return stored, err
+	}
+
+	fetched, fetchErr := DefaultProvider.FetchRate(ctx, from, to, date)
+	if fetchErr != nil {
+		log.Printf("Error fetching the %s-%s rate of %s: %v", from, to, date, fetchErr)
+		if err == nil {
+			return stored, nil
+		}
+		return Rate{}, fetchErr
+	}
+
+	// The rate is stored for the date asked, the one published then being in effect
+	rate := Rate{Pair: pairKey(from, to), Date: date, Rate: fetched.Rate}
+	item, err := attributevalue.MarshalMap(rate)
+	if err != nil {
+		return Rate{}, err
+	}
+	_, err = DynamoDbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String("fx-rates"),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("Error putting the %s rate of %s into DynamoDB: %v", rate.Pair, date, err)
+	}
+	return rate, nil
+}
@@ -0,0 +1,182 @@
+// Package i18n holds the message catalogs used to localize the labels of enumerations and
+// user-facing texts, while the API keeps returning stable machine codes next to them.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// DefaultLanguage is used when none of the requested languages is supported.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// placeholderPattern matches the {name} placeholders of a message.
+var placeholderPattern = regexp.MustCompile(`\{\w+\}`)
+
+// catalogs maps each supported language to its messages, keyed by message key.
+var catalogs = loadCatalogs()
+
+// Label is a machine code with its localized label.
+type Label struct {
+	Code  string `json:"code"`
+	Label string `json:"label"`
+}
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	loaded := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic("invalid catalog " + file.Name() + ": " + err.Error())
+		}
+		loaded[strings.TrimSuffix(file.Name(), ".json")] = messages
+	}
+	return loaded
+}
+
+// Languages returns the supported languages, sorted.
+func Languages() []string {
+	languages := make([]string, 0, len(catalogs))
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Match returns the supported language for a language tag, matching the primary language
+// when the region isn't supported, e.g. pt-PT gets pt-BR.
+func Match(tag string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", false
+	}
+	for language := range catalogs {
+		if strings.EqualFold(language, tag) {
+			return language, true
+		}
+	}
+
+	primary, _, _ := strings.Cut(tag, "-")
+	candidates := make([]string, 0, len(catalogs))
+	for language := range catalogs {
+		languagePrimary, _, _ := strings.Cut(language, "-")
+		if strings.EqualFold(languagePrimary, primary) {
+			candidates = append(candidates, language)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Strings(candidates)
+	return candidates[0], true
+}
+
+// Language picks the language of the response: the locale preference of the user from
+// their claims, then the Accept-Language header, then DefaultLanguage.
+func Language(request events.APIGatewayProxyRequest) string {
+	if claims, err := auth.ParseClaims(request); err == nil {
+		if language, ok := Match(claims.Locale); ok {
+			return language
+		}
+	}
+
+	for _, tag := range acceptedLanguages(common.Header(request, "Accept-Language")) {
+		if language, ok := Match(tag); ok {
+			return language
+		}
+	}
+	return DefaultLanguage
+}
+
+// acceptedLanguages returns the language tags of an Accept-Language header, most preferred first.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag    string
+		weight float64
+	}
+
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight > 0 {
+			tags = append(tags, weighted{tag, weight})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].weight > tags[j].weight
+	})
+
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.tag
+	}
+	return result
+}
+
+// T returns the message of the key in the language, with {name} placeholders replaced
+// with the data. Missing messages fall back to DefaultLanguage, then to the key itself.
+func T(language, key string, data map[string]string) string {
+	message, ok := Render(language, key, data)
+	if !ok && message == "" {
+		return key
+	}
+	return message
+}
+
+// Render is like T, but reports whether the message was found and all its placeholders
+// were filled, so callers can keep a text of their own otherwise.
+func Render(language, key string, data map[string]string) (string, bool) {
+	message, ok := catalogs[language][key]
+	if !ok {
+		message, ok = catalogs[DefaultLanguage][key]
+	}
+	if !ok {
+		return "", false
+	}
+	for name, value := range data {
+		message = strings.ReplaceAll(message, "{"+name+"}", value)
+	}
+	return message, !placeholderPattern.MatchString(message)
+}
+
+// Labels localizes the codes, looking up each one under the key prefix, e.g. "category.".
+func Labels(language, prefix string, codes []string) []Label {
+	labels := make([]Label, 0, len(codes))
+	for _, code := range codes {
+		labels = append(labels, Label{Code: code, Label: T(language, prefix+code, nil)})
+	}
+	return labels
+}
@@ -0,0 +1,69 @@
+package i18n
+
+import (
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	// Exact matches are case-insensitive
+	language, ok := Match("PT-br")
+	assert.True(t, ok)
+	assert.Equal(t, "pt-BR", language)
+
+	// Unsupported regions fall back to the primary language
+	language, ok = Match("pt-PT")
+	assert.True(t, ok)
+	assert.Equal(t, "pt-BR", language)
+
+	_, ok = Match("de-DE")
+	assert.False(t, ok)
+}
+
+func TestLanguage(t *testing.T) {
+	// Languages are tried in the order of their weights
+	request := testutil.NewRequest("GET", "/categories").
+		WithHeader("Accept-Language", "de;q=0.9, pt-BR;q=0.8, en;q=0.1").
+		Build()
+	assert.Equal(t, "pt-BR", Language(request))
+
+	// The locale of the user takes precedence over the header
+	request = testutil.NewRequest("GET", "/categories").
+		WithClaims("user-1", "alice").
+		WithClaim("locale", "en-US").
+		WithHeader("Accept-Language", "pt-BR").
+		Build()
+	assert.Equal(t, "en", Language(request))
+
+	// Unsupported languages get the default one
+	request = testutil.NewRequest("GET", "/categories").WithHeader("Accept-Language", "de, *;q=0.5").Build()
+	assert.Equal(t, DefaultLanguage, Language(request))
+}
+
+func TestT(t *testing.T) {
+	assert.Equal(t, "Seu pedido para entrar em House foi aprovado",
+		T("pt-BR", "notification.JOIN_REQUEST_APPROVED", map[string]string{"groupName": "House"}))
+
+	// Missing messages fall back to the default language, then to the key
+	assert.Equal(t, "Food", T("fr", "category.FOOD", nil))
+	assert.Equal(t, "category.UNKNOWN", T("en", "category.UNKNOWN", nil))
+
+	// Messages with unfilled placeholders aren't rendered
+	_, ok := Render("en", "notification.JOIN_REQUEST_APPROVED", nil)
+	assert.False(t, ok)
+}
+
+func TestLabels(t *testing.T) {
+	labels := Labels("pt-BR", "category.", []string{"FOOD"})
+	assert.Equal(t, []Label{{Code: "FOOD", Label: "Alimentação"}}, labels)
+}
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	for language, messages := range catalogs {
+		for key := range catalogs[DefaultLanguage] {
+			assert.Contains(t, messages, key, "missing in %s", language)
+		}
+	}
+}
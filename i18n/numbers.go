@@ -0,0 +1,172 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// The rounding modes of the displayed amounts.
+const (
+	RoundHalfUp   = "HALF_UP"
+	RoundHalfEven = "HALF_EVEN"
+	RoundDown     = "DOWN"
+	RoundUp       = "UP"
+)
+
+// roundingModes lists the supported rounding modes
+var roundingModes = []string{RoundHalfUp, RoundHalfEven, RoundDown, RoundUp}
+
+// defaultDecimalPlaces is the number of decimals displayed when the format sets none.
+const defaultDecimalPlaces = 2
+
+// maxDecimalPlaces bounds the decimals displayed, the amounts being stored with no more.
+const maxDecimalPlaces = 4
+
+// separators holds the thousands and decimal separators of a locale.
+type separators struct {
+	thousands string
+	decimal   string
+}
+
+// localeSeparators maps the locales, or their primary language, to their separators, the
+// spaces being non-breaking. The locales missing from it are formatted like DefaultLanguage.
+var localeSeparators = map[string]separators{
+	"en":    {",", "."},
+	"ja":    {",", "."},
+	"zh":    {",", "."},
+	"pt":    {".", ","},
+	"es":    {".", ","},
+	"de":    {".", ","},
+	"it":    {".", ","},
+	"nl":    {".", ","},
+	"de-CH": {"'", "."},
+	"fr":    {"\u202f", ","},
+	"pl":    {"\u00a0", ","},
+	"sv":    {"\u00a0", ","},
+}
+
+// NumberFormat is how the amounts are displayed: the decimals shown, how they are rounded
+// to them and the separators of the locale. The zero value formats like "1,234.56".
+type NumberFormat struct {
+	DecimalPlaces *int   `json:"decimalPlaces,omitempty" dynamodbav:"decimalPlaces,omitempty"`
+	Rounding      string `json:"rounding,omitempty" dynamodbav:"rounding,omitempty"`
+	Locale        string `json:"locale,omitempty" dynamodbav:"locale,omitempty"`
+}
+
+// Validate checks the format is one the amounts can be displayed with.
+func (f NumberFormat) Validate() error {
+	if f.DecimalPlaces != nil && (*f.DecimalPlaces < 0 || *f.DecimalPlaces > maxDecimalPlaces) {
+		return fmt.Errorf("invalid decimal places, expected 0 to %d", maxDecimalPlaces)
+	}
+	if f.Rounding != "" && !slices.Contains(roundingModes, f.Rounding) {
+		return fmt.Errorf("invalid rounding, expected one of %s", strings.Join(roundingModes, ", "))
+	}
+	if f.Locale != "" {
+		if _, ok := matchSeparators(f.Locale); !ok {
+			return errors.New("invalid locale")
+		}
+	}
+	return nil
+}
+
+// matchSeparators returns the separators of a locale, matching its primary language when
+// the region has none of its own, e.g. pt-BR gets those of pt.
+func matchSeparators(locale string) (separators, bool) {
+	locale = strings.TrimSpace(locale)
+	for tag, seps := range localeSeparators {
+		if strings.EqualFold(tag, locale) {
+			return seps, true
+		}
+	}
+	primary, _, _ := strings.Cut(locale, "-")
+	seps, ok := localeSeparators[strings.ToLower(primary)]
+	return seps, ok
+}
+
+// Number formats the number with the decimals, rounding and separators of the format, e.g.
+// "1.234,56" for pt-BR. Numbers that can't be parsed are returned as they are.
+func (f NumberFormat) Number(number json.Number) string {
+	value, ok := new(big.Rat).SetString(number.String())
+	if !ok {
+		return number.String()
+	}
+	places := defaultDecimalPlaces
+	if f.DecimalPlaces != nil {
+		places = *f.DecimalPlaces
+	}
+	seps, ok := matchSeparators(f.Locale)
+	if !ok {
+		seps = localeSeparators[DefaultLanguage]
+	}
+
+	// Round the number scaled to an integer of the smallest displayed unit
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(scale))
+	units := round(scaled, f.Rounding)
+
+	negative := units.Sign() < 0
+	digits := units.Abs(units).String()
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-places], digits[len(digits)-places:]
+
+	var formatted strings.Builder
+	if negative {
+		formatted.WriteString("-")
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			formatted.WriteString(seps.thousands)
+		}
+		formatted.WriteRune(digit)
+	}
+	if places > 0 {
+		formatted.WriteString(seps.decimal)
+		formatted.WriteString(fraction)
+	}
+	return formatted.String()
+}
+
+// Amount formats the amount like Number, followed by its currency, e.g. "1.234,56 BRL".
+func (f NumberFormat) Amount(amount json.Number, currency string) string {
+	if currency == "" {
+		return f.Number(amount)
+	}
+	return f.Number(amount) + " " + currency
+}
+
+// round rounds the number to an integer with the rounding mode, half up by default. Half up
+// and up round away from zero, down towards it.
+func round(number *big.Rat, mode string) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(number.Num(), number.Denom(), new(big.Int))
+	if remainder.Sign() == 0 {
+		return quotient
+	}
+	away := big.NewInt(int64(number.Sign()))
+
+	switch mode {
+	case RoundDown:
+		return quotient
+	case RoundUp:
+		return quotient.Add(quotient, away)
+	}
+
+	// Compare twice the remainder with the denominator to find which half it is in
+	half := new(big.Int).Abs(remainder)
+	half.Lsh(half, 1)
+	switch half.Cmp(number.Denom()) {
+	case 1:
+		return quotient.Add(quotient, away)
+	case 0:
+		if mode == RoundHalfEven && quotient.Bit(0) == 0 {
+			return quotient
+		}
+		return quotient.Add(quotient, away)
+	}
+	return quotient
+}
@@ -0,0 +1,48 @@
+package i18n
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumberFormat(t *testing.T) {
+	places := func(n int) *int { return &n }
+
+	// The zero value formats like English with two decimals
+	assert.Equal(t, "1,234,567.50", NumberFormat{}.Number("1234567.5"))
+	assert.Equal(t, "0.05", NumberFormat{}.Number("0.045"))
+	assert.Equal(t, "-1,000.00", NumberFormat{}.Number("-1000"))
+
+	// The separators follow the locale, or its primary language
+	assert.Equal(t, "1.234,56 BRL", NumberFormat{Locale: "pt-BR"}.Amount("1234.56", "BRL"))
+	assert.Equal(t, "1'234.56", NumberFormat{Locale: "de-ch"}.Number("1234.56"))
+	assert.Equal(t, "1\u202f234,56", NumberFormat{Locale: "fr-FR"}.Number("1234.56"))
+
+	// Each rounding mode, away from or towards zero
+	cases := []struct {
+		rounding, number, expected string
+	}{
+		{"", "2.5", "3"},
+		{RoundHalfUp, "-2.5", "-3"},
+		{RoundHalfEven, "2.5", "2"},
+		{RoundHalfEven, "3.5", "4"},
+		{RoundHalfEven, "2.51", "3"},
+		{RoundDown, "2.99", "2"},
+		{RoundDown, "-2.99", "-2"},
+		{RoundUp, "2.01", "3"},
+		{RoundUp, "-2.01", "-3"},
+	}
+	for _, c := range cases {
+		format := NumberFormat{DecimalPlaces: places(0), Rounding: c.rounding}
+		assert.Equal(t, c.expected, format.Number(json.Number(c.number)), c)
+	}
+	assert.Equal(t, "0.3334", NumberFormat{DecimalPlaces: places(4), Rounding: RoundUp}.Number("0.33333"))
+
+	// Invalid formats are rejected
+	assert.NoError(t, NumberFormat{DecimalPlaces: places(0), Rounding: RoundHalfEven, Locale: "pt-BR"}.Validate())
+	assert.Error(t, NumberFormat{DecimalPlaces: places(5)}.Validate())
+	assert.Error(t, NumberFormat{Rounding: "CEILING"}.Validate())
+	assert.Error(t, NumberFormat{Locale: "xx"}.Validate())
+}
@@ -0,0 +1,267 @@
+// Package email reads the receipts and order confirmations the users forward to their
+// inbound address: it finds the text of the message and extracts the merchant, total and
+// date an expense draft is made of.
+package email
+
+import (
+	"encoding/base64"
+	"errors"
+	"html"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNoAmount is returned when no total can be found in the message.
+var ErrNoAmount = errors.New("no amount found in the email")
+
+// maxBodySize bounds how much of a part of the message is read.
+const maxBodySize = 1 << 20
+
+// Receipt is what was extracted from a forwarded email. Amount is a decimal with two
+// digits, Currency empty when the message doesn't tell.
+type Receipt struct {
+	Subject  string
+	Merchant string
+	Amount   string
+	Currency string
+	Date     time.Time
+}
+
+// currencySymbols maps the currency symbols found in receipts to their codes.
+var currencySymbols = map[string]string{"R$": "BRL", "US$": "USD", "$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY"}
+
+// currencyCodes are the currency codes recognized in the text, so words like "THE" aren't
+// taken for one.
+var currencyCodes = map[string]bool{"USD": true, "EUR": true, "GBP": true, "BRL": true, "CAD": true, "AUD": true, "JPY": true, "CHF": true, "MXN": true, "ARS": true}
+
+// amountPattern matches an amount with an optional currency before or after it.
+var amountPattern = regexp.MustCompile(`(R\$|US\$|\$|€|£|¥|\b[A-Z]{3}\b)?\s?(\d{1,3}(?:[.,]\d{3})+(?:[.,]\d{1,2})?|\d+(?:[.,]\d{1,2})?)\s?(€|\b[A-Z]{3}\b)?`)
+
+// totalPattern matches the lines holding the total of a receipt, in English and Portuguese,
+// but not the subtotals.
+var totalPattern = regexp.MustCompile(`(?i)(\b(grand |order |total pago|valor total|amount paid|amount charged|total charged)|^\s*total\b)`)
+
+// subtotalPattern matches the lines of the subtotals.
+var subtotalPattern = regexp.MustCompile(`(?i)sub-?total`)
+
+// forwardedHeaderPattern matches the headers of the forwarded message quoted in the body.
+var forwardedHeaderPattern = regexp.MustCompile(`(?im)^\s*>?\s*(From|De|Date|Data):\s*(.+)$`)
+
+// subjectPrefixPattern matches the prefixes added to the subject when forwarding.
+var subjectPrefixPattern = regexp.MustCompile(`(?i)^\s*((fwd?|fw|enc|re|tr)\s*:\s*)+`)
+
+// tagPattern matches the HTML tags, removed from the HTML-only messages.
+var tagPattern = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<br\s*/?>|</(p|div|tr|li|h\d)>|<[^>]+>`)
+
+// Parse reads the raw message and extracts the receipt it forwards. The merchant and date
+// are those of the forwarded message when its headers are quoted, of the message itself
+// otherwise.
+func Parse(raw io.Reader) (Receipt, error) {
+	message, err := mail.ReadMessage(raw)
+	if err != nil {
+		return Receipt{}, err
+	}
+	body, err := readText(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(message.Header.Get("Subject"))
+	if err != nil {
+		subject = message.Header.Get("Subject")
+	}
+	receipt := Receipt{Subject: strings.TrimSpace(subjectPrefixPattern.ReplaceAllString(subject, ""))}
+
+	// The first forwarded headers are the closest to the original message
+	from, date := "", ""
+	for _, match := range forwardedHeaderPattern.FindAllStringSubmatch(body, -1) {
+		switch strings.ToLower(match[1]) {
+		case "from", "de":
+			if from == "" {
+				from = match[2]
+			}
+		case "date", "data":
+			if date == "" {
+				date = match[2]
+			}
+		}
+	}
+	if from == "" {
+		from = message.Header.Get("From")
+	}
+	receipt.Merchant = merchantOf(from)
+	receipt.Date, err = mail.ParseDate(strings.TrimSpace(date))
+	if err != nil {
+		receipt.Date, _ = mail.ParseDate(message.Header.Get("Date"))
+	}
+
+	receipt.Amount, receipt.Currency = findTotal(body)
+	if receipt.Amount == "" {
+		return receipt, ErrNoAmount
+	}
+	return receipt, nil
+}
+
+// readText returns the text of a part, preferring the plain text of the multipart messages
+// and stripping the tags of the HTML ones.
+func readText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, &newlineSkipper{body})
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		var plain, rich string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			if part.FileName() != "" {
+				continue
+			}
+			text, err := readText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if partType == "text/html" {
+				rich += text
+			} else {
+				plain += text
+			}
+		}
+		if strings.TrimSpace(plain) != "" {
+			return plain, nil
+		}
+		return rich, nil
+	}
+
+	if !strings.HasPrefix(mediaType, "text/") {
+		return "", nil
+	}
+	payload, err := io.ReadAll(io.LimitReader(body, maxBodySize))
+	if err != nil {
+		return "", err
+	}
+	text := string(payload)
+	if mediaType == "text/html" {
+		text = html.UnescapeString(tagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+			if strings.HasPrefix(tag, "<br") || strings.HasPrefix(tag, "</") {
+				return "\n"
+			}
+			return " "
+		}))
+	}
+	return text, nil
+}
+
+// newlineSkipper drops the line breaks of base64 bodies, which the decoder doesn't expect.
+type newlineSkipper struct {
+	reader io.Reader
+}
+
+func (s *newlineSkipper) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+// merchantOf names the merchant from the address the receipt was sent from: its display
+// name, or the domain of the address.
+func merchantOf(from string) string {
+	address, err := mail.ParseAddress(strings.TrimSpace(from))
+	if err != nil {
+		return ""
+	}
+	if address.Name != "" {
+		return address.Name
+	}
+	domain := address.Address[strings.LastIndex(address.Address, "@")+1:]
+	return strings.TrimPrefix(domain, "www.")
+}
+
+// findTotal returns the total of the receipt: the last amount of the last total line, or
+// else the largest amount with a currency.
+func findTotal(body string) (string, string) {
+	var total, totalCurrency string
+	var largest *big.Rat
+	var largestText, largestCurrency string
+	for _, line := range strings.Split(body, "\n") {
+		isTotal := totalPattern.MatchString(line) && !subtotalPattern.MatchString(line)
+		for _, match := range amountPattern.FindAllStringSubmatch(line, -1) {
+			currency := currencyOf(match[1])
+			if currency == "" {
+				currency = currencyOf(match[3])
+			}
+			amount, ok := parseAmount(match[2])
+			if !ok {
+				continue
+			}
+			if isTotal {
+				total, totalCurrency = amount.FloatString(2), currency
+			}
+			if currency != "" && (largest == nil || amount.Cmp(largest) > 0) {
+				largest, largestText, largestCurrency = amount, amount.FloatString(2), currency
+			}
+		}
+	}
+	if total != "" {
+		if totalCurrency == "" {
+			totalCurrency = largestCurrency
+		}
+		return total, totalCurrency
+	}
+	return largestText, largestCurrency
+}
+
+// currencyOf returns the code of a currency symbol or code, or "" when it isn't one.
+func currencyOf(text string) string {
+	if code, ok := currencySymbols[text]; ok {
+		return code
+	}
+	if currencyCodes[text] {
+		return text
+	}
+	return ""
+}
+
+// parseAmount parses an amount written with either decimal separator. The last separator
+// is the decimal one when followed by one or two digits, the others group the thousands.
+func parseAmount(text string) (*big.Rat, bool) {
+	decimals := ""
+	if i := strings.LastIndexAny(text, ".,"); i >= 0 && len(text)-i-1 <= 2 {
+		text, decimals = text[:i], text[i+1:]
+	}
+	text = strings.NewReplacer(".", "", ",", "").Replace(text)
+	amount, ok := new(big.Rat).SetString(text + "." + decimals + "0")
+	if !ok || amount.Sign() <= 0 {
+		return nil, false
+	}
+	return amount, true
+}
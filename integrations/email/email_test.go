@@ -0,0 +1,77 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForwardedReceipt(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: Alice <alice@example.com>",
+		"To: 0a1b2c@in.example.com",
+		"Subject: Fwd: Your order has shipped",
+		"Date: Wed, 03 Jan 2024 09:00:00 +0000",
+		"Content-Type: multipart/alternative; boundary=b1",
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"---------- Forwarded message ---------",
+		"From: Book Shop <orders@books.example>",
+		"Date: Tue, 02 Jan 2024 18:30:00 +0100",
+		"",
+		"Subtotal: $40.00",
+		"Shipping: $5.50",
+		"Order total: $45.50",
+		"--b1",
+		"Content-Type: text/html",
+		"",
+		"<p>Order total: <b>$45.50</b></p>",
+		"--b1--",
+	}, "\r\n")
+
+	receipt, err := Parse(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, Receipt{
+		Subject:  "Your order has shipped",
+		Merchant: "Book Shop",
+		Amount:   "45.50",
+		Currency: "USD",
+		Date:     receipt.Date,
+	}, receipt)
+	assert.True(t, receipt.Date.Equal(time.Date(2024, 1, 2, 17, 30, 0, 0, time.UTC)))
+}
+
+func TestParseHTMLReceipt(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: noreply@www.restaurante.example",
+		"Subject: Recibo",
+		"Date: Wed, 03 Jan 2024 09:00:00 +0000",
+		"Content-Type: text/html; charset=utf-8",
+		"",
+		"<table><tr><td>Prato</td><td>R$ 1.234,50</td></tr><tr><td>Valor total</td><td>R$ 1.358,00</td></tr></table>",
+	}, "\r\n")
+
+	receipt, err := Parse(strings.NewReader(raw))
+	assert.NoError(t, err)
+	assert.Equal(t, "restaurante.example", receipt.Merchant)
+	assert.Equal(t, "1358.00", receipt.Amount)
+	assert.Equal(t, "BRL", receipt.Currency)
+
+	_, err = Parse(strings.NewReader("Subject: Hello\r\n\r\nSee you tomorrow!"))
+	assert.ErrorIs(t, err, ErrNoAmount)
+}
+
+func TestParseAmount(t *testing.T) {
+	for text, expected := range map[string]string{"12": "12.00", "12.5": "12.50", "1,234": "1234.00", "1.234,56": "1234.56", "1,234.56": "1234.56"} {
+		amount, ok := parseAmount(text)
+		assert.True(t, ok, text)
+		assert.Equal(t, expected, amount.FloatString(2), text)
+	}
+	_, ok := parseAmount("0")
+	assert.False(t, ok)
+}
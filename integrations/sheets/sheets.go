@@ -0,0 +1,166 @@
+// Package sheets pushes rows to Google Sheets on behalf of the users who linked a
+// spreadsheet, with the OAuth tokens they granted.
+package sheets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrUnauthorized is returned when Google rejects the tokens, e.g. once the user revoked the
+// access. The spreadsheet must be linked again.
+var ErrUnauthorized = errors.New("google sheets access was revoked")
+
+// Scope is the OAuth scope the clients must request, limited to the spreadsheets.
+const Scope = "https://www.googleapis.com/auth/spreadsheets"
+
+// Token is the OAuth token granted by the user. The refresh token is only returned by the
+// exchange of the authorization code.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// Expired reports whether the access token expires within a minute, leaving time for the
+// requests using it.
+func (t Token) Expired(now time.Time) bool {
+	return !now.Add(time.Minute).Before(t.ExpiresAt)
+}
+
+// Client calls the Google OAuth and Sheets APIs with the credentials of the app.
+type Client struct {
+	ClientID     string
+	ClientSecret string
+	TokenURL     string
+	BaseURL      string
+	HTTPClient   *http.Client
+}
+
+// Default is the client used by the handlers. It is nil until Google credentials are
+// configured.
+var Default *Client
+
+// NewClient creates a client with the OAuth credentials of the app.
+func NewClient(clientID, clientSecret string) *Client {
+	return &Client{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		BaseURL:      "https://sheets.googleapis.com/v4/spreadsheets",
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Exchange trades the authorization code the client got from the consent screen for a token.
+func (c *Client) Exchange(ctx context.Context, code, redirectURI string) (Token, error) {
+	return c.token(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	})
+}
+
+// Refresh gets a new access token with the refresh token, which is kept.
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (Token, error) {
+	token, err := c.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err == nil && token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, err
+}
+
+func (c *Client) token(ctx context.Context, form url.Values) (Token, error) {
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+	payload, err := c.do(ctx, "POST", c.TokenURL, "", "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return Token{}, err
+	}
+	return Token{
+		AccessToken:  result.AccessToken,
+		RefreshToken: result.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(result.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// Replace overwrites the sheet of the spreadsheet with the rows.
+func (c *Client) Replace(ctx context.Context, accessToken, spreadsheetId, sheet string, rows [][]string) error {
+	_, err := c.do(ctx, "POST", c.valuesURL(spreadsheetId, sheet, ":clear"), accessToken, "application/json", []byte("{}"))
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"majorDimension": "ROWS", "values": rows})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "PUT", c.valuesURL(spreadsheetId, sheet, "")+"?valueInputOption=RAW", accessToken, "application/json", body)
+	return err
+}
+
+// Append adds the rows after the last row of the sheet.
+func (c *Client) Append(ctx context.Context, accessToken, spreadsheetId, sheet string, rows [][]string) error {
+	body, err := json.Marshal(map[string]interface{}{"majorDimension": "ROWS", "values": rows})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "POST", c.valuesURL(spreadsheetId, sheet, ":append")+"?valueInputOption=RAW&insertDataOption=INSERT_ROWS", accessToken, "application/json", body)
+	return err
+}
+
+// valuesURL is the URL of the values of the whole sheet, its name quoted as an A1 range.
+func (c *Client) valuesURL(spreadsheetId, sheet, action string) string {
+	a1 := "'" + strings.ReplaceAll(sheet, "'", "''") + "'"
+	return c.BaseURL + "/" + url.PathEscape(spreadsheetId) + "/values/" + url.PathEscape(a1) + action
+}
+
+// do sends the request and returns the response body, failing on non-2xx responses.
+func (c *Client) do(ctx context.Context, method, target, accessToken, contentType string, body []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	payload, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	// The token endpoint answers invalid_grant with a 400 once the refresh token is revoked
+	if response.StatusCode == http.StatusUnauthorized || (response.StatusCode == http.StatusBadRequest && bytes.Contains(payload, []byte("invalid_grant"))) {
+		return payload, ErrUnauthorized
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return payload, fmt.Errorf("Google %s %s returned %s: %s", method, request.URL.Path, response.Status, payload)
+	}
+	return payload, nil
+}
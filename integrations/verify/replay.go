@@ -0,0 +1,79 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"vassistant-backend/common"
+)
+
+// ReplayCache remembers the deliveries already received.
+type ReplayCache interface {
+	// Remember records the delivery for the ttl and reports whether it is the first time
+	// it is seen.
+	Remember(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// MemoryReplayCache is a ReplayCache kept in the memory of the Lambda instance. A replay
+// reaching another instance goes unnoticed, so it is only suited to a single instance and
+// tests; use a DynamoDBReplayCache otherwise.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewMemoryReplayCache creates an empty MemoryReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{expires: map[string]time.Time{}}
+}
+
+func (c *MemoryReplayCache) Remember(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for other, expiresAt := range c.expires {
+		if !now.Before(expiresAt) {
+			delete(c.expires, other)
+		}
+	}
+	if _, ok := c.expires[key]; ok {
+		return false, nil
+	}
+	c.expires[key] = now.Add(ttl)
+	return true, nil
+}
+
+// DynamoDBReplayCache is a ReplayCache shared by all the Lambda instances, kept in a table
+// keyed by deliveryKey with expiresAt as its TTL attribute. Deliveries are recorded with a
+// conditional put, so concurrent replays can't both get through.
+type DynamoDBReplayCache struct {
+	Client    common.DynamoDBAPI
+	TableName string
+}
+
+// replayEntry is the item stored for a delivery.
+type replayEntry struct {
+	DeliveryKey string `dynamodbav:"deliveryKey"`
+	ExpiresAt   int64  `dynamodbav:"expiresAt"` // Unix seconds, also the DynamoDB TTL attribute
+}
+
+// NewDynamoDBReplayCache creates a DynamoDBReplayCache on the table.
+func NewDynamoDBReplayCache(client common.DynamoDBAPI, tableName string) *DynamoDBReplayCache {
+	return &DynamoDBReplayCache{Client: client, TableName: tableName}
+}
+
+// Remember records the delivery. DynamoDB deletes expired items lazily, so a delivery may be
+// remembered for longer than the ttl, which only makes the cache stricter.
+func (c *DynamoDBReplayCache) Remember(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	entry := replayEntry{DeliveryKey: key, ExpiresAt: time.Now().Add(ttl).Unix()}
+	err := common.ConditionalPutItem(ctx, c.Client, c.TableName, entry, common.IfNotExists("deliveryKey"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,245 @@
+// Package verify authenticates the requests sent by third-party integrations, like Telegram,
+// Slack, Stripe or generic webhooks: it checks their signatures and timestamps and rejects the
+// deliveries it has already seen, so a captured request can't be replayed.
+package verify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// DefaultTolerance is how far the timestamp of a signed request may be from now.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleTimestamp   = errors.New("timestamp outside the tolerance")
+	ErrReplayed         = errors.New("request already received")
+)
+
+// Scheme checks the signature of the requests of an integration.
+type Scheme interface {
+	// Verify checks the signature of the request. It returns the time the request was
+	// signed, zero when the integration doesn't sign one, and the ID of the delivery the
+	// replay cache remembers.
+	Verify(request events.APIGatewayProxyRequest) (time.Time, string, error)
+}
+
+// Verifier verifies the requests of an integration with its Scheme and rejects replays.
+type Verifier struct {
+	Name    string
+	Scheme  Scheme
+	Replays ReplayCache
+
+	// Tolerance is how far the timestamp of a request may be from now.
+	Tolerance time.Duration
+}
+
+// NewVerifier creates a Verifier of the integration with the default tolerance.
+func NewVerifier(name string, scheme Scheme, replays ReplayCache) *Verifier {
+	return &Verifier{Name: name, Scheme: scheme, Replays: replays, Tolerance: DefaultTolerance}
+}
+
+// Verify checks the signature and timestamp of the request and records its delivery,
+// rejecting deliveries received before.
+func (v *Verifier) Verify(ctx context.Context, request events.APIGatewayProxyRequest) error {
+	signedAt, deliveryId, err := v.Scheme.Verify(request)
+	if err != nil {
+		return err
+	}
+	if !signedAt.IsZero() {
+		if age := time.Since(signedAt); age > v.Tolerance || age < -v.Tolerance {
+			return ErrStaleTimestamp
+		}
+	}
+
+	// Deliveries only need to be remembered while their timestamp is accepted, with some
+	// margin for clock skew
+	first, err := v.Replays.Remember(ctx, v.Name+":"+deliveryId, 2*v.Tolerance)
+	if err != nil {
+		return err
+	}
+	if !first {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// Verified rejects the requests that fail verification before they reach the handler.
+func Verified(verifier *Verifier, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		err := verifier.Verify(context.TODO(), request)
+		switch {
+		case err == nil:
+			return next(request)
+		case errors.Is(err, ErrReplayed):
+			log.Printf("Rejected replayed %s request", verifier.Name)
+			return common.CreateErrorResponse(409, "Request already received")
+		case errors.Is(err, ErrMissingSignature), errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrStaleTimestamp):
+			log.Printf("Rejected %s request: %v", verifier.Name, err)
+			return common.CreateErrorResponse(401, "Invalid signature")
+		default:
+			log.Printf("Error verifying %s request: %v", verifier.Name, err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+}
+
+// sign returns the hex HMAC-SHA256 of the message.
+func sign(secret []byte, message string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseUnix parses a timestamp in Unix seconds.
+func parseUnix(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidSignature
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// HMACScheme verifies requests signed with an HMAC-SHA256 of their timestamp and body, the
+// signature and the timestamp in Unix seconds being sent in headers.
+type HMACScheme struct {
+	Secret          []byte
+	SignatureHeader string
+	TimestampHeader string
+
+	// Prefix is prepended to the hex signature in its header, e.g. "v0=".
+	Prefix string
+	// Message builds the signed message from the timestamp and the body.
+	Message func(timestamp, body string) string
+}
+
+func (s HMACScheme) Verify(request events.APIGatewayProxyRequest) (time.Time, string, error) {
+	signature, timestamp := common.Header(request, s.SignatureHeader), common.Header(request, s.TimestampHeader)
+	if signature == "" || timestamp == "" {
+		return time.Time{}, "", ErrMissingSignature
+	}
+	signedAt, err := parseUnix(timestamp)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	expected := s.Prefix + sign(s.Secret, s.Message(timestamp, request.Body))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return time.Time{}, "", ErrInvalidSignature
+	}
+	return signedAt, signature, nil
+}
+
+// Slack verifies the requests signed with the signing secret of a Slack app.
+func Slack(signingSecret string) HMACScheme {
+	return HMACScheme{
+		Secret:          []byte(signingSecret),
+		SignatureHeader: "X-Slack-Signature",
+		TimestampHeader: "X-Slack-Request-Timestamp",
+		Prefix:          "v0=",
+		Message: func(timestamp, body string) string {
+			return "v0:" + timestamp + ":" + body
+		},
+	}
+}
+
+// Webhook verifies the requests of the generic webhooks, signed like Stripe does: an
+// HMAC-SHA256 of the timestamp and the body joined by a dot.
+func Webhook(secret string) HMACScheme {
+	return HMACScheme{
+		Secret:          []byte(secret),
+		SignatureHeader: "X-Webhook-Signature",
+		TimestampHeader: "X-Webhook-Timestamp",
+		Message: func(timestamp, body string) string {
+			return timestamp + "." + body
+		},
+	}
+}
+
+// TelegramScheme verifies the updates of a Telegram bot, which carry the secret token set
+// with setWebhook instead of a signature. Updates aren't timestamped, their update_id
+// identifies the delivery.
+type TelegramScheme struct {
+	SecretToken string
+}
+
+// Telegram verifies the updates of a Telegram bot with the secret token of its webhook.
+func Telegram(secretToken string) TelegramScheme {
+	return TelegramScheme{SecretToken: secretToken}
+}
+
+func (s TelegramScheme) Verify(request events.APIGatewayProxyRequest) (time.Time, string, error) {
+	token := common.Header(request, "X-Telegram-Bot-Api-Secret-Token")
+	if token == "" {
+		return time.Time{}, "", ErrMissingSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.SecretToken)) != 1 {
+		return time.Time{}, "", ErrInvalidSignature
+	}
+
+	var update struct {
+		UpdateID *int64 `json:"update_id"`
+	}
+	if err := json.Unmarshal([]byte(request.Body), &update); err != nil || update.UpdateID == nil {
+		return time.Time{}, "", ErrInvalidSignature
+	}
+	return time.Time{}, strconv.FormatInt(*update.UpdateID, 10), nil
+}
+
+// StripeScheme verifies the events of a Stripe webhook endpoint. The Stripe-Signature header
+// carries the timestamp and one HMAC-SHA256 of the timestamp and the body per signing secret
+// of the endpoint, e.g. "t=1712345678,v1=5257a8...,v1=8f0e1c..." while a secret is rolled.
+type StripeScheme struct {
+	Secret []byte
+}
+
+// Stripe verifies the events of the webhook endpoint with its signing secret.
+func Stripe(signingSecret string) StripeScheme {
+	return StripeScheme{Secret: []byte(signingSecret)}
+}
+
+func (s StripeScheme) Verify(request events.APIGatewayProxyRequest) (time.Time, string, error) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(common.Header(request, "Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return time.Time{}, "", ErrMissingSignature
+	}
+	signedAt, err := parseUnix(timestamp)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	// Stripe signs the retries of an event again, so the signature identifies the delivery
+	// and a retry of an event that failed is still accepted
+	expected := sign(s.Secret, timestamp+"."+request.Body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return signedAt, signature, nil
+		}
+	}
+	return time.Time{}, "", ErrInvalidSignature
+}
@@ -0,0 +1,118 @@
+package verify
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// slackRequest returns a request signed like Slack does at the given time.
+func slackRequest(secret string, signedAt time.Time, body string) events.APIGatewayProxyRequest {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	return events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"x-slack-request-timestamp": timestamp,
+			"x-slack-signature":         "v0=" + sign([]byte(secret), "v0:"+timestamp+":"+body),
+		},
+		Body: body,
+	}
+}
+
+func TestVerifySlack(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	caches := map[string]ReplayCache{
+		"memory":   NewMemoryReplayCache(),
+		"dynamodb": NewDynamoDBReplayCache(fake, "replay-cache"),
+	}
+
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			verifier := NewVerifier("slack", Slack("secret"), cache)
+			request := slackRequest("secret", time.Now(), `{"type":"event_callback"}`)
+
+			// The first delivery is accepted, the replay is not
+			assert.NoError(t, verifier.Verify(context.TODO(), request))
+			assert.ErrorIs(t, verifier.Verify(context.TODO(), request), ErrReplayed)
+
+			// Tampered bodies, other secrets and old timestamps are rejected
+			tampered := slackRequest("secret", time.Now(), `{"type":"event_callback"}`)
+			tampered.Body = `{"type":"other"}`
+			assert.ErrorIs(t, verifier.Verify(context.TODO(), tampered), ErrInvalidSignature)
+			assert.ErrorIs(t, verifier.Verify(context.TODO(), slackRequest("other", time.Now(), "{}")), ErrInvalidSignature)
+			assert.ErrorIs(t, verifier.Verify(context.TODO(), slackRequest("secret", time.Now().Add(-time.Hour), "{}")), ErrStaleTimestamp)
+			assert.ErrorIs(t, verifier.Verify(context.TODO(), events.APIGatewayProxyRequest{Body: "{}"}), ErrMissingSignature)
+		})
+	}
+}
+
+func TestVerifyTelegram(t *testing.T) {
+	verifier := NewVerifier("telegram", Telegram("token"), NewMemoryReplayCache())
+	request := events.APIGatewayProxyRequest{
+		Headers: map[string]string{"X-Telegram-Bot-Api-Secret-Token": "token"},
+		Body:    `{"update_id":42,"message":{"text":"hi"}}`,
+	}
+
+	assert.NoError(t, verifier.Verify(context.TODO(), request))
+	assert.ErrorIs(t, verifier.Verify(context.TODO(), request), ErrReplayed)
+
+	request.Headers["X-Telegram-Bot-Api-Secret-Token"] = "wrong"
+	assert.ErrorIs(t, verifier.Verify(context.TODO(), request), ErrInvalidSignature)
+}
+
+func TestVerifyStripe(t *testing.T) {
+	verifier := NewVerifier("stripe", Stripe("whsec_new"), NewMemoryReplayCache())
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	body := `{"id":"evt_1","type":"customer.subscription.updated"}`
+	request := events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"stripe-signature": "t=" + timestamp + ",v1=" + sign([]byte("whsec_old"), timestamp+"."+body) + ",v1=" + sign([]byte("whsec_new"), timestamp+"."+body),
+		},
+		Body: body,
+	}
+
+	// Any of the signatures of the secrets being rolled is accepted, once
+	assert.NoError(t, verifier.Verify(context.TODO(), request))
+	assert.ErrorIs(t, verifier.Verify(context.TODO(), request), ErrReplayed)
+
+	request.Body = `{"id":"evt_2","type":"customer.subscription.updated"}`
+	assert.ErrorIs(t, verifier.Verify(context.TODO(), request), ErrInvalidSignature)
+	request.Headers["stripe-signature"] = "t=" + timestamp
+	assert.ErrorIs(t, verifier.Verify(context.TODO(), request), ErrMissingSignature)
+}
+
+func TestVerified(t *testing.T) {
+	calls := 0
+	handler := Verified(NewVerifier("webhook", Webhook("secret"), NewMemoryReplayCache()), func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	})
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request := events.APIGatewayProxyRequest{
+		Headers: map[string]string{
+			"X-Webhook-Timestamp": timestamp,
+			"X-Webhook-Signature": sign([]byte("secret"), timestamp+".{}"),
+		},
+		Body: "{}",
+	}
+
+	response, err := handler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = handler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 409, response.StatusCode)
+
+	request.Headers["X-Webhook-Signature"] = "forged"
+	response, err = handler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 401, response.StatusCode)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,134 @@
+// Package llm is the interface to the large language models behind the assistant. Features
+// build a Request and call Complete, without depending on a specific provider.
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrNotConfigured is returned by Complete when no provider is configured.
+var ErrNotConfigured = errors.New("no language model provider configured")
+
+// Roles of the messages of a conversation
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// Message is a message of the conversation sent to the model. The messages of the assistant
+// may call tools, whose results are sent back as tool messages answering the call.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"toolCalls,omitempty"`
+	ToolCallID string     `json:"toolCallId,omitempty"`
+}
+
+// ToolSpec describes a tool the model may call, with the JSON schema of its arguments.
+type ToolSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// ToolCall is a call of a tool requested by the model.
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Request is a completion request. The gateway picks the model when none is named.
+type Request struct {
+	Model     string     `json:"model,omitempty"`
+	System    string     `json:"system,omitempty"`
+	Messages  []Message  `json:"messages"`
+	Tools     []ToolSpec `json:"tools,omitempty"`
+	MaxTokens int        `json:"maxTokens,omitempty"`
+	// Temperature is the sampling temperature, the default of the model when nil.
+	Temperature *float64 `json:"temperature,omitempty"`
+	// User is the user the request is made for, to track the costs per user.
+	User string `json:"user,omitempty"`
+}
+
+// Response is the completion of a Request. When the model calls tools, the content may be
+// empty and the request is expected to be completed again with their results.
+type Response struct {
+	Content      string     `json:"content"`
+	ToolCalls    []ToolCall `json:"toolCalls,omitempty"`
+	Model        string     `json:"model"`
+	InputTokens  int        `json:"inputTokens"`
+	OutputTokens int        `json:"outputTokens"`
+}
+
+// Provider completes requests with a language model.
+type Provider interface {
+	Complete(ctx context.Context, request Request) (Response, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface.
+type ProviderFunc func(ctx context.Context, request Request) (Response, error)
+
+func (f ProviderFunc) Complete(ctx context.Context, request Request) (Response, error) {
+	return f(ctx, request)
+}
+
+// DefaultProvider is used by Complete. It is nil until one is configured.
+var DefaultProvider Provider
+
+// Complete completes the request with the DefaultProvider, with the model picked by the
+// DefaultRouter when there is one.
+func Complete(ctx context.Context, request Request) (Response, error) {
+	if DefaultProvider == nil {
+		return Response{}, ErrNotConfigured
+	}
+	return DefaultProvider.Complete(ctx, route(request))
+}
+
+// HTTPProvider completes requests through an HTTP gateway in front of the model, posting
+// the Request as JSON and reading the Response as JSON.
+type HTTPProvider struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewHTTPProvider creates an HTTPProvider for the endpoint with a 30 seconds timeout.
+func NewHTTPProvider(endpoint string) *HTTPProvider {
+	return &HTTPProvider{Endpoint: endpoint, Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (p *HTTPProvider) Complete(ctx context.Context, request Request) (Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return Response{}, err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := p.Client.Do(httpRequest)
+	if err != nil {
+		return Response{}, err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return Response{}, fmt.Errorf("language model gateway returned %s", httpResponse.Status)
+	}
+
+	var response Response
+	if err := json.NewDecoder(httpResponse.Body).Decode(&response); err != nil {
+		return Response{}, err
+	}
+	return response, nil
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	DefaultProvider = nil
+	_, err := Complete(context.TODO(), Request{})
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	DefaultProvider = ProviderFunc(func(ctx context.Context, request Request) (Response, error) {
+		return Response{Content: "echo: " + request.Messages[0].Content}, nil
+	})
+	defer func() { DefaultProvider = nil }()
+
+	response, err := Complete(context.TODO(), Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "echo: hi", response.Content)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	// Set up a gateway answering with the last message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var request Request
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "Be brief", request.System)
+		json.NewEncoder(w).Encode(Response{Content: request.Messages[0].Content, Model: "test-model", InputTokens: 3, OutputTokens: 1})
+	}))
+	defer server.Close()
+
+	response, err := NewHTTPProvider(server.URL).Complete(context.TODO(), Request{
+		System:   "Be brief",
+		Messages: []Message{{Role: RoleUser, Content: "hello"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Response{Content: "hello", Model: "test-model", InputTokens: 3, OutputTokens: 1}, response)
+}
+
+func TestHTTPProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPProvider(server.URL).Complete(context.TODO(), Request{})
+	assert.ErrorContains(t, err, "429")
+}
+
+func TestStub(t *testing.T) {
+	request := Request{
+		System:   "Be brief.",
+		Messages: []Message{{Role: RoleUser, Content: "Hello"}, {Role: RoleUser, Content: "  How much   do I owe?"}},
+	}
+	response, err := Stub{}.Complete(context.TODO(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, Response{Content: "Stub reply to: How much do I owe?", Model: StubModel, InputTokens: 8, OutputTokens: 8}, response)
+
+	// The same request gets the same response
+	again, err := Stub{}.Complete(context.TODO(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, response, again)
+}
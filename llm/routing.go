@@ -0,0 +1,69 @@
+package llm
+
+import "log"
+
+// Default thresholds of the Router, in characters
+const (
+	defaultLongContext = 6000
+	defaultLongMessage = 500
+)
+
+// Router picks the model of each request: the fast model for short conversations and
+// questions, the strong model for requests offering tools, continuing tool calls or
+// carrying a long context.
+type Router struct {
+	FastModel   string
+	StrongModel string
+
+	// LongContext is the length of the prompt, system prompt included, from which the
+	// strong model is used.
+	LongContext int
+	// LongMessage is the length of the last message from which the strong model is used.
+	LongMessage int
+}
+
+// NewRouter creates a Router between the models with the default thresholds.
+func NewRouter(fastModel, strongModel string) *Router {
+	return &Router{
+		FastModel:   fastModel,
+		StrongModel: strongModel,
+		LongContext: defaultLongContext,
+		LongMessage: defaultLongMessage,
+	}
+}
+
+// DefaultRouter picks the model of the requests completed with Complete which don't name
+// one. Without a router, the gateway uses its default model.
+var DefaultRouter *Router
+
+// Route returns the model for the request.
+func (r *Router) Route(request Request) string {
+	if len(request.Tools) > 0 {
+		return r.StrongModel
+	}
+
+	length := len(request.System)
+	for _, message := range request.Messages {
+		if message.Role == RoleTool || len(message.ToolCalls) > 0 {
+			return r.StrongModel
+		}
+		length += len(message.Content)
+	}
+	if length >= r.LongContext {
+		return r.StrongModel
+	}
+	if len(request.Messages) > 0 && len(request.Messages[len(request.Messages)-1].Content) >= r.LongMessage {
+		return r.StrongModel
+	}
+	return r.FastModel
+}
+
+// route sets the model of the request with the DefaultRouter, unless it names one.
+func route(request Request) Request {
+	if DefaultRouter == nil || request.Model != "" {
+		return request
+	}
+	request.Model = DefaultRouter.Route(request)
+	log.Printf("Routed the language model request to %s", request.Model)
+	return request
+}
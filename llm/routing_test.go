@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	router := NewRouter("fast", "strong")
+
+	question := Request{Messages: []Message{{Role: RoleUser, Content: "When is my rent due?"}}}
+	assert.Equal(t, "fast", router.Route(question))
+
+	// Tools, tool results, long messages and long contexts need the strong model
+	withTools := question
+	withTools.Tools = []ToolSpec{{Name: "list_groups"}}
+	assert.Equal(t, "strong", router.Route(withTools))
+
+	toolResult := Request{Messages: []Message{
+		{Role: RoleUser, Content: "How much do I owe?"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "1", Name: "get_net_debts"}}},
+		{Role: RoleTool, Content: "[]", ToolCallID: "1"},
+	}}
+	assert.Equal(t, "strong", router.Route(toolResult))
+
+	longMessage := Request{Messages: []Message{{Role: RoleUser, Content: strings.Repeat("a", 500)}}}
+	assert.Equal(t, "strong", router.Route(longMessage))
+
+	var history []Message
+	for range 20 {
+		history = append(history, Message{Role: RoleUser, Content: strings.Repeat("a", 300)})
+	}
+	assert.Equal(t, "strong", router.Route(Request{Messages: history}))
+}
+
+func TestCompleteRoutes(t *testing.T) {
+	var models []string
+	DefaultProvider = ProviderFunc(func(ctx context.Context, request Request) (Response, error) {
+		models = append(models, request.Model)
+		return Response{Model: request.Model}, nil
+	})
+	defer func() { DefaultProvider = nil }()
+
+	// Without a router, the gateway picks the model
+	_, err := Complete(context.TODO(), Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+
+	DefaultRouter = NewRouter("fast", "strong")
+	defer func() { DefaultRouter = nil }()
+	_, err = Complete(context.TODO(), Request{Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+
+	// Requests naming a model aren't routed
+	_, err = Complete(context.TODO(), Request{Model: "pinned", Messages: []Message{{Role: RoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"", "fast", "pinned"}, models)
+}
@@ -0,0 +1,40 @@
+package llm
+
+import (
+	"context"
+	"strings"
+)
+
+// StubModel is the model of the responses of the Stub provider.
+const StubModel = "stub"
+
+// maxStubEcho bounds the characters of the last message echoed by the Stub provider.
+const maxStubEcho = 200
+
+// Stub answers every request with the same text for the same last message, without calling
+// any model or tool, for the end-to-end tests of the staging stages. The tokens are counted
+// as words.
+type Stub struct{}
+
+func (Stub) Complete(ctx context.Context, request Request) (Response, error) {
+	var last string
+	if len(request.Messages) > 0 {
+		last = request.Messages[len(request.Messages)-1].Content
+	}
+	echo := strings.Join(strings.Fields(last), " ")
+	if runes := []rune(echo); len(runes) > maxStubEcho {
+		echo = string(runes[:maxStubEcho]) + "…"
+	}
+
+	inputTokens := len(strings.Fields(request.System))
+	for _, message := range request.Messages {
+		inputTokens += len(strings.Fields(message.Content))
+	}
+	content := "Stub reply to: " + echo
+	return Response{
+		Content:      content,
+		Model:        StubModel,
+		InputTokens:  inputTokens,
+		OutputTokens: len(strings.Fields(content)),
+	}, nil
+}
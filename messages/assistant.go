@@ -0,0 +1,187 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/tools"
+)
+
+// mockReply is the reply of the assistant when no language model is configured.
+const mockReply = "This is a mock response from the assistant."
+
+// maxHistoryMessages bounds how many previous messages of the conversation are sent to the model.
+const maxHistoryMessages = 20
+
+// maxReplyTokens bounds the length of the replies of the assistant.
+const maxReplyTokens = 800
+
+// maxToolRounds bounds how many times the model is asked again with the results of its tool
+// calls for a single reply.
+const maxToolRounds = 4
+
+var errTooManyToolRounds = errors.New("the model kept calling tools")
+
+// Tools are the tools the assistant may call, within the policy of the user. The assistant
+// answers without tools when nil.
+var Tools *tools.Dispatcher
+
+// planningPrompt asks the model to plan the replies taking several tools, run in order over
+// the rounds, the results of a step being those the next one builds on.
+const planningPrompt = "\n\nWhen answering takes several tools, e.g. reading the balances of a group before " +
+	"settling them, first state your plan as short numbered steps, then call the tools of each step in turn, " +
+	"using the results of the previous steps. Stop and tell the user when a step fails."
+
+const assistantSystemPrompt = "You are Vassistant, a personal assistant helping the user organize their life " +
+	"and their shared expenses. Answer concisely, in the language of the user."
+
+// assemblePrompt builds the request to the model from the last messages of the conversation,
+// the newest last, and the memories relevant to the new message.
+func assemblePrompt(history []GetMessage, memories []Memory) llm.Request {
+	system := assistantSystemPrompt
+	if len(memories) > 0 {
+		var facts strings.Builder
+		facts.WriteString("\n\nWhat you know about the user, from previous conversations:")
+		for _, memory := range memories {
+			facts.WriteString("\n- " + memory.Fact)
+		}
+		system += facts.String()
+	}
+
+	if len(history) > maxHistoryMessages {
+		history = history[len(history)-maxHistoryMessages:]
+	}
+	messages := make([]llm.Message, 0, len(history))
+	for _, message := range history {
+		role := llm.RoleUser
+		if message.Role == "assistant" {
+			role = llm.RoleAssistant
+		}
+		messages = append(messages, llm.Message{Role: role, Content: message.Content})
+	}
+	return llm.Request{System: system, Messages: messages, MaxTokens: maxReplyTokens}
+}
+
+// generateReply answers the message of the user in its branch of the conversation, whose messages
+// are already saved, returning the final response of the model with its trimmed content.
+// In a conversation about a group, the assistant is told about the group instead of the
+// memories of the user, and its tools are scoped to the group. In the onboarding conversation,
+// it is told the step the user is at. The settings of the conversation override the model,
+// temperature, reply length and persona. The steps of the replies calling tools are traced
+// under the ID of the message. Without a language model, the assistant answers with a mock
+// reply.
+func (h *Handlers) generateReply(ctx context.Context, message GetMessage, group *financial.GroupMember) (llm.Response, error) {
+	if llm.DefaultProvider == nil {
+		return llm.Response{Content: mockReply}, nil
+	}
+
+	// The history is the branch of the conversation the message is on
+	messages, err := h.conversationMessages(message.UserId, conversationOf(message))
+	if err != nil {
+		return llm.Response{}, err
+	}
+	if len(messages) == 0 || messages[len(messages)-1].Id != message.Id {
+		messages = append(messages, message)
+	}
+	history := thread(messages, message.Id)
+
+	var memories []Memory
+	if group == nil {
+		memories, err = h.relevantMemories(ctx, message.UserId, message.Content)
+		if err != nil {
+			return llm.Response{}, err
+		}
+	}
+
+	settings, err := h.conversationSettings(ctx, message.UserId, conversationOf(message))
+	if err != nil {
+		return llm.Response{}, err
+	}
+
+	request := assemblePrompt(history, memories)
+	request.User = message.UserId
+	settings.apply(&request)
+	var groupId string
+	if group != nil {
+		groupId = group.GroupID
+		groupPrompt, err := h.assembleGroupPrompt(ctx, message.UserId, *group)
+		if err != nil {
+			return llm.Response{}, err
+		}
+		request.System += groupPrompt
+	}
+	if conversationOf(message) == OnboardingConversation {
+		onboardingPrompt, err := h.assembleOnboardingPrompt(ctx, message.UserId)
+		if err != nil {
+			return llm.Response{}, err
+		}
+		request.System += onboardingPrompt
+	}
+	if Tools != nil {
+		request.Tools, err = Tools.Specs(ctx, message.UserId, settings.persona())
+		if err != nil {
+			return llm.Response{}, err
+		}
+		if len(request.Tools) > 0 {
+			request.System += planningPrompt
+		}
+	}
+
+	// The steps are traced as they run, the trace being saved once the reply is done or failed
+	trace := &Trace{UserID: message.UserId, MessageID: message.Id}
+	for round := 0; ; round++ {
+		response, err := llm.Complete(ctx, request)
+		if err != nil {
+			h.saveTrace(ctx, trace, err)
+			return llm.Response{}, err
+		}
+		trace.Model = response.Model
+		if len(response.ToolCalls) == 0 || Tools == nil {
+			response.Content = strings.TrimSpace(response.Content)
+			trace.add(TraceStep{Round: round, Kind: TraceAnswer, Content: response.Content})
+			h.saveTrace(ctx, trace, nil)
+			return response, nil
+		}
+		if round == maxToolRounds {
+			h.saveTrace(ctx, trace, errTooManyToolRounds)
+			return llm.Response{}, errTooManyToolRounds
+		}
+		if plan := strings.TrimSpace(response.Content); plan != "" {
+			trace.add(TraceStep{Round: round, Kind: TracePlan, Content: plan})
+		}
+
+		// Run the calls and complete again with their results, errors included so the model
+		// can tell the user what it couldn't do
+		request.Messages = append(request.Messages, llm.Message{Role: llm.RoleAssistant, Content: response.Content, ToolCalls: response.ToolCalls})
+		for _, toolCall := range response.ToolCalls {
+			started := time.Now()
+			result, err := Tools.Dispatch(ctx, tools.Call{
+				ID:             toolCall.ID,
+				UserID:         message.UserId,
+				Persona:        settings.persona(),
+				ConversationID: conversationOf(message),
+				GroupID:        groupId,
+				Name:           toolCall.Name,
+				Arguments:      toolCall.Arguments,
+			})
+			step := TraceStep{
+				Round:      round,
+				Kind:       TraceTool,
+				Tool:       toolCall.Name,
+				CallID:     toolCall.ID,
+				Arguments:  string(toolCall.Arguments),
+				Result:     result,
+				DurationMs: time.Since(started).Milliseconds(),
+			}
+			if err != nil {
+				result = "Error: " + err.Error()
+				step.Error = err.Error()
+			}
+			trace.add(step)
+			request.Messages = append(request.Messages, llm.Message{Role: llm.RoleTool, Content: result, ToolCallID: toolCall.ID})
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+	"vassistant-backend/tools"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssistantTools(t *testing.T) {
+	// Set up the fake DynamoDB, shared with the tools for the permissions and the audit log
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+	tools.DynamoDbClient = fake
+
+	run := func(ctx context.Context, call tools.Call) (string, error) { return call.Name + " done", nil }
+	Tools = tools.NewDispatcher(
+		tools.Tool{Name: "list_groups", Access: tools.ReadAccess, Run: run},
+		tools.Tool{Name: "propose_expense", Access: tools.WriteAccess, Run: run},
+	)
+	defer func() { Tools = nil }()
+
+	// The model calls a read tool and a write tool it wasn't offered, then answers
+	var requests []llm.Request
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]"}, nil
+		}
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			return llm.Response{ToolCalls: []llm.ToolCall{
+				{ID: "call-1", Name: "list_groups"},
+				{ID: "call-2", Name: "propose_expense"},
+			}}, nil
+		}
+		return llm.Response{Content: "You have one group."}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	postMessage(t, h, "", "Add a 10 EUR dinner to my group")
+
+	assert.Len(t, requests, 2)
+	assert.Len(t, requests[0].Tools, 1)
+	assert.Equal(t, "list_groups", requests[0].Tools[0].Name)
+
+	// The results of the calls are sent back, the write call refused
+	results := requests[1].Messages[len(requests[1].Messages)-2:]
+	assert.Equal(t, llm.Message{Role: llm.RoleTool, Content: "list_groups done", ToolCallID: "call-1"}, results[0])
+	assert.Equal(t, llm.Message{Role: llm.RoleTool, Content: "Error: tool not allowed", ToolCallID: "call-2"}, results[1])
+
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "You have one group.", messages[len(messages)-1].Content)
+}
+
+func TestReplyModel(t *testing.T) {
+	// Set up the fake DynamoDB
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]", Model: request.Model}, nil
+		}
+		return llm.Response{Content: "On the 5th.", Model: request.Model}, nil
+	})
+	llm.DefaultRouter = llm.NewRouter("fast-model", "strong-model")
+	defer func() {
+		llm.DefaultProvider = nil
+		llm.DefaultRouter = nil
+	}()
+
+	postMessage(t, h, "", "When is my rent due?")
+
+	// The model of the reply is recorded on the message of the assistant
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	assert.Empty(t, messages[0].Model)
+	assert.Equal(t, "fast-model", messages[1].Model)
+}
+
+func TestAssistantTrace(t *testing.T) {
+	// Set up the fake DynamoDB, shared with the tools for the permissions and the audit log
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+	tools.DynamoDbClient = fake
+
+	run := func(ctx context.Context, call tools.Call) (string, error) { return "Ana owes you 20 EUR", nil }
+	Tools = tools.NewDispatcher(
+		tools.Tool{Name: "get_balances", Access: tools.ReadAccess, Run: run},
+		tools.Tool{Name: "propose_settlement", Access: tools.WriteAccess, Run: run},
+	)
+	defer func() { Tools = nil }()
+
+	// The model plans, reads the balances, then settles them with their result
+	var requests []llm.Request
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]"}, nil
+		}
+		requests = append(requests, request)
+		switch len(requests) {
+		case 1:
+			return llm.Response{Content: "1. Read the balances\n2. Settle them", Model: "test-model", ToolCalls: []llm.ToolCall{
+				{ID: "call-1", Name: "get_balances"},
+			}}, nil
+		case 2:
+			return llm.Response{Model: "test-model", ToolCalls: []llm.ToolCall{
+				{ID: "call-2", Name: "propose_settlement", Arguments: []byte(`{"amount":20}`)},
+			}}, nil
+		}
+		return llm.Response{Content: "I couldn't settle it.", Model: "test-model"}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	postMessage(t, h, "", "Settle up with Ana")
+
+	assert.Len(t, requests, 3)
+	assert.Contains(t, requests[0].System, planningPrompt)
+
+	// The trace is under the ID of the message of the user
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/messages/"+messages[0].Id+"/trace").
+		WithClaims("test-user-id", "test-user").
+		WithPathParam("messageId", messages[0].Id).
+		Build()
+	response, err := h.GetTraceHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var trace Trace
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &trace))
+	assert.Equal(t, TraceAnswered, trace.Outcome)
+	assert.Equal(t, "test-model", trace.Model)
+	assert.Len(t, trace.Steps, 4)
+	assert.Equal(t, TraceStep{Round: 0, Kind: TracePlan, Content: "1. Read the balances\n2. Settle them"}, trace.Steps[0])
+	assert.Equal(t, TraceTool, trace.Steps[1].Kind)
+	assert.Equal(t, "get_balances", trace.Steps[1].Tool)
+	assert.Equal(t, "Ana owes you 20 EUR", trace.Steps[1].Result)
+	assert.Equal(t, 1, trace.Steps[2].Round)
+	assert.Equal(t, "propose_settlement", trace.Steps[2].Tool)
+	assert.Equal(t, `{"amount":20}`, trace.Steps[2].Arguments)
+	assert.Equal(t, "tool not allowed", trace.Steps[2].Error)
+	assert.Equal(t, TraceStep{Round: 2, Kind: TraceAnswer, Content: "I couldn't settle it."}, trace.Steps[3])
+
+	// The reply of the assistant has no trace of its own, and the traces are of their user only
+	request.PathParameters["messageId"] = messages[1].Id
+	response, err = h.GetTraceHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	request = testutil.NewRequest("GET", "/VassistantBackendProxy/messages/"+messages[0].Id+"/trace").
+		WithClaims("other-user-id", "other-user").
+		WithPathParam("messageId", messages[0].Id).
+		Build()
+	response, err = h.GetTraceHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
@@ -0,0 +1,290 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// errMessageNotFound is returned when editing a message the user doesn't have.
+var errMessageNotFound = errors.New("message not found")
+
+// rootParentId is the parent of the first messages of the conversations, the messages
+// without a parent being those predating branches.
+const rootParentId = "root"
+
+// Branch is a chain of messages of a conversation, from its first message to a leaf.
+// Editing an earlier message of the user starts a new branch at the edited message.
+type Branch struct {
+	LeafId        string `json:"leafId"`
+	MessageCount  int    `json:"messageCount"`
+	LastMessage   string `json:"lastMessage"`
+	LastMessageAt string `json:"lastMessageAt"`
+	Active        bool   `json:"active"`
+}
+
+// SwitchBranchRequest struct to parse the body of the branch switch, any message of the
+// branch to switch to.
+type SwitchBranchRequest struct {
+	MessageId string `json:"messageId"`
+}
+
+// conversationMessages returns the messages of the conversation, oldest first.
+func (h *Handlers) conversationMessages(userId, conversationId string) ([]GetMessage, error) {
+	messages, err := h.queryMessagesByUserID(userId, aws.Bool(true))
+	if err != nil {
+		return nil, err
+	}
+	var result []GetMessage
+	for _, message := range messages {
+		if conversationOf(message) == conversationId {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+// parentsOf returns the parent of each message of a conversation, the messages oldest first,
+// "" for the first messages. The messages predating branches have no parent ID, their parent
+// is the message before them.
+func parentsOf(messages []GetMessage) map[string]string {
+	parents := make(map[string]string, len(messages))
+	previous := ""
+	for _, message := range messages {
+		switch message.ParentId {
+		case rootParentId:
+			parents[message.Id] = ""
+		case "":
+			parents[message.Id] = previous
+		default:
+			parents[message.Id] = message.ParentId
+		}
+		previous = message.Id
+	}
+	return parents
+}
+
+// thread returns the chain of messages ending with the leaf, oldest first.
+func thread(messages []GetMessage, leafId string) []GetMessage {
+	byId := make(map[string]GetMessage, len(messages))
+	for _, message := range messages {
+		byId[message.Id] = message
+	}
+	parents := parentsOf(messages)
+
+	var chain []GetMessage
+	for id := leafId; id != ""; id = parents[id] {
+		message, ok := byId[id]
+		if !ok {
+			break
+		}
+		chain = append(chain, message)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// latestLeaf returns the leaf reached from the message by following its newest replies.
+func latestLeaf(messages []GetMessage, messageId string) string {
+	parents := parentsOf(messages)
+	newestChild := map[string]string{}
+	for _, message := range messages {
+		// The messages are oldest first, so the last child seen is the newest
+		newestChild[parents[message.Id]] = message.Id
+	}
+	for {
+		child, ok := newestChild[messageId]
+		if !ok {
+			return messageId
+		}
+		messageId = child
+	}
+}
+
+// activeLeaf returns the last message of the active branch of the conversation: the one
+// its summary points to, or the last message for conversations predating branches.
+func activeLeaf(conversation *Conversation, messages []GetMessage) string {
+	if conversation != nil && conversation.ActiveMessageId != "" {
+		return conversation.ActiveMessageId
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+	return messages[len(messages)-1].Id
+}
+
+// branches returns the branches of the conversation, most recently active first.
+func branches(messages []GetMessage, activeId string) []Branch {
+	parents := parentsOf(messages)
+	hasChildren := map[string]bool{}
+	for _, message := range messages {
+		hasChildren[parents[message.Id]] = true
+	}
+
+	result := []Branch{}
+	for _, message := range messages {
+		if hasChildren[message.Id] {
+			continue
+		}
+		result = append(result, Branch{
+			LeafId:        message.Id,
+			MessageCount:  len(thread(messages, message.Id)),
+			LastMessage:   snippet(message.Content),
+			LastMessageAt: message.CreatedAt,
+			Active:        message.Id == activeId,
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].LastMessageAt > result[j].LastMessageAt })
+	return result
+}
+
+func (h *Handlers) GetBranchesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract conversationId from path parameters
+	conversationId, ok := request.PathParameters["conversationId"]
+	if !ok || conversationId == "" {
+		return common.CreateErrorResponse(400, "Conversation ID is missing")
+	}
+
+	messages, err := h.conversationMessages(claims.Sub, conversationId)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if len(messages) == 0 {
+		return common.CreateErrorResponse(404, "Conversation not found")
+	}
+	conversation, err := h.getConversation(context.TODO(), claims.Sub, conversationId)
+	if err != nil {
+		log.Printf("Error getting conversation from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the branches into JSON for the payload
+	payload, err := json.Marshal(branches(messages, activeLeaf(conversation, messages)))
+	if err != nil {
+		log.Println("Error marshalling branches:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) SwitchBranchHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract conversationId from path parameters
+	conversationId, ok := request.PathParameters["conversationId"]
+	if !ok || conversationId == "" {
+		return common.CreateErrorResponse(400, "Conversation ID is missing")
+	}
+
+	// Parse the request body into a SwitchBranchRequest struct
+	var switchRequest SwitchBranchRequest
+	err = json.Unmarshal([]byte(request.Body), &switchRequest)
+	if err != nil || switchRequest.MessageId == "" {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	messages, err := h.conversationMessages(claims.Sub, conversationId)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	found := false
+	for _, message := range messages {
+		found = found || message.Id == switchRequest.MessageId
+	}
+	if !found {
+		return common.CreateErrorResponse(404, "Message not found")
+	}
+
+	// Switching to a message of the middle of a branch continues with its newest replies
+	leafId := latestLeaf(messages, switchRequest.MessageId)
+	err = h.saveConversation(context.TODO(), claims.Sub, conversationId, func(conversation *Conversation) {
+		conversation.ActiveMessageId = leafId
+	})
+	if err != nil {
+		log.Printf("Error saving conversation to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s switched conversation %s to branch %s", claims.Sub, conversationId, leafId)
+
+	// Marshal the messages of the branch into JSON for the payload
+	payload, err := json.Marshal(thread(messages, leafId))
+	if err != nil {
+		log.Println("Error marshalling messages:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// parentOfNewMessage returns the parent of the incoming message: the parent of the message
+// it edits, which must be a message of the user in the conversation, or the end of the
+// active branch.
+func (h *Handlers) parentOfNewMessage(userId string, incoming IncomingRequest) (string, error) {
+	conversationId := conversationOf(GetMessage{ConversationId: incoming.ConversationId})
+	if incoming.EditOf == "" {
+		conversation, err := h.getConversation(context.TODO(), userId, conversationId)
+		if err != nil {
+			return "", err
+		}
+		if conversation != nil && conversation.ActiveMessageId != "" {
+			return conversation.ActiveMessageId, nil
+		}
+	}
+
+	messages, err := h.conversationMessages(userId, conversationId)
+	if err != nil {
+		return "", err
+	}
+	parentId := activeLeaf(nil, messages)
+	if incoming.EditOf != "" {
+		parentId = ""
+		found := false
+		for _, message := range messages {
+			if message.Id == incoming.EditOf && message.Role == "user" {
+				parentId, found = parentsOf(messages)[message.Id], true
+			}
+		}
+		if !found {
+			return "", errMessageNotFound
+		}
+	}
+	if parentId == "" {
+		return rootParentId, nil
+	}
+	return parentId, nil
+}
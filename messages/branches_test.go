@@ -0,0 +1,129 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sendMessage posts a message of test-user-id to the default conversation, returning the
+// message and the reply of the assistant.
+func sendMessage(t *testing.T, h *Handlers, content, editOf string) (GetMessage, GetMessage) {
+	request := testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithJSONBody(t, map[string]string{"content": content, "editOf": editOf}).
+		Build()
+	response, err := h.PostMessageHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+
+	var messages []GetMessage
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &messages))
+	return messages[0], messages[1]
+}
+
+// activeBranch returns the contents of the active branch of the default conversation.
+func activeBranch(t *testing.T, h *Handlers) []string {
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithQueryParam("conversationId", DefaultConversation).
+		WithQueryParam("branch", "active").
+		Build()
+	response, err := h.GetMessageHandler(request)
+	assert.NoError(t, err)
+
+	var messages []GetMessage
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &messages))
+	var contents []string
+	for _, message := range messages {
+		contents = append(contents, message.Content)
+	}
+	return contents
+}
+
+func TestBranches(t *testing.T) {
+	// Set up the fake DynamoDB and a model echoing the conversation it is given
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]"}, nil
+		}
+		reply := "re:"
+		for _, message := range request.Messages {
+			if message.Role == llm.RoleUser {
+				reply += " " + message.Content
+			}
+		}
+		return llm.Response{Content: reply}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	first, _ := sendMessage(t, h, "a", "")
+	second, _ := sendMessage(t, h, "b", "")
+	assert.Equal(t, rootParentId, first.ParentId)
+
+	// Editing the second message branches the conversation after the first one
+	edited, reply := sendMessage(t, h, "B", second.Id)
+	assert.Equal(t, second.ParentId, edited.ParentId)
+	assert.Equal(t, "re: a B", reply.Content)
+	assert.Equal(t, []string{"a", "re: a", "B", "re: a B"}, activeBranch(t, h))
+
+	// The next message continues the active branch
+	sendMessage(t, h, "c", "")
+	assert.Equal(t, []string{"a", "re: a", "B", "re: a B", "c", "re: a B c"}, activeBranch(t, h))
+
+	request := testutil.NewRequest("GET", "").
+		WithClaims("test-user-id", "test-user").
+		WithPathParam("conversationId", DefaultConversation).
+		Build()
+	response, err := h.GetBranchesHandler(request)
+	assert.NoError(t, err)
+	var listed []Branch
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &listed))
+	assert.Len(t, listed, 2)
+	assert.True(t, listed[0].Active)
+	assert.Equal(t, 6, listed[0].MessageCount)
+	assert.Equal(t, 4, listed[1].MessageCount)
+
+	// Switch back to the original branch
+	request = testutil.NewRequest("PUT", "").
+		WithClaims("test-user-id", "test-user").
+		WithPathParam("conversationId", DefaultConversation).
+		WithJSONBody(t, SwitchBranchRequest{MessageId: second.Id}).
+		Build()
+	response, err = h.SwitchBranchHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, []string{"a", "re: a", "b", "re: a b"}, activeBranch(t, h))
+
+	// Editing the first message starts a branch from the start
+	_, reply = sendMessage(t, h, "A", first.Id)
+	assert.Equal(t, "re: A", reply.Content)
+	assert.Equal(t, []string{"A", "re: A"}, activeBranch(t, h))
+
+	// Only the messages of the user can be edited
+	request = testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithJSONBody(t, map[string]string{"content": "x", "editOf": reply.Id}).
+		Build()
+	response, err = h.PostMessageHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestLegacyThread(t *testing.T) {
+	// Messages predating branches follow each other
+	messages := []GetMessage{{Id: "1"}, {Id: "2"}, {Id: "3"}, {Id: "4", ParentId: "2"}}
+	assert.Len(t, thread(messages, "3"), 3)
+	assert.Len(t, thread(messages, "4"), 3)
+	assert.Equal(t, "4", latestLeaf(messages, "1"))
+	assert.Len(t, branches(messages, "4"), 2)
+}
@@ -0,0 +1,229 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"vassistant-backend/financial"
+)
+
+// errCommandUsage is returned by the commands run with invalid arguments.
+var errCommandUsage = errors.New("invalid command arguments")
+
+// CommandResult is the structured result of a command, for the clients to render along
+// with the text of the reply. Error is set instead of Data when the command failed.
+type CommandResult struct {
+	Name  string          `json:"name" dynamodbav:"name"`
+	Error string          `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty" dynamodbav:"data,omitempty"`
+}
+
+// command is run by a message starting with "/" and its name, e.g. "/balance House", and
+// answered right away rather than by the language model. It returns the text of the reply
+// and the data of its result.
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, userId, args string) (string, any, error)
+}
+
+// commands returns the commands by name.
+func (h *Handlers) commands() map[string]command {
+	return map[string]command{
+		"help": {
+			usage:       "/help",
+			description: "Lists the commands",
+			run:         h.helpCommand,
+		},
+		"groups": {
+			usage:       "/groups",
+			description: "Lists your groups",
+			run:         h.groupsCommand,
+		},
+		"balance": {
+			usage:       "/balance [group]",
+			description: "Shows who owes what in a group",
+			run:         h.balanceCommand,
+		},
+		"add": {
+			usage:       "/add <amount> [currency] <title> [@group]",
+			description: "Adds an expense you paid, split with the group defaults",
+			run:         h.addCommand,
+		},
+	}
+}
+
+// isCommand reports whether the content of a message is a command.
+func isCommand(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "/")
+}
+
+// parseCommand splits a command into its name, lower-cased, and its arguments.
+func parseCommand(content string) (string, string) {
+	content = strings.TrimPrefix(strings.TrimSpace(content), "/")
+	name, args, _ := strings.Cut(content, " ")
+	return strings.ToLower(name), strings.TrimSpace(args)
+}
+
+// runCommand runs the command of the message. The mistakes of the user, like an unknown
+// command or group, are answered in the reply; the error is for the command failing.
+func (h *Handlers) runCommand(ctx context.Context, message GetMessage) (string, *CommandResult, error) {
+	name, args := parseCommand(message.Content)
+	return h.runUserCommand(ctx, message.UserId, name, args)
+}
+
+// runUserCommand runs the command with the arguments for the user, like runCommand.
+func (h *Handlers) runUserCommand(ctx context.Context, userId, name, args string) (string, *CommandResult, error) {
+	result := &CommandResult{Name: name}
+	cmd, ok := h.commands()[name]
+	if !ok {
+		result.Error = fmt.Sprintf("Unknown command /%s, send /help for the commands", name)
+		return result.Error, result, nil
+	}
+
+	text, data, err := cmd.run(ctx, userId, args)
+	var invalidExpense *financial.InvalidExpenseError
+	var quotaErr *financial.QuotaError
+	switch {
+	case errors.Is(err, errCommandUsage):
+		result.Error = "Usage: " + cmd.usage
+	case errors.Is(err, financial.ErrGroupNotFound):
+		result.Error = "You have no group with that name, send /groups for your groups"
+	case errors.Is(err, financial.ErrAmbiguousGroup):
+		result.Error = "More than one of your groups matches, send /groups for your groups"
+	case errors.Is(err, financial.ErrNotGroupMember):
+		result.Error = "You are not a member of that group"
+	case errors.As(err, &invalidExpense):
+		result.Error = invalidExpense.Message
+	case errors.As(err, &quotaErr):
+		result.Error = fmt.Sprintf("The group has reached its quota of %d %s", quotaErr.Limit, quotaErr.Resource)
+	case err != nil:
+		return "", nil, err
+	}
+	if result.Error != "" {
+		return result.Error, result, nil
+	}
+
+	if data != nil {
+		result.Data, err = json.Marshal(data)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return text, result, nil
+}
+
+func (h *Handlers) helpCommand(ctx context.Context, userId, args string) (string, any, error) {
+	commands := h.commands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{"Commands:"}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s: %s", commands[name].usage, commands[name].description))
+	}
+	return strings.Join(lines, "\n"), nil, nil
+}
+
+func (h *Handlers) groupsCommand(ctx context.Context, userId, args string) (string, any, error) {
+	groups, err := h.financial.UserGroups(ctx, userId)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(groups) == 0 {
+		return "You have no groups yet.", []financial.GroupMember{}, nil
+	}
+
+	lines := []string{"Your groups:"}
+	for _, group := range groups {
+		lines = append(lines, group.GroupName)
+	}
+	return strings.Join(lines, "\n"), groups, nil
+}
+
+func (h *Handlers) balanceCommand(ctx context.Context, userId, args string) (string, any, error) {
+	group, err := h.financial.FindUserGroup(ctx, userId, args)
+	if err != nil {
+		return "", nil, err
+	}
+	balance, err := h.financial.GetUserGroupBalance(ctx, userId, group)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(balance.Balances) == 0 {
+		return fmt.Sprintf("You are settled up in %s.", group.GroupName), balance, nil
+	}
+
+	lines := []string{group.GroupName + ":"}
+	for _, debt := range balance.Balances {
+		amount := strings.TrimPrefix(debt.Amount.String(), "-")
+		if strings.HasPrefix(debt.Amount.String(), "-") {
+			lines = append(lines, fmt.Sprintf("You owe %s %s %s", displayName(debt.User, debt.UserID), amount, debt.Currency))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s owes you %s %s", displayName(debt.User, debt.UserID), amount, debt.Currency))
+		}
+	}
+	return strings.Join(lines, "\n"), balance, nil
+}
+
+func (h *Handlers) addCommand(ctx context.Context, userId, args string) (string, any, error) {
+	// The group, if any, follows the last "@"
+	groupName := ""
+	if at := strings.LastIndex(args, "@"); at >= 0 {
+		args, groupName = args[:at], args[at+1:]
+	}
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return "", nil, errCommandUsage
+	}
+
+	// A decimal comma is accepted for the amount, and a currency code in capitals may follow it
+	amount := strings.Replace(fields[0], ",", ".", 1)
+	currency := ""
+	if len(fields) > 2 && len(fields[1]) == 3 && fields[1] == strings.ToUpper(fields[1]) {
+		currency, fields = fields[1], fields[1:]
+	}
+	title := strings.Join(fields[1:], " ")
+
+	group, err := h.financial.FindUserGroup(ctx, userId, groupName)
+	if err != nil {
+		return "", nil, err
+	}
+	added, err := h.financial.AddExpense(ctx, userId, group.GroupID, financial.FinancialExpense{
+		Title:    title,
+		Amount:   json.Number(amount),
+		Currency: currency,
+		PaidBy:   userId,
+		DateTime: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	expense := added.Expense
+	amountText := strings.TrimSpace(fmt.Sprintf("%s %s", expense.Amount, expense.Currency))
+	if added.Approval != nil {
+		text := fmt.Sprintf("%s, %s, takes members of %s over their spending cap, it waits for an admin to approve it.", expense.Title, amountText, group.GroupName)
+		return text, added.Approval, nil
+	}
+	lines := []string{fmt.Sprintf("Added %s, %s, to %s.", expense.Title, amountText, group.GroupName)}
+	lines = append(lines, added.Warnings...)
+	return strings.Join(lines, "\n"), expense, nil
+}
+
+// displayName returns the name to show for a user in the replies.
+func displayName(user financial.User, userId string) string {
+	if user.ShowableName != "" {
+		return user.ShowableName
+	}
+	if user.Username != "" {
+		return user.Username
+	}
+	return userId
+}
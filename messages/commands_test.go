@@ -0,0 +1,90 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommand(t *testing.T) {
+	name, args := parseCommand("  /Balance  House party ")
+	assert.Equal(t, "balance", name)
+	assert.Equal(t, "House party", args)
+
+	name, args = parseCommand("/help")
+	assert.Equal(t, "help", name)
+	assert.Equal(t, "", args)
+
+	assert.True(t, isCommand(" /add 20 pizza"))
+	assert.False(t, isCommand("20/4 each?"))
+}
+
+func TestCommands(t *testing.T) {
+	// Set up the fake DynamoDB with two groups of user-1, and a model failing the test if asked
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "test-user-id", "groupId": "house", "groupName": "House"},
+			{"userId": "test-user-id", "groupId": "trip", "groupName": "Trip to Rome"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+		"splitter-group-settings": {{"groupId": "house", "defaultCurrency": "EUR"}},
+		"vassistant-users":        {{"userId": "user-2", "username": "bob", "showableName": "Bob"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		t.Error("commands must not be sent to the language model")
+		return llm.Response{}, errors.New("unexpected request")
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	// Adding an expense to a group named by a prefix of its name
+	_, reply := sendMessage(t, h, "/add 20 pizza night @hou", "")
+	assert.Equal(t, "Added pizza night, 20 EUR, to House.", reply.Content)
+	assert.Equal(t, "add", reply.Command.Name)
+	var expense financial.FinancialExpense
+	assert.NoError(t, json.Unmarshal(reply.Command.Data, &expense))
+	assert.Equal(t, "house", expense.GroupID)
+	assert.Equal(t, "test-user-id", expense.PaidBy)
+	assert.Len(t, expense.Participants, 2)
+
+	// The balance of the group shows the share of the other member
+	_, reply = sendMessage(t, h, "/balance house", "")
+	assert.Equal(t, "House:\nBob owes you 10.00 EUR", reply.Content)
+	var balance financial.UserGroupBalance
+	assert.NoError(t, json.Unmarshal(reply.Command.Data, &balance))
+	assert.Equal(t, []financial.MemberDebt{{
+		UserID:   "user-2",
+		User:     financial.User{UserID: "user-2", Username: "bob", ShowableName: "Bob"},
+		Currency: "EUR",
+		Amount:   "10.00",
+	}}, balance.Balances)
+
+	// The result is saved with the reply
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "house", balance.GroupID)
+	assert.Equal(t, "balance", messages[len(messages)-1].Command.Name)
+	assert.JSONEq(t, string(reply.Command.Data), string(messages[len(messages)-1].Command.Data))
+
+	// The mistakes of the user are answered with what went wrong
+	for content, want := range map[string]string{
+		"/balance":        "More than one of your groups matches, send /groups for your groups",
+		"/balance office": "You have no group with that name, send /groups for your groups",
+		"/add pizza":      "Usage: /add <amount> [currency] <title> [@group]",
+		"/add ten pizza":  "More than one of your groups matches, send /groups for your groups",
+		"/add ten x @hou": "Invalid amount",
+		"/dance":          "Unknown command /dance, send /help for the commands",
+	} {
+		_, reply = sendMessage(t, h, content, "")
+		assert.Equal(t, want, reply.Content, content)
+		assert.Equal(t, want, reply.Command.Error, content)
+	}
+}
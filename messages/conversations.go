@@ -0,0 +1,228 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DefaultConversation is the conversation of the messages sent without one, like all the
+// messages sent before there were conversations.
+const DefaultConversation = "default"
+
+// maxSnippetLength is the length of the preview of the last message, in characters.
+const maxSnippetLength = 100
+
+// maxSummaryAttempts bounds how many times updating a summary is retried on concurrent writes.
+const maxSummaryAttempts = 3
+
+// Conversation struct for the chat-conversations table, the summary of a conversation
+// maintained on every message so conversations are listed with a single query.
+type Conversation struct {
+	UserId          string                `json:"userId" dynamodbav:"userId"`
+	ConversationId  string                `json:"conversationId" dynamodbav:"conversationId"`
+	LastMessage     string                `json:"lastMessage" dynamodbav:"lastMessage"`
+	LastMessageRole string                `json:"lastMessageRole" dynamodbav:"lastMessageRole"`
+	LastMessageAt   string                `json:"lastMessageAt" dynamodbav:"lastMessageAt"`
+	UnreadCount     int                   `json:"unreadCount" dynamodbav:"unreadCount"`
+	MessageCount    int                   `json:"messageCount" dynamodbav:"messageCount"`
+	CreatedAt       string                `json:"createdAt" dynamodbav:"createdAt"`
+	ActiveMessageId string                `json:"activeMessageId,omitempty" dynamodbav:"activeMessageId,omitempty"` // last message of the active branch
+	Settings        *ConversationSettings `json:"settings,omitempty" dynamodbav:"settings,omitempty"`
+	Version         int                   `json:"-" dynamodbav:"version,omitempty"`
+}
+
+// conversationOf returns the conversation of the message.
+func conversationOf(message GetMessage) string {
+	if message.ConversationId == "" {
+		return DefaultConversation
+	}
+	return message.ConversationId
+}
+
+// snippet returns the preview of the content of a message.
+func snippet(content string) string {
+	runes := []rune(content)
+	if len(runes) <= maxSnippetLength {
+		return content
+	}
+	return string(runes[:maxSnippetLength-1]) + "…"
+}
+
+// getConversation returns the summary of the conversation, or nil if there is none.
+func (h *Handlers) getConversation(ctx context.Context, userId, conversationId string) (*Conversation, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("chat-conversations"),
+		Key: map[string]types.AttributeValue{
+			"userId":         &types.AttributeValueMemberS{Value: userId},
+			"conversationId": &types.AttributeValueMemberS{Value: conversationId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var conversation Conversation
+	err = attributevalue.UnmarshalMap(result.Item, &conversation)
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// applyMessages updates the summary with the messages, in order, the last one becoming the
+// end of the active branch. A message of the user means they read the conversation, the
+// messages of the assistant are unread until then.
+func applyMessages(conversation *Conversation, messages []GetMessage) {
+	for _, message := range messages {
+		if conversation.CreatedAt == "" {
+			conversation.CreatedAt = message.CreatedAt
+		}
+		conversation.LastMessage = snippet(message.Content)
+		conversation.LastMessageRole = message.Role
+		conversation.LastMessageAt = message.CreatedAt
+		conversation.MessageCount++
+		conversation.ActiveMessageId = message.Id
+		if message.Role == "user" {
+			conversation.UnreadCount = 0
+		} else {
+			conversation.UnreadCount++
+		}
+	}
+}
+
+// updateConversation applies the messages, all of the same conversation, to its summary,
+// retrying when the summary is updated concurrently.
+func (h *Handlers) updateConversation(ctx context.Context, userId string, messages ...GetMessage) error {
+	conversationId := conversationOf(messages[0])
+	return h.saveConversation(ctx, userId, conversationId, func(conversation *Conversation) {
+		applyMessages(conversation, messages)
+	})
+}
+
+// saveConversation updates the summary of the conversation with the change, creating it
+// when missing, retrying when the summary is updated concurrently.
+func (h *Handlers) saveConversation(ctx context.Context, userId, conversationId string, change func(*Conversation)) error {
+	for attempt := 1; ; attempt++ {
+		conversation, err := h.getConversation(ctx, userId, conversationId)
+		if err != nil {
+			return err
+		}
+		if conversation == nil {
+			conversation = &Conversation{UserId: userId, ConversationId: conversationId}
+		}
+
+		expectedVersion := conversation.Version
+		change(conversation)
+		conversation.Version = expectedVersion + 1
+		err = common.ConditionalPutItem(ctx, h.client, "chat-conversations", conversation, common.IfVersion(expectedVersion))
+		if errors.Is(err, common.ErrConditionFailed) && attempt < maxSummaryAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+func (h *Handlers) GetConversationsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Build the query input
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("chat-conversations"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: claims.Sub},
+		},
+		ConsistentRead: common.ConsistentRead(request),
+	}
+
+	// Make the DynamoDB Query API call
+	result, err := h.client.Query(context.TODO(), queryInput)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Unmarshal the Items into a slice of Conversation structs
+	conversations := []Conversation{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &conversations)
+	if err != nil {
+		log.Printf("Error unmarshalling conversations: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Most recently active first
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].LastMessageAt > conversations[j].LastMessageAt
+	})
+
+	// Marshal the conversations into JSON for the payload
+	payload, err := json.Marshal(conversations)
+	if err != nil {
+		log.Println("Error marshalling conversations:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) ReadConversationHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract conversationId from path parameters
+	conversationId, ok := request.PathParameters["conversationId"]
+	if !ok || conversationId == "" {
+		return common.CreateErrorResponse(400, "Conversation ID is missing")
+	}
+
+	conversation, err := h.getConversation(context.TODO(), claims.Sub, conversationId)
+	if err != nil {
+		log.Printf("Error getting conversation from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if conversation == nil {
+		return common.CreateErrorResponse(404, "Conversation not found")
+	}
+
+	if conversation.UnreadCount > 0 {
+		err = h.saveConversation(context.TODO(), claims.Sub, conversationId, func(conversation *Conversation) {
+			conversation.UnreadCount = 0
+		})
+		if err != nil {
+			log.Printf("Error saving conversation to DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
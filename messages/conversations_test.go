@@ -0,0 +1,73 @@
+package messages
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// postMessage sends a message of test-user-id to the conversation.
+func postMessage(t *testing.T, h *Handlers, conversationId, content string) {
+	request := testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithJSONBody(t, map[string]string{"conversationId": conversationId, "content": content}).
+		Build()
+	response, err := h.PostMessageHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+}
+
+// listConversations returns the conversations of test-user-id.
+func listConversations(t *testing.T, h *Handlers) []Conversation {
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/messages/conversations").
+		WithClaims("test-user-id", "test-user").
+		Build()
+	response, err := h.GetConversationsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var conversations []Conversation
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &conversations))
+	return conversations
+}
+
+func TestConversations(t *testing.T) {
+	// Set up the fake DynamoDB
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+
+	postMessage(t, h, "", "Hello, world!")
+	postMessage(t, h, "rent", strings.Repeat("When is my rent due? ", 10))
+	postMessage(t, h, "rent", "Thanks")
+
+	// Both conversations are listed, the most recent first, with the reply of the assistant unread
+	conversations := listConversations(t, h)
+	assert.Len(t, conversations, 2)
+	assert.Equal(t, "rent", conversations[0].ConversationId)
+	assert.Equal(t, 4, conversations[0].MessageCount)
+	assert.Equal(t, 1, conversations[0].UnreadCount)
+	assert.Equal(t, "assistant", conversations[0].LastMessageRole)
+	assert.Equal(t, DefaultConversation, conversations[1].ConversationId)
+
+	// Reading the conversation clears its unread count
+	request := testutil.NewRequest("POST", "").
+		WithClaims("test-user-id", "test-user").
+		WithPathParam("conversationId", "rent").
+		Build()
+	response, err := h.ReadConversationHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	assert.Equal(t, 0, listConversations(t, h)[0].UnreadCount)
+}
+
+func TestSnippet(t *testing.T) {
+	assert.Equal(t, "short", snippet("short"))
+	long := snippet(strings.Repeat("á", 150))
+	assert.Equal(t, maxSnippetLength, len([]rune(long)))
+	assert.True(t, strings.HasSuffix(long, "…"))
+}
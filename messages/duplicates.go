@@ -0,0 +1,63 @@
+package messages
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// duplicateWindow is how long after a message the same content sent again by the user in
+// the conversation is taken for a resubmission, like a double tap, rather than a new message.
+const duplicateWindow = 5 * time.Second
+
+// findResubmission returns the message of the user the incoming one resubmits, with the
+// reply of the assistant to it, or nil when the incoming message is new. The reply is nil
+// while the assistant is still answering the original message.
+func (h *Handlers) findResubmission(ctx context.Context, userId string, incoming IncomingRequest, now time.Time) (*GetMessage, *GetMessage, error) {
+	// The createdAt of the messages have a variable number of decimals, so the query starts
+	// at the second before the window and the exact times are compared once parsed
+	since := now.Add(-duplicateWindow - time.Second).UTC().Format("2006-01-02T15:04:05")
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("chat"),
+		KeyConditionExpression: aws.String("userId = :userId AND createdAt >= :since"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+			":since":  &types.AttributeValueMemberS{Value: since},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var messages []GetMessage
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conversationId := conversationOf(GetMessage{ConversationId: incoming.ConversationId})
+	var original, reply *GetMessage
+	for i, message := range messages {
+		if conversationOf(message) != conversationId {
+			continue
+		}
+		if message.Role == "assistant" && original != nil && reply == nil {
+			reply = &messages[i]
+		}
+		if message.Role != "user" {
+			continue
+		}
+
+		// Only the last message of the user can be resubmitted
+		original, reply = nil, nil
+		createdAt, err := time.Parse(time.RFC3339Nano, message.CreatedAt)
+		if err == nil && message.Content == incoming.Content && now.Sub(createdAt) <= duplicateWindow {
+			original = &messages[i]
+		}
+	}
+	return original, reply, nil
+}
@@ -0,0 +1,94 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResubmittedMessage(t *testing.T) {
+	// Set up the fake DynamoDB and a model counting its replies
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+
+	replies := 0
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]"}, nil
+		}
+		replies++
+		return llm.Response{Content: "Hi!"}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	send := func(conversationId, content string) (int, []GetMessage) {
+		request := testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+			WithClaims("test-user-id", "test-user").
+			WithJSONBody(t, map[string]string{"conversationId": conversationId, "content": content}).
+			Build()
+		response, err := h.PostMessageHandler(request)
+		assert.NoError(t, err)
+		var messages []GetMessage
+		json.Unmarshal([]byte(response.Body), &messages)
+		return response.StatusCode, messages
+	}
+
+	status, first := send("", "Hello")
+	assert.Equal(t, http.StatusCreated, status)
+
+	// A double tap gets the same messages back without another reply
+	status, second := send("", "Hello")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, replies)
+
+	// The same content in another conversation, or after another message, is a new message
+	status, _ = send("work", "Hello")
+	assert.Equal(t, http.StatusCreated, status)
+	send("", "How are you?")
+	status, _ = send("", "Hello")
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 4, replies)
+
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 8)
+}
+
+func TestFindResubmission(t *testing.T) {
+	now := time.Now()
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"chat": {
+			{"userId": "test-user-id", "id": "old", "role": "user", "content": "Hello", "createdAt": now.Add(-time.Minute).UTC().Format(time.RFC3339Nano)},
+			{"userId": "test-user-id", "id": "pending", "role": "user", "content": "Pay rent", "createdAt": now.Add(-time.Second).UTC().Format(time.RFC3339Nano)},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+
+	// The original message is still being answered
+	original, reply, err := h.findResubmission(context.TODO(), "test-user-id", IncomingRequest{Content: "Pay rent"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "pending", original.Id)
+	assert.Nil(t, reply)
+
+	request := testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithBody(`{"content": "Pay rent"}`).
+		Build()
+	response, err := h.PostMessageHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, response.StatusCode)
+
+	// Messages older than the window aren't resubmitted
+	original, _, err = h.findResubmission(context.TODO(), "test-user-id", IncomingRequest{Content: "Hello"}, now)
+	assert.NoError(t, err)
+	assert.Nil(t, original)
+}
@@ -0,0 +1,90 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/financial"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// groupConversationPrefix starts the IDs of the conversations about a group, one per user
+// and group, which only the group assistant endpoint posts to.
+const groupConversationPrefix = "group:"
+
+// groupConversationId returns the ID of the conversation of the user about the group.
+func groupConversationId(groupId string) string {
+	return groupConversationPrefix + groupId
+}
+
+// isGroupConversation reports whether the conversation is about a group.
+func isGroupConversation(conversationId string) bool {
+	return strings.HasPrefix(conversationId, groupConversationPrefix)
+}
+
+// assembleGroupPrompt tells the assistant about the group the conversation is about, and
+// what its members owe the user.
+func (h *Handlers) assembleGroupPrompt(ctx context.Context, userId string, group financial.GroupMember) (string, error) {
+	balance, err := h.financial.GetUserGroupBalance(ctx, userId, group)
+	if err != nil {
+		return "", err
+	}
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "\n\nThis conversation is about the expense group %q. Only use and discuss the data of this group.", group.GroupName)
+	if len(balance.Balances) == 0 {
+		prompt.WriteString("\nThe user is settled up with every member of the group.")
+	}
+	for _, debt := range balance.Balances {
+		amount := strings.TrimPrefix(debt.Amount.String(), "-")
+		if strings.HasPrefix(debt.Amount.String(), "-") {
+			fmt.Fprintf(&prompt, "\n- The user owes %s %s %s", displayName(debt.User, debt.UserID), amount, debt.Currency)
+		} else {
+			fmt.Fprintf(&prompt, "\n- %s owes the user %s %s", displayName(debt.User, debt.UserID), amount, debt.Currency)
+		}
+	}
+	return prompt.String(), nil
+}
+
+// PostGroupAssistantHandler posts a message of the user to their conversation with the
+// assistant about the group. The user must be a member of the group.
+func (h *Handlers) PostGroupAssistantHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract groupId from path parameters
+	groupId, ok := request.PathParameters["groupId"]
+	if !ok || groupId == "" {
+		return common.CreateErrorResponse(400, "Group ID is missing")
+	}
+
+	// Parse the incoming request body
+	var incomingReq IncomingRequest
+	err = json.Unmarshal([]byte(request.Body), &incomingReq)
+	if err != nil {
+		log.Println("Error unmarshalling request body:", err)
+		return common.CreateErrorResponse(400, "Invalid request body format")
+	}
+	incomingReq.ConversationId = groupConversationId(groupId)
+
+	group, err := h.financial.UserGroup(context.TODO(), claims.Sub, groupId)
+	if err != nil {
+		log.Printf("Error getting group member from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if group == nil {
+		return common.CreateErrorResponse(404, "Group not found")
+	}
+
+	return h.postConversationMessage(claims.Sub, claims.Username, incomingReq, group)
+}
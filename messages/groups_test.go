@@ -0,0 +1,86 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+	"vassistant-backend/tools"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostGroupAssistantHandler(t *testing.T) {
+	// Set up the fake DynamoDB with a user in two groups, owed money in one of them
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "test-user-id", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+			{"userId": "test-user-id", "groupId": "trip", "groupName": "Trip"},
+		},
+		"splitter-expenses": {{
+			"groupId": "house", "expenseId": "rent", "title": "Rent", "amount": "100", "currency": "EUR", "paidBy": "test-user-id",
+			"participants": []map[string]interface{}{{"userId": "test-user-id", "calculatedMoney": "50"}, {"userId": "user-2", "calculatedMoney": "50"}},
+		}},
+		"vassistant-users": {{"userId": "user-2", "username": "bob", "showableName": "Bob"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+	tools.DynamoDbClient = fake
+	Tools = tools.NewDispatcher(h.financial.AssistantTools()...)
+	defer func() { Tools = nil }()
+
+	// The model lists the groups and debts, then answers
+	var requests []llm.Request
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			return llm.Response{ToolCalls: []llm.ToolCall{
+				{ID: "call-1", Name: "list_groups"},
+				{ID: "call-2", Name: "get_net_debts"},
+			}}, nil
+		}
+		return llm.Response{Content: "Bob owes you 50 EUR."}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	post := func(sub, groupId string) events.APIGatewayProxyResponse {
+		response, err := h.PostGroupAssistantHandler(testutil.NewRequest("POST", "").
+			WithClaims(sub, "alice").
+			WithPathParam("groupId", groupId).
+			WithJSONBody(t, IncomingRequest{Content: "Who owes me money in this group?"}).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	response := post("test-user-id", "house")
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var messages []GetMessage
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &messages))
+	assert.Equal(t, "group:house", messages[0].ConversationId)
+	assert.Equal(t, "Bob owes you 50 EUR.", messages[1].Content)
+
+	// The assistant is told about the group, and its tools only reach the group
+	assert.Contains(t, requests[0].System, `"House"`)
+	assert.Contains(t, requests[0].System, "Bob owes the user 50.00 EUR")
+	results := requests[1].Messages[len(requests[1].Messages)-2:]
+	assert.JSONEq(t, `[{"groupId":"house","groupName":"House"}]`, results[0].Content)
+	assert.Contains(t, results[1].Content, `"groupId":"house"`)
+	assert.NotContains(t, results[1].Content, "trip")
+
+	// Only the members of the group can ask about it
+	assert.Equal(t, http.StatusNotFound, post("user-3", "house").StatusCode)
+
+	// The group conversations can't be posted to as plain conversations
+	plain, err := h.PostMessageHandler(testutil.NewRequest("POST", "").
+		WithClaims("test-user-id", "alice").
+		WithJSONBody(t, IncomingRequest{Content: "Hi", ConversationId: "group:trip"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, plain.StatusCode)
+}
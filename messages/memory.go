@@ -0,0 +1,309 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/llm"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// maxMemories bounds how many facts are remembered per user; new facts are dropped beyond it.
+const maxMemories = 100
+
+// maxRelevantMemories bounds how many memories are injected into a prompt.
+const maxRelevantMemories = 5
+
+// minMemoryScore is the similarity to the message below which a memory isn't relevant, when
+// the memories are searched by meaning.
+const minMemoryScore = 0.3
+
+// maxFactLength bounds the length of a remembered fact, in characters.
+const maxFactLength = 200
+
+const extractionSystemPrompt = "Extract the durable facts the user states about themselves in their message, " +
+	"like dates, amounts, people or preferences, e.g. \"My rent is due on the 5th\". Ignore questions, requests " +
+	"and anything temporary. Answer with a JSON array of short sentences in the language of the user, " +
+	"or [] when there are none."
+
+// Memory struct for the assistant-memories table, a fact about the user the assistant remembers.
+type Memory struct {
+	UserId         string `json:"-" dynamodbav:"userId"`
+	MemoryId       string `json:"memoryId" dynamodbav:"memoryId"`
+	Fact           string `json:"fact" dynamodbav:"fact"`
+	ConversationId string `json:"conversationId,omitempty" dynamodbav:"conversationId,omitempty"`
+	CreatedAt      string `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// listMemories returns the memories of the user, oldest first.
+func (h *Handlers) listMemories(ctx context.Context, userId string) ([]Memory, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("assistant-memories"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	}
+
+	memories := []Memory{}
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var page []Memory
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		memories = append(memories, page...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+	sort.SliceStable(memories, func(i, j int) bool { return memories[i].CreatedAt < memories[j].CreatedAt })
+	return memories, nil
+}
+
+// words returns the lowercased words of the text with at least three letters or digits.
+func words(text string) map[string]struct{} {
+	result := map[string]struct{}{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(word)) >= 3 {
+			result[word] = struct{}{}
+		}
+	}
+	return result
+}
+
+// rankMemories returns the memories sharing the most words with the content, at most
+// maxRelevantMemories of them. When the user has few memories, all of them are relevant.
+func rankMemories(memories []Memory, content string) []Memory {
+	if len(memories) <= maxRelevantMemories {
+		return memories
+	}
+
+	contentWords := words(content)
+	scores := make(map[string]int, len(memories))
+	var relevant []Memory
+	for _, memory := range memories {
+		for word := range words(memory.Fact) {
+			if _, ok := contentWords[word]; ok {
+				scores[memory.MemoryId]++
+			}
+		}
+		if scores[memory.MemoryId] > 0 {
+			relevant = append(relevant, memory)
+		}
+	}
+	sort.SliceStable(relevant, func(i, j int) bool { return scores[relevant[i].MemoryId] > scores[relevant[j].MemoryId] })
+	if len(relevant) > maxRelevantMemories {
+		relevant = relevant[:maxRelevantMemories]
+	}
+	return relevant
+}
+
+// relevantMemories returns the memories of the user relevant to the content of a message.
+// With the embeddings enabled, the memories closest in meaning come first, followed by those
+// sharing words with the message, like the memories remembered before they were embedded.
+func (h *Handlers) relevantMemories(ctx context.Context, userId, content string) ([]Memory, error) {
+	memories, err := h.listMemories(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	ranked := rankMemories(memories, content)
+	if !h.embeddings.Enabled() || len(memories) <= maxRelevantMemories {
+		return ranked, nil
+	}
+
+	matches, err := h.embeddings.Search(ctx, []string{embeddings.UserNamespace(userId)}, []string{embeddings.KindMemory}, content, maxRelevantMemories, minMemoryScore)
+	if err != nil {
+		log.Printf("Error searching the memories, ranking them by words: %v", err)
+		return ranked, nil
+	}
+	byId := make(map[string]Memory, len(memories))
+	for _, memory := range memories {
+		byId[memory.MemoryId] = memory
+	}
+	relevant := []Memory{}
+	seen := map[string]bool{}
+	for _, match := range matches {
+		if memory, ok := byId[match.ID]; ok {
+			relevant = append(relevant, memory)
+			seen[memory.MemoryId] = true
+		}
+	}
+	for _, memory := range ranked {
+		if len(relevant) == maxRelevantMemories {
+			break
+		}
+		if !seen[memory.MemoryId] {
+			relevant = append(relevant, memory)
+		}
+	}
+	return relevant, nil
+}
+
+// parseFacts parses the facts extracted by the model, ignoring anything but a JSON array of
+// strings, which models sometimes wrap in a code block.
+func parseFacts(content string) []string {
+	content = strings.TrimSpace(content)
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.Trim(content, "` \n")
+
+	var extracted []string
+	if err := json.Unmarshal([]byte(content), &extracted); err != nil {
+		return nil
+	}
+	var facts []string
+	for _, fact := range extracted {
+		fact = strings.TrimSpace(fact)
+		if fact != "" && len([]rune(fact)) <= maxFactLength {
+			facts = append(facts, fact)
+		}
+	}
+	return facts
+}
+
+// extractMemories asks the model for the facts the user stated in the message and remembers
+// the new ones. Nothing is extracted without a language model.
+func (h *Handlers) extractMemories(ctx context.Context, message GetMessage) error {
+	if llm.DefaultProvider == nil {
+		return nil
+	}
+
+	response, err := llm.Complete(ctx, llm.Request{
+		System:    extractionSystemPrompt,
+		Messages:  []llm.Message{{Role: llm.RoleUser, Content: message.Content}},
+		MaxTokens: 200,
+		User:      message.UserId,
+	})
+	if err != nil {
+		return err
+	}
+	facts := parseFacts(response.Content)
+	if len(facts) == 0 {
+		return nil
+	}
+
+	memories, err := h.listMemories(ctx, message.UserId)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]struct{}, len(memories))
+	for _, memory := range memories {
+		known[strings.ToLower(memory.Fact)] = struct{}{}
+	}
+
+	count := len(memories)
+	for _, fact := range facts {
+		if _, ok := known[strings.ToLower(fact)]; ok {
+			continue
+		}
+		if count >= maxMemories {
+			log.Printf("User %s has %d memories, not remembering more", message.UserId, count)
+			return nil
+		}
+
+		memory := Memory{
+			UserId:         message.UserId,
+			MemoryId:       uuid.New().String(),
+			Fact:           fact,
+			ConversationId: conversationOf(message),
+			CreatedAt:      time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		err = common.ConditionalPutItem(ctx, h.client, "assistant-memories", memory, common.IfNotExists("memoryId"))
+		if err != nil {
+			return err
+		}
+		err = h.embeddings.Index(ctx, embeddings.UserNamespace(message.UserId), embeddings.Item{Kind: embeddings.KindMemory, ID: memory.MemoryId}, fact)
+		if err != nil {
+			log.Printf("Error embedding memory %s: %v", memory.MemoryId, err)
+		}
+		known[strings.ToLower(fact)] = struct{}{}
+		count++
+	}
+	return nil
+}
+
+func (h *Handlers) GetMemoriesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	memories, err := h.listMemories(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the memories into JSON for the payload
+	payload, err := json.Marshal(memories)
+	if err != nil {
+		log.Println("Error marshalling memories:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) DeleteMemoryHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract memoryId from path parameters
+	memoryId, ok := request.PathParameters["memoryId"]
+	if !ok || memoryId == "" {
+		return common.CreateErrorResponse(400, "Memory ID is missing")
+	}
+
+	// Users can only forget their own memories, the key includes their ID
+	_, err = h.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("assistant-memories"),
+		Key: map[string]types.AttributeValue{
+			"userId":   &types.AttributeValueMemberS{Value: claims.Sub},
+			"memoryId": &types.AttributeValueMemberS{Value: memoryId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting memory from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	err = h.embeddings.Remove(context.TODO(), embeddings.UserNamespace(claims.Sub), embeddings.KindMemory, memoryId)
+	if err != nil {
+		log.Printf("Error removing the embedding of memory %s: %v", memoryId, err)
+	}
+
+	log.Printf("User %s deleted memory %s", claims.Sub, memoryId)
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
@@ -0,0 +1,77 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemories(t *testing.T) {
+	// Set up the fake DynamoDB and a model extracting a fact from the first message
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+
+	var prompts []llm.Request
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			if request.Messages[0].Content == "My rent is due on the 5th" {
+				return llm.Response{Content: "```json\n[\"My rent is due on the 5th\"]\n```"}, nil
+			}
+			return llm.Response{Content: "[]"}, nil
+		}
+		prompts = append(prompts, request)
+		return llm.Response{Content: " Noted! "}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	postMessage(t, h, "", "My rent is due on the 5th")
+	postMessage(t, h, "", "When should I pay the rent?")
+
+	// The fact is remembered and injected into the next prompt, with the conversation so far
+	assert.Len(t, prompts, 2)
+	assert.Contains(t, prompts[1].System, "- My rent is due on the 5th")
+	assert.Len(t, prompts[1].Messages, 3)
+	assert.Equal(t, llm.RoleAssistant, prompts[1].Messages[1].Role)
+	assert.Equal(t, "Noted!", prompts[1].Messages[1].Content)
+
+	// The user can list and delete their memories
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/memories").WithClaims("test-user-id", "test-user").Build()
+	response, err := h.GetMemoriesHandler(request)
+	assert.NoError(t, err)
+	var memories []Memory
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &memories))
+	assert.Len(t, memories, 1)
+	assert.Equal(t, DefaultConversation, memories[0].ConversationId)
+
+	request = testutil.NewRequest("DELETE", "").
+		WithClaims("test-user-id", "test-user").
+		WithPathParam("memoryId", memories[0].MemoryId).
+		Build()
+	response, err = h.DeleteMemoryHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	remaining, err := h.listMemories(context.TODO(), "test-user-id")
+	assert.NoError(t, err)
+	assert.Empty(t, remaining)
+}
+
+func TestRankMemories(t *testing.T) {
+	var memories []Memory
+	for i, fact := range []string{"My rent is due on the 5th", "I have a cat named Tom", "My partner is Ana", "I live in Lisbon", "I work remotely", "My rent is 900 EUR"} {
+		memories = append(memories, Memory{MemoryId: string(rune('a' + i)), Fact: fact})
+	}
+
+	relevant := rankMemories(memories, "How much is my rent?")
+	assert.Len(t, relevant, 2)
+	assert.Equal(t, "My rent is due on the 5th", relevant[0].Fact)
+
+	// Few memories are all relevant
+	assert.Len(t, rankMemories(memories[:2], "Hello"), 2)
+}
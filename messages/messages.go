@@ -3,9 +3,16 @@ package messages
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
+	"vassistant-backend/auth"
 	"vassistant-backend/common"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/plans"
+	"vassistant-backend/realtime"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -24,82 +31,192 @@ type Message struct {
 
 // GetMessage struct for the "get messages" response
 type GetMessage struct {
-	Id        string `json:"id" dynamodbav:"id"`
-	UserId    string `json:"userId" dynamodbav:"userId"`
-	Username  string `json:"username" dynamodbav:"username"`
-	Role      string `json:"role" dynamodbav:"role"`
-	Content   string `json:"content" dynamodbav:"content"`
-	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+	Id             string         `json:"id" dynamodbav:"id"`
+	UserId         string         `json:"userId" dynamodbav:"userId"`
+	Username       string         `json:"username" dynamodbav:"username"`
+	Role           string         `json:"role" dynamodbav:"role"`
+	Content        string         `json:"content" dynamodbav:"content"`
+	CreatedAt      string         `json:"createdAt" dynamodbav:"createdAt"`
+	ConversationId string         `json:"conversationId,omitempty" dynamodbav:"conversationId,omitempty"`
+	Model          string         `json:"model,omitempty" dynamodbav:"model,omitempty"` // model of the replies of the assistant
+	ParentId       string         `json:"parentId,omitempty" dynamodbav:"parentId,omitempty"`
+	Command        *CommandResult `json:"command,omitempty" dynamodbav:"command,omitempty"` // result of the commands answered
 }
 
-// IncomingRequest struct to parse the request body
+// IncomingRequest struct to parse the request body. Messages without a conversation go to
+// the default one. A message editing an earlier message of the user starts a new branch
+// of the conversation next to it.
 type IncomingRequest struct {
-	Content string `json:"content"`
+	Content        string `json:"content"`
+	ConversationId string `json:"conversationId"`
+	EditOf         string `json:"editOf"`
 }
 
-var DynamoDbClient common.DynamoDBAPI
+// maxConversationIdLength bounds the IDs of the conversations chosen by the clients.
+const maxConversationIdLength = 64
 
-func PostMessageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+// Handlers serves the messages routes and jobs with the DynamoDB client it was created with,
+// and the financial handlers the commands and the group assistant work with.
+type Handlers struct {
+	client     common.DynamoDBAPI
+	financial  *financial.Handlers
+	plans      *plans.Handlers
+	embeddings *embeddings.Store
+}
+
+// NewHandlers creates the messages handlers reading and writing through the client, the
+// plans of the users and the vectors of the search included.
+func NewHandlers(client common.DynamoDBAPI, financial *financial.Handlers) *Handlers {
+	return &Handlers{client: client, financial: financial, plans: plans.NewHandlers(client), embeddings: embeddings.NewStore(client)}
+}
+
+func (h *Handlers) PostMessageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	// Extract claims from the authorizer
-	authorizer := request.RequestContext.Authorizer
-	claims, ok := authorizer["claims"].(map[string]interface{})
-	if !ok {
-		log.Println("Error: Invalid claims format")
-		return common.CreateErrorResponse(403, "Unauthorized: Invalid claims format")
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
 	}
-	sub, _ := claims["sub"].(string)
-	username, _ := claims["cognito:username"].(string)
+	sub, username := claims.Sub, claims.Username
 
 	// Parse the incoming request body
 	var incomingReq IncomingRequest
-	err := json.Unmarshal([]byte(request.Body), &incomingReq)
+	err = json.Unmarshal([]byte(request.Body), &incomingReq)
 	if err != nil {
 		log.Println("Error unmarshalling request body:", err)
 		return common.CreateErrorResponse(400, "Invalid request body format")
 	}
+	if len(incomingReq.ConversationId) > maxConversationIdLength || isGroupConversation(incomingReq.ConversationId) {
+		return common.CreateErrorResponse(400, "Invalid conversation ID")
+	}
 
-	// Create the new message object
-	newMessage := GetMessage{
-		Id:        uuid.New().String(),
-		UserId:    sub,
-		Username:  username,
-		Role:      "user",
-		Content:   incomingReq.Content,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	return h.postConversationMessage(sub, username, incomingReq, nil)
+}
+
+// postConversationMessage saves the message of the user and the reply of the assistant, in the
+// conversation about the group when one is given.
+func (h *Handlers) postConversationMessage(sub, username string, incomingReq IncomingRequest, group *financial.GroupMember) (events.APIGatewayProxyResponse, error) {
+	// The same message sent again right away is a resubmission, e.g. a double tap: answer
+	// with the saved messages rather than replying, and billing, twice
+	original, originalReply, err := h.findResubmission(context.TODO(), sub, incomingReq, time.Now())
+	if err != nil {
+		log.Printf("Error querying recent messages from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if original != nil && originalReply == nil {
+		return common.CreateErrorResponse(409, "Message is already being answered")
+	}
+	if original != nil {
+		log.Printf("Message %s of user %s was resubmitted", original.Id, sub)
+		responseBody, err := json.Marshal([]GetMessage{*original, *originalReply})
+		if err != nil {
+			log.Printf("Error marshalling response body: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       string(responseBody),
+		}, nil
+	}
+
+	// The messages answered by the language model count towards the plan of the user
+	if !isCommand(incomingReq.Content) {
+		used, err := h.countMonthlyMessages(context.TODO(), sub, time.Now())
+		if err == nil {
+			err = h.plans.Allows(context.TODO(), sub, plans.Messages, used)
+		}
+		var limitErr *plans.LimitError
+		if errors.As(err, &limitErr) {
+			return plans.ErrorResponse(limitErr)
+		}
+		if err != nil {
+			log.Printf("Error checking the message quota: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
 	}
 
-	// Marshal the message into an attribute value map
-	av, err := attributevalue.MarshalMap(newMessage)
+	// The message follows the active branch, or the parent of the message it edits
+	parentId, err := h.parentOfNewMessage(sub, incomingReq)
+	if errors.Is(err, errMessageNotFound) {
+		return common.CreateErrorResponse(404, "Message not found")
+	}
 	if err != nil {
-		log.Printf("Error marshalling message to AttributeValue: %v", err)
+		log.Printf("Error finding the parent of the message: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
-	// Create the PutItem input
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String("chat"),
-		Item:      av,
+	// Create the new message object
+	newMessage := GetMessage{
+		Id:             uuid.New().String(),
+		UserId:         sub,
+		Username:       username,
+		Role:           "user",
+		Content:        incomingReq.Content,
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339Nano),
+		ConversationId: incomingReq.ConversationId,
+		ParentId:       parentId,
 	}
 
-	// Save the message to DynamoDB
-	_, err = DynamoDbClient.PutItem(context.TODO(), putItemInput)
+	// Save the message to DynamoDB, refusing to overwrite an existing one
+	err = common.ConditionalPutItem(context.TODO(), h.client, "chat", newMessage, common.IfNotExists("userId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(409, "Message already exists")
+	}
 	if err != nil {
 		log.Printf("Error saving message to DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Failed to save message")
 	}
 
-	// Save a mock assistant message
-	assistantMessage, err := saveAssistantMessage(sub)
+	// Commands are answered right away, the other messages by the language model, or a
+	// mock reply when there is none
+	var reply llm.Response
+	var commandResult *CommandResult
+	if isCommand(newMessage.Content) {
+		reply.Content, commandResult, err = h.runCommand(context.TODO(), newMessage)
+		if err != nil {
+			log.Printf("Error running command: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	} else {
+		// Over the rate of the user, the message is answered once the limit allows it
+		if ReplyLimiter != nil && ReplyQueue != nil {
+			allowed, retryAfter, err := ReplyLimiter.Allow(context.TODO(), sub)
+			if err != nil {
+				log.Printf("Error checking the reply rate limit: %v", err)
+			}
+			if err == nil && !allowed {
+				position, err := h.queueReply(context.TODO(), newMessage, group, retryAfter, time.Now())
+				if err != nil {
+					log.Printf("Error queueing the reply: %v", err)
+					return common.CreateErrorResponse(500, "Internal server error")
+				}
+				log.Printf("Queued the reply to message %s of user %s at position %d", newMessage.Id, sub, position.Position)
+				realtime.Publish(context.TODO(), realtime.EventMessageCreated, []GetMessage{newMessage}, sub)
+				return queuedResponse(newMessage, position)
+			}
+		}
+		reply, err = h.generateReply(context.TODO(), newMessage, group)
+		if err != nil {
+			log.Printf("Error generating assistant reply: %v", err)
+			return common.CreateErrorResponse(502, "The assistant could not reply")
+		}
+	}
+	assistantMessage, err := h.saveAssistantMessage(sub, incomingReq.ConversationId, reply.Content, reply.Model, newMessage.Id, commandResult)
 	if err != nil {
 		log.Printf("Error saving assistant message to DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Failed to save assistant message")
 	}
 
+	h.finishReply(context.TODO(), newMessage, assistantMessage, commandResult)
+
 	// Create a response that includes both the user's message and the assistant's message
 	responseMessages := []GetMessage{newMessage, assistantMessage}
 
+	// Sync the other devices of the user
+	realtime.Publish(context.TODO(), realtime.EventMessageCreated, responseMessages, sub)
+
 	// Marshal the messages into JSON for the response body
 	responseBody, err := json.Marshal(responseMessages)
 	if err != nil {
@@ -115,28 +232,53 @@ func PostMessageHandler(request events.APIGatewayProxyRequest) (events.APIGatewa
 	}, nil
 }
 
-func saveAssistantMessage(sub string) (GetMessage, error) {
-	assistantMessage := GetMessage{
-		Id:        uuid.New().String(),
-		UserId:    sub,
-		Username:  "ai-assistant",
-		Role:      "assistant",
-		Content:   "This is a mock response from the assistant.",
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+// finishReply follows up on the reply of the assistant to the message, both saved already, so
+// the failures are only logged.
+func (h *Handlers) finishReply(ctx context.Context, message, reply GetMessage, commandResult *CommandResult) {
+	// Keep the summary of the conversation up to date. A failure only leaves the summary
+	// behind until the next message.
+	err := h.updateConversation(ctx, message.UserId, message, reply)
+	if err != nil {
+		log.Printf("Error updating conversation summary: %v", err)
 	}
 
-	av, err := attributevalue.MarshalMap(assistantMessage)
-	if err != nil {
-		log.Printf("Error marshalling assistant message to AttributeValue: %v", err)
-		return GetMessage{}, err
+	// Remember the facts the user shared, for the next conversations
+	if commandResult == nil {
+		err = h.extractMemories(ctx, message)
+		if err != nil {
+			log.Printf("Error extracting memories: %v", err)
+		}
+	}
+
+	// Embed the messages for the semantic search, the commands being of no use to find
+	if commandResult == nil {
+		h.indexMessages(ctx, message, reply)
+	}
+
+	// Move the onboarding on once the reply completed its step
+	if conversationOf(message) == OnboardingConversation {
+		err = h.progressOnboarding(ctx, message.UserId)
+		if err != nil {
+			log.Printf("Error progressing onboarding: %v", err)
+		}
 	}
+}
 
-	putItemInput := &dynamodb.PutItemInput{
-		TableName: aws.String("chat"),
-		Item:      av,
+func (h *Handlers) saveAssistantMessage(sub, conversationId, content, model, parentId string, command *CommandResult) (GetMessage, error) {
+	assistantMessage := GetMessage{
+		Id:             uuid.New().String(),
+		UserId:         sub,
+		Username:       "ai-assistant",
+		Role:           "assistant",
+		Content:        content,
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339Nano),
+		ConversationId: conversationId,
+		Model:          model,
+		ParentId:       parentId,
+		Command:        command,
 	}
 
-	_, err = DynamoDbClient.PutItem(context.TODO(), putItemInput)
+	err := common.ConditionalPutItem(context.TODO(), h.client, "chat", assistantMessage, common.IfNotExists("userId"))
 	if err != nil {
 		log.Printf("Error saving assistant message to DynamoDB: %v", err)
 		return GetMessage{}, err
@@ -145,7 +287,7 @@ func saveAssistantMessage(sub string) (GetMessage, error) {
 }
 
 // queryMessagesByUserID queries the DynamoDB table for messages by userId
-func queryMessagesByUserID(userID string) ([]GetMessage, error) {
+func (h *Handlers) queryMessagesByUserID(userID string, consistentRead *bool) ([]GetMessage, error) {
 	// Build the query input
 	queryInput := &dynamodb.QueryInput{
 		TableName:              aws.String("chat"),
@@ -154,10 +296,11 @@ func queryMessagesByUserID(userID string) ([]GetMessage, error) {
 			":userId": &types.AttributeValueMemberS{Value: userID},
 		},
 		ScanIndexForward: aws.Bool(true), // Sort by createdAt ascending
+		ConsistentRead:   consistentRead,
 	}
 
 	// Make the DynamoDB Query API call
-	result, err := DynamoDbClient.Query(context.TODO(), queryInput)
+	result, err := h.client.Query(context.TODO(), queryInput)
 	if err != nil {
 		return nil, err
 	}
@@ -172,27 +315,88 @@ func queryMessagesByUserID(userID string) ([]GetMessage, error) {
 	return messages, nil
 }
 
-func GetMessageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+// countMonthlyMessages counts the messages the user sent to the assistant since the start of
+// the calendar month, leaving out the commands, following the pages of the query.
+func (h *Handlers) countMonthlyMessages(ctx context.Context, userId string, now time.Time) (int, error) {
+	now = now.UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("chat"),
+		KeyConditionExpression: aws.String("userId = :userId AND createdAt >= :since"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+			":since":  &types.AttributeValueMemberS{Value: monthStart.Format(time.RFC3339)},
+		},
+		ProjectionExpression:     aws.String("#role, content"),
+		ExpressionAttributeNames: map[string]string{"#role": "role"},
+	}
+
+	count := 0
+	for {
+		result, err := h.client.Query(ctx, queryInput)
+		if err != nil {
+			return 0, err
+		}
+		var messages []GetMessage
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &messages)
+		if err != nil {
+			return 0, err
+		}
+		for _, message := range messages {
+			if message.Role == "user" && !isCommand(message.Content) {
+				count++
+			}
+		}
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return count, nil
+		}
+	}
+}
+
+func (h *Handlers) GetMessageHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("request: %+v\n", request)
 
-	// Extract claims from the authorizer
-	authorizer := request.RequestContext.Authorizer
-	claims, ok := authorizer["claims"].(map[string]interface{})
-	if !ok {
-		log.Println("Error: Invalid claims format")
-		return common.CreateErrorResponse(403, "Unauthorized: Invalid claims format")
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
 	}
-	sub, _ := claims["sub"].(string)
-	username, _ := claims["cognito:username"].(string)
+	sub, username := claims.Sub, claims.Username
 	log.Printf("request from user: %s, sub: %s\n", username, sub)
 
 	// Query messages from DynamoDB
-	messages, err := queryMessagesByUserID(sub)
+	messages, err := h.queryMessagesByUserID(sub, common.ConsistentRead(request))
 	if err != nil {
 		log.Printf("Error querying DynamoDB: %v", err)
 		return common.CreateErrorResponse(500, "Internal server error")
 	}
 
+	// Keep the messages of a single conversation, when one is asked for
+	conversationId := request.QueryStringParameters["conversationId"]
+	if conversationId != "" {
+		filtered := []GetMessage{}
+		for _, message := range messages {
+			if conversationOf(message) == conversationId {
+				filtered = append(filtered, message)
+			}
+		}
+		messages = filtered
+	}
+
+	// Keep the messages of the active branch of the conversation, when asked for
+	if conversationId != "" && request.QueryStringParameters["branch"] == "active" {
+		conversation, err := h.getConversation(context.TODO(), sub, conversationId)
+		if err != nil {
+			log.Printf("Error getting conversation from DynamoDB: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if leafId := activeLeaf(conversation, messages); leafId != "" {
+			messages = thread(messages, leafId)
+		}
+	}
+
 	// Marshal the messages into JSON for the payload
 	payload, err := json.Marshal(messages)
 	if err != nil {
@@ -3,54 +3,41 @@ package messages
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
+	"vassistant-backend/plans"
+	"vassistant-backend/testutil"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/stretchr/testify/assert"
-	"vassistant-backend/common"
 )
 
-// MockDynamoDBClient is a mock implementation of the DynamoDBAPI interface
-type MockDynamoDBClient struct {
-	common.DynamoDBAPI
-	PutItemFunc func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
-}
-
-func (m *MockDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
-	return m.PutItemFunc(ctx, params, optFns...)
-}
-
-func (m *MockDynamoDBClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
-	// Not needed for this test
-	return nil, nil
-}
-
 func TestPostMessageHandler(t *testing.T) {
 	// Set up the mock DynamoDB client
-	mockClient := &MockDynamoDBClient{
+	mockClient := &testutil.MockDynamoDBClient{
 		PutItemFunc: func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
 			return &dynamodb.PutItemOutput{}, nil
 		},
+		QueryFunc: func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{}, nil
+		},
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{}, nil
+		},
 	}
-	DynamoDbClient = mockClient
+	h := NewHandlers(mockClient, nil)
 
 	// Create a sample request
-	request := events.APIGatewayProxyRequest{
-		RequestContext: events.APIGatewayProxyRequestContext{
-			Authorizer: map[string]interface{}{
-				"claims": map[string]interface{}{
-					"sub":              "test-user-id",
-					"cognito:username": "test-user",
-				},
-			},
-		},
-		Body: `{"content": "Hello, world!"}`,
-	}
+	request := testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithBody(`{"content": "Hello, world!"}`).
+		Build()
 
 	// Call the handler
-	response, err := PostMessageHandler(request)
+	response, err := h.PostMessageHandler(request)
 	assert.NoError(t, err)
 
 	// Check the response
@@ -75,3 +62,44 @@ func TestPostMessageHandler(t *testing.T) {
 	assert.Equal(t, "assistant", assistantMessage.Role)
 	assert.Equal(t, "This is a mock response from the assistant.", assistantMessage.Content)
 }
+
+func TestPostMessageHandlerPlanLimit(t *testing.T) {
+	// Set up the fake DynamoDB with a user on the free plan who already sent their monthly
+	// messages, and a command
+	now := time.Now().UTC()
+	var chat []map[string]interface{}
+	for i := 0; i < plans.Tiers[plans.Free].MonthlyMessages; i++ {
+		chat = append(chat, map[string]interface{}{
+			"userId": "test-user-id", "id": fmt.Sprintf("message-%d", i), "role": "user", "content": "Hi",
+			"createdAt": now.Add(-time.Duration(i+1) * time.Millisecond).Format(time.RFC3339Nano),
+		})
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"chat":             chat,
+		"vassistant-users": {{"userId": "test-user-id", "username": "test-user"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+	plans.Enforced = true
+	defer func() { plans.Enforced = false }()
+
+	post := func(content string) events.APIGatewayProxyResponse {
+		response, err := h.PostMessageHandler(testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+			WithClaims("test-user-id", "test-user").
+			WithJSONBody(t, IncomingRequest{Content: content}).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The message isn't saved, and the user is told how to lift the limit
+	response := post("One more question")
+	assert.Equal(t, http.StatusPaymentRequired, response.StatusCode)
+	assert.Contains(t, response.Body, `"plan":"PREMIUM"`)
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	assert.Len(t, messages, len(chat))
+
+	// The commands don't go to the language model, so they don't count
+	assert.Equal(t, http.StatusCreated, post("/help").StatusCode)
+}
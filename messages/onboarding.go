@@ -0,0 +1,335 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/financial"
+	"vassistant-backend/i18n"
+	"vassistant-backend/realtime"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// OnboardingConversation is the conversation in which the assistant walks a new user through
+// setting up their first group and expense.
+const OnboardingConversation = "onboarding"
+
+// Steps of the onboarding, in order
+const (
+	// OnboardingGroup waits for the user to be in a group and to set it up.
+	OnboardingGroup = "GROUP"
+	// OnboardingExpense waits for the first expense of the user in the group.
+	OnboardingExpense = "EXPENSE"
+	// OnboardingDone is the end of the onboarding.
+	OnboardingDone = "DONE"
+)
+
+// OnboardingState struct for the assistant-onboarding table, where a user is in their
+// onboarding. The steps are completed by what the user has, not by what they said, so the
+// onboarding resumes where it was left in any later session, and steps done in the app
+// count as well.
+type OnboardingState struct {
+	UserID      string `json:"-" dynamodbav:"userId"`
+	Step        string `json:"step" dynamodbav:"step"`
+	GroupID     string `json:"groupId,omitempty" dynamodbav:"groupId,omitempty"` // the group being set up
+	Language    string `json:"-" dynamodbav:"language,omitempty"`
+	Introduced  string `json:"-" dynamodbav:"introduced,omitempty"` // key of the last message introducing a step
+	StartedAt   string `json:"startedAt" dynamodbav:"startedAt"`
+	UpdatedAt   string `json:"updatedAt" dynamodbav:"updatedAt"`
+	CompletedAt string `json:"completedAt,omitempty" dynamodbav:"completedAt,omitempty"`
+}
+
+// OnboardingProgress is the response of the onboarding endpoints, the state of the
+// onboarding and the message the assistant introduced its step with, if it just did.
+type OnboardingProgress struct {
+	OnboardingState
+	ConversationID string      `json:"conversationId"`
+	Message        *GetMessage `json:"message,omitempty"`
+}
+
+// getOnboarding returns the onboarding of the user, or nil if they didn't start it.
+func (h *Handlers) getOnboarding(ctx context.Context, userId string) (*OnboardingState, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("assistant-onboarding"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var state OnboardingState
+	err = attributevalue.UnmarshalMap(result.Item, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (h *Handlers) saveOnboarding(ctx context.Context, state OnboardingState) error {
+	item, err := attributevalue.MarshalMap(state)
+	if err != nil {
+		return err
+	}
+	_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String("assistant-onboarding"),
+		Item:      item,
+	})
+	return err
+}
+
+// onboardingGroup returns the group the user is setting up: the one chosen before if they
+// are still a member of it, their first group otherwise, or nil if they have none.
+func (h *Handlers) onboardingGroup(ctx context.Context, state OnboardingState) (*financial.GroupMember, error) {
+	groups, err := h.financial.UserGroups(ctx, state.UserID)
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	for _, group := range groups {
+		if group.GroupID == state.GroupID {
+			return &group, nil
+		}
+	}
+	return &groups[0], nil
+}
+
+// advanceOnboarding moves the onboarding past the steps the user completed, reporting
+// whether it changed.
+func (h *Handlers) advanceOnboarding(ctx context.Context, state *OnboardingState, now time.Time) (bool, error) {
+	changed := false
+	for {
+		switch state.Step {
+		case OnboardingGroup:
+			group, err := h.onboardingGroup(ctx, *state)
+			if err != nil || group == nil {
+				return changed, err
+			}
+			if group.GroupID != state.GroupID {
+				state.GroupID = group.GroupID
+				changed = true
+			}
+			setUp, err := h.financial.GroupSetUp(ctx, group.GroupID)
+			if err != nil || !setUp {
+				return changed, err
+			}
+			state.Step = OnboardingExpense
+			changed = true
+		case OnboardingExpense:
+			added, err := h.financial.HasExpenseOf(ctx, state.UserID, state.GroupID)
+			if err != nil || !added {
+				return changed, err
+			}
+			state.Step = OnboardingDone
+			state.CompletedAt = now.UTC().Format(time.RFC3339)
+			changed = true
+		default:
+			return changed, nil
+		}
+	}
+}
+
+// assembleOnboardingPrompt tells the assistant what the current step of the onboarding of
+// the user is and how to help them complete it, nothing once it is done.
+func (h *Handlers) assembleOnboardingPrompt(ctx context.Context, userId string) (string, error) {
+	state, err := h.getOnboarding(ctx, userId)
+	if err != nil || state == nil {
+		return "", err
+	}
+	_, err = h.advanceOnboarding(ctx, state, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	const intro = "\n\nYou are onboarding a new user, one step at a time. "
+	const permissions = " If a tool isn't allowed, tell them to allow the assistant to make changes in the assistant settings."
+	switch state.Step {
+	case OnboardingGroup:
+		group, err := h.onboardingGroup(ctx, *state)
+		if err != nil {
+			return "", err
+		}
+		if group == nil {
+			return intro + "They aren't in any expense group yet: explain that they can create one in the app, " +
+				"or ask a friend for an invite link, and come back to this conversation afterwards.", nil
+		}
+		return intro + fmt.Sprintf("Help them set up their group %q (ID %s): ask whether it is for a household, "+
+			"a trip or a couple, and in which currency they spend, then call set_up_group.", group.GroupName, group.GroupID) + permissions, nil
+	case OnboardingExpense:
+		return intro + fmt.Sprintf("Their group (ID %s) is set up. Help them add their first expense: ask what they "+
+			"paid for and how much, call propose_expense, and tell them to confirm the draft.", state.GroupID) + permissions, nil
+	default:
+		return "", nil
+	}
+}
+
+// introduceOnboardingStep writes the message of the assistant introducing the current step
+// of the onboarding, in the active branch of the onboarding conversation. It writes nothing,
+// returning nil, when the step was introduced already.
+func (h *Handlers) introduceOnboardingStep(ctx context.Context, state *OnboardingState) (*GetMessage, error) {
+	data := map[string]string{}
+	key := "onboarding." + state.Step
+	if state.Step != OnboardingDone {
+		group, err := h.onboardingGroup(ctx, *state)
+		if err != nil {
+			return nil, err
+		}
+		if group == nil {
+			key = "onboarding.NO_GROUP"
+		} else {
+			data["groupName"] = group.GroupName
+		}
+	}
+	if state.Introduced == key {
+		return nil, nil
+	}
+
+	parentId, err := h.parentOfNewMessage(state.UserID, IncomingRequest{ConversationId: OnboardingConversation})
+	if err != nil {
+		return nil, err
+	}
+	message, err := h.saveAssistantMessage(state.UserID, OnboardingConversation, i18n.T(state.Language, key, data), "", parentId, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = h.updateConversation(ctx, state.UserID, message)
+	if err != nil {
+		log.Printf("Error updating conversation summary: %v", err)
+	}
+	realtime.Publish(ctx, realtime.EventMessageCreated, []GetMessage{message}, state.UserID)
+
+	state.Introduced = key
+	return &message, nil
+}
+
+// progressOnboarding advances the onboarding of the user after a message of the onboarding
+// conversation, introducing the next step when the tools the assistant called, or the user
+// in the app, completed one.
+func (h *Handlers) progressOnboarding(ctx context.Context, userId string) error {
+	state, err := h.getOnboarding(ctx, userId)
+	if err != nil || state == nil {
+		return err
+	}
+	now := time.Now()
+	changed, err := h.advanceOnboarding(ctx, state, now)
+	if err != nil {
+		return err
+	}
+	message, err := h.introduceOnboardingStep(ctx, state)
+	if err != nil {
+		return err
+	}
+	if !changed && message == nil {
+		return nil
+	}
+	state.UpdatedAt = now.UTC().Format(time.RFC3339)
+	return h.saveOnboarding(ctx, *state)
+}
+
+// GetOnboardingHandler returns where the user is in their onboarding, counting the steps they
+// completed since it was last saved.
+func (h *Handlers) GetOnboardingHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	state, err := h.getOnboarding(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting onboarding from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if state == nil {
+		return common.CreateErrorResponse(404, "Onboarding not started")
+	}
+	_, err = h.advanceOnboarding(context.TODO(), state, time.Now())
+	if err != nil {
+		log.Printf("Error advancing onboarding: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the progress into JSON for the payload
+	payload, err := json.Marshal(OnboardingProgress{OnboardingState: *state, ConversationID: OnboardingConversation})
+	if err != nil {
+		log.Println("Error marshalling onboarding:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// StartOnboardingHandler starts the onboarding of the user, or resumes it where it was left.
+// The assistant introduces the current step in the onboarding conversation, unless it
+// already did, and the user goes on by posting messages to that conversation.
+func (h *Handlers) StartOnboardingHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	state, err := h.getOnboarding(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting onboarding from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	now := time.Now()
+	if state == nil {
+		state = &OnboardingState{UserID: claims.Sub, Step: OnboardingGroup, StartedAt: now.UTC().Format(time.RFC3339)}
+	}
+	state.Language = i18n.Language(request)
+	_, err = h.advanceOnboarding(context.TODO(), state, now)
+	if err != nil {
+		log.Printf("Error advancing onboarding: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	message, err := h.introduceOnboardingStep(context.TODO(), state)
+	if err != nil {
+		log.Printf("Error introducing onboarding step: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	state.UpdatedAt = now.UTC().Format(time.RFC3339)
+	err = h.saveOnboarding(context.TODO(), *state)
+	if err != nil {
+		log.Printf("Error saving onboarding to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s is at onboarding step %s", claims.Sub, state.Step)
+
+	// Marshal the progress into JSON for the payload
+	payload, err := json.Marshal(OnboardingProgress{OnboardingState: *state, ConversationID: OnboardingConversation, Message: message})
+	if err != nil {
+		log.Println("Error marshalling onboarding:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
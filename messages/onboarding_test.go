@@ -0,0 +1,110 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+	"vassistant-backend/tools"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnboarding(t *testing.T) {
+	// Set up the fake DynamoDB with a user in a group nobody set up, who allowed the
+	// assistant to make changes
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "test-user-id", "groupId": "flat", "groupName": "Flat"},
+			{"userId": "user-2", "groupId": "flat", "groupName": "Flat"},
+		},
+		"assistant-permissions": {{"userId": "test-user-id", "allowWrite": true}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+	tools.DynamoDbClient = fake
+	Tools = tools.NewDispatcher(h.financial.AssistantTools()...)
+	defer func() { Tools = nil }()
+
+	start := func() OnboardingProgress {
+		response, err := h.StartOnboardingHandler(testutil.NewRequest("POST", "/VassistantBackendProxy/assistant/onboarding").
+			WithClaims("test-user-id", "test-user").
+			Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var progress OnboardingProgress
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &progress))
+		return progress
+	}
+	get := func() (int, OnboardingProgress) {
+		response, err := h.GetOnboardingHandler(testutil.NewRequest("GET", "/VassistantBackendProxy/assistant/onboarding").
+			WithClaims("test-user-id", "test-user").
+			Build())
+		assert.NoError(t, err)
+		var progress OnboardingProgress
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &progress))
+		}
+		return response.StatusCode, progress
+	}
+
+	status, _ := get()
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Starting introduces the group of the user, once: resuming doesn't repeat it
+	progress := start()
+	assert.Equal(t, OnboardingGroup, progress.Step)
+	assert.Equal(t, "flat", progress.GroupID)
+	assert.Equal(t, OnboardingConversation, progress.ConversationID)
+	assert.Contains(t, progress.Message.Content, "Flat")
+	assert.Nil(t, start().Message)
+
+	// The assistant is told the step, and sets the group up with the tool
+	var requests []llm.Request
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		if request.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]"}, nil
+		}
+		requests = append(requests, request)
+		if len(requests) == 1 {
+			return llm.Response{ToolCalls: []llm.ToolCall{{
+				ID: "call-1", Name: "set_up_group", Arguments: json.RawMessage(`{"groupId":"flat","template":"HOUSEHOLD","currency":"EUR"}`),
+			}}}, nil
+		}
+		return llm.Response{Content: "Your flat is set up."}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	postMessage(t, h, OnboardingConversation, "It's the flat I share, we pay in euros")
+	assert.Contains(t, requests[0].System, "set_up_group")
+	assert.Contains(t, requests[1].Messages[len(requests[1].Messages)-1].Content, `"defaultCurrency":"EUR"`)
+
+	// The next step is introduced right after the reply
+	messages, err := h.conversationMessages("test-user-id", OnboardingConversation)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "Your flat is set up.", messages[2].Content)
+	assert.Equal(t, messages[2].Id, messages[3].ParentId)
+	assert.Contains(t, messages[3].Content, "first expense")
+	status, progress = get()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, OnboardingExpense, progress.Step)
+
+	// The first expense, even added in the app, completes the onboarding
+	_, err = h.financial.AddExpense(context.TODO(), "test-user-id", "flat", financial.FinancialExpense{
+		Title: "Groceries", Amount: "40", PaidBy: "test-user-id", DateTime: "2024-04-13T10:00:00Z",
+	})
+	assert.NoError(t, err)
+	progress = start()
+	assert.Equal(t, OnboardingDone, progress.Step)
+	assert.NotEmpty(t, progress.CompletedAt)
+	assert.NotNil(t, progress.Message)
+
+	// Once done, the assistant is no longer told about the onboarding
+	requests = nil
+	postMessage(t, h, OnboardingConversation, "Thanks!")
+	assert.NotContains(t, requests[0].System, "onboarding")
+}
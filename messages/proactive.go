@@ -0,0 +1,342 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/notifications"
+	"vassistant-backend/realtime"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dayLayout is the layout of the due dates in the proactive messages.
+const dayLayout = "2006-01-02"
+
+// proactiveNotification is the notification type of the messages the assistant sends on its own.
+const proactiveNotification = "ASSISTANT_MESSAGE"
+
+const proactiveSystemPrompt = "You are Vassistant, the personal assistant of the user, writing to them on your own. " +
+	"You get a JSON summary of their expenses due soon and of the categories they spent unusually much in this week. " +
+	"Write a short, friendly message of 2 to 4 sentences telling them about it. " +
+	"Only use the figures in the summary, never make up numbers, and don't give financial advice."
+
+// ProactiveSettings struct for the assistant-proactive table, whether the user opted in to
+// the messages the assistant sends on its own, nothing being sent until they do.
+type ProactiveSettings struct {
+	UserID     string   `json:"-" dynamodbav:"userId"`
+	Enabled    bool     `json:"enabled" dynamodbav:"enabled"`
+	UpdatedAt  string   `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+	LastSentAt string   `json:"lastSentAt,omitempty" dynamodbav:"lastSentAt,omitempty"`
+	Sent       []string `json:"-" dynamodbav:"sent,omitempty"` // keys of the items of the last message, not to repeat them
+}
+
+// ProactiveResult summarizes a run of the proactive assistant.
+type ProactiveResult struct {
+	Users  int
+	Sent   int
+	Failed int
+}
+
+// getProactiveSettings returns the settings of the user, the opted-out defaults if none are stored.
+func (h *Handlers) getProactiveSettings(ctx context.Context, userId string) (ProactiveSettings, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("assistant-proactive"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return ProactiveSettings{}, err
+	}
+
+	settings := ProactiveSettings{UserID: userId}
+	if result.Item == nil {
+		return settings, nil
+	}
+	err = attributevalue.UnmarshalMap(result.Item, &settings)
+	if err != nil {
+		return ProactiveSettings{}, err
+	}
+	return settings, nil
+}
+
+// listProactiveUsers returns the settings of the users who opted in, following the pages
+// of the scan.
+func (h *Handlers) listProactiveUsers(ctx context.Context) ([]ProactiveSettings, error) {
+	scanInput := &dynamodb.ScanInput{TableName: aws.String("assistant-proactive")}
+
+	var enabled []ProactiveSettings
+	for {
+		result, err := h.client.Scan(ctx, scanInput)
+		if err != nil {
+			return nil, err
+		}
+		var items []ProactiveSettings
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if item.Enabled {
+				enabled = append(enabled, item)
+			}
+		}
+
+		scanInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(scanInput.ExclusiveStartKey) == 0 {
+			return enabled, nil
+		}
+	}
+}
+
+// upcomingKey and unusualKey identify the items of the digests, the same item giving the
+// same key on the next runs.
+func upcomingKey(upcoming financial.UpcomingExpense) string {
+	return fmt.Sprintf("upcoming|%s|%s|%s", upcoming.GroupID, strings.ToLower(upcoming.Title), upcoming.DueAt[:len(dayLayout)])
+}
+
+func unusualKey(unusual financial.UnusualSpending) string {
+	return fmt.Sprintf("unusual|%s|%s|%s", unusual.GroupID, unusual.Category, unusual.Currency)
+}
+
+// digestKeys returns the keys of the items of the digest.
+func digestKeys(digest financial.Digest) []string {
+	var keys []string
+	for _, upcoming := range digest.Upcoming {
+		keys = append(keys, upcomingKey(upcoming))
+	}
+	for _, unusual := range digest.Unusual {
+		keys = append(keys, unusualKey(unusual))
+	}
+	return keys
+}
+
+// newItems keeps the items of the digest the user wasn't told about in the last message.
+func newItems(digest financial.Digest, sent []string) financial.Digest {
+	told := map[string]bool{}
+	for _, key := range sent {
+		told[key] = true
+	}
+
+	fresh := financial.Digest{Upcoming: []financial.UpcomingExpense{}, Unusual: []financial.UnusualSpending{}}
+	for _, upcoming := range digest.Upcoming {
+		if !told[upcomingKey(upcoming)] {
+			fresh.Upcoming = append(fresh.Upcoming, upcoming)
+		}
+	}
+	for _, unusual := range digest.Unusual {
+		if !told[unusualKey(unusual)] {
+			fresh.Unusual = append(fresh.Unusual, unusual)
+		}
+	}
+	return fresh
+}
+
+// describeDigest writes the message of the digest without the language model.
+func describeDigest(digest financial.Digest) string {
+	var lines []string
+	for _, upcoming := range digest.Upcoming {
+		lines = append(lines, fmt.Sprintf("%s in %s, usually %s %s, is due on %s.",
+			upcoming.Title, upcoming.GroupName, upcoming.Amount, upcoming.Currency, upcoming.DueAt[:len(dayLayout)]))
+	}
+	for _, unusual := range digest.Unusual {
+		category := strings.ToLower(unusual.Category)
+		if category == "" {
+			category = "uncategorized"
+		}
+		lines = append(lines, fmt.Sprintf("You spent %s %s on %s in %s this week, %s %s in a usual week.",
+			unusual.Amount, unusual.Currency, category, unusual.GroupName, unusual.Usual, unusual.Currency))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// composeProactiveMessage writes the message of the digest with the language model, or
+// without it when there is none or it fails.
+func composeProactiveMessage(ctx context.Context, userId string, digest financial.Digest) llm.Response {
+	summary, err := json.Marshal(digest)
+	if err != nil {
+		return llm.Response{Content: describeDigest(digest)}
+	}
+	response, err := llm.Complete(ctx, llm.Request{
+		System:    proactiveSystemPrompt,
+		Messages:  []llm.Message{{Role: llm.RoleUser, Content: string(summary)}},
+		MaxTokens: maxReplyTokens,
+		User:      userId,
+	})
+	if err != nil || strings.TrimSpace(response.Content) == "" {
+		if !errors.Is(err, llm.ErrNotConfigured) {
+			log.Printf("Error composing the proactive message, using the plain one: %v", err)
+		}
+		return llm.Response{Content: describeDigest(digest)}
+	}
+	return response
+}
+
+// sendProactiveMessage tells the user about the items of their digest they weren't told
+// about yet, in the active branch of the default conversation, and notifies them. It
+// reports whether a message was sent.
+func (h *Handlers) sendProactiveMessage(ctx context.Context, settings ProactiveSettings, now time.Time) (bool, error) {
+	digest, err := h.financial.AssistantDigest(ctx, settings.UserID, now)
+	if err != nil {
+		return false, err
+	}
+	fresh := newItems(digest, settings.Sent)
+	if fresh.Empty() {
+		return false, nil
+	}
+
+	parentId, err := h.parentOfNewMessage(settings.UserID, IncomingRequest{})
+	if err != nil {
+		return false, err
+	}
+	reply := composeProactiveMessage(ctx, settings.UserID, fresh)
+	message, err := h.saveAssistantMessage(settings.UserID, "", reply.Content, reply.Model, parentId, nil)
+	if err != nil {
+		return false, err
+	}
+	err = h.updateConversation(ctx, settings.UserID, message)
+	if err != nil {
+		log.Printf("Error updating conversation summary: %v", err)
+	}
+	realtime.Publish(ctx, realtime.EventMessageCreated, []GetMessage{message}, settings.UserID)
+
+	err = notifications.Notify(ctx, settings.UserID, proactiveNotification, map[string]string{
+		"conversationId": DefaultConversation,
+		"messageId":      message.Id,
+	})
+	if err != nil {
+		log.Printf("Error notifying user %s of the proactive message: %v", settings.UserID, err)
+	}
+
+	// Remember what the user was told, unless they opted out in the meantime
+	settings.Sent = digestKeys(digest)
+	settings.LastSentAt = now.UTC().Format(time.RFC3339)
+	err = common.ConditionalPutItem(ctx, h.client, "assistant-proactive", settings, common.IfExists("userId"))
+	if err != nil && !errors.Is(err, common.ErrConditionFailed) {
+		return true, err
+	}
+	return true, nil
+}
+
+// SendProactiveMessages sends their proactive message to every user who opted in and has
+// something new to be told. A user failing is logged and counted without stopping the run.
+func (h *Handlers) SendProactiveMessages(ctx context.Context, now time.Time) (ProactiveResult, error) {
+	var result ProactiveResult
+	users, err := h.listProactiveUsers(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for _, settings := range users {
+		result.Users++
+		sent, err := h.sendProactiveMessage(ctx, settings, now)
+		if err != nil {
+			log.Printf("Error sending the proactive message of user %s: %v", settings.UserID, err)
+			result.Failed++
+			continue
+		}
+		if sent {
+			result.Sent++
+		}
+	}
+	return result, nil
+}
+
+func (h *Handlers) GetProactiveSettingsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	settings, err := h.getProactiveSettings(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting proactive settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the settings into JSON for the payload
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		log.Println("Error marshalling proactive settings:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) PutProactiveSettingsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Parse the request body into a ProactiveSettings struct
+	var update ProactiveSettings
+	err = json.Unmarshal([]byte(request.Body), &update)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	// Keep what the user was already told, so opting in again doesn't repeat it
+	settings, err := h.getProactiveSettings(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting proactive settings from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	settings.Enabled = update.Enabled
+	settings.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	item, err := attributevalue.MarshalMap(settings)
+	if err != nil {
+		log.Printf("Error marshalling proactive settings: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	_, err = h.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("assistant-proactive"),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("Error saving proactive settings to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s set proactive messages to %t", claims.Sub, settings.Enabled)
+
+	// Marshal the settings into JSON for the payload
+	payload, err := json.Marshal(settings)
+	if err != nil {
+		log.Println("Error marshalling proactive settings:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,120 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/financial"
+	"vassistant-backend/llm"
+	"vassistant-backend/notifications"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendProactiveMessages(t *testing.T) {
+	// Set up the fake DynamoDB with a monthly rent, due in two days, of two users of which
+	// only one opted in
+	now := time.Date(2024, 4, 13, 9, 0, 0, 0, time.UTC)
+	rent := func(dateTime string) map[string]interface{} {
+		return map[string]interface{}{
+			"groupId": "house", "expenseId": dateTime, "title": "Rent", "amount": "1000", "paidBy": "user-1", "dateTime": dateTime,
+			"participants": []map[string]interface{}{{"userId": "user-1", "calculatedMoney": "500"}, {"userId": "user-2", "calculatedMoney": "500"}},
+		}
+	}
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"assistant-proactive": {{"userId": "user-1", "enabled": true}, {"userId": "user-2", "enabled": false}},
+		"splitter-group-members": {
+			{"userId": "user-1", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+		"splitter-group-settings": {{"groupId": "house", "defaultCurrency": "EUR"}},
+		"splitter-expenses":       {rent("2024-02-15T10:00:00Z"), rent("2024-03-15T10:00:00Z")},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+	notifications.DynamoDbClient = fake
+
+	var summary financial.Digest
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		assert.Equal(t, proactiveSystemPrompt, request.System)
+		assert.Equal(t, "user-1", request.User)
+		assert.NoError(t, json.Unmarshal([]byte(request.Messages[0].Content), &summary))
+		return llm.Response{Content: "Your rent in House is due on Monday.", Model: "test-model"}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	result, err := h.SendProactiveMessages(context.TODO(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, ProactiveResult{Users: 1, Sent: 1}, result)
+	assert.Equal(t, "2024-04-15T10:00:00Z", summary.Upcoming[0].DueAt)
+
+	// The message is in the conversation of the user, unread, and they are notified of it
+	messages, err := h.queryMessagesByUserID("user-1", nil)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "assistant", messages[0].Role)
+	assert.Equal(t, "Your rent in House is due on Monday.", messages[0].Content)
+	assert.Equal(t, "test-model", messages[0].Model)
+	conversation, err := h.getConversation(context.TODO(), "user-1", DefaultConversation)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, conversation.UnreadCount)
+
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/notifications").WithClaims("user-1", "alice").Build()
+	response, err := notifications.GetNotificationsHandler(request)
+	assert.NoError(t, err)
+	var received []notifications.Notification
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &received))
+	assert.Len(t, received, 1)
+	assert.Equal(t, proactiveNotification, received[0].Type)
+	assert.Equal(t, messages[0].Id, received[0].Data["messageId"])
+
+	// The next run has nothing new to tell
+	result, err = h.SendProactiveMessages(context.TODO(), now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, ProactiveResult{Users: 1}, result)
+	messages, err = h.queryMessagesByUserID("user-1", nil)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+}
+
+func TestDescribeDigest(t *testing.T) {
+	content := describeDigest(financial.Digest{
+		Upcoming: []financial.UpcomingExpense{{GroupName: "House", Title: "Rent", Amount: "1000", Currency: "EUR", DueAt: "2024-04-15T10:00:00Z"}},
+		Unusual:  []financial.UnusualSpending{{GroupName: "House", Category: "FOOD", Currency: "EUR", Amount: "25.00", Usual: "10.00"}},
+	})
+	assert.Equal(t, "Rent in House, usually 1000 EUR, is due on 2024-04-15.\n"+
+		"You spent 25.00 EUR on food in House this week, 10.00 EUR in a usual week.", content)
+}
+
+func TestProactiveSettingsHandlers(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"assistant-proactive": {{"userId": "test-user-id", "enabled": false, "sent": []string{"upcoming|house|rent|2024-04-15"}}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+
+	// Opting in keeps what the user was already told
+	request := testutil.NewRequest("PUT", "/VassistantBackendProxy/assistant/proactive").
+		WithClaims("test-user-id", "test-user").
+		WithJSONBody(t, map[string]bool{"enabled": true}).
+		Build()
+	response, err := h.PutProactiveSettingsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	settings, err := h.getProactiveSettings(context.TODO(), "test-user-id")
+	assert.NoError(t, err)
+	assert.True(t, settings.Enabled)
+	assert.Equal(t, []string{"upcoming|house|rent|2024-04-15"}, settings.Sent)
+
+	request = testutil.NewRequest("GET", "/VassistantBackendProxy/assistant/proactive").WithClaims("test-user-id", "test-user").Build()
+	response, err = h.GetProactiveSettingsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var got ProactiveSettings
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &got))
+	assert.True(t, got.Enabled)
+}
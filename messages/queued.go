@@ -0,0 +1,238 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/financial"
+	"vassistant-backend/queue"
+	"vassistant-backend/ratelimit"
+	"vassistant-backend/realtime"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// queuedRepliesTable holds the messages waiting in the queue for a reply, keyed by userId and
+// messageId, so the position of a new one can be estimated.
+const queuedRepliesTable = "assistant-reply-queue"
+
+// ReplyLimiter limits how often the language model replies to each user. The messages over
+// the limit are queued in ReplyQueue and answered asynchronously, rather than rejected. The
+// replies aren't limited when either is nil.
+var ReplyLimiter ratelimit.Limiter
+
+// ReplyQueue queues the messages over the ReplyLimiter for the replies worker.
+var ReplyQueue queue.Queue
+
+// ReplySpacing is the time between the queued replies of a user, the period of the limiter
+// over its limit, so the queued messages don't come back all at once.
+var ReplySpacing = 10 * time.Second
+
+// errQueuedMessageNotFound is returned when the queued message no longer exists, e.g. after
+// the user deleted their data.
+var errQueuedMessageNotFound = errors.New("queued message not found")
+
+// ReplyJob is the job of the replies worker, the message of the user to reply to.
+type ReplyJob struct {
+	UserID    string `json:"userId"`
+	MessageID string `json:"messageId"`
+	CreatedAt string `json:"createdAt"` // the sort key of the message
+	GroupID   string `json:"groupId,omitempty"`
+}
+
+// QueuedReply struct for the assistant-reply-queue table
+type QueuedReply struct {
+	UserID    string `dynamodbav:"userId"`
+	MessageID string `dynamodbav:"messageId"`
+	QueuedAt  string `dynamodbav:"queuedAt"`
+	ExpiresAt int64  `dynamodbav:"expiresAt"` // Unix seconds, the DynamoDB TTL attribute, for the jobs lost
+}
+
+// QueuePosition estimates when a queued message is answered.
+type QueuePosition struct {
+	Position             int `json:"position"`
+	EstimatedWaitSeconds int `json:"estimatedWaitSeconds"`
+}
+
+// QueuedMessage is the response to a message queued for a reply, delivered later like the
+// messages of the other devices and listed with the messages once saved.
+type QueuedMessage struct {
+	Message GetMessage    `json:"message"`
+	Queue   QueuePosition `json:"queue"`
+}
+
+// queuedReplies returns how many messages of the user wait for a reply.
+func (h *Handlers) queuedReplies(ctx context.Context, userId string) (int, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(queuedRepliesTable),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(result.Items), nil
+}
+
+// queueReply queues the message for a reply once the limit of the user allows it, after the
+// replies queued before it.
+func (h *Handlers) queueReply(ctx context.Context, message GetMessage, group *financial.GroupMember, retryAfter time.Duration, now time.Time) (QueuePosition, error) {
+	ahead, err := h.queuedReplies(ctx, message.UserId)
+	if err != nil {
+		return QueuePosition{}, err
+	}
+	delay := min(retryAfter+time.Duration(ahead)*ReplySpacing, queue.MaxDelay)
+
+	job := ReplyJob{UserID: message.UserId, MessageID: message.Id, CreatedAt: message.CreatedAt}
+	if group != nil {
+		job.GroupID = group.GroupID
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		return QueuePosition{}, err
+	}
+	err = common.ConditionalPutItem(ctx, h.client, queuedRepliesTable, QueuedReply{
+		UserID:    message.UserId,
+		MessageID: message.Id,
+		QueuedAt:  now.UTC().Format(time.RFC3339Nano),
+		ExpiresAt: now.Add(2 * queue.MaxDelay).Unix(),
+	}, common.IfNotExists("userId"))
+	if err != nil {
+		return QueuePosition{}, err
+	}
+	if err := ReplyQueue.Send(ctx, body, delay); err != nil {
+		return QueuePosition{}, err
+	}
+	return QueuePosition{Position: ahead + 1, EstimatedWaitSeconds: int(delay.Round(time.Second) / time.Second)}, nil
+}
+
+// queuedResponse answers the message queued for a reply with 202 and its position.
+func queuedResponse(message GetMessage, position QueuePosition) (events.APIGatewayProxyResponse, error) {
+	responseBody, err := json.Marshal(QueuedMessage{Message: message, Queue: position})
+	if err != nil {
+		log.Printf("Error marshalling response body: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 202,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(responseBody),
+	}, nil
+}
+
+// DeliverQueuedReply replies to the queued message of the job, saves the reply and sends it
+// to the devices of the user. The jobs of the messages answered or deleted since are skipped,
+// so a job delivered twice replies once.
+func (h *Handlers) DeliverQueuedReply(ctx context.Context, job ReplyJob) error {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(queuedRepliesTable),
+		Key: map[string]types.AttributeValue{
+			"userId":    &types.AttributeValueMemberS{Value: job.UserID},
+			"messageId": &types.AttributeValueMemberS{Value: job.MessageID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	if result.Item == nil {
+		log.Printf("Skipping the reply to message %s of user %s, no longer queued", job.MessageID, job.UserID)
+		return nil
+	}
+
+	message, err := h.getMessage(ctx, job.UserID, job.CreatedAt)
+	if err != nil {
+		return err
+	}
+	var group *financial.GroupMember
+	if message != nil && job.GroupID != "" {
+		group, err = h.financial.UserGroup(ctx, job.UserID, job.GroupID)
+		if err != nil {
+			return err
+		}
+	}
+	if message == nil || message.Id != job.MessageID || (job.GroupID != "" && group == nil) {
+		log.Printf("Dropping the reply to message %s of user %s: %v", job.MessageID, job.UserID, errQueuedMessageNotFound)
+		return h.dequeueReply(ctx, job)
+	}
+
+	reply, err := h.generateReply(ctx, *message, group)
+	if err != nil {
+		return fmt.Errorf("generating the reply: %w", err)
+	}
+	assistantMessage, err := h.saveAssistantMessage(job.UserID, message.ConversationId, reply.Content, reply.Model, message.Id, nil)
+	if err != nil {
+		return err
+	}
+	if err := h.dequeueReply(ctx, job); err != nil {
+		log.Printf("Error removing message %s from the reply queue: %v", job.MessageID, err)
+	}
+	h.finishReply(ctx, *message, assistantMessage, nil)
+
+	// The reply reaches the devices like the messages sent from another device
+	realtime.Publish(ctx, realtime.EventMessageCreated, []GetMessage{assistantMessage}, job.UserID)
+	return nil
+}
+
+func (h *Handlers) dequeueReply(ctx context.Context, job ReplyJob) error {
+	_, err := h.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(queuedRepliesTable),
+		Key: map[string]types.AttributeValue{
+			"userId":    &types.AttributeValueMemberS{Value: job.UserID},
+			"messageId": &types.AttributeValueMemberS{Value: job.MessageID},
+		},
+	})
+	return err
+}
+
+// getMessage returns the message of the user saved at createdAt, or nil if there is none.
+func (h *Handlers) getMessage(ctx context.Context, userId, createdAt string) (*GetMessage, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("chat"),
+		Key: map[string]types.AttributeValue{
+			"userId":    &types.AttributeValueMemberS{Value: userId},
+			"createdAt": &types.AttributeValueMemberS{Value: createdAt},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var message GetMessage
+	if err := attributevalue.UnmarshalMap(result.Item, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ReplyQueueHandler is the handler of the replies worker, consuming the jobs of ReplyQueue.
+// The jobs that fail are reported so SQS delivers them again, and the others are deleted.
+func (h *Handlers) ReplyQueueHandler(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	var response events.SQSEventResponse
+	for _, record := range event.Records {
+		var job ReplyJob
+		if err := json.Unmarshal([]byte(record.Body), &job); err != nil {
+			log.Printf("Dropping malformed reply job %s: %v", record.MessageId, err)
+			continue
+		}
+		if err := h.DeliverQueuedReply(ctx, job); err != nil {
+			log.Printf("Error replying to message %s of user %s: %v", job.MessageID, job.UserID, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{ItemIdentifier: record.MessageId})
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,92 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/ratelimit"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// sentJob is a job sent to the recordingQueue.
+type sentJob struct {
+	body  string
+	delay time.Duration
+}
+
+// recordingQueue records the jobs sent instead of queueing them.
+type recordingQueue struct {
+	jobs []sentJob
+}
+
+func (q *recordingQueue) Send(ctx context.Context, body []byte, delay time.Duration) error {
+	q.jobs = append(q.jobs, sentJob{body: string(body), delay: delay})
+	return nil
+}
+
+func TestQueuedReplies(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+	jobs := &recordingQueue{}
+	ReplyLimiter, ReplyQueue = ratelimit.NewMemoryLimiter(1, time.Minute), jobs
+	defer func() { ReplyLimiter, ReplyQueue = nil, nil }()
+
+	post := func(content string) events.APIGatewayProxyResponse {
+		response, err := h.PostMessageHandler(testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+			WithClaims("test-user-id", "test-user").
+			WithJSONBody(t, map[string]string{"content": content}).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The first message is answered right away, the next ones over the rate are queued
+	// behind each other
+	assert.Equal(t, http.StatusCreated, post("What's on today?").StatusCode)
+	var queued []QueuedMessage
+	for _, content := range []string{"And tomorrow?", "And on Friday?"} {
+		response := post(content)
+		assert.Equal(t, http.StatusAccepted, response.StatusCode)
+		var message QueuedMessage
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &message))
+		assert.Equal(t, content, message.Message.Content)
+		queued = append(queued, message)
+	}
+	assert.Equal(t, 1, queued[0].Queue.Position)
+	assert.Equal(t, 2, queued[1].Queue.Position)
+	assert.Equal(t, int(ReplySpacing/time.Second), queued[1].Queue.EstimatedWaitSeconds-queued[0].Queue.EstimatedWaitSeconds)
+	assert.Len(t, jobs.jobs, 2)
+	assert.Equal(t, time.Duration(queued[1].Queue.EstimatedWaitSeconds)*time.Second, jobs.jobs[1].delay.Round(time.Second))
+
+	// Commands aren't limited
+	assert.Equal(t, http.StatusCreated, post("/help").StatusCode)
+
+	// The worker replies to the queued messages, once even when a job is delivered twice
+	var event events.SQSEvent
+	for i, job := range append(jobs.jobs, jobs.jobs[0]) {
+		event.Records = append(event.Records, events.SQSMessage{MessageId: string(rune('a' + i)), Body: job.body})
+	}
+	response, err := h.ReplyQueueHandler(context.TODO(), event)
+	assert.NoError(t, err)
+	assert.Empty(t, response.BatchItemFailures)
+
+	messages, err := h.queryMessagesByUserID("test-user-id", nil)
+	assert.NoError(t, err)
+	replies := map[string]int{}
+	for _, message := range messages {
+		if message.Role == "assistant" {
+			replies[message.ParentId]++
+		}
+	}
+	assert.Equal(t, 1, replies[queued[0].Message.Id])
+	assert.Equal(t, 1, replies[queued[1].Message.Id])
+	waiting, err := h.queuedReplies(context.TODO(), "test-user-id")
+	assert.NoError(t, err)
+	assert.Zero(t, waiting)
+}
@@ -0,0 +1,127 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// QuickExpenseRequest is the JSON body of the quick expense endpoint. The body may also be
+// the terse text of the /add command instead, e.g. "12,50 EUR Coffee @House".
+type QuickExpenseRequest struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency"`
+	Title    string      `json:"title"`
+	Group    string      `json:"group"`
+}
+
+// QuickResponse is the minimal JSON response of the quick endpoints, for the automations
+// asking for JSON rather than text.
+type QuickResponse struct {
+	Text  string          `json:"text,omitempty"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// args returns the arguments of the /add command for the request.
+func (r QuickExpenseRequest) args() string {
+	fields := []string{r.Amount.String(), strings.TrimSpace(r.Currency), strings.ReplaceAll(r.Title, "@", "")}
+	if group := strings.TrimSpace(r.Group); group != "" {
+		fields = append(fields, "@"+group)
+	}
+	return strings.Join(strings.Fields(strings.Join(fields, " ")), " ")
+}
+
+// wantsJSON reports whether the quick response is sent as JSON rather than plain text,
+// asked for by the Accept header or the format query parameter.
+func wantsJSON(request events.APIGatewayProxyRequest) bool {
+	if format := request.QueryStringParameters["format"]; format != "" {
+		return format == "json"
+	}
+	return strings.Contains(common.Header(request, "Accept"), "application/json")
+}
+
+// quickResponse answers a quick endpoint with the result of the command, as plain text or
+// minimal JSON. The commands failing on the input of the user are answered with 400.
+func quickResponse(request events.APIGatewayProxyRequest, statusCode int, text string, result *CommandResult) (events.APIGatewayProxyResponse, error) {
+	response := QuickResponse{Text: text, Data: result.Data}
+	if result.Error != "" {
+		statusCode, response = 400, QuickResponse{Error: result.Error}
+	}
+
+	if !wantsJSON(request) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: statusCode,
+			Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			Body:       text + "\n",
+		}, nil
+	}
+
+	// Marshal the response into JSON for the payload
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshalling quick response:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// PostQuickExpenseHandler adds an expense paid by the user from a terse payload, like the
+// /add command. It is meant for iOS Shortcuts and Tasker, authenticated by an API key.
+func (h *Handlers) PostQuickExpenseHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// The body is either JSON or the arguments of the /add command
+	args := strings.TrimSpace(request.Body)
+	if strings.HasPrefix(args, "{") {
+		var expenseRequest QuickExpenseRequest
+		err = json.Unmarshal([]byte(args), &expenseRequest)
+		if err != nil {
+			log.Printf("Error unmarshalling request body: %v", err)
+			return common.CreateErrorResponse(400, "Invalid request body")
+		}
+		args = expenseRequest.args()
+	}
+
+	text, result, err := h.runUserCommand(context.TODO(), claims.Sub, "add", args)
+	if err != nil {
+		log.Printf("Error adding quick expense: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return quickResponse(request, 201, text, result)
+}
+
+// GetQuickBalanceHandler returns the balance of the user in the group named by the group
+// query parameter, the only group of the user when omitted, like the /balance command.
+func (h *Handlers) GetQuickBalanceHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	group := strings.TrimSpace(request.QueryStringParameters["group"])
+	text, result, err := h.runUserCommand(context.TODO(), claims.Sub, "balance", group)
+	if err != nil {
+		log.Printf("Error getting quick balance: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return quickResponse(request, 200, text, result)
+}
@@ -0,0 +1,70 @@
+package messages
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/financial"
+	"vassistant-backend/fx"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuickEndpoints(t *testing.T) {
+	// Set up the fake DynamoDB with a group of two housemates
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "test-user-id", "groupId": "house", "groupName": "House"},
+			{"userId": "user-2", "groupId": "house", "groupName": "House"},
+		},
+		"splitter-group-settings": {{"groupId": "house", "defaultCurrency": "EUR"}},
+		"vassistant-users":        {{"userId": "user-2", "username": "bob", "showableName": "Bob"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+	fx.DynamoDbClient = fake
+
+	// The terse text of /add is answered with plain text
+	response, err := h.PostQuickExpenseHandler(testutil.NewRequest("POST", "/quick/expense").
+		WithClaims("test-user-id", "test-user").
+		WithBody("20 pizza @house").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", response.Headers["Content-Type"])
+	assert.Equal(t, "Added pizza, 20 EUR, to House.\n", response.Body)
+
+	// A JSON body asking for JSON gets the expense too
+	response, err = h.PostQuickExpenseHandler(testutil.NewRequest("POST", "/quick/expense").
+		WithClaims("test-user-id", "test-user").
+		WithHeader("Accept", "application/json").
+		WithJSONBody(t, QuickExpenseRequest{Amount: "10.50", Currency: "USD", Title: "Taxi @airport"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var quick QuickResponse
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &quick))
+	assert.Equal(t, "Added Taxi airport, 10.50 USD, to House.", quick.Text)
+	var expense financial.FinancialExpense
+	assert.NoError(t, json.Unmarshal(quick.Data, &expense))
+	assert.Equal(t, "house", expense.GroupID)
+
+	// The mistakes of the user are answered with 400
+	response, err = h.PostQuickExpenseHandler(testutil.NewRequest("POST", "/quick/expense").
+		WithClaims("test-user-id", "test-user").
+		WithQueryParam("format", "json").
+		WithBody("pizza").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	assert.JSONEq(t, `{"error":"Usage: /add <amount> [currency] <title> [@group]"}`, response.Body)
+
+	// The balance of the only group of the user
+	response, err = h.GetQuickBalanceHandler(testutil.NewRequest("GET", "/quick/balance").
+		WithClaims("test-user-id", "test-user").
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, response.Body, "House:\nBob owes you 10")
+}
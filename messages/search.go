@@ -0,0 +1,158 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/financial"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// defaultSearchLimit and maxSearchLimit bound how many results a search returns.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// minSearchScore is the similarity to the query below which an item isn't a result.
+const minSearchScore = 0.2
+
+// maxSearchQueryLength bounds the length of a search query, in characters.
+const maxSearchQueryLength = 500
+
+// SearchResult is an item found by the semantic search, a message of the user or an expense
+// of their groups, with how close it is to the query.
+type SearchResult struct {
+	Kind    string                      `json:"kind"`
+	Score   float64                     `json:"score"`
+	Message *GetMessage                 `json:"message,omitempty"`
+	Expense *financial.FinancialExpense `json:"expense,omitempty"`
+}
+
+// indexMessages embeds the message of the user and the reply of the assistant, so they can
+// be found by meaning. The failures are only logged, the messages being saved already.
+func (h *Handlers) indexMessages(ctx context.Context, messages ...GetMessage) {
+	for _, message := range messages {
+		if strings.TrimSpace(message.Content) == "" {
+			continue
+		}
+		item := embeddings.Item{Kind: embeddings.KindMessage, ID: message.Id, Ref: message.CreatedAt}
+		err := h.embeddings.Index(ctx, embeddings.UserNamespace(message.UserId), item, message.Content)
+		if err != nil {
+			log.Printf("Error embedding message %s: %v", message.Id, err)
+		}
+	}
+}
+
+// search returns the messages of the user and the expenses of their groups closest in
+// meaning to the query, leaving out those deleted since they were embedded.
+func (h *Handlers) search(ctx context.Context, userId, query string, kinds []string, limit int) ([]SearchResult, error) {
+	namespaces := []string{embeddings.UserNamespace(userId)}
+	groupOf := map[string]string{}
+	if h.financial != nil {
+		groups, err := h.financial.UserGroups(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range groups {
+			namespace := embeddings.GroupNamespace(group.GroupID)
+			namespaces = append(namespaces, namespace)
+			groupOf[namespace] = group.GroupID
+		}
+	}
+
+	matches, err := h.embeddings.Search(ctx, namespaces, kinds, query, limit, minSearchScore)
+	if err != nil {
+		return nil, err
+	}
+	results := []SearchResult{}
+	for _, match := range matches {
+		result := SearchResult{Kind: match.Kind, Score: match.Score}
+		switch match.Kind {
+		case embeddings.KindMessage:
+			message, err := h.getMessage(ctx, userId, match.Ref)
+			if err != nil {
+				return nil, err
+			}
+			if message == nil || message.Id != match.ID {
+				continue
+			}
+			result.Message = message
+		case embeddings.KindExpense:
+			groupId, ok := groupOf[match.Namespace]
+			if !ok {
+				continue
+			}
+			expense, err := h.financial.Expense(ctx, groupId, match.ID)
+			if err != nil {
+				return nil, err
+			}
+			if expense == nil {
+				continue
+			}
+			result.Expense = expense
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// SearchHandler searches the messages of the user and the expenses of their groups by
+// meaning, with the query in the q parameter. The kind parameter restricts the results to the
+// messages or the expenses.
+func (h *Handlers) SearchHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	if !h.embeddings.Enabled() {
+		return common.CreateErrorResponse(503, "Search is not available")
+	}
+
+	query := strings.TrimSpace(request.QueryStringParameters["q"])
+	if query == "" || len([]rune(query)) > maxSearchQueryLength {
+		return common.CreateErrorResponse(400, "Invalid search query")
+	}
+	kinds := []string{embeddings.KindMessage, embeddings.KindExpense}
+	if kind := request.QueryStringParameters["kind"]; kind != "" {
+		if kind != embeddings.KindMessage && kind != embeddings.KindExpense {
+			return common.CreateErrorResponse(400, "Invalid kind, expected message or expense")
+		}
+		kinds = []string{kind}
+	}
+	limit := defaultSearchLimit
+	if value := request.QueryStringParameters["limit"]; value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return common.CreateErrorResponse(400, "Invalid limit")
+		}
+	}
+
+	results, err := h.search(context.TODO(), claims.Sub, query, kinds, limit)
+	if err != nil {
+		log.Printf("Error searching: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	payload, err := json.Marshal(results)
+	if err != nil {
+		log.Println("Error marshalling search results:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
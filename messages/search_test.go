@@ -0,0 +1,76 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/financial"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"splitter-group-members": {
+			{"userId": "test-user-id", "groupId": "house", "groupName": "House"},
+		},
+		"splitter-expenses": {
+			{"groupId": "house", "expenseId": "rent", "title": "Rent of the flat", "amount": "900", "currency": "EUR", "paidBy": "test-user-id"},
+			{"groupId": "elsewhere", "expenseId": "rent", "title": "Rent of the cabin", "amount": "300", "currency": "EUR", "paidBy": "user-2"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake, financial.NewHandlers(fake))
+
+	search := func(query map[string]string) events.APIGatewayProxyResponse {
+		request := testutil.NewRequest("GET", "/VassistantBackendProxy/search").WithClaims("test-user-id", "test-user")
+		for name, value := range query {
+			request = request.WithQueryParam(name, value)
+		}
+		response, err := h.SearchHandler(request.Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Without embeddings there is nothing to search
+	assert.Equal(t, http.StatusServiceUnavailable, search(map[string]string{"q": "rent"}).StatusCode)
+
+	embeddings.DefaultEmbedder = embeddings.Stub{}
+	defer func() { embeddings.DefaultEmbedder = nil }()
+
+	// The messages are embedded once answered, the expense titles by the streams Lambda
+	response, err := h.PostMessageHandler(testutil.NewRequest("POST", "/VassistantBackendProxy/messages").
+		WithClaims("test-user-id", "test-user").
+		WithJSONBody(t, map[string]string{"content": "Remind me to pay the rent of the flat"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	for _, groupId := range []string{"house", "elsewhere"} {
+		err = h.embeddings.Index(context.TODO(), embeddings.GroupNamespace(groupId), embeddings.Item{Kind: embeddings.KindExpense, ID: "rent"}, "Rent of the flat")
+		assert.NoError(t, err)
+	}
+
+	// Only the messages of the user and the expenses of their groups are found
+	response = search(map[string]string{"q": "flat rent"})
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var results []SearchResult
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &results))
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, embeddings.KindExpense, results[0].Kind)
+		assert.Equal(t, "house", results[0].Expense.GroupID)
+		assert.Equal(t, embeddings.KindMessage, results[1].Kind)
+		assert.Equal(t, "Remind me to pay the rent of the flat", results[1].Message.Content)
+	}
+
+	response = search(map[string]string{"q": "flat rent", "kind": "message"})
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &results))
+	assert.Len(t, results, 1)
+
+	assert.Equal(t, http.StatusBadRequest, search(map[string]string{"q": " "}).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, search(map[string]string{"q": "rent", "kind": "memory"}).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, search(map[string]string{"q": "rent", "limit": "500"}).StatusCode)
+}
@@ -0,0 +1,184 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/llm"
+	"vassistant-backend/tools"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// maxConversationTokens bounds the length of the replies a conversation can ask for.
+const maxConversationTokens = 4000
+
+// maxTemperature bounds the temperature a conversation can ask for.
+const maxTemperature = 1.0
+
+// ConversationModels are the models the users may pick for their conversations. None can
+// be picked when empty, the router or the gateway picking them instead.
+var ConversationModels []string
+
+// ConversationSettings are the overrides of a conversation, used for its replies instead of
+// the defaults of the assistant.
+type ConversationSettings struct {
+	Model       string   `json:"model,omitempty" dynamodbav:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty" dynamodbav:"temperature,omitempty"`
+	MaxTokens   int      `json:"maxTokens,omitempty" dynamodbav:"maxTokens,omitempty"`
+	Persona     string   `json:"persona,omitempty" dynamodbav:"persona,omitempty"`
+}
+
+// empty reports whether the settings override nothing.
+func (s ConversationSettings) empty() bool {
+	return s.Model == "" && s.Temperature == nil && s.MaxTokens == 0 && s.Persona == ""
+}
+
+// validate checks the settings are within what the users may ask for.
+func (s ConversationSettings) validate() error {
+	if s.Model != "" && !slices.Contains(ConversationModels, s.Model) {
+		return fmt.Errorf("model %s is not available", s.Model)
+	}
+	if s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > maxTemperature) {
+		return fmt.Errorf("temperature must be between 0 and %g", maxTemperature)
+	}
+	if s.MaxTokens < 0 || s.MaxTokens > maxConversationTokens {
+		return fmt.Errorf("maxTokens must be between 1 and %d", maxConversationTokens)
+	}
+	if _, ok := tools.Personas[s.Persona]; s.Persona != "" && !ok {
+		return fmt.Errorf("persona %s is not available", s.Persona)
+	}
+	return nil
+}
+
+// patch applies a JSON merge patch to the settings: the fields given are set, the fields
+// set to null are reset to the defaults of the assistant, and the others are kept.
+func (s *ConversationSettings) patch(body []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return errors.New("invalid request body")
+	}
+	for name, value := range fields {
+		var target any
+		switch name {
+		case "model":
+			s.Model, target = "", &s.Model
+		case "temperature":
+			s.Temperature, target = nil, &s.Temperature
+		case "maxTokens":
+			s.MaxTokens, target = 0, &s.MaxTokens
+		case "persona":
+			s.Persona, target = "", &s.Persona
+		default:
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			return fmt.Errorf("invalid %s", name)
+		}
+	}
+	return nil
+}
+
+// persona returns the persona of the assistant in the conversation.
+func (s *ConversationSettings) persona() string {
+	if s == nil || s.Persona == "" {
+		return tools.DefaultPersona
+	}
+	return s.Persona
+}
+
+// apply overrides the request to the model with the settings.
+func (s *ConversationSettings) apply(request *llm.Request) {
+	if s == nil {
+		return
+	}
+	if s.Model != "" {
+		request.Model = s.Model
+	}
+	if s.Temperature != nil {
+		request.Temperature = s.Temperature
+	}
+	if s.MaxTokens > 0 {
+		request.MaxTokens = s.MaxTokens
+	}
+}
+
+// conversationSettings returns the settings of the conversation, or nil if it has none.
+func (h *Handlers) conversationSettings(ctx context.Context, userId, conversationId string) (*ConversationSettings, error) {
+	conversation, err := h.getConversation(ctx, userId, conversationId)
+	if err != nil || conversation == nil {
+		return nil, err
+	}
+	return conversation.Settings, nil
+}
+
+// PatchConversationSettingsHandler changes the settings of the conversation, creating its
+// summary if it has no messages yet, so a conversation can be set up before it starts.
+func (h *Handlers) PatchConversationSettingsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Extract conversationId from path parameters
+	conversationId, ok := request.PathParameters["conversationId"]
+	if !ok || conversationId == "" {
+		return common.CreateErrorResponse(400, "Conversation ID is missing")
+	}
+	if len(conversationId) > maxConversationIdLength {
+		return common.CreateErrorResponse(400, "Invalid conversation ID")
+	}
+
+	conversation, err := h.getConversation(context.TODO(), claims.Sub, conversationId)
+	if err != nil {
+		log.Printf("Error getting conversation from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	var settings ConversationSettings
+	if conversation != nil && conversation.Settings != nil {
+		settings = *conversation.Settings
+	}
+	err = settings.patch([]byte(request.Body))
+	if err == nil {
+		err = settings.validate()
+	}
+	if err != nil {
+		return common.CreateErrorResponse(400, err.Error())
+	}
+
+	var saved Conversation
+	err = h.saveConversation(context.TODO(), claims.Sub, conversationId, func(conversation *Conversation) {
+		conversation.Settings = &settings
+		if settings.empty() {
+			conversation.Settings = nil
+		}
+		saved = *conversation
+	})
+	if err != nil {
+		log.Printf("Error saving conversation to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s changed the settings of conversation %s", claims.Sub, conversationId)
+
+	// Marshal the conversation into JSON for the payload
+	payload, err := json.Marshal(saved)
+	if err != nil {
+		log.Println("Error marshalling conversation:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchConversationSettingsHandler(t *testing.T) {
+	// Set up the fake DynamoDB
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	h := NewHandlers(fake, nil)
+	ConversationModels = []string{"fast-model", "strong-model"}
+	defer func() { ConversationModels = nil }()
+
+	patch := func(body string) events.APIGatewayProxyResponse {
+		response, err := h.PatchConversationSettingsHandler(testutil.NewRequest("PATCH", "/VassistantBackendProxy/messages/conversations/work/settings").
+			WithClaims("test-user-id", "test-user").
+			WithPathParam("conversationId", "work").
+			WithBody(body).
+			Build())
+		assert.NoError(t, err)
+		return response
+	}
+
+	// The conversation can be set up before it starts
+	response := patch(`{"model": "strong-model", "temperature": 0.2, "maxTokens": 1500}`)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var conversation Conversation
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &conversation))
+	assert.Equal(t, "strong-model", conversation.Settings.Model)
+	assert.Equal(t, 0.2, *conversation.Settings.Temperature)
+
+	// The settings outside of what the users may ask for are refused as a whole
+	for _, body := range []string{
+		`{"model": "other-model"}`,
+		`{"temperature": 1.5}`,
+		`{"maxTokens": 100000}`,
+		`{"persona": "unknown"}`,
+		`{"maxTokens": 100, "topK": 3}`,
+		`{"maxTokens": "many"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, patch(body).StatusCode, body)
+	}
+
+	// The replies use the settings, the ones set to null going back to the defaults
+	response = patch(`{"temperature": null}`)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var request llm.Request
+	llm.DefaultProvider = llm.ProviderFunc(func(ctx context.Context, r llm.Request) (llm.Response, error) {
+		if r.System == extractionSystemPrompt {
+			return llm.Response{Content: "[]"}, nil
+		}
+		request = r
+		return llm.Response{Content: "Sure.", Model: r.Model}, nil
+	})
+	defer func() { llm.DefaultProvider = nil }()
+
+	postMessage(t, h, "work", "Draft an email to my landlord")
+	assert.Equal(t, "strong-model", request.Model)
+	assert.Equal(t, 1500, request.MaxTokens)
+	assert.Nil(t, request.Temperature)
+
+	// The other conversations keep the defaults
+	postMessage(t, h, "", "Hi")
+	assert.Equal(t, "", request.Model)
+	assert.Equal(t, maxReplyTokens, request.MaxTokens)
+}
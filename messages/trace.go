@@ -0,0 +1,165 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tracesTable holds the step traces of the replies of the assistant, keyed by userId and the
+// messageId of the message replied to, with expiresAt as its TTL attribute.
+const tracesTable = "assistant-traces"
+
+// traceRetention is how long the traces are kept, long enough to debug a reply reported by
+// the user.
+const traceRetention = 30 * 24 * time.Hour
+
+// maxTracedText bounds the arguments, results and contents kept in a trace step, in bytes.
+const maxTracedText = 4096
+
+// The kinds of the steps of a trace
+const (
+	TracePlan   = "plan"   // what the model said it would do, along with its tool calls
+	TraceTool   = "tool"   // a tool call and its result
+	TraceAnswer = "answer" // the final answer of the model
+)
+
+// The outcomes of the traced replies
+const (
+	TraceAnswered = "answered"
+	TraceFailed   = "failed"
+)
+
+// TraceStep is a step of a reply of the assistant. The steps of a round are the plan of the
+// model, if it gave one, and the tools it called, run in order.
+type TraceStep struct {
+	Round      int    `json:"round" dynamodbav:"round"`
+	Kind       string `json:"kind" dynamodbav:"kind"`
+	Content    string `json:"content,omitempty" dynamodbav:"content,omitempty"`
+	Tool       string `json:"tool,omitempty" dynamodbav:"tool,omitempty"`
+	CallID     string `json:"callId,omitempty" dynamodbav:"callId,omitempty"`
+	Arguments  string `json:"arguments,omitempty" dynamodbav:"arguments,omitempty"`
+	Result     string `json:"result,omitempty" dynamodbav:"result,omitempty"`
+	Error      string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty" dynamodbav:"durationMs,omitempty"`
+}
+
+// Trace struct for the assistant-traces table, the steps the assistant took to reply to a
+// message of the user. Only the replies calling tools are traced.
+type Trace struct {
+	UserID    string      `json:"-" dynamodbav:"userId"`
+	MessageID string      `json:"messageId" dynamodbav:"messageId"`
+	Model     string      `json:"model,omitempty" dynamodbav:"model,omitempty"`
+	Outcome   string      `json:"outcome" dynamodbav:"outcome"`
+	Error     string      `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	Steps     []TraceStep `json:"steps" dynamodbav:"steps"`
+	CreatedAt string      `json:"createdAt" dynamodbav:"createdAt"`
+	ExpiresAt int64       `json:"-" dynamodbav:"expiresAt"`
+}
+
+// truncateTrace bounds a text kept in a trace.
+func truncateTrace(text string) string {
+	if len(text) > maxTracedText {
+		return text[:maxTracedText] + "…"
+	}
+	return text
+}
+
+// add appends the step to the trace, truncating its texts.
+func (t *Trace) add(step TraceStep) {
+	step.Content = truncateTrace(step.Content)
+	step.Arguments = truncateTrace(step.Arguments)
+	step.Result = truncateTrace(step.Result)
+	t.Steps = append(t.Steps, step)
+}
+
+// calledTools reports whether the reply called any tool.
+func (t *Trace) calledTools() bool {
+	for _, step := range t.Steps {
+		if step.Kind == TraceTool {
+			return true
+		}
+	}
+	return false
+}
+
+// saveTrace saves the trace of a reply that called tools, failed with err or not, replacing
+// that of a previous reply to the message. A failure to save it is only logged, the reply
+// doesn't depend on it.
+func (h *Handlers) saveTrace(ctx context.Context, trace *Trace, err error) {
+	if !trace.calledTools() {
+		return
+	}
+	now := time.Now()
+	trace.Outcome = TraceAnswered
+	if err != nil {
+		trace.Outcome, trace.Error = TraceFailed, err.Error()
+	}
+	trace.CreatedAt = now.UTC().Format(time.RFC3339Nano)
+	trace.ExpiresAt = now.Add(traceRetention).Unix()
+	item, err := attributevalue.MarshalMap(trace)
+	if err == nil {
+		_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(tracesTable), Item: item})
+	}
+	if err != nil {
+		log.Printf("Error saving the trace of the reply to message %s: %v", trace.MessageID, err)
+	}
+}
+
+// GetTraceHandler returns the trace of the reply of the assistant to a message of the user,
+// given the ID of the message replied to, i.e. the parentId of the reply.
+func (h *Handlers) GetTraceHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	messageId, ok := request.PathParameters["messageId"]
+	if !ok || messageId == "" {
+		return common.CreateErrorResponse(400, "Message ID is missing")
+	}
+
+	result, err := h.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(tracesTable),
+		Key: map[string]types.AttributeValue{
+			"userId":    &types.AttributeValueMemberS{Value: claims.Sub},
+			"messageId": &types.AttributeValueMemberS{Value: messageId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error getting trace from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if result.Item == nil {
+		return common.CreateErrorResponse(404, "Trace not found")
+	}
+	var trace Trace
+	if err := attributevalue.UnmarshalMap(result.Item, &trace); err != nil {
+		log.Printf("Error unmarshalling trace: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	payload, err := json.Marshal(trace)
+	if err != nil {
+		log.Println("Error marshalling trace:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
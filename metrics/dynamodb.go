@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBStats holds the DynamoDB usage recorded during an invocation.
+type DynamoDBStats struct {
+	Calls            int
+	Operations       map[string]int
+	ConsumedCapacity float64
+	Duration         time.Duration
+}
+
+// InstrumentedDynamoDB wraps a DynamoDB client, counting the operations performed and
+// the capacity they consumed so N+1 access patterns show up in logs and metrics.
+type InstrumentedDynamoDB struct {
+	Client common.DynamoDBAPI
+
+	// CallBudget is the number of calls per invocation above which a warning is logged.
+	CallBudget int
+	// SlowCallThreshold is the duration above which a single call is logged as slow.
+	SlowCallThreshold time.Duration
+
+	mu    sync.Mutex
+	stats DynamoDBStats
+}
+
+// NewInstrumentedDynamoDB creates an InstrumentedDynamoDB with the default thresholds.
+func NewInstrumentedDynamoDB(client common.DynamoDBAPI) *InstrumentedDynamoDB {
+	return &InstrumentedDynamoDB{
+		Client:            client,
+		CallBudget:        10,
+		SlowCallThreshold: 200 * time.Millisecond,
+		stats:             DynamoDBStats{Operations: map[string]int{}},
+	}
+}
+
+// Reset clears the recorded stats, it is called at the start of every invocation.
+func (c *InstrumentedDynamoDB) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = DynamoDBStats{Operations: map[string]int{}}
+}
+
+// Stats returns a copy of the stats recorded since the last Reset.
+func (c *InstrumentedDynamoDB) Stats() DynamoDBStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Operations = make(map[string]int, len(c.stats.Operations))
+	for operation, count := range c.stats.Operations {
+		stats.Operations[operation] = count
+	}
+	return stats
+}
+
+// Middleware records the DynamoDB usage of each request and publishes it as EMF metrics.
+func (c *InstrumentedDynamoDB) Middleware(route api.Route, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		c.Reset()
+		response, err := next(request)
+
+		stats := c.Stats()
+		routeName := route.Method + " " + route.Template
+		if stats.Calls > c.CallBudget {
+			log.Printf("Warning: %s made %d DynamoDB calls (budget %d): %v", routeName, stats.Calls, c.CallBudget, stats.Operations)
+		}
+
+		Emit(map[string]string{"Route": routeName},
+			Metric{Name: "DynamoDBCalls", Unit: UnitCount, Value: float64(stats.Calls)},
+			Metric{Name: "DynamoDBConsumedCapacity", Unit: UnitNone, Value: stats.ConsumedCapacity},
+			Metric{Name: "DynamoDBDuration", Unit: UnitMilliseconds, Value: float64(stats.Duration.Milliseconds())},
+		)
+		return response, err
+	}
+}
+
+func (c *InstrumentedDynamoDB) record(operation string, duration time.Duration, capacity ...types.ConsumedCapacity) {
+	if duration > c.SlowCallThreshold {
+		log.Printf("Warning: slow DynamoDB %s took %s", operation, duration)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Calls++
+	c.stats.Operations[operation]++
+	c.stats.Duration += duration
+	for _, consumed := range capacity {
+		if consumed.CapacityUnits != nil {
+			c.stats.ConsumedCapacity += *consumed.CapacityUnits
+		}
+	}
+}
+
+// consumed dereferences an optional ConsumedCapacity returned by single-item operations.
+func consumed(capacity *types.ConsumedCapacity) []types.ConsumedCapacity {
+	if capacity == nil {
+		return nil
+	}
+	return []types.ConsumedCapacity{*capacity}
+}
+
+func (c *InstrumentedDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.Query(ctx, params, optFns...)
+	if err != nil {
+		c.record("Query", time.Since(start))
+		return output, err
+	}
+	c.record("Query", time.Since(start), consumed(output.ConsumedCapacity)...)
+	return output, nil
+}
+
+func (c *InstrumentedDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.PutItem(ctx, params, optFns...)
+	if err != nil {
+		c.record("PutItem", time.Since(start))
+		return output, err
+	}
+	c.record("PutItem", time.Since(start), consumed(output.ConsumedCapacity)...)
+	return output, nil
+}
+
+func (c *InstrumentedDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.GetItem(ctx, params, optFns...)
+	if err != nil {
+		c.record("GetItem", time.Since(start))
+		return output, err
+	}
+	c.record("GetItem", time.Since(start), consumed(output.ConsumedCapacity)...)
+	return output, nil
+}
+
+func (c *InstrumentedDynamoDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.BatchGetItem(ctx, params, optFns...)
+	if err != nil {
+		c.record("BatchGetItem", time.Since(start))
+		return output, err
+	}
+	c.record("BatchGetItem", time.Since(start), output.ConsumedCapacity...)
+	return output, nil
+}
+
+func (c *InstrumentedDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.DeleteItem(ctx, params, optFns...)
+	if err != nil {
+		c.record("DeleteItem", time.Since(start))
+		return output, err
+	}
+	c.record("DeleteItem", time.Since(start), consumed(output.ConsumedCapacity)...)
+	return output, nil
+}
+
+func (c *InstrumentedDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.Scan(ctx, params, optFns...)
+	if err != nil {
+		c.record("Scan", time.Since(start))
+		return output, err
+	}
+	c.record("Scan", time.Since(start), consumed(output.ConsumedCapacity)...)
+	return output, nil
+}
+
+func (c *InstrumentedDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	params.ReturnConsumedCapacity = types.ReturnConsumedCapacityTotal
+	start := time.Now()
+	output, err := c.Client.TransactWriteItems(ctx, params, optFns...)
+	if err != nil {
+		c.record("TransactWriteItems", time.Since(start))
+		return output, err
+	}
+	c.record("TransactWriteItems", time.Since(start), output.ConsumedCapacity...)
+	return output, nil
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"vassistant-backend/api"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedDynamoDBMiddleware(t *testing.T) {
+	var output bytes.Buffer
+	Output = &output
+
+	client := NewInstrumentedDynamoDB(&testutil.MockDynamoDBClient{
+		GetItemFunc: func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+			return &dynamodb.GetItemOutput{
+				ConsumedCapacity: &types.ConsumedCapacity{CapacityUnits: aws.Float64(0.5)},
+			}, nil
+		},
+	})
+
+	// Register a route whose handler makes two DynamoDB calls
+	router := api.NewRouter()
+	router.Use(client.Middleware)
+	router.AddRoute("GET", "/groups/(?P<groupId>[^/]+)", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		input := &dynamodb.GetItemInput{}
+		_, _ = client.GetItem(context.TODO(), input)
+		_, _ = client.GetItem(context.TODO(), input)
+
+		// The client asks DynamoDB to report the consumed capacity
+		assert.Equal(t, types.ReturnConsumedCapacityTotal, input.ReturnConsumedCapacity)
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	})
+
+	// Call the router
+	response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/groups/test-group-id"})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+
+	// Check the stats of the invocation
+	stats := client.Stats()
+	assert.Equal(t, 2, stats.Calls)
+	assert.Equal(t, 2, stats.Operations["GetItem"])
+	assert.Equal(t, 1.0, stats.ConsumedCapacity)
+
+	// Check the EMF log line
+	var document map[string]interface{}
+	err = json.Unmarshal(output.Bytes(), &document)
+	assert.NoError(t, err)
+	assert.Equal(t, "GET /groups/{groupId}", document["Route"])
+	assert.Equal(t, 2.0, document["DynamoDBCalls"])
+	assert.Equal(t, 1.0, document["DynamoDBConsumedCapacity"])
+	assert.Contains(t, document, "_aws")
+}
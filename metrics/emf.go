@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Namespace is the CloudWatch namespace the metrics are published under.
+const Namespace = "Vassistant"
+
+// Units supported by CloudWatch for the metrics we publish.
+const (
+	UnitCount        = "Count"
+	UnitMilliseconds = "Milliseconds"
+	UnitNone         = "None"
+)
+
+// Metric is a single named value published in an EMF log line.
+type Metric struct {
+	Name  string
+	Unit  string
+	Value float64
+}
+
+// Output is where EMF log lines are written. Lambda forwards stdout to CloudWatch Logs,
+// which extracts the metrics from lines that start with the EMF JSON document.
+var Output io.Writer = os.Stdout
+
+// Emit writes the metrics as a CloudWatch Embedded Metric Format log line.
+func Emit(dimensions map[string]string, metrics ...Metric) {
+	dimensionNames := make([]string, 0, len(dimensions))
+	document := make(map[string]interface{}, len(dimensions)+len(metrics)+1)
+	for name, value := range dimensions {
+		dimensionNames = append(dimensionNames, name)
+		document[name] = value
+	}
+
+	definitions := make([]map[string]string, 0, len(metrics))
+	for _, metric := range metrics {
+		definitions = append(definitions, map[string]string{"Name": metric.Name, "Unit": metric.Unit})
+		document[metric.Name] = metric.Value
+	}
+
+	document["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{
+			{
+				"Namespace":  Namespace,
+				"Dimensions": [][]string{dimensionNames},
+				"Metrics":    definitions,
+			},
+		},
+	}
+
+	line, err := json.Marshal(document)
+	if err != nil {
+		log.Printf("Error marshalling EMF metrics: %v", err)
+		return
+	}
+	fmt.Fprintln(Output, string(line))
+}
@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/llm"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// ByMonth is the monthly granularity of the cost aggregates.
+const ByMonth = "month"
+
+// ByModel groups the cost aggregates by model.
+const ByModel = "model"
+
+// SystemUser is the user of the model requests made on nobody's behalf, like scheduled jobs.
+const SystemUser = "system"
+
+// UnknownModel is the model of the responses of providers not telling theirs.
+const UnknownModel = "unknown"
+
+// maxParallelDays bounds how many days of costs are queried at the same time.
+const maxParallelDays = 4
+
+// Price is the price of a model, in US dollars per million tokens.
+type Price struct {
+	InputPerMillion  float64 `json:"input"`
+	OutputPerMillion float64 `json:"output"`
+}
+
+// DefaultPricing is the on-demand Bedrock pricing of the models the gateway may route to.
+// Models missing from the pricing are recorded without a cost.
+var DefaultPricing = map[string]Price{
+	"amazon.nova-micro-v1:0": {InputPerMillion: 0.035, OutputPerMillion: 0.14},
+	"amazon.nova-lite-v1:0":  {InputPerMillion: 0.06, OutputPerMillion: 0.24},
+	"amazon.nova-pro-v1:0":   {InputPerMillion: 0.8, OutputPerMillion: 3.2},
+}
+
+// ParsePricing parses a JSON object of prices by model, like
+// {"amazon.nova-lite-v1:0": {"input": 0.06, "output": 0.24}}, over the DefaultPricing.
+func ParsePricing(spec string) (map[string]Price, error) {
+	var prices map[string]Price
+	if err := json.Unmarshal([]byte(spec), &prices); err != nil {
+		return nil, err
+	}
+	pricing := make(map[string]Price, len(DefaultPricing)+len(prices))
+	for model, price := range DefaultPricing {
+		pricing[model] = price
+	}
+	for model, price := range prices {
+		if price.InputPerMillion < 0 || price.OutputPerMillion < 0 {
+			return nil, fmt.Errorf("invalid price of model %s", model)
+		}
+		pricing[model] = price
+	}
+	return pricing, nil
+}
+
+// CostRecord is a model request stored in the costs table, partitioned by day.
+type CostRecord struct {
+	Day          string `dynamodbav:"day"`
+	ID           string `dynamodbav:"id"`
+	Model        string `dynamodbav:"model"`
+	UserID       string `dynamodbav:"userId"`
+	InputTokens  int    `dynamodbav:"inputTokens"`
+	OutputTokens int    `dynamodbav:"outputTokens"`
+	CostMicros   int64  `dynamodbav:"costMicros"` // in millionths of a US dollar
+	Priced       bool   `dynamodbav:"priced"`
+	ExpiresAt    int64  `dynamodbav:"expiresAt"`
+}
+
+// CostQuery selects the requests to aggregate: those between From and To, bucketed by
+// Granularity and grouped by Dimension.
+type CostQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Dimension   string
+}
+
+// CostBucket aggregates the requests of a model or user during a day or month. The cost
+// is in US dollars; Unpriced counts the requests of models without a price, left out of it.
+type CostBucket struct {
+	Start        string      `json:"start"`
+	Key          string      `json:"key"`
+	Requests     int         `json:"requests"`
+	InputTokens  int         `json:"inputTokens"`
+	OutputTokens int         `json:"outputTokens"`
+	Cost         json.Number `json:"cost"`
+	Unpriced     int         `json:"unpriced,omitempty"`
+}
+
+// LLMCostRecorder records the model, token counts and cost of every language model request
+// in a table keyed by day and id, with expiresAt as its TTL attribute.
+type LLMCostRecorder struct {
+	Client    common.DynamoDBAPI
+	TableName string
+	Pricing   map[string]Price
+
+	// Retention is how long the requests are kept before DynamoDB expires them.
+	Retention time.Duration
+}
+
+// NewLLMCostRecorder creates an LLMCostRecorder on the table with the DefaultPricing,
+// keeping the requests for 400 days so months can be compared year over year.
+func NewLLMCostRecorder(client common.DynamoDBAPI, tableName string) *LLMCostRecorder {
+	return &LLMCostRecorder{Client: client, TableName: tableName, Pricing: DefaultPricing, Retention: 400 * 24 * time.Hour}
+}
+
+// cost returns the cost of the tokens with the price of the model, in millionths of a
+// dollar, and whether the model has a price.
+func (r *LLMCostRecorder) cost(model string, inputTokens, outputTokens int) (int64, bool) {
+	price, ok := r.Pricing[model]
+	if !ok {
+		return 0, false
+	}
+	return int64(math.Round(float64(inputTokens)*price.InputPerMillion + float64(outputTokens)*price.OutputPerMillion)), true
+}
+
+// Provider wraps the provider, recording the requests it completes. Failing to record a
+// request is logged without failing it.
+func (r *LLMCostRecorder) Provider(next llm.Provider) llm.Provider {
+	return llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		start := time.Now()
+		response, err := next.Complete(ctx, request)
+		if err != nil {
+			return response, err
+		}
+
+		record := CostRecord{
+			Day:          start.UTC().Format(time.DateOnly),
+			ID:           start.UTC().Format(time.RFC3339Nano) + "#" + uuid.New().String(),
+			Model:        response.Model,
+			UserID:       request.User,
+			InputTokens:  response.InputTokens,
+			OutputTokens: response.OutputTokens,
+			ExpiresAt:    start.Add(r.Retention).Unix(),
+		}
+		if record.Model == "" {
+			record.Model = UnknownModel
+		}
+		if record.UserID == "" {
+			record.UserID = SystemUser
+		}
+		record.CostMicros, record.Priced = r.cost(record.Model, record.InputTokens, record.OutputTokens)
+		if !record.Priced {
+			log.Printf("Warning: no price for model %s, recording its request without a cost", record.Model)
+		}
+		if recordErr := r.record(ctx, record); recordErr != nil {
+			log.Printf("Error recording the cost of a %s request: %v", record.Model, recordErr)
+		}
+		return response, nil
+	})
+}
+
+func (r *LLMCostRecorder) record(ctx context.Context, record CostRecord) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.TableName),
+		Item:      item,
+	})
+	return err
+}
+
+// dayRequests returns the requests recorded during the day, following the pages of the result.
+func (r *LLMCostRecorder) dayRequests(ctx context.Context, day string) ([]CostRecord, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(r.TableName),
+		KeyConditionExpression: aws.String("#day = :day"),
+		ExpressionAttributeNames: map[string]string{
+			"#day": "day",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":day": &types.AttributeValueMemberS{Value: day},
+		},
+	}
+
+	var records []CostRecord
+	for {
+		result, err := r.Client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var items []CostRecord
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, items...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return records, nil
+		}
+	}
+}
+
+// Aggregate returns the requests selected by the query, per bucket of time and model or
+// user, ordered by bucket and then by cost.
+func (r *LLMCostRecorder) Aggregate(ctx context.Context, query CostQuery) ([]CostBucket, error) {
+	var days []string
+	for day := query.From.UTC().Truncate(24 * time.Hour); day.Before(query.To); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(time.DateOnly))
+	}
+
+	perDay := make([][]CostRecord, len(days))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelDays)
+	for i, day := range days {
+		g.Go(func() error {
+			records, err := r.dayRequests(ctx, day)
+			perDay[i] = records
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct{ start, key string }
+	costs := map[bucketKey]int64{}
+	buckets := map[bucketKey]*CostBucket{}
+	for _, records := range perDay {
+		for _, record := range records {
+			start := record.Day
+			if query.Granularity == ByMonth {
+				start = record.Day[:len("2006-01")]
+			}
+			key := bucketKey{start, record.Model}
+			if query.Dimension == ByUser {
+				key.key = record.UserID
+			}
+
+			if buckets[key] == nil {
+				buckets[key] = &CostBucket{Start: key.start, Key: key.key}
+			}
+			buckets[key].Requests++
+			buckets[key].InputTokens += record.InputTokens
+			buckets[key].OutputTokens += record.OutputTokens
+			if !record.Priced {
+				buckets[key].Unpriced++
+			}
+			costs[key] += record.CostMicros
+		}
+	}
+
+	result := make([]CostBucket, 0, len(buckets))
+	for key, bucket := range buckets {
+		bucket.Cost = json.Number(big.NewRat(costs[key], 1e6).FloatString(6))
+		result = append(result, *bucket)
+	}
+	micros := func(bucket CostBucket) int64 { return costs[bucketKey{bucket.Start, bucket.Key}] }
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		if micros(result[i]) != micros(result[j]) {
+			return micros(result[i]) > micros(result[j])
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
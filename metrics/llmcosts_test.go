@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vassistant-backend/llm"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLMCostRecorder(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	recorder := NewLLMCostRecorder(fake, "llm-costs")
+
+	// A provider answering with the model of the system prompt
+	provider := recorder.Provider(llm.ProviderFunc(func(ctx context.Context, request llm.Request) (llm.Response, error) {
+		return llm.Response{Content: "ok", Model: request.System, InputTokens: 1000, OutputTokens: 500}, nil
+	}))
+	for _, request := range []llm.Request{
+		{System: "amazon.nova-pro-v1:0", User: "user-1"},
+		{System: "amazon.nova-pro-v1:0", User: "user-2"},
+		{System: "amazon.nova-micro-v1:0", User: "user-1"},
+		{System: "custom-model"},
+	} {
+		response, err := provider.Complete(context.TODO(), request)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", response.Content)
+	}
+
+	now := time.Now()
+	query := CostQuery{From: now.Add(-24 * time.Hour), To: now.Add(24 * time.Hour), Granularity: ByDay, Dimension: ByModel}
+	buckets, err := recorder.Aggregate(context.TODO(), query)
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 3)
+	assert.Equal(t, "amazon.nova-pro-v1:0", buckets[0].Key)
+	assert.Equal(t, 2, buckets[0].Requests)
+	assert.Equal(t, 2000, buckets[0].InputTokens)
+	assert.Equal(t, "0.004800", string(buckets[0].Cost))
+	assert.Equal(t, "amazon.nova-micro-v1:0", buckets[1].Key)
+	assert.Equal(t, "0.000105", string(buckets[1].Cost))
+	assert.Equal(t, "custom-model", buckets[2].Key)
+	assert.Equal(t, "0.000000", string(buckets[2].Cost))
+	assert.Equal(t, 1, buckets[2].Unpriced)
+
+	// Monthly, per user; requests made on nobody's behalf are the system's
+	query.Granularity = ByMonth
+	query.Dimension = ByUser
+	buckets, err = recorder.Aggregate(context.TODO(), query)
+	assert.NoError(t, err)
+	costs := map[string]string{}
+	for _, bucket := range buckets {
+		assert.Len(t, bucket.Start, len("2006-01"))
+		costs[bucket.Key] += string(bucket.Cost)
+	}
+	assert.Equal(t, map[string]string{"user-1": "0.002505", "user-2": "0.002400", SystemUser: "0.000000"}, costs)
+}
+
+func TestParsePricing(t *testing.T) {
+	pricing, err := ParsePricing(`{"custom-model": {"input": 1, "output": 2}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, Price{InputPerMillion: 1, OutputPerMillion: 2}, pricing["custom-model"])
+	assert.Equal(t, DefaultPricing["amazon.nova-lite-v1:0"], pricing["amazon.nova-lite-v1:0"])
+
+	_, err = ParsePricing(`{"custom-model": {"input": -1}}`)
+	assert.Error(t, err)
+	_, err = ParsePricing(`[]`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// Granularities of the usage aggregates
+const (
+	ByHour = "hour"
+	ByDay  = "day"
+)
+
+// Dimensions the usage can be aggregated by
+const (
+	ByRoute = "route"
+	ByUser  = "user"
+)
+
+// AnonymousUser is the user of the calls made without signing in.
+const AnonymousUser = "anonymous"
+
+// hourLayout formats the hour partitioning the usage table.
+const hourLayout = "2006-01-02T15"
+
+// maxParallelHours bounds how many hours of usage are queried at the same time.
+const maxParallelHours = 4
+
+// UsageRecord is a call to the API stored in the usage table, partitioned by hour. The
+// calls are stored one by one rather than as counters so latency percentiles can be computed.
+type UsageRecord struct {
+	Hour       string `dynamodbav:"hour"`
+	ID         string `dynamodbav:"id"`
+	Route      string `dynamodbav:"route"`
+	UserID     string `dynamodbav:"userId"`
+	StatusCode int    `dynamodbav:"statusCode"`
+	LatencyMs  int64  `dynamodbav:"latencyMs"`
+	ExpiresAt  int64  `dynamodbav:"expiresAt"`
+}
+
+// UsageQuery selects the calls to aggregate: those between From and To, optionally of a
+// single route or user, bucketed by Granularity and grouped by Dimension.
+type UsageQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Dimension   string
+	Route       string
+	UserID      string
+}
+
+// UsageBucket aggregates the calls of a route or user during a bucket of time.
+type UsageBucket struct {
+	Start        string  `json:"start"`
+	Key          string  `json:"key"`
+	Calls        int     `json:"calls"`
+	Users        int     `json:"users"`
+	Errors       int     `json:"errors"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	P95LatencyMs int64   `json:"p95LatencyMs"`
+}
+
+// UsageRecorder records the calls of every route with their user and latency in a table
+// keyed by hour and id, with expiresAt as its TTL attribute.
+type UsageRecorder struct {
+	Client    common.DynamoDBAPI
+	TableName string
+
+	// Retention is how long the calls are kept before DynamoDB expires them.
+	Retention time.Duration
+}
+
+// NewUsageRecorder creates a UsageRecorder on the table, keeping the calls for 90 days.
+func NewUsageRecorder(client common.DynamoDBAPI, tableName string) *UsageRecorder {
+	return &UsageRecorder{Client: client, TableName: tableName, Retention: 90 * 24 * time.Hour}
+}
+
+// Middleware records the call of each request once handled. Failing to record a call is
+// logged without failing the request.
+func (u *UsageRecorder) Middleware(route api.Route, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		response, err := next(request)
+
+		record := UsageRecord{
+			Hour:       start.UTC().Format(hourLayout),
+			ID:         start.UTC().Format(time.RFC3339Nano) + "#" + request.RequestContext.RequestID,
+			Route:      route.Method + " " + route.Template,
+			UserID:     AnonymousUser,
+			StatusCode: response.StatusCode,
+			LatencyMs:  time.Since(start).Milliseconds(),
+			ExpiresAt:  start.Add(u.Retention).Unix(),
+		}
+		if err != nil {
+			record.StatusCode = 500
+		}
+		if claims, claimsErr := auth.ParseClaims(request); claimsErr == nil {
+			record.UserID = claims.Sub
+		}
+		if recordErr := u.record(context.TODO(), record); recordErr != nil {
+			log.Printf("Error recording the usage of %s: %v", record.Route, recordErr)
+		}
+		return response, err
+	}
+}
+
+func (u *UsageRecorder) record(ctx context.Context, record UsageRecord) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+	_, err = u.Client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(u.TableName),
+		Item:      item,
+	})
+	return err
+}
+
+// hourCalls returns the calls recorded during the hour, following the pages of the result.
+func (u *UsageRecorder) hourCalls(ctx context.Context, hour string) ([]UsageRecord, error) {
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(u.TableName),
+		KeyConditionExpression: aws.String("#hour = :hour"),
+		ExpressionAttributeNames: map[string]string{
+			"#hour": "hour",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":hour": &types.AttributeValueMemberS{Value: hour},
+		},
+	}
+
+	var records []UsageRecord
+	for {
+		result, err := u.Client.Query(ctx, queryInput)
+		if err != nil {
+			return nil, err
+		}
+		var items []UsageRecord
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, items...)
+
+		queryInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(queryInput.ExclusiveStartKey) == 0 {
+			return records, nil
+		}
+	}
+}
+
+// Aggregate returns the calls selected by the query, per bucket of time and route or user,
+// ordered by bucket and then by number of calls.
+func (u *UsageRecorder) Aggregate(ctx context.Context, query UsageQuery) ([]UsageBucket, error) {
+	var hours []string
+	for hour := query.From.UTC().Truncate(time.Hour); hour.Before(query.To); hour = hour.Add(time.Hour) {
+		hours = append(hours, hour.Format(hourLayout))
+	}
+
+	perHour := make([][]UsageRecord, len(hours))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxParallelHours)
+	for i, hour := range hours {
+		g.Go(func() error {
+			records, err := u.hourCalls(ctx, hour)
+			perHour[i] = records
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct{ start, key string }
+	latencies := map[bucketKey][]int64{}
+	users := map[bucketKey]map[string]struct{}{}
+	buckets := map[bucketKey]*UsageBucket{}
+	for _, records := range perHour {
+		for _, record := range records {
+			if (query.Route != "" && record.Route != query.Route) || (query.UserID != "" && record.UserID != query.UserID) {
+				continue
+			}
+			start := record.Hour
+			if query.Granularity == ByDay {
+				start = record.Hour[:len("2006-01-02")]
+			}
+			key := bucketKey{start, record.Route}
+			if query.Dimension == ByUser {
+				key.key = record.UserID
+			}
+
+			if buckets[key] == nil {
+				buckets[key] = &UsageBucket{Start: key.start, Key: key.key}
+				users[key] = map[string]struct{}{}
+			}
+			buckets[key].Calls++
+			if record.StatusCode >= 500 {
+				buckets[key].Errors++
+			}
+			users[key][record.UserID] = struct{}{}
+			latencies[key] = append(latencies[key], record.LatencyMs)
+		}
+	}
+
+	result := make([]UsageBucket, 0, len(buckets))
+	for key, bucket := range buckets {
+		bucket.Users = len(users[key])
+		bucket.AvgLatencyMs, bucket.P95LatencyMs = latencyStats(latencies[key])
+		result = append(result, *bucket)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		if result[i].Calls != result[j].Calls {
+			return result[i].Calls > result[j].Calls
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// latencyStats returns the average latency, rounded to the millisecond, and the 95th
+// percentile using the nearest-rank method.
+func latencyStats(latencies []int64) (float64, int64) {
+	if len(latencies) == 0 {
+		return 0, 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total int64
+	for _, latency := range latencies {
+		total += latency
+	}
+	rank := int(math.Ceil(0.95*float64(len(latencies)))) - 1
+	return math.Round(float64(total) / float64(len(latencies))), latencies[rank]
+}
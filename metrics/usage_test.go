@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageRecorder(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	recorder := NewUsageRecorder(fake, "usage-metrics")
+
+	// Register a route answering with the status code of the query string
+	router := api.NewRouter()
+	router.Use(recorder.Middleware)
+	router.AddRoute("GET", "/groups/(?P<groupId>[^/]+)", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if request.QueryStringParameters["fail"] == "true" {
+			return events.APIGatewayProxyResponse{StatusCode: 500}, nil
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	})
+
+	// Two users call the route, one of the calls fails
+	calls := []events.APIGatewayProxyRequest{
+		testutil.NewRequest("GET", "/groups/group-1").WithClaims("user-1", "alice").Build(),
+		testutil.NewRequest("GET", "/groups/group-1").WithClaims("user-1", "alice").WithQueryParam("fail", "true").Build(),
+		testutil.NewRequest("GET", "/groups/group-2").WithClaims("user-2", "bob").Build(),
+		testutil.NewRequest("GET", "/groups/group-2").Build(),
+	}
+	for i, request := range calls {
+		request.RequestContext.RequestID = string(rune('a' + i))
+		_, err := router.Serve(request)
+		assert.NoError(t, err)
+	}
+
+	now := time.Now()
+	query := UsageQuery{From: now.Add(-time.Hour), To: now.Add(time.Hour), Granularity: ByDay, Dimension: ByRoute}
+	buckets, err := recorder.Aggregate(context.TODO(), query)
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 1)
+	assert.Equal(t, now.UTC().Format(time.DateOnly), buckets[0].Start)
+	assert.Equal(t, "GET /groups/{groupId}", buckets[0].Key)
+	assert.Equal(t, 4, buckets[0].Calls)
+	assert.Equal(t, 3, buckets[0].Users)
+	assert.Equal(t, 1, buckets[0].Errors)
+
+	// The calls of a user, grouped by user
+	query.Dimension = ByUser
+	query.UserID = "user-1"
+	buckets, err = recorder.Aggregate(context.TODO(), query)
+	assert.NoError(t, err)
+	assert.Len(t, buckets, 1)
+	assert.Equal(t, "user-1", buckets[0].Key)
+	assert.Equal(t, 2, buckets[0].Calls)
+}
+
+func TestLatencyStats(t *testing.T) {
+	average, p95 := latencyStats([]int64{100, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19})
+	assert.Equal(t, 15.0, average)
+	assert.Equal(t, int64(19), p95)
+
+	average, p95 = latencyStats(nil)
+	assert.Equal(t, 0.0, average)
+	assert.Equal(t, int64(0), p95)
+}
@@ -0,0 +1,123 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/i18n"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// Notification struct for the notifications table
+type Notification struct {
+	UserID         string            `json:"userId" dynamodbav:"userId"`
+	CreatedAt      string            `json:"createdAt" dynamodbav:"createdAt"`
+	NotificationID string            `json:"notificationId" dynamodbav:"notificationId"`
+	Type           string            `json:"type" dynamodbav:"type"`
+	Message        string            `json:"message" dynamodbav:"message"`
+	Data           map[string]string `json:"data" dynamodbav:"data"`
+}
+
+var DynamoDbClient common.DynamoDBAPI
+
+// messageKey is the catalog key of the message of a notification type.
+func messageKey(notificationType string) string {
+	return "notification." + notificationType
+}
+
+// Notify stores a notification for the user. Its message is rendered from the catalog
+// entry of its type with the data; the stored message is the default language one,
+// notifications are localized again when listed. The types muted by the user aren't stored.
+func Notify(ctx context.Context, userId, notificationType string, data map[string]string) error {
+	preferences, err := GetPreferences(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !preferences.Sends(notificationType) {
+		log.Printf("Not notifying user %s of the muted %s", userId, notificationType)
+		return nil
+	}
+
+	notification := Notification{
+		UserID:         userId,
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339Nano),
+		NotificationID: uuid.New().String(),
+		Type:           notificationType,
+		Message:        i18n.T(i18n.DefaultLanguage, messageKey(notificationType), data),
+		Data:           data,
+	}
+
+	err = common.ConditionalPutItem(ctx, DynamoDbClient, "notifications", notification, common.IfNotExists("userId"))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Notified user %s of %s", userId, notificationType)
+	return nil
+}
+
+func GetNotificationsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Build the query input, newest notifications first
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String("notifications"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: claims.Sub},
+		},
+		ScanIndexForward: aws.Bool(false),
+		ConsistentRead:   common.ConsistentRead(request),
+	}
+
+	// Make the DynamoDB Query API call
+	result, err := DynamoDbClient.Query(context.TODO(), queryInput)
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Unmarshal the Items into a slice of Notification structs
+	notifications := []Notification{}
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &notifications)
+	if err != nil {
+		log.Printf("Error unmarshalling notifications: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Localize the messages of the known notification types
+	language := i18n.Language(request)
+	for i, notification := range notifications {
+		if message, ok := i18n.Render(language, messageKey(notification.Type), notification.Data); ok {
+			notifications[i].Message = message
+		}
+	}
+
+	// Marshal the notifications into JSON for the payload
+	payload, err := json.Marshal(notifications)
+	if err != nil {
+		log.Println("Error marshalling notifications:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json", "Content-Language": language},
+		Body:       string(payload),
+	}, nil
+}
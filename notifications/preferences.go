@@ -0,0 +1,322 @@
+package notifications
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/i18n"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// UnsubscribeURL is the address of the public unsubscribe endpoint, e.g.
+// https://api.example.com/prod/VassistantBackendProxy/public/unsubscribe, the links in the
+// notifications being left out while it is unset.
+var UnsubscribeURL string
+
+// maxPreferencesAttempts bounds how many times saving the preferences is retried on
+// concurrent writes.
+const maxPreferencesAttempts = 3
+
+// Preferences struct for the notification-preferences table, the notification types the
+// user doesn't want to be sent, every type being sent until muted.
+type Preferences struct {
+	UserID    string   `json:"-" dynamodbav:"userId"`
+	Muted     []string `json:"muted" dynamodbav:"muted,omitempty"`
+	UpdatedAt string   `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+	// UnsubscribeSecret is kept in the clear to build the same links every time; it can
+	// only mute notifications
+	UnsubscribeSecret string `json:"-" dynamodbav:"unsubscribeSecret,omitempty"`
+	Version           int    `json:"-" dynamodbav:"version,omitempty"`
+}
+
+// Sends reports whether the notifications of the type are sent to the user.
+func (p Preferences) Sends(notificationType string) bool {
+	return !slices.Contains(p.Muted, notificationType)
+}
+
+// mute adds the notification type to the muted ones, reporting whether it wasn't already.
+func (p *Preferences) mute(notificationType string) bool {
+	if !p.Sends(notificationType) {
+		return false
+	}
+	p.Muted = append(p.Muted, notificationType)
+	slices.Sort(p.Muted)
+	return true
+}
+
+// knownType reports whether the notification type has a message in the catalog.
+func knownType(notificationType string) bool {
+	return notificationType != "" && i18n.T(i18n.DefaultLanguage, messageKey(notificationType), nil) != messageKey(notificationType)
+}
+
+// GetPreferences returns the preferences of the user, the defaults sending everything if
+// none are stored.
+func GetPreferences(ctx context.Context, userId string) (Preferences, error) {
+	result, err := DynamoDbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("notification-preferences"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Preferences{}, err
+	}
+
+	preferences := Preferences{UserID: userId, Muted: []string{}}
+	if result.Item == nil {
+		return preferences, nil
+	}
+	err = attributevalue.UnmarshalMap(result.Item, &preferences)
+	if err != nil {
+		return Preferences{}, err
+	}
+	if preferences.Muted == nil {
+		preferences.Muted = []string{}
+	}
+	return preferences, nil
+}
+
+// UnsubscribeLink returns the link muting the notification type for the user without
+// signing in, creating their unsubscribe secret on first use. It is empty while
+// UnsubscribeURL is unset.
+func UnsubscribeLink(ctx context.Context, userId, notificationType string) (string, error) {
+	if UnsubscribeURL == "" {
+		return "", nil
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	// Another run may have created the secret in the meantime, whose links are kept
+	preferences, err := savePreferences(ctx, userId, func(preferences *Preferences) bool {
+		if preferences.UnsubscribeSecret != "" {
+			return false
+		}
+		preferences.UnsubscribeSecret = base64.RawURLEncoding.EncodeToString(random)
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{"token": {userId + "." + preferences.UnsubscribeSecret}, "type": {notificationType}}
+	return UnsubscribeURL + "?" + query.Encode(), nil
+}
+
+// userOfToken returns the preferences of the user whose unsubscribe token it is with their
+// ID, which is empty if it isn't one.
+func userOfToken(ctx context.Context, token string) (Preferences, string, error) {
+	userId, secret, ok := strings.Cut(token, ".")
+	if !ok || userId == "" || secret == "" {
+		return Preferences{}, "", nil
+	}
+	preferences, err := GetPreferences(ctx, userId)
+	if err != nil {
+		return Preferences{}, "", err
+	}
+	if preferences.UnsubscribeSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(preferences.UnsubscribeSecret)) != 1 {
+		return Preferences{}, "", nil
+	}
+	return preferences, userId, nil
+}
+
+// savePreferences applies the change to the stored preferences of the user and returns them,
+// retrying when they are updated concurrently, so the unsubscribe secret created meanwhile
+// is kept. The change reports whether it changed anything, nothing being written otherwise.
+func savePreferences(ctx context.Context, userId string, change func(*Preferences) bool) (Preferences, error) {
+	for attempt := 1; ; attempt++ {
+		preferences, err := GetPreferences(ctx, userId)
+		if err != nil || !change(&preferences) {
+			return preferences, err
+		}
+
+		expectedVersion := preferences.Version
+		preferences.Version = expectedVersion + 1
+		preferences.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		err = common.ConditionalPutItem(ctx, DynamoDbClient, "notification-preferences", preferences, common.IfVersion(expectedVersion))
+		if errors.Is(err, common.ErrConditionFailed) && attempt < maxPreferencesAttempts {
+			continue
+		}
+		return preferences, err
+	}
+}
+
+// preferencesResponse answers with the preferences as JSON.
+func preferencesResponse(preferences Preferences) (events.APIGatewayProxyResponse, error) {
+	// Marshal the preferences into JSON for the payload
+	payload, err := json.Marshal(preferences)
+	if err != nil {
+		log.Println("Error marshalling notification preferences:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func GetPreferencesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	preferences, err := GetPreferences(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting notification preferences from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return preferencesResponse(preferences)
+}
+
+func PutPreferencesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Parse the request body into a Preferences struct
+	var update Preferences
+	err = json.Unmarshal([]byte(request.Body), &update)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	for _, notificationType := range update.Muted {
+		if !knownType(notificationType) {
+			return common.CreateErrorResponse(400, "Unknown notification type: "+notificationType)
+		}
+	}
+
+	preferences, err := savePreferences(context.TODO(), claims.Sub, func(preferences *Preferences) bool {
+		preferences.Muted = []string{}
+		for _, notificationType := range update.Muted {
+			preferences.mute(notificationType)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Error saving notification preferences to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s muted %d notification types", claims.Sub, len(preferences.Muted))
+	return preferencesResponse(preferences)
+}
+
+// unsubscribeLink returns the notification type and the preferences of the user of an
+// unsubscribe link, from its type and token query parameters, or the response turning it away.
+func unsubscribeLink(request events.APIGatewayProxyRequest) (string, Preferences, string, *events.APIGatewayProxyResponse) {
+	notificationType := request.QueryStringParameters["type"]
+	if !knownType(notificationType) {
+		response, _ := common.CreateErrorResponse(400, "Unknown notification type: "+notificationType)
+		return "", Preferences{}, "", &response
+	}
+	preferences, userId, err := userOfToken(context.TODO(), request.QueryStringParameters["token"])
+	if err != nil {
+		log.Printf("Error getting notification preferences from DynamoDB: %v", err)
+		response, _ := common.CreateErrorResponse(500, "Internal server error")
+		return "", Preferences{}, "", &response
+	}
+	if userId == "" {
+		response, _ := common.CreateErrorResponse(404, "Unsubscribe link not found")
+		return "", Preferences{}, "", &response
+	}
+	return notificationType, preferences, userId, nil
+}
+
+// GetUnsubscribeHandler shows the page of an unsubscribe link, asking to confirm. Opening the
+// link changes nothing, as mail scanners and link previews open it too: the page posts
+// back to the link, which UnsubscribeHandler answers.
+func GetUnsubscribeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("Unsubscribe page request from %s", request.RequestContext.Identity.SourceIP)
+
+	notificationType, preferences, _, errorResponse := unsubscribeLink(request)
+	if errorResponse != nil {
+		return *errorResponse, nil
+	}
+	return unsubscribePage(notificationType, preferences), nil
+}
+
+// unsubscribePage is the page of an unsubscribe link, asking to confirm until the user no
+// longer receives the notifications of the type.
+func unsubscribePage(notificationType string, preferences Preferences) events.APIGatewayProxyResponse {
+	body := "<p>Stop receiving the " + html.EscapeString(notificationType) + " notifications?</p>" +
+		`<form method="post"><button type="submit">Unsubscribe</button></form>`
+	if !preferences.Sends(notificationType) {
+		body = "<p>You no longer receive the " + html.EscapeString(notificationType) + " notifications.</p>"
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "text/html; charset=utf-8", "Cache-Control": "no-store"},
+		Body:       `<!DOCTYPE html><html><head><meta charset="utf-8"><title>Unsubscribe</title></head><body>` + body + "</body></html>",
+	}
+}
+
+// isOneClick reports whether the request is the one-click unsubscribe of a mail client,
+// posting List-Unsubscribe=One-Click (RFC 8058), rather than the form of the page.
+func isOneClick(request events.APIGatewayProxyRequest) bool {
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return false
+		}
+		body = string(decoded)
+	}
+	form, err := url.ParseQuery(body)
+	return err == nil && form.Get("List-Unsubscribe") == "One-Click"
+}
+
+// UnsubscribeHandler mutes the notification type given by the type query parameter for the
+// user of the token query parameter, from the links in the notifications. It is public, the
+// token standing for the user, and answers the POST of the page of the link as well as the
+// one-click unsubscribe of the mail clients (RFC 8058). The page gets the confirmation page
+// back, the mail clients the preferences as JSON.
+func UnsubscribeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("Unsubscribe request from %s", request.RequestContext.Identity.SourceIP)
+
+	notificationType, _, userId, errorResponse := unsubscribeLink(request)
+	if errorResponse != nil {
+		return *errorResponse, nil
+	}
+
+	preferences, err := savePreferences(context.TODO(), userId, func(preferences *Preferences) bool {
+		return preferences.mute(notificationType)
+	})
+	if err != nil {
+		log.Printf("Error saving notification preferences to DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	log.Printf("User %s unsubscribed from %s", userId, notificationType)
+
+	if !isOneClick(request) {
+		return unsubscribePage(notificationType, preferences), nil
+	}
+	return preferencesResponse(preferences)
+}
@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrentSecret is a DynamoDB client on which the unsubscribe secret of the user is
+// created right after their preferences are first read.
+type concurrentSecret struct {
+	*testutil.FakeDynamoDB
+	created bool
+}
+
+func (c *concurrentSecret) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	result, err := c.FakeDynamoDB.GetItem(ctx, params, optFns...)
+	if err != nil || c.created || aws.ToString(params.TableName) != "notification-preferences" {
+		return result, err
+	}
+	c.created = true
+	item := map[string]types.AttributeValue{
+		"userId":            params.Key["userId"],
+		"unsubscribeSecret": &types.AttributeValueMemberS{Value: "secret"},
+		"version":           &types.AttributeValueMemberN{Value: "1"},
+	}
+	if result.Item != nil {
+		item = maps.Clone(result.Item)
+		item["unsubscribeSecret"] = &types.AttributeValueMemberS{Value: "secret"}
+	}
+	_, err = c.FakeDynamoDB.PutItem(ctx, &dynamodb.PutItemInput{TableName: params.TableName, Item: item})
+	return result, err
+}
+
+func TestPutPreferencesHandlerKeepsSecret(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = &concurrentSecret{FakeDynamoDB: fake}
+
+	// The preferences read before the secret was created don't drop it
+	response, err := PutPreferencesHandler(testutil.NewRequest("PUT", "/notifications/preferences").
+		WithClaims("user-1", "alice").
+		WithJSONBody(t, Preferences{Muted: []string{"WEEKLY_DIGEST"}}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	preferences, err := GetPreferences(context.TODO(), "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", preferences.UnsubscribeSecret)
+	assert.Equal(t, []string{"WEEKLY_DIGEST"}, preferences.Muted)
+}
+
+func TestUnsubscribeHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+	UnsubscribeURL = "https://api.example.com/public/unsubscribe"
+	defer func() { UnsubscribeURL = "" }()
+
+	unsubscribe := func(notificationType, body string) events.APIGatewayProxyResponse {
+		link, err := UnsubscribeLink(context.TODO(), "user-1", notificationType)
+		assert.NoError(t, err)
+		parsed, err := url.Parse(link)
+		assert.NoError(t, err)
+		response, err := UnsubscribeHandler(testutil.NewRequest("POST", "/public/unsubscribe").
+			WithQueryParam("token", parsed.Query().Get("token")).
+			WithQueryParam("type", notificationType).
+			WithBody(body).
+			Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		return response
+	}
+
+	// The form of the page gets the confirmation page back
+	response := unsubscribe("WEEKLY_DIGEST", "")
+	assert.Equal(t, "text/html; charset=utf-8", response.Headers["Content-Type"])
+	assert.Contains(t, response.Body, "You no longer receive the WEEKLY_DIGEST notifications.")
+
+	// The one-click unsubscribe of the mail clients gets the preferences
+	response = unsubscribe("EXPENSE_APPROVED", "List-Unsubscribe=One-Click")
+	assert.Equal(t, "application/json", response.Headers["Content-Type"])
+	var preferences Preferences
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &preferences))
+	assert.Equal(t, []string{"EXPENSE_APPROVED", "WEEKLY_DIGEST"}, preferences.Muted)
+}
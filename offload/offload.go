@@ -0,0 +1,164 @@
+// Package offload keeps responses under the 6MB payload limit of Lambda: responses close to
+// it are stored in S3 and replaced with a redirect to a presigned URL of the stored body.
+package offload
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// PayloadLimit is the largest response Lambda returns through API Gateway, in bytes.
+const PayloadLimit = 6 << 20
+
+// Threshold is the size of the Lambda response above which the body is offloaded. It leaves
+// room for the headers and the escaping of the body in the response document.
+const Threshold = PayloadLimit - 512<<10
+
+// URLExpiry is how long the presigned URLs of the offloaded bodies are valid.
+const URLExpiry = 15 * time.Minute
+
+// keyPrefix is where the offloaded bodies are stored in the bucket. A lifecycle rule of the
+// bucket is expected to expire them.
+const keyPrefix = "responses/"
+
+// S3API defines the S3 operations used to offload responses, the multipart uploads being
+// those of the streamed ones. This allows for mocking the client in tests.
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// PresignAPI defines the presigning of the URLs of the offloaded bodies.
+type PresignAPI interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+var S3Client S3API
+var Presigner PresignAPI
+
+// Bucket is the S3 bucket the bodies are offloaded to. Offloading is disabled without one.
+var Bucket string
+
+// Offloaded is the body of the redirect to an offloaded response.
+type Offloaded struct {
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+// responseSize returns the size of the response document Lambda would return.
+func responseSize(response events.APIGatewayProxyResponse) int {
+	// Only small bodies can be told apart cheaply, larger ones are marshalled to account
+	// for their escaping
+	if len(response.Body) < Threshold/2 {
+		return len(response.Body)
+	}
+	document, err := json.Marshal(response)
+	if err != nil {
+		return len(response.Body)
+	}
+	return len(document)
+}
+
+// Large offloads the responses of the handler that would exceed the payload limit, for
+// the exports and listings whose size isn't bounded. The redirect is a 303 to the presigned
+// URL, so clients following redirects get the body transparently.
+func Large(next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+		if err != nil || response.StatusCode != 200 || responseSize(response) <= Threshold {
+			return response, err
+		}
+
+		if Bucket == "" {
+			log.Printf("Error: response of %s %s is %d bytes and offloading is disabled", request.HTTPMethod, api.LoggedPath(request.Path), len(response.Body))
+			return common.CreateErrorResponse(500, "Response too large")
+		}
+		offloaded, err := store(context.TODO(), response)
+		if err != nil {
+			log.Printf("Error offloading response: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		log.Printf("Offloaded %d bytes response of %s %s", len(response.Body), request.HTTPMethod, api.LoggedPath(request.Path))
+		return redirect(offloaded)
+	}
+}
+
+// redirect answers with a 303 to the offloaded body.
+func redirect(offloaded *Offloaded) (events.APIGatewayProxyResponse, error) {
+	// Marshal the offloaded response into JSON for the payload
+	payload, err := json.Marshal(offloaded)
+	if err != nil {
+		log.Println("Error marshalling offloaded response:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 303,
+		Headers: map[string]string{
+			"Content-Type":  "application/json",
+			"Location":      offloaded.URL,
+			"Cache-Control": "no-store",
+		},
+		Body: string(payload),
+	}, nil
+}
+
+// store saves the body of the response in the bucket and presigns its URL.
+func store(ctx context.Context, response events.APIGatewayProxyResponse) (*Offloaded, error) {
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	contentType := "application/json"
+	for name, value := range response.Headers {
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+	}
+
+	key := keyPrefix + uuid.New().String()
+	_, err := S3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(Bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(body),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(int64(len(body))),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return presign(ctx, key)
+}
+
+// presign returns the presigned URL of the stored body.
+func presign(ctx context.Context, key string) (*Offloaded, error) {
+	expiresAt := time.Now().Add(URLExpiry)
+	presigned, err := Presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(URLExpiry))
+	if err != nil {
+		return nil, err
+	}
+	return &Offloaded{URL: presigned.URL, ExpiresAt: expiresAt.UTC().Format(time.RFC3339)}, nil
+}
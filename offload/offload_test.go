@@ -0,0 +1,103 @@
+package offload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockS3Client struct {
+	input *s3.PutObjectInput
+	body  []byte
+
+	// The multipart upload, its parts and whether it was completed or aborted
+	upload    *s3.CreateMultipartUploadInput
+	parts     [][]byte
+	completed *s3.CompleteMultipartUploadInput
+	aborted   bool
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	m.input = params
+	m.body, _ = io.ReadAll(params.Body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mockS3Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	m.upload = params
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (m *mockS3Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	part, _ := io.ReadAll(params.Body)
+	m.parts = append(m.parts, part)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", aws.ToInt32(params.PartNumber)))}, nil
+}
+
+func (m *mockS3Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	m.completed = params
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *mockS3Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	m.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+type mockPresigner struct{}
+
+func (mockPresigner) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return &v4.PresignedHTTPRequest{URL: "https://" + aws.ToString(params.Bucket) + ".s3.amazonaws.com/" + aws.ToString(params.Key) + "?X-Amz-Signature=sig"}, nil
+}
+
+// bodyHandler returns a handler answering with the body.
+func bodyHandler(body string) func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       body,
+		}, nil
+	}
+}
+
+func TestLarge(t *testing.T) {
+	client := &mockS3Client{}
+	S3Client, Presigner, Bucket = client, mockPresigner{}, "offload-bucket"
+	t.Cleanup(func() { Bucket = "" })
+
+	// Small responses are returned as they are
+	response, err := Large(bodyHandler(`["small"]`))(events.APIGatewayProxyRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Nil(t, client.input)
+
+	// Bodies whose escaping would exceed the limit are offloaded
+	large := `[` + strings.Repeat(`"\"",`, Threshold/5) + `""]`
+	response, err = Large(bodyHandler(large))(events.APIGatewayProxyRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, 303, response.StatusCode)
+	assert.Equal(t, large, string(client.body))
+	assert.Equal(t, "application/json", aws.ToString(client.input.ContentType))
+	assert.True(t, strings.HasPrefix(aws.ToString(client.input.Key), "responses/"))
+
+	var offloaded Offloaded
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &offloaded))
+	assert.Equal(t, offloaded.URL, response.Headers["Location"])
+	assert.Contains(t, offloaded.URL, aws.ToString(client.input.Key))
+
+	// Without a bucket, the response is rejected rather than failing in Lambda
+	Bucket = ""
+	response, err = Large(bodyHandler(large))(events.APIGatewayProxyRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, 500, response.StatusCode)
+}
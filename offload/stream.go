@@ -0,0 +1,199 @@
+package offload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+// PartSize is the size of the parts of the streamed bodies uploaded to the bucket, above the
+// 5MB S3 requires of every part but the last.
+const PartSize = 8 << 20
+
+// errDisabled rejects the streamed bodies growing past Threshold without a bucket.
+var errDisabled = errors.New("response too large and offloading is disabled")
+
+// Writer streams a response body of unbounded size, e.g. an export written a page of items
+// at a time. The body is held in memory while it may still be returned as it is; past
+// Threshold it is uploaded to the bucket in parts of PartSize, so at most a part is held in
+// memory, and the response redirects to it like those offloaded by Large.
+//
+// Response must be called once the body is written, or Abort when the writing failed, so no
+// upload is left incomplete.
+type Writer struct {
+	ctx      context.Context
+	headers  map[string]string
+	buffer   bytes.Buffer
+	written  int
+	key      string
+	uploadId string
+	parts    []types.CompletedPart
+	err      error
+}
+
+// NewWriter creates a Writer of a response with the headers, e.g. its Content-Type. The
+// Content-Type and Content-Disposition are those of the uploaded body too.
+func NewWriter(ctx context.Context, headers map[string]string) *Writer {
+	return &Writer{ctx: ctx, headers: headers}
+}
+
+// header returns the value of the header, whatever its case.
+func (w *Writer) header(name string) string {
+	for key, value := range w.headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Write appends to the body, uploading the parts complete once it is offloaded.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buffer.Write(p)
+	w.written += len(p)
+
+	if w.uploadId == "" {
+		if w.buffer.Len() <= Threshold {
+			return len(p), nil
+		}
+		if w.err = w.start(); w.err != nil {
+			return 0, w.err
+		}
+	}
+	for w.buffer.Len() >= PartSize {
+		if w.err = w.uploadPart(w.buffer.Next(PartSize)); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// start creates the multipart upload of the body.
+func (w *Writer) start() error {
+	if Bucket == "" {
+		return errDisabled
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(Bucket),
+		Key:         aws.String(keyPrefix + uuid.New().String()),
+		ContentType: aws.String("application/json"),
+	}
+	if contentType := w.header("Content-Type"); contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if disposition := w.header("Content-Disposition"); disposition != "" {
+		input.ContentDisposition = aws.String(disposition)
+	}
+	output, err := S3Client.CreateMultipartUpload(w.ctx, input)
+	if err != nil {
+		return err
+	}
+	w.key, w.uploadId = aws.ToString(input.Key), aws.ToString(output.UploadId)
+	return nil
+}
+
+// uploadPart uploads the next part of the body.
+func (w *Writer) uploadPart(part []byte) error {
+	number := int32(len(w.parts) + 1)
+	output, err := S3Client.UploadPart(w.ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(Bucket),
+		Key:           aws.String(w.key),
+		UploadId:      aws.String(w.uploadId),
+		PartNumber:    aws.Int32(number),
+		Body:          bytes.NewReader(part),
+		ContentLength: aws.Int64(int64(len(part))),
+	})
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, types.CompletedPart{ETag: output.ETag, PartNumber: aws.Int32(number)})
+	return nil
+}
+
+// Response returns the body written as a 200, or offloaded like Large does when too large
+// to be returned. A body streamed to the bucket has its last part uploaded and the upload
+// completed.
+func (w *Writer) Response() (events.APIGatewayProxyResponse, error) {
+	if errors.Is(w.err, errDisabled) {
+		log.Printf("Error: streamed response is over %d bytes and offloading is disabled", w.written)
+		return common.CreateErrorResponse(500, "Response too large")
+	}
+	if w.err != nil {
+		log.Printf("Error streaming response: %v", w.err)
+		w.Abort()
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	if w.uploadId == "" {
+		response := events.APIGatewayProxyResponse{StatusCode: 200, Headers: w.headers, Body: w.buffer.String()}
+		if responseSize(response) <= Threshold {
+			return response, nil
+		}
+		if Bucket == "" {
+			log.Printf("Error: streamed response is %d bytes and offloading is disabled", w.written)
+			return common.CreateErrorResponse(500, "Response too large")
+		}
+		offloaded, err := store(w.ctx, response)
+		if err != nil {
+			log.Printf("Error offloading response: %v", err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		log.Printf("Offloaded %d bytes streamed response", w.written)
+		return redirect(offloaded)
+	}
+
+	if w.buffer.Len() > 0 {
+		if err := w.uploadPart(w.buffer.Next(w.buffer.Len())); err != nil {
+			log.Printf("Error uploading the last part of the streamed response: %v", err)
+			w.Abort()
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+	}
+	_, err := S3Client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(Bucket),
+		Key:             aws.String(w.key),
+		UploadId:        aws.String(w.uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+	})
+	if err != nil {
+		log.Printf("Error completing the upload of the streamed response: %v", err)
+		w.Abort()
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	offloaded, err := presign(w.ctx, w.key)
+	if err != nil {
+		log.Printf("Error presigning the streamed response: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	log.Printf("Streamed %d bytes response in %d parts", w.written, len(w.parts))
+	return redirect(offloaded)
+}
+
+// Abort discards the body, and the parts uploaded of it.
+func (w *Writer) Abort() {
+	if w.uploadId == "" {
+		return
+	}
+	_, err := S3Client.AbortMultipartUpload(w.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(Bucket),
+		Key:      aws.String(w.key),
+		UploadId: aws.String(w.uploadId),
+	})
+	if err != nil {
+		log.Printf("Error aborting the upload of the streamed response: %v", err)
+	}
+	w.uploadId = ""
+	w.buffer.Reset()
+}
@@ -0,0 +1,76 @@
+package offload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeLines writes numbered lines of the given size to the writer until it has size bytes.
+func writeLines(t *testing.T, writer *Writer, size int) []byte {
+	var written bytes.Buffer
+	for i := 0; written.Len() < size; i++ {
+		line := fmt.Appendf(nil, "%-1023d\n", i)
+		_, err := writer.Write(line)
+		assert.NoError(t, err)
+		written.Write(line)
+	}
+	return written.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	client := &mockS3Client{}
+	S3Client, Presigner, Bucket = client, mockPresigner{}, "offload-bucket"
+	t.Cleanup(func() { Bucket = "" })
+	headers := map[string]string{"Content-Type": "application/x-ndjson", "Content-Disposition": `attachment; filename="ledger.jsonl"`}
+
+	// Small bodies are returned as they are, without any upload
+	writer := NewWriter(context.TODO(), headers)
+	body := writeLines(t, writer, 10<<10)
+	response, err := writer.Response()
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, string(body), response.Body)
+	assert.Nil(t, client.upload)
+
+	// Larger bodies are uploaded a part at a time as they are written
+	writer = NewWriter(context.TODO(), headers)
+	body = writeLines(t, writer, 2*PartSize+PartSize/2)
+	assert.Len(t, client.parts, 2)
+	assert.Nil(t, client.completed)
+	response, err = writer.Response()
+	assert.NoError(t, err)
+	assert.Equal(t, 303, response.StatusCode)
+	if assert.Len(t, client.parts, 3) {
+		assert.Len(t, client.parts[0], PartSize)
+		assert.Equal(t, body, bytes.Join(client.parts, nil))
+	}
+	assert.Equal(t, "application/x-ndjson", aws.ToString(client.upload.ContentType))
+	assert.Equal(t, `attachment; filename="ledger.jsonl"`, aws.ToString(client.upload.ContentDisposition))
+	if assert.NotNil(t, client.completed) {
+		assert.Len(t, client.completed.MultipartUpload.Parts, 3)
+		assert.Equal(t, "etag-3", aws.ToString(client.completed.MultipartUpload.Parts[2].ETag))
+	}
+	assert.Contains(t, response.Headers["Location"], aws.ToString(client.upload.Key))
+
+	// An export failing halfway discards the parts uploaded
+	*client = mockS3Client{}
+	writer = NewWriter(context.TODO(), headers)
+	writeLines(t, writer, PartSize+1)
+	writer.Abort()
+	assert.True(t, client.aborted)
+	assert.Nil(t, client.completed)
+
+	// Without a bucket, the bodies too large are rejected as soon as they are
+	Bucket = ""
+	writer = NewWriter(context.TODO(), headers)
+	_, err = writer.Write(make([]byte, Threshold+1))
+	assert.Error(t, err)
+	response, err = writer.Response()
+	assert.NoError(t, err)
+	assert.Equal(t, 500, response.StatusCode)
+}
@@ -0,0 +1,280 @@
+// Package openapi validates the JSON responses of the API against its published OpenAPI
+// spec, logging the violations, so the drift between the Go structs and the spec is caught
+// in staging. It is enabled with DEBUG_RESPONSE_VALIDATION outside of production only.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"vassistant-backend/api"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// productionStages are the values of STAGE in which DEBUG_RESPONSE_VALIDATION is ignored.
+var productionStages = []string{"prod", "production"}
+
+// Spec is the part of an OpenAPI 3 document the responses are validated against. The paths
+// are relative to the base path of the router, like the templates of its routes.
+type Spec struct {
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// Operation is a method of a path of the spec.
+type Operation struct {
+	Responses map[string]Response `json:"responses"`
+}
+
+// Response is a documented response of an operation, keyed by its status code, a range like
+// 2XX or default.
+type Response struct {
+	Content map[string]struct {
+		Schema *Schema `json:"schema"`
+	} `json:"content"`
+}
+
+// Schema is the subset of JSON Schema the responses are validated with. The oneOf schemas
+// are validated like anyOf, the formats and the numeric and length bounds are ignored.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaType         `json:"type"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []any              `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	AllOf                []*Schema          `json:"allOf"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	OneOf                []*Schema          `json:"oneOf"`
+}
+
+// schemaType is the type of a schema, a single type in OpenAPI 3.0 and possibly several,
+// e.g. ["string", "null"], in 3.1.
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaType{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// Parse parses an OpenAPI document in JSON.
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document, %w", err)
+	}
+	return &spec, nil
+}
+
+// FromEnv loads the spec from the file named by DEBUG_RESPONSE_VALIDATION, or returns nil
+// when it is unset or the stage is a production one.
+func FromEnv() (*Spec, error) {
+	path := os.Getenv("DEBUG_RESPONSE_VALIDATION")
+	if path == "" {
+		return nil, nil
+	}
+	stage := strings.ToLower(os.Getenv("STAGE"))
+	if slices.Contains(productionStages, stage) {
+		log.Printf("Warning: ignoring DEBUG_RESPONSE_VALIDATION in stage %s", stage)
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Warning: validating the responses against %s", path)
+	return spec, nil
+}
+
+// Middleware logs the violations of the JSON responses of the routes against the spec,
+// returning them unchanged.
+func (s *Spec) Middleware(route api.Route, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		response, err := next(request)
+		if err != nil || response.IsBase64Encoded || !strings.HasPrefix(response.Headers["Content-Type"], "application/json") {
+			return response, err
+		}
+		for _, violation := range s.Validate(route.Method, route.Template, response.StatusCode, []byte(response.Body)) {
+			log.Printf("Response schema violation on %s %s %d: %s", route.Method, route.Template, response.StatusCode, violation)
+		}
+		return response, nil
+	}
+}
+
+// Validate returns the violations of the JSON response body against the schema documented
+// for the route and status code, the missing ones being violations too.
+func (s *Spec) Validate(method, template string, statusCode int, body []byte) []string {
+	operation, ok := s.Paths[template][strings.ToLower(method)]
+	if !ok {
+		return []string{"the route is not documented"}
+	}
+	status := strconv.Itoa(statusCode)
+	response, ok := operation.Responses[status]
+	if !ok {
+		response, ok = operation.Responses[status[:1]+"XX"]
+	}
+	if !ok {
+		response, ok = operation.Responses["default"]
+	}
+	if !ok {
+		return []string{"the status code is not documented"}
+	}
+	content, ok := response.Content["application/json"]
+	if !ok || content.Schema == nil {
+		return nil
+	}
+
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return []string{"the body is not valid JSON"}
+	}
+	var violations []string
+	s.validate(content.Schema, value, "$", &violations, 0)
+	return violations
+}
+
+// maxRefDepth bounds the references followed, the schemas of a spec being able to refer to
+// themselves.
+const maxRefDepth = 32
+
+func (s *Spec) validate(schema *Schema, value any, path string, violations *[]string, depth int) {
+	if schema.Ref != "" {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		referenced := s.Components.Schemas[name]
+		if !ok || referenced == nil {
+			*violations = append(*violations, fmt.Sprintf("%s: unknown schema %s", path, schema.Ref))
+			return
+		}
+		if depth < maxRefDepth {
+			s.validate(referenced, value, path, violations, depth+1)
+		}
+		return
+	}
+
+	if value == nil {
+		if !schema.Nullable && len(schema.Type) > 0 && !slices.Contains(schema.Type, "null") {
+			*violations = append(*violations, fmt.Sprintf("%s: expected %s, got null", path, strings.Join(schema.Type, " or ")))
+		}
+		return
+	}
+	if len(schema.Type) > 0 && !slices.ContainsFunc(schema.Type, func(t string) bool { return hasType(value, t) }) {
+		*violations = append(*violations, fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(schema.Type, " or "), typeOf(value)))
+		return
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(allowed any) bool { return reflect.DeepEqual(allowed, value) }) {
+		*violations = append(*violations, fmt.Sprintf("%s: %v is not one of the allowed values", path, value))
+	}
+
+	for _, all := range schema.AllOf {
+		s.validate(all, value, path, violations, depth)
+	}
+	if alternatives := append(slices.Clone(schema.AnyOf), schema.OneOf...); len(alternatives) > 0 {
+		matched := slices.ContainsFunc(alternatives, func(alternative *Schema) bool {
+			var alternativeViolations []string
+			s.validate(alternative, value, path, &alternativeViolations, depth)
+			return len(alternativeViolations) == 0
+		})
+		if !matched {
+			*violations = append(*violations, fmt.Sprintf("%s: matches none of the alternative schemas", path))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := value[name]; !ok {
+				*violations = append(*violations, fmt.Sprintf("%s: missing required property %s", path, name))
+			}
+		}
+		additional := additionalProperties(schema)
+		for _, name := range slices.Sorted(maps.Keys(value)) {
+			property, ok := schema.Properties[name]
+			if !ok {
+				property = additional
+			}
+			if property == nil {
+				if string(schema.AdditionalProperties) == "false" {
+					*violations = append(*violations, fmt.Sprintf("%s: unexpected property %s", path, name))
+				}
+				continue
+			}
+			s.validate(property, value[name], path+"."+name, violations, depth)
+		}
+	case []any:
+		if schema.Items != nil {
+			for i, item := range value {
+				s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), violations, depth)
+			}
+		}
+	}
+}
+
+// additionalProperties returns the schema of the properties not in the properties of the
+// schema, nil when they are either allowed or not.
+func additionalProperties(schema *Schema) *Schema {
+	if len(schema.AdditionalProperties) == 0 || schema.AdditionalProperties[0] != '{' {
+		return nil
+	}
+	var additional Schema
+	decoder := json.NewDecoder(bytes.NewReader(schema.AdditionalProperties))
+	decoder.UseNumber()
+	if err := decoder.Decode(&additional); err != nil {
+		return nil
+	}
+	return &additional
+}
+
+// hasType reports whether the decoded JSON value is of the JSON Schema type.
+func hasType(value any, t string) bool {
+	switch t {
+	case "integer":
+		number, ok := value.(json.Number)
+		_, err := number.Int64()
+		return ok && err == nil
+	}
+	return typeOf(value) == t
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value.
+func typeOf(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+	"vassistant-backend/api"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSpec = `{
+  "paths": {
+    "/financial/groups/{groupId}/expenses": {
+      "get": {
+        "responses": {
+          "200": {"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Expense"}}}}},
+          "4XX": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Expense": {
+        "type": "object",
+        "required": ["expenseId", "amount"],
+        "additionalProperties": false,
+        "properties": {
+          "expenseId": {"type": "string"},
+          "amount": {"type": "string"},
+          "version": {"type": "integer"},
+          "splitType": {"type": "string", "enum": ["EQUAL", "PERCENTAGE"]},
+          "note": {"type": ["string", "null"]},
+          "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+        }
+      },
+      "Error": {"type": "object", "required": ["error"], "properties": {"error": {"type": "string"}}}
+    }
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	spec, err := Parse([]byte(testSpec))
+	assert.NoError(t, err)
+	template := "/financial/groups/{groupId}/expenses"
+
+	valid := `[{"expenseId": "e1", "amount": "12.50", "version": 2, "splitType": "EQUAL", "note": null, "labels": {"en": "Food"}}]`
+	assert.Empty(t, spec.Validate("GET", template, 200, []byte(valid)))
+	assert.Empty(t, spec.Validate("GET", template, 404, []byte(`{"error": "Group not found"}`)))
+
+	invalid := `[{"expenseId": "e1", "amount": 12.5, "version": 2.5, "splitType": "SHARES", "labels": {"en": 1}, "catagory": "FOOD"}, {"amount": "1"}]`
+	assert.Equal(t, []string{
+		"$[0].amount: expected string, got number",
+		"$[0]: unexpected property catagory",
+		"$[0].labels.en: expected string, got number",
+		"$[0].splitType: SHARES is not one of the allowed values",
+		"$[0].version: expected integer, got number",
+		"$[1]: missing required property expenseId",
+	}, spec.Validate("GET", template, 200, []byte(invalid)))
+
+	// The undocumented routes and status codes are violations too
+	assert.Equal(t, []string{"the route is not documented"}, spec.Validate("POST", template, 201, []byte(`{}`)))
+	assert.Equal(t, []string{"the status code is not documented"}, spec.Validate("GET", template, 500, []byte(`{}`)))
+}
+
+func TestMiddleware(t *testing.T) {
+	spec, err := Parse([]byte(testSpec))
+	assert.NoError(t, err)
+
+	// The responses are returned unchanged, violations or not
+	router := api.NewRouter()
+	router.Use(spec.Middleware)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/expenses", func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Headers: map[string]string{"Content-Type": "application/json"}, Body: `[{"amount": 1}]`}, nil
+	})
+	response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/financial/groups/group-1/expenses"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, `[{"amount": 1}]`, response.Body)
+}
@@ -0,0 +1,310 @@
+// Package plans holds the plan of every user, free or premium, stored on their user record,
+// and the entitlements each plan gives: how many messages the assistant answers a month, how
+// many groups the user can be in, and which exports they can set up. The features check the
+// entitlements before running, and answer with the upgrade the user needs otherwise.
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The plans
+const (
+	Free    = "FREE"
+	Premium = "PREMIUM"
+)
+
+// The features limited by the plans
+const (
+	// Messages are the messages the assistant answers in a calendar month.
+	Messages = "messages"
+	// Groups are the groups the user is a member of.
+	Groups = "groups"
+)
+
+// The exports the plans may include
+const (
+	GoogleSheetsExport = "google-sheets"
+)
+
+// Error codes of the responses refusing what the plan doesn't allow
+const (
+	// LimitCode is the code of the 402 responses of the features whose limit is reached.
+	LimitCode = "plan_limit_reached"
+	// UpgradeCode is the code of the 403 responses of the features the plan doesn't include.
+	UpgradeCode = "upgrade_required"
+)
+
+// ErrUnknownPlan is returned when setting a plan that doesn't exist.
+var ErrUnknownPlan = errors.New("unknown plan")
+
+// ErrUserNotFound is returned when setting the plan of a user without a user record.
+var ErrUserNotFound = errors.New("user not found")
+
+// Handlers reads and writes the plans on the vassistant-users table with its client.
+type Handlers struct {
+	client common.DynamoDBAPI
+}
+
+// NewHandlers returns the plan handlers reading and writing the plans with the client.
+func NewHandlers(client common.DynamoDBAPI) *Handlers {
+	return &Handlers{client: client}
+}
+
+// Enforced turns the entitlement checks on. Until then every feature is allowed, whatever
+// the plan of the user.
+var Enforced bool
+
+// Entitlements are what a plan allows. A limit of 0 is unlimited.
+type Entitlements struct {
+	MonthlyMessages int      `json:"monthlyMessages"`
+	MaxGroups       int      `json:"maxGroups"`
+	Exports         []string `json:"exports"`
+}
+
+// Tiers are the entitlements of each plan.
+var Tiers = map[string]Entitlements{
+	Free:    {MonthlyMessages: 100, MaxGroups: 3, Exports: []string{}},
+	Premium: {MonthlyMessages: 3000, MaxGroups: 0, Exports: []string{GoogleSheetsExport}},
+}
+
+// Plan is the plan of a user with its entitlements. The bonus messages granted to the user,
+// e.g. for their referrals, are counted in the monthly messages of the entitlements.
+type Plan struct {
+	Name          string       `json:"plan"`
+	Entitlements  Entitlements `json:"entitlements"`
+	BonusMessages int          `json:"bonusMessages,omitempty"`
+	UpdatedAt     string       `json:"updatedAt,omitempty"`
+}
+
+// LimitError is returned by the checks refusing a feature. Limit is 0 when the plan doesn't
+// include the feature at all.
+type LimitError struct {
+	Plan    string
+	Feature string
+	Limit   int
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == 0 {
+		return fmt.Sprintf("%s is not included in the %s plan", e.Feature, e.Plan)
+	}
+	return fmt.Sprintf("the %s plan is limited to %d %s", e.Plan, e.Limit, e.Feature)
+}
+
+// Get returns the plan of the user, the free plan if their record names none.
+func (h *Handlers) Get(ctx context.Context, userId string) (Plan, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("vassistant-users"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ProjectionExpression: aws.String("#plan, planUpdatedAt, bonusMessages"),
+		ExpressionAttributeNames: map[string]string{
+			"#plan": "plan",
+		},
+	})
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{Name: Free}
+	if name, ok := result.Item["plan"].(*types.AttributeValueMemberS); ok {
+		if _, known := Tiers[name.Value]; known {
+			plan.Name = name.Value
+		}
+	}
+	if updatedAt, ok := result.Item["planUpdatedAt"].(*types.AttributeValueMemberS); ok {
+		plan.UpdatedAt = updatedAt.Value
+	}
+	plan.Entitlements = Tiers[plan.Name]
+	if bonus, ok := result.Item["bonusMessages"].(*types.AttributeValueMemberN); ok {
+		plan.BonusMessages, _ = strconv.Atoi(bonus.Value)
+		if plan.Entitlements.MonthlyMessages > 0 {
+			plan.Entitlements.MonthlyMessages += plan.BonusMessages
+		}
+	}
+	return plan, nil
+}
+
+// Set changes the plan of the user on their record, keeping the rest of it.
+func (h *Handlers) Set(ctx context.Context, userId, name, updatedBy string, now time.Time) (Plan, error) {
+	if _, ok := Tiers[name]; !ok {
+		return Plan{}, ErrUnknownPlan
+	}
+	plan := Plan{Name: name, Entitlements: Tiers[name], UpdatedAt: now.UTC().Format(time.RFC3339)}
+	err := h.updateUser(ctx, userId, func(item map[string]types.AttributeValue) bool {
+		setPlan(item, plan, updatedBy)
+		return true
+	})
+	if err != nil {
+		return Plan{}, err
+	}
+	return plan, nil
+}
+
+// GrantBonusMessages adds messages to the monthly messages of the user, whatever their plan.
+func (h *Handlers) GrantBonusMessages(ctx context.Context, userId string, count int) error {
+	return h.updateUser(ctx, userId, func(item map[string]types.AttributeValue) bool {
+		bonus := 0
+		if value, ok := item["bonusMessages"].(*types.AttributeValueMemberN); ok {
+			bonus, _ = strconv.Atoi(value.Value)
+		}
+		item["bonusMessages"] = &types.AttributeValueMemberN{Value: strconv.Itoa(bonus + count)}
+		return true
+	})
+}
+
+// setPlan writes the plan on the user record.
+func setPlan(item map[string]types.AttributeValue, plan Plan, updatedBy string) {
+	item["plan"] = &types.AttributeValueMemberS{Value: plan.Name}
+	item["planUpdatedAt"] = &types.AttributeValueMemberS{Value: plan.UpdatedAt}
+	item["planUpdatedBy"] = &types.AttributeValueMemberS{Value: updatedBy}
+}
+
+// updateUser changes the record of the user with update, keeping the rest of it. The record
+// isn't written when update returns false.
+func (h *Handlers) updateUser(ctx context.Context, userId string, update func(item map[string]types.AttributeValue) bool) error {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("vassistant-users"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	if result.Item == nil {
+		return ErrUserNotFound
+	}
+	if !update(result.Item) {
+		return nil
+	}
+	_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String("vassistant-users"),
+		Item:      result.Item,
+	})
+	return err
+}
+
+// Allows checks the user may use one more of the limited feature, having used used of it
+// already. It returns a *LimitError when the plan of the user doesn't allow it.
+func (h *Handlers) Allows(ctx context.Context, userId, feature string, used int) error {
+	if !Enforced {
+		return nil
+	}
+	plan, err := h.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	var limit int
+	switch feature {
+	case Messages:
+		limit = plan.Entitlements.MonthlyMessages
+	case Groups:
+		limit = plan.Entitlements.MaxGroups
+	}
+	if limit > 0 && used >= limit {
+		return &LimitError{Plan: plan.Name, Feature: feature, Limit: limit}
+	}
+	return nil
+}
+
+// AllowsExport checks the plan of the user includes the export. It returns a *LimitError
+// when it doesn't.
+func (h *Handlers) AllowsExport(ctx context.Context, userId, export string) error {
+	if !Enforced {
+		return nil
+	}
+	plan, err := h.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(plan.Entitlements.Exports, export) {
+		return &LimitError{Plan: plan.Name, Feature: export}
+	}
+	return nil
+}
+
+// UpgradeHint tells the clients which plan lifts a limit.
+type UpgradeHint struct {
+	CurrentPlan string `json:"currentPlan"`
+	Plan        string `json:"plan"`
+	Feature     string `json:"feature"`
+	Limit       int    `json:"limit,omitempty"`
+}
+
+// UpgradeResponse is the body of the responses refusing what the plan doesn't allow.
+type UpgradeResponse struct {
+	common.ErrorResponse
+	Upgrade UpgradeHint `json:"upgrade"`
+}
+
+// ErrorResponse answers a *LimitError with 402 when a limit of the plan is reached, or 403
+// when the plan doesn't include the feature, with the plan to upgrade to.
+func ErrorResponse(err *LimitError) (events.APIGatewayProxyResponse, error) {
+	statusCode, body := 402, UpgradeResponse{
+		ErrorResponse: common.ErrorResponse{Error: "Plan limit reached, upgrade to continue", Code: LimitCode},
+	}
+	if err.Limit == 0 {
+		statusCode = 403
+		body.ErrorResponse = common.ErrorResponse{Error: "Not included in your plan, upgrade to use it", Code: UpgradeCode}
+	}
+	body.Upgrade = UpgradeHint{CurrentPlan: err.Plan, Plan: Premium, Feature: err.Feature, Limit: err.Limit}
+
+	payload, marshalErr := json.Marshal(body)
+	if marshalErr != nil {
+		log.Println("Error marshalling upgrade response:", marshalErr)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func (h *Handlers) GetPlanHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	plan, err := h.Get(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting plan from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the plan into JSON for the payload
+	payload, err := json.Marshal(plan)
+	if err != nil {
+		log.Println("Error marshalling plan:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
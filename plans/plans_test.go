@@ -0,0 +1,83 @@
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntitlements(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice"},
+			{"userId": "user-2", "username": "bob", "plan": "PREMIUM"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	// Nothing is limited until the entitlements are enforced
+	assert.NoError(t, h.Allows(context.TODO(), "user-1", Messages, 1000))
+	Enforced = true
+	defer func() { Enforced = false }()
+
+	// The users without a plan are on the free plan
+	plan, err := h.Get(context.TODO(), "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, Free, plan.Name)
+	assert.NoError(t, h.Allows(context.TODO(), "user-1", Groups, 2))
+
+	var limitErr *LimitError
+	assert.True(t, errors.As(h.Allows(context.TODO(), "user-1", Groups, 3), &limitErr))
+	assert.Equal(t, LimitError{Plan: Free, Feature: Groups, Limit: 3}, *limitErr)
+	response, err := ErrorResponse(limitErr)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPaymentRequired, response.StatusCode)
+	assert.JSONEq(t, `{"error": "Plan limit reached, upgrade to continue", "code": "plan_limit_reached",
+		"upgrade": {"currentPlan": "FREE", "plan": "PREMIUM", "feature": "groups", "limit": 3}}`, response.Body)
+
+	// The features left out of a plan are forbidden
+	assert.True(t, errors.As(h.AllowsExport(context.TODO(), "user-1", GoogleSheetsExport), &limitErr))
+	response, err = ErrorResponse(limitErr)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Contains(t, response.Body, `"code":"upgrade_required"`)
+
+	// The premium plan lifts them
+	assert.NoError(t, h.AllowsExport(context.TODO(), "user-2", GoogleSheetsExport))
+	assert.NoError(t, h.Allows(context.TODO(), "user-2", Groups, 50))
+
+	// Changing the plan keeps the rest of the user record
+	now := time.Date(2024, 4, 13, 9, 0, 0, 0, time.UTC)
+	plan, err = h.Set(context.TODO(), "user-1", Premium, "root", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-04-13T09:00:00Z", plan.UpdatedAt)
+	assert.NoError(t, h.AllowsExport(context.TODO(), "user-1", GoogleSheetsExport))
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/plan").WithClaims("user-1", "alice").Build()
+	response, err = h.GetPlanHandler(request)
+	assert.NoError(t, err)
+	var got Plan
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &got))
+	assert.Equal(t, Premium, got.Name)
+	assert.Equal(t, []string{GoogleSheetsExport}, got.Entitlements.Exports)
+	item, err := fake.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String("vassistant-users"),
+		Key:       map[string]types.AttributeValue{"userId": &types.AttributeValueMemberS{Value: "user-1"}},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, item.Item["username"])
+
+	_, err = h.Set(context.TODO(), "user-1", "GOLD", "root", now)
+	assert.ErrorIs(t, err, ErrUnknownPlan)
+	_, err = h.Set(context.TODO(), "user-3", Premium, "root", now)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
@@ -0,0 +1,227 @@
+package plans
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/integrations/verify"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// The Stripe events changing the subscription of a user
+const (
+	EventSubscriptionCreated = "customer.subscription.created"
+	EventSubscriptionUpdated = "customer.subscription.updated"
+	EventSubscriptionDeleted = "customer.subscription.deleted"
+)
+
+// premiumStatuses are the statuses of the Stripe subscriptions giving the premium plan. A
+// past due subscription keeps it while Stripe retries the payment.
+var premiumStatuses = map[string]bool{"active": true, "trialing": true, "past_due": true}
+
+// StripeWebhook verifies the events of the Stripe webhook endpoint. The webhook is disabled
+// when nil.
+var StripeWebhook *verify.Verifier
+
+// Subscription is the paid subscription of a user and the plan it gives them.
+type Subscription struct {
+	Plan              string       `json:"plan"`
+	Entitlements      Entitlements `json:"entitlements"`
+	Status            string       `json:"status,omitempty"`
+	RenewsAt          string       `json:"renewsAt,omitempty"` // end of the current period
+	CancelAtPeriodEnd bool         `json:"cancelAtPeriodEnd"`
+}
+
+// stripeEvent is the part of a Stripe event the webhook reads.
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object stripeSubscription `json:"object"`
+	} `json:"data"`
+}
+
+// stripeSubscription is the part of a Stripe subscription the webhook reads. The checkout
+// creating it puts the ID of the user in its metadata.
+type stripeSubscription struct {
+	ID                string            `json:"id"`
+	Customer          string            `json:"customer"`
+	Status            string            `json:"status"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+	Items             struct {
+		Data []struct {
+			CurrentPeriodEnd int64 `json:"current_period_end"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// periodEnd returns the end of the current period of the subscription, read from its items
+// in the API versions that moved it there.
+func (s stripeSubscription) periodEnd() int64 {
+	if s.CurrentPeriodEnd == 0 && len(s.Items.Data) > 0 {
+		return s.Items.Data[0].CurrentPeriodEnd
+	}
+	return s.CurrentPeriodEnd
+}
+
+// GetSubscription returns the subscription of the user, with only the plan if they never
+// subscribed.
+func (h *Handlers) GetSubscription(ctx context.Context, userId string) (Subscription, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("vassistant-users"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+		ProjectionExpression: aws.String("#plan, subscriptionStatus, subscriptionRenewsAt, subscriptionCancelAtPeriodEnd"),
+		ExpressionAttributeNames: map[string]string{
+			"#plan": "plan",
+		},
+	})
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	subscription := Subscription{Plan: Free}
+	if name, ok := result.Item["plan"].(*types.AttributeValueMemberS); ok {
+		if _, known := Tiers[name.Value]; known {
+			subscription.Plan = name.Value
+		}
+	}
+	if status, ok := result.Item["subscriptionStatus"].(*types.AttributeValueMemberS); ok {
+		subscription.Status = status.Value
+	}
+	if renewsAt, ok := result.Item["subscriptionRenewsAt"].(*types.AttributeValueMemberS); ok {
+		subscription.RenewsAt = renewsAt.Value
+	}
+	if cancel, ok := result.Item["subscriptionCancelAtPeriodEnd"].(*types.AttributeValueMemberBOOL); ok {
+		subscription.CancelAtPeriodEnd = cancel.Value
+	}
+	subscription.Entitlements = Tiers[subscription.Plan]
+	return subscription, nil
+}
+
+// applyStripeEvent records the subscription of the event on the record of its user and moves
+// them to the plan it gives. Stripe doesn't deliver the events in order, so the events older
+// than the last one applied are ignored; it reports whether the event was applied.
+func (h *Handlers) applyStripeEvent(ctx context.Context, userId string, event stripeEvent, now time.Time) (bool, error) {
+	subscription := event.Data.Object
+	plan := Plan{Name: Free, UpdatedAt: now.UTC().Format(time.RFC3339)}
+	if event.Type != EventSubscriptionDeleted && premiumStatuses[subscription.Status] {
+		plan.Name = Premium
+	}
+
+	applied := false
+	err := h.updateUser(ctx, userId, func(item map[string]types.AttributeValue) bool {
+		if last, ok := item["subscriptionEventAt"].(*types.AttributeValueMemberN); ok {
+			if at, err := strconv.ParseInt(last.Value, 10, 64); err == nil && event.Created < at {
+				return false
+			}
+		}
+
+		setPlan(item, plan, "stripe")
+		item["stripeCustomerId"] = &types.AttributeValueMemberS{Value: subscription.Customer}
+		item["stripeSubscriptionId"] = &types.AttributeValueMemberS{Value: subscription.ID}
+		item["subscriptionStatus"] = &types.AttributeValueMemberS{Value: subscription.Status}
+		item["subscriptionCancelAtPeriodEnd"] = &types.AttributeValueMemberBOOL{Value: subscription.CancelAtPeriodEnd}
+		item["subscriptionEventAt"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(event.Created, 10)}
+		delete(item, "subscriptionRenewsAt")
+		if end := subscription.periodEnd(); end > 0 && event.Type != EventSubscriptionDeleted {
+			item["subscriptionRenewsAt"] = &types.AttributeValueMemberS{Value: time.Unix(end, 0).UTC().Format(time.RFC3339)}
+		}
+		applied = true
+		return true
+	})
+	return applied, err
+}
+
+// StripeWebhookHandler receives the events of the Stripe webhook endpoint, verified by
+// StripeWebhook, and updates the plan of the users on their subscription events. The other
+// events are acknowledged and ignored, and so are the events Stripe couldn't do better by
+// retrying, like those of subscriptions without a known user.
+func (h *Handlers) StripeWebhookHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	if StripeWebhook == nil {
+		return common.CreateErrorResponse(503, "Stripe webhook is not configured")
+	}
+	return verify.Verified(StripeWebhook, h.handleStripeEvent)(request)
+}
+
+func (h *Handlers) handleStripeEvent(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var event stripeEvent
+	if err := json.Unmarshal([]byte(request.Body), &event); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+
+	switch event.Type {
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionDeleted:
+		userId := event.Data.Object.Metadata["userId"]
+		if userId == "" {
+			log.Printf("Ignored Stripe event %s of subscription %s without a userId", event.ID, event.Data.Object.ID)
+			break
+		}
+		applied, err := h.applyStripeEvent(context.TODO(), userId, event, time.Now())
+		if errors.Is(err, ErrUserNotFound) {
+			log.Printf("Ignored Stripe event %s of unknown user %s", event.ID, userId)
+			break
+		}
+		if err != nil {
+			log.Printf("Error applying Stripe event %s: %v", event.ID, err)
+			return common.CreateErrorResponse(500, "Internal server error")
+		}
+		if !applied {
+			log.Printf("Ignored Stripe event %s older than the subscription of user %s", event.ID, userId)
+			break
+		}
+		log.Printf("Subscription %s of user %s is %s after Stripe event %s", event.Data.Object.ID, userId, event.Data.Object.Status, event.ID)
+	default:
+		log.Printf("Ignored Stripe event %s of type %s", event.ID, event.Type)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       `{"received":true}`,
+	}, nil
+}
+
+func (h *Handlers) GetSubscriptionHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	subscription, err := h.GetSubscription(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting subscription from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the subscription into JSON for the payload
+	payload, err := json.Marshal(subscription)
+	if err != nil {
+		log.Println("Error marshalling subscription:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,92 @@
+package plans
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+	"vassistant-backend/integrations/verify"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+// stripeRequest returns the delivery of the event signed like Stripe does.
+func stripeRequest(secret, body string) events.APIGatewayProxyRequest {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+	return testutil.NewRequest("POST", "/VassistantBackendProxy/integrations/stripe/webhook").
+		WithHeader("Stripe-Signature", "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil))).
+		WithBody(body).
+		Build()
+}
+
+// subscriptionEvent returns a Stripe event of the subscription of user-1.
+func subscriptionEvent(id, eventType string, created int64, status string) string {
+	return fmt.Sprintf(`{"id":%q,"type":%q,"created":%d,"data":{"object":{"id":"sub_1","customer":"cus_1","status":%q,`+
+		`"current_period_end":1717200000,"cancel_at_period_end":false,"metadata":{"userId":"user-1"}}}}`, id, eventType, created, status)
+}
+
+func TestStripeWebhookHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"vassistant-users": {{"userId": "user-1", "username": "alice"}},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	post := func(request events.APIGatewayProxyRequest) int {
+		response, err := h.StripeWebhookHandler(request)
+		assert.NoError(t, err)
+		return response.StatusCode
+	}
+	subscription := func() Subscription {
+		response, err := h.GetSubscriptionHandler(testutil.NewRequest("GET", "/VassistantBackendProxy/users/me/subscription").
+			WithClaims("user-1", "alice").
+			Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var subscription Subscription
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &subscription))
+		return subscription
+	}
+
+	// The webhook is off until its secret is configured
+	assert.Equal(t, http.StatusServiceUnavailable, post(stripeRequest("whsec_test", "{}")))
+	StripeWebhook = verify.NewVerifier("stripe", verify.Stripe("whsec_test"), verify.NewMemoryReplayCache())
+	defer func() { StripeWebhook = nil }()
+	assert.Equal(t, Subscription{Plan: Free, Entitlements: Tiers[Free]}, subscription())
+
+	// Forged events are rejected
+	assert.Equal(t, http.StatusUnauthorized, post(stripeRequest("whsec_other", subscriptionEvent("evt_0", EventSubscriptionCreated, 100, "active"))))
+	assert.Equal(t, Free, subscription().Plan)
+
+	// An active subscription gives the premium plan until its renewal
+	assert.Equal(t, http.StatusOK, post(stripeRequest("whsec_test", subscriptionEvent("evt_1", EventSubscriptionCreated, 100, "active"))))
+	current := subscription()
+	assert.Equal(t, Premium, current.Plan)
+	assert.Equal(t, "active", current.Status)
+	assert.Equal(t, "2024-06-01T00:00:00Z", current.RenewsAt)
+
+	// The events delivered out of order don't undo the newer ones
+	assert.Equal(t, http.StatusOK, post(stripeRequest("whsec_test", subscriptionEvent("evt_3", EventSubscriptionUpdated, 300, "unpaid"))))
+	assert.Equal(t, http.StatusOK, post(stripeRequest("whsec_test", subscriptionEvent("evt_2", EventSubscriptionUpdated, 200, "active"))))
+	current = subscription()
+	assert.Equal(t, Free, current.Plan)
+	assert.Equal(t, "unpaid", current.Status)
+
+	// Deleting the subscription ends the premium plan, and the other events are acknowledged
+	assert.Equal(t, http.StatusOK, post(stripeRequest("whsec_test", subscriptionEvent("evt_4", EventSubscriptionUpdated, 400, "active"))))
+	assert.Equal(t, Premium, subscription().Plan)
+	assert.Equal(t, http.StatusOK, post(stripeRequest("whsec_test", subscriptionEvent("evt_5", EventSubscriptionDeleted, 500, "canceled"))))
+	current = subscription()
+	assert.Equal(t, Free, current.Plan)
+	assert.Empty(t, current.RenewsAt)
+	assert.Equal(t, http.StatusOK, post(stripeRequest("whsec_test", `{"id":"evt_6","type":"invoice.paid","created":600}`)))
+}
@@ -0,0 +1,114 @@
+// Package providers picks the implementations of the external services the Lambdas call,
+// by name from the registry of each kind: LLM_PROVIDER names the language model provider,
+// EMBEDDINGS_PROVIDER the text embeddings one and FX_PROVIDER the exchange rates one. The stub providers are deterministic and free,
+// for the end-to-end tests of the staging stages; they are ignored in the production ones.
+package providers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/fx"
+	"vassistant-backend/llm"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// productionStages are the values of STAGE in which the stub providers are ignored.
+var productionStages = []string{"prod", "production"}
+
+// Stub is the name every kind registers its stub provider under.
+const Stub = "stub"
+
+// LLM are the language model providers LLM_PROVIDER can name. The http provider calls the
+// gateway at LLM_ENDPOINT and is the one picked when only LLM_ENDPOINT is set.
+var LLM = map[string]func() (llm.Provider, error){
+	"http": func() (llm.Provider, error) {
+		endpoint := os.Getenv("LLM_ENDPOINT")
+		if endpoint == "" {
+			return nil, fmt.Errorf("the http LLM provider needs LLM_ENDPOINT")
+		}
+		return llm.NewHTTPProvider(endpoint), nil
+	},
+	Stub: func() (llm.Provider, error) { return llm.Stub{}, nil },
+}
+
+// Embeddings are the text embeddings providers EMBEDDINGS_PROVIDER can name. The bedrock
+// provider calls the EMBEDDINGS_MODEL of Bedrock, a Titan text embeddings model, in the
+// region of the Lambda.
+var Embeddings = map[string]func() (embeddings.Embedder, error){
+	"bedrock": func() (embeddings.Embedder, error) {
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		model := os.Getenv("EMBEDDINGS_MODEL")
+		if model == "" {
+			model = embeddings.DefaultModel
+		}
+		return embeddings.NewBedrockEmbedder(model, cfg), nil
+	},
+	Stub: func() (embeddings.Embedder, error) { return embeddings.Stub{}, nil },
+}
+
+// FX are the exchange rate providers FX_PROVIDER can name. The frankfurter provider calls
+// the public Frankfurter API, or the self-hosted instance at FX_PROVIDER_URL.
+var FX = map[string]func() (fx.Provider, error){
+	"frankfurter": func() (fx.Provider, error) {
+		provider := fx.NewFrankfurter()
+		if baseURL := os.Getenv("FX_PROVIDER_URL"); baseURL != "" {
+			provider.BaseURL = baseURL
+		}
+		return provider, nil
+	},
+	Stub: func() (fx.Provider, error) { return fx.Stub{}, nil },
+}
+
+// pick creates the provider of the kind registered under the name, the zero provider when
+// the name is empty or the stub in a production stage.
+func pick[P any](registry map[string]func() (P, error), variable, name string) (P, error) {
+	var none P
+	if name == "" {
+		return none, nil
+	}
+	factory, ok := registry[name]
+	if !ok {
+		return none, fmt.Errorf("unknown %s %q, expected one of %s", variable, name, strings.Join(slices.Sorted(maps.Keys(registry)), ", "))
+	}
+	if name == Stub {
+		stage := strings.ToLower(os.Getenv("STAGE"))
+		if slices.Contains(productionStages, stage) {
+			log.Printf("Warning: ignoring %s=%s in stage %s", variable, name, stage)
+			return none, nil
+		}
+		log.Printf("Warning: using the stub provider of %s", variable)
+	}
+	return factory()
+}
+
+// LLMFromEnv returns the language model provider named by LLM_PROVIDER, or nil when none is
+// configured.
+func LLMFromEnv() (llm.Provider, error) {
+	name := os.Getenv("LLM_PROVIDER")
+	if name == "" && os.Getenv("LLM_ENDPOINT") != "" {
+		name = "http"
+	}
+	return pick(LLM, "LLM_PROVIDER", name)
+}
+
+// EmbeddingsFromEnv returns the text embeddings provider named by EMBEDDINGS_PROVIDER, or
+// nil when none is configured.
+func EmbeddingsFromEnv() (embeddings.Embedder, error) {
+	return pick(Embeddings, "EMBEDDINGS_PROVIDER", os.Getenv("EMBEDDINGS_PROVIDER"))
+}
+
+// FXFromEnv returns the exchange rate provider named by FX_PROVIDER, or nil when none is
+// configured.
+func FXFromEnv() (fx.Provider, error) {
+	return pick(FX, "FX_PROVIDER", os.Getenv("FX_PROVIDER"))
+}
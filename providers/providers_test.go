@@ -0,0 +1,71 @@
+package providers
+
+import (
+	"testing"
+	"vassistant-backend/embeddings"
+	"vassistant-backend/fx"
+	"vassistant-backend/llm"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLMFromEnv(t *testing.T) {
+	// The gateway is picked when only its endpoint is set
+	t.Setenv("LLM_PROVIDER", "")
+	t.Setenv("LLM_ENDPOINT", "https://llm.example.com")
+	provider, err := LLMFromEnv()
+	assert.NoError(t, err)
+	assert.IsType(t, &llm.HTTPProvider{}, provider)
+
+	t.Setenv("LLM_PROVIDER", "stub")
+	provider, err = LLMFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, llm.Stub{}, provider)
+
+	t.Setenv("LLM_PROVIDER", "openai")
+	_, err = LLMFromEnv()
+	assert.EqualError(t, err, `unknown LLM_PROVIDER "openai", expected one of http, stub`)
+
+	t.Setenv("LLM_PROVIDER", "http")
+	t.Setenv("LLM_ENDPOINT", "")
+	_, err = LLMFromEnv()
+	assert.Error(t, err)
+}
+
+func TestStubsIgnoredInProduction(t *testing.T) {
+	t.Setenv("STAGE", "prod")
+	t.Setenv("LLM_PROVIDER", "stub")
+	t.Setenv("FX_PROVIDER", "stub")
+	llmProvider, err := LLMFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, llmProvider)
+	fxProvider, err := FXFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, fxProvider)
+
+	// The real providers are still picked
+	t.Setenv("FX_PROVIDER", "frankfurter")
+	t.Setenv("FX_PROVIDER_URL", "http://frankfurter.internal")
+	fxProvider, err = FXFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "http://frankfurter.internal", fxProvider.(*fx.Frankfurter).BaseURL)
+}
+
+func TestEmbeddingsFromEnv(t *testing.T) {
+	t.Setenv("EMBEDDINGS_PROVIDER", "")
+	embedder, err := EmbeddingsFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, embedder)
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("EMBEDDINGS_PROVIDER", "bedrock")
+	embedder, err = EmbeddingsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, embeddings.DefaultModel, embedder.Model())
+	assert.Equal(t, "https://bedrock-runtime.eu-west-1.amazonaws.com", embedder.(*embeddings.BedrockEmbedder).Endpoint)
+
+	t.Setenv("EMBEDDINGS_PROVIDER", "stub")
+	embedder, err = EmbeddingsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, embeddings.Stub{}, embedder)
+}
@@ -0,0 +1,217 @@
+// Package queue sends the jobs handled asynchronously, like the replies of the assistant
+// delayed by the rate limits, to an SQS queue a worker Lambda consumes, and reads back the
+// jobs its dead-letter queue collects.
+package queue
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// MaxDelay is the longest SQS can delay the delivery of a message.
+const MaxDelay = 15 * time.Minute
+
+// MaxReceive is the most messages SQS returns in a receive.
+const MaxReceive = 10
+
+// Queue sends jobs to the worker.
+type Queue interface {
+	// Send queues the body of the job, to be delivered to the worker after the delay.
+	Send(ctx context.Context, body []byte, delay time.Duration) error
+}
+
+// DeadLetters is the queue the jobs failing too often are moved to by the redrive policy
+// of their queue, for the admins to inspect them and requeue those worth retrying.
+type DeadLetters interface {
+	// Receive returns up to limit of the messages, hidden from the other receives for the
+	// visibility timeout.
+	Receive(ctx context.Context, limit int, visibility time.Duration) ([]Message, error)
+	// Delete removes the message received with the receipt handle.
+	Delete(ctx context.Context, receiptHandle string) error
+	// Count returns the approximate number of messages waiting.
+	Count(ctx context.Context) (int, error)
+}
+
+// Message is a message received from a queue.
+type Message struct {
+	ID            string    `json:"id"`
+	Body          string    `json:"body"`
+	SentAt        time.Time `json:"sentAt"`
+	ReceiveCount  int       `json:"receiveCount"` // including the receives failed before it was dead-lettered
+	ReceiptHandle string    `json:"-"`
+}
+
+// SQSQueue is a Queue, or DeadLetters, on an SQS queue, calling the SQS API with its JSON
+// protocol and signing the requests with SigV4.
+type SQSQueue struct {
+	URL         string
+	Region      string
+	Credentials aws.CredentialsProvider
+	HTTPClient  *http.Client
+	signer      *v4.Signer
+}
+
+// NewSQSQueue creates the queue of the SQS queue URL, e.g.
+// https://sqs.us-east-1.amazonaws.com/123456789012/assistant-replies, signing the requests
+// with the credentials of the AWS config.
+func NewSQSQueue(queueURL string, cfg aws.Config) *SQSQueue {
+	return &SQSQueue{
+		URL:         queueURL,
+		Region:      cfg.Region,
+		Credentials: cfg.Credentials,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		signer:      v4.NewSigner(),
+	}
+}
+
+// sendMessageRequest is the body of the SendMessage action.
+type sendMessageRequest struct {
+	QueueUrl     string `json:"QueueUrl"`
+	MessageBody  string `json:"MessageBody"`
+	DelaySeconds int    `json:"DelaySeconds,omitempty"`
+}
+
+func (q *SQSQueue) Send(ctx context.Context, body []byte, delay time.Duration) error {
+	delay = min(max(delay, 0), MaxDelay)
+	return q.do(ctx, "SendMessage", sendMessageRequest{
+		QueueUrl:     q.URL,
+		MessageBody:  string(body),
+		DelaySeconds: int(delay.Round(time.Second) / time.Second),
+	}, nil)
+}
+
+// receiveMessageRequest is the body of the ReceiveMessage action.
+type receiveMessageRequest struct {
+	QueueUrl                    string   `json:"QueueUrl"`
+	MaxNumberOfMessages         int      `json:"MaxNumberOfMessages"`
+	VisibilityTimeout           int      `json:"VisibilityTimeout"`
+	MessageSystemAttributeNames []string `json:"MessageSystemAttributeNames"`
+}
+
+// sqsMessage is a message of the ReceiveMessage response.
+type sqsMessage struct {
+	MessageId     string            `json:"MessageId"`
+	ReceiptHandle string            `json:"ReceiptHandle"`
+	Body          string            `json:"Body"`
+	Attributes    map[string]string `json:"Attributes"`
+}
+
+func (q *SQSQueue) Receive(ctx context.Context, limit int, visibility time.Duration) ([]Message, error) {
+	var response struct {
+		Messages []sqsMessage `json:"Messages"`
+	}
+	err := q.do(ctx, "ReceiveMessage", receiveMessageRequest{
+		QueueUrl:                    q.URL,
+		MaxNumberOfMessages:         min(limit, MaxReceive),
+		VisibilityTimeout:           int(visibility.Round(time.Second) / time.Second),
+		MessageSystemAttributeNames: []string{"SentTimestamp", "ApproximateReceiveCount"},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(response.Messages))
+	for _, received := range response.Messages {
+		message := Message{ID: received.MessageId, ReceiptHandle: received.ReceiptHandle, Body: received.Body}
+		if millis, err := strconv.ParseInt(received.Attributes["SentTimestamp"], 10, 64); err == nil {
+			message.SentAt = time.UnixMilli(millis).UTC()
+		}
+		message.ReceiveCount, _ = strconv.Atoi(received.Attributes["ApproximateReceiveCount"])
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// deleteMessageRequest is the body of the DeleteMessage action.
+type deleteMessageRequest struct {
+	QueueUrl      string `json:"QueueUrl"`
+	ReceiptHandle string `json:"ReceiptHandle"`
+}
+
+func (q *SQSQueue) Delete(ctx context.Context, receiptHandle string) error {
+	return q.do(ctx, "DeleteMessage", deleteMessageRequest{QueueUrl: q.URL, ReceiptHandle: receiptHandle}, nil)
+}
+
+// getQueueAttributesRequest is the body of the GetQueueAttributes action.
+type getQueueAttributesRequest struct {
+	QueueUrl       string   `json:"QueueUrl"`
+	AttributeNames []string `json:"AttributeNames"`
+}
+
+func (q *SQSQueue) Count(ctx context.Context) (int, error) {
+	var response struct {
+		Attributes map[string]string `json:"Attributes"`
+	}
+	err := q.do(ctx, "GetQueueAttributes", getQueueAttributesRequest{
+		QueueUrl:       q.URL,
+		AttributeNames: []string{"ApproximateNumberOfMessages"},
+	}, &response)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(response.Attributes["ApproximateNumberOfMessages"])
+}
+
+// do posts the action to the SQS API, decoding its response into out unless nil.
+func (q *SQSQueue) do(ctx context.Context, action string, in, out interface{}) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	// The actions are posted to the root of the endpoint of the queue
+	endpoint, err := url.Parse(q.URL)
+	if err != nil {
+		return err
+	}
+	endpoint.Path = "/"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	request.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+
+	if q.Credentials != nil {
+		credentials, err := q.Credentials.Retrieve(ctx)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(payload)
+		signer := q.signer
+		if signer == nil {
+			signer = v4.NewSigner()
+		}
+		err = signer.SignHTTP(ctx, credentials, request, hex.EncodeToString(hash[:]), "sqs", q.Region, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	response, err := q.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("SQS %s returned %s: %s", action, response.Status, message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQSQueueSend(t *testing.T) {
+	var received sendMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/", r.URL.Path)
+		assert.Equal(t, "AmazonSQS.SendMessage", r.Header.Get("X-Amz-Target"))
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &received))
+		if received.MessageBody == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"MessageId":"1"}`))
+	}))
+	defer server.Close()
+
+	queue := &SQSQueue{URL: server.URL + "/123456789012/assistant-replies", HTTPClient: server.Client()}
+
+	// The delay is rounded to seconds, and capped to what SQS allows
+	assert.NoError(t, queue.Send(context.TODO(), []byte(`{"job":1}`), 2400*time.Millisecond))
+	assert.Equal(t, server.URL+"/123456789012/assistant-replies", received.QueueUrl)
+	assert.Equal(t, `{"job":1}`, received.MessageBody)
+	assert.Equal(t, 2, received.DelaySeconds)
+	assert.NoError(t, queue.Send(context.TODO(), []byte(`{"job":2}`), time.Hour))
+	assert.Equal(t, 900, received.DelaySeconds)
+
+	assert.Error(t, queue.Send(context.TODO(), []byte("fail"), 0))
+}
+
+func TestSQSQueueDeadLetters(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSQS.ReceiveMessage":
+			var received receiveMessageRequest
+			assert.NoError(t, json.Unmarshal(body, &received))
+			assert.Equal(t, MaxReceive, received.MaxNumberOfMessages)
+			assert.Equal(t, 30, received.VisibilityTimeout)
+			w.Write([]byte(`{"Messages":[{"MessageId":"m-1","ReceiptHandle":"h-1","Body":"{\"job\":1}","Attributes":{"SentTimestamp":"1700000000000","ApproximateReceiveCount":"4"}}]}`))
+		case "AmazonSQS.DeleteMessage":
+			var received deleteMessageRequest
+			assert.NoError(t, json.Unmarshal(body, &received))
+			deleted = append(deleted, received.ReceiptHandle)
+			w.Write([]byte(`{}`))
+		case "AmazonSQS.GetQueueAttributes":
+			w.Write([]byte(`{"Attributes":{"ApproximateNumberOfMessages":"3"}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	queue := &SQSQueue{URL: server.URL + "/123456789012/assistant-replies-dlq", HTTPClient: server.Client()}
+
+	messages, err := queue.Receive(context.TODO(), 50, 30*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{
+		ID:            "m-1",
+		Body:          `{"job":1}`,
+		SentAt:        time.UnixMilli(1700000000000).UTC(),
+		ReceiveCount:  4,
+		ReceiptHandle: "h-1",
+	}}, messages)
+
+	assert.NoError(t, queue.Delete(context.TODO(), "h-1"))
+	assert.Equal(t, []string{"h-1"}, deleted)
+
+	count, err := queue.Count(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
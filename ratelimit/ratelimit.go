@@ -0,0 +1,105 @@
+// Package ratelimit limits how often the clients of a route can call it, for the routes
+// reachable without signing in.
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Limiter counts the requests of each key in fixed windows.
+type Limiter interface {
+	// Allow records a request of the key and reports whether it is within the limit, and
+	// otherwise how long until the next window.
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// MemoryLimiter is a Limiter kept in the memory of the Lambda instance. Every instance counts
+// on its own, so the effective limit grows with the number of instances; API Gateway
+// throttling remains the global bound.
+type MemoryLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]window
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// NewMemoryLimiter creates a MemoryLimiter allowing limit requests per key every period.
+func NewMemoryLimiter(limit int, period time.Duration) *MemoryLimiter {
+	return &MemoryLimiter{Limit: limit, Window: period, windows: map[string]window{}}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	current, ok := l.windows[key]
+	if !ok || now.Sub(current.start) >= l.Window {
+		// Start a new window, dropping the expired ones so the map doesn't grow unbounded
+		for other, w := range l.windows {
+			if now.Sub(w.start) >= l.Window {
+				delete(l.windows, other)
+			}
+		}
+		current = window{start: now}
+	}
+	if current.count >= l.Limit {
+		return false, current.start.Add(l.Window).Sub(now), nil
+	}
+	current.count++
+	l.windows[key] = current
+	return true, 0, nil
+}
+
+// SourceIP keys the requests by the IP address of the caller.
+func SourceIP(request events.APIGatewayProxyRequest) string {
+	return request.RequestContext.Identity.SourceIP
+}
+
+// Documented documents the limit of the limiter on the route it limits by source IP, for the
+// capabilities of the API. Only the limit of a MemoryLimiter is known ahead; the routes of
+// the other limiters are left undocumented.
+func Documented(limiter Limiter) api.RouteOption {
+	return func(route *api.Route) {
+		if memory, ok := limiter.(*MemoryLimiter); ok {
+			api.RateLimited(api.RateLimit{Limit: memory.Limit, WindowSeconds: int(memory.Window.Seconds()), Key: "sourceIp"})(route)
+		}
+	}
+}
+
+// Limited rejects the requests over the limit of their key with a 429 and a Retry-After
+// header. Limiter failures let the request through rather than failing it.
+func Limited(limiter Limiter, key func(request events.APIGatewayProxyRequest) string, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		allowed, retryAfter, err := limiter.Allow(context.TODO(), key(request))
+		if err != nil {
+			log.Printf("Error checking the rate limit: %v", err)
+			return next(request)
+		}
+		if allowed {
+			return next(request)
+		}
+
+		response, err := common.CreateErrorResponse(429, "Too many requests")
+		if response.Headers == nil {
+			response.Headers = map[string]string{}
+		}
+		response.Headers["Retry-After"] = strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+		return response, err
+	}
+}
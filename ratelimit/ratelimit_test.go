@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vassistant-backend/api"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	limiter := NewMemoryLimiter(2, time.Minute)
+
+	// The requests within the limit are allowed, the next one has to wait for the window
+	for range 2 {
+		allowed, _, err := limiter.Allow(context.TODO(), "1.2.3.4")
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	allowed, retryAfter, err := limiter.Allow(context.TODO(), "1.2.3.4")
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter, time.Duration(0))
+
+	// Other keys count on their own
+	allowed, _, err = limiter.Allow(context.TODO(), "5.6.7.8")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// A new window starts over
+	limiter.Window = 0
+	allowed, _, err = limiter.Allow(context.TODO(), "1.2.3.4")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestLimited(t *testing.T) {
+	calls := 0
+	handler := Limited(NewMemoryLimiter(1, time.Minute), SourceIP, func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		calls++
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	})
+	request := events.APIGatewayProxyRequest{}
+	request.RequestContext.Identity.SourceIP = "1.2.3.4"
+
+	response, err := handler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = handler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 429, response.StatusCode)
+	assert.Equal(t, "60", response.Headers["Retry-After"])
+	assert.Equal(t, 1, calls)
+}
+
+func TestDocumented(t *testing.T) {
+	router := api.NewRouter()
+	router.AddRoute("GET", "/memory", nil, Documented(NewMemoryLimiter(30, time.Minute)))
+	router.AddRoute("GET", "/other", nil, Documented(nil))
+
+	routes := router.Routes()
+	assert.Equal(t, &api.RateLimit{Limit: 30, WindowSeconds: 60, Key: "sourceIp"}, routes[0].RateLimit)
+	assert.Nil(t, routes[1].RateLimit)
+}
@@ -0,0 +1,136 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// The types of the events sent to the clients.
+const (
+	EventMessageCreated      = "message.created"
+	EventExpenseCreated      = "expense.created"
+	EventGroupMessageCreated = "group.message.created"
+)
+
+// maxParallelPosts limits the posts to the connections in flight at once.
+const maxParallelPosts = 10
+
+// Event is the payload sent to the WebSocket clients.
+type Event struct {
+	Type   string      `json:"type"`
+	Data   interface{} `json:"data"`
+	SentAt string      `json:"sentAt"`
+}
+
+// Poster is the part of the API Gateway management API client the Broadcaster uses.
+type Poster interface {
+	PostToConnection(ctx context.Context, params *apigatewaymanagementapi.PostToConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.PostToConnectionOutput, error)
+}
+
+// Broadcaster sends the events to all the connections of the users.
+type Broadcaster struct {
+	Client Poster
+}
+
+// Default is the Broadcaster the handlers publish through, nil when the WebSocket API isn't
+// configured, making Publish a no-op.
+var Default *Broadcaster
+
+// NewBroadcaster creates a Broadcaster posting through the management API of the WebSocket
+// API stage at the endpoint, e.g. https://{api-id}.execute-api.{region}.amazonaws.com/{stage}.
+func NewBroadcaster(cfg aws.Config, endpoint string) *Broadcaster {
+	return &Broadcaster{
+		Client: apigatewaymanagementapi.NewFromConfig(cfg, func(o *apigatewaymanagementapi.Options) {
+			o.BaseEndpoint = aws.String(endpoint)
+		}),
+	}
+}
+
+// Enabled reports whether events are broadcast, so the callers can skip building them.
+func Enabled() bool {
+	return Default != nil
+}
+
+// Publish broadcasts the event to the connections of the users through the Default
+// Broadcaster. Delivering is best effort, the failures are logged and never fail the
+// request that published the event.
+func Publish(ctx context.Context, eventType string, data interface{}, userIds ...string) {
+	if Default == nil {
+		return
+	}
+	sent, err := Default.Broadcast(ctx, Event{Type: eventType, Data: data}, userIds...)
+	if err != nil {
+		log.Printf("Error broadcasting %s to %d users: %v", eventType, len(userIds), err)
+		return
+	}
+	log.Printf("Broadcast %s to %d connections", eventType, sent)
+}
+
+// Broadcast posts the event to every connection of the users, returning how many received
+// it. The connections API Gateway reports as gone are removed from the registry, and the
+// failures of single connections are logged without failing the broadcast.
+func (b *Broadcaster) Broadcast(ctx context.Context, event Event, userIds ...string) (int, error) {
+	now := time.Now()
+	if event.SentAt == "" {
+		event.SentAt = now.UTC().Format(time.RFC3339)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	var connections []Connection
+	seen := map[string]bool{}
+	for _, userId := range userIds {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+		userConnections, err := listConnections(ctx, userId, now)
+		if err != nil {
+			return 0, err
+		}
+		connections = append(connections, userConnections...)
+	}
+
+	delivered := make([]bool, len(connections))
+	var g errgroup.Group
+	g.SetLimit(maxParallelPosts)
+	for i, connection := range connections {
+		g.Go(func() error {
+			_, err := b.Client.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+				ConnectionId: aws.String(connection.ConnectionID),
+				Data:         payload,
+			})
+			var gone *types.GoneException
+			switch {
+			case errors.As(err, &gone):
+				if err := deleteConnection(ctx, connection.UserID, connection.ConnectionID); err != nil {
+					log.Printf("Error removing the gone connection %s: %v", connection.ConnectionID, err)
+				}
+			case err != nil:
+				log.Printf("Error posting to the connection %s: %v", connection.ConnectionID, err)
+			default:
+				delivered[i] = true
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	sent := 0
+	for _, ok := range delivered {
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
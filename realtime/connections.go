@@ -0,0 +1,201 @@
+// Package realtime keeps the registry of the WebSocket clients connected to the API and
+// broadcasts the events of a user, like a new message or expense, to all of their clients,
+// so the web and mobile apps stay in sync without polling. The WebSocket API invokes the
+// websocket Lambda when clients connect and disconnect, and the handlers publish events
+// through the API Gateway management API configured with WEBSOCKET_ENDPOINT.
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// connectionTTL is how long a connection is kept without news from its client. API Gateway
+// closes the WebSocket connections after two hours at most.
+const connectionTTL = 3 * time.Hour
+
+// Connection struct for the websocket-connections table, a WebSocket client of a user.
+type Connection struct {
+	UserID       string `json:"-" dynamodbav:"userId"`
+	ConnectionID string `json:"connectionId" dynamodbav:"connectionId"`
+	Device       string `json:"device,omitempty" dynamodbav:"device,omitempty"`
+	ConnectedAt  string `json:"connectedAt" dynamodbav:"connectedAt"`
+	LastSeenAt   string `json:"lastSeenAt" dynamodbav:"lastSeenAt"`
+	ExpiresAt    int64  `json:"-" dynamodbav:"expiresAt"` // DynamoDB TTL attribute, in Unix seconds
+}
+
+var DynamoDbClient common.DynamoDBAPI
+
+// saveConnection stores the connection, seen now.
+func saveConnection(ctx context.Context, connection Connection, now time.Time) error {
+	connection.LastSeenAt = now.UTC().Format(time.RFC3339)
+	connection.ExpiresAt = now.Add(connectionTTL).Unix()
+	item, err := attributevalue.MarshalMap(connection)
+	if err != nil {
+		return err
+	}
+	_, err = DynamoDbClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String("websocket-connections"),
+		Item:      item,
+	})
+	return err
+}
+
+// deleteConnection removes the connection from the registry.
+func deleteConnection(ctx context.Context, userId, connectionId string) error {
+	_, err := DynamoDbClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String("websocket-connections"),
+		Key: map[string]types.AttributeValue{
+			"userId":       &types.AttributeValueMemberS{Value: userId},
+			"connectionId": &types.AttributeValueMemberS{Value: connectionId},
+		},
+	})
+	return err
+}
+
+// listConnections returns the connections of the user, leaving out the expired ones the
+// TTL hasn't deleted yet.
+func listConnections(ctx context.Context, userId string, now time.Time) ([]Connection, error) {
+	result, err := DynamoDbClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("websocket-connections"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var items []Connection
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	connections := []Connection{}
+	for _, connection := range items {
+		if connection.ExpiresAt > now.Unix() {
+			connections = append(connections, connection)
+		}
+	}
+	return connections, nil
+}
+
+// websocketUser returns the user of a WebSocket request, the sub the authorizer of the
+// $connect route put in its context, which API Gateway passes on to every route.
+func websocketUser(request events.APIGatewayWebsocketProxyRequest) string {
+	authorizer, _ := request.RequestContext.Authorizer.(map[string]interface{})
+	if sub, ok := authorizer["sub"].(string); ok && sub != "" {
+		return sub
+	}
+	principal, _ := authorizer["principalId"].(string)
+	return principal
+}
+
+// WebsocketHandler handles the routes of the WebSocket API: $connect registers the client,
+// $disconnect removes it, and the messages of the client, e.g. {"action":"ping"}, keep
+// the connection alive.
+func WebsocketHandler(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	userId := websocketUser(request)
+	connectionId := request.RequestContext.ConnectionID
+	if userId == "" || connectionId == "" {
+		return events.APIGatewayProxyResponse{StatusCode: 401}, nil
+	}
+
+	now := time.Now()
+	var err error
+	switch request.RequestContext.RouteKey {
+	case "$connect":
+		err = saveConnection(ctx, Connection{
+			UserID:       userId,
+			ConnectionID: connectionId,
+			Device:       request.QueryStringParameters["device"],
+			ConnectedAt:  now.UTC().Format(time.RFC3339),
+		}, now)
+		if err == nil {
+			log.Printf("User %s connected %s", userId, connectionId)
+		}
+	case "$disconnect":
+		err = deleteConnection(ctx, userId, connectionId)
+		if err == nil {
+			log.Printf("User %s disconnected %s", userId, connectionId)
+		}
+	default:
+		var connection *Connection
+		connection, err = getConnection(ctx, userId, connectionId)
+		if err == nil && connection != nil {
+			err = saveConnection(ctx, *connection, now)
+		}
+	}
+	if err != nil {
+		log.Printf("Error updating the WebSocket connections in DynamoDB: %v", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500}, nil
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+// getConnection returns the connection, or nil if it isn't registered.
+func getConnection(ctx context.Context, userId, connectionId string) (*Connection, error) {
+	result, err := DynamoDbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("websocket-connections"),
+		Key: map[string]types.AttributeValue{
+			"userId":       &types.AttributeValueMemberS{Value: userId},
+			"connectionId": &types.AttributeValueMemberS{Value: connectionId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var connection Connection
+	err = attributevalue.UnmarshalMap(result.Item, &connection)
+	if err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+// GetConnectionsHandler lists the connected clients of the user, their presence on each device.
+func GetConnectionsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	connections, err := listConnections(context.TODO(), claims.Sub, time.Now())
+	if err != nil {
+		log.Printf("Error querying DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the connections into JSON for the payload
+	payload, err := json.Marshal(connections)
+	if err != nil {
+		log.Println("Error marshalling connections:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePoster records the payloads posted to each connection, failing the connections in gone.
+type fakePoster struct {
+	mu     sync.Mutex
+	posted map[string][]Event
+	gone   map[string]bool
+}
+
+func (p *fakePoster) PostToConnection(ctx context.Context, params *apigatewaymanagementapi.PostToConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.PostToConnectionOutput, error) {
+	connectionId := aws.ToString(params.ConnectionId)
+	if p.gone[connectionId] {
+		return nil, &types.GoneException{}
+	}
+	var event Event
+	if err := json.Unmarshal(params.Data, &event); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.posted[connectionId] = append(p.posted[connectionId], event)
+	return &apigatewaymanagementapi.PostToConnectionOutput{}, nil
+}
+
+func websocketRequest(routeKey, userId, connectionId string) events.APIGatewayWebsocketProxyRequest {
+	return events.APIGatewayWebsocketProxyRequest{
+		QueryStringParameters: map[string]string{"device": "web"},
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			RouteKey:     routeKey,
+			ConnectionID: connectionId,
+			Authorizer:   map[string]interface{}{"sub": userId},
+		},
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	// Connect two devices of user-1, one already gone, and one of user-2
+	for _, connection := range [][2]string{{"user-1", "web"}, {"user-1", "phone"}, {"user-1", "stale"}, {"user-2", "other"}} {
+		response, err := WebsocketHandler(context.TODO(), websocketRequest("$connect", connection[0], connection[1]))
+		assert.NoError(t, err)
+		assert.Equal(t, 200, response.StatusCode)
+	}
+	response, err := WebsocketHandler(context.TODO(), websocketRequest("$connect", "", "anonymous"))
+	assert.NoError(t, err)
+	assert.Equal(t, 401, response.StatusCode)
+
+	// The event reaches every live connection of the user only, and the gone one is removed
+	poster := &fakePoster{posted: map[string][]Event{}, gone: map[string]bool{"stale": true}}
+	broadcaster := &Broadcaster{Client: poster}
+	sent, err := broadcaster.Broadcast(context.TODO(), Event{Type: EventMessageCreated, Data: map[string]string{"id": "m1"}}, "user-1", "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Len(t, poster.posted["web"], 1)
+	assert.Len(t, poster.posted["phone"], 1)
+	assert.Empty(t, poster.posted["other"])
+	assert.Equal(t, EventMessageCreated, poster.posted["web"][0].Type)
+	assert.NotEmpty(t, poster.posted["web"][0].SentAt)
+
+	// The presence lists the connections left
+	response, err = GetConnectionsHandler(testutil.NewRequest("GET", "/VassistantBackendProxy/realtime/connections").WithClaims("user-1", "alice").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	var connections []Connection
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &connections))
+	assert.Len(t, connections, 2)
+	assert.Equal(t, "web", connections[0].Device)
+
+	// A disconnected client no longer receives the events
+	_, err = WebsocketHandler(context.TODO(), websocketRequest("$disconnect", "user-1", "phone"))
+	assert.NoError(t, err)
+	sent, err = broadcaster.Broadcast(context.TODO(), Event{Type: EventExpenseCreated}, "user-1", "user-2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Len(t, poster.posted["phone"], 1)
+	assert.Len(t, poster.posted["other"], 1)
+}
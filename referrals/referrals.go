@@ -0,0 +1,367 @@
+// Package referrals gives every user a referral code to invite their friends with, attributes
+// the users signing up with a code to the user it belongs to, and rewards the referrers with
+// bonus messages on their plan.
+package referrals
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+	"vassistant-backend/plans"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Handlers serves the referral routes and the sign-up confirmations with the DynamoDB client
+// it was created with.
+type Handlers struct {
+	client common.DynamoDBAPI
+	plans  *plans.Handlers
+}
+
+// NewHandlers creates the referral handlers reading and writing through the client, the
+// bonus messages granted on the plans of the users included.
+func NewHandlers(client common.DynamoDBAPI) *Handlers {
+	return &Handlers{client: client, plans: plans.NewHandlers(client)}
+}
+
+// codesTable holds the referral codes, keyed by code, with the userId-index to find the code
+// of a user.
+const codesTable = "referral-codes"
+
+// referralsTable holds the users each user referred, keyed by referrerId and referredId.
+const referralsTable = "referrals"
+
+// codeAlphabet is the alphabet of the referral codes, without the characters read alike.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// codeLength is the length of the referral codes.
+const codeLength = 8
+
+// The statuses of the reward of a referral
+const (
+	// RewardPending is a reward not granted yet, e.g. after the grant failed.
+	RewardPending = "PENDING"
+	// RewardGranted is a reward added to the plan of the referrer.
+	RewardGranted = "GRANTED"
+	// RewardCapped is a referral past the referrals rewarded to a user.
+	RewardCapped = "CAPPED"
+)
+
+// RewardMessages are the bonus messages a referrer is granted for each user they referred.
+const RewardMessages = 50
+
+// MaxRewardedReferrals bounds the referrals rewarded to a user.
+const MaxRewardedReferrals = 10
+
+var (
+	ErrUnknownCode  = errors.New("unknown referral code")
+	ErrSelfReferral = errors.New("users can't refer themselves")
+)
+
+// ReferralCode struct for the referral-codes table
+type ReferralCode struct {
+	Code      string `json:"code" dynamodbav:"code"`
+	UserID    string `json:"-" dynamodbav:"userId"`
+	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// Referral struct for the referrals table, a user who signed up with the code of another.
+type Referral struct {
+	ReferrerID string `json:"-" dynamodbav:"referrerId"`
+	ReferredID string `json:"-" dynamodbav:"referredId"`
+	Code       string `json:"-" dynamodbav:"code"`
+	ReferredAt string `json:"referredAt" dynamodbav:"referredAt"`
+	Reward     string `json:"reward" dynamodbav:"reward"`
+	RewardedAt string `json:"rewardedAt,omitempty" dynamodbav:"rewardedAt,omitempty"`
+}
+
+// ReferralsResponse is the response of the referrals endpoint. The referred users are only
+// listed by the date they signed up and the status of their reward.
+type ReferralsResponse struct {
+	Code          string     `json:"code"`
+	Referred      int        `json:"referred"`
+	Rewarded      int        `json:"rewarded"`
+	Pending       int        `json:"pending"`
+	BonusMessages int        `json:"bonusMessages"`
+	Referrals     []Referral `json:"referrals"`
+}
+
+// NormalizeCode returns the code as it is stored, forgiving the case and the spaces of the
+// codes typed by the users.
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// newCode generates a random referral code.
+func newCode() (string, error) {
+	random := make([]byte, codeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := make([]byte, codeLength)
+	for i, b := range random {
+		code[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(code), nil
+}
+
+func (h *Handlers) getCode(ctx context.Context, code string) (*ReferralCode, error) {
+	result, err := h.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(codesTable),
+		Key: map[string]types.AttributeValue{
+			"code": &types.AttributeValueMemberS{Value: code},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var referralCode ReferralCode
+	if err := attributevalue.UnmarshalMap(result.Item, &referralCode); err != nil {
+		return nil, err
+	}
+	return &referralCode, nil
+}
+
+// EnsureCode returns the referral code of the user, generating it the first time.
+func (h *Handlers) EnsureCode(ctx context.Context, userId string, now time.Time) (ReferralCode, error) {
+	result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(codesTable),
+		IndexName:              aws.String("userId-index"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return ReferralCode{}, err
+	}
+	var codes []ReferralCode
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &codes); err != nil {
+		return ReferralCode{}, err
+	}
+	if len(codes) > 0 {
+		return codes[0], nil
+	}
+
+	// The codes are short enough to collide once in a while, a new one is drawn then
+	for attempt := 0; ; attempt++ {
+		code, err := newCode()
+		if err != nil {
+			return ReferralCode{}, err
+		}
+		referralCode := ReferralCode{Code: code, UserID: userId, CreatedAt: now.UTC().Format(time.RFC3339)}
+		err = common.ConditionalPutItem(ctx, h.client, codesTable, referralCode, common.IfNotExists("code"))
+		if errors.Is(err, common.ErrConditionFailed) && attempt < 3 {
+			continue
+		}
+		if err != nil {
+			return ReferralCode{}, err
+		}
+		return referralCode, nil
+	}
+}
+
+func (h *Handlers) listReferrals(ctx context.Context, referrerId string) ([]Referral, error) {
+	referrals := []Referral{}
+	var startKey map[string]types.AttributeValue
+	for {
+		result, err := h.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String(referralsTable),
+			KeyConditionExpression: aws.String("referrerId = :referrerId"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":referrerId": &types.AttributeValueMemberS{Value: referrerId},
+			},
+			ExclusiveStartKey: startKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var page []Referral
+		if err := attributevalue.UnmarshalListOfMaps(result.Items, &page); err != nil {
+			return nil, err
+		}
+		referrals = append(referrals, page...)
+		if len(result.LastEvaluatedKey) == 0 {
+			return referrals, nil
+		}
+		startKey = result.LastEvaluatedKey
+	}
+}
+
+func (h *Handlers) saveReferral(ctx context.Context, referral Referral) error {
+	item, err := attributevalue.MarshalMap(referral)
+	if err != nil {
+		return err
+	}
+	_, err = h.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(referralsTable),
+		Item:      item,
+	})
+	return err
+}
+
+// reward grants the pending reward of the referral to its referrer, or caps it once the
+// referrer was rewarded MaxRewardedReferrals times. The referral is left pending when the
+// grant fails, to be retried when the referrer lists their referrals.
+func (h *Handlers) reward(ctx context.Context, referral *Referral, rewarded int, now time.Time) error {
+	if rewarded >= MaxRewardedReferrals {
+		referral.Reward = RewardCapped
+		return h.saveReferral(ctx, *referral)
+	}
+	if err := h.plans.GrantBonusMessages(ctx, referral.ReferrerID, RewardMessages); err != nil {
+		return err
+	}
+	referral.Reward = RewardGranted
+	referral.RewardedAt = now.UTC().Format(time.RFC3339)
+	return h.saveReferral(ctx, *referral)
+}
+
+// Attribute records that the user signed up with the referral code and rewards its owner.
+// Attributing a user again is a no-op, as the signup triggers may be retried.
+func (h *Handlers) Attribute(ctx context.Context, referredId, code string, now time.Time) error {
+	referralCode, err := h.getCode(ctx, NormalizeCode(code))
+	if err != nil {
+		return err
+	}
+	if referralCode == nil {
+		return ErrUnknownCode
+	}
+	if referralCode.UserID == referredId {
+		return ErrSelfReferral
+	}
+
+	referral := Referral{
+		ReferrerID: referralCode.UserID,
+		ReferredID: referredId,
+		Code:       referralCode.Code,
+		ReferredAt: now.UTC().Format(time.RFC3339),
+		Reward:     RewardPending,
+	}
+	err = common.ConditionalPutItem(ctx, h.client, referralsTable, referral, common.IfNotExists("referrerId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	referrals, err := h.listReferrals(ctx, referral.ReferrerID)
+	if err != nil {
+		return err
+	}
+	rewarded := 0
+	for _, other := range referrals {
+		if other.Reward == RewardGranted {
+			rewarded++
+		}
+	}
+	return h.reward(ctx, &referral, rewarded, now)
+}
+
+// PostConfirmationHandler is the post confirmation trigger of the Cognito user pool. It
+// generates the referral code of the new user and attributes them to the code they signed up
+// with, sent by the apps in the referralCode client metadata or the custom:referral_code
+// attribute. The signup doesn't fail on the referrals, so the errors are only logged.
+func (h *Handlers) PostConfirmationHandler(ctx context.Context, event events.CognitoEventUserPoolsPostConfirmation) (events.CognitoEventUserPoolsPostConfirmation, error) {
+	if event.TriggerSource != "PostConfirmation_ConfirmSignUp" {
+		return event, nil
+	}
+	userId := event.Request.UserAttributes["sub"]
+	now := time.Now()
+
+	if _, err := h.EnsureCode(ctx, userId, now); err != nil {
+		log.Printf("Error generating referral code of user %s: %v", userId, err)
+	}
+
+	code := event.Request.ClientMetadata["referralCode"]
+	if code == "" {
+		code = event.Request.UserAttributes["custom:referral_code"]
+	}
+	if code == "" {
+		return event, nil
+	}
+	err := h.Attribute(ctx, userId, code, now)
+	switch {
+	case errors.Is(err, ErrUnknownCode), errors.Is(err, ErrSelfReferral):
+		log.Printf("Ignored referral code %q of user %s: %v", code, userId, err)
+	case err != nil:
+		log.Printf("Error attributing user %s to referral code %q: %v", userId, code, err)
+	default:
+		log.Printf("User %s signed up with referral code %q", userId, code)
+	}
+	return event, nil
+}
+
+// GetReferralsHandler returns the referral code of the user, generating it for the users who
+// signed up before the referrals, with the users they referred and their rewards. The pending
+// rewards are granted again along the way.
+func (h *Handlers) GetReferralsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	now := time.Now()
+	code, err := h.EnsureCode(ctx, claims.Sub, now)
+	if err != nil {
+		log.Printf("Error getting referral code from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	referrals, err := h.listReferrals(ctx, claims.Sub)
+	if err != nil {
+		log.Printf("Error querying referrals from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	response := ReferralsResponse{Code: code.Code, Referred: len(referrals), Referrals: referrals}
+	for _, referral := range referrals {
+		if referral.Reward == RewardGranted {
+			response.Rewarded++
+		}
+	}
+	for i := range referrals {
+		if referrals[i].Reward != RewardPending {
+			continue
+		}
+		if err := h.reward(ctx, &referrals[i], response.Rewarded, now); err != nil {
+			log.Printf("Error granting referral reward to user %s: %v", claims.Sub, err)
+			response.Pending++
+			continue
+		}
+		if referrals[i].Reward == RewardGranted {
+			response.Rewarded++
+		}
+	}
+	response.BonusMessages = response.Rewarded * RewardMessages
+
+	// Marshal the referrals into JSON for the payload
+	payload, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshalling referrals:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
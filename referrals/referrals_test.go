@@ -0,0 +1,77 @@
+package referrals
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/plans"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferrals(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(map[string][]map[string]interface{}{
+		"vassistant-users": {
+			{"userId": "user-1", "username": "alice"},
+			{"userId": "user-2", "username": "bob"},
+		},
+	})
+	assert.NoError(t, err)
+	h := NewHandlers(fake)
+
+	getReferrals := func(userId string) ReferralsResponse {
+		response, err := h.GetReferralsHandler(testutil.NewRequest("GET", "/VassistantBackendProxy/users/me/referrals").
+			WithClaims(userId, userId).
+			Build())
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var referrals ReferralsResponse
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &referrals))
+		return referrals
+	}
+	confirm := func(userId string, clientMetadata map[string]string) {
+		var event events.CognitoEventUserPoolsPostConfirmation
+		event.TriggerSource = "PostConfirmation_ConfirmSignUp"
+		event.Request.UserAttributes = map[string]string{"sub": userId}
+		event.Request.ClientMetadata = clientMetadata
+		_, err := h.PostConfirmationHandler(context.TODO(), event)
+		assert.NoError(t, err)
+	}
+
+	// The users who signed up before the referrals get their code when they first ask
+	referrals := getReferrals("user-1")
+	assert.Len(t, referrals.Code, codeLength)
+	assert.Equal(t, referrals.Code, getReferrals("user-1").Code)
+	assert.Empty(t, referrals.Referrals)
+
+	// Signing up with the code, typed loosely, attributes the user and rewards the referrer once
+	code := referrals.Code
+	confirm("user-2", map[string]string{"referralCode": " " + code[:4] + " " + code[4:] + " "})
+	confirm("user-2", map[string]string{"referralCode": code})
+	referrals = getReferrals("user-1")
+	assert.Equal(t, 1, referrals.Referred)
+	assert.Equal(t, 1, referrals.Rewarded)
+	assert.Equal(t, RewardMessages, referrals.BonusMessages)
+	assert.Equal(t, RewardGranted, referrals.Referrals[0].Reward)
+	plan, err := h.plans.Get(context.TODO(), "user-1")
+	assert.NoError(t, err)
+	assert.Equal(t, plans.Tiers[plans.Free].MonthlyMessages+RewardMessages, plan.Entitlements.MonthlyMessages)
+
+	// The new users get their own code, and unknown codes or their own don't fail the signup
+	assert.NotEqual(t, code, getReferrals("user-2").Code)
+	confirm("user-3", map[string]string{"referralCode": "NOPE"})
+	confirm("user-2", map[string]string{"referralCode": getReferrals("user-2").Code})
+	assert.Equal(t, 0, getReferrals("user-2").Referred)
+
+	// The rewards stop past the cap
+	for i := 0; i < MaxRewardedReferrals; i++ {
+		assert.NoError(t, h.Attribute(context.TODO(), "user-"+string(rune('a'+i)), code, time.Now()))
+	}
+	referrals = getReferrals("user-1")
+	assert.Equal(t, MaxRewardedReferrals+1, referrals.Referred)
+	assert.Equal(t, MaxRewardedReferrals, referrals.Rewarded)
+}
@@ -0,0 +1,424 @@
+// Package residency keeps the data of the groups requiring it in the region of their
+// jurisdiction. The RoutingDynamoDB client sends the requests on the items of a group to the
+// tables of its region, the same tables in another region, and the other requests to the
+// home region of the Lambda, so the handlers are unaware of it. It is enabled with
+// DATA_RESIDENCY_REGIONS.
+//
+// The memberships, and with them the names of the groups, stay in the home region, as that is
+// how the users find their groups. The scans, like those of the jobs, only read the home
+// region. The items of the group tables read by another key than the group, like a receipt
+// by its ID, are looked for in every region.
+package residency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// residencyTable holds the region of the groups whose data is kept outside of the home region,
+// keyed by groupId. It is in the home region.
+const residencyTable = "splitter-group-residency"
+
+// cacheTTL is how long the region of a group is remembered. The region only changes while
+// the group has no data, so a stale region finds nothing to miss.
+const cacheTTL = 5 * time.Minute
+
+// ErrUnknownRegion is returned for the groups in a region without a client, rather than
+// reading or writing their data in the home region.
+var ErrUnknownRegion = errors.New("the region of the group is not configured")
+
+// ErrCrossRegion is returned for the transactions writing to several regions, which DynamoDB
+// can't do atomically.
+var ErrCrossRegion = errors.New("transaction spans several regions")
+
+// Table names the attribute of the items of a table holding the ID of their group.
+type Table struct {
+	GroupKey string
+}
+
+// DefaultTables are the tables holding the data of the groups, routed to the region of the
+// group.
+var DefaultTables = map[string]Table{
+	"splitter-expense-approvals":     {GroupKey: "groupId"},
+	"splitter-expenses":              {GroupKey: "groupId"},
+	"splitter-group-balances":        {GroupKey: "groupId"},
+	"splitter-group-chat":            {GroupKey: "groupId"},
+	"splitter-group-insights":        {GroupKey: "groupId"},
+	"splitter-group-settings":        {GroupKey: "groupId"},
+	"splitter-ledger":                {GroupKey: "groupId"},
+	"splitter-receipts":              {GroupKey: "groupId"},
+	"splitter-recurring-expenses":    {GroupKey: "groupId"},
+	"splitter-recurring-suggestions": {GroupKey: "groupId"},
+	"splitter-reimbursements":        {GroupKey: "groupId"},
+	"splitter-sheet-links":           {GroupKey: "groupId"},
+	"splitter-shopping-items":        {GroupKey: "groupId"},
+	"splitter-spending-caps":         {GroupKey: "groupId"},
+}
+
+// Residency struct for the splitter-group-residency table
+type Residency struct {
+	GroupID   string `json:"groupId" dynamodbav:"groupId"`
+	Region    string `json:"region" dynamodbav:"region"`
+	UpdatedAt string `json:"updatedAt,omitempty" dynamodbav:"updatedAt"`
+	UpdatedBy string `json:"updatedBy,omitempty" dynamodbav:"updatedBy"`
+}
+
+// cachedRegion is the region of a group remembered until it expires.
+type cachedRegion struct {
+	region  string
+	expires time.Time
+}
+
+// RoutingDynamoDB sends the requests on the items of the Tables to the client of the region
+// of their group, and the others to the Home client.
+type RoutingDynamoDB struct {
+	Home       common.DynamoDBAPI
+	HomeRegion string
+	Regions    map[string]common.DynamoDBAPI
+	Tables     map[string]Table
+
+	mu    sync.Mutex
+	cache map[string]cachedRegion
+}
+
+// Default is the router of the API, to tag the groups with their region. It is nil until
+// the residency is enabled.
+var Default *RoutingDynamoDB
+
+// NewRoutingDynamoDB creates a RoutingDynamoDB routing the DefaultTables between the home
+// client and the clients of the other regions.
+func NewRoutingDynamoDB(home common.DynamoDBAPI, homeRegion string, regions map[string]common.DynamoDBAPI) *RoutingDynamoDB {
+	return &RoutingDynamoDB{Home: home, HomeRegion: homeRegion, Regions: regions, Tables: DefaultTables}
+}
+
+// FromEnv wraps the client in a RoutingDynamoDB when DATA_RESIDENCY_REGIONS lists the regions
+// the groups may keep their data in, e.g. eu-central-1,sa-east-1, each with the same tables
+// as the home region. It returns the client itself when the residency is disabled.
+func FromEnv(cfg aws.Config, client common.DynamoDBAPI) common.DynamoDBAPI {
+	spec := os.Getenv("DATA_RESIDENCY_REGIONS")
+	if spec == "" {
+		return client
+	}
+	regions := map[string]common.DynamoDBAPI{}
+	for _, region := range strings.Split(spec, ",") {
+		region = strings.TrimSpace(region)
+		if region == "" || region == cfg.Region {
+			continue
+		}
+		regions[region] = dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) { o.Region = region })
+	}
+	return NewRoutingDynamoDB(client, cfg.Region, regions)
+}
+
+// AllowedRegions returns the regions a group may keep its data in, the home region first.
+func (c *RoutingDynamoDB) AllowedRegions() []string {
+	return append([]string{c.HomeRegion}, sortedRegions(c.Regions)...)
+}
+
+// Get returns the residency of the group, in the home region unless tagged otherwise.
+func (c *RoutingDynamoDB) Get(ctx context.Context, groupId string) (Residency, error) {
+	result, err := c.Home.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(residencyTable),
+		Key: map[string]types.AttributeValue{
+			"groupId": &types.AttributeValueMemberS{Value: groupId},
+		},
+	})
+	if err != nil {
+		return Residency{}, err
+	}
+	residency := Residency{GroupID: groupId, Region: c.HomeRegion}
+	if result.Item != nil {
+		if err := attributevalue.UnmarshalMap(result.Item, &residency); err != nil {
+			return Residency{}, err
+		}
+	}
+	return residency, nil
+}
+
+// Set tags the group with the region its data is kept in. It doesn't move the data, so it is
+// only meant for the groups without any yet.
+func (c *RoutingDynamoDB) Set(ctx context.Context, groupId, region, updatedBy string, now time.Time) (Residency, error) {
+	if !slices.Contains(c.AllowedRegions(), region) {
+		return Residency{}, ErrUnknownRegion
+	}
+	residency := Residency{
+		GroupID:   groupId,
+		Region:    region,
+		UpdatedAt: now.UTC().Format(time.RFC3339),
+		UpdatedBy: updatedBy,
+	}
+	item, err := attributevalue.MarshalMap(residency)
+	if err != nil {
+		return Residency{}, err
+	}
+	_, err = c.Home.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(residencyTable), Item: item})
+	if err != nil {
+		return Residency{}, err
+	}
+
+	c.mu.Lock()
+	delete(c.cache, groupId)
+	c.mu.Unlock()
+	return residency, nil
+}
+
+// region returns the region of the group, remembered for a while.
+func (c *RoutingDynamoDB) region(ctx context.Context, groupId string) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[groupId]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.region, nil
+	}
+
+	residency, err := c.Get(ctx, groupId)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = map[string]cachedRegion{}
+	}
+	c.cache[groupId] = cachedRegion{region: residency.Region, expires: time.Now().Add(cacheTTL)}
+	c.mu.Unlock()
+	return residency.Region, nil
+}
+
+// clientOf returns the client of the region of the group, the home one for the items
+// without a group.
+func (c *RoutingDynamoDB) clientOf(ctx context.Context, groupId string) (common.DynamoDBAPI, error) {
+	if groupId == "" {
+		return c.Home, nil
+	}
+	region, err := c.region(ctx, groupId)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" || region == c.HomeRegion {
+		return c.Home, nil
+	}
+	client, ok := c.Regions[region]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s of group %s", ErrUnknownRegion, region, groupId)
+	}
+	return client, nil
+}
+
+// groupOf returns the group of the item or key of the table, "" when the table isn't routed
+// or the attributes don't name the group.
+func (c *RoutingDynamoDB) groupOf(table string, attributes map[string]types.AttributeValue) string {
+	spec, ok := c.Tables[table]
+	if !ok {
+		return ""
+	}
+	if value, ok := attributes[spec.GroupKey].(*types.AttributeValueMemberS); ok {
+		return value.Value
+	}
+	return ""
+}
+
+// unrouted reports whether the key of the table doesn't name the group of its item, which may
+// then be in any region.
+func (c *RoutingDynamoDB) unrouted(table string, key map[string]types.AttributeValue) bool {
+	_, ok := c.Tables[table]
+	return ok && c.groupOf(table, key) == ""
+}
+
+// all returns the clients of every region, the home one first.
+func (c *RoutingDynamoDB) all() []common.DynamoDBAPI {
+	clients := []common.DynamoDBAPI{c.Home}
+	for _, region := range sortedRegions(c.Regions) {
+		clients = append(clients, c.Regions[region])
+	}
+	return clients
+}
+
+func sortedRegions(regions map[string]common.DynamoDBAPI) []string {
+	names := make([]string, 0, len(regions))
+	for region := range regions {
+		names = append(names, region)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// keyEquality matches the equality conditions of a key condition expression.
+var keyEquality = regexp.MustCompile(`(#?[A-Za-z0-9_]+)\s*=\s*(:[A-Za-z0-9_]+)`)
+
+// queryGroup returns the group a query on the table is for, from the equality on the group
+// key in its key condition, "" when there is none.
+func (c *RoutingDynamoDB) queryGroup(params *dynamodb.QueryInput) string {
+	spec, ok := c.Tables[aws.ToString(params.TableName)]
+	if !ok {
+		return ""
+	}
+	for _, match := range keyEquality.FindAllStringSubmatch(aws.ToString(params.KeyConditionExpression), -1) {
+		name := match[1]
+		if strings.HasPrefix(name, "#") {
+			name = params.ExpressionAttributeNames[name]
+		}
+		if name != spec.GroupKey {
+			continue
+		}
+		if value, ok := params.ExpressionAttributeValues[match[2]].(*types.AttributeValueMemberS); ok {
+			return value.Value
+		}
+	}
+	return ""
+}
+
+func (c *RoutingDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	table := aws.ToString(params.TableName)
+	if c.unrouted(table, params.Key) {
+		// The item may be in any region, the first one found is it
+		var output *dynamodb.GetItemOutput
+		for _, client := range c.all() {
+			var err error
+			output, err = client.GetItem(ctx, params, optFns...)
+			if err != nil || output.Item != nil {
+				return output, err
+			}
+		}
+		return output, nil
+	}
+	client, err := c.clientOf(ctx, c.groupOf(table, params.Key))
+	if err != nil {
+		return nil, err
+	}
+	return client.GetItem(ctx, params, optFns...)
+}
+
+func (c *RoutingDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	client, err := c.clientOf(ctx, c.groupOf(aws.ToString(params.TableName), params.Item))
+	if err != nil {
+		return nil, err
+	}
+	return client.PutItem(ctx, params, optFns...)
+}
+
+func (c *RoutingDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	table := aws.ToString(params.TableName)
+	client, err := c.clientOf(ctx, c.groupOf(table, params.Key))
+	if err != nil {
+		return nil, err
+	}
+	if c.unrouted(table, params.Key) {
+		// Delete the item where it is found
+		for _, candidate := range c.all() {
+			output, err := candidate.GetItem(ctx, &dynamodb.GetItemInput{TableName: params.TableName, Key: params.Key})
+			if err != nil {
+				return nil, err
+			}
+			if output.Item != nil {
+				client = candidate
+				break
+			}
+		}
+	}
+	return client.DeleteItem(ctx, params, optFns...)
+}
+
+func (c *RoutingDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	client, err := c.clientOf(ctx, c.queryGroup(params))
+	if err != nil {
+		return nil, err
+	}
+	return client.Query(ctx, params, optFns...)
+}
+
+func (c *RoutingDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	return c.Home.Scan(ctx, params, optFns...)
+}
+
+func (c *RoutingDynamoDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	// Split the keys between the regions of their groups
+	var clients []common.DynamoDBAPI
+	requests := map[common.DynamoDBAPI]map[string]types.KeysAndAttributes{}
+	for table, keysAndAttributes := range params.RequestItems {
+		for _, key := range keysAndAttributes.Keys {
+			client, err := c.clientOf(ctx, c.groupOf(table, key))
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := requests[client]; !ok {
+				clients = append(clients, client)
+				requests[client] = map[string]types.KeysAndAttributes{}
+			}
+			request, ok := requests[client][table]
+			if !ok {
+				request = keysAndAttributes
+				request.Keys = nil
+			}
+			request.Keys = append(request.Keys, key)
+			requests[client][table] = request
+		}
+	}
+	if len(clients) == 1 {
+		return clients[0].BatchGetItem(ctx, params, optFns...)
+	}
+
+	result := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]types.AttributeValue{},
+		UnprocessedKeys: map[string]types.KeysAndAttributes{},
+	}
+	for _, client := range clients {
+		input := *params
+		input.RequestItems = requests[client]
+		output, err := client.BatchGetItem(ctx, &input, optFns...)
+		if err != nil {
+			return nil, err
+		}
+		for table, items := range output.Responses {
+			result.Responses[table] = append(result.Responses[table], items...)
+		}
+		for table, unprocessed := range output.UnprocessedKeys {
+			keys := result.UnprocessedKeys[table]
+			unprocessed.Keys = append(keys.Keys, unprocessed.Keys...)
+			result.UnprocessedKeys[table] = unprocessed
+		}
+	}
+	return result, nil
+}
+
+func (c *RoutingDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	var client common.DynamoDBAPI
+	for _, item := range params.TransactItems {
+		var table string
+		var attributes map[string]types.AttributeValue
+		switch {
+		case item.Put != nil:
+			table, attributes = aws.ToString(item.Put.TableName), item.Put.Item
+		case item.Update != nil:
+			table, attributes = aws.ToString(item.Update.TableName), item.Update.Key
+		case item.Delete != nil:
+			table, attributes = aws.ToString(item.Delete.TableName), item.Delete.Key
+		case item.ConditionCheck != nil:
+			table, attributes = aws.ToString(item.ConditionCheck.TableName), item.ConditionCheck.Key
+		}
+		itemClient, err := c.clientOf(ctx, c.groupOf(table, attributes))
+		if err != nil {
+			return nil, err
+		}
+		if client != nil && itemClient != client {
+			return nil, ErrCrossRegion
+		}
+		client = itemClient
+	}
+	if client == nil {
+		client = c.Home
+	}
+	return client.TransactWriteItems(ctx, params, optFns...)
+}
@@ -0,0 +1,112 @@
+package residency
+
+import (
+	"context"
+	"testing"
+	"time"
+	"vassistant-backend/common"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type expense struct {
+	GroupID   string `dynamodbav:"groupId"`
+	ExpenseID string `dynamodbav:"expenseId"`
+	Title     string `dynamodbav:"title"`
+}
+
+// put stores the item through the client.
+func put(t *testing.T, client common.DynamoDBAPI, table string, item any) error {
+	av, err := attributevalue.MarshalMap(item)
+	assert.NoError(t, err)
+	_, err = client.PutItem(context.TODO(), &dynamodb.PutItemInput{TableName: aws.String(table), Item: av})
+	return err
+}
+
+func TestRoutingDynamoDB(t *testing.T) {
+	home, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	eu, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	client := NewRoutingDynamoDB(home, "us-east-1", map[string]common.DynamoDBAPI{"eu-central-1": eu})
+	ctx := context.TODO()
+
+	_, err = client.Set(ctx, "berlin", "eu-central-1", "admin", time.Now())
+	assert.NoError(t, err)
+	_, err = client.Set(ctx, "tokyo", "ap-northeast-1", "admin", time.Now())
+	assert.ErrorIs(t, err, ErrUnknownRegion)
+	assert.Equal(t, []string{"us-east-1", "eu-central-1"}, client.AllowedRegions())
+
+	// The items of the group tables go to the region of their group, the others stay home
+	assert.NoError(t, put(t, client, "splitter-expenses", expense{GroupID: "berlin", ExpenseID: "rent", Title: "Miete"}))
+	assert.NoError(t, put(t, client, "splitter-expenses", expense{GroupID: "boston", ExpenseID: "rent", Title: "Rent"}))
+	assert.NoError(t, put(t, client, "splitter-group-members", map[string]string{"userId": "user-1", "groupId": "berlin"}))
+	stored := func(db *testutil.FakeDynamoDB, table string) int {
+		result, err := db.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(table)})
+		assert.NoError(t, err)
+		return len(result.Items)
+	}
+	assert.Equal(t, 1, stored(eu, "splitter-expenses"))
+	assert.Equal(t, 1, stored(home, "splitter-expenses"))
+	assert.Equal(t, 1, stored(home, "splitter-group-members"))
+	assert.Equal(t, 0, stored(eu, "splitter-group-members"))
+
+	// The reads find them there
+	result, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String("splitter-expenses"),
+		KeyConditionExpression:   aws.String("#group = :groupId"),
+		ExpressionAttributeNames: map[string]string{"#group": "groupId"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groupId": &types.AttributeValueMemberS{Value: "berlin"},
+		},
+	})
+	assert.NoError(t, err)
+	var expenses []expense
+	assert.NoError(t, attributevalue.UnmarshalListOfMaps(result.Items, &expenses))
+	assert.Equal(t, []expense{{GroupID: "berlin", ExpenseID: "rent", Title: "Miete"}}, expenses)
+
+	key := func(groupId string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"groupId":   &types.AttributeValueMemberS{Value: groupId},
+			"expenseId": &types.AttributeValueMemberS{Value: "rent"},
+		}
+	}
+	batch, err := client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: map[string]types.KeysAndAttributes{
+		"splitter-expenses": {Keys: []map[string]types.AttributeValue{key("berlin"), key("boston")}},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, batch.Responses["splitter-expenses"], 2)
+
+	// The items read by another key than their group are looked for in every region
+	assert.NoError(t, put(t, client, "splitter-receipts", map[string]string{"receiptId": "receipt-1", "groupId": "berlin"}))
+	receipt, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("splitter-receipts"),
+		Key:       map[string]types.AttributeValue{"receiptId": &types.AttributeValueMemberS{Value: "receipt-1"}},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, receipt.Item)
+	assert.Equal(t, 1, stored(eu, "splitter-receipts"))
+
+	// A transaction stays in one region
+	err = common.TransactPutItems(ctx, client, []common.ConditionalPut{
+		{TableName: "splitter-expenses", Item: expense{GroupID: "berlin", ExpenseID: "food"}, Condition: common.IfNotExists("expenseId")},
+		{TableName: "splitter-receipts", Item: map[string]string{"receiptId": "receipt-2", "groupId": "berlin"}, Condition: common.IfNotExists("receiptId")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stored(eu, "splitter-expenses"))
+	err = common.TransactPutItems(ctx, client, []common.ConditionalPut{
+		{TableName: "splitter-expenses", Item: expense{GroupID: "berlin", ExpenseID: "taxi"}, Condition: common.IfNotExists("expenseId")},
+		{TableName: "splitter-expenses", Item: expense{GroupID: "boston", ExpenseID: "taxi"}, Condition: common.IfNotExists("expenseId")},
+	})
+	assert.ErrorIs(t, err, ErrCrossRegion)
+
+	// The groups of a region no longer configured fail rather than landing home
+	client.Regions = map[string]common.DynamoDBAPI{}
+	_, err = client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String("splitter-expenses"), Key: key("berlin")})
+	assert.ErrorIs(t, err, ErrUnknownRegion)
+}
@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"time"
+	"vassistant-backend/admin"
+	"vassistant-backend/api"
+	"vassistant-backend/apikeys"
+	"vassistant-backend/cache"
+	"vassistant-backend/devices"
+	"vassistant-backend/financial"
+	"vassistant-backend/messages"
+	"vassistant-backend/notifications"
+	"vassistant-backend/offload"
+	"vassistant-backend/plans"
+	"vassistant-backend/ratelimit"
+	"vassistant-backend/realtime"
+	"vassistant-backend/referrals"
+	"vassistant-backend/shadow"
+	"vassistant-backend/status"
+	"vassistant-backend/tools"
+	"vassistant-backend/uploads"
+)
+
+// ResponseCache holds the responses of the cached routes. It is kept in memory unless
+// replaced, e.g. by a DynamoDBStore shared by all the instances.
+var ResponseCache cache.Store = cache.NewMemoryStore()
+
+// referenceDataTTL is how long reference data like the expense categories is cached.
+const referenceDataTTL = time.Hour
+
+// GuestLinkLimiter limits how often each IP address can open guest and unsubscribe links.
+// Their routes are public, so this is what stands between them and token guessing.
+var GuestLinkLimiter ratelimit.Limiter = ratelimit.NewMemoryLimiter(30, time.Minute)
+
+// QuickLimiter limits how often each IP address can call the quick endpoints. They are
+// reached without the Cognito authorizer, authenticated by an API key instead.
+var QuickLimiter ratelimit.Limiter = ratelimit.NewMemoryLimiter(60, time.Minute)
+
+// SplitPreviewLimiter limits how often each IP address can preview splits. The preview is
+// public, for the marketing site, and only costs compute.
+var SplitPreviewLimiter ratelimit.Limiter = ratelimit.NewMemoryLimiter(60, time.Minute)
+
+// DefaultBasePath is the proxy resource of the API the routes are served under, unless
+// configured otherwise for the environment.
+const DefaultBasePath = "/VassistantBackendProxy"
+
+// payloadLimits are the payload limits documented by the capabilities. The requests are
+// bound by the Lambda payload limit like the responses.
+func payloadLimits() api.PayloadLimits {
+	return api.PayloadLimits{
+		RequestBytes:       offload.PayloadLimit,
+		ResponseBytes:      offload.PayloadLimit,
+		UploadBytes:        uploads.MaxSize,
+		OffloadedResponses: offload.Bucket != "",
+	}
+}
+
+// Handlers holds the handlers the routes are served by, created with the clients they use.
+type Handlers struct {
+	Financial *financial.Handlers
+	Messages  *messages.Handlers
+	Plans     *plans.Handlers
+	Admin     *admin.Handlers
+	Devices   *devices.Handlers
+	Referrals *referrals.Handlers
+	// Candidates serves the candidates of the shadow experiments, with a client of their own
+	// so their reads don't count towards the metrics of the requests they shadow.
+	Candidates *financial.Handlers
+}
+
+// Register adds all the API routes to the router.
+func Register(router *api.Router, handlers Handlers) {
+	router.AddRoute("POST", "/messages", handlers.Messages.PostMessageHandler)
+	router.AddRoute("GET", "/messages", handlers.Messages.GetMessageHandler)
+	router.AddRoute("GET", "/messages/conversations", handlers.Messages.GetConversationsHandler)
+	router.AddRoute("POST", "/messages/conversations/(?P<conversationId>[^/]+)/read", handlers.Messages.ReadConversationHandler)
+	router.AddRoute("PATCH", "/messages/conversations/(?P<conversationId>[^/]+)/settings", handlers.Messages.PatchConversationSettingsHandler)
+	router.AddRoute("GET", "/messages/conversations/(?P<conversationId>[^/]+)/branches", handlers.Messages.GetBranchesHandler)
+	router.AddRoute("PUT", "/messages/conversations/(?P<conversationId>[^/]+)/active-branch", handlers.Messages.SwitchBranchHandler)
+	router.AddRoute("GET", "/messages/(?P<messageId>[^/]+)/trace", handlers.Messages.GetTraceHandler)
+	router.AddRoute("POST", "/quick/expense", ratelimit.Limited(QuickLimiter, ratelimit.SourceIP, apikeys.Authenticated(handlers.Messages.PostQuickExpenseHandler)), api.Unsigned, ratelimit.Documented(QuickLimiter))
+	router.AddRoute("GET", "/quick/balance", ratelimit.Limited(QuickLimiter, ratelimit.SourceIP, apikeys.Authenticated(handlers.Messages.GetQuickBalanceHandler)), ratelimit.Documented(QuickLimiter))
+	router.AddRoute("GET", "/api-keys", apikeys.GetAPIKeysHandler)
+	router.AddRoute("POST", "/api-keys", apikeys.PostAPIKeyHandler)
+	router.AddRoute("DELETE", "/api-keys/(?P<keyId>[^/]+)", apikeys.DeleteAPIKeyHandler)
+	router.AddRoute("GET", "/devices", handlers.Devices.GetDevicesHandler)
+	router.AddRoute("POST", "/devices", handlers.Devices.PostDeviceHandler, api.Unsigned)
+	router.AddRoute("DELETE", "/devices/(?P<deviceId>[^/]+)", handlers.Devices.DeleteDeviceHandler)
+	router.AddRoute("GET", "/search", handlers.Messages.SearchHandler)
+	router.AddRoute("GET", "/memories", handlers.Messages.GetMemoriesHandler)
+	router.AddRoute("DELETE", "/memories/(?P<memoryId>[^/]+)", handlers.Messages.DeleteMemoryHandler)
+	router.AddRoute("GET", "/assistant/permissions", tools.GetPermissionsHandler)
+	router.AddRoute("PUT", "/assistant/permissions", tools.PutPermissionsHandler)
+	router.AddRoute("GET", "/assistant/proactive", handlers.Messages.GetProactiveSettingsHandler)
+	router.AddRoute("PUT", "/assistant/proactive", handlers.Messages.PutProactiveSettingsHandler)
+	router.AddRoute("GET", "/assistant/onboarding", handlers.Messages.GetOnboardingHandler)
+	router.AddRoute("POST", "/assistant/onboarding", handlers.Messages.StartOnboardingHandler)
+	router.AddRoute("GET", "/plan", handlers.Plans.GetPlanHandler)
+	router.AddRoute("GET", "/users/me/subscription", handlers.Plans.GetSubscriptionHandler)
+	router.AddRoute("GET", "/users/me/referrals", handlers.Referrals.GetReferralsHandler, api.Mutating)
+	router.AddRoute("POST", "/integrations/stripe/webhook", handlers.Plans.StripeWebhookHandler, api.Unsigned)
+	router.AddRoute("GET", "/notifications", notifications.GetNotificationsHandler)
+	router.AddRoute("GET", "/notifications/preferences", notifications.GetPreferencesHandler)
+	router.AddRoute("PUT", "/notifications/preferences", notifications.PutPreferencesHandler)
+	router.AddRoute("GET", "/realtime/connections", realtime.GetConnectionsHandler)
+	router.AddRoute("GET", "/financial/groups", handlers.Financial.GetGroupsHandler)
+	router.AddRoute("GET", "/financial/me/net-debts", handlers.Financial.GetNetDebtsHandler, shadow.Compared("net-debts", handlers.Candidates.GetProjectedNetDebtsHandler))
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)", handlers.Financial.GetGroupHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/chat", handlers.Financial.GetGroupChatHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/chat", handlers.Financial.PostGroupChatHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/assistant", handlers.Messages.PostGroupAssistantHandler)
+	router.AddRoute("DELETE", "/financial/groups/(?P<groupId>[^/]+)", handlers.Financial.DeleteGroupHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/restore", handlers.Financial.RestoreGroupHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/expenses", offload.Large(handlers.Financial.GetGroupExpensesHandler))
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)", handlers.Financial.GetExpenseHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses", handlers.Financial.PostGroupExpenseHandler, api.StrictJSON(financial.FinancialExpense{}))
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses/batch", handlers.Financial.PostGroupExpenseBatchHandler, api.StrictJSON(financial.ExpenseBatch{}))
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses/recategorize", handlers.Financial.RecategorizeExpensesHandler, api.StrictJSON(financial.RecategorizeRequest{}))
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)/image", handlers.Financial.PutExpenseImageHandler)
+	router.AddRoute("POST", "/financial/receipts/(?P<receiptId>[^/]+)/assign", handlers.Financial.AssignReceiptHandler)
+	router.AddRoute("GET", "/financial/receipts/(?P<receiptId>[^/]+)/suggestions", handlers.Financial.GetReceiptSuggestionsHandler)
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)/receipt", handlers.Financial.AttachReceiptHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)/settlements", handlers.Financial.SettleExpenseHandler, api.StrictJSON(financial.ExpenseSettlement{}))
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/settlements", handlers.Financial.SettleBetweenMembersHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/reimbursements", handlers.Financial.GetReimbursementsHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/reimbursements", handlers.Financial.ReimburseExpensesHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/ledger", handlers.Financial.GetLedgerHandler, api.Mutating)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/ledger/verify", handlers.Financial.VerifyLedgerHandler, api.ReadOnly)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)/dispute", handlers.Financial.DisputeExpenseHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)/dispute/resolve", handlers.Financial.ResolveDisputeHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)/dispute/adjust", handlers.Financial.AdjustDisputeHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/insights", handlers.Financial.GetGroupInsightsHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/analytics", handlers.Financial.GetGroupAnalyticsHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/series", handlers.Financial.GetGroupSeriesHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/users", handlers.Financial.GetGroupUsersHandler)
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/users/(?P<userId>[^/]+)/nickname", handlers.Financial.PutNicknameHandler, api.StrictJSON(financial.Nickname{}))
+	router.AddRoute("DELETE", "/financial/groups/(?P<groupId>[^/]+)/users/(?P<userId>[^/]+)/nickname", handlers.Financial.DeleteNicknameHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/settings", handlers.Financial.GetGroupSettingsHandler)
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/settings", handlers.Financial.PutGroupSettingsHandler, api.StrictJSON(financial.GroupSettings{}))
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/template", handlers.Financial.ApplyGroupTemplateHandler, api.StrictJSON(financial.GroupTemplateRequest{}))
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/caps", handlers.Financial.GetSpendingCapsHandler)
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/caps", handlers.Financial.PutSpendingCapsHandler, api.StrictJSON(financial.SpendingCaps{}))
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/expense-approvals", handlers.Financial.GetExpenseApprovalsHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expense-approvals/(?P<expenseId>[^/]+)/approve", handlers.Financial.ApproveExpenseHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expense-approvals/(?P<expenseId>[^/]+)/deny", handlers.Financial.DenyExpenseHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/shopping-list", handlers.Financial.GetShoppingListHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/shopping-list", handlers.Financial.PostShoppingItemHandler, api.StrictJSON(financial.ShoppingItem{}))
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/shopping-list/expense", handlers.Financial.PostShoppingExpenseHandler, api.StrictJSON(financial.ShoppingExpenseRequest{}))
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/shopping-list/(?P<itemId>[^/]+)", handlers.Financial.PutShoppingItemHandler, api.StrictJSON(financial.ShoppingItem{}))
+	router.AddRoute("DELETE", "/financial/groups/(?P<groupId>[^/]+)/shopping-list/(?P<itemId>[^/]+)", handlers.Financial.DeleteShoppingItemHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/recurring", handlers.Financial.GetRecurringExpensesHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/recurring/suggestions", handlers.Financial.GetRecurringSuggestionsHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/recurring/suggestions/(?P<suggestionId>[^/]+)/accept", handlers.Financial.AcceptRecurringSuggestionHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/recurring/suggestions/(?P<suggestionId>[^/]+)/dismiss", handlers.Financial.DismissRecurringSuggestionHandler)
+	router.AddRoute("GET", "/financial/discoverable-groups", handlers.Financial.GetDiscoverableGroupsHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/join-requests", handlers.Financial.PostJoinRequestHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/join-requests", handlers.Financial.GetJoinRequestsHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/join-requests/(?P<userId>[^/]+)/approve", handlers.Financial.ApproveJoinRequestHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/join-requests/(?P<userId>[^/]+)/deny", handlers.Financial.DenyJoinRequestHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/guest-links", handlers.Financial.PostGuestLinkHandler)
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/guest-links", handlers.Financial.GetGuestLinksHandler)
+	router.AddRoute("DELETE", "/financial/groups/(?P<groupId>[^/]+)/guest-links/(?P<linkId>[^/]+)", handlers.Financial.RevokeGuestLinkHandler)
+	router.AddRoute("GET", "/public/guest/(?P<token>[^/]+)", ratelimit.Limited(GuestLinkLimiter, ratelimit.SourceIP, offload.Large(handlers.Financial.GetGuestViewHandler)), ratelimit.Documented(GuestLinkLimiter))
+	router.AddRoute("GET", "/public/unsubscribe", ratelimit.Limited(GuestLinkLimiter, ratelimit.SourceIP, notifications.GetUnsubscribeHandler), ratelimit.Documented(GuestLinkLimiter))
+	router.AddRoute("POST", "/public/unsubscribe", ratelimit.Limited(GuestLinkLimiter, ratelimit.SourceIP, notifications.UnsubscribeHandler), ratelimit.Documented(GuestLinkLimiter))
+	router.AddRoute("POST", "/public/split-preview", ratelimit.Limited(SplitPreviewLimiter, ratelimit.SourceIP, financial.SplitPreviewHandler), api.ReadOnly, api.StrictJSON(financial.SplitPreviewRequest{}), ratelimit.Documented(SplitPreviewLimiter))
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/integrations/google-sheets", handlers.Financial.GetSheetLinkHandler)
+	router.AddRoute("PUT", "/financial/groups/(?P<groupId>[^/]+)/integrations/google-sheets", handlers.Financial.PutSheetLinkHandler)
+	router.AddRoute("DELETE", "/financial/groups/(?P<groupId>[^/]+)/integrations/google-sheets", handlers.Financial.DeleteSheetLinkHandler)
+	router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/integrations/google-sheets/sync", handlers.Financial.SyncSheetHandler)
+	router.AddRoute("GET", "/financial/email-inbox", handlers.Financial.GetEmailInboxHandler)
+	router.AddRoute("PUT", "/financial/email-inbox", handlers.Financial.PutEmailInboxHandler)
+	router.AddRoute("DELETE", "/financial/email-inbox", handlers.Financial.DeleteEmailInboxHandler)
+	router.AddRoute("GET", "/financial/drafts/(?P<draftId>[^/]+)", handlers.Financial.GetExpenseDraftHandler)
+	router.AddRoute("POST", "/financial/drafts/(?P<draftId>[^/]+)/confirm", handlers.Financial.ConfirmExpenseDraftHandler)
+	router.AddRoute("GET", "/admin/usage", offload.Large(admin.GetUsageHandler))
+	router.AddRoute("GET", "/admin/llm-costs", offload.Large(admin.GetLLMCostsHandler))
+	router.AddRoute("GET", "/admin/maintenance", admin.GetMaintenanceHandler)
+	router.AddRoute("PUT", "/admin/maintenance", admin.PutMaintenanceHandler, api.AllowedInMaintenance)
+	router.AddRoute("GET", "/admin/inspect/groups/(?P<groupId>[^/]+)", handlers.Admin.InspectGroupHandler)
+	router.AddRoute("GET", "/admin/inspect/groups/(?P<groupId>[^/]+)/expenses/(?P<expenseId>[^/]+)", handlers.Admin.InspectExpenseHandler)
+	router.AddRoute("GET", "/admin/inspect/users/(?P<userId>[^/]+)/messages/(?P<messageId>[^/]+)", handlers.Admin.InspectMessageHandler)
+	router.AddRoute("POST", "/admin/replay/analytics", handlers.Admin.ReplayAnalyticsHandler)
+	router.AddRoute("PUT", "/admin/users/(?P<userId>[^/]+)/plan", handlers.Admin.PutUserPlanHandler)
+	router.AddRoute("GET", "/admin/groups/(?P<groupId>[^/]+)/residency", handlers.Admin.GetGroupResidencyHandler)
+	router.AddRoute("PUT", "/admin/groups/(?P<groupId>[^/]+)/residency", handlers.Admin.PutGroupResidencyHandler)
+	router.AddRoute("GET", "/admin/dead-letters", admin.GetDeadLetterQueuesHandler)
+	router.AddRoute("GET", "/admin/dead-letters/(?P<queue>[^/]+)", admin.GetDeadLettersHandler)
+	router.AddRoute("POST", "/admin/dead-letters/(?P<queue>[^/]+)/requeue", admin.RequeueDeadLettersHandler)
+	router.AddRoute("GET", "/admin/notices", status.GetNoticesHandler)
+	router.AddRoute("POST", "/admin/notices", status.PostNoticeHandler, api.AllowedInMaintenance)
+	router.AddRoute("PUT", "/admin/notices/(?P<noticeId>[^/]+)", status.PutNoticeHandler, api.AllowedInMaintenance)
+	router.AddRoute("DELETE", "/admin/notices/(?P<noticeId>[^/]+)", status.DeleteNoticeHandler, api.AllowedInMaintenance)
+	router.AddRoute("GET", "/status", status.GetStatusHandler)
+	router.AddRoute("GET", "/capabilities", api.CapabilitiesHandler(router, payloadLimits))
+	router.AddRoute("GET", "/health/deep", status.GetDeepHealthHandler)
+	router.AddRoute("GET", "/financial/expense-split-types", cache.Cached(ResponseCache, referenceDataTTL, financial.GetExpenseSplitTypeHandler))
+	router.AddRoute("GET", "/financial/expense-categories", cache.Cached(ResponseCache, referenceDataTTL, financial.GetExpenseCategoriesHandler))
+	router.AddRoute("GET", "/financial/group-templates", cache.Cached(ResponseCache, referenceDataTTL, financial.GetGroupTemplatesHandler))
+}
@@ -0,0 +1,259 @@
+// Package shadow sends a share of the requests of a route to a candidate handler too, e.g. a
+// rewrite of the balances, and logs where its responses differ from the handler serving the
+// request. The response of the candidate is only compared, never returned, so a rewrite is
+// exercised with the production traffic before the route switches over to it.
+//
+// A route is shadowed with the Compared option, naming the experiment:
+//
+//	router.AddRoute("GET", "/financial/me/net-debts", handler, shadow.Compared("net-debts", candidate))
+//
+// and the share of its requests sent to the candidate is set with SHADOW_TRAFFIC. Only the
+// read-only routes can be shadowed, as the candidate runs on the same request. The candidate
+// should read through a client of its own, as it may outlive the request.
+package shadow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Rates is the share of the requests of each experiment sent to its candidate, from 0 to 1.
+// The experiments not listed send it nothing.
+var Rates = map[string]float64{}
+
+// Rand returns a number in [0, 1), deciding which requests are sent to the candidate.
+var Rand = rand.Float64
+
+// Timeout is how long the response waits for the candidate once the handler is done, kept
+// short as it delays the response. The candidates running longer are reported as timed out.
+var Timeout = 100 * time.Millisecond
+
+// maxDifferences is the number of differences logged at most for a request.
+const maxDifferences = 10
+
+// Comparison is the outcome of a request sent to both the handler and the candidate, logged
+// as JSON.
+type Comparison struct {
+	Experiment      string   `json:"experiment"`
+	Method          string   `json:"method"`
+	Path            string   `json:"path"`
+	Match           bool     `json:"match"`
+	Status          int      `json:"status"`
+	CandidateStatus int      `json:"candidateStatus,omitempty"`
+	Millis          int64    `json:"millis"`
+	CandidateMillis int64    `json:"candidateMillis,omitempty"`
+	Differences     []string `json:"differences,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// Parse parses a spec like "balances=0.1,search=0.05", the share of the requests of each
+// experiment sent to its candidate.
+func Parse(spec string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, field := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid experiment %q", field)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid rate %q of experiment %s", value, name)
+		}
+		rates[name] = rate
+	}
+	return rates, nil
+}
+
+// FromEnv returns the rates of SHADOW_TRAFFIC, or none when it isn't set.
+func FromEnv() (map[string]float64, error) {
+	spec := os.Getenv("SHADOW_TRAFFIC")
+	if spec == "" {
+		return map[string]float64{}, nil
+	}
+	return Parse(spec)
+}
+
+type outcome struct {
+	response events.APIGatewayProxyResponse
+	err      error
+	duration time.Duration
+}
+
+// run calls the handler, turning its panics into errors so a broken candidate can't take
+// the request down.
+func run(handler api.HandlerFunc, request events.APIGatewayProxyRequest) (result outcome) {
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.err = fmt.Errorf("panic: %v", recovered)
+		}
+		result.duration = time.Since(start)
+	}()
+	result.response, result.err = handler(request)
+	return result
+}
+
+// Compared sends the share of the requests of the route set in Rates for the experiment to
+// the candidate too. It panics when the route isn't read-only, so give it after the options
+// marking the route as such.
+func Compared(experiment string, candidate api.HandlerFunc) api.RouteOption {
+	return func(route *api.Route) {
+		if !route.ReadOnly {
+			panic(fmt.Sprintf("shadow: %s %s isn't read-only, experiment %s would run its side effects twice", route.Method, route.Template, experiment))
+		}
+		route.Handler = compared(experiment, candidate, route.Handler)
+	}
+}
+
+// compared serves the requests with next, sending the share of them set in Rates for the
+// experiment to the candidate too, at the same time. The responses are compared once the
+// candidate is done, within Timeout of next, and the comparison logged and published as the
+// ShadowMismatch metric.
+func compared(experiment string, candidate, next api.HandlerFunc) api.HandlerFunc {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		rate := Rates[experiment]
+		if rate <= 0 || Rand() >= rate {
+			return next(request)
+		}
+
+		candidateDone := make(chan outcome, 1)
+		go func() { candidateDone <- run(candidate, request) }()
+		start := time.Now()
+		response, err := next(request)
+		elapsed := time.Since(start)
+
+		comparison := Comparison{
+			Experiment: experiment,
+			Method:     request.HTTPMethod,
+			Path:       api.LoggedPath(request.Path),
+			Status:     response.StatusCode,
+			Millis:     elapsed.Milliseconds(),
+		}
+		select {
+		case shadowed := <-candidateDone:
+			comparison.CandidateStatus = shadowed.response.StatusCode
+			comparison.CandidateMillis = shadowed.duration.Milliseconds()
+			if shadowed.err != nil {
+				comparison.Error = shadowed.err.Error()
+			} else {
+				comparison.Differences = differences(response, shadowed.response)
+				comparison.Match = err == nil && len(comparison.Differences) == 0
+			}
+		case <-time.After(Timeout):
+			comparison.Error = "candidate timed out"
+		}
+		report(comparison)
+		return response, err
+	}
+}
+
+// report logs the comparison and publishes whether it matched.
+func report(comparison Comparison) {
+	line, err := json.Marshal(comparison)
+	if err != nil {
+		log.Printf("Error marshalling shadow comparison: %v", err)
+		return
+	}
+	log.Printf("Shadow comparison: %s", line)
+
+	mismatch := 0.0
+	if !comparison.Match {
+		mismatch = 1
+	}
+	metrics.Emit(map[string]string{"Experiment": comparison.Experiment},
+		metrics.Metric{Name: "ShadowMismatch", Unit: metrics.UnitCount, Value: mismatch},
+		metrics.Metric{Name: "ShadowCandidateLatency", Unit: metrics.UnitMilliseconds, Value: float64(comparison.CandidateMillis)},
+	)
+}
+
+// differences lists where the candidate response differs from the response returned: the
+// status and, for JSON bodies, the path of every value differing, e.g. "$.balances[1].amount".
+// The headers aren't compared, they hold request IDs and timings.
+func differences(primary, candidate events.APIGatewayProxyResponse) []string {
+	var found []string
+	if primary.StatusCode != candidate.StatusCode {
+		found = append(found, fmt.Sprintf("status: %d != %d", primary.StatusCode, candidate.StatusCode))
+	}
+
+	var primaryBody, candidateBody interface{}
+	primaryErr := decode(primary.Body, &primaryBody)
+	candidateErr := decode(candidate.Body, &candidateBody)
+	if primaryErr != nil || candidateErr != nil {
+		if primary.Body != candidate.Body {
+			found = append(found, "body")
+		}
+		return found
+	}
+	return diff("$", primaryBody, candidateBody, found)
+}
+
+// decode unmarshals a JSON body keeping the numbers as written, so "10.50" and "10.5" differ
+// the way the clients see them.
+func decode(body string, value *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(body)))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
+
+func diff(path string, a, b interface{}, found []string) []string {
+	if len(found) >= maxDifferences {
+		return found
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			return append(found, path)
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for key := range a {
+			keys = append(keys, key)
+		}
+		for key := range b {
+			if _, ok := a[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			aValue, inA := a[key]
+			bValue, inB := b[key]
+			if inA != inB {
+				found = append(found, path+"."+key)
+				continue
+			}
+			found = diff(path+"."+key, aValue, bValue, found)
+		}
+		return found
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			return append(found, path)
+		}
+		if len(a) != len(b) {
+			return append(found, fmt.Sprintf("%s: %d items != %d", path, len(a), len(b)))
+		}
+		for i := range a {
+			found = diff(fmt.Sprintf("%s[%d]", path, i), a[i], b[i], found)
+		}
+		return found
+	default:
+		if !reflect.DeepEqual(a, b) {
+			return append(found, path)
+		}
+		return found
+	}
+}
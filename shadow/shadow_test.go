@@ -0,0 +1,157 @@
+package shadow
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"vassistant-backend/api"
+	"vassistant-backend/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	rates, err := Parse("balances=0.1, search=1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"balances": 0.1, "search": 1}, rates)
+
+	for _, spec := range []string{"balances", "balances=2", "=0.1", "balances=some"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+// respond returns a handler answering with the body, counting its calls.
+func respond(body string, calls *int) func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		*calls++
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: body}, nil
+	}
+}
+
+// comparisons captures the comparisons logged while running f.
+func comparisons(t *testing.T, f func()) []Comparison {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	metrics.Output = &bytes.Buffer{}
+	defer func() { log.SetOutput(os.Stderr); metrics.Output = os.Stdout }()
+	f()
+
+	var found []Comparison
+	for _, line := range strings.Split(logs.String(), "\n") {
+		_, document, ok := strings.Cut(line, "Shadow comparison: ")
+		if !ok {
+			continue
+		}
+		var comparison Comparison
+		assert.NoError(t, json.Unmarshal([]byte(document), &comparison))
+		found = append(found, comparison)
+	}
+	return found
+}
+
+func TestCompared(t *testing.T) {
+	defer func() { Rates, Rand, Timeout = map[string]float64{}, rand.Float64, 100*time.Millisecond }()
+	Rates = map[string]float64{"balances": 0.5}
+	draw := 0.7
+	Rand = func() float64 { return draw }
+
+	var served, shadowed int
+	handler := compared("balances",
+		respond(`{"balances":[{"userId":"user-1","amount":"10.5"},{"userId":"user-2","amount":"-10.5"}]}`, &shadowed),
+		respond(`{"balances":[{"userId":"user-1","amount":"10.50"},{"userId":"user-2","amount":"-10.50"}]}`, &served))
+
+	// The requests outside the share only go to the handler
+	logged := comparisons(t, func() {
+		response, err := handler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/financial/groups/house/balances"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Body, "10.50")
+	})
+	assert.Empty(t, logged)
+	assert.Equal(t, 0, shadowed)
+
+	// The others go to the candidate too, whose response is compared but not returned
+	draw = 0.2
+	logged = comparisons(t, func() {
+		response, err := handler(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/financial/groups/house/balances"})
+		assert.NoError(t, err)
+		assert.Contains(t, response.Body, "10.50")
+	})
+	assert.Equal(t, 1, shadowed)
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, "balances", logged[0].Experiment)
+		assert.False(t, logged[0].Match)
+		assert.Equal(t, []string{"$.balances[0].amount", "$.balances[1].amount"}, logged[0].Differences)
+	}
+
+	// A failing candidate doesn't fail the request
+	handler = compared("balances", func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		panic("not implemented")
+	}, respond(`{}`, &served))
+	logged = comparisons(t, func() {
+		response, err := handler(events.APIGatewayProxyRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, 200, response.StatusCode)
+	})
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, "panic: not implemented", logged[0].Error)
+	}
+
+	// Nor does a slow one
+	Timeout = 10 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	handler = compared("balances", func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		<-release
+		return events.APIGatewayProxyResponse{}, errors.New("too late")
+	}, respond(`{}`, &served))
+	logged = comparisons(t, func() {
+		_, err := handler(events.APIGatewayProxyRequest{})
+		assert.NoError(t, err)
+	})
+	if assert.Len(t, logged, 1) {
+		assert.Equal(t, "candidate timed out", logged[0].Error)
+	}
+}
+
+func TestComparedRoutes(t *testing.T) {
+	defer func() { Rates, Rand = map[string]float64{}, rand.Float64 }()
+	Rates = map[string]float64{"balances": 1}
+	Rand = func() float64 { return 0 }
+
+	var served, shadowed int
+	router := api.NewRouter()
+	router.AddRoute("GET", "/financial/groups/(?P<groupId>[^/]+)/balances", respond(`{}`, &served), Compared("balances", respond(`{}`, &shadowed)))
+	router.AddRoute("POST", "/financial/balances/search", respond(`{}`, &served), api.ReadOnly, Compared("balances", respond(`{}`, &shadowed)))
+	comparisons(t, func() {
+		response, err := router.Serve(events.APIGatewayProxyRequest{HTTPMethod: "GET", Path: "/financial/groups/house/balances"})
+		assert.NoError(t, err)
+		assert.Equal(t, 200, response.StatusCode)
+	})
+	assert.Equal(t, 1, served)
+	assert.Equal(t, 1, shadowed)
+
+	// The candidates of mutating routes would run their side effects twice
+	assert.Panics(t, func() {
+		router.AddRoute("POST", "/financial/groups/(?P<groupId>[^/]+)/expenses", respond(`{}`, &served), Compared("expenses", respond(`{}`, &shadowed)))
+	})
+	assert.Panics(t, func() {
+		router.AddRoute("GET", "/notifications/unsubscribe", respond(`{}`, &served), api.Mutating, Compared("unsubscribe", respond(`{}`, &shadowed)))
+	})
+}
+
+func TestDifferences(t *testing.T) {
+	same := events.APIGatewayProxyResponse{StatusCode: 200, Body: `{"a":1,"b":[1,2]}`}
+	assert.Empty(t, differences(same, events.APIGatewayProxyResponse{StatusCode: 200, Body: `{"b":[1,2],"a":1}`}))
+	assert.Equal(t, []string{"status: 200 != 500", "$.a", "$.b: 2 items != 1", "$.c"},
+		differences(same, events.APIGatewayProxyResponse{StatusCode: 500, Body: `{"a":2,"b":[1],"c":null}`}))
+	assert.Equal(t, []string{"body"},
+		differences(events.APIGatewayProxyResponse{Body: "a,b"}, events.APIGatewayProxyResponse{Body: "a,c"}))
+}
@@ -0,0 +1,164 @@
+package status
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"vassistant-backend/admin"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// CheckTimeout bounds each check of the deep health, so a dependency that hangs is reported
+// as failing instead of holding the response.
+var CheckTimeout = 3 * time.Second
+
+// The statuses of the checks and of the deep health.
+const (
+	CheckOK        = "ok"
+	CheckFailing   = "failing"
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// Check checks a downstream dependency of the service, returning why it isn't usable.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Checks are the dependencies the deep health checks, configured with the clients.
+var Checks []Check
+
+// CheckResult is the outcome of a check, Error being the error of the dependency.
+type CheckResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthResponse is the response of the deep health endpoint, degraded when a check fails.
+type HealthResponse struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// BucketAPI defines the S3 operation used to check a bucket.
+type BucketAPI interface {
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+}
+
+// TableCheck checks the table can be read, with a scan of a single item.
+func TableCheck(client common.DynamoDBAPI, table string) Check {
+	return Check{Name: "dynamodb:" + table, Run: func(ctx context.Context) error {
+		_, err := client.Scan(ctx, &dynamodb.ScanInput{TableName: aws.String(table), Limit: aws.Int32(1)})
+		return err
+	}}
+}
+
+// BucketCheck checks the bucket exists and is reachable with the permissions of the Lambda.
+func BucketCheck(client BucketAPI, bucket string) Check {
+	return Check{Name: "s3:" + bucket, Run: func(ctx context.Context) error {
+		_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		return err
+	}}
+}
+
+// HTTPCheck checks the service at the URL answers, e.g. the language model gateway. Any
+// response but a server error counts, the checks not being authenticated.
+func HTTPCheck(name, url string, client *http.Client) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode >= 500 {
+			return fmt.Errorf("status %d", response.StatusCode)
+		}
+		return nil
+	}}
+}
+
+// runCheck runs the check within the timeout. The check runs on its own goroutine, so even
+// a check ignoring its context doesn't delay the result past the timeout.
+func runCheck(ctx context.Context, check Check, timeout time.Duration) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Run(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{Name: check.Name, Status: CheckOK, LatencyMs: time.Since(started).Milliseconds()}
+	if err != nil {
+		result.Status, result.Error = CheckFailing, err.Error()
+	}
+	return result
+}
+
+// deepHealth runs the checks concurrently, reporting them in their order.
+func deepHealth(ctx context.Context, checks []Check, timeout time.Duration) HealthResponse {
+	health := HealthResponse{Status: HealthOK, Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health.Checks[i] = runCheck(ctx, check, timeout)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range health.Checks {
+		if result.Status != CheckOK {
+			health.Status = HealthDegraded
+		}
+	}
+	return health
+}
+
+// GetDeepHealthHandler checks the downstream dependencies of the service for the admins,
+// answering 503 when one of them fails so the monitors can alarm on the status alone.
+func GetDeepHealthHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(admin.AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	health := deepHealth(context.TODO(), Checks, CheckTimeout)
+	if health.Status != HealthOK {
+		for _, result := range health.Checks {
+			if result.Status != CheckOK {
+				log.Printf("Health check %s failing: %s", result.Name, result.Error)
+			}
+		}
+		return jsonResponse(503, health)
+	}
+	return jsonResponse(200, health)
+}
@@ -0,0 +1,75 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"vassistant-backend/admin"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeepHealth(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	hang := make(chan struct{})
+	defer close(hang)
+	Checks = []Check{
+		TableCheck(fake, "splitter-expenses"),
+		HTTPCheck("llm", server.URL, server.Client()),
+		HTTPCheck("down", server.URL+"/down", server.Client()),
+		{Name: "ses", Run: func(ctx context.Context) error { return errors.New("access denied") }},
+		{Name: "hanging", Run: func(ctx context.Context) error { <-hang; return nil }},
+	}
+	CheckTimeout = 50 * time.Millisecond
+	defer func() { Checks, CheckTimeout = nil, 3*time.Second }()
+
+	// Only the admins can check the health
+	response, err := GetDeepHealthHandler(testutil.NewRequest("GET", "/health/deep").WithClaims("user-1", "alice").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// The failing and hanging dependencies degrade the health, without holding the response
+	started := time.Now()
+	response, err = GetDeepHealthHandler(testutil.NewRequest("GET", "/health/deep").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", admin.AdminGroup).
+		Build())
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode)
+	var health HealthResponse
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &health))
+	assert.Equal(t, HealthDegraded, health.Status)
+	statuses := map[string]string{}
+	for _, result := range health.Checks {
+		statuses[result.Name] = result.Status
+	}
+	assert.Equal(t, map[string]string{
+		"dynamodb:splitter-expenses": CheckOK,
+		"llm":                        CheckOK,
+		"down":                       CheckFailing,
+		"ses":                        CheckFailing,
+		"hanging":                    CheckFailing,
+	}, statuses)
+	assert.Equal(t, "access denied", health.Checks[3].Error)
+	assert.Equal(t, context.DeadlineExceeded.Error(), health.Checks[4].Error)
+
+	// The health is ok while the dependencies are
+	health = deepHealth(context.Background(), Checks[:2], CheckTimeout)
+	assert.Equal(t, HealthOK, health.Status)
+}
@@ -0,0 +1,390 @@
+// Package status serves the status of the service: the planned maintenance windows and the
+// notices the clients show as a banner, managed by the admins.
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"vassistant-backend/admin"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+var DynamoDbClient common.DynamoDBAPI
+
+// noticesTable holds the maintenance windows and notices, keyed by noticeId.
+const noticesTable = "service-notices"
+
+// The kinds of notices.
+const (
+	KindMaintenance = "maintenance"
+	KindNotice      = "notice"
+)
+
+// The severities of the notices, telling the clients how to style the banner.
+var severities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// maxNoticeMessageLength bounds the message of a notice, in characters.
+const maxNoticeMessageLength = 500
+
+// The overall statuses of the service.
+const (
+	StatusOK          = "ok"
+	StatusMaintenance = "maintenance"
+)
+
+// Notice is a planned maintenance window or a service notice. Maintenance windows have both
+// a start and an end; the notices are shown from their start, if any, until their end, if
+// any.
+type Notice struct {
+	NoticeID  string `json:"noticeId" dynamodbav:"noticeId"`
+	Kind      string `json:"kind" dynamodbav:"kind"`
+	Severity  string `json:"severity" dynamodbav:"severity"`
+	Title     string `json:"title" dynamodbav:"title"`
+	Message   string `json:"message,omitempty" dynamodbav:"message,omitempty"`
+	StartsAt  string `json:"startsAt,omitempty" dynamodbav:"startsAt,omitempty"`
+	EndsAt    string `json:"endsAt,omitempty" dynamodbav:"endsAt,omitempty"`
+	CreatedBy string `json:"createdBy,omitempty" dynamodbav:"createdBy,omitempty"`
+	CreatedAt string `json:"createdAt,omitempty" dynamodbav:"createdAt,omitempty"`
+	UpdatedAt string `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+}
+
+// StatusResponse is the response of the status endpoint. Maintenance lists the ongoing and
+// upcoming maintenance windows, Notices the notices to show now.
+type StatusResponse struct {
+	Status      string   `json:"status"`
+	ReadOnly    bool     `json:"readOnly"`
+	Maintenance []Notice `json:"maintenance"`
+	Notices     []Notice `json:"notices"`
+}
+
+// NoticeRequest is the body of the notice creation and update endpoints.
+type NoticeRequest struct {
+	Kind     string `json:"kind"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	StartsAt string `json:"startsAt"`
+	EndsAt   string `json:"endsAt"`
+}
+
+// validate checks the request and normalizes its times to UTC, returning the message of the
+// 400 response when invalid.
+func (r *NoticeRequest) validate() string {
+	if r.Kind == "" {
+		r.Kind = KindNotice
+	}
+	if r.Kind != KindMaintenance && r.Kind != KindNotice {
+		return "kind must be maintenance or notice"
+	}
+	if r.Severity == "" {
+		r.Severity = "info"
+	}
+	if !severities[r.Severity] {
+		return "severity must be info, warning or critical"
+	}
+	r.Title = strings.TrimSpace(r.Title)
+	if r.Title == "" {
+		return "title is required"
+	}
+	if utf8.RuneCountInString(r.Message) > maxNoticeMessageLength {
+		return "message is too long"
+	}
+
+	var startsAt, endsAt time.Time
+	for _, field := range []struct {
+		name  string
+		value *string
+		time  *time.Time
+	}{{"startsAt", &r.StartsAt, &startsAt}, {"endsAt", &r.EndsAt, &endsAt}} {
+		if *field.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, *field.value)
+		if err != nil {
+			return field.name + " must be an RFC 3339 time"
+		}
+		*field.time = parsed.UTC()
+		*field.value = field.time.Format(time.RFC3339)
+	}
+	if r.Kind == KindMaintenance && (startsAt.IsZero() || endsAt.IsZero()) {
+		return "maintenance windows need startsAt and endsAt"
+	}
+	if !startsAt.IsZero() && !endsAt.IsZero() && !endsAt.After(startsAt) {
+		return "endsAt must be after startsAt"
+	}
+	return ""
+}
+
+// listNotices returns every notice, past ones included, ordered by start.
+func listNotices(ctx context.Context) ([]Notice, error) {
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(noticesTable),
+	}
+
+	notices := []Notice{}
+	for {
+		result, err := DynamoDbClient.Scan(ctx, scanInput)
+		if err != nil {
+			return nil, err
+		}
+		var page []Notice
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		notices = append(notices, page...)
+
+		scanInput.ExclusiveStartKey = result.LastEvaluatedKey
+		if len(scanInput.ExclusiveStartKey) == 0 {
+			break
+		}
+	}
+
+	// The times are stored in UTC, so they sort as strings
+	sort.Slice(notices, func(i, j int) bool {
+		if notices[i].StartsAt != notices[j].StartsAt {
+			return notices[i].StartsAt < notices[j].StartsAt
+		}
+		return notices[i].NoticeID < notices[j].NoticeID
+	})
+	return notices, nil
+}
+
+// currentStatus builds the status at now from the notices and the maintenance mode.
+func currentStatus(notices []Notice, readOnly bool, now time.Time) StatusResponse {
+	status := StatusResponse{Status: StatusOK, ReadOnly: readOnly, Maintenance: []Notice{}, Notices: []Notice{}}
+	if readOnly {
+		status.Status = StatusMaintenance
+	}
+	at := now.UTC().Format(time.RFC3339)
+	for _, notice := range notices {
+		if notice.EndsAt != "" && notice.EndsAt <= at {
+			continue
+		}
+		if notice.Kind == KindMaintenance {
+			// Upcoming windows are listed so the clients can warn ahead
+			status.Maintenance = append(status.Maintenance, notice)
+			if notice.StartsAt <= at {
+				status.Status = StatusMaintenance
+			}
+			continue
+		}
+		if notice.StartsAt == "" || notice.StartsAt <= at {
+			status.Notices = append(status.Notices, notice)
+		}
+	}
+	return status
+}
+
+// GetStatusHandler serves the status of the service. It is public, so the clients can show
+// the banner before signing in.
+func GetStatusHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	notices, err := listNotices(ctx)
+	if err != nil {
+		log.Printf("Error scanning notices from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	readOnly := admin.Maintenance != nil && admin.Maintenance.Mode(ctx).ReadOnly
+
+	return jsonResponse(200, currentStatus(notices, readOnly, time.Now()))
+}
+
+func GetNoticesHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(admin.AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	notices, err := listNotices(context.TODO())
+	if err != nil {
+		log.Printf("Error scanning notices from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return jsonResponse(200, notices)
+}
+
+func PostNoticeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(admin.AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	var incoming NoticeRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if message := incoming.validate(); message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	notice := Notice{
+		NoticeID:  uuid.New().String(),
+		Kind:      incoming.Kind,
+		Severity:  incoming.Severity,
+		Title:     incoming.Title,
+		Message:   incoming.Message,
+		StartsAt:  incoming.StartsAt,
+		EndsAt:    incoming.EndsAt,
+		CreatedBy: claims.Username,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = common.ConditionalPutItem(context.TODO(), DynamoDbClient, noticesTable, notice, common.IfNotExists("noticeId"))
+	if err != nil {
+		log.Printf("Error putting notice into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return jsonResponse(201, notice)
+}
+
+func PutNoticeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+	ctx := context.TODO()
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(admin.AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	// Extract noticeId from path parameters
+	noticeId := request.PathParameters["noticeId"]
+	if noticeId == "" {
+		return common.CreateErrorResponse(400, "noticeId is required")
+	}
+
+	var incoming NoticeRequest
+	if err := json.Unmarshal([]byte(request.Body), &incoming); err != nil {
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	if message := incoming.validate(); message != "" {
+		return common.CreateErrorResponse(400, message)
+	}
+
+	existing, err := getNotice(ctx, noticeId)
+	if err != nil {
+		log.Printf("Error getting notice from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	if existing == nil {
+		return common.CreateErrorResponse(404, "Notice not found")
+	}
+
+	notice := *existing
+	notice.Kind = incoming.Kind
+	notice.Severity = incoming.Severity
+	notice.Title = incoming.Title
+	notice.Message = incoming.Message
+	notice.StartsAt = incoming.StartsAt
+	notice.EndsAt = incoming.EndsAt
+	notice.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	err = common.ConditionalPutItem(ctx, DynamoDbClient, noticesTable, notice, common.IfExists("noticeId"))
+	if errors.Is(err, common.ErrConditionFailed) {
+		return common.CreateErrorResponse(404, "Notice not found")
+	}
+	if err != nil {
+		log.Printf("Error putting notice into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return jsonResponse(200, notice)
+}
+
+func DeleteNoticeHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+	if !claims.HasGroup(admin.AdminGroup) {
+		return common.CreateErrorResponse(403, "Forbidden")
+	}
+
+	// Extract noticeId from path parameters
+	noticeId := request.PathParameters["noticeId"]
+	if noticeId == "" {
+		return common.CreateErrorResponse(400, "noticeId is required")
+	}
+
+	_, err = DynamoDbClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(noticesTable),
+		Key: map[string]types.AttributeValue{
+			"noticeId": &types.AttributeValueMemberS{Value: noticeId},
+		},
+	})
+	if err != nil {
+		log.Printf("Error deleting notice from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 204}, nil
+}
+
+// getNotice returns the notice, or nil when it doesn't exist.
+func getNotice(ctx context.Context, noticeId string) (*Notice, error) {
+	result, err := DynamoDbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(noticesTable),
+		Key: map[string]types.AttributeValue{
+			"noticeId": &types.AttributeValueMemberS{Value: noticeId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+	var notice Notice
+	if err := attributevalue.UnmarshalMap(result.Item, &notice); err != nil {
+		return nil, err
+	}
+	return &notice, nil
+}
+
+func jsonResponse(statusCode int, value interface{}) (events.APIGatewayProxyResponse, error) {
+	// Marshal the value into JSON for the payload
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshalling response:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
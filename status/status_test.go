@@ -0,0 +1,119 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+	"vassistant-backend/admin"
+	"vassistant-backend/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrentStatus(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	notices := []Notice{
+		{NoticeID: "past", Kind: KindMaintenance, StartsAt: "2024-04-01T00:00:00Z", EndsAt: "2024-04-01T01:00:00Z"},
+		{NoticeID: "ongoing", Kind: KindMaintenance, StartsAt: "2024-05-01T11:00:00Z", EndsAt: "2024-05-01T13:00:00Z"},
+		{NoticeID: "upcoming", Kind: KindMaintenance, StartsAt: "2024-05-08T00:00:00Z", EndsAt: "2024-05-08T02:00:00Z"},
+		{NoticeID: "open", Kind: KindNotice},
+		{NoticeID: "later", Kind: KindNotice, StartsAt: "2024-06-01T00:00:00Z"},
+		{NoticeID: "expired", Kind: KindNotice, EndsAt: "2024-05-01T12:00:00Z"},
+	}
+
+	status := currentStatus(notices, false, now)
+	assert.Equal(t, StatusMaintenance, status.Status)
+	assert.Equal(t, []Notice{notices[1], notices[2]}, status.Maintenance)
+	assert.Equal(t, []Notice{notices[3]}, status.Notices)
+
+	// Upcoming windows don't change the status, the read-only mode does
+	status = currentStatus(notices[2:], false, now)
+	assert.Equal(t, StatusOK, status.Status)
+	status = currentStatus(nil, true, now)
+	assert.Equal(t, StatusMaintenance, status.Status)
+	assert.True(t, status.ReadOnly)
+}
+
+func TestNotices(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	// Only the admins manage the notices
+	request := testutil.NewRequest("POST", "").
+		WithClaims("user-1", "alice").
+		WithJSONBody(t, NoticeRequest{Title: "Hello"}).
+		Build()
+	response, err := PostNoticeHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	post := func(incoming NoticeRequest) Notice {
+		response, err := PostNoticeHandler(testutil.NewRequest("POST", "").
+			WithClaims("admin-1", "root").
+			WithClaim("cognito:groups", admin.AdminGroup).
+			WithJSONBody(t, incoming).
+			Build())
+		assert.NoError(t, err)
+		var notice Notice
+		if response.StatusCode == http.StatusCreated {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &notice))
+		}
+		return notice
+	}
+
+	// Maintenance windows need their start and end
+	assert.Empty(t, post(NoticeRequest{Kind: KindMaintenance, Title: "Database upgrade"}).NoticeID)
+	window := post(NoticeRequest{
+		Kind:     KindMaintenance,
+		Severity: "warning",
+		Title:    "Database upgrade",
+		StartsAt: time.Now().Add(-time.Hour).Format(time.RFC3339),
+		EndsAt:   time.Now().Add(time.Hour).Format(time.RFC3339),
+	})
+	assert.NotEmpty(t, window.NoticeID)
+	notice := post(NoticeRequest{Title: "New app version"})
+	assert.Equal(t, "info", notice.Severity)
+
+	// The status endpoint is public
+	response, err = GetStatusHandler(testutil.NewRequest("GET", "").Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var status StatusResponse
+	assert.NoError(t, json.Unmarshal([]byte(response.Body), &status))
+	assert.Equal(t, StatusMaintenance, status.Status)
+	assert.Equal(t, []Notice{window}, status.Maintenance)
+	assert.Equal(t, []Notice{notice}, status.Notices)
+
+	// Ending the window early takes it off the status
+	response, err = PutNoticeHandler(testutil.NewRequest("PUT", "").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", admin.AdminGroup).
+		WithPathParam("noticeId", window.NoticeID).
+		WithJSONBody(t, NoticeRequest{Kind: KindMaintenance, Title: "Database upgrade", StartsAt: window.StartsAt, EndsAt: time.Now().Add(-time.Minute).Format(time.RFC3339)}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = DeleteNoticeHandler(testutil.NewRequest("DELETE", "").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", admin.AdminGroup).
+		WithPathParam("noticeId", notice.NoticeID).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	response, err = GetStatusHandler(testutil.NewRequest("GET", "").Build())
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"status":"ok","readOnly":false,"maintenance":[],"notices":[]}`, response.Body)
+
+	// Updating a missing notice is a 404
+	response, err = PutNoticeHandler(testutil.NewRequest("PUT", "").
+		WithClaims("admin-1", "root").
+		WithClaim("cognito:groups", admin.AdminGroup).
+		WithPathParam("noticeId", "missing").
+		WithJSONBody(t, NoticeRequest{Title: "Gone"}).
+		Build())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
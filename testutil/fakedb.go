@@ -0,0 +1,394 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableKeys lists the key attributes of each table, partition key first.
+var tableKeys = map[string][]string{
+	"admin-audit":                    {"auditId"},
+	"api-keys":                       {"keyId"},
+	"assistant-memories":             {"userId", "memoryId"},
+	"assistant-onboarding":           {"userId"},
+	"assistant-permissions":          {"userId"},
+	"assistant-proactive":            {"userId"},
+	"assistant-reply-queue":          {"userId", "messageId"},
+	"assistant-tool-audit":           {"userId", "id"},
+	"assistant-traces":               {"userId", "messageId"},
+	"chat":                           {"userId", "createdAt"},
+	"chat-conversations":             {"userId", "conversationId"},
+	"device-keys":                    {"userId", "deviceId"},
+	"embeddings":                     {"namespace", "itemId"},
+	"encryption-keys":                {"ownerId"},
+	"fx-rates":                       {"pair", "date"},
+	"llm-costs":                      {"day", "id"},
+	"maintenance-mode":               {"name"},
+	"notification-preferences":       {"userId"},
+	"notifications":                  {"userId", "createdAt"},
+	"referral-codes":                 {"code"},
+	"referrals":                      {"referrerId", "referredId"},
+	"replay-cache":                   {"deliveryKey"},
+	"service-notices":                {"noticeId"},
+	"splitter-email-inboxes":         {"inboxId"},
+	"splitter-expense-approvals":     {"groupId", "expenseId"},
+	"splitter-expense-drafts":        {"draftId"},
+	"splitter-expenses":              {"groupId", "expenseId"},
+	"splitter-group-chat":            {"groupId", "createdAt"},
+	"splitter-group-balances":        {"groupId"},
+	"splitter-group-deletions":       {"groupId"},
+	"splitter-group-insights":        {"groupId", "period"},
+	"splitter-group-members":         {"userId", "groupId"},
+	"splitter-group-residency":       {"groupId"},
+	"splitter-group-settings":        {"groupId"},
+	"splitter-guest-links":           {"linkId"},
+	"splitter-join-requests":         {"groupId", "userId"},
+	"splitter-ledger":                {"groupId", "sequence"},
+	"splitter-nicknames":             {"userId", "memberId"},
+	"splitter-receipts":              {"receiptId"},
+	"splitter-recurring-expenses":    {"groupId", "recurringId"},
+	"splitter-recurring-suggestions": {"groupId", "suggestionId"},
+	"splitter-reimbursements":        {"groupId", "reimbursementId"},
+	"splitter-sheet-links":           {"groupId"},
+	"splitter-shopping-items":        {"groupId", "itemId"},
+	"splitter-spending-caps":         {"groupId"},
+	"usage-metrics":                  {"hour", "id"},
+	"vassistant-users":               {"userId"},
+	"websocket-connections":          {"userId", "connectionId"},
+}
+
+// TableKeys returns the key attributes of each table of the fake, partition key first, for
+// the tests checking a table is registered where it must be.
+func TableKeys() map[string][]string {
+	return maps.Clone(tableKeys)
+}
+
+// indexKeys lists the key attributes of each global secondary index, partition key first.
+var indexKeys = map[string][]string{
+	"groupId-dateTime-index": {"groupId", "dateTime"},
+	"groupId-index":          {"groupId"},
+	"userId-index":           {"userId"},
+}
+
+// FakeDynamoDB is an in-memory DynamoDB supporting the access patterns used by the handlers:
+// key lookups, scans, deletes, transactional puts, key conditions with sort key comparisons, projections and
+// the conditions built by the common write helpers.
+type FakeDynamoDB struct {
+	common.DynamoDBAPI
+	tables map[string][]map[string]types.AttributeValue
+}
+
+// NewFakeDynamoDB creates a FakeDynamoDB holding the seed items, keyed by table name.
+func NewFakeDynamoDB(seed map[string][]map[string]interface{}) (*FakeDynamoDB, error) {
+	fake := &FakeDynamoDB{tables: map[string][]map[string]types.AttributeValue{}}
+	for table, items := range seed {
+		for _, item := range items {
+			av, err := attributevalue.MarshalMap(item)
+			if err != nil {
+				return nil, err
+			}
+			fake.tables[table] = append(fake.tables[table], av)
+		}
+	}
+	return fake, nil
+}
+
+func (f *FakeDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	keys := tableKeys[aws.ToString(params.TableName)]
+	if params.IndexName != nil {
+		keys = indexKeys[aws.ToString(params.IndexName)]
+	}
+
+	// Key conditions are an equality on the partition key, optionally followed by a
+	// comparison on the sort key
+	conditions := strings.Split(aws.ToString(params.KeyConditionExpression), " AND ")
+	var items []map[string]types.AttributeValue
+	for _, item := range f.tables[aws.ToString(params.TableName)] {
+		matched := true
+		for _, condition := range conditions {
+			holds, err := compare(item, condition, params.ExpressionAttributeNames, params.ExpressionAttributeValues)
+			if err != nil {
+				return nil, err
+			}
+			matched = matched && holds
+		}
+		if matched {
+			items = append(items, item)
+		}
+	}
+
+	// Sort by the sort key, if any
+	if len(keys) > 1 {
+		sortKey := keys[1]
+		forward := params.ScanIndexForward == nil || *params.ScanIndexForward
+		sort.SliceStable(items, func(i, j int) bool {
+			if forward {
+				return less(items[i][sortKey], items[j][sortKey])
+			}
+			return less(items[j][sortKey], items[i][sortKey])
+		})
+	}
+	// Resume after the last key of the previous page, and end the page at the limit with
+	// the key of its last item, made of the table and index keys like DynamoDB does
+	if len(params.ExclusiveStartKey) > 0 {
+		for i, item := range items {
+			if matches(item, params.ExclusiveStartKey) {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+	var lastKey map[string]types.AttributeValue
+	if params.Limit != nil && int(*params.Limit) < len(items) {
+		items = items[:*params.Limit]
+		last := items[len(items)-1]
+		lastKey = map[string]types.AttributeValue{}
+		for _, name := range tableKeys[aws.ToString(params.TableName)] {
+			lastKey[name] = last[name]
+		}
+		for _, name := range keys {
+			lastKey[name] = last[name]
+		}
+	}
+
+	projected := make([]map[string]types.AttributeValue, 0, len(items))
+	for _, item := range items {
+		projected = append(projected, project(item, params.ProjectionExpression, params.ExpressionAttributeNames))
+	}
+	return &dynamodb.QueryOutput{Items: projected, Count: int32(len(projected)), LastEvaluatedKey: lastKey}, nil
+}
+
+func (f *FakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	item := f.find(aws.ToString(params.TableName), params.Key)
+	if item == nil {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: project(item, params.ProjectionExpression, params.ExpressionAttributeNames)}, nil
+}
+
+func (f *FakeDynamoDB) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	responses := map[string][]map[string]types.AttributeValue{}
+	for table, request := range params.RequestItems {
+		for _, key := range request.Keys {
+			if item := f.find(table, key); item != nil {
+				responses[table] = append(responses[table], project(item, request.ProjectionExpression, request.ExpressionAttributeNames))
+			}
+		}
+	}
+	return &dynamodb.BatchGetItemOutput{Responses: responses}, nil
+}
+
+func (f *FakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	table := aws.ToString(params.TableName)
+	key := map[string]types.AttributeValue{}
+	for _, name := range tableKeys[table] {
+		key[name] = params.Item[name]
+	}
+
+	existing := f.find(table, key)
+	if params.ConditionExpression != nil && !conditionHolds(existing, *params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues) {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+
+	items := f.tables[table]
+	for i, item := range items {
+		if matches(item, key) {
+			items[i] = params.Item
+			return &dynamodb.PutItemOutput{}, nil
+		}
+	}
+	f.tables[table] = append(items, params.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *FakeDynamoDB) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	table := aws.ToString(params.TableName)
+	existing := f.find(table, params.Key)
+	if params.ConditionExpression != nil && !conditionHolds(existing, *params.ConditionExpression, params.ExpressionAttributeNames, params.ExpressionAttributeValues) {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+
+	items := f.tables[table]
+	for i, item := range items {
+		if matches(item, params.Key) {
+			f.tables[table] = append(items[:i:i], items[i+1:]...)
+			break
+		}
+	}
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// Scan returns every item of the table; filter expressions are not supported.
+func (f *FakeDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if params.FilterExpression != nil {
+		return nil, fmt.Errorf("unsupported filter expression %q", aws.ToString(params.FilterExpression))
+	}
+	items := make([]map[string]types.AttributeValue, 0, len(f.tables[aws.ToString(params.TableName)]))
+	for _, item := range f.tables[aws.ToString(params.TableName)] {
+		items = append(items, project(item, params.ProjectionExpression, params.ExpressionAttributeNames))
+	}
+	return &dynamodb.ScanOutput{Items: items, Count: int32(len(items))}, nil
+}
+
+// TransactWriteItems applies the puts of the transaction, all or none; other writes are not supported.
+func (f *FakeDynamoDB) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	// Check every condition before writing anything
+	reasons := make([]types.CancellationReason, len(params.TransactItems))
+	canceled := false
+	for i, item := range params.TransactItems {
+		put := item.Put
+		if put == nil {
+			return nil, fmt.Errorf("unsupported transaction item %d", i)
+		}
+		key := map[string]types.AttributeValue{}
+		for _, name := range tableKeys[aws.ToString(put.TableName)] {
+			key[name] = put.Item[name]
+		}
+
+		reasons[i] = types.CancellationReason{Code: aws.String("None")}
+		existing := f.find(aws.ToString(put.TableName), key)
+		if put.ConditionExpression != nil && !conditionHolds(existing, *put.ConditionExpression, put.ExpressionAttributeNames, put.ExpressionAttributeValues) {
+			reasons[i] = types.CancellationReason{Code: aws.String("ConditionalCheckFailed")}
+			canceled = true
+		}
+	}
+	if canceled {
+		return nil, &types.TransactionCanceledException{Message: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+	}
+
+	for _, item := range params.TransactItems {
+		_, err := f.PutItem(ctx, &dynamodb.PutItemInput{TableName: item.Put.TableName, Item: item.Put.Item})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// conditionHolds evaluates the condition expressions built by the common write helpers:
+// attribute_exists(#name), attribute_not_exists(#name) and #name = :value.
+func conditionHolds(item map[string]types.AttributeValue, condition string, names map[string]string, values map[string]types.AttributeValue) bool {
+	condition = strings.TrimSpace(condition)
+	if argument, ok := strings.CutPrefix(condition, "attribute_not_exists("); ok {
+		_, exists := item[resolveName(strings.TrimSuffix(argument, ")"), names)]
+		return !exists
+	}
+	if argument, ok := strings.CutPrefix(condition, "attribute_exists("); ok {
+		_, exists := item[resolveName(strings.TrimSuffix(argument, ")"), names)]
+		return exists
+	}
+	if name, placeholder, ok := strings.Cut(condition, " = "); ok {
+		value, exists := item[resolveName(strings.TrimSpace(name), names)]
+		return exists && scalar(value) == scalar(values[strings.TrimSpace(placeholder)])
+	}
+	return false
+}
+
+// keyOperators lists the key condition comparison operators, longest first so that
+// "<=" is not mistaken for "<".
+var keyOperators = []string{" <= ", " >= ", " < ", " > ", " = "}
+
+// compare evaluates a single key condition comparison like #name <= :value.
+// Values are compared as strings, which is enough for the ISO dates and IDs used as keys.
+func compare(item map[string]types.AttributeValue, condition string, names map[string]string, values map[string]types.AttributeValue) (bool, error) {
+	for _, operator := range keyOperators {
+		name, placeholder, ok := strings.Cut(condition, operator)
+		if !ok {
+			continue
+		}
+		value, exists := item[resolveName(strings.TrimSpace(name), names)]
+		if !exists {
+			return false, nil
+		}
+		got, want := scalar(value), scalar(values[strings.TrimSpace(placeholder)])
+		switch strings.TrimSpace(operator) {
+		case "<=":
+			return got <= want, nil
+		case ">=":
+			return got >= want, nil
+		case "<":
+			return got < want, nil
+		case ">":
+			return got > want, nil
+		default:
+			return got == want, nil
+		}
+	}
+	return false, fmt.Errorf("unsupported key condition %q", condition)
+}
+
+func (f *FakeDynamoDB) find(table string, key map[string]types.AttributeValue) map[string]types.AttributeValue {
+	for _, item := range f.tables[table] {
+		if matches(item, key) {
+			return item
+		}
+	}
+	return nil
+}
+
+func matches(item, key map[string]types.AttributeValue) bool {
+	for name, value := range key {
+		if scalar(item[name]) != scalar(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// scalar returns the string representation of a string or number attribute.
+func scalar(av types.AttributeValue) string {
+	switch value := av.(type) {
+	case *types.AttributeValueMemberS:
+		return value.Value
+	case *types.AttributeValueMemberN:
+		return value.Value
+	default:
+		return ""
+	}
+}
+
+// less orders the keys like DynamoDB does: numbers by value, strings by their bytes.
+func less(a, b types.AttributeValue) bool {
+	x, xIsNumber := a.(*types.AttributeValueMemberN)
+	y, yIsNumber := b.(*types.AttributeValueMemberN)
+	if xIsNumber && yIsNumber {
+		first, errFirst := strconv.ParseFloat(x.Value, 64)
+		second, errSecond := strconv.ParseFloat(y.Value, 64)
+		if errFirst == nil && errSecond == nil {
+			return first < second
+		}
+	}
+	return scalar(a) < scalar(b)
+}
+
+func resolveName(name string, names map[string]string) string {
+	if resolved, ok := names[name]; ok {
+		return resolved
+	}
+	return name
+}
+
+// project keeps only the attributes listed in the projection expression.
+func project(item map[string]types.AttributeValue, projection *string, names map[string]string) map[string]types.AttributeValue {
+	if projection == nil {
+		return item
+	}
+	projected := map[string]types.AttributeValue{}
+	for _, name := range strings.Split(*projection, ",") {
+		name = resolveName(strings.TrimSpace(name), names)
+		if value, ok := item[name]; ok {
+			projected[name] = value
+		}
+	}
+	return projected
+}
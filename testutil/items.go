@@ -0,0 +1,63 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MarshalItem marshals the value into a DynamoDB item, failing the test on error.
+func MarshalItem(t testing.TB, value interface{}) map[string]types.AttributeValue {
+	t.Helper()
+	item, err := attributevalue.MarshalMap(value)
+	if err != nil {
+		t.Fatalf("unable to marshal item: %v", err)
+	}
+	return item
+}
+
+// UserItem is a canned vassistant-users item.
+func UserItem(userId, showableName string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"userId":       &types.AttributeValueMemberS{Value: userId},
+		"showableName": &types.AttributeValueMemberS{Value: showableName},
+	}
+}
+
+// GroupMemberItem is a canned splitter-group-members item.
+func GroupMemberItem(userId, groupId, groupName string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"userId":    &types.AttributeValueMemberS{Value: userId},
+		"groupId":   &types.AttributeValueMemberS{Value: groupId},
+		"groupName": &types.AttributeValueMemberS{Value: groupName},
+	}
+}
+
+// ExpenseItem is a canned splitter-expenses item splitting the amount evenly between the participants.
+func ExpenseItem(expenseId, groupId, amount, paidBy string, participants ...string) map[string]types.AttributeValue {
+	participantItems := make([]types.AttributeValue, 0, len(participants))
+	for _, participant := range participants {
+		participantItems = append(participantItems, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: participant},
+			"share":  &types.AttributeValueMemberN{Value: "1"},
+		}})
+	}
+	return map[string]types.AttributeValue{
+		"expenseId":    &types.AttributeValueMemberS{Value: expenseId},
+		"groupId":      &types.AttributeValueMemberS{Value: groupId},
+		"amount":       &types.AttributeValueMemberN{Value: amount},
+		"paidBy":       &types.AttributeValueMemberS{Value: paidBy},
+		"participants": &types.AttributeValueMemberL{Value: participantItems},
+	}
+}
+
+// UsersOutput is a BatchGetItem output returning the given vassistant-users items.
+func UsersOutput(users ...map[string]types.AttributeValue) *dynamodb.BatchGetItemOutput {
+	return &dynamodb.BatchGetItemOutput{
+		Responses: map[string][]map[string]types.AttributeValue{
+			"vassistant-users": users,
+		},
+	}
+}
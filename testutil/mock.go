@@ -0,0 +1,77 @@
+// Package testutil provides request builders, canned DynamoDB items and DynamoDB
+// client doubles shared by the handler tests.
+package testutil
+
+import (
+	"context"
+	"errors"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// ErrUnexpectedCall is returned by MockDynamoDBClient for operations without a mock function.
+var ErrUnexpectedCall = errors.New("unexpected DynamoDB call")
+
+// MockDynamoDBClient is a mock implementation of the DynamoDBAPI interface.
+// Operations without a mock function return ErrUnexpectedCall.
+type MockDynamoDBClient struct {
+	common.DynamoDBAPI
+	QueryFunc        func(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	BatchGetItemFunc func(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	GetItemFunc      func(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItemFunc      func(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItemFunc   func(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	ScanFunc         func(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+
+	TransactWriteItemsFunc func(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+func (m *MockDynamoDBClient) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	if m.QueryFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.QueryFunc(ctx, params, optFns...)
+}
+
+func (m *MockDynamoDBClient) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	if m.BatchGetItemFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.BatchGetItemFunc(ctx, params, optFns...)
+}
+
+func (m *MockDynamoDBClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if m.GetItemFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.GetItemFunc(ctx, params, optFns...)
+}
+
+func (m *MockDynamoDBClient) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	if m.PutItemFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.PutItemFunc(ctx, params, optFns...)
+}
+
+func (m *MockDynamoDBClient) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if m.DeleteItemFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.DeleteItemFunc(ctx, params, optFns...)
+}
+
+func (m *MockDynamoDBClient) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	if m.ScanFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.ScanFunc(ctx, params, optFns...)
+}
+
+func (m *MockDynamoDBClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if m.TransactWriteItemsFunc == nil {
+		return nil, ErrUnexpectedCall
+	}
+	return m.TransactWriteItemsFunc(ctx, params, optFns...)
+}
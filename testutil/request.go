@@ -0,0 +1,87 @@
+package testutil
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RequestBuilder builds APIGatewayProxyRequests for handler tests.
+type RequestBuilder struct {
+	request events.APIGatewayProxyRequest
+}
+
+// NewRequest starts building a request with the given method and path.
+func NewRequest(method, path string) *RequestBuilder {
+	return &RequestBuilder{request: events.APIGatewayProxyRequest{
+		HTTPMethod:            method,
+		Path:                  path,
+		Headers:               map[string]string{},
+		QueryStringParameters: map[string]string{},
+		PathParameters:        map[string]string{},
+	}}
+}
+
+// WithClaims sets the sub and cognito:username claims of the Cognito authorizer.
+func (b *RequestBuilder) WithClaims(sub, username string) *RequestBuilder {
+	b.WithClaim("sub", sub)
+	if username != "" {
+		b.WithClaim("cognito:username", username)
+	}
+	return b
+}
+
+// WithClaim sets a single claim of the Cognito authorizer.
+func (b *RequestBuilder) WithClaim(name string, value interface{}) *RequestBuilder {
+	if b.request.RequestContext.Authorizer == nil {
+		b.request.RequestContext.Authorizer = map[string]interface{}{}
+	}
+	claims, ok := b.request.RequestContext.Authorizer["claims"].(map[string]interface{})
+	if !ok {
+		claims = map[string]interface{}{}
+		b.request.RequestContext.Authorizer["claims"] = claims
+	}
+	claims[name] = value
+	return b
+}
+
+// WithPathParam sets a path parameter, as the router would after matching the path.
+func (b *RequestBuilder) WithPathParam(name, value string) *RequestBuilder {
+	b.request.PathParameters[name] = value
+	return b
+}
+
+// WithQueryParam sets a query string parameter.
+func (b *RequestBuilder) WithQueryParam(name, value string) *RequestBuilder {
+	b.request.QueryStringParameters[name] = value
+	return b
+}
+
+// WithHeader sets a request header.
+func (b *RequestBuilder) WithHeader(name, value string) *RequestBuilder {
+	b.request.Headers[name] = value
+	return b
+}
+
+// WithBody sets the raw request body.
+func (b *RequestBuilder) WithBody(body string) *RequestBuilder {
+	b.request.Body = body
+	return b
+}
+
+// WithJSONBody marshals the value into the request body.
+func (b *RequestBuilder) WithJSONBody(t testing.TB, value interface{}) *RequestBuilder {
+	t.Helper()
+	body, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("unable to marshal request body: %v", err)
+	}
+	b.request.Body = string(body)
+	return b
+}
+
+// Build returns the built request.
+func (b *RequestBuilder) Build() events.APIGatewayProxyRequest {
+	return b.request
+}
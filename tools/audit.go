@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"context"
+	"time"
+	"vassistant-backend/common"
+)
+
+// Outcomes of the audited tool calls
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeDenied    = "denied"
+)
+
+// maxAuditedArguments bounds the length of the arguments kept in the audit log, in bytes.
+const maxAuditedArguments = 2048
+
+// AuditRecord struct for the assistant-tool-audit table, a tool call of the assistant.
+type AuditRecord struct {
+	UserID         string `json:"userId" dynamodbav:"userId"`
+	ID             string `json:"id" dynamodbav:"id"` // RFC3339Nano time of the call and its ID
+	Tool           string `json:"tool" dynamodbav:"tool"`
+	Access         string `json:"access,omitempty" dynamodbav:"access,omitempty"`
+	Persona        string `json:"persona" dynamodbav:"persona"`
+	ConversationID string `json:"conversationId,omitempty" dynamodbav:"conversationId,omitempty"`
+	GroupID        string `json:"groupId,omitempty" dynamodbav:"groupId,omitempty"`
+	Arguments      string `json:"arguments,omitempty" dynamodbav:"arguments,omitempty"`
+	Outcome        string `json:"outcome" dynamodbav:"outcome"`
+	Error          string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	DurationMs     int64  `json:"durationMs" dynamodbav:"durationMs"`
+	ExpiresAt      int64  `json:"-" dynamodbav:"expiresAt"` // DynamoDB TTL attribute, in Unix seconds
+}
+
+// AuditLog records the tool calls.
+type AuditLog interface {
+	Record(ctx context.Context, record AuditRecord) error
+}
+
+// DynamoDBAuditLog records the tool calls in the assistant-tool-audit table, keyed by user
+// and id, with expiresAt as its TTL attribute.
+type DynamoDBAuditLog struct {
+	// Retention is how long the calls are kept before DynamoDB expires them.
+	Retention time.Duration
+}
+
+// NewDynamoDBAuditLog creates a DynamoDBAuditLog keeping the calls for a year.
+func NewDynamoDBAuditLog() *DynamoDBAuditLog {
+	return &DynamoDBAuditLog{Retention: 365 * 24 * time.Hour}
+}
+
+func (a *DynamoDBAuditLog) Record(ctx context.Context, record AuditRecord) error {
+	if len(record.Arguments) > maxAuditedArguments {
+		record.Arguments = record.Arguments[:maxAuditedArguments]
+	}
+	record.ExpiresAt = time.Now().Add(a.Retention).Unix()
+	return common.ConditionalPutItem(ctx, DynamoDbClient, "assistant-tool-audit", record, common.IfNotExists("id"))
+}
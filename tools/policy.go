@@ -0,0 +1,161 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"vassistant-backend/auth"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DefaultPersona is the persona of the assistant in the conversations of the user.
+const DefaultPersona = "assistant"
+
+// ErrUnknownPersona is returned for the policy of a persona that isn't configured.
+var ErrUnknownPersona = errors.New("unknown persona")
+
+// Personas are the tools each persona of the assistant may call, all of them when empty.
+// Write tools still need the user to allow them.
+var Personas = map[string][]string{
+	DefaultPersona: nil,
+}
+
+var DynamoDbClient common.DynamoDBAPI
+
+// PolicySource returns the policy for a user and persona.
+type PolicySource interface {
+	Policy(ctx context.Context, userId, persona string) (Policy, error)
+}
+
+// Permissions struct for the assistant-permissions table, what the user allows the assistant
+// to do. The assistant is read-only until the user allows write actions.
+type Permissions struct {
+	UserID     string `json:"-" dynamodbav:"userId"`
+	AllowWrite bool   `json:"allowWrite" dynamodbav:"allowWrite"`
+	UpdatedAt  string `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+}
+
+// getPermissions returns the permissions of the user, the read-only defaults if none are stored.
+func getPermissions(ctx context.Context, userId string) (Permissions, error) {
+	result, err := DynamoDbClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String("assistant-permissions"),
+		Key: map[string]types.AttributeValue{
+			"userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	if err != nil {
+		return Permissions{}, err
+	}
+
+	permissions := Permissions{UserID: userId}
+	if result.Item == nil {
+		return permissions, nil
+	}
+	err = attributevalue.UnmarshalMap(result.Item, &permissions)
+	if err != nil {
+		return Permissions{}, err
+	}
+	return permissions, nil
+}
+
+// StoredPolicies builds the policies from the tools of the persona and the permissions
+// the user stored.
+type StoredPolicies struct{}
+
+func (StoredPolicies) Policy(ctx context.Context, userId, persona string) (Policy, error) {
+	tools, ok := Personas[persona]
+	if !ok {
+		return Policy{}, ErrUnknownPersona
+	}
+	permissions, err := getPermissions(ctx, userId)
+	if err != nil {
+		return Policy{}, err
+	}
+	return Policy{AllowWrite: permissions.AllowWrite, Tools: tools}, nil
+}
+
+func GetPermissionsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	permissions, err := getPermissions(context.TODO(), claims.Sub)
+	if err != nil {
+		log.Printf("Error getting assistant permissions from DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	// Marshal the permissions into JSON for the payload
+	payload, err := json.Marshal(permissions)
+	if err != nil {
+		log.Println("Error marshalling assistant permissions:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func PutPermissionsHandler(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("request: %+v\n", request)
+
+	// Extract and validate the claims from the authorizer
+	claims, err := auth.ParseClaims(request)
+	if err != nil {
+		return auth.ErrorResponse(err)
+	}
+
+	// Parse the request body into a Permissions struct
+	var permissions Permissions
+	err = json.Unmarshal([]byte(request.Body), &permissions)
+	if err != nil {
+		log.Printf("Error unmarshalling request body: %v", err)
+		return common.CreateErrorResponse(400, "Invalid request body")
+	}
+	permissions.UserID = claims.Sub
+	permissions.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	item, err := attributevalue.MarshalMap(permissions)
+	if err != nil {
+		log.Printf("Error marshalling assistant permissions: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+	_, err = DynamoDbClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("assistant-permissions"),
+		Item:      item,
+	})
+	if err != nil {
+		log.Printf("Error putting assistant permissions into DynamoDB: %v", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	log.Printf("User %s set assistant write actions to %t", claims.Sub, permissions.AllowWrite)
+
+	// Marshal the permissions into JSON for the payload
+	payload, err := json.Marshal(permissions)
+	if err != nil {
+		log.Println("Error marshalling assistant permissions:", err)
+		return common.CreateErrorResponse(500, "Internal server error")
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
@@ -0,0 +1,163 @@
+// Package tools dispatches the tool calls of the assistant. Every call goes through the
+// policy of the user and persona, which decides which tools the assistant may invoke, and
+// is recorded in the audit log whether it was allowed or not.
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"time"
+	"vassistant-backend/llm"
+
+	"github.com/google/uuid"
+)
+
+// Access levels of the tools
+const (
+	// ReadAccess tools only read the data of the user.
+	ReadAccess = "read"
+	// WriteAccess tools change the data of the user, or propose changes to it.
+	WriteAccess = "write"
+)
+
+var (
+	ErrUnknownTool = errors.New("unknown tool")
+	ErrNotAllowed  = errors.New("tool not allowed")
+)
+
+// Tool is an action the assistant can take on behalf of the user.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments of the tool.
+	Parameters json.RawMessage
+	Access     string
+	// Run runs the call, returning the result sent back to the model.
+	Run func(ctx context.Context, call Call) (string, error)
+}
+
+// Call is a call of a tool by the assistant on behalf of a user. In the conversations about
+// a group, GroupID scopes the call: the tools only reach the data of that group.
+type Call struct {
+	ID             string
+	UserID         string
+	Persona        string
+	ConversationID string
+	GroupID        string
+	Name           string
+	Arguments      json.RawMessage
+}
+
+// Dispatcher runs the tool calls of the assistant allowed by the policies, auditing them.
+type Dispatcher struct {
+	tools []Tool
+
+	Policies PolicySource
+	Audit    AuditLog
+}
+
+// NewDispatcher creates a Dispatcher of the tools, with the permissions the users stored
+// and auditing to the DynamoDB audit table.
+func NewDispatcher(tools ...Tool) *Dispatcher {
+	return &Dispatcher{tools: tools, Policies: StoredPolicies{}, Audit: NewDynamoDBAuditLog()}
+}
+
+func (d *Dispatcher) tool(name string) (Tool, bool) {
+	for _, tool := range d.tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
+
+// Specs returns the tools the policy of the user allows, to offer them to the model.
+func (d *Dispatcher) Specs(ctx context.Context, userId, persona string) ([]llm.ToolSpec, error) {
+	policy, err := d.Policies.Policy(ctx, userId, persona)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []llm.ToolSpec
+	for _, tool := range d.tools {
+		if policy.Allows(tool) {
+			specs = append(specs, llm.ToolSpec{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters})
+		}
+	}
+	return specs, nil
+}
+
+// Dispatch runs the call if the policy of the user allows it. Calls of unknown or disallowed
+// tools are refused with ErrUnknownTool or ErrNotAllowed; the model may ask for tools it
+// wasn't offered, so the policy is enforced here rather than trusted to the prompt.
+func (d *Dispatcher) Dispatch(ctx context.Context, call Call) (string, error) {
+	if call.ID == "" {
+		call.ID = uuid.New().String()
+	}
+	start := time.Now()
+	record := AuditRecord{
+		UserID:         call.UserID,
+		ID:             start.UTC().Format(time.RFC3339Nano) + "#" + call.ID,
+		Tool:           call.Name,
+		Persona:        call.Persona,
+		ConversationID: call.ConversationID,
+		GroupID:        call.GroupID,
+		Arguments:      string(call.Arguments),
+	}
+
+	result, err := d.dispatch(ctx, call, &record)
+	record.DurationMs = time.Since(start).Milliseconds()
+	switch {
+	case errors.Is(err, ErrUnknownTool), errors.Is(err, ErrNotAllowed):
+		record.Outcome = OutcomeDenied
+		record.Error = err.Error()
+	case err != nil:
+		record.Outcome = OutcomeFailed
+		record.Error = err.Error()
+	default:
+		record.Outcome = OutcomeSucceeded
+	}
+
+	// The call is logged in any case, so it is audited even when the audit table isn't written
+	log.Printf("Tool call %s of user %s: %s (%s) %s in %dms", call.ID, call.UserID, call.Name, record.Access, record.Outcome, record.DurationMs)
+	if auditErr := d.Audit.Record(ctx, record); auditErr != nil {
+		log.Printf("Error auditing tool call %s: %v", call.ID, auditErr)
+	}
+	return result, err
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, call Call, record *AuditRecord) (string, error) {
+	tool, ok := d.tool(call.Name)
+	if !ok {
+		return "", ErrUnknownTool
+	}
+	record.Access = tool.Access
+
+	policy, err := d.Policies.Policy(ctx, call.UserID, call.Persona)
+	if err != nil {
+		return "", err
+	}
+	if !policy.Allows(tool) {
+		return "", ErrNotAllowed
+	}
+	return tool.Run(ctx, call)
+}
+
+// Policy is what the assistant may do for a user with a persona.
+type Policy struct {
+	// AllowWrite allows the write tools, the assistant is read-only otherwise.
+	AllowWrite bool
+	// Tools are the names of the tools of the persona, all the tools when empty.
+	Tools []string
+}
+
+// Allows reports whether the policy allows the tool.
+func (p Policy) Allows(tool Tool) bool {
+	if tool.Access != ReadAccess && !p.AllowWrite {
+		return false
+	}
+	return len(p.Tools) == 0 || slices.Contains(p.Tools, tool.Name)
+}
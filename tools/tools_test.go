@@ -0,0 +1,118 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testTools(ran *[]string) []Tool {
+	run := func(ctx context.Context, call Call) (string, error) {
+		*ran = append(*ran, call.Name)
+		return "ok", nil
+	}
+	return []Tool{
+		{Name: "list_groups", Access: ReadAccess, Run: run},
+		{Name: "propose_expense", Access: WriteAccess, Run: run},
+	}
+}
+
+func auditRecords(t *testing.T, userId string) []AuditRecord {
+	result, err := DynamoDbClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("assistant-tool-audit"),
+		KeyConditionExpression: aws.String("userId = :userId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	})
+	assert.NoError(t, err)
+	var records []AuditRecord
+	assert.NoError(t, attributevalue.UnmarshalListOfMaps(result.Items, &records))
+	return records
+}
+
+func TestDispatch(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	var ran []string
+	dispatcher := NewDispatcher(testTools(&ran)...)
+
+	// The assistant is read-only by default
+	specs, err := dispatcher.Specs(context.TODO(), "test-user-id", DefaultPersona)
+	assert.NoError(t, err)
+	assert.Len(t, specs, 1)
+	assert.Equal(t, "list_groups", specs[0].Name)
+
+	result, err := dispatcher.Dispatch(context.TODO(), Call{ID: "1", UserID: "test-user-id", Persona: DefaultPersona, Name: "list_groups"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", result)
+	_, err = dispatcher.Dispatch(context.TODO(), Call{ID: "2", UserID: "test-user-id", Persona: DefaultPersona, Name: "propose_expense", Arguments: json.RawMessage(`{"amount":"10"}`)})
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	_, err = dispatcher.Dispatch(context.TODO(), Call{ID: "3", UserID: "test-user-id", Persona: DefaultPersona, Name: "delete_everything"})
+	assert.ErrorIs(t, err, ErrUnknownTool)
+	assert.Equal(t, []string{"list_groups"}, ran)
+
+	// Once the user allows write actions, the write tools are offered and run
+	request := testutil.NewRequest("PUT", "/VassistantBackendProxy/assistant/permissions").
+		WithClaims("test-user-id", "test-user").
+		WithBody(`{"allowWrite": true}`).
+		Build()
+	response, err := PutPermissionsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+
+	specs, err = dispatcher.Specs(context.TODO(), "test-user-id", DefaultPersona)
+	assert.NoError(t, err)
+	assert.Len(t, specs, 2)
+	_, err = dispatcher.Dispatch(context.TODO(), Call{ID: "4", UserID: "test-user-id", Persona: DefaultPersona, Name: "propose_expense"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"list_groups", "propose_expense"}, ran)
+
+	// Unknown personas can't call anything
+	_, err = dispatcher.Dispatch(context.TODO(), Call{ID: "5", UserID: "test-user-id", Persona: "stranger", Name: "list_groups"})
+	assert.ErrorIs(t, err, ErrUnknownPersona)
+
+	// Every call is audited, whatever its outcome
+	records := auditRecords(t, "test-user-id")
+	outcomes := map[string]string{}
+	for _, record := range records {
+		outcomes[record.Tool+"@"+record.ID[len(record.ID)-1:]] = record.Outcome
+	}
+	assert.Equal(t, map[string]string{
+		"list_groups@1":       OutcomeSucceeded,
+		"propose_expense@2":   OutcomeDenied,
+		"delete_everything@3": OutcomeDenied,
+		"propose_expense@4":   OutcomeSucceeded,
+		"list_groups@5":       OutcomeFailed,
+	}, outcomes)
+}
+
+func TestPolicyTools(t *testing.T) {
+	policy := Policy{AllowWrite: true, Tools: []string{"list_groups"}}
+	assert.True(t, policy.Allows(Tool{Name: "list_groups", Access: ReadAccess}))
+	assert.False(t, policy.Allows(Tool{Name: "get_net_debts", Access: ReadAccess}))
+
+	// Tools without an access level are treated as write tools
+	assert.False(t, Policy{}.Allows(Tool{Name: "list_groups"}))
+}
+
+func TestGetPermissionsHandler(t *testing.T) {
+	fake, err := testutil.NewFakeDynamoDB(nil)
+	assert.NoError(t, err)
+	DynamoDbClient = fake
+
+	request := testutil.NewRequest("GET", "/VassistantBackendProxy/assistant/permissions").WithClaims("test-user-id", "test-user").Build()
+	response, err := GetPermissionsHandler(request)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.JSONEq(t, `{"allowWrite": false}`, response.Body)
+}
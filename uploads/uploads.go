@@ -0,0 +1,145 @@
+// Package uploads handles files uploaded through API Gateway as multipart/form-data bodies,
+// for clients that can't upload to S3 with presigned URLs, and stores them in S3.
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"vassistant-backend/common"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// MaxSize is the largest file accepted, in bytes.
+const MaxSize = 5 << 20
+
+// allowedContentTypes maps the accepted content types to the extension of the stored files.
+var allowedContentTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+var (
+	ErrNotMultipart    = errors.New("Expected a multipart/form-data body")
+	ErrFileMissing     = errors.New("File is missing")
+	ErrTooLarge        = errors.New("File is larger than 5MB")
+	ErrUnsupportedType = errors.New("Unsupported file type")
+)
+
+// S3API defines the S3 operations used to store uploads.
+// This allows for mocking the client in tests.
+type S3API interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+var S3Client S3API
+
+// Bucket is the S3 bucket the uploads are stored in.
+var Bucket string
+
+// BaseURL is the public URL the stored keys are served from, e.g. a CloudFront distribution.
+// Without one, the virtual-hosted URL of the bucket is used.
+var BaseURL string
+
+// File is an uploaded file whose content type was checked against the allowed ones.
+type File struct {
+	Name        string
+	ContentType string
+	Extension   string
+	Data        []byte
+}
+
+// ParseMultipart reads the file of the form field from a multipart/form-data request. The
+// content type is sniffed from the data rather than trusted from the client.
+func ParseMultipart(request events.APIGatewayProxyRequest, field string) (*File, error) {
+	mediaType, params, err := mime.ParseMediaType(common.Header(request, "Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, ErrNotMultipart
+	}
+
+	// API Gateway base64-encodes binary bodies
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		body, err = base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return nil, ErrNotMultipart
+		}
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrFileMissing
+		}
+		if err != nil {
+			return nil, ErrNotMultipart
+		}
+		if part.FormName() != field {
+			continue
+		}
+
+		// Read one byte more than allowed to tell files of exactly MaxSize from larger ones
+		data, err := io.ReadAll(io.LimitReader(part, MaxSize+1))
+		if err != nil {
+			return nil, ErrNotMultipart
+		}
+		if len(data) > MaxSize {
+			return nil, ErrTooLarge
+		}
+		if len(data) == 0 {
+			return nil, ErrFileMissing
+		}
+
+		contentType := http.DetectContentType(data)
+		extension, ok := allowedContentTypes[contentType]
+		if !ok {
+			return nil, ErrUnsupportedType
+		}
+		return &File{Name: part.FileName(), ContentType: contentType, Extension: extension, Data: data}, nil
+	}
+}
+
+// ErrorResponse maps the errors of ParseMultipart to their API responses.
+func ErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return common.CreateErrorResponse(413, err.Error())
+	case errors.Is(err, ErrUnsupportedType):
+		return common.CreateErrorResponse(415, err.Error())
+	default:
+		return common.CreateErrorResponse(400, err.Error())
+	}
+}
+
+// Store saves the file in the bucket under the key, which gets the extension of the file,
+// and returns the URL it is served from.
+func Store(ctx context.Context, key string, file *File) (string, error) {
+	key += file.Extension
+	_, err := S3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(Bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(file.Data),
+		ContentType:   aws.String(file.ContentType),
+		ContentLength: aws.Int64(int64(len(file.Data))),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	baseURL := BaseURL
+	if baseURL == "" {
+		baseURL = "https://" + Bucket + ".s3.amazonaws.com"
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + key, nil
+}
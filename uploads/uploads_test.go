@@ -0,0 +1,107 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime/multipart"
+	"testing"
+	"vassistant-backend/testutil"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader is the signature of PNG files, enough for the content type to be sniffed.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// multipartRequest builds a request uploading the data as the form field, base64-encoded as
+// API Gateway does for binary bodies.
+func multipartRequest(t *testing.T, field string, data []byte) events.APIGatewayProxyRequest {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, "receipt.png")
+	assert.NoError(t, err)
+	_, err = part.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+
+	request := testutil.NewRequest("PUT", "/image").
+		WithHeader("Content-Type", writer.FormDataContentType()).
+		WithBody(base64.StdEncoding.EncodeToString(body.Bytes())).
+		Build()
+	request.IsBase64Encoded = true
+	return request
+}
+
+type mockS3Client struct {
+	input *s3.PutObjectInput
+	body  []byte
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	m.input = params
+	m.body, _ = io.ReadAll(params.Body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestParseMultipart(t *testing.T) {
+	file, err := ParseMultipart(multipartRequest(t, "image", pngHeader), "image")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", file.ContentType)
+	assert.Equal(t, ".png", file.Extension)
+	assert.Equal(t, "receipt.png", file.Name)
+	assert.Equal(t, pngHeader, file.Data)
+}
+
+func TestParseMultipartRejectsInvalidUploads(t *testing.T) {
+	// The file must be in the expected field
+	_, err := ParseMultipart(multipartRequest(t, "other", pngHeader), "image")
+	assert.ErrorIs(t, err, ErrFileMissing)
+
+	// The content type is sniffed, whatever the file name says
+	_, err = ParseMultipart(multipartRequest(t, "image", []byte("<html><body>hi</body></html>")), "image")
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+
+	// Files larger than MaxSize are refused
+	large := append(append([]byte{}, pngHeader...), make([]byte, MaxSize)...)
+	_, err = ParseMultipart(multipartRequest(t, "image", large), "image")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// Only multipart bodies are accepted
+	request := testutil.NewRequest("PUT", "/image").WithHeader("Content-Type", "application/json").WithBody("{}").Build()
+	_, err = ParseMultipart(request, "image")
+	assert.ErrorIs(t, err, ErrNotMultipart)
+}
+
+func TestErrorResponse(t *testing.T) {
+	response, _ := ErrorResponse(ErrTooLarge)
+	assert.Equal(t, 413, response.StatusCode)
+	response, _ = ErrorResponse(ErrUnsupportedType)
+	assert.Equal(t, 415, response.StatusCode)
+	response, _ = ErrorResponse(ErrFileMissing)
+	assert.Equal(t, 400, response.StatusCode)
+}
+
+func TestStore(t *testing.T) {
+	client := &mockS3Client{}
+	S3Client = client
+	Bucket = "uploads-bucket"
+	BaseURL = ""
+
+	url, err := Store(context.TODO(), "expenses/expense-1", &File{ContentType: "image/png", Extension: ".png", Data: pngHeader})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://uploads-bucket.s3.amazonaws.com/expenses/expense-1.png", url)
+	assert.Equal(t, "expenses/expense-1.png", aws.ToString(client.input.Key))
+	assert.Equal(t, "image/png", aws.ToString(client.input.ContentType))
+	assert.Equal(t, pngHeader, client.body)
+
+	// A CDN can serve the files instead
+	BaseURL = "https://cdn.example.com/"
+	url, err = Store(context.TODO(), "expenses/expense-1", &File{ContentType: "image/png", Extension: ".png", Data: pngHeader})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/expenses/expense-1.png", url)
+}